@@ -1,21 +1,38 @@
 package app
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"net"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/go-loggregator"
 	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+	clientpoolv2 "code.cloudfoundry.org/loggregator-agent/pkg/clientpool/v2"
 	"code.cloudfoundry.org/loggregator-agent/pkg/healthendpoint"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
 	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	"code.cloudfoundry.org/loggregator-agent/pkg/spiffe"
+	"code.cloudfoundry.org/loggregator-agent/pkg/systemd"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+var logger = logging.New("agent")
+
+// spiffeReadyTimeout is how long to wait for the first SVID from the
+// Workload API before giving up.
+const spiffeReadyTimeout = 30 * time.Second
+
 type Agent struct {
-	config *Config
-	lookup func(string) ([]net.IP, error)
+	config      *Config
+	lookup      func(string) ([]net.IP, error)
+	credentials CredentialsProvider
+
+	mu       sync.Mutex
+	appV2    *AppV2
+	notifier *systemd.Notifier
 }
 
 // AgentOption configures agent options.
@@ -28,6 +45,15 @@ func WithLookup(l func(string) ([]net.IP, error)) func(*Agent) {
 	}
 }
 
+// WithCredentialsProvider sets the provider of the credentials the agent
+// dials and serves gRPC with, in place of the certificate files or SPIFFE
+// Workload API in the config.
+func WithCredentialsProvider(p CredentialsProvider) func(*Agent) {
+	return func(a *Agent) {
+		a.credentials = p
+	}
+}
+
 func NewAgent(
 	c *Config,
 	opts ...AgentOption,
@@ -45,67 +71,211 @@ func NewAgent(
 }
 
 func (a *Agent) Start() {
-	clientCreds, err := plumbing.NewClientCredentials(
-		a.config.GRPC.CertFile,
-		a.config.GRPC.KeyFile,
-		a.config.GRPC.CAFile,
-		"doppler",
-	)
+	creds := a.credentialsProvider()
+	clientCreds, err := creds.DopplerCredentials(a.config.RouterAddr)
 	if err != nil {
-		log.Fatalf("Could not use GRPC creds for client: %s", err)
+		logger.Fatalf("Could not use GRPC creds for client: %s", err)
 	}
 
-	var opts []plumbing.ConfigOption
-	if len(a.config.GRPC.CipherSuites) > 0 {
-		opts = append(opts, plumbing.WithCipherSuites(a.config.GRPC.CipherSuites))
-	}
-
-	serverCreds, err := plumbing.NewServerCredentials(
-		a.config.GRPC.CertFile,
-		a.config.GRPC.KeyFile,
-		a.config.GRPC.CAFile,
-		opts...,
-	)
+	serverCreds, err := creds.ServerCredentials()
 	if err != nil {
-		log.Fatalf("Could not use GRPC creds for server: %s", err)
+		logger.Fatalf("Could not use GRPC creds for server: %s", err)
 	}
 
-	batchInterval := time.Duration(a.config.MetricBatchIntervalMilliseconds) * time.Millisecond
-	ingressTLS, err := loggregator.NewIngressTLSConfig(
-		a.config.GRPC.CAFile,
-		a.config.GRPC.CertFile,
-		a.config.GRPC.KeyFile,
-	)
+	ingressTLS, err := creds.IngressTLSConfig()
 	if err != nil {
-		log.Fatalf("failed to load ingress TLS config: %s", err)
+		logger.Fatalf("failed to load ingress TLS config: %s", err)
 	}
 
+	batchInterval := time.Duration(a.config.MetricBatchIntervalMilliseconds) * time.Millisecond
 	ingressClient, err := loggregator.NewIngressClient(ingressTLS,
 		loggregator.WithTag("origin", "loggregator.metron"),
 		loggregator.WithAddr(fmt.Sprintf("127.0.0.1:%d", a.config.GRPC.Port)),
 	)
 	if err != nil {
-		log.Fatalf("failed to initialize ingress client: %s", err)
+		logger.Fatalf("failed to initialize ingress client: %s", err)
 	}
 
-	metricClient := pulseemitter.New(
+	metricClient := expvarMetricClient{pulseemitter.New(
 		ingressClient,
 		pulseemitter.WithPulseInterval(batchInterval),
 		pulseemitter.WithSourceID(a.config.MetricSourceID),
-	)
+	)}
 
-	healthRegistrar := startHealthEndpoint(fmt.Sprintf("127.0.0.1:%d", a.config.HealthEndpointPort))
+	readiness := healthendpoint.NewReadiness()
+	destinations := healthendpoint.NewDestinations()
+	healthRegistrar := startHealthEndpoint(
+		fmt.Sprintf("127.0.0.1:%d", a.config.HealthEndpointPort),
+		readiness,
+		destinations,
+	)
 
 	appV1 := NewV1App(a.config, healthRegistrar, clientCreds, metricClient)
 	go appV1.Start()
 
-	appV2 := NewV2App(a.config, healthRegistrar, clientCreds, serverCreds, metricClient)
+	v2Opts := []AppV2Option{WithV2CredentialsProvider(creds)}
+	if a.config.AdminPort != 0 {
+		adminServer := admin.NewServer(fmt.Sprintf("127.0.0.1:%d", a.config.AdminPort))
+		adminServer.Handle("/log-level", admin.NewLogLevelHandler())
+		adminServer.Handle("/config", admin.NewJSONHandler(func() interface{} {
+			return a.config
+		}))
+		adminServer.Start()
+		v2Opts = append(v2Opts, WithAdminServer(adminServer))
+	}
+
+	appV2 := NewV2App(a.config, healthRegistrar, clientCreds, serverCreds, metricClient, v2Opts...)
+	a.mu.Lock()
+	a.appV2 = appV2
+	a.mu.Unlock()
+	destinations.Register("doppler_v2", func() interface{} {
+		return appV2.Destinations()
+	})
+	readiness.Register("doppler", func() error {
+		if healthRegistrar.Get("dopplerV1Streams") >= 1 {
+			return nil
+		}
+
+		health := appV2.Destinations()
+		if clientpoolv2.DestinationsUp(health) {
+			return nil
+		}
+
+		if len(health) > 0 {
+			return fmt.Errorf("no streams to doppler are established: %s", health[0].Reason)
+		}
+
+		return errors.New("no streams to doppler are established")
+	})
+	readiness.Register("ingress", func() error {
+		if !appV2.IngressListening() {
+			return errors.New("v2 ingress server is not listening")
+		}
+
+		return nil
+	})
 	go appV2.Start()
+
+	// Under systemd, the agent is ready once ingress is listening and a
+	// stream to a Doppler is up. The watchdog is pinged regardless so that
+	// an unavailable Doppler does not get the agent restarted.
+	if n := systemd.NewNotifierFromEnv(); n != nil {
+		a.mu.Lock()
+		a.notifier = n
+		a.mu.Unlock()
+
+		go n.Supervise(func() bool {
+			return len(readiness.Check()) == 0
+		})
+	}
 }
 
-func startHealthEndpoint(addr string) *healthendpoint.Registrar {
+// Stop drains the v2 pipeline, taking no longer than the configured
+// ShutdownDrainTimeout, and logs how many envelopes were drained and lost.
+func (a *Agent) Stop() DrainSummary {
+	a.mu.Lock()
+	appV2 := a.appV2
+	notifier := a.notifier
+	a.mu.Unlock()
+
+	if notifier != nil {
+		notifier.Stop()
+	}
+
+	if appV2 == nil {
+		return DrainSummary{Complete: true}
+	}
+
+	summary := appV2.Stop(a.config.ShutdownDrainTimeout)
+	l := logger.With(logging.Fields{
+		"drained": summary.Drained,
+		"lost":    summary.Lost,
+	})
+	if !summary.Complete {
+		l.Warnf("stopped after %s without draining, drained %d envelopes and lost %d",
+			a.config.ShutdownDrainTimeout, summary.Drained, summary.Lost)
+		return summary
+	}
+	l.Printf("stopped, drained %d envelopes and lost %d", summary.Drained, summary.Lost)
+
+	return summary
+}
+
+// credentialsProvider returns the provider set with
+// WithCredentialsProvider. Otherwise credentials are loaded from files
+// unless a SPIFFE Workload API socket is configured.
+func (a *Agent) credentialsProvider() CredentialsProvider {
+	if a.credentials != nil {
+		return a.credentials
+	}
+
+	var opts []plumbing.ConfigOption
+	if len(a.config.GRPC.CipherSuites) > 0 {
+		opts = append(opts, plumbing.WithCipherSuites(a.config.GRPC.CipherSuites))
+	}
+
+	if a.config.GRPC.SPIFFEEndpointSocket != "" {
+		if a.config.DopplerCRLFile != "" || a.config.DopplerOCSPStapling != OCSPStaplingOff {
+			logger.Warnf("revocation checking is not supported with SPIFFE credentials and is disabled")
+		}
+
+		source := spiffe.NewSource(a.config.GRPC.SPIFFEEndpointSocket)
+		go source.Start()
+
+		if err := source.WaitUntilReady(spiffeReadyTimeout); err != nil {
+			logger.Fatalf("Could not fetch SVID from workload API: %s", err)
+		}
+		logger.Printf("using SVID for %s", source.SPIFFEID())
+
+		return &spiffeCredentials{source: source, serverOpts: opts}
+	}
+
+	var dopplerOpts []plumbing.ConfigOption
+	if checker := a.revocationChecker(); checker != nil {
+		dopplerOpts = append(dopplerOpts, plumbing.WithRevocationChecker(checker))
+	}
+
+	return &fileCredentials{
+		config:      a.config,
+		serverOpts:  opts,
+		dopplerOpts: dopplerOpts,
+	}
+}
+
+// revocationChecker returns a checker for Doppler certificates, or nil when
+// revocation checking is not configured.
+func (a *Agent) revocationChecker() *plumbing.RevocationChecker {
+	var opts []plumbing.RevocationOption
+	if a.config.DopplerCRLFile != "" {
+		opts = append(opts, plumbing.WithCRLFile(a.config.DopplerCRLFile, a.config.DopplerCRLRefreshInterval))
+	}
+
+	switch a.config.DopplerOCSPStapling {
+	case OCSPStaplingCheck:
+		opts = append(opts, plumbing.WithOCSPStapling(false))
+	case OCSPStaplingRequire:
+		opts = append(opts, plumbing.WithOCSPStapling(true))
+	}
+
+	if len(opts) == 0 {
+		return nil
+	}
+
+	checker, err := plumbing.NewRevocationChecker(opts...)
+	if err != nil {
+		logger.Fatalf("Could not configure revocation checking: %s", err)
+	}
+	go checker.Start()
+
+	return checker
+}
+
+func startHealthEndpoint(
+	addr string,
+	r *healthendpoint.Readiness,
+	d *healthendpoint.Destinations,
+) *healthendpoint.Registrar {
 	promRegistry := prometheus.NewRegistry()
-	healthendpoint.StartServer(addr, promRegistry)
 	healthRegistrar := healthendpoint.New(promRegistry, map[string]prometheus.Gauge{
 		// metric-documentation-health: (dopplerConnections)
 		// Number of connections open to dopplers.
@@ -149,5 +319,20 @@ func startHealthEndpoint(addr string) *healthendpoint.Registrar {
 		),
 	})
 
+	// The agent's CPU, memory, goroutines and garbage collection are
+	// served with its health metrics.
+	promRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	healthendpoint.StartServer(
+		addr,
+		promRegistry,
+		healthendpoint.WithReadiness(r),
+		healthendpoint.WithDopplerStates(healthRegistrar),
+		healthendpoint.WithDestinations(d),
+	)
+
 	return healthRegistrar
 }
@@ -2,7 +2,6 @@ package app
 
 import (
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
 	"time"
@@ -60,13 +59,14 @@ func NewV1App(
 }
 
 func (a *AppV1) Start() {
-	if a.config.DisableUDP {
+	// When UDP is converted to v2 the v2 pipeline owns the UDP listener.
+	if a.config.DisableUDP || a.config.ConvertUDPToV2 {
 		return
 	}
 
 	eventWriter := egress.New("MetronAgent")
 
-	log.Print("Startup: Setting up the agent")
+	logger.Printf("Startup: Setting up the agent")
 	marshaller := a.initializeV1DopplerPool()
 
 	messageTagger := egress.NewTagger(
@@ -87,10 +87,10 @@ func (a *AppV1) Start() {
 		a.metricClient,
 	)
 	if err != nil {
-		log.Panic(fmt.Errorf("Failed to listen on %s: %s", agentAddress, err))
+		logger.Panicf("Failed to listen on %s: %s", agentAddress, err)
 	}
 
-	log.Printf("agent v1 API started on addr %s", agentAddress)
+	logger.Printf("agent v1 API started on addr %s", agentAddress)
 	go networkReader.StartReading()
 	networkReader.StartWriting()
 }
@@ -115,12 +115,18 @@ func (a *AppV1) setupGRPC() *clientpoolv1.ClientPool {
 		balancers = append(balancers, clientpoolv1.NewBalancer(
 			a.config.RouterAddrWithAZ,
 			clientpoolv1.WithLookup(a.lookup),
+			clientpoolv1.WithIPFamily(a.ipFamily()),
+		))
+	}
+	if hostPorts := staticHostPorts(a.config.RouterAddr); hostPorts != nil {
+		balancers = append(balancers, clientpoolv1.NewStaticBalancer(hostPorts))
+	} else {
+		balancers = append(balancers, clientpoolv1.NewBalancer(
+			a.config.RouterAddr,
+			clientpoolv1.WithLookup(a.lookup),
+			clientpoolv1.WithIPFamily(a.ipFamily()),
 		))
 	}
-	balancers = append(balancers, clientpoolv1.NewBalancer(
-		a.config.RouterAddr,
-		clientpoolv1.WithLookup(a.lookup),
-	))
 
 	avgEnvelopeSize := a.metricClient.NewGaugeMetric("average_envelope", "bytes/minute",
 		pulseemitter.WithVersion(2, 0),
@@ -138,12 +144,12 @@ func (a *AppV1) setupGRPC() *clientpoolv1.ClientPool {
 		Timeout:             15 * time.Second,
 		PermitWithoutStream: true,
 	}
-	fetcher := clientpoolv1.NewPusherFetcher(
-		a.healthRegistrar,
+	dialOpts := append([]grpc.DialOption{
 		grpc.WithTransportCredentials(a.creds),
 		grpc.WithStatsHandler(statsHandler),
 		grpc.WithKeepaliveParams(kp),
-	)
+	}, dopplerDialOptions(a.config)...)
+	fetcher := clientpoolv1.NewPusherFetcher(a.healthRegistrar, dialOpts...)
 
 	connector := clientpoolv1.MakeGRPCConnector(fetcher, balancers)
 
@@ -158,3 +164,15 @@ func (a *AppV1) setupGRPC() *clientpoolv1.ClientPool {
 
 	return clientpoolv1.New(connManagers...)
 }
+
+// ipFamily returns the balancer IP family for the configured IP family.
+func (a *AppV1) ipFamily() clientpoolv1.IPFamily {
+	switch a.config.IPFamily {
+	case IPFamilyV4:
+		return clientpoolv1.IPFamilyV4
+	case IPFamilyV6:
+		return clientpoolv1.IPFamilyV6
+	default:
+		return clientpoolv1.IPFamilyDual
+	}
+}
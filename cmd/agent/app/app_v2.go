@@ -1,21 +1,41 @@
 package app
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"math"
 	"math/rand"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	gendiodes "code.cloudfoundry.org/go-diodes"
 	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+	"code.cloudfoundry.org/loggregator-agent/pkg/binding"
 	"code.cloudfoundry.org/loggregator-agent/pkg/clientpool"
 	clientpoolv2 "code.cloudfoundry.org/loggregator-agent/pkg/clientpool/v2"
 	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
 	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
 	"code.cloudfoundry.org/loggregator-agent/pkg/healthendpoint"
 	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"code.cloudfoundry.org/loggregator-agent/pkg/pipeline"
 	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	"code.cloudfoundry.org/loggregator-agent/pkg/ratelimit"
+	"code.cloudfoundry.org/loggregator-agent/pkg/tap"
+	"code.cloudfoundry.org/loggregator-agent/pkg/tracing"
+	"github.com/Shopify/sarama"
+	nats "github.com/nats-io/go-nats"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
@@ -37,6 +57,22 @@ func WithV2Lookup(l func(string) ([]net.IP, error)) func(*AppV2) {
 	}
 }
 
+// WithAdminServer registers the v2 admin handlers with the given server.
+func WithAdminServer(s *admin.Server) func(*AppV2) {
+	return func(a *AppV2) {
+		a.adminServer = s
+	}
+}
+
+// WithV2CredentialsProvider sets the provider asked for the credentials of
+// each Doppler and forward destination. Without one every Doppler is dialed
+// with the client credentials the app is created with.
+func WithV2CredentialsProvider(p CredentialsProvider) func(*AppV2) {
+	return func(a *AppV2) {
+		a.credentials = p
+	}
+}
+
 type AppV2 struct {
 	config          *Config
 	healthRegistrar *healthendpoint.Registrar
@@ -44,6 +80,23 @@ type AppV2 struct {
 	serverCreds     credentials.TransportCredentials
 	metricClient    MetricClient
 	lookup          func(string) ([]net.IP, error)
+	adminServer     *admin.Server
+	credentials     CredentialsProvider
+	rateLimits      *ratelimit.Registry
+	runtimeStats    *ingress.RuntimeStats
+
+	mu             sync.Mutex
+	ingressServers []*ingress.Server
+	connManagers   []*clientpoolv2.ConnManager
+	buffer         envelopeBuffer
+	sources        *ingress.SourceManager
+	transponders   []*egress.Transponder
+	pipeline       *pipeline.Pipeline
+	catchUp        *egress.CatchUpWriter
+	samplers       map[string]*egress.AdaptiveSampler
+	appDrains      *egress.AppDrainWriter
+	tracer         *tracing.Tracer
+	flowControl    *ingress.FlowController
 }
 
 func NewV2App(
@@ -61,6 +114,8 @@ func NewV2App(
 		serverCreds:     serverCreds,
 		metricClient:    metricClient,
 		lookup:          net.LookupIP,
+		rateLimits:      ratelimit.NewRegistry(),
+		runtimeStats:    ingress.NewRuntimeStats(),
 	}
 
 	for _, o := range opts {
@@ -72,7 +127,7 @@ func NewV2App(
 
 func (a *AppV2) Start() {
 	if a.serverCreds == nil {
-		log.Panic("Failed to load TLS server config")
+		logger.Panicf("Failed to load TLS server config")
 	}
 
 	droppedMetric := a.metricClient.NewCounterMetric("dropped",
@@ -80,58 +135,1650 @@ func (a *AppV2) Start() {
 		pulseemitter.WithTags(map[string]string{"direction": "ingress"}),
 	)
 
-	envelopeBuffer := diodes.NewManyToOneEnvelopeV2(10000, gendiodes.AlertFunc(func(missed int) {
+	drops := logger.Summarize(logging.WarnLevel, logging.SummaryInterval)
+	envelopeBuffer := a.newEnvelopeBuffer(gendiodes.AlertFunc(func(missed int) {
 		// metric-documentation-v2: (loggregator.metron.dropped) Number of v2 envelopes
 		// dropped from the agent ingress diode
 		droppedMetric.Increment(uint64(missed))
 
-		log.Printf("Dropped %d v2 envelopes", missed)
+		drops.Add("Dropped v2 envelopes", uint64(missed))
 	}))
 
-	pool := a.initializePool()
-	counterAggr := egress.NewCounterAggregator(pool)
-	tx := egress.NewTransponder(
-		envelopeBuffer,
-		counterAggr,
-		a.config.Tags,
-		100, 100*time.Millisecond,
-		a.metricClient,
+	// metric-documentation-v2: (loggregator.metron.buffer_capacity)
+	// Number of envelopes, or bytes for an mmap buffer, the ingress buffer
+	// can hold
+	capacity := a.metricClient.NewGaugeMetric("buffer_capacity", a.bufferSizeUnit(),
+		pulseemitter.WithVersion(2, 0),
 	)
-	go tx.Start()
+	capacity.Set(float64(envelopeBuffer.Size()))
+
+	if isolating, ok := envelopeBuffer.(*diodes.IsolatingEnvelopeV2); ok {
+		go rebalanceIsolation(isolating)
+	}
+
+	if a.config.IngressFlowControlWindow > 0 {
+		a.mu.Lock()
+		a.flowControl = ingress.NewFlowController(
+			envelopeBuffer,
+			a.config.IngressFlowControlWindow,
+			a.metricClient,
+			ingress.WithFlowControlMaxWait(a.config.IngressFlowControlMaxWait),
+		)
+		a.mu.Unlock()
+	}
+
+	expvarStats.Store(ingress.StatsFunc(a.selfTelemetryStats))
+
+	if a.config.TracingOTLPURL != "" {
+		exporter := tracing.NewOTLPExporter(a.config.TracingOTLPURL)
+		go exporter.Start()
+
+		a.mu.Lock()
+		a.tracer = tracing.NewTracer(a.config.TracingSampleRatio, exporter)
+		a.mu.Unlock()
+		logger.Printf("tracing %g of envelopes to %s", a.config.TracingSampleRatio, a.config.TracingOTLPURL)
+	}
+
+	pipelineConfig := pipeline.DefaultConfig()
+	if a.config.PipelineConfigPath != "" {
+		var err error
+		pipelineConfig, err = pipeline.LoadConfig(a.config.PipelineConfigPath)
+		if err != nil {
+			logger.Panicf("Failed to load pipeline config: %s", err)
+		}
+	}
+
+	// As a forwarder the agent writes to the downstream consumers instead
+	// of to Dopplers, each through its own pool and buffer.
+	if a.config.PipelineConfigPath == "" && len(a.config.ForwardAddrs) > 0 {
+		pipelineConfig.Sinks = nil
+		for i, addr := range a.config.ForwardAddrs {
+			pipelineConfig.Sinks = append(pipelineConfig.Sinks, pipeline.Stage{
+				Name:    fmt.Sprintf("forward_%d", i),
+				Type:    forwardSinkType,
+				Options: map[string]string{"addr": addr},
+			})
+		}
+	}
+
+	if a.config.ConvertUDPToV2 && !a.config.DisableUDP && !hasSourceType(pipelineConfig, udpSourceType) {
+		pipelineConfig.Sources = append(pipelineConfig.Sources, pipeline.Stage{
+			Name: udpSourceType,
+			Type: udpSourceType,
+		})
+	}
+
+	if a.config.UnixSocketPath != "" && !hasSourceType(pipelineConfig, grpcUnixSourceType) {
+		pipelineConfig.Sources = append(pipelineConfig.Sources, pipeline.Stage{
+			Name: grpcUnixSourceType,
+			Type: grpcUnixSourceType,
+		})
+	}
+
+	if a.config.JournaldEnabled && !hasSourceType(pipelineConfig, journaldSourceType) {
+		pipelineConfig.Sources = append(pipelineConfig.Sources, pipeline.Stage{
+			Name: journaldSourceType,
+			Type: journaldSourceType,
+		})
+	}
+
+	if len(a.config.FileTailGlobs) > 0 && !hasSourceType(pipelineConfig, fileTailSourceType) {
+		pipelineConfig.Sources = append(pipelineConfig.Sources, pipeline.Stage{
+			Name: fileTailSourceType,
+			Type: fileTailSourceType,
+		})
+	}
+
+	if a.config.HTTPIngressPort != 0 && !hasSourceType(pipelineConfig, httpSourceType) {
+		pipelineConfig.Sources = append(pipelineConfig.Sources, pipeline.Stage{
+			Name: httpSourceType,
+			Type: httpSourceType,
+		})
+	}
+
+	if a.config.SelfTelemetryInterval > 0 && !hasSourceType(pipelineConfig, selfTelemetrySourceType) {
+		pipelineConfig.Sources = append(pipelineConfig.Sources, pipeline.Stage{
+			Name: selfTelemetrySourceType,
+			Type: selfTelemetrySourceType,
+		})
+	}
+
+	if (a.config.BackfillTimestamps || a.config.MonotonicTimestamps) && !hasProcessorType(pipelineConfig, timestampFixerProcessorType) {
+		pipelineConfig.Processors = append(pipelineConfig.Processors, pipeline.Stage{
+			Name: timestampFixerProcessorType,
+			Type: timestampFixerProcessorType,
+		})
+	}
+
+	if a.config.JoinMultilineLogs && !hasProcessorType(pipelineConfig, multilineJoinerProcessorType) {
+		pipelineConfig.Processors = append(pipelineConfig.Processors, pipeline.Stage{
+			Name: multilineJoinerProcessorType,
+			Type: multilineJoinerProcessorType,
+		})
+	}
+
+	if a.config.SplitLogLines && !hasProcessorType(pipelineConfig, lineSplitterProcessorType) {
+		pipelineConfig.Processors = append(pipelineConfig.Processors, pipeline.Stage{
+			Name: lineSplitterProcessorType,
+			Type: lineSplitterProcessorType,
+		})
+	}
+
+	if a.config.SanitizeUTF8 && !hasProcessorType(pipelineConfig, utf8SanitizerProcessorType) {
+		pipelineConfig.Processors = append(pipelineConfig.Processors, pipeline.Stage{
+			Name: utf8SanitizerProcessorType,
+			Type: utf8SanitizerProcessorType,
+		})
+	}
+
+	if (a.config.MaxEnvelopeSize > 0 || a.config.MaxLogPayloadSize > 0) && !hasProcessorType(pipelineConfig, truncatorProcessorType) {
+		pipelineConfig.Processors = append(pipelineConfig.Processors, pipeline.Stage{
+			Name: truncatorProcessorType,
+			Type: truncatorProcessorType,
+		})
+	}
+
+	for i, u := range a.config.AggregateDrainURLs {
+		pipelineConfig.Sinks = append(pipelineConfig.Sinks, pipeline.Stage{
+			Name: fmt.Sprintf("aggregate_drain_%d", i),
+			Type: syslogSinkType,
+			Options: map[string]string{
+				"url":              u,
+				"drain_type":       a.config.AggregateDrainType,
+				"skip_cert_verify": strconv.FormatBool(a.config.AggregateDrainSkipCertVerify),
+			},
+		})
+	}
+
+	if a.config.BindingsAPIAddr != "" {
+		pipelineConfig.Sinks = append(pipelineConfig.Sinks, pipeline.Stage{
+			Name: appDrainSinkType,
+			Type: appDrainSinkType,
+		})
+	}
+
+	talkers := ingress.NewTalkerCounter(envelopeBuffer)
+	sources := ingress.NewSourceManager(talkers, a.metricClient)
+	p, err := a.pipelineBuilder().Build(pipelineConfig, sources)
+	if err != nil {
+		logger.Panicf("Failed to build pipeline: %s", err)
+	}
+	var w egress.Writer = p
+
+	if a.config.TapAddr != "" {
+		t := tap.New(w)
+		w = t
+		a.startTap(t)
+	}
+
+	debug := egress.NewDebugWriter(w, egress.WithDebugDir(a.config.DebugOutputDir))
+	w = debug
+
+	var catchUp *egress.CatchUpWriter
+	if a.config.CatchUpRateMultiple > 0 {
+		catchUp = egress.NewCatchUpWriter(a.config.CatchUpRateMultiple, envelopeBuffer, w, a.metricClient)
+		w = catchUp
+	}
 
-	agentAddress := fmt.Sprintf("127.0.0.1:%d", a.config.GRPC.Port)
-	log.Printf("agent v2 API started on addr %s", agentAddress)
+	// Each shard of a sharded buffer is drained by its own transponder so
+	// that no single reader limits ingress.
+	nexters := []egress.Nexter{envelopeBuffer}
+	if sharded, ok := envelopeBuffer.(*diodes.ShardedEnvelopeV2); ok {
+		nexters = nexters[:0]
+		for _, s := range sharded.Shards() {
+			nexters = append(nexters, s)
+		}
+	}
+
+	txOpts := []egress.TransponderOption{
+		egress.WithTransponderTracer(a.tracer),
+	}
+	// Batch slices are only reused when no processor or sink keeps them
+	// after a write.
+	if egress.BorrowsBatches(w) {
+		txOpts = append(txOpts, egress.WithTransponderPooledBatches())
+	}
+	if a.config.TagPrecedence == TagPrecedenceAgent {
+		txOpts = append(txOpts, egress.WithTransponderTagOverride())
+	}
+	if a.config.ReportTagConflicts {
+		txOpts = append(txOpts, egress.WithTransponderTagConflicts())
+	}
+	if a.config.ProbeInterval > 0 {
+		prober := egress.NewProber(
+			envelopeBuffer,
+			a.config.MetricSourceID,
+			a.config.ProbeInterval,
+			a.config.ProbeTimeout,
+			a.metricClient,
+		)
+		go prober.Start()
+		txOpts = append(txOpts, egress.WithTransponderProber(prober))
+	}
+
+	var transponders []*egress.Transponder
+	for _, n := range nexters {
+		tx := egress.NewTransponder(
+			n,
+			w,
+			a.config.Tags,
+			100, 100*time.Millisecond,
+			a.metricClient,
+			txOpts...,
+		)
+		go tx.Start()
+		transponders = append(transponders, tx)
+	}
+
+	a.mu.Lock()
+	a.buffer = envelopeBuffer
+	a.sources = sources
+	a.transponders = transponders
+	a.pipeline = p
+	a.catchUp = catchUp
+	a.mu.Unlock()
+
+	if a.config.DropAlertThreshold > 0 {
+		watchdog := ingress.NewDropWatchdog(
+			envelopeBuffer,
+			a.config.MetricSourceID,
+			a.config.DropAlertThreshold,
+			a.config.DropAlertWindow,
+			func() (uint64, uint64) {
+				dropped := envelopeBuffer.Dropped()
+				for _, tx := range transponders {
+					dropped += tx.Dropped()
+				}
+
+				return talkers.Total(), dropped
+			},
+		)
+		go watchdog.Start()
+	}
+
+	sources.Start()
+
+	if a.adminServer != nil {
+		a.adminServer.Handle("/sources/", admin.NewSourcesHandler(sources))
+		a.adminServer.Handle("/rate-limits/", admin.NewRateLimitsHandler(a.rateLimits))
+		a.adminServer.Handle("/top-talkers", admin.NewJSONHandler(func() interface{} {
+			return talkers.Top(10)
+		}))
+		a.adminServer.Handle("/connections", admin.NewJSONHandler(a.connectionStats))
+		a.adminServer.Handle("/app-drains", admin.NewJSONHandler(a.appDrainStats))
+		a.adminServer.Handle("/debug", admin.NewDebugHandler(debug))
+		a.adminServer.Handle("/buffer", admin.NewJSONHandler(func() interface{} {
+			stats := bufferStats{
+				Type:            a.config.BufferType,
+				Size:            envelopeBuffer.Size(),
+				Depth:           envelopeBuffer.Depth(),
+				Dropped:         envelopeBuffer.Dropped(),
+				RecentlyDropped: envelopeBuffer.RecentlyDropped(),
+			}
+			if isolating, ok := envelopeBuffer.(*diodes.IsolatingEnvelopeV2); ok {
+				stats.IsolatedSourceIDs = isolating.Isolated()
+			}
+
+			return stats
+		}))
+	}
+}
+
+// drainPollInterval is how often the ingress buffer is checked while it is
+// drained on shutdown.
+const drainPollInterval = 10 * time.Millisecond
+
+// DrainSummary describes what happened to the envelopes held by the agent
+// when it stopped.
+type DrainSummary struct {
+	// Drained is the number of envelopes written after ingress stopped.
+	Drained uint64
+
+	// Lost is the number of envelopes left in the buffer or in the sink
+	// buffers, or that failed to be written.
+	Lost uint64
+
+	// Complete is false when the drain timed out.
+	Complete bool
+}
 
-	rx := ingress.NewReceiver(envelopeBuffer, a.metricClient, a.healthRegistrar)
+// Stop shuts the v2 pipeline down in order so that as few envelopes as
+// possible are lost: ingress is stopped, the buffer is drained, the
+// transponders write their current batches, the processors and sink
+// buffers write what they hold, the sinks are closed and then the
+// connections to Dopplers are closed. Draining and writing take no longer
+// than timeout.
+func (a *AppV2) Stop(timeout time.Duration) DrainSummary {
+	deadline := time.Now().Add(timeout)
+
+	a.mu.Lock()
+	sources := a.sources
+	buffer := a.buffer
+	transponders := a.transponders
+	p := a.pipeline
+	catchUp := a.catchUp
+	managers := a.connManagers
+	a.mu.Unlock()
+
+	if buffer == nil {
+		return DrainSummary{Complete: true}
+	}
+
+	sources.Stop()
+
+	depth := uint64(buffer.Depth())
+	dropsBefore := egressDrops(transponders)
+	for buffer.Depth() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	// A batch held while Dopplers are unavailable would otherwise keep its
+	// transponder from stopping.
+	if catchUp != nil {
+		catchUp.Stop()
+	}
+
+	flushed := true
+	for _, tx := range transponders {
+		if !tx.Stop(time.Until(deadline)) {
+			flushed = false
+		}
+	}
+
+	pipelineLost := p.Stop(time.Until(deadline))
+
+	for _, m := range managers {
+		m.Close()
+	}
+
+	remaining := buffer.Depth()
+	lost := uint64(remaining) + egressDrops(transponders) - dropsBefore + pipelineLost
+
+	var drained uint64
+	if depth > lost {
+		drained = depth - lost
+	}
+
+	return DrainSummary{
+		Drained:  drained,
+		Lost:     lost,
+		Complete: flushed && remaining == 0,
+	}
+}
+
+func egressDrops(transponders []*egress.Transponder) uint64 {
+	var n uint64
+	for _, tx := range transponders {
+		n += tx.Dropped()
+	}
+
+	return n
+}
+
+// envelopeBuffer holds envelopes written by the v2 sources until they are
+// read by the transponder.
+type envelopeBuffer interface {
+	ingress.DataSetter
+	egress.Nexter
+	Depth() int
+	Size() int
+	Dropped() uint64
+	RecentlyDropped() uint64
+}
+
+func (a *AppV2) newEnvelopeBuffer(alerter gendiodes.Alerter) envelopeBuffer {
+	if a.config.IngressShards > 1 {
+		policy := diodes.ShardBySourceID
+		if a.config.IngressShardBy == ShardRoundRobin {
+			policy = diodes.ShardRoundRobin
+		}
+		logger.Printf("using %d ingress shards", a.config.IngressShards)
+
+		return diodes.NewShardedEnvelopeV2(a.config.IngressShards, a.config.IngressBufferSize, policy, alerter)
+	}
+
+	if a.config.IngressIsolatedSources > 0 {
+		logger.Printf("isolating the %d noisiest sources", a.config.IngressIsolatedSources)
+		return diodes.NewIsolatingEnvelopeV2(
+			a.config.IngressBufferSize,
+			a.config.IngressIsolatedSources,
+			a.config.IngressIsolatedBufferSize,
+			alerter,
+		)
+	}
+
+	if a.config.IngressOverflowPolicy == OverflowBlock {
+		logger.Printf("blocking ingress writers while the buffer is full")
+		return diodes.NewBlockingEnvelopeV2(a.config.IngressBufferSize)
+	}
+
+	if a.config.IngressOverflowPolicy == OverflowPrioritize {
+		logger.Printf("shedding logs before metrics while the buffer is full")
+		return diodes.NewPriorityEnvelopeV2(a.config.IngressBufferSize, alerter)
+	}
+
+	if a.config.BufferType != MMapBufferType {
+		return diodes.NewManyToOneEnvelopeV2(a.config.IngressBufferSize, alerter)
+	}
+
+	b, err := diodes.NewMMapEnvelopeV2(a.config.MMapBufferSize, alerter)
+	if err != nil {
+		logger.Panicf("Failed to create mmap buffer: %s", err)
+	}
+	logger.Printf("using %d byte mmap buffer", a.config.MMapBufferSize)
+
+	return b
+}
+
+// isolationInterval is how often the noisiest sources are chosen for
+// isolation.
+const isolationInterval = 10 * time.Second
+
+// rebalanceIsolation isolates the noisiest sources of the last interval for
+// the life of the agent.
+func rebalanceIsolation(d *diodes.IsolatingEnvelopeV2) {
+	t := time.NewTicker(isolationInterval)
+	defer t.Stop()
+
+	for range t.C {
+		d.Rebalance()
+		logger.Debugf("isolated sources: %v", d.Isolated())
+	}
+}
+
+// bufferSizeUnit returns the unit of the ingress buffer's size.
+func (a *AppV2) bufferSizeUnit() string {
+	if a.config.BufferType == MMapBufferType {
+		return "bytes"
+	}
+
+	return "envelopes"
+}
+
+// bufferStats is the admin representation of the v2 ingress diode. The size
+// is the number of envelopes for a memory buffer and the number of bytes for
+// an mmap buffer.
+type bufferStats struct {
+	Type            string `json:"type"`
+	Size            int    `json:"size"`
+	Depth           int    `json:"depth"`
+	Dropped         uint64 `json:"dropped"`
+	RecentlyDropped uint64 `json:"recently_dropped"`
+
+	IsolatedSourceIDs []string `json:"isolated_source_ids,omitempty"`
+}
+
+// IngressListening reports whether any gRPC ingress server is listening.
+func (a *AppV2) IngressListening() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, s := range a.ingressServers {
+		if s.Listening() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Destinations returns the health of every connection to a doppler.
+func (a *AppV2) Destinations() []clientpoolv2.DestinationHealth {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	health := make([]clientpoolv2.DestinationHealth, 0, len(a.connManagers))
+	for _, m := range a.connManagers {
+		health = append(health, m.Health())
+	}
+
+	return health
+}
+
+func (a *AppV2) connectionStats() interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := make([]clientpoolv2.ConnStats, 0, len(a.connManagers))
+	for _, m := range a.connManagers {
+		stats = append(stats, m.Stats())
+	}
+
+	return stats
+}
+
+// startTap serves the tap's WebSocket handler on the tap address.
+func (a *AppV2) startTap(t *tap.Tap) {
+	lis, err := net.Listen("tcp", a.config.TapAddr)
+	if err != nil {
+		logger.Panicf("Failed to listen on tap address: %s", err)
+	}
+	logger.Printf("tap bound to: %s", lis.Addr())
+
+	go func() {
+		logger.Printf("tap server closing: %s", http.Serve(lis, t.Handler()))
+	}()
+}
+
+func (a *AppV2) appDrainStats() interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.appDrains == nil {
+		return []egress.AppDrainStats{}
+	}
+
+	return a.appDrains.Stats()
+}
+
+// udpSourceType is the pipeline source type that accepts dropsonde v1
+// envelopes over UDP and converts them to v2.
+const udpSourceType = "udp"
+
+// grpcUnixSourceType is the pipeline source type that serves the v2 ingress
+// API on a Unix domain socket for co-located jobs.
+const grpcUnixSourceType = "grpc_unix"
+
+// httpSourceType is the pipeline source type that accepts JSON envelopes
+// over HTTPS.
+const httpSourceType = "http"
+
+// journaldSourceType is the pipeline source type that follows the systemd
+// journal.
+const journaldSourceType = "journald"
+
+// fileTailSourceType is the pipeline source type that tails log files.
+const fileTailSourceType = "file_tail"
+
+// selfTelemetrySourceType is the pipeline source type that writes the
+// agent's own runtime stats into the pipeline.
+const selfTelemetrySourceType = "self_telemetry"
+
+// timestampFixerProcessorType is the pipeline processor type that sets
+// missing timestamps and corrects skewed ones.
+const timestampFixerProcessorType = "timestamp_fixer"
+
+// multilineJoinerProcessorType is the pipeline processor type that joins
+// the lines of stack traces into a single log envelope.
+const multilineJoinerProcessorType = "multiline_joiner"
+
+// lineSplitterProcessorType is the pipeline processor type that splits
+// multi-line log payloads into an envelope per line.
+const lineSplitterProcessorType = "line_splitter"
+
+// utf8SanitizerProcessorType is the pipeline processor type that replaces
+// invalid UTF-8 in log payloads.
+const utf8SanitizerProcessorType = "utf8_sanitizer"
+
+// truncatorProcessorType is the pipeline processor type that truncates
+// oversized log envelopes and payloads.
+const truncatorProcessorType = "truncator"
+
+// syslogSinkType is the pipeline sink type that forwards envelopes to a
+// syslog drain.
+const syslogSinkType = "syslog"
+
+// forwardSinkType is the pipeline sink type that forwards envelopes to a
+// downstream v2 gRPC consumer, such as another agent, with its own client
+// pool.
+const forwardSinkType = "forward"
+
+// appDrainSinkType is the pipeline sink type that forwards application
+// envelopes to the syslog drains bound to them.
+const appDrainSinkType = "app_drain"
+
+// bindingsBatchSize is the number of applications requested in each page
+// of bindings.
+const bindingsBatchSize = 1000
+
+// defaultSelfTelemetryInterval is used by a self telemetry stage without an
+// interval option when no SelfTelemetryInterval is configured.
+const defaultSelfTelemetryInterval = 15 * time.Second
+
+func hasSourceType(c pipeline.Config, typ string) bool {
+	for _, s := range c.Sources {
+		if s.Type == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasProcessorType(c pipeline.Config, typ string) bool {
+	for _, s := range c.Processors {
+		if s.Type == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selfTelemetryStats returns the resources used by the agent and the
+// runtime stats of the v2 pipeline: the state of the envelope buffer, the
+// doppler connection pool, the latency of batch writes, the envelopes
+// dropped by reason and, when enabled, the progress of catching up after an
+// egress outage and the sampling rate of each source being sampled.
+func (a *AppV2) selfTelemetryStats() []ingress.Stat {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.buffer == nil || len(a.transponders) == 0 {
+		return nil
+	}
+
+	stats := a.runtimeStats.Stats()
+
+	// The batch write latency is that of the slowest transponder.
+	var (
+		writeLatency time.Duration
+		egressDrops  uint64
+	)
+	for _, tx := range a.transponders {
+		if l := tx.WriteLatency(); l > writeLatency {
+			writeLatency = l
+		}
+		egressDrops += tx.Dropped()
+	}
+
+	var connected int
+	for _, m := range a.connManagers {
+		if m.Health().State == clientpoolv2.DestinationConnected {
+			connected++
+		}
+	}
+
+	stats = append(stats, []ingress.Stat{
+		{Name: "buffer_depth", Unit: "envelopes", Value: float64(a.buffer.Depth())},
+		{Name: "buffer_size", Unit: a.bufferSizeUnit(), Value: float64(a.buffer.Size())},
+		{Name: "pool_size", Unit: "connections", Value: float64(len(a.connManagers))},
+		{Name: "pool_connected", Unit: "connections", Value: float64(connected)},
+		{
+			Name:  "batch_write_latency",
+			Unit:  "ms",
+			Value: float64(writeLatency) / float64(time.Millisecond),
+		},
+		{
+			Name:    "dropped",
+			Value:   float64(a.buffer.Dropped()),
+			Counter: true,
+			Tags:    map[string]string{"reason": "buffer_full"},
+		},
+		{
+			Name:    "dropped",
+			Value:   float64(egressDrops),
+			Counter: true,
+			Tags:    map[string]string{"reason": "egress_failed"},
+		},
+	}...)
+
+	if b, ok := a.buffer.(*diodes.BlockingEnvelopeV2); ok {
+		stats = append(stats, ingress.Stat{
+			Name:    "blocked_writes",
+			Value:   float64(b.Blocked()),
+			Counter: true,
+		})
+	}
+
+	if b, ok := a.buffer.(*diodes.PriorityEnvelopeV2); ok {
+		stats = append(stats, ingress.Stat{
+			Name:    "shed_logs",
+			Value:   float64(b.Shed()),
+			Counter: true,
+		})
+	}
+
+	if a.catchUp != nil {
+		stats = append(stats, ingress.Stat{
+			Name:  "catch_up_progress",
+			Unit:  "ratio",
+			Value: a.catchUp.Progress(),
+		})
+	}
+
+	for name, sampler := range a.samplers {
+		for sourceID, rate := range sampler.SamplingRates() {
+			stats = append(stats, ingress.Stat{
+				Name:  "sampling_rate",
+				Unit:  "ratio",
+				Value: rate,
+				Tags:  map[string]string{"stage": name, "sampled_source_id": sourceID},
+			})
+		}
+	}
+
+	return stats
+}
+
+// ingressServerOptions returns the gRPC options shared by every ingress
+// server.
+func (a *AppV2) ingressServerOptions() []grpc.ServerOption {
 	kp := keepalive.EnforcementPolicy{
 		MinTime:             10 * time.Second,
 		PermitWithoutStream: true,
 	}
-	ingressServer := ingress.NewServer(
-		agentAddress,
-		rx,
-		grpc.Creds(a.serverCreds),
+
+	opts := []grpc.ServerOption{
 		grpc.KeepaliveEnforcementPolicy(kp),
+		grpc.MaxRecvMsgSize(a.config.IngressMaxRecvMsgSize),
+		ingress.OversizedMessageCounter(a.metricClient),
+	}
+	if a.config.IngressMaxStreams > 0 {
+		l := ingress.NewStreamLimiter(a.config.IngressMaxStreams, a.metricClient)
+		opts = append(opts, l.ServerOptions()...)
+	}
+	if a.config.IngressClientRateLimit > 0 {
+		// The key was validated when the config was loaded.
+		key, _ := ingress.ParseClientKey(a.config.IngressClientRateKey)
+		limit := ratelimit.Limit{
+			Rate:  a.config.IngressClientRateLimit,
+			Burst: a.config.IngressClientRateBurst,
+		}
+		l := ingress.NewClientRateLimiter(limit, key, a.metricClient)
+		opts = append(opts, l.ServerOptions()...)
+	}
+	if a.flowControl != nil {
+		opts = append(opts, a.flowControl.ServerOptions()...)
+	}
+
+	return opts
+}
+
+// ingressReceiverOptions returns the options shared by the receivers of
+// every ingress server.
+func (a *AppV2) ingressReceiverOptions() []ingress.ReceiverOption {
+	opts := []ingress.ReceiverOption{ingress.WithReceiverTracer(a.tracer)}
+	if a.config.IngressPeerMetrics {
+		opts = append(opts, ingress.WithReceiverPeerMetrics(a.metricClient))
+	}
+	if a.config.IngressSizeMetrics {
+		opts = append(opts, ingress.WithReceiverSizeMetrics(a.metricClient))
+	}
+
+	return opts
+}
+
+// configureIngressServer limits connections to an ingress server and
+// enables gRPC reflection when the stage's reflection option, or by default
+// the agent config, asks for it.
+func (a *AppV2) configureIngressServer(s pipeline.Stage, srv *ingress.Server) error {
+	if a.config.IngressMaxConnections > 0 {
+		srv.LimitConnections(a.config.IngressMaxConnections, a.metricClient)
+	}
+
+	enabled, err := strconv.ParseBool(s.Option("reflection", strconv.FormatBool(a.config.IngressReflectionEnabled)))
+	if err != nil {
+		return fmt.Errorf("reflection must be true or false")
+	}
+
+	if enabled {
+		logger.Printf("gRPC reflection enabled on ingress")
+		srv.EnableReflection()
+	}
+
+	return nil
+}
+
+// pipelineBuilder returns a builder for all of the stage types the v2
+// pipeline supports.
+func (a *AppV2) pipelineBuilder() *pipeline.Builder {
+	b := pipeline.NewBuilder(pipeline.WithSinkBuffers(a.config.SinkBufferSize, a.metricClient))
+
+	b.RegisterSource("grpc", func(s pipeline.Stage, w ingress.DataSetter) (ingress.Source, error) {
+		addr := s.Option("addr", fmt.Sprintf("127.0.0.1:%d", a.config.GRPC.Port))
+		logger.Printf("agent v2 API started on addr %s", addr)
+
+		rx := ingress.NewReceiver(w, a.metricClient, a.healthRegistrar, a.ingressReceiverOptions()...)
+
+		opts := append(a.ingressServerOptions(), grpc.Creds(a.serverCreds))
+		if len(a.config.IngressAllowedIdentities) > 0 {
+			action, err := ingress.ParseIdentityAction(a.config.IngressIdentityAction)
+			if err != nil {
+				return nil, err
+			}
+			l := ingress.NewIdentityAllowList(a.config.IngressAllowedIdentities, action, a.metricClient)
+			opts = append(opts, l.ServerOptions()...)
+		}
+
+		srv := ingress.NewServer(addr, rx, opts...)
+		if err := a.configureIngressServer(s, srv); err != nil {
+			return nil, err
+		}
+
+		a.mu.Lock()
+		a.ingressServers = append(a.ingressServers, srv)
+		a.mu.Unlock()
+
+		return srv, nil
+	})
+
+	b.RegisterSource(grpcUnixSourceType, func(s pipeline.Stage, w ingress.DataSetter) (ingress.Source, error) {
+		path := s.Option("path", a.config.UnixSocketPath)
+		if path == "" {
+			return nil, fmt.Errorf("path is required")
+		}
+
+		mode, err := strconv.ParseUint(s.Option("mode", a.config.UnixSocketMode), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("mode must be an octal file mode")
+		}
+		logger.Printf("agent v2 API started on unix socket %s", path)
+
+		rx := ingress.NewReceiver(w, a.metricClient, a.healthRegistrar, a.ingressReceiverOptions()...)
+
+		srv := ingress.NewUnixServer(path, os.FileMode(mode), rx, a.ingressServerOptions()...)
+		if err := a.configureIngressServer(s, srv); err != nil {
+			return nil, err
+		}
+
+		a.mu.Lock()
+		a.ingressServers = append(a.ingressServers, srv)
+		a.mu.Unlock()
+
+		return srv, nil
+	})
+
+	b.RegisterSource(udpSourceType, func(s pipeline.Stage, w ingress.DataSetter) (ingress.Source, error) {
+		addr := s.Option("addr", fmt.Sprintf("127.0.0.1:%d", a.config.IncomingUDPPort))
+		logger.Printf("agent v1 UDP API converted to v2 started on addr %s", addr)
+
+		return ingress.NewUDPSource(addr, w), nil
+	})
+
+	b.RegisterSource(httpSourceType, func(s pipeline.Stage, w ingress.DataSetter) (ingress.Source, error) {
+		addr := s.Option("addr", fmt.Sprintf("127.0.0.1:%d", a.config.HTTPIngressPort))
+
+		tlsConfig, err := a.httpIngressTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("agent v2 HTTP API started on addr %s", addr)
+
+		return ingress.NewHTTPSource(addr, tlsConfig, w), nil
+	})
+
+	b.RegisterSource(journaldSourceType, func(s pipeline.Stage, w ingress.DataSetter) (ingress.Source, error) {
+		cursorPath := s.Option("cursor_path", a.config.JournaldCursorPath)
+		if cursorPath == "" {
+			return nil, fmt.Errorf("cursor_path is required")
+		}
+
+		var opts []ingress.JournalOption
+		if units, ok := s.Options["units"]; ok {
+			opts = append(opts, ingress.WithJournalUnits(strings.Split(units, ",")))
+		}
+		logger.Printf("agent journald source started with cursor %s", cursorPath)
+
+		return ingress.NewJournalSource(cursorPath, w, opts...), nil
+	})
+
+	b.RegisterSource(fileTailSourceType, func(s pipeline.Stage, w ingress.DataSetter) (ingress.Source, error) {
+		globs := a.config.FileTailGlobs
+		if v, ok := s.Options["globs"]; ok {
+			globs = strings.Split(v, ",")
+		}
+		if len(globs) == 0 {
+			return nil, fmt.Errorf("globs is required")
+		}
+
+		checkpointPath := s.Option("checkpoint_path", a.config.FileTailCheckpointPath)
+		if checkpointPath == "" {
+			return nil, fmt.Errorf("checkpoint_path is required")
+		}
+
+		var opts []ingress.FileSourceOption
+		if v, ok := s.Options["poll_interval"]; ok {
+			interval, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, err
+			}
+			if interval <= 0 {
+				return nil, fmt.Errorf("poll_interval must be positive")
+			}
+			opts = append(opts, ingress.WithFilePollInterval(interval))
+		}
+		logger.Printf("agent file tail source started for %s", strings.Join(globs, ", "))
+
+		return ingress.NewFileSource(globs, checkpointPath, w, opts...), nil
+	})
+
+	b.RegisterSource(selfTelemetrySourceType, func(s pipeline.Stage, w ingress.DataSetter) (ingress.Source, error) {
+		interval := a.config.SelfTelemetryInterval
+		if interval <= 0 {
+			interval = defaultSelfTelemetryInterval
+		}
+
+		if v, ok := s.Options["interval"]; ok {
+			var err error
+			interval, err = time.ParseDuration(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+
+		return ingress.NewTelemetrySource(w, a.config.MetricSourceID, interval, a.selfTelemetryStats), nil
+	})
+
+	b.RegisterProcessor("counter_aggregator", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		window, err := time.ParseDuration(s.Option("window", "0s"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid window: %s", err)
+		}
+
+		staleAfter, err := time.ParseDuration(s.Option("stale_after", "0s"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid stale_after: %s", err)
+		}
+
+		maxTracked, err := strconv.Atoi(s.Option("max_tracked", "10000"))
+		if err != nil || maxTracked <= 0 {
+			return nil, fmt.Errorf("max_tracked must be a positive integer")
+		}
+
+		eviction, err := egress.ParseCounterEviction(s.Option("eviction", "all"))
+		if err != nil {
+			return nil, err
+		}
+
+		// metric-documentation-v2: (loggregator.metron.tracked_counters)
+		// Number of distinct counters whose totals are being aggregated
+		trackedGauge := a.metricClient.NewGaugeMetric("tracked_counters", "counters",
+			pulseemitter.WithVersion(2, 0),
+			pulseemitter.WithTags(map[string]string{"stage": s.Name}),
+		)
+
+		return egress.NewCounterAggregator(next,
+			egress.WithCounterWindow(window),
+			egress.WithCounterStaleAfter(staleAfter),
+			egress.WithCounterMaxTracked(maxTracked),
+			egress.WithCounterEviction(eviction),
+			egress.WithCounterTrackedGauge(trackedGauge),
+		), nil
+	})
+
+	b.RegisterProcessor("rate_limiter", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		rate, err := strconv.ParseFloat(s.Option("rate", "0"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate: %s", err)
+		}
+
+		burst, err := strconv.Atoi(s.Option("burst", strconv.Itoa(int(math.Ceil(rate)))))
+		if err != nil {
+			return nil, fmt.Errorf("invalid burst: %s", err)
+		}
+
+		limit := ratelimit.Limit{Rate: rate, Burst: burst}
+		if err := limit.Validate(); err != nil {
+			return nil, err
+		}
+
+		l := ratelimit.NewLimiter(limit)
+		a.rateLimits.Register(s.Name, l)
+
+		return egress.NewRateLimitWriter(l, next, a.metricClient), nil
+	})
+
+	b.RegisterProcessor("quota", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		limit, err := strconv.ParseUint(s.Option("limit", "0"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit: %s", err)
+		}
+		if limit == 0 {
+			return nil, fmt.Errorf("limit must be positive")
+		}
+
+		var period time.Duration
+		switch p := s.Option("period", "daily"); p {
+		case "hourly":
+			period = time.Hour
+		case "daily":
+			period = 24 * time.Hour
+		default:
+			return nil, fmt.Errorf("period must be \"hourly\" or \"daily\", got %q", p)
+		}
+
+		var opts []egress.QuotaOption
+		switch action := s.Option("action", "drop"); action {
+		case "drop":
+		case "notice":
+			opts = append(opts, egress.WithQuotaNotice())
+		default:
+			return nil, fmt.Errorf("action must be \"drop\" or \"notice\", got %q", action)
+		}
+
+		return egress.NewQuotaWriter(limit, period, next, a.metricClient, opts...), nil
+	})
+
+	b.RegisterProcessor("dedup", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		window, err := time.ParseDuration(s.Option("window", "10s"))
+		if err != nil {
+			return nil, err
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("window must be positive")
+		}
+
+		var opts []egress.DeduplicatorOption
+		if v, ok := s.Options["max_entries"]; ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("max_entries must be a positive integer")
+			}
+			opts = append(opts, egress.WithDedupMaxEntries(n))
+		}
+
+		return egress.NewDeduplicator(window, next, a.metricClient, opts...), nil
+	})
+
+	b.RegisterProcessor("gauge_coalescer", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		interval, err := time.ParseDuration(s.Option("interval", "1s"))
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+
+		return egress.NewGaugeCoalescer(interval, next, a.metricClient), nil
+	})
+
+	b.RegisterProcessor("timer_aggregator", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		interval, err := time.ParseDuration(s.Option("interval", "10s"))
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+
+		var opts []egress.TimerAggregatorOption
+		if v, ok := s.Options["max_samples"]; ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("max_samples must be a positive integer")
+			}
+			opts = append(opts, egress.WithTimerMaxSamples(n))
+		}
+		if v, ok := s.Options["percentiles"]; ok {
+			var percentiles []float64
+			for _, f := range strings.Split(v, ",") {
+				p, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+				if err != nil || p <= 0 || p > 100 {
+					return nil, fmt.Errorf("percentiles must be between 0 and 100")
+				}
+				percentiles = append(percentiles, p)
+			}
+			opts = append(opts, egress.WithTimerPercentiles(percentiles...))
+		}
+
+		return egress.NewTimerAggregator(interval, next, a.metricClient, opts...), nil
+	})
+
+	b.RegisterProcessor("adaptive_sampler", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		percentile, err := strconv.ParseFloat(s.Option("percentile", "95"), 64)
+		if err != nil || percentile <= 0 || percentile > 100 {
+			return nil, fmt.Errorf("percentile must be between 0 and 100")
+		}
+
+		shed, err := strconv.ParseFloat(s.Option("shed", "0.5"), 64)
+		if err != nil || shed < 0 || shed > 1 {
+			return nil, fmt.Errorf("shed must be between 0 and 1")
+		}
+
+		var opts []egress.AdaptiveSamplerOption
+		if v, ok := s.Options["window"]; ok {
+			window, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, err
+			}
+			if window <= 0 {
+				return nil, fmt.Errorf("window must be positive")
+			}
+			opts = append(opts, egress.WithSamplingWindow(window))
+		}
+
+		sampler := egress.NewAdaptiveSampler(percentile, shed, next, a.metricClient, opts...)
+
+		a.mu.Lock()
+		if a.samplers == nil {
+			a.samplers = make(map[string]*egress.AdaptiveSampler)
+		}
+		a.samplers[s.Name] = sampler
+		a.mu.Unlock()
+
+		return sampler, nil
+	})
+
+	b.RegisterProcessor("validator", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		return egress.NewValidator(next, a.metricClient), nil
+	})
+
+	b.RegisterProcessor(timestampFixerProcessorType, func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		var opts []egress.TimestampFixerOption
+
+		monotonic, err := strconv.ParseBool(s.Option("monotonic", strconv.FormatBool(a.config.MonotonicTimestamps)))
+		if err != nil {
+			return nil, fmt.Errorf("monotonic must be true or false")
+		}
+		if monotonic {
+			opts = append(opts, egress.WithMonotonicTimestamps())
+		}
+
+		if v, ok := s.Options["max_skew"]; ok {
+			maxSkew, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, err
+			}
+			if maxSkew <= 0 {
+				return nil, fmt.Errorf("max_skew must be positive")
+			}
+			opts = append(opts, egress.WithMaxClockSkew(maxSkew))
+		}
+
+		return egress.NewTimestampFixer(next, a.metricClient, opts...), nil
+	})
+
+	b.RegisterProcessor(multilineJoinerProcessorType, func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		continuation, err := regexp.Compile(s.Option("pattern", egress.DefaultContinuationPattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %s", err)
+		}
+
+		timeout, err := time.ParseDuration(s.Option("timeout", "1s"))
+		if err != nil {
+			return nil, err
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("timeout must be positive")
+		}
+
+		var opts []egress.MultilineJoinerOption
+		if v, ok := s.Options["max_lines"]; ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("max_lines must be a positive integer")
+			}
+			opts = append(opts, egress.WithMultilineMaxLines(n))
+		}
+
+		j := egress.NewMultilineJoiner(continuation, timeout, next, opts...)
+		go j.Start()
+
+		return j, nil
+	})
+
+	b.RegisterProcessor(lineSplitterProcessorType, func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		return egress.NewLineSplitter(next), nil
+	})
+
+	b.RegisterProcessor(utf8SanitizerProcessorType, func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		var opts []egress.UTF8SanitizerOption
+		if r, ok := s.Options["replacement"]; ok {
+			if !utf8.ValidString(r) {
+				return nil, fmt.Errorf("replacement must be valid UTF-8")
+			}
+			opts = append(opts, egress.WithUTF8Replacement(r))
+		}
+
+		return egress.NewUTF8Sanitizer(next, a.metricClient, opts...), nil
+	})
+
+	b.RegisterProcessor(truncatorProcessorType, func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		maxSize, err := strconv.Atoi(s.Option("max_size", strconv.Itoa(a.config.MaxEnvelopeSize)))
+		if err != nil || maxSize < 0 {
+			return nil, fmt.Errorf("max_size must be a non-negative integer")
+		}
+
+		maxPayloadSize, err := strconv.Atoi(s.Option("max_payload_size", strconv.Itoa(a.config.MaxLogPayloadSize)))
+		if err != nil || maxPayloadSize < 0 {
+			return nil, fmt.Errorf("max_payload_size must be a non-negative integer")
+		}
+
+		if maxSize == 0 && maxPayloadSize == 0 {
+			return nil, fmt.Errorf("max_size or max_payload_size is required")
+		}
+
+		suffix := s.Option("suffix", a.config.LogTruncationSuffix)
+		if maxPayloadSize > 0 && len(suffix) >= maxPayloadSize {
+			return nil, fmt.Errorf("suffix must be shorter than max_payload_size")
+		}
+
+		return egress.NewTruncator(maxSize, next, a.metricClient,
+			egress.WithMaxPayloadSize(maxPayloadSize),
+			egress.WithTruncationSuffix(suffix),
+		), nil
+	})
+
+	b.RegisterProcessor("source_id_rewriter", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+		rules, err := egress.ParseSourceIDRules(s.Option("rules", ""))
+		if err != nil {
+			return nil, err
+		}
+
+		var opts []egress.SourceIDRewriterOption
+		if tag := s.Option("empty_from_tag", ""); tag != "" {
+			opts = append(opts, egress.WithEmptySourceIDFromTag(tag))
+		}
+
+		if len(rules) == 0 && len(opts) == 0 {
+			return nil, fmt.Errorf("rules or empty_from_tag is required")
+		}
+
+		return egress.NewSourceIDRewriter(rules, next, opts...), nil
+	})
+
+	b.RegisterSink("subprocess", func(s pipeline.Stage) (egress.Writer, error) {
+		command := s.Option("command", "")
+		if command == "" {
+			return nil, fmt.Errorf("command is required")
+		}
+		logger.Printf("agent v2 subprocess sink started with command %s", command)
+
+		return egress.NewSubprocessWriter(command, strings.Fields(s.Option("args", ""))), nil
+	})
+
+	b.RegisterSink("kafka", func(s pipeline.Stage) (egress.Writer, error) {
+		brokers := s.Option("brokers", "")
+		if brokers == "" {
+			return nil, fmt.Errorf("brokers is required")
+		}
+
+		topic := s.Option("topic", "")
+		if topic == "" {
+			return nil, fmt.Errorf("topic is required")
+		}
+
+		encoding, err := egress.ParseKafkaEncoding(s.Option("encoding", "protobuf"))
+		if err != nil {
+			return nil, err
+		}
+
+		var partitionBySourceID bool
+		switch p := s.Option("partition_by", "source_id"); p {
+		case "source_id":
+			partitionBySourceID = true
+		case "none":
+		default:
+			return nil, fmt.Errorf("unknown partition_by %q", p)
+		}
+
+		config := sarama.NewConfig()
+		config.ClientID = "loggregator-agent"
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Producer.Return.Successes = true
+
+		if certFile, ok := s.Options["cert_file"]; ok {
+			tlsConfig, err := plumbing.NewClientMutualTLSConfig(
+				certFile,
+				s.Option("key_file", ""),
+				s.Option("ca_file", ""),
+				s.Option("server_name", ""),
+			)
+			if err != nil {
+				return nil, err
+			}
+			config.Net.TLS.Enable = true
+			config.Net.TLS.Config = tlsConfig
+		}
+
+		// The producer is created in the background so that brokers that
+		// are down do not stop the agent from starting.
+		connect := func() (egress.KafkaProducer, error) {
+			return sarama.NewSyncProducer(strings.Split(brokers, ","), config)
+		}
+		logger.Printf("agent v2 kafka sink started for topic %s", topic)
+
+		return egress.NewKafkaConnectWriter(connect, topic, a.metricClient,
+			egress.WithKafkaEncoding(encoding),
+			egress.WithKafkaPartitionBySourceID(partitionBySourceID),
+		), nil
+	})
+
+	b.RegisterSink("nats", func(s pipeline.Stage) (egress.Writer, error) {
+		servers := s.Option("servers", "")
+		if servers == "" {
+			return nil, fmt.Errorf("servers is required")
+		}
+
+		opts := []nats.Option{
+			nats.Name("loggregator-agent"),
+			nats.MaxReconnects(-1),
+		}
+		if user, ok := s.Options["user"]; ok {
+			opts = append(opts, nats.UserInfo(user, s.Option("password", "")))
+		}
+		if certFile, ok := s.Options["cert_file"]; ok {
+			tlsConfig, err := plumbing.NewClientMutualTLSConfig(
+				certFile,
+				s.Option("key_file", ""),
+				s.Option("ca_file", ""),
+				s.Option("server_name", ""),
+			)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, nats.Secure(tlsConfig))
+		}
+
+		connect := func() (egress.NATSPublisher, error) {
+			return nats.Connect(servers, opts...)
+		}
+
+		prefix := s.Option("subject_prefix", "loggregator.v2")
+		logger.Printf("agent v2 nats sink started with subject prefix %s", prefix)
+
+		return egress.NewNATSConnectWriter(connect, prefix, a.metricClient), nil
+	})
+
+	b.RegisterSink(syslogSinkType, func(s pipeline.Stage) (egress.Writer, error) {
+		u, err := url.Parse(s.Option("url", ""))
+		if err != nil {
+			return nil, err
+		}
+
+		var logsOnly bool
+		switch t := s.Option("drain_type", AllDrainType); t {
+		case AllDrainType:
+		case LogsDrainType:
+			logsOnly = true
+		default:
+			return nil, fmt.Errorf("unknown drain_type %q", t)
+		}
+
+		skipCertVerify, err := strconv.ParseBool(s.Option("skip_cert_verify", "false"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid skip_cert_verify: %s", err)
+		}
+
+		format, err := egress.ParseSyslogFormat(s.Option("format", "rfc5424"))
+		if err != nil {
+			return nil, err
+		}
+
+		opts := []egress.SyslogOption{
+			egress.WithSyslogLogsOnly(logsOnly),
+			egress.WithSyslogFormat(format),
+		}
+		if u.Scheme == "syslog-tls" {
+			opts = append(opts, egress.WithSyslogTLSConfig(&tls.Config{
+				ServerName:         u.Hostname(),
+				InsecureSkipVerify: skipCertVerify,
+			}))
+		}
+
+		w, err := egress.NewSyslogWriter(u, a.metricClient, opts...)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("agent v2 syslog sink started for %s", u.Host)
+
+		return w, nil
+	})
+
+	b.RegisterSink("https", func(s pipeline.Stage) (egress.Writer, error) {
+		u, err := url.Parse(s.Option("url", ""))
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("url must be an https URL")
+		}
+
+		encoding, err := egress.ParseHTTPEncoding(s.Option("encoding", "json"))
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := httpSinkClient(s)
+		if err != nil {
+			return nil, err
+		}
+
+		opts := []egress.HTTPOption{egress.WithHTTPEncoding(encoding)}
+		if auth, ok := s.Options["authorization"]; ok {
+			opts = append(opts, egress.WithHTTPHeader("Authorization", auth))
+		}
+		logger.Printf("agent v2 https sink started for %s", u.Host)
+
+		return egress.NewHTTPWriter(s.Name, u.String(), client, a.metricClient, opts...), nil
+	})
+
+	b.RegisterSink("remote_write", func(s pipeline.Stage) (egress.Writer, error) {
+		u, err := url.Parse(s.Option("url", ""))
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("url must be an http or https URL")
+		}
+
+		client, err := httpSinkClient(s)
+		if err != nil {
+			return nil, err
+		}
+
+		var opts []egress.HTTPOption
+		if auth, ok := s.Options["authorization"]; ok {
+			opts = append(opts, egress.WithHTTPHeader("Authorization", auth))
+		}
+		if tenant, ok := s.Options["tenant"]; ok {
+			opts = append(opts, egress.WithHTTPHeader("X-Scope-OrgID", tenant))
+		}
+		logger.Printf("agent v2 remote_write sink started for %s", u.Host)
+
+		return egress.NewRemoteWriteWriter(s.Name, u.String(), client, a.metricClient, opts...), nil
+	})
+
+	b.RegisterSink(appDrainSinkType, func(s pipeline.Stage) (egress.Writer, error) {
+		addr := s.Option("addr", a.config.BindingsAPIAddr)
+		if addr == "" {
+			return nil, fmt.Errorf("addr is required")
+		}
+
+		interval := a.config.BindingsPollingInterval
+		if v, ok := s.Options["polling_interval"]; ok {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid polling_interval %q", v)
+			}
+			interval = d
+		}
+
+		tlsConfig, err := plumbing.NewClientMutualTLSConfig(
+			a.config.BindingsAPICertFile,
+			a.config.BindingsAPIKeyFile,
+			a.config.BindingsAPICAFile,
+			a.config.BindingsAPICommonName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}
+
+		w := egress.NewAppDrainWriter(
+			binding.NewFetcher(addr, bindingsBatchSize, client),
+			a.metricClient,
+			egress.WithAppDrainPollInterval(interval),
+			egress.WithAppDrainSkipCertVerify(a.config.AppDrainSkipCertVerify),
+		)
+		go w.Start()
+		logger.Printf("agent v2 app drain sink started with bindings from %s", addr)
+
+		a.mu.Lock()
+		a.appDrains = w
+		a.mu.Unlock()
+
+		return w, nil
+	})
+
+	b.RegisterSink("doppler", func(s pipeline.Stage) (egress.Writer, error) {
+		// A stage with its own address does not use the AZ specific
+		// address.
+		addr, azAddr := a.config.RouterAddr, a.config.RouterAddrWithAZ
+		if stageAddr, ok := s.Options["addr"]; ok {
+			addr, azAddr = stageAddr, ""
+		}
+
+		creds := a.clientCreds
+		if a.credentials != nil {
+			var err error
+			creds, err = a.credentials.DopplerCredentials(addr)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return a.initializePool(addr, azAddr, creds, dopplerDialOptions(a.config)...), nil
+	})
+
+	b.RegisterSink(forwardSinkType, func(s pipeline.Stage) (egress.Writer, error) {
+		addr := s.Option("addr", "")
+		if addr == "" {
+			return nil, fmt.Errorf("addr is required")
+		}
+
+		creds, err := a.forwardCredentials(addr, s)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("forwarding v2 envelopes to %s", addr)
+
+		return a.initializePool(addr, "", creds), nil
+	})
+
+	return b
+}
+
+// httpIngressTLSConfig returns the TLS config of the HTTP source. Like the
+// gRPC ingress servers' credentials, it comes from the credentials provider
+// when there is one, so that agents using SPIFFE need no certificate files.
+func (a *AppV2) httpIngressTLSConfig() (*tls.Config, error) {
+	if a.credentials != nil {
+		return a.credentials.HTTPIngressTLSConfig()
+	}
+
+	return plumbing.NewServerTLSConfig(a.config.GRPC.CertFile, a.config.GRPC.KeyFile)
+}
+
+// forwardCredentials returns the credentials for dialing the consumer of a
+// forward sink. The agent's own certificate, or the credentials provider's,
+// is used unless the stage sets cert_file, key_file and ca_file, and the
+// consumer is expected to be named server_name.
+func (a *AppV2) forwardCredentials(addr string, s pipeline.Stage) (credentials.TransportCredentials, error) {
+	_, hasCert := s.Options["cert_file"]
+	if a.credentials != nil && !hasCert {
+		return a.credentials.ForwardCredentials(addr, s.Option("server_name", a.config.ForwardServerName))
+	}
+
+	if a.config.GRPC.SPIFFEEndpointSocket != "" && !hasCert {
+		// SVIDs are verified by trust domain rather than by name.
+		return a.clientCreds, nil
+	}
+
+	return plumbing.NewClientCredentials(
+		s.Option("cert_file", a.config.GRPC.CertFile),
+		s.Option("key_file", a.config.GRPC.KeyFile),
+		s.Option("ca_file", a.config.GRPC.CAFile),
+		s.Option("server_name", a.config.ForwardServerName),
 	)
-	ingressServer.Start()
 }
 
-func (a *AppV2) initializePool() *clientpoolv2.ClientPool {
-	if a.clientCreds == nil {
-		log.Panic("Failed to load TLS client config")
+// httpSinkClient returns the client an HTTP sink posts with, configured by
+// the stage's timeout and ca_file options.
+func httpSinkClient(s pipeline.Stage) (*http.Client, error) {
+	timeout, err := time.ParseDuration(s.Option("timeout", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout: %s", err)
+	}
+
+	tlsConfig := plumbing.NewTLSConfig()
+	if caFile, ok := s.Options["ca_file"]; ok {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to load ca_file %s", caFile)
+		}
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// dopplerDialOptions returns the options for dialing Dopplers in addition to
+// credentials and keepalives.
+func dopplerDialOptions(c *Config) []grpc.DialOption {
+	var opts []grpc.DialOption
+	if c.DopplerServiceConfig != "" {
+		// gRPC only applies retry policies when GRPC_GO_RETRY is on.
+		if strings.Contains(c.DopplerServiceConfig, "retryPolicy") && os.Getenv("GRPC_GO_RETRY") != "on" {
+			logger.Warnf("the Doppler service config has a retry policy but GRPC_GO_RETRY is not on, retries are disabled")
+		}
+		opts = append(opts, grpc.WithDefaultServiceConfig(c.DopplerServiceConfig))
+	}
+
+	if c.DopplerProxyURL != "" {
+		dial, err := plumbing.NewProxyDialer(c.DopplerProxyURL)
+		if err != nil {
+			logger.Panicf("Failed to configure Doppler proxy: %s", err)
+		}
+		opts = append(opts, grpc.WithContextDialer(dial))
+	}
+
+	return opts
+}
+
+func (a *AppV2) initializePool(
+	routerAddr, routerAddrWithAZ string,
+	creds credentials.TransportCredentials,
+	dialOpts ...grpc.DialOption,
+) *clientpoolv2.ClientPool {
+	if creds == nil {
+		logger.Panicf("Failed to load TLS client config")
 	}
 
+	family := a.ipFamily()
 	balancers := make([]*clientpoolv2.Balancer, 0, 2)
-	if a.config.RouterAddrWithAZ != "" {
+	if routerAddrWithAZ != "" {
 		balancers = append(balancers, clientpoolv2.NewBalancer(
-			a.config.RouterAddrWithAZ,
-			clientpoolv2.WithLookup(a.lookup)),
+			routerAddrWithAZ,
+			clientpoolv2.WithLookup(a.lookup),
+			clientpoolv2.WithIPFamily(family),
+			clientpoolv2.WithBOSHDNSQuery(a.boshDNSQuery(true))),
+		)
+	}
+	var discovery *clientpoolv2.Balancer
+	if a.config.DopplerConsulAddr != "" && routerAddr == a.config.RouterAddr {
+		discovery = clientpoolv2.NewDiscoveryBalancer(clientpoolv2.NewConsulDiscoverer(
+			a.config.DopplerConsulAddr,
+			a.config.DopplerConsulService,
+			clientpoolv2.WithConsulTag(a.config.DopplerConsulTag),
+			clientpoolv2.WithConsulDatacenter(a.config.DopplerConsulDatacenter),
+			clientpoolv2.WithConsulToken(a.config.DopplerConsulToken),
+		))
+		balancers = append(balancers, discovery)
+	} else if hostPorts := staticHostPorts(routerAddr); hostPorts != nil {
+		balancers = append(balancers, clientpoolv2.NewStaticBalancer(hostPorts))
+	} else {
+		balancers = append(balancers, clientpoolv2.NewBalancer(
+			routerAddr,
+			clientpoolv2.WithLookup(a.lookup),
+			clientpoolv2.WithIPFamily(family),
+			clientpoolv2.WithBOSHDNSQuery(a.boshDNSQuery(routerAddrWithAZ == ""))),
 		)
 	}
-	balancers = append(balancers, clientpoolv2.NewBalancer(
-		a.config.RouterAddr,
-		clientpoolv2.WithLookup(a.lookup)),
-	)
 
 	avgEnvelopeSize := a.metricClient.NewGaugeMetric("average_envelope", "bytes/minute",
 		pulseemitter.WithVersion(2, 0),
@@ -142,30 +1789,175 @@ func (a *AppV2) initializePool() *clientpoolv2.ClientPool {
 	tracker.Start(60*time.Second, func(average float64) {
 		avgEnvelopeSize.Set(average)
 	})
-	statsHandler := clientpool.NewStatsHandler(tracker)
+
+	// metric-documentation-v2: (loggregator.metron.egress_throughput) Bytes
+	// per second written to each destination, averaged over a minute
+	egressThroughput := a.metricClient.NewGaugeMetric("egress_throughput", "bytes/second",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{
+			"loggregator": "v2",
+			"destination": routerAddr,
+		}))
+	throughput := plumbing.NewThroughputTracker()
+	throughput.Start(60*time.Second, func(bytesPerSecond float64) {
+		egressThroughput.Set(bytesPerSecond)
+	})
+	statsHandler := clientpool.NewStatsHandler(tracker, throughput)
 
 	kp := keepalive.ClientParameters{
 		Time:                15 * time.Second,
 		Timeout:             15 * time.Second,
 		PermitWithoutStream: true,
 	}
-	fetcher := clientpoolv2.NewSenderFetcher(
-		a.healthRegistrar,
-		grpc.WithTransportCredentials(a.clientCreds),
+	dialOpts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
 		grpc.WithStatsHandler(statsHandler),
 		grpc.WithKeepaliveParams(kp),
+	}, dialOpts...)
+	fetcher := clientpoolv2.NewSenderFetcher(a.healthRegistrar, dialOpts...)
+
+	connector := clientpoolv2.MakeGRPCConnector(fetcher, balancers,
+		clientpoolv2.WithFailoverPolicy(a.failoverPolicy()),
+		clientpoolv2.WithFailoverTimeout(a.config.AZFailoverTimeout),
 	)
 
-	connector := clientpoolv2.MakeGRPCConnector(fetcher, balancers)
+	var managerOpts []clientpoolv2.ConnManagerOption
+	if a.config.SkipDeprecatedTags {
+		managerOpts = append(managerOpts, clientpoolv2.WithoutDeprecatedTags())
+	}
+	if a.config.DopplerWriteTimeout > 0 {
+		managerOpts = append(managerOpts, clientpoolv2.WithWriteTimeout(a.config.DopplerWriteTimeout))
+	}
 
+	var managers []*clientpoolv2.ConnManager
 	var connManagers []clientpoolv2.Conn
 	for i := 0; i < 5; i++ {
-		connManagers = append(connManagers, clientpoolv2.NewConnManager(
+		m := clientpoolv2.NewConnManager(
 			connector,
 			100000+rand.Int63n(1000),
 			time.Second,
-		))
+			managerOpts...,
+		)
+		a.mu.Lock()
+		a.connManagers = append(a.connManagers, m)
+		a.mu.Unlock()
+		managers = append(managers, m)
+		connManagers = append(connManagers, m)
+	}
+
+	for _, b := range balancers {
+		interval := a.config.DNSResolveInterval
+		if b == discovery {
+			interval = a.config.DopplerConsulRefreshInterval
+		}
+
+		go b.Watch(interval, nil, func(ips []net.IP) {
+			logger.With(logging.Fields{"ips": ips}).Printf("doppler addresses changed, rebalancing %d connections", len(managers))
+			// Managers are migrated one at a time so the others keep
+			// writing while each makes its new connection.
+			for _, m := range managers {
+				if err := m.Migrate(); err != nil {
+					logger.Warnf("failed to migrate doppler connection, keeping the current one: %s", err)
+				}
+			}
+		})
+	}
+
+	if routerAddrWithAZ != "" {
+		// metric-documentation-v2: (loggregator.metron.cross_az_ratio) Fraction
+		// of open connections to Dopplers that are outside the Agent's AZ
+		crossAZ := a.metricClient.NewGaugeMetric("cross_az_ratio", "ratio",
+			pulseemitter.WithVersion(2, 0),
+		)
+		go reportCrossAZ(connector, managers, crossAZ)
 	}
 
 	return clientpoolv2.New(connManagers...)
 }
+
+// failoverPolicy returns the connector policy for the configured AZ
+// failover policy.
+func (a *AppV2) failoverPolicy() clientpoolv2.FailoverPolicy {
+	switch a.config.AZFailoverPolicy {
+	case FailoverStrictAZ:
+		return clientpoolv2.FailoverStrict
+	case FailoverRoundRobin:
+		return clientpoolv2.FailoverRoundRobin
+	default:
+		return clientpoolv2.FailoverPrefer
+	}
+}
+
+// ipFamily returns the balancer IP family for the configured IP family.
+func (a *AppV2) ipFamily() clientpoolv2.IPFamily {
+	switch a.config.IPFamily {
+	case IPFamilyV4:
+		return clientpoolv2.IPFamilyV4
+	case IPFamilyV6:
+		return clientpoolv2.IPFamilyV6
+	default:
+		return clientpoolv2.IPFamilyDual
+	}
+}
+
+// boshDNSQuery returns the BOSH DNS filters for resolving Doppler
+// addresses. The AZ filter is only applied to the AZ specific address, or
+// to the plain address when there is none, so that the plain address stays
+// a fallback to other AZs.
+func (a *AppV2) boshDNSQuery(az bool) clientpoolv2.BOSHDNSQuery {
+	var q clientpoolv2.BOSHDNSQuery
+	switch a.config.BOSHDNSHealth {
+	case BOSHDNSHealthSmart:
+		q.Health = clientpoolv2.BOSHDNSHealthSmart
+	case BOSHDNSHealthHealthy:
+		q.Health = clientpoolv2.BOSHDNSHealthHealthy
+	case BOSHDNSHealthAll:
+		q.Health = clientpoolv2.BOSHDNSHealthAll
+	}
+
+	if az {
+		for _, i := range a.config.BOSHDNSAZIndexes {
+			// The indexes are validated when the config is loaded.
+			n, _ := strconv.Atoi(i)
+			q.AZIndexes = append(q.AZIndexes, n)
+		}
+	}
+
+	return q
+}
+
+// crossAZInterval is how often the fraction of cross-AZ connections is
+// reported.
+const crossAZInterval = 10 * time.Second
+
+// reportCrossAZ sets the gauge to the fraction of the managers' open
+// connections that were made outside the Agent's AZ for the life of the
+// agent.
+func reportCrossAZ(
+	connector clientpoolv2.GRPCConnector,
+	managers []*clientpoolv2.ConnManager,
+	gauge pulseemitter.GaugeMetric,
+) {
+	t := time.NewTicker(crossAZInterval)
+	defer t.Stop()
+
+	for range t.C {
+		var open, crossAZ int
+		for _, m := range managers {
+			s := m.Stats()
+			if !s.Connected {
+				continue
+			}
+			open++
+			if connector.Fallback(s.Addr) {
+				crossAZ++
+			}
+		}
+
+		if open == 0 {
+			gauge.Set(0)
+			continue
+		}
+		gauge.Set(float64(crossAZ) / float64(open))
+	}
+}
@@ -1,7 +1,13 @@
 package app_test
 
 import (
+	"crypto/tls"
+	"expvar"
+	"io/ioutil"
 	"net"
+	"os"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/loggregator-agent/cmd/agent/app"
 	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
@@ -11,16 +17,26 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/credentials"
 )
 
 var _ = Describe("v2 App", func() {
-	It("uses DopplerAddrWithAZ for AZ affinity", func() {
-		spyLookup := newSpyLookup()
+	var (
+		spyLookup   *spyLookup
+		he          *healthendpoint.Registrar
+		clientCreds credentials.TransportCredentials
+		serverCreds credentials.TransportCredentials
+	)
+
+	BeforeEach(func() {
+		spyLookup = newSpyLookup()
 		gaugeMap := stubGaugeMap()
 
 		promRegistry := prometheus.NewRegistry()
-		he := healthendpoint.New(promRegistry, gaugeMap)
-		clientCreds, err := plumbing.NewClientCredentials(
+		he = healthendpoint.New(promRegistry, gaugeMap)
+
+		var err error
+		clientCreds, err = plumbing.NewClientCredentials(
 			testhelper.Cert("metron.crt"),
 			testhelper.Cert("metron.key"),
 			testhelper.Cert("loggregator-ca.crt"),
@@ -28,13 +44,15 @@ var _ = Describe("v2 App", func() {
 		)
 		Expect(err).ToNot(HaveOccurred())
 
-		serverCreds, err := plumbing.NewServerCredentials(
+		serverCreds, err = plumbing.NewServerCredentials(
 			testhelper.Cert("router.crt"),
 			testhelper.Cert("router.key"),
 			testhelper.Cert("loggregator-ca.crt"),
 		)
 		Expect(err).ToNot(HaveOccurred())
+	})
 
+	It("uses DopplerAddrWithAZ for AZ affinity", func() {
 		config := buildAgentConfig("127.0.0.1", 1234)
 		config.Zone = "something-bad"
 		expectedHost, _, err := net.SplitHostPort(config.RouterAddrWithAZ)
@@ -52,4 +70,210 @@ var _ = Describe("v2 App", func() {
 
 		Eventually(spyLookup.calledWith(expectedHost)).Should(BeTrue())
 	})
+
+	It("publishes pipeline stats with expvar", func() {
+		config := buildAgentConfig("127.0.0.1", 1234)
+
+		app := app.NewV2App(
+			&config,
+			he,
+			clientCreds,
+			serverCreds,
+			testhelper.NewMetricClient(),
+			app.WithV2Lookup(spyLookup.lookup),
+		)
+		go app.Start()
+
+		Eventually(func() string {
+			return expvar.Get("pipeline").String()
+		}).Should(ContainSubstring(`"pool_connected"`))
+		Expect(expvar.Get("pipeline").String()).To(ContainSubstring(`"dropped.buffer_full"`))
+		Expect(expvar.Get("counters")).ToNot(BeNil())
+	})
+
+	It("builds the pipeline from the pipeline config", func() {
+		f, err := ioutil.TempFile("", "pipeline")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+
+		_, err = f.Write([]byte(`
+sources:
+- type: grpc
+  options:
+    addr: 127.0.0.1:0
+sinks:
+- type: doppler
+  options:
+    addr: other-doppler:1234
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		config := buildAgentConfig("127.0.0.1", 1234)
+		config.PipelineConfigPath = f.Name()
+
+		app := app.NewV2App(
+			&config,
+			he,
+			clientCreds,
+			serverCreds,
+			testhelper.NewMetricClient(),
+			app.WithV2Lookup(spyLookup.lookup),
+		)
+		go app.Start()
+
+		Eventually(spyLookup.calledWith("other-doppler")).Should(BeTrue())
+	})
+
+	It("forwards to the downstream consumers instead of Doppler", func() {
+		config := buildAgentConfig("127.0.0.1", 1234)
+		config.ForwardAddrs = []string{"downstream-a:3458", "downstream-b:3458"}
+		config.ForwardServerName = "metron"
+		azHost, _, err := net.SplitHostPort(config.RouterAddrWithAZ)
+		Expect(err).ToNot(HaveOccurred())
+
+		app := app.NewV2App(
+			&config,
+			he,
+			clientCreds,
+			serverCreds,
+			testhelper.NewMetricClient(),
+			app.WithV2Lookup(spyLookup.lookup),
+		)
+		go app.Start()
+
+		Eventually(spyLookup.calledWith("downstream-a")).Should(BeTrue())
+		Eventually(spyLookup.calledWith("downstream-b")).Should(BeTrue())
+		Consistently(spyLookup.calledWith(azHost)).Should(BeFalse())
+	})
+
+	It("asks the credentials provider for each destination's credentials", func() {
+		config := buildAgentConfig("127.0.0.1", 1234)
+		config.ForwardAddrs = []string{"downstream-a:3458"}
+		config.ForwardServerName = "consumer"
+		provider := newSpyCredentialsProvider(clientCreds, serverCreds)
+
+		app := app.NewV2App(
+			&config,
+			he,
+			clientCreds,
+			serverCreds,
+			testhelper.NewMetricClient(),
+			app.WithV2Lookup(spyLookup.lookup),
+			app.WithV2CredentialsProvider(provider),
+		)
+		go app.Start()
+
+		Eventually(provider.forwardedTo).Should(ConsistOf("downstream-a:3458 consumer"))
+	})
+
+	It("asks the credentials provider for the HTTP source's TLS config", func() {
+		config := buildAgentConfig("127.0.0.1", 1234)
+		config.GRPC.CertFile = ""
+		config.GRPC.KeyFile = ""
+		config.HTTPIngressPort = freePort()
+		provider := newSpyCredentialsProvider(clientCreds, serverCreds)
+
+		app := app.NewV2App(
+			&config,
+			he,
+			clientCreds,
+			serverCreds,
+			testhelper.NewMetricClient(),
+			app.WithV2Lookup(spyLookup.lookup),
+			app.WithV2CredentialsProvider(provider),
+		)
+		go app.Start()
+
+		Eventually(provider.httpIngressRequested).Should(BeTrue())
+	})
+
+	It("stops ingress and drains when stopped", func() {
+		config := buildAgentConfig("127.0.0.1", 1234)
+
+		app := app.NewV2App(
+			&config,
+			he,
+			clientCreds,
+			serverCreds,
+			testhelper.NewMetricClient(),
+			app.WithV2Lookup(spyLookup.lookup),
+		)
+		go app.Start()
+		Eventually(app.IngressListening).Should(BeTrue())
+
+		summary := app.Stop(time.Second)
+
+		Expect(summary.Complete).To(BeTrue())
+		Expect(summary.Lost).To(BeZero())
+		Expect(app.IngressListening()).To(BeFalse())
+	})
 })
+
+type spyCredentialsProvider struct {
+	clientCreds credentials.TransportCredentials
+	serverCreds credentials.TransportCredentials
+
+	mu          sync.Mutex
+	forwarded   []string
+	httpIngress bool
+}
+
+func newSpyCredentialsProvider(client, server credentials.TransportCredentials) *spyCredentialsProvider {
+	return &spyCredentialsProvider{
+		clientCreds: client,
+		serverCreds: server,
+	}
+}
+
+func (s *spyCredentialsProvider) DopplerCredentials(string) (credentials.TransportCredentials, error) {
+	return s.clientCreds, nil
+}
+
+func (s *spyCredentialsProvider) ForwardCredentials(addr, serverName string) (credentials.TransportCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forwarded = append(s.forwarded, addr+" "+serverName)
+
+	return s.clientCreds, nil
+}
+
+func (s *spyCredentialsProvider) ServerCredentials() (credentials.TransportCredentials, error) {
+	return s.serverCreds, nil
+}
+
+func (s *spyCredentialsProvider) IngressTLSConfig() (*tls.Config, error) {
+	return nil, nil
+}
+
+func (s *spyCredentialsProvider) HTTPIngressTLSConfig() (*tls.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.httpIngress = true
+
+	return plumbing.NewServerTLSConfig(testhelper.Cert("metron.crt"), testhelper.Cert("metron.key"))
+}
+
+func (s *spyCredentialsProvider) httpIngressRequested() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.httpIngress
+}
+
+func (s *spyCredentialsProvider) forwardedTo() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.forwarded...)
+}
+
+// freePort returns a port that nothing was listening on when it was
+// called.
+func freePort() int {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	defer lis.Close()
+
+	return lis.Addr().(*net.TCPAddr).Port
+}
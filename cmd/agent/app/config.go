@@ -2,10 +2,22 @@ package app
 
 import (
 	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	envstruct "code.cloudfoundry.org/go-envstruct"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
 	"golang.org/x/net/idna"
+	"google.golang.org/grpc"
 )
 
 // GRPC stores the configuration for the router as a server using a PORT
@@ -16,8 +28,112 @@ type GRPC struct {
 	CertFile     string   `env:"AGENT_CERT_FILE"`
 	KeyFile      string   `env:"AGENT_KEY_FILE"`
 	CipherSuites []string `env:"AGENT_CIPHER_SUITES"`
+
+	// SPIFFEEndpointSocket is the path of a SPIFFE Workload API socket.
+	// When it is set, TLS credentials are fetched and rotated from the
+	// Workload API and the file options are ignored.
+	SPIFFEEndpointSocket string `env:"AGENT_SPIFFE_ENDPOINT_SOCKET"`
 }
 
+const (
+	// MemoryBufferType buffers v2 envelopes in a diode on the heap.
+	MemoryBufferType = "memory"
+
+	// MMapBufferType buffers v2 envelopes in a ring buffer mapped outside
+	// of the heap. The size of the ring is set by MMapBufferSize.
+	MMapBufferType = "mmap"
+)
+
+const (
+	// OverflowDrop overwrites the oldest envelopes in the ingress buffer
+	// when it is full.
+	OverflowDrop = "drop"
+
+	// OverflowBlock holds ingress writers while the ingress buffer is full,
+	// pushing back on emitters instead of losing envelopes.
+	OverflowBlock = "block"
+
+	// OverflowPrioritize sheds log envelopes once the ingress buffer is
+	// nearly full so that counters and gauges are retained.
+	OverflowPrioritize = "prioritize"
+)
+
+const (
+	// FailoverStrictAZ only connects to Dopplers in the Agent's AZ.
+	FailoverStrictAZ = "strict_az"
+
+	// FailoverPreferAZ connects to Dopplers in other AZs only when none in
+	// the Agent's AZ can be connected to for AZFailoverTimeout.
+	FailoverPreferAZ = "prefer_az"
+
+	// FailoverRoundRobin spreads connections across Dopplers in the Agent's
+	// AZ and in other AZs.
+	FailoverRoundRobin = "round_robin"
+)
+
+const (
+	// IPFamilyDual connects to Dopplers over IPv4 and IPv6 alike.
+	IPFamilyDual = "dual"
+
+	// IPFamilyV4 prefers connecting to Dopplers over IPv4.
+	IPFamilyV4 = "ipv4"
+
+	// IPFamilyV6 prefers connecting to Dopplers over IPv6.
+	IPFamilyV6 = "ipv6"
+)
+
+const (
+	// BOSHDNSHealthSmart resolves Dopplers reporting healthy, or every
+	// Doppler if none are.
+	BOSHDNSHealthSmart = "smart"
+
+	// BOSHDNSHealthHealthy only resolves Dopplers reporting healthy.
+	BOSHDNSHealthHealthy = "healthy"
+
+	// BOSHDNSHealthAll resolves Dopplers regardless of their health.
+	BOSHDNSHealthAll = "all"
+)
+
+const (
+	// TagPrecedenceEnvelope keeps the value of an envelope's tag when it has
+	// the same name as one of the agent's tags.
+	TagPrecedenceEnvelope = "envelope"
+
+	// TagPrecedenceAgent replaces an envelope's tag with the agent's tag of
+	// the same name.
+	TagPrecedenceAgent = "agent"
+)
+
+const (
+	// AllDrainType forwards logs, counters and gauges to aggregate drains.
+	AllDrainType = "all"
+
+	// LogsDrainType forwards only logs to aggregate drains.
+	LogsDrainType = "logs"
+)
+
+const (
+	// ShardBySourceID sets the envelopes of a source on the same ingress
+	// shard so that they stay in order.
+	ShardBySourceID = "source_id"
+
+	// ShardRoundRobin spreads envelopes evenly across the ingress shards.
+	ShardRoundRobin = "round_robin"
+)
+
+const (
+	// OCSPStaplingOff ignores OCSP responses stapled by Dopplers.
+	OCSPStaplingOff = "off"
+
+	// OCSPStaplingCheck rejects Dopplers that staple an OCSP response
+	// reporting their certificate as revoked.
+	OCSPStaplingCheck = "check"
+
+	// OCSPStaplingRequire rejects Dopplers that do not staple a current
+	// OCSP response reporting their certificate as good.
+	OCSPStaplingRequire = "require"
+)
+
 // Config stores all configurations options for the Agent.
 type Config struct {
 	Deployment                      string            `env:"AGENT_DEPLOYMENT"`
@@ -27,13 +143,106 @@ type Config struct {
 	IP                              string            `env:"AGENT_IP"`
 	Tags                            map[string]string `env:"AGENT_TAGS"`
 	DisableUDP                      bool              `env:"AGENT_DISABLE_UDP"`
+	ConvertUDPToV2                  bool              `env:"AGENT_CONVERT_UDP_TO_V2"`
 	IncomingUDPPort                 int               `env:"AGENT_INCOMING_UDP_PORT"`
 	HealthEndpointPort              uint              `env:"AGENT_HEALTH_ENDPOINT_PORT"`
 	MetricBatchIntervalMilliseconds uint              `env:"AGENT_METRIC_BATCH_INTERVAL_MILLISECONDS"`
 	MetricSourceID                  string            `env:"AGENT_METRIC_SOURCE_ID"`
+	PProfEnabled                    bool              `env:"AGENT_PPROF_ENABLED"`
 	PProfPort                       uint32            `env:"AGENT_PPROF_PORT"`
+	PProfBlockProfileRate           int               `env:"AGENT_PPROF_BLOCK_PROFILE_RATE"`
+	AdminPort                       uint32            `env:"AGENT_ADMIN_PORT"`
+	DebugOutputDir                  string            `env:"AGENT_DEBUG_OUTPUT_DIR"`
+	HTTPIngressPort                 uint16            `env:"AGENT_HTTP_INGRESS_PORT"`
 	RouterAddr                      string            `env:"ROUTER_ADDR"`
 	RouterAddrWithAZ                string            `env:"ROUTER_ADDR_WITH_AZ"`
+	AZFailoverPolicy                string            `env:"AGENT_AZ_FAILOVER_POLICY"`
+	AZFailoverTimeout               time.Duration     `env:"AGENT_AZ_FAILOVER_TIMEOUT"`
+	DNSResolveInterval              time.Duration     `env:"AGENT_DNS_RESOLVE_INTERVAL"`
+	IPFamily                        string            `env:"AGENT_IP_FAMILY"`
+	LogFormat                       string            `env:"LOG_FORMAT"`
+	LogLevel                        string            `env:"LOG_LEVEL"`
+	LogFile                         string            `env:"AGENT_LOG_FILE"`
+	LogFileMaxSize                  int64             `env:"AGENT_LOG_FILE_MAX_SIZE"`
+	LogFileMaxAge                   time.Duration     `env:"AGENT_LOG_FILE_MAX_AGE"`
+	LogFileMaxBackups               int               `env:"AGENT_LOG_FILE_MAX_BACKUPS"`
+	LogFileCompress                 bool              `env:"AGENT_LOG_FILE_COMPRESS"`
+	PipelineConfigPath              string            `env:"AGENT_PIPELINE_CONFIG_PATH"`
+	BufferType                      string            `env:"AGENT_BUFFER_TYPE"`
+	MMapBufferSize                  int               `env:"AGENT_MMAP_BUFFER_SIZE"`
+	IngressBufferSize               int               `env:"AGENT_INGRESS_BUFFER_SIZE"`
+	IngressOverflowPolicy           string            `env:"AGENT_INGRESS_OVERFLOW_POLICY"`
+	IngressIsolatedSources          int               `env:"AGENT_INGRESS_ISOLATED_SOURCES"`
+	IngressIsolatedBufferSize       int               `env:"AGENT_INGRESS_ISOLATED_BUFFER_SIZE"`
+	SelfTelemetryInterval           time.Duration     `env:"AGENT_SELF_TELEMETRY_INTERVAL"`
+	UnixSocketPath                  string            `env:"AGENT_UNIX_SOCKET_PATH"`
+	UnixSocketMode                  string            `env:"AGENT_UNIX_SOCKET_MODE"`
+	CatchUpRateMultiple             float64           `env:"AGENT_CATCH_UP_RATE_MULTIPLE"`
+	JournaldEnabled                 bool              `env:"AGENT_JOURNALD_ENABLED"`
+	JournaldCursorPath              string            `env:"AGENT_JOURNALD_CURSOR_PATH"`
+	FileTailGlobs                   []string          `env:"AGENT_FILE_TAIL_GLOBS"`
+	FileTailCheckpointPath          string            `env:"AGENT_FILE_TAIL_CHECKPOINT_PATH"`
+	SinkBufferSize                  int               `env:"AGENT_SINK_BUFFER_SIZE"`
+	AggregateDrainURLs              []string          `env:"AGENT_AGGREGATE_DRAIN_URLS"`
+	AggregateDrainType              string            `env:"AGENT_AGGREGATE_DRAIN_TYPE"`
+	AggregateDrainSkipCertVerify    bool              `env:"AGENT_AGGREGATE_DRAIN_SKIP_CERT_VERIFY"`
+	BindingsAPIAddr                 string            `env:"AGENT_BINDINGS_API_ADDR"`
+	BindingsAPICAFile               string            `env:"AGENT_BINDINGS_API_CA_FILE"`
+	BindingsAPICertFile             string            `env:"AGENT_BINDINGS_API_CERT_FILE"`
+	BindingsAPIKeyFile              string            `env:"AGENT_BINDINGS_API_KEY_FILE"`
+	BindingsAPICommonName           string            `env:"AGENT_BINDINGS_API_COMMON_NAME"`
+	BindingsPollingInterval         time.Duration     `env:"AGENT_BINDINGS_POLLING_INTERVAL"`
+	AppDrainSkipCertVerify          bool              `env:"AGENT_APP_DRAIN_SKIP_CERT_VERIFY"`
+	TapAddr                         string            `env:"AGENT_TAP_ADDR"`
+	IngressShards                   int               `env:"AGENT_INGRESS_SHARDS"`
+	IngressShardBy                  string            `env:"AGENT_INGRESS_SHARD_BY"`
+	IngressAllowedIdentities        []string          `env:"AGENT_INGRESS_ALLOWED_IDENTITIES"`
+	IngressIdentityAction           string            `env:"AGENT_INGRESS_IDENTITY_ACTION"`
+	IngressReflectionEnabled        bool              `env:"AGENT_INGRESS_REFLECTION_ENABLED"`
+	IngressMaxRecvMsgSize           int               `env:"AGENT_INGRESS_MAX_RECV_MSG_SIZE"`
+	IngressMaxStreams               int               `env:"AGENT_INGRESS_MAX_STREAMS"`
+	IngressMaxConnections           int               `env:"AGENT_INGRESS_MAX_CONNECTIONS"`
+	ForwardAddrs                    []string          `env:"AGENT_FORWARD_ADDRS"`
+	ForwardServerName               string            `env:"AGENT_FORWARD_SERVER_NAME"`
+	DopplerCRLFile                  string            `env:"AGENT_DOPPLER_CRL_FILE"`
+	DopplerCRLRefreshInterval       time.Duration     `env:"AGENT_DOPPLER_CRL_REFRESH_INTERVAL"`
+	DopplerOCSPStapling             string            `env:"AGENT_DOPPLER_OCSP_STAPLING"`
+	DopplerProxyURL                 string            `env:"AGENT_DOPPLER_PROXY_URL" json:"-"`
+	TracingOTLPURL                  string            `env:"AGENT_TRACING_OTLP_URL"`
+	TracingSampleRatio              float64           `env:"AGENT_TRACING_SAMPLE_RATIO"`
+	TagPrecedence                   string            `env:"AGENT_TAG_PRECEDENCE"`
+	ReportTagConflicts              bool              `env:"AGENT_REPORT_TAG_CONFLICTS"`
+	SkipDeprecatedTags              bool              `env:"AGENT_SKIP_DEPRECATED_TAGS"`
+	MaxEnvelopeSize                 int               `env:"AGENT_MAX_ENVELOPE_SIZE"`
+	MaxLogPayloadSize               int               `env:"AGENT_MAX_LOG_PAYLOAD_SIZE"`
+	LogTruncationSuffix             string            `env:"AGENT_LOG_TRUNCATION_SUFFIX"`
+	SanitizeUTF8                    bool              `env:"AGENT_SANITIZE_UTF8"`
+	SplitLogLines                   bool              `env:"AGENT_SPLIT_LOG_LINES"`
+	JoinMultilineLogs               bool              `env:"AGENT_JOIN_MULTILINE_LOGS"`
+	BackfillTimestamps              bool              `env:"AGENT_BACKFILL_TIMESTAMPS"`
+	MonotonicTimestamps             bool              `env:"AGENT_MONOTONIC_TIMESTAMPS"`
+	IngressPeerMetrics              bool              `env:"AGENT_INGRESS_PEER_METRICS"`
+	IngressClientRateLimit          float64           `env:"AGENT_INGRESS_CLIENT_RATE_LIMIT"`
+	IngressClientRateBurst          int               `env:"AGENT_INGRESS_CLIENT_RATE_BURST"`
+	IngressClientRateKey            string            `env:"AGENT_INGRESS_CLIENT_RATE_KEY"`
+	IngressFlowControlWindow        int               `env:"AGENT_INGRESS_FLOW_CONTROL_WINDOW"`
+	IngressFlowControlMaxWait       time.Duration     `env:"AGENT_INGRESS_FLOW_CONTROL_MAX_WAIT"`
+	DopplerServiceConfig            string            `env:"AGENT_DOPPLER_SERVICE_CONFIG"`
+	DopplerWriteTimeout             time.Duration     `env:"AGENT_DOPPLER_WRITE_TIMEOUT"`
+	IngressSizeMetrics              bool              `env:"AGENT_INGRESS_SIZE_METRICS"`
+	ProbeInterval                   time.Duration     `env:"AGENT_PROBE_INTERVAL"`
+	ProbeTimeout                    time.Duration     `env:"AGENT_PROBE_TIMEOUT"`
+	DropAlertThreshold              float64           `env:"AGENT_DROP_ALERT_THRESHOLD"`
+	DropAlertWindow                 time.Duration     `env:"AGENT_DROP_ALERT_WINDOW"`
+	ShutdownDrainTimeout            time.Duration     `env:"AGENT_SHUTDOWN_DRAIN_TIMEOUT"`
+	DopplerConsulAddr               string            `env:"AGENT_DOPPLER_CONSUL_ADDR"`
+	DopplerConsulService            string            `env:"AGENT_DOPPLER_CONSUL_SERVICE"`
+	DopplerConsulTag                string            `env:"AGENT_DOPPLER_CONSUL_TAG"`
+	DopplerConsulDatacenter         string            `env:"AGENT_DOPPLER_CONSUL_DATACENTER"`
+	DopplerConsulToken              string            `env:"AGENT_DOPPLER_CONSUL_TOKEN" json:"-"`
+	DopplerConsulRefreshInterval    time.Duration     `env:"AGENT_DOPPLER_CONSUL_REFRESH_INTERVAL"`
+	BOSHDNSHealth                   string            `env:"AGENT_BOSH_DNS_HEALTH"`
+	BOSHDNSAZIndexes                []string          `env:"AGENT_BOSH_DNS_AZ_INDEXES"`
 	GRPC                            GRPC
 }
 
@@ -44,6 +253,39 @@ func LoadConfig() (*Config, error) {
 		MetricSourceID:                  "metron",
 		IncomingUDPPort:                 3457,
 		HealthEndpointPort:              14824,
+		LogFormat:                       logging.TextFormat,
+		LogLevel:                        logging.InfoLevel.String(),
+		LogFileMaxSize:                  100 * 1024 * 1024,
+		LogFileMaxBackups:               7,
+		BufferType:                      MemoryBufferType,
+		MMapBufferSize:                  256 * 1024 * 1024,
+		IngressBufferSize:               10000,
+		IngressOverflowPolicy:           OverflowDrop,
+		AZFailoverPolicy:                FailoverPreferAZ,
+		IPFamily:                        IPFamilyDual,
+		IngressIsolatedBufferSize:       1000,
+		UnixSocketMode:                  "0660",
+		JournaldCursorPath:              "/var/vcap/data/loggregator_agent/journald.cursor",
+		FileTailCheckpointPath:          "/var/vcap/data/loggregator_agent/file_tail.json",
+		SinkBufferSize:                  10000,
+		AggregateDrainType:              AllDrainType,
+		BindingsPollingInterval:         time.Minute,
+		IngressShards:                   1,
+		IngressShardBy:                  ShardBySourceID,
+		IngressIdentityAction:           "reject",
+		IngressMaxRecvMsgSize:           4 * 1024 * 1024,
+		ForwardServerName:               "metron",
+		DopplerCRLRefreshInterval:       time.Hour,
+		DopplerOCSPStapling:             OCSPStaplingOff,
+		TracingSampleRatio:              0.001,
+		TagPrecedence:                   TagPrecedenceEnvelope,
+		IngressClientRateKey:            "connection",
+		IngressFlowControlMaxWait:       time.Second,
+		ProbeTimeout:                    30 * time.Second,
+		DropAlertWindow:                 time.Minute,
+		ShutdownDrainTimeout:            10 * time.Second,
+		DopplerConsulService:            "doppler",
+		DopplerConsulRefreshInterval:    10 * time.Second,
 		GRPC: GRPC{
 			Port: 3458,
 		},
@@ -57,6 +299,281 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("RouterAddr is required")
 	}
 
+	if hostPorts := staticHostPorts(config.RouterAddr); hostPorts != nil {
+		for _, hp := range hostPorts {
+			if _, _, err := net.SplitHostPort(hp); err != nil {
+				return nil, fmt.Errorf("RouterAddr entries must be host:port: %s", err)
+			}
+		}
+		config.RouterAddr = strings.Join(hostPorts, ",")
+	}
+
+	if config.LogFormat != logging.TextFormat && config.LogFormat != logging.JSONFormat {
+		return nil, fmt.Errorf("LogFormat must be %q or %q", logging.TextFormat, logging.JSONFormat)
+	}
+
+	if _, err := logging.ParseLevel(config.LogLevel); err != nil {
+		return nil, fmt.Errorf("LogLevel must be one of debug, info, warn or error")
+	}
+
+	if config.LogFileMaxSize < 0 {
+		return nil, fmt.Errorf("LogFileMaxSize must not be negative")
+	}
+
+	if config.LogFileMaxAge < 0 {
+		return nil, fmt.Errorf("LogFileMaxAge must not be negative")
+	}
+
+	if config.LogFileMaxBackups < 0 {
+		return nil, fmt.Errorf("LogFileMaxBackups must not be negative")
+	}
+
+	if config.BufferType != MemoryBufferType && config.BufferType != MMapBufferType {
+		return nil, fmt.Errorf("BufferType must be %q or %q", MemoryBufferType, MMapBufferType)
+	}
+
+	if _, err := strconv.ParseUint(config.UnixSocketMode, 8, 32); err != nil {
+		return nil, fmt.Errorf("UnixSocketMode must be an octal file mode")
+	}
+
+	if config.CatchUpRateMultiple < 0 {
+		return nil, fmt.Errorf("CatchUpRateMultiple must not be negative")
+	}
+
+	if config.SinkBufferSize <= 0 {
+		return nil, fmt.Errorf("SinkBufferSize must be positive")
+	}
+
+	if config.AggregateDrainType != AllDrainType && config.AggregateDrainType != LogsDrainType {
+		return nil, fmt.Errorf("AggregateDrainType must be %q or %q", AllDrainType, LogsDrainType)
+	}
+
+	if config.BindingsPollingInterval <= 0 {
+		return nil, fmt.Errorf("BindingsPollingInterval must be positive")
+	}
+
+	if config.IngressShards < 1 {
+		return nil, fmt.Errorf("IngressShards must be at least 1")
+	}
+
+	if config.IngressShardBy != ShardBySourceID && config.IngressShardBy != ShardRoundRobin {
+		return nil, fmt.Errorf("IngressShardBy must be %q or %q", ShardBySourceID, ShardRoundRobin)
+	}
+
+	if config.IngressShards > 1 && config.BufferType == MMapBufferType {
+		return nil, fmt.Errorf("IngressShards must be 1 when BufferType is %q", MMapBufferType)
+	}
+
+	if config.IngressBufferSize <= 0 {
+		return nil, fmt.Errorf("IngressBufferSize must be positive")
+	}
+
+	switch config.IngressOverflowPolicy {
+	case OverflowDrop:
+	case OverflowBlock, OverflowPrioritize:
+		if config.BufferType != MemoryBufferType || config.IngressShards > 1 {
+			return nil, fmt.Errorf("IngressOverflowPolicy %q requires a single %q buffer", config.IngressOverflowPolicy, MemoryBufferType)
+		}
+	default:
+		return nil, fmt.Errorf("IngressOverflowPolicy must be %q, %q or %q", OverflowDrop, OverflowBlock, OverflowPrioritize)
+	}
+
+	switch config.AZFailoverPolicy {
+	case FailoverStrictAZ, FailoverPreferAZ, FailoverRoundRobin:
+	default:
+		return nil, fmt.Errorf("AZFailoverPolicy must be %q, %q or %q", FailoverStrictAZ, FailoverPreferAZ, FailoverRoundRobin)
+	}
+
+	if config.AZFailoverTimeout < 0 {
+		return nil, fmt.Errorf("AZFailoverTimeout must not be negative")
+	}
+
+	switch config.IPFamily {
+	case IPFamilyDual, IPFamilyV4, IPFamilyV6:
+	default:
+		return nil, fmt.Errorf("IPFamily must be %q, %q or %q", IPFamilyDual, IPFamilyV4, IPFamilyV6)
+	}
+
+	if config.DNSResolveInterval < 0 {
+		return nil, fmt.Errorf("DNSResolveInterval must not be negative")
+	}
+
+	if config.IngressIsolatedSources < 0 {
+		return nil, fmt.Errorf("IngressIsolatedSources must not be negative")
+	}
+
+	if config.IngressIsolatedBufferSize <= 0 {
+		return nil, fmt.Errorf("IngressIsolatedBufferSize must be positive")
+	}
+
+	if config.IngressIsolatedSources > 0 && (config.BufferType != MemoryBufferType ||
+		config.IngressShards > 1 || config.IngressOverflowPolicy != OverflowDrop) {
+		return nil, fmt.Errorf("IngressIsolatedSources requires a single %q buffer that drops on overflow", MemoryBufferType)
+	}
+
+	if _, err := ingress.ParseIdentityAction(config.IngressIdentityAction); err != nil {
+		return nil, fmt.Errorf("IngressIdentityAction must be \"reject\" or \"tag\"")
+	}
+
+	if config.IngressMaxRecvMsgSize <= 0 {
+		return nil, fmt.Errorf("IngressMaxRecvMsgSize must be positive")
+	}
+
+	if config.IngressMaxStreams < 0 {
+		return nil, fmt.Errorf("IngressMaxStreams must not be negative")
+	}
+
+	if config.IngressMaxConnections < 0 {
+		return nil, fmt.Errorf("IngressMaxConnections must not be negative")
+	}
+
+	if config.IngressClientRateLimit < 0 {
+		return nil, fmt.Errorf("IngressClientRateLimit must not be negative")
+	}
+
+	if config.IngressClientRateBurst < 0 {
+		return nil, fmt.Errorf("IngressClientRateBurst must not be negative")
+	}
+
+	if config.IngressClientRateBurst == 0 {
+		config.IngressClientRateBurst = int(math.Ceil(config.IngressClientRateLimit))
+	}
+
+	if _, err := ingress.ParseClientKey(config.IngressClientRateKey); err != nil {
+		return nil, fmt.Errorf("IngressClientRateKey must be \"connection\" or \"identity\"")
+	}
+
+	if config.IngressFlowControlWindow < 0 {
+		return nil, fmt.Errorf("IngressFlowControlWindow must not be negative")
+	}
+
+	// Flow control compares the depth of the buffer, in envelopes, with its
+	// size, which an mmap buffer gives in bytes.
+	if config.IngressFlowControlWindow > 0 && config.BufferType == MMapBufferType {
+		return nil, fmt.Errorf("IngressFlowControlWindow requires a %q buffer", MemoryBufferType)
+	}
+
+	if config.IngressFlowControlMaxWait <= 0 {
+		return nil, fmt.Errorf("IngressFlowControlMaxWait must be positive")
+	}
+
+	if config.ProbeInterval < 0 {
+		return nil, fmt.Errorf("ProbeInterval must not be negative")
+	}
+
+	if config.ProbeTimeout <= 0 {
+		return nil, fmt.Errorf("ProbeTimeout must be positive")
+	}
+
+	if config.DropAlertThreshold < 0 || config.DropAlertThreshold > 100 {
+		return nil, fmt.Errorf("DropAlertThreshold must be a percentage between 0 and 100")
+	}
+
+	if config.DropAlertWindow <= 0 {
+		return nil, fmt.Errorf("DropAlertWindow must be positive")
+	}
+
+	if config.ShutdownDrainTimeout <= 0 {
+		return nil, fmt.Errorf("ShutdownDrainTimeout must be positive")
+	}
+
+	switch config.BOSHDNSHealth {
+	case "", BOSHDNSHealthSmart, BOSHDNSHealthHealthy, BOSHDNSHealthAll:
+	default:
+		return nil, fmt.Errorf("BOSHDNSHealth must be %q, %q or %q", BOSHDNSHealthSmart, BOSHDNSHealthHealthy, BOSHDNSHealthAll)
+	}
+
+	for _, i := range config.BOSHDNSAZIndexes {
+		if n, err := strconv.Atoi(i); err != nil || n < 0 {
+			return nil, fmt.Errorf("BOSHDNSAZIndexes must be non-negative integers: %q", i)
+		}
+	}
+
+	if config.DopplerConsulAddr != "" {
+		if config.DopplerConsulService == "" {
+			return nil, fmt.Errorf("DopplerConsulService is required with DopplerConsulAddr")
+		}
+		if config.DopplerConsulRefreshInterval <= 0 {
+			return nil, fmt.Errorf("DopplerConsulRefreshInterval must be positive")
+		}
+	}
+
+	switch config.DopplerOCSPStapling {
+	case OCSPStaplingOff, OCSPStaplingCheck, OCSPStaplingRequire:
+	default:
+		return nil, fmt.Errorf("DopplerOCSPStapling must be %q, %q or %q", OCSPStaplingOff, OCSPStaplingCheck, OCSPStaplingRequire)
+	}
+
+	if config.DopplerCRLRefreshInterval <= 0 {
+		return nil, fmt.Errorf("DopplerCRLRefreshInterval must be positive")
+	}
+
+	if config.SplitLogLines && config.JoinMultilineLogs {
+		return nil, fmt.Errorf("SplitLogLines and JoinMultilineLogs can not both be enabled")
+	}
+
+	if config.MaxEnvelopeSize < 0 {
+		return nil, fmt.Errorf("MaxEnvelopeSize must not be negative")
+	}
+
+	if config.MaxLogPayloadSize < 0 {
+		return nil, fmt.Errorf("MaxLogPayloadSize must not be negative")
+	}
+
+	if config.MaxLogPayloadSize > 0 && len(config.LogTruncationSuffix) >= config.MaxLogPayloadSize {
+		return nil, fmt.Errorf("LogTruncationSuffix must be shorter than MaxLogPayloadSize")
+	}
+
+	if config.TagPrecedence != TagPrecedenceEnvelope && config.TagPrecedence != TagPrecedenceAgent {
+		return nil, fmt.Errorf("TagPrecedence must be %q or %q", TagPrecedenceEnvelope, TagPrecedenceAgent)
+	}
+
+	if config.TracingOTLPURL != "" {
+		u, err := url.Parse(config.TracingOTLPURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("TracingOTLPURL must be an http or https URL")
+		}
+	}
+
+	if config.TracingSampleRatio < 0 || config.TracingSampleRatio > 1 {
+		return nil, fmt.Errorf("TracingSampleRatio must be between 0 and 1")
+	}
+
+	if config.DopplerProxyURL != "" {
+		if _, err := plumbing.NewProxyDialer(config.DopplerProxyURL); err != nil {
+			return nil, fmt.Errorf("DopplerProxyURL is invalid: %s", err)
+		}
+	}
+
+	if config.DopplerWriteTimeout < 0 {
+		return nil, fmt.Errorf("DopplerWriteTimeout must not be negative")
+	}
+
+	if config.DopplerServiceConfig != "" {
+		if err := validateServiceConfig(config.DopplerServiceConfig); err != nil {
+			return nil, fmt.Errorf("DopplerServiceConfig is invalid: %s", err)
+		}
+	}
+
+	if config.TapAddr != "" && !isLoopbackAddr(config.TapAddr) {
+		return nil, fmt.Errorf("TapAddr must be a localhost address")
+	}
+
+	for _, raw := range config.AggregateDrainURLs {
+		u, err := url.Parse(raw)
+		if err == nil {
+			err = egress.ValidateSyslogURL(u)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("AggregateDrainURLs must be syslog or syslog-tls URLs: %s", err)
+		}
+	}
+
+	config.Tags, err = expandTags(config.Tags, placeholderValues(&config))
+	if err != nil {
+		return nil, err
+	}
+
 	config.RouterAddrWithAZ, err = idna.ToASCII(config.RouterAddrWithAZ)
 	if err != nil {
 		return nil, err
@@ -65,3 +582,94 @@ func LoadConfig() (*Config, error) {
 
 	return &config, nil
 }
+
+var placeholderPattern = regexp.MustCompile(`{{\s*(\w+)\s*}}`)
+
+// placeholderValues returns the values of the placeholders that can be used
+// in tags, e.g. {{az}}.
+func placeholderValues(c *Config) map[string]string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Warnf("failed to get hostname for tags: %s", err)
+	}
+
+	return map[string]string{
+		"hostname":   hostname,
+		"ip":         c.IP,
+		"az":         c.Zone,
+		"bosh_job":   c.Job,
+		"index":      c.Index,
+		"deployment": c.Deployment,
+	}
+}
+
+// expandTags replaces the placeholders in tag values with their values. It
+// returns an error if a tag uses an unknown placeholder.
+func expandTags(tags, values map[string]string) (map[string]string, error) {
+	for k, v := range tags {
+		var unknown string
+		tags[k] = placeholderPattern.ReplaceAllStringFunc(v, func(p string) string {
+			name := placeholderPattern.FindStringSubmatch(p)[1]
+			value, ok := values[name]
+			if !ok {
+				unknown = name
+			}
+			return value
+		})
+
+		if unknown != "" {
+			return nil, fmt.Errorf("tag %q uses unknown placeholder {{%s}}", k, unknown)
+		}
+	}
+
+	return tags, nil
+}
+
+// staticHostPorts returns the entries of a comma-separated list of
+// host:port addresses or nil if addr is a single address. A list of Doppler
+// addresses is connected to in turn without DNS.
+func staticHostPorts(addr string) []string {
+	if !strings.Contains(addr, ",") {
+		return nil
+	}
+
+	var hostPorts []string
+	for _, hp := range strings.Split(addr, ",") {
+		if hp = strings.TrimSpace(hp); hp != "" {
+			hostPorts = append(hostPorts, hp)
+		}
+	}
+
+	return hostPorts
+}
+
+// isLoopbackAddr reports whether the host of a host:port address is
+// localhost or a loopback IP.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validateServiceConfig returns an error if gRPC does not accept s as a
+// default service config. gRPC only parses it when dialing, which does not
+// wait for a connection.
+func validateServiceConfig(s string) error {
+	conn, err := grpc.Dial("passthrough:///localhost",
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(s),
+	)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"crypto/tls"
+
+	"code.cloudfoundry.org/go-loggregator"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	"code.cloudfoundry.org/loggregator-agent/pkg/spiffe"
+	"google.golang.org/grpc/credentials"
+)
+
+// CredentialsProvider provides the transport credentials the agent dials
+// and serves gRPC with. Providers other than the file and SPIFFE ones the
+// agent configures itself, such as one fetching certificates from a secret
+// store or trusting a different CA per destination, can be set with
+// WithCredentialsProvider.
+type CredentialsProvider interface {
+	// DopplerCredentials returns the credentials for dialing the Dopplers
+	// at addr.
+	DopplerCredentials(addr string) (credentials.TransportCredentials, error)
+
+	// ForwardCredentials returns the credentials for dialing the forward
+	// consumer at addr, which is expected to be named serverName.
+	ForwardCredentials(addr, serverName string) (credentials.TransportCredentials, error)
+
+	// ServerCredentials returns the credentials ingress servers use.
+	ServerCredentials() (credentials.TransportCredentials, error)
+
+	// IngressTLSConfig returns the TLS config the agent's own metric
+	// client dials its ingress server with.
+	IngressTLSConfig() (*tls.Config, error)
+
+	// HTTPIngressTLSConfig returns the TLS config the HTTP ingress source
+	// serves with. It presents the same certificate as the gRPC ingress
+	// servers but does not ask clients for one.
+	HTTPIngressTLSConfig() (*tls.Config, error)
+}
+
+// fileCredentials loads credentials from the certificate, key and CA files
+// in the config.
+type fileCredentials struct {
+	config      *Config
+	serverOpts  []plumbing.ConfigOption
+	dopplerOpts []plumbing.ConfigOption
+}
+
+func (f *fileCredentials) DopplerCredentials(string) (credentials.TransportCredentials, error) {
+	return plumbing.NewClientCredentials(
+		f.config.GRPC.CertFile,
+		f.config.GRPC.KeyFile,
+		f.config.GRPC.CAFile,
+		"doppler",
+		f.dopplerOpts...,
+	)
+}
+
+func (f *fileCredentials) ForwardCredentials(_, serverName string) (credentials.TransportCredentials, error) {
+	return plumbing.NewClientCredentials(
+		f.config.GRPC.CertFile,
+		f.config.GRPC.KeyFile,
+		f.config.GRPC.CAFile,
+		serverName,
+	)
+}
+
+func (f *fileCredentials) ServerCredentials() (credentials.TransportCredentials, error) {
+	return plumbing.NewServerCredentials(
+		f.config.GRPC.CertFile,
+		f.config.GRPC.KeyFile,
+		f.config.GRPC.CAFile,
+		f.serverOpts...,
+	)
+}
+
+func (f *fileCredentials) IngressTLSConfig() (*tls.Config, error) {
+	return loggregator.NewIngressTLSConfig(
+		f.config.GRPC.CAFile,
+		f.config.GRPC.CertFile,
+		f.config.GRPC.KeyFile,
+	)
+}
+
+func (f *fileCredentials) HTTPIngressTLSConfig() (*tls.Config, error) {
+	c, err := plumbing.NewServerTLSConfig(f.config.GRPC.CertFile, f.config.GRPC.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range f.serverOpts {
+		o(c)
+	}
+
+	return c, nil
+}
+
+// spiffeCredentials uses the SVIDs fetched from a SPIFFE Workload API.
+// Peers are verified by trust domain rather than by name.
+type spiffeCredentials struct {
+	source     *spiffe.Source
+	serverOpts []plumbing.ConfigOption
+}
+
+func (s *spiffeCredentials) DopplerCredentials(string) (credentials.TransportCredentials, error) {
+	return credentials.NewTLS(s.source.ClientTLSConfig()), nil
+}
+
+func (s *spiffeCredentials) ForwardCredentials(string, string) (credentials.TransportCredentials, error) {
+	return credentials.NewTLS(s.source.ClientTLSConfig()), nil
+}
+
+func (s *spiffeCredentials) ServerCredentials() (credentials.TransportCredentials, error) {
+	return credentials.NewTLS(s.source.ServerTLSConfig(s.serverOpts...)), nil
+}
+
+func (s *spiffeCredentials) IngressTLSConfig() (*tls.Config, error) {
+	return s.source.ClientTLSConfig(), nil
+}
+
+func (s *spiffeCredentials) HTTPIngressTLSConfig() (*tls.Config, error) {
+	opts := append([]plumbing.ConfigOption(nil), s.serverOpts...)
+	opts = append(opts, func(c *tls.Config) {
+		c.ClientAuth = tls.NoClientCert
+	})
+
+	return s.source.ServerTLSConfig(opts...), nil
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"expvar"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+)
+
+// expvarCounters holds the totals of the agent's counter metrics, such as
+// ingress, egress and dropped, by metric name. Counters of the same name
+// with different tags are summed.
+var expvarCounters = expvar.NewMap("counters")
+
+// expvarStats holds the ingress.StatsFunc of the running AppV2. Its stats,
+// such as the connected pool size and batch write latency, are published as
+// "pipeline".
+var expvarStats atomic.Value
+
+func init() {
+	expvar.Publish("pipeline", expvar.Func(pipelineVars))
+}
+
+func pipelineVars() interface{} {
+	vars := make(map[string]float64)
+
+	f, ok := expvarStats.Load().(ingress.StatsFunc)
+	if !ok {
+		return vars
+	}
+
+	for _, s := range f() {
+		vars[statVarName(s)] = s.Value
+	}
+
+	return vars
+}
+
+// statVarName returns the stat's name followed by its tag values in the
+// order of their keys.
+func statVarName(s ingress.Stat) string {
+	keys := make([]string, 0, len(s.Tags))
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{s.Name}
+	for _, k := range keys {
+		parts = append(parts, s.Tags[k])
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// expvarMetricClient adds the increments of every counter metric it creates
+// to expvarCounters.
+type expvarMetricClient struct {
+	MetricClient
+}
+
+func (c expvarMetricClient) NewCounterMetric(name string, opts ...pulseemitter.MetricOption) pulseemitter.CounterMetric {
+	return expvarCounter{
+		CounterMetric: c.MetricClient.NewCounterMetric(name, opts...),
+		name:          name,
+	}
+}
+
+type expvarCounter struct {
+	pulseemitter.CounterMetric
+	name string
+}
+
+func (c expvarCounter) Increment(n uint64) {
+	c.CounterMetric.Increment(n)
+	expvarCounters.Add(c.name, int64(n))
+}
@@ -0,0 +1,321 @@
+// Package loadgen sends synthetic envelopes to a running agent at a fixed
+// rate and reports how the agent kept up, for capacity planning without
+// external tooling.
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/jsonpb"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// tapSettle is how long the tap is given to register the subscription
+// before envelopes are sent.
+const tapSettle = 100 * time.Millisecond
+
+// Config is where to send envelopes, how many to send and where to observe
+// the agent.
+type Config struct {
+	// IngressAddr is the address of the agent's v2 gRPC ingress.
+	IngressAddr string
+
+	// Credentials are used to dial the ingress. The connection is insecure
+	// when they are nil.
+	Credentials credentials.TransportCredentials
+
+	// AdminAddr is the address of the agent's admin API. When it is set
+	// the envelopes dropped by the agent's buffer are reported.
+	AdminAddr string
+
+	// TapAddr is the address of the agent's tap. When it is set the
+	// envelopes egressed and their latency through the agent are reported.
+	TapAddr string
+
+	// Rate is the number of envelopes sent each second.
+	Rate int
+
+	// Duration is how long envelopes are sent for.
+	Duration time.Duration
+
+	// Drain is how long to wait after sending for the agent to egress the
+	// envelopes it has buffered.
+	Drain time.Duration
+
+	// Senders is the number of concurrent streams envelopes are sent on.
+	Senders int
+
+	// BatchSize is the number of envelopes sent in each batch.
+	BatchSize int
+
+	// PayloadSize is the number of bytes in each log payload.
+	PayloadSize int
+
+	// SourceID identifies the synthetic envelopes.
+	SourceID string
+}
+
+// Result is what was observed during a run.
+type Result struct {
+	Duration   time.Duration
+	Sent       uint64
+	SendErrors uint64
+
+	// Egressed and Latencies are only observed when a tap address is set.
+	Egressed  uint64
+	Latencies []time.Duration
+
+	// Dropped is only observed when an admin address is set.
+	Dropped *uint64
+}
+
+// Run sends envelopes as configured and writes a report of the result to
+// out.
+func Run(c Config, out io.Writer) (Result, error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+
+	var droppedBefore uint64
+	if c.AdminAddr != "" {
+		var err error
+		droppedBefore, err = bufferDropped(client, c.AdminAddr)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	var obs *tapObserver
+	if c.TapAddr != "" {
+		var err error
+		obs, err = observeTap(c.TapAddr, c.SourceID)
+		if err != nil {
+			return Result{}, err
+		}
+		defer obs.close()
+		time.Sleep(tapSettle)
+	}
+
+	dialOpt := grpc.WithInsecure()
+	if c.Credentials != nil {
+		dialOpt = grpc.WithTransportCredentials(c.Credentials)
+	}
+	conn, err := grpc.Dial(c.IngressAddr, dialOpt)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	r := Result{Duration: c.Duration}
+	send(c, loggregator_v2.NewIngressClient(conn), &r)
+
+	time.Sleep(c.Drain)
+
+	if obs != nil {
+		r.Egressed, r.Latencies = obs.result()
+	}
+
+	if c.AdminAddr != "" {
+		droppedAfter, err := bufferDropped(client, c.AdminAddr)
+		if err != nil {
+			return Result{}, err
+		}
+		dropped := droppedAfter - droppedBefore
+		r.Dropped = &dropped
+	}
+
+	return r, report(out, c, r)
+}
+
+// send sends envelopes from every sender until the duration has elapsed.
+func send(c Config, client loggregator_v2.IngressClient, r *Result) {
+	payload := make([]byte, c.PayloadSize)
+	for i := range payload {
+		payload[i] = 'x'
+	}
+
+	// Each sender sends a batch every interval so that together they send
+	// at the configured rate.
+	interval := time.Duration(float64(time.Second) * float64(c.BatchSize*c.Senders) / float64(c.Rate))
+	deadline := time.Now().Add(c.Duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.Senders; i++ {
+		wg.Add(1)
+		go func(instanceID string) {
+			defer wg.Done()
+
+			sender, err := client.BatchSender(context.Background())
+			if err != nil {
+				atomic.AddUint64(&r.SendErrors, 1)
+				return
+			}
+			defer sender.CloseAndRecv()
+
+			t := time.NewTicker(interval)
+			defer t.Stop()
+
+			for time.Now().Before(deadline) {
+				batch := make([]*loggregator_v2.Envelope, c.BatchSize)
+				now := time.Now().UnixNano()
+				for j := range batch {
+					batch[j] = &loggregator_v2.Envelope{
+						Timestamp:  now,
+						SourceId:   c.SourceID,
+						InstanceId: instanceID,
+						Message: &loggregator_v2.Envelope_Log{
+							Log: &loggregator_v2.Log{Payload: payload},
+						},
+					}
+				}
+
+				if err := sender.Send(&loggregator_v2.EnvelopeBatch{Batch: batch}); err != nil {
+					atomic.AddUint64(&r.SendErrors, 1)
+					return
+				}
+				atomic.AddUint64(&r.Sent, uint64(len(batch)))
+
+				<-t.C
+			}
+		}(strconv.Itoa(i))
+	}
+
+	wg.Wait()
+}
+
+// tapObserver counts the synthetic envelopes streamed from the tap and
+// records how long each took to get through the agent.
+type tapObserver struct {
+	conn *websocket.Conn
+
+	mu        sync.Mutex
+	egressed  uint64
+	latencies []time.Duration
+}
+
+func observeTap(addr, sourceID string) (*tapObserver, error) {
+	u := url.URL{
+		Scheme:   "ws",
+		Host:     addr,
+		RawQuery: url.Values{"source_id": {sourceID}}.Encode(),
+	}
+	conn, err := websocket.Dial(u.String(), "", "http://localhost/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to tap: %s", err)
+	}
+
+	o := &tapObserver{conn: conn}
+	go o.read()
+
+	return o, nil
+}
+
+func (o *tapObserver) read() {
+	for {
+		var msg string
+		if err := websocket.Message.Receive(o.conn, &msg); err != nil {
+			return
+		}
+
+		var e loggregator_v2.Envelope
+		if err := jsonpb.UnmarshalString(msg, &e); err != nil {
+			continue
+		}
+		latency := time.Since(time.Unix(0, e.GetTimestamp()))
+
+		o.mu.Lock()
+		o.egressed++
+		o.latencies = append(o.latencies, latency)
+		o.mu.Unlock()
+	}
+}
+
+func (o *tapObserver) result() (uint64, []time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	latencies := make([]time.Duration, len(o.latencies))
+	copy(latencies, o.latencies)
+
+	return o.egressed, latencies
+}
+
+func (o *tapObserver) close() {
+	o.conn.Close()
+}
+
+func bufferDropped(client *http.Client, addr string) (uint64, error) {
+	resp, err := client.Get(fmt.Sprintf("http://%s/buffer", addr))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var b struct {
+		Dropped uint64 `json:"dropped"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		return 0, fmt.Errorf("unexpected response from /buffer (%d): %s", resp.StatusCode, err)
+	}
+
+	return b.Dropped, nil
+}
+
+func report(out io.Writer, c Config, r Result) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	seconds := r.Duration.Seconds()
+	fmt.Fprintf(tw, "DURATION\t%s\n", r.Duration)
+	fmt.Fprintf(tw, "SENT\t%d envelopes\t%.1f/s\n", r.Sent, float64(r.Sent)/seconds)
+	fmt.Fprintf(tw, "SEND ERRORS\t%d\n", r.SendErrors)
+
+	if c.TapAddr != "" {
+		fmt.Fprintf(tw, "EGRESSED\t%d envelopes\t%.1f/s\n", r.Egressed, float64(r.Egressed)/seconds)
+	}
+
+	if r.Dropped != nil {
+		var rate float64
+		if r.Sent > 0 {
+			rate = 100 * float64(*r.Dropped) / float64(r.Sent)
+		}
+		fmt.Fprintf(tw, "DROPPED\t%d envelopes\t%.2f%%\n", *r.Dropped, rate)
+	}
+
+	if len(r.Latencies) > 0 {
+		sorted := make([]time.Duration, len(r.Latencies))
+		copy(sorted, r.Latencies)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		fmt.Fprintf(tw, "LATENCY\tp50 %s\tp95 %s\tp99 %s\tmax %s\n",
+			percentile(sorted, 50),
+			percentile(sorted, 95),
+			percentile(sorted, 99),
+			sorted[len(sorted)-1],
+		)
+	}
+
+	return tw.Flush()
+}
+
+// percentile returns the nearest rank percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
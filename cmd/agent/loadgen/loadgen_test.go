@@ -0,0 +1,130 @@
+package loadgen_test
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/cmd/agent/loadgen"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/tap"
+	"google.golang.org/grpc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run", func() {
+	var (
+		received    *spyWriter
+		t           *tap.Tap
+		grpcServer  *grpc.Server
+		ingressAddr string
+		tapServer   *httptest.Server
+		adminServer *httptest.Server
+	)
+
+	BeforeEach(func() {
+		received = &spyWriter{}
+		t = tap.New(received)
+
+		rx := ingress.NewReceiver(tapSetter{t}, testhelper.NewMetricClient(), nil)
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		ingressAddr = lis.Addr().String()
+		grpcServer = grpc.NewServer()
+		loggregator_v2.RegisterIngressServer(grpcServer, rx)
+		go grpcServer.Serve(lis)
+
+		tapServer = httptest.NewServer(t.Handler())
+		adminServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"dropped": 0}`))
+		}))
+	})
+
+	AfterEach(func() {
+		grpcServer.Stop()
+		tapServer.Close()
+		adminServer.Close()
+	})
+
+	It("sends envelopes at the configured rate and reports the result", func() {
+		var out bytes.Buffer
+		r, err := loadgen.Run(loadgen.Config{
+			IngressAddr: ingressAddr,
+			AdminAddr:   strings.TrimPrefix(adminServer.URL, "http://"),
+			TapAddr:     strings.TrimPrefix(tapServer.URL, "http://"),
+			Rate:        1000,
+			Duration:    500 * time.Millisecond,
+			Drain:       200 * time.Millisecond,
+			Senders:     2,
+			BatchSize:   10,
+			PayloadSize: 16,
+			SourceID:    "loadgen",
+		}, &out)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(r.Sent).To(BeNumerically("~", 500, 50))
+		Expect(r.SendErrors).To(BeZero())
+		Expect(received.count()).To(BeNumerically("==", r.Sent))
+		Expect(r.Egressed).To(BeNumerically("==", r.Sent))
+		Expect(r.Latencies).To(HaveLen(int(r.Egressed)))
+		Expect(r.Dropped).ToNot(BeNil())
+		Expect(*r.Dropped).To(BeZero())
+
+		Expect(out.String()).To(ContainSubstring("SENT"))
+		Expect(out.String()).To(ContainSubstring("EGRESSED"))
+		Expect(out.String()).To(MatchRegexp(`DROPPED\s+0 envelopes\s+0.00%`))
+		Expect(out.String()).To(ContainSubstring("LATENCY"))
+	})
+
+	It("only reports what it can observe", func() {
+		var out bytes.Buffer
+		r, err := loadgen.Run(loadgen.Config{
+			IngressAddr: ingressAddr,
+			Rate:        100,
+			Duration:    100 * time.Millisecond,
+			Senders:     1,
+			BatchSize:   10,
+			SourceID:    "loadgen",
+		}, &out)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(r.Sent).ToNot(BeZero())
+		Expect(r.Dropped).To(BeNil())
+		Expect(out.String()).ToNot(ContainSubstring("EGRESSED"))
+		Expect(out.String()).ToNot(ContainSubstring("DROPPED"))
+	})
+})
+
+type tapSetter struct {
+	t *tap.Tap
+}
+
+func (s tapSetter) Set(e *loggregator_v2.Envelope) {
+	s.t.Write([]*loggregator_v2.Envelope{e})
+}
+
+type spyWriter struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (w *spyWriter) Write(batch []*loggregator_v2.Envelope) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.n += len(batch)
+	return nil
+}
+
+func (w *spyWriter) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.n
+}
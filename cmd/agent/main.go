@@ -1,20 +1,62 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
 	"net/http"
-	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 	"time"
 
 	"code.cloudfoundry.org/loggregator-agent/cmd/agent/app"
+	"code.cloudfoundry.org/loggregator-agent/cmd/agent/loadgen"
+	"code.cloudfoundry.org/loggregator-agent/cmd/agent/status"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
 	"google.golang.org/grpc/grpclog"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		runStatus(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "loadgen" {
+		runLoadgen(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		runService(os.Args[2:])
+		return
+	}
+
+	if runningAsService() {
+		runAsService()
+		return
+	}
+
+	a := startAgent()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	log.Printf("received %s, stopping", sig)
+
+	if !a.Stop().Complete {
+		os.Exit(1)
+	}
+}
+
+// startAgent loads the config from the environment and starts the agent.
+func startAgent() *app.Agent {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
 	rand.Seed(time.Now().UnixNano())
@@ -25,13 +67,141 @@ func main() {
 		log.Fatalf("Unable to parse config: %s", err)
 	}
 
+	if err := logging.SetFormat(config.LogFormat); err != nil {
+		log.Fatalf("Unable to set log format: %s", err)
+	}
+
+	level, err := logging.ParseLevel(config.LogLevel)
+	if err != nil {
+		log.Fatalf("Unable to set log level: %s", err)
+	}
+	logging.SetLevel(level)
+
+	if config.LogFile != "" {
+		setLogFile(config)
+	}
+
 	a := app.NewAgent(config)
 	go a.Start()
 
-	runPProf(config.PProfPort)
+	if config.PProfEnabled {
+		go runPProf(config.PProfPort, config.PProfBlockProfileRate)
+	}
+
+	return a
 }
 
-func runPProf(port uint32) {
+// runStatus renders a live view of the agent running on this host. The
+// ports default to the agent's environment so that the subcommand can be
+// run from the agent's job environment without flags.
+func runStatus(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	adminPort := flags.String("admin-port", os.Getenv("AGENT_ADMIN_PORT"), "port of the agent admin API")
+	healthPort := flags.String("health-port", envOr("AGENT_HEALTH_ENDPOINT_PORT", "14824"), "port of the agent health endpoint")
+	interval := flags.Duration("interval", 2*time.Second, "time between refreshes")
+	once := flags.Bool("once", false, "render once and exit")
+	flags.Parse(args)
+
+	c := status.Config{
+		Interval: *interval,
+		Once:     *once,
+	}
+	if *adminPort != "" && *adminPort != "0" {
+		c.AdminAddr = net.JoinHostPort("127.0.0.1", *adminPort)
+	}
+	if *healthPort != "" && *healthPort != "0" {
+		c.HealthAddr = net.JoinHostPort("127.0.0.1", *healthPort)
+	}
+
+	if err := status.Run(c, os.Stdout); err != nil {
+		log.Fatalf("status failed: %s", err)
+	}
+}
+
+// runLoadgen sends synthetic envelopes to the agent running on this host and
+// reports its throughput, drops and latency. Like runStatus, the addresses
+// and certificates default to the agent's environment.
+func runLoadgen(args []string) {
+	flags := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	port := flags.String("port", envOr("AGENT_PORT", "3458"), "port of the agent v2 gRPC ingress")
+	caFile := flags.String("ca-file", os.Getenv("AGENT_CA_FILE"), "CA certificate of the agent")
+	certFile := flags.String("cert-file", os.Getenv("AGENT_CERT_FILE"), "client certificate")
+	keyFile := flags.String("key-file", os.Getenv("AGENT_KEY_FILE"), "client key")
+	serverName := flags.String("server-name", "metron", "name in the agent's certificate")
+	adminPort := flags.String("admin-port", os.Getenv("AGENT_ADMIN_PORT"), "port of the agent admin API, to report drops")
+	tapAddr := flags.String("tap-addr", os.Getenv("AGENT_TAP_ADDR"), "address of the agent tap, to report egress and latency")
+	rate := flags.Int("rate", 10000, "envelopes sent per second")
+	duration := flags.Duration("duration", 10*time.Second, "how long to send envelopes for")
+	drain := flags.Duration("drain", 2*time.Second, "how long to wait for the agent to egress after sending")
+	senders := flags.Int("senders", 4, "number of concurrent streams")
+	batchSize := flags.Int("batch-size", 100, "envelopes in each batch")
+	payloadSize := flags.Int("payload-size", 256, "bytes in each log payload")
+	sourceID := flags.String("source-id", "loadgen", "source ID of the synthetic envelopes")
+	flags.Parse(args)
+
+	if *rate <= 0 || *senders <= 0 || *batchSize <= 0 || *duration <= 0 {
+		log.Fatalf("rate, senders, batch-size and duration must be positive")
+	}
+
+	c := loadgen.Config{
+		IngressAddr: net.JoinHostPort("127.0.0.1", *port),
+		TapAddr:     *tapAddr,
+		Rate:        *rate,
+		Duration:    *duration,
+		Drain:       *drain,
+		Senders:     *senders,
+		BatchSize:   *batchSize,
+		PayloadSize: *payloadSize,
+		SourceID:    *sourceID,
+	}
+	if *adminPort != "" && *adminPort != "0" {
+		c.AdminAddr = net.JoinHostPort("127.0.0.1", *adminPort)
+	}
+	if *caFile != "" {
+		creds, err := plumbing.NewClientCredentials(*certFile, *keyFile, *caFile, *serverName)
+		if err != nil {
+			log.Fatalf("Failed to load client credentials: %s", err)
+		}
+		c.Credentials = creds
+	}
+
+	if _, err := loadgen.Run(c, os.Stdout); err != nil {
+		log.Fatalf("loadgen failed: %s", err)
+	}
+}
+
+// setLogFile writes the agent's logs to the configured file, rotating it
+// by size and age.
+func setLogFile(c *app.Config) {
+	opts := []logging.RotationOption{
+		logging.WithMaxSize(c.LogFileMaxSize),
+		logging.WithMaxAge(c.LogFileMaxAge),
+		logging.WithMaxBackups(c.LogFileMaxBackups),
+	}
+	if c.LogFileCompress {
+		opts = append(opts, logging.WithCompression())
+	}
+
+	f, err := logging.NewRotatingFile(c.LogFile, opts...)
+	if err != nil {
+		log.Fatalf("Unable to open log file: %s", err)
+	}
+	logging.SetOutput(f)
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return def
+}
+
+func runPProf(port uint32, blockProfileRate int) {
+	if blockProfileRate > 0 {
+		runtime.SetBlockProfileRate(blockProfileRate)
+	}
+
 	addr := fmt.Sprintf("127.0.0.1:%d", port)
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -39,7 +209,7 @@ func runPProf(port uint32) {
 	}
 
 	log.Printf("pprof bound to: %s", lis.Addr())
-	err = http.Serve(lis, nil)
+	err = http.Serve(lis, plumbing.NewDebugHandler())
 	if err != nil {
 		log.Panicf("Error starting pprof server: %s", err)
 	}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "log"
+
+// runningAsService reports whether the agent was started by the Windows
+// service control manager, which it never is on other platforms.
+func runningAsService() bool {
+	return false
+}
+
+// runAsService is only supported on Windows.
+func runAsService() {}
+
+// runService is only supported on Windows.
+func runService(args []string) {
+	log.Fatalf("the service subcommand is only supported on Windows")
+}
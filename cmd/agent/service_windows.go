@@ -0,0 +1,234 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name the agent is registered with the Windows service
+// control manager and the event log under.
+const serviceName = "loggregator-agent"
+
+// serviceStopTimeout is how long the stop subcommand waits for the service
+// to stop.
+const serviceStopTimeout = time.Minute
+
+// runningAsService reports whether the agent was started by the Windows
+// service control manager.
+func runningAsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// runAsService runs the agent under the service control manager, logging to
+// the Windows event log unless a log file is configured.
+func runAsService() {
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		log.Printf("failed to open event log: %s", err)
+	} else {
+		defer elog.Close()
+		logging.SetOutput(eventLogWriter{elog: elog})
+	}
+
+	if err := svc.Run(serviceName, &agentService{}); err != nil {
+		log.Fatalf("service failed: %s", err)
+	}
+}
+
+// agentService starts the agent when the service starts and drains it when
+// the service is stopped or the machine shuts down.
+type agentService struct{}
+
+// Execute implements svc.Handler.
+func (s *agentService) Execute(
+	_ []string,
+	requests <-chan svc.ChangeRequest,
+	status chan<- svc.Status,
+) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	a := startAgent()
+	status <- svc.Status{
+		State:   svc.Running,
+		Accepts: svc.AcceptStop | svc.AcceptShutdown,
+	}
+
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			if !a.Stop().Complete {
+				return false, 1
+			}
+			return false, 0
+		}
+	}
+
+	return false, 0
+}
+
+// runService installs, uninstalls, starts or stops the agent's Windows
+// service.
+func runService(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: service install|uninstall|start|stop")
+	}
+
+	var err error
+	switch args[0] {
+	case "install":
+		err = installService()
+	case "uninstall":
+		err = uninstallService()
+	case "start":
+		err = startService()
+	case "stop":
+		err = stopService()
+	default:
+		err = fmt.Errorf("unknown service command %q", args[0])
+	}
+
+	if err != nil {
+		log.Fatalf("service %s failed: %s", args[0], err)
+	}
+}
+
+func installService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Loggregator Agent",
+		Description: "Forwards logs and metrics to Loggregator",
+		StartType:   mgr.StartAutomatic,
+	})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		s.Delete()
+		return err
+	}
+
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return err
+	}
+
+	return eventlog.Remove(serviceName)
+}
+
+func startService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return s.Start()
+}
+
+func stopService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service did not stop within %s", serviceStopTimeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+
+		status, err = s.Query()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eventLogWriter writes each log line to the Windows event log. JSON lines
+// at warn or error level are logged as warnings or errors and every other
+// line as information.
+type eventLogWriter struct {
+	elog *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+
+	var err error
+	switch {
+	case strings.Contains(msg, `"level":"error"`):
+		err = w.elog.Error(1, msg)
+	case strings.Contains(msg, `"level":"warn"`):
+		err = w.elog.Warning(1, msg)
+	default:
+		err = w.elog.Info(1, msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,271 @@
+// Package status renders a live, read-only view of a running agent from its
+// admin and health endpoints.
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+	clientpoolv2 "code.cloudfoundry.org/loggregator-agent/pkg/clientpool/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/healthendpoint"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+)
+
+// clearScreen moves the cursor to the top left and clears the terminal.
+const clearScreen = "\033[H\033[2J"
+
+// Config is where to find the agent and how often to refresh.
+type Config struct {
+	AdminAddr  string
+	HealthAddr string
+	Interval   time.Duration
+
+	// Once renders a single view without clearing the terminal.
+	Once bool
+}
+
+// Run renders the agent's status to out every interval until an error
+// occurs writing to out. When Once is set it renders a single view and
+// returns.
+func Run(c Config, out io.Writer) error {
+	client := &http.Client{Timeout: 2 * time.Second}
+
+	var prev *snapshot
+	for {
+		s := fetch(client, c)
+
+		var b strings.Builder
+		if !c.Once {
+			b.WriteString(clearScreen)
+		}
+		render(&b, c, s, prev)
+
+		if _, err := io.WriteString(out, b.String()); err != nil {
+			return err
+		}
+
+		if c.Once {
+			return nil
+		}
+
+		prev = &s
+		time.Sleep(c.Interval)
+	}
+}
+
+type bufferStats struct {
+	Type            string `json:"type"`
+	Size            int    `json:"size"`
+	Depth           int    `json:"depth"`
+	Dropped         uint64 `json:"dropped"`
+	RecentlyDropped uint64 `json:"recently_dropped"`
+}
+
+type readiness struct {
+	Ready    bool              `json:"ready"`
+	Failures map[string]string `json:"failures"`
+}
+
+// snapshot is everything read from the agent in a single refresh. Sections
+// that could not be read are recorded in errs and left empty.
+type snapshot struct {
+	at          time.Time
+	buffer      *bufferStats
+	connections []clientpoolv2.ConnStats
+	sources     []admin.SourceStatus
+	talkers     []ingress.TalkerCount
+	dopplers    []healthendpoint.DopplerState
+	ready       *readiness
+	errs        []string
+}
+
+func fetch(client *http.Client, c Config) snapshot {
+	s := snapshot{at: time.Now()}
+
+	get := func(addr, path string, v interface{}) bool {
+		if addr == "" {
+			return false
+		}
+
+		if err := getJSON(client, fmt.Sprintf("http://%s%s", addr, path), v); err != nil {
+			s.errs = append(s.errs, fmt.Sprintf("%s: %s", path, err))
+			return false
+		}
+
+		return true
+	}
+
+	var b bufferStats
+	if get(c.AdminAddr, "/buffer", &b) {
+		s.buffer = &b
+	}
+	get(c.AdminAddr, "/connections", &s.connections)
+	get(c.AdminAddr, "/sources/", &s.sources)
+	get(c.AdminAddr, "/top-talkers", &s.talkers)
+
+	get(c.HealthAddr, "/dopplers", &s.dopplers)
+	var r readiness
+	if get(c.HealthAddr, "/ready", &r) {
+		s.ready = &r
+	}
+
+	return s
+}
+
+// getJSON decodes the body of a GET. Responses other than 200 are accepted
+// when they have a JSON body, such as /ready reporting that the agent is not
+// ready.
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unexpected response (%d): %s", resp.StatusCode, err)
+	}
+
+	return nil
+}
+
+func render(out io.Writer, c Config, s snapshot, prev *snapshot) {
+	fmt.Fprintf(out, "loggregator agent status at %s\n", s.at.Format(time.RFC3339))
+	if !c.Once {
+		fmt.Fprintf(out, "refreshing every %s, ctrl-c to exit\n", c.Interval)
+	}
+	fmt.Fprintln(out)
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	if s.ready != nil {
+		if s.ready.Ready {
+			fmt.Fprintln(tw, "READY\tyes")
+		} else {
+			fmt.Fprintln(tw, "READY\tno")
+
+			names := make([]string, 0, len(s.ready.Failures))
+			for name := range s.ready.Failures {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				fmt.Fprintf(tw, "  %s\t%s\n", name, s.ready.Failures[name])
+			}
+		}
+	}
+
+	if s.connections != nil {
+		var connected int
+		var writes int64
+		for _, conn := range s.connections {
+			if conn.Connected {
+				connected++
+			}
+			writes += conn.TotalWrites
+		}
+
+		throughput := "-"
+		if prev != nil && prev.connections != nil {
+			var prevWrites int64
+			for _, conn := range prev.connections {
+				prevWrites += conn.TotalWrites
+			}
+			throughput = fmt.Sprintf("%.1f batches/s", rate(uint64(writes), uint64(prevWrites), s.at.Sub(prev.at)))
+		}
+		fmt.Fprintf(tw, "THROUGHPUT\t%s\t%d batches total\n", throughput, writes)
+		fmt.Fprintf(tw, "CONNECTIONS\t%d/%d connected\n", connected, len(s.connections))
+		for _, conn := range s.connections {
+			addr := conn.Addr
+			if !conn.Connected {
+				addr = "(not connected)"
+			}
+			fmt.Fprintf(tw, "  %s\t%d writes\n", addr, conn.Writes)
+		}
+	}
+
+	if s.buffer != nil {
+		var used float64
+		if s.buffer.Size > 0 {
+			used = 100 * float64(s.buffer.Depth) / float64(s.buffer.Size)
+		}
+		fmt.Fprintf(tw, "BUFFER\t%s\t%d/%d (%.1f%%)\tdropped %d (%d recently)\n",
+			s.buffer.Type, s.buffer.Depth, s.buffer.Size, used, s.buffer.Dropped, s.buffer.RecentlyDropped)
+	}
+
+	if len(s.dopplers) > 0 {
+		fmt.Fprintln(tw, "DOPPLERS")
+		for _, d := range s.dopplers {
+			fmt.Fprintf(tw, "  %s\t%s since %s\t%d streams\t%s\n",
+				d.Addr, d.State, d.Since.Format(time.RFC3339), d.Streams, d.Error)
+		}
+	}
+
+	if len(s.sources) > 0 {
+		fmt.Fprintln(tw, "SOURCES")
+		for _, src := range s.sources {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", src.Name, enabled(src.Enabled), running(src.Running))
+		}
+	}
+
+	if len(s.talkers) > 0 {
+		fmt.Fprintln(tw, "TOP TALKERS")
+		for _, t := range s.talkers {
+			r := "-"
+			if prev != nil {
+				if p, ok := talkerCount(prev.talkers, t.SourceID); ok {
+					r = fmt.Sprintf("%.1f/s", rate(t.Count, p, s.at.Sub(prev.at)))
+				}
+			}
+			fmt.Fprintf(tw, "  %s\t%d envelopes\t%s\n", t.SourceID, t.Count, r)
+		}
+	}
+
+	for _, err := range s.errs {
+		fmt.Fprintf(tw, "ERROR\t%s\n", err)
+	}
+}
+
+func talkerCount(talkers []ingress.TalkerCount, id string) (uint64, bool) {
+	for _, t := range talkers {
+		if t.SourceID == id {
+			return t.Count, true
+		}
+	}
+
+	return 0, false
+}
+
+// rate returns the per second rate between two readings of a counter. A
+// counter that went down, such as after the agent restarted, has a rate of
+// zero.
+func rate(cur, prev uint64, d time.Duration) float64 {
+	if d <= 0 || cur < prev {
+		return 0
+	}
+
+	return float64(cur-prev) / d.Seconds()
+}
+
+func enabled(b bool) string {
+	if b {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func running(b bool) string {
+	if b {
+		return "running"
+	}
+	return "stopped"
+}
@@ -0,0 +1,83 @@
+package status_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/cmd/agent/status"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run", func() {
+	var (
+		adminServer  *httptest.Server
+		healthServer *httptest.Server
+	)
+
+	serveJSON := func(routes map[string]string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, ok := routes[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+	}
+
+	BeforeEach(func() {
+		adminServer = serveJSON(map[string]string{
+			"/buffer":      `{"type": "memory", "size": 10000, "depth": 2500, "dropped": 7, "recently_dropped": 1}`,
+			"/connections": `[{"connected": true, "addr": "10.0.0.1:8082", "writes": 3, "total_writes": 30}, {"connected": false, "total_writes": 5}]`,
+			"/sources/":    `[{"name": "grpc", "enabled": true, "running": true}]`,
+			"/top-talkers": `[{"source_id": "some-app", "count": 42}]`,
+		})
+		healthServer = serveJSON(map[string]string{
+			"/dopplers": `[{"addr": "10.0.0.1:8082", "state": "connected", "since": "2018-01-01T00:00:00Z", "streams": 1}]`,
+			"/ready":    `{"ready": false, "failures": {"doppler": "no streams"}}`,
+		})
+	})
+
+	AfterEach(func() {
+		adminServer.Close()
+		healthServer.Close()
+	})
+
+	It("renders every section once", func() {
+		var out bytes.Buffer
+		err := status.Run(status.Config{
+			AdminAddr:  strings.TrimPrefix(adminServer.URL, "http://"),
+			HealthAddr: strings.TrimPrefix(healthServer.URL, "http://"),
+			Interval:   time.Second,
+			Once:       true,
+		}, &out)
+		Expect(err).ToNot(HaveOccurred())
+
+		view := out.String()
+		Expect(view).ToNot(ContainSubstring("\033[2J"))
+		Expect(view).To(MatchRegexp(`READY\s+no`))
+		Expect(view).To(MatchRegexp(`doppler\s+no streams`))
+		Expect(view).To(MatchRegexp(`THROUGHPUT\s+-\s+35 batches total`))
+		Expect(view).To(MatchRegexp(`CONNECTIONS\s+1/2 connected`))
+		Expect(view).To(MatchRegexp(`BUFFER\s+memory\s+2500/10000 \(25.0%\)\s+dropped 7 \(1 recently\)`))
+		Expect(view).To(MatchRegexp(`10.0.0.1:8082\s+connected since 2018-01-01T00:00:00Z\s+1 streams`))
+		Expect(view).To(MatchRegexp(`grpc\s+enabled\s+running`))
+		Expect(view).To(MatchRegexp(`some-app\s+42 envelopes`))
+	})
+
+	It("reports sections that cannot be read", func() {
+		var out bytes.Buffer
+		err := status.Run(status.Config{
+			AdminAddr: strings.TrimPrefix(healthServer.URL, "http://"),
+			Once:      true,
+		}, &out)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(out.String()).To(MatchRegexp(`ERROR\s+/buffer: unexpected response \(404\)`))
+	})
+})
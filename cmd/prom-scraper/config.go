@@ -16,19 +16,29 @@ type config struct {
 
 	LoggregatorIngressAddr string        `env:"LOGGREGATOR_AGENT_ADDR, report, required"`
 	SourceID               string        `env:"SOURCE_ID, report, required"`
-	MetricsURL             *url.URL      `env:"METRICS_URL, report, required"`
+	MetricsURL             *url.URL      `env:"METRICS_URL, report"`
 	ScrapeInterval         time.Duration `env:"SCRAPE_INTERVAL, report"`
+
+	// ScrapeConfigPath is a YAML list of scrape targets. It is watched for
+	// changes so targets can be added and removed without a restart.
+	ScrapeConfigPath     string        `env:"SCRAPE_CONFIG_PATH, report"`
+	ScrapeConfigInterval time.Duration `env:"SCRAPE_CONFIG_INTERVAL, report"`
 }
 
 func loadConfig(log *log.Logger) config {
 	cfg := config{
-		ScrapeInterval: 15 * time.Second,
+		ScrapeInterval:       15 * time.Second,
+		ScrapeConfigInterval: 10 * time.Second,
 	}
 
 	if err := envstruct.Load(&cfg); err != nil {
 		log.Fatal(err)
 	}
 
+	if cfg.MetricsURL == nil && cfg.ScrapeConfigPath == "" {
+		log.Fatal("one of METRICS_URL or SCRAPE_CONFIG_PATH is required")
+	}
+
 	envstruct.WriteReport(&cfg)
 
 	return cfg
@@ -4,7 +4,6 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"time"
 
 	loggregator "code.cloudfoundry.org/go-loggregator"
 	"code.cloudfoundry.org/loggregator-agent/pkg/scraper"
@@ -35,16 +34,53 @@ func main() {
 		log.Fatal(err)
 	}
 
-	scraper := scraper.New(
+	manager := scraper.NewManager(
 		cfg.SourceID,
-		cfg.MetricsURL.String(),
+		cfg.ScrapeInterval,
 		client,
 		http.DefaultClient,
+		log,
 	)
+	defer manager.Stop()
 
-	for range time.Tick(cfg.ScrapeInterval) {
-		if err := scraper.Scrape(); err != nil {
-			log.Printf("failed to scrape: %s", err)
-		}
+	if cfg.ScrapeConfigPath == "" {
+		manager.Update([]scraper.Target{
+			{SourceID: cfg.SourceID, URL: cfg.MetricsURL.String()},
+		})
+
+		select {}
+	}
+
+	var staticTargets []scraper.Target
+	if cfg.MetricsURL != nil {
+		staticTargets = append(staticTargets, scraper.Target{
+			SourceID: cfg.SourceID,
+			URL:      cfg.MetricsURL.String(),
+		})
 	}
+
+	watcher := scraper.NewConfigWatcher(
+		cfg.ScrapeConfigPath,
+		cfg.ScrapeConfigInterval,
+		withStaticTargets(manager, staticTargets),
+		log,
+	)
+	watcher.Start()
+}
+
+type targetUpdaterFunc func(targets []scraper.Target)
+
+func (f targetUpdaterFunc) Update(targets []scraper.Target) {
+	f(targets)
+}
+
+// withStaticTargets ensures the targets given via METRICS_URL are always
+// scraped in addition to the targets from the scrape config.
+func withStaticTargets(u scraper.TargetUpdater, static []scraper.Target) scraper.TargetUpdater {
+	return targetUpdaterFunc(func(targets []scraper.Target) {
+		all := make([]scraper.Target, 0, len(targets)+len(static))
+		all = append(all, targets...)
+		all = append(all, static...)
+		u.Update(all)
+	})
 }
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"fmt"
+
+	envstruct "code.cloudfoundry.org/go-envstruct"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+)
+
+// Config stores all configurations options for the Syslog Agent.
+type Config struct {
+	// Addr is the address syslog is accepted on. Syslog is served over TLS
+	// when TLSCertFile and TLSKeyFile are set and over TCP otherwise.
+	Addr            string            `env:"SYSLOG_AGENT_ADDR"`
+	TLSCertFile     string            `env:"SYSLOG_AGENT_TLS_CERT_FILE"`
+	TLSKeyFile      string            `env:"SYSLOG_AGENT_TLS_KEY_FILE"`
+	DefaultSourceID string            `env:"SYSLOG_AGENT_DEFAULT_SOURCE_ID"`
+	BufferSize      int               `env:"SYSLOG_AGENT_BUFFER_SIZE"`
+	Tags            map[string]string `env:"SYSLOG_AGENT_TAGS"`
+
+	RouterAddr       string `env:"ROUTER_ADDR"`
+	RouterAddrWithAZ string `env:"ROUTER_ADDR_WITH_AZ"`
+
+	// The certificates are used to connect to Dopplers and to the
+	// Loggregator Agent at LoggregatorAgentAddr, which the Syslog Agent
+	// emits its own metrics to.
+	CAFile               string `env:"SYSLOG_AGENT_CA_FILE"`
+	CertFile             string `env:"SYSLOG_AGENT_CERT_FILE"`
+	KeyFile              string `env:"SYSLOG_AGENT_KEY_FILE"`
+	LoggregatorAgentAddr string `env:"LOGGREGATOR_AGENT_ADDR"`
+	MetricSourceID       string `env:"SYSLOG_AGENT_METRIC_SOURCE_ID"`
+
+	HealthEndpointPort uint16 `env:"SYSLOG_AGENT_HEALTH_ENDPOINT_PORT"`
+	LogFormat          string `env:"LOG_FORMAT"`
+	LogLevel           string `env:"LOG_LEVEL"`
+}
+
+// LoadConfig reads from the environment to create a Config.
+func LoadConfig() (*Config, error) {
+	config := Config{
+		Addr:                 "127.0.0.1:5514",
+		DefaultSourceID:      "syslog",
+		BufferSize:           10000,
+		LoggregatorAgentAddr: "127.0.0.1:3458",
+		MetricSourceID:       "syslog_agent",
+		HealthEndpointPort:   14825,
+		LogFormat:            logging.TextFormat,
+		LogLevel:             logging.InfoLevel.String(),
+	}
+	err := envstruct.Load(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.RouterAddr == "" {
+		return nil, fmt.Errorf("RouterAddr is required")
+	}
+
+	if config.CAFile == "" || config.CertFile == "" || config.KeyFile == "" {
+		return nil, fmt.Errorf("CAFile, CertFile and KeyFile are required")
+	}
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLSCertFile and TLSKeyFile must be set together")
+	}
+
+	if config.BufferSize <= 0 {
+		return nil, fmt.Errorf("BufferSize must be positive")
+	}
+
+	if config.LogFormat != logging.TextFormat && config.LogFormat != logging.JSONFormat {
+		return nil, fmt.Errorf("LogFormat must be %q or %q", logging.TextFormat, logging.JSONFormat)
+	}
+
+	if _, err := logging.ParseLevel(config.LogLevel); err != nil {
+		return nil, fmt.Errorf("LogLevel must be one of debug, info, warn or error")
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,256 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	clientpoolv2 "code.cloudfoundry.org/loggregator-agent/pkg/clientpool/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/healthendpoint"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+var logger = logging.New("syslog-agent")
+
+// MetricClient creates new CounterMetrics to be emitted periodically.
+type MetricClient interface {
+	NewCounterMetric(name string, opts ...pulseemitter.MetricOption) pulseemitter.CounterMetric
+}
+
+// SyslogAgentOption configures SyslogAgent options.
+type SyslogAgentOption func(*SyslogAgent)
+
+// WithLookup allows the default DNS resolver to be changed.
+func WithLookup(l func(string) ([]net.IP, error)) SyslogAgentOption {
+	return func(a *SyslogAgent) {
+		a.lookup = l
+	}
+}
+
+// SyslogAgent accepts syslog messages and forwards them to Dopplers as Log
+// envelopes with the same buffering and connection pool as the v2 Agent.
+type SyslogAgent struct {
+	config       *Config
+	clientCreds  credentials.TransportCredentials
+	serverTLS    *tls.Config
+	metricClient MetricClient
+	lookup       func(string) ([]net.IP, error)
+
+	mu           sync.Mutex
+	source       *ingress.SyslogSource
+	health       *healthendpoint.Registrar
+	connManagers []*clientpoolv2.ConnManager
+}
+
+// NewSyslogAgent returns a SyslogAgent that dials Dopplers with the client
+// credentials. Syslog is served over TLS with serverTLS unless it is nil.
+func NewSyslogAgent(
+	c *Config,
+	clientCreds credentials.TransportCredentials,
+	serverTLS *tls.Config,
+	metricClient MetricClient,
+	opts ...SyslogAgentOption,
+) *SyslogAgent {
+	a := &SyslogAgent{
+		config:       c,
+		clientCreds:  clientCreds,
+		serverTLS:    serverTLS,
+		metricClient: metricClient,
+		lookup:       net.LookupIP,
+	}
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a
+}
+
+// Start serves the health endpoint, connects to Dopplers and starts
+// accepting syslog. It does not block.
+func (a *SyslogAgent) Start() {
+	readiness := healthendpoint.NewReadiness()
+	destinations := healthendpoint.NewDestinations()
+	health := startHealthEndpoint(
+		fmt.Sprintf("127.0.0.1:%d", a.config.HealthEndpointPort),
+		readiness,
+		destinations,
+	)
+
+	droppedMetric := a.metricClient.NewCounterMetric("dropped",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"direction": "ingress"}),
+	)
+	drops := logger.Summarize(logging.WarnLevel, logging.SummaryInterval)
+	buffer := diodes.NewManyToOneEnvelopeV2(a.config.BufferSize, gendiodes.AlertFunc(func(missed int) {
+		// metric-documentation-v2: (loggregator.syslog_agent.dropped) Number
+		// of syslog messages dropped from the syslog agent ingress diode
+		droppedMetric.Increment(uint64(missed))
+
+		drops.Add("Dropped syslog messages", uint64(missed))
+	}))
+
+	a.mu.Lock()
+	a.health = health
+	a.mu.Unlock()
+
+	pool := a.initializePool()
+	destinations.Register("doppler_v2", func() interface{} {
+		return pool.Health()
+	})
+	readiness.Register("doppler", func() error {
+		if !clientpoolv2.DestinationsUp(pool.Health()) {
+			return fmt.Errorf("no streams to doppler are established")
+		}
+
+		return nil
+	})
+
+	var txOpts []egress.TransponderOption
+	if egress.BorrowsBatches(pool) {
+		txOpts = append(txOpts, egress.WithTransponderPooledBatches())
+	}
+	tx := egress.NewTransponder(
+		buffer,
+		pool,
+		a.config.Tags,
+		100, 100*time.Millisecond,
+		a.metricClient,
+		txOpts...,
+	)
+	go tx.Start()
+
+	opts := []ingress.SyslogSourceOption{
+		ingress.WithSyslogDefaultSourceID(a.config.DefaultSourceID),
+	}
+	if a.serverTLS != nil {
+		opts = append(opts, ingress.WithSyslogSourceTLSConfig(a.serverTLS))
+	}
+	source := ingress.NewSyslogSource(a.config.Addr, buffer, a.metricClient, opts...)
+
+	a.mu.Lock()
+	a.source = source
+	a.mu.Unlock()
+
+	go func() {
+		if err := source.Start(); err != nil {
+			logger.Fatalf("syslog source failed: %s", err)
+		}
+	}()
+}
+
+// Addr returns the address syslog is accepted on or nil if the agent is not
+// listening.
+func (a *SyslogAgent) Addr() net.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.source == nil {
+		return nil
+	}
+
+	return a.source.Addr()
+}
+
+// Stop stops accepting syslog and closes all syslog connections.
+func (a *SyslogAgent) Stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.source != nil {
+		a.source.Stop()
+	}
+}
+
+func (a *SyslogAgent) initializePool() *clientpoolv2.ClientPool {
+	balancers := make([]*clientpoolv2.Balancer, 0, 2)
+	if a.config.RouterAddrWithAZ != "" {
+		balancers = append(balancers, clientpoolv2.NewBalancer(
+			a.config.RouterAddrWithAZ,
+			clientpoolv2.WithLookup(a.lookup)),
+		)
+	}
+	balancers = append(balancers, clientpoolv2.NewBalancer(
+		a.config.RouterAddr,
+		clientpoolv2.WithLookup(a.lookup)),
+	)
+
+	kp := keepalive.ClientParameters{
+		Time:                15 * time.Second,
+		Timeout:             15 * time.Second,
+		PermitWithoutStream: true,
+	}
+	fetcher := clientpoolv2.NewSenderFetcher(
+		a.health,
+		grpc.WithTransportCredentials(a.clientCreds),
+		grpc.WithKeepaliveParams(kp),
+	)
+
+	connector := clientpoolv2.MakeGRPCConnector(fetcher, balancers)
+
+	var connManagers []clientpoolv2.Conn
+	for i := 0; i < 5; i++ {
+		m := clientpoolv2.NewConnManager(
+			connector,
+			100000+rand.Int63n(1000),
+			time.Second,
+		)
+		a.mu.Lock()
+		a.connManagers = append(a.connManagers, m)
+		a.mu.Unlock()
+		connManagers = append(connManagers, m)
+	}
+
+	return clientpoolv2.New(connManagers...)
+}
+
+func startHealthEndpoint(
+	addr string,
+	r *healthendpoint.Readiness,
+	d *healthendpoint.Destinations,
+) *healthendpoint.Registrar {
+	promRegistry := prometheus.NewRegistry()
+	healthRegistrar := healthendpoint.New(promRegistry, map[string]prometheus.Gauge{
+		// metric-documentation-health: (dopplerConnections)
+		// Number of connections open to dopplers.
+		"dopplerConnections": prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "loggregator",
+				Subsystem: "syslog_agent",
+				Name:      "dopplerConnections",
+				Help:      "Number of connections open to dopplers",
+			},
+		),
+		// metric-documentation-health: (dopplerV2Streams)
+		// Number of V2 gRPC streams to dopplers.
+		"dopplerV2Streams": prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "loggregator",
+				Subsystem: "syslog_agent",
+				Name:      "dopplerV2Streams",
+				Help:      "Number of V2 gRPC streams to dopplers",
+			},
+		),
+	})
+
+	healthendpoint.StartServer(
+		addr,
+		promRegistry,
+		healthendpoint.WithReadiness(r),
+		healthendpoint.WithDopplerStates(healthRegistrar),
+		healthendpoint.WithDestinations(d),
+	)
+
+	return healthRegistrar
+}
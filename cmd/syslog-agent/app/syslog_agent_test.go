@@ -0,0 +1,161 @@
+package app_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/cmd/syslog-agent/app"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SyslogAgent", func() {
+	var (
+		doppler *spyDoppler
+		agent   *app.SyslogAgent
+	)
+
+	BeforeEach(func() {
+		doppler = newSpyDoppler()
+
+		// The test certificates have no SANs, so the Doppler is not
+		// verified by name.
+		tlsConfig, err := plumbing.NewClientMutualTLSConfig(
+			testhelper.Cert("metron.crt"),
+			testhelper.Cert("metron.key"),
+			testhelper.Cert("loggregator-ca.crt"),
+			"doppler",
+		)
+		Expect(err).ToNot(HaveOccurred())
+		tlsConfig.InsecureSkipVerify = true
+
+		agent = app.NewSyslogAgent(
+			&app.Config{
+				Addr:            "127.0.0.1:0",
+				DefaultSourceID: "syslog",
+				BufferSize:      100,
+				Tags:            map[string]string{"deployment": "cf"},
+				RouterAddr:      doppler.addr,
+			},
+			credentials.NewTLS(tlsConfig),
+			nil,
+			testhelper.NewMetricClient(),
+		)
+		agent.Start()
+		Eventually(agent.Addr).ShouldNot(BeNil())
+	})
+
+	AfterEach(func() {
+		agent.Stop()
+		doppler.stop()
+	})
+
+	It("forwards syslog messages to Doppler as log envelopes", func() {
+		conn, err := net.Dial("tcp", agent.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		Eventually(func() []*loggregator_v2.Envelope {
+			fmt.Fprint(conn, "<14>1 - host some-app 0 - [tags@47450 job=\"router\"] hello\n")
+			return doppler.envelopes()
+		}).ShouldNot(BeEmpty())
+
+		e := doppler.envelopes()[0]
+		Expect(e.SourceId).To(Equal("some-app"))
+		Expect(e.InstanceId).To(Equal("0"))
+		Expect(e.Tags).To(HaveKeyWithValue("job", "router"))
+		Expect(e.Tags).To(HaveKeyWithValue("deployment", "cf"))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("hello")))
+	})
+})
+
+var _ = Describe("Config", func() {
+	It("requires a router address and certificates", func() {
+		_, err := app.LoadConfig()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for a TLS certificate without a key", func() {
+		os.Setenv("ROUTER_ADDR", "router-addr")
+		defer os.Unsetenv("ROUTER_ADDR")
+		os.Setenv("SYSLOG_AGENT_CA_FILE", "ca.crt")
+		defer os.Unsetenv("SYSLOG_AGENT_CA_FILE")
+		os.Setenv("SYSLOG_AGENT_CERT_FILE", "agent.crt")
+		defer os.Unsetenv("SYSLOG_AGENT_CERT_FILE")
+		os.Setenv("SYSLOG_AGENT_KEY_FILE", "agent.key")
+		defer os.Unsetenv("SYSLOG_AGENT_KEY_FILE")
+
+		cfg, err := app.LoadConfig()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Addr).To(Equal("127.0.0.1:5514"))
+
+		os.Setenv("SYSLOG_AGENT_TLS_CERT_FILE", "syslog.crt")
+		defer os.Unsetenv("SYSLOG_AGENT_TLS_CERT_FILE")
+
+		_, err = app.LoadConfig()
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+type spyDoppler struct {
+	loggregator_v2.IngressServer
+
+	addr   string
+	server *grpc.Server
+
+	mu   sync.Mutex
+	envs []*loggregator_v2.Envelope
+}
+
+func newSpyDoppler() *spyDoppler {
+	creds, err := plumbing.NewServerCredentials(
+		testhelper.Cert("router.crt"),
+		testhelper.Cert("router.key"),
+		testhelper.Cert("loggregator-ca.crt"),
+	)
+	Expect(err).ToNot(HaveOccurred())
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+
+	d := &spyDoppler{
+		addr:   lis.Addr().String(),
+		server: grpc.NewServer(grpc.Creds(creds)),
+	}
+	loggregator_v2.RegisterIngressServer(d.server, d)
+	go d.server.Serve(lis)
+
+	return d
+}
+
+func (d *spyDoppler) BatchSender(s loggregator_v2.Ingress_BatchSenderServer) error {
+	for {
+		b, err := s.Recv()
+		if err != nil {
+			return err
+		}
+
+		d.mu.Lock()
+		d.envs = append(d.envs, b.GetBatch()...)
+		d.mu.Unlock()
+	}
+}
+
+func (d *spyDoppler) envelopes() []*loggregator_v2.Envelope {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]*loggregator_v2.Envelope(nil), d.envs...)
+}
+
+func (d *spyDoppler) stop() {
+	d.server.Stop()
+}
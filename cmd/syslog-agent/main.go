@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"time"
+
+	loggregator "code.cloudfoundry.org/go-loggregator"
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/loggregator-agent/cmd/syslog-agent/app"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	"google.golang.org/grpc/grpclog"
+)
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+
+	rand.Seed(time.Now().UnixNano())
+	grpclog.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	config, err := app.LoadConfig()
+	if err != nil {
+		log.Fatalf("Unable to parse config: %s", err)
+	}
+
+	if err := logging.SetFormat(config.LogFormat); err != nil {
+		log.Fatalf("Unable to set log format: %s", err)
+	}
+
+	level, err := logging.ParseLevel(config.LogLevel)
+	if err != nil {
+		log.Fatalf("Unable to set log level: %s", err)
+	}
+	logging.SetLevel(level)
+
+	clientCreds, err := plumbing.NewClientCredentials(
+		config.CertFile,
+		config.KeyFile,
+		config.CAFile,
+		"doppler",
+	)
+	if err != nil {
+		log.Fatalf("Could not use GRPC creds for client: %s", err)
+	}
+
+	var serverTLS *tls.Config
+	if config.TLSCertFile != "" {
+		serverTLS, err = plumbing.NewServerTLSConfig(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("Could not use TLS config for syslog: %s", err)
+		}
+	}
+
+	ingressTLS, err := loggregator.NewIngressTLSConfig(
+		config.CAFile,
+		config.CertFile,
+		config.KeyFile,
+	)
+	if err != nil {
+		log.Fatalf("Could not use TLS config for metrics: %s", err)
+	}
+
+	ingressClient, err := loggregator.NewIngressClient(ingressTLS,
+		loggregator.WithTag("origin", "loggregator.syslog_agent"),
+		loggregator.WithAddr(config.LoggregatorAgentAddr),
+	)
+	if err != nil {
+		log.Fatalf("Failed to initialize ingress client: %s", err)
+	}
+
+	metricClient := pulseemitter.New(
+		ingressClient,
+		pulseemitter.WithPulseInterval(time.Minute),
+		pulseemitter.WithSourceID(config.MetricSourceID),
+	)
+
+	app.NewSyslogAgent(config, clientCreds, serverTLS, metricClient).Start()
+
+	select {}
+}
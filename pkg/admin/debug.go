@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// DebugOutput is where the agent writes a readable copy of the envelopes
+// it egresses.
+type DebugOutput interface {
+	Output() string
+	SetOutput(output string) error
+}
+
+type debugOutput struct {
+	Output string `json:"output"`
+}
+
+// NewDebugHandler returns a handler to be registered at /debug. A GET
+// returns the current debug output. A PUT with a body of
+// {"output": "stdout"} or {"output": "envelopes.log"}, a file in the
+// agent's debug directory, starts writing envelopes to the output and
+// {"output": ""} stops.
+func NewDebugHandler(d DebugOutput) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req debugOutput
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if err := d.SetOutput(req.Output); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if req.Output == "" {
+				logger.Printf("stopped debug output")
+			} else {
+				logger.Printf("writing debug output to %s", req.Output)
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, debugOutput{Output: d.Output()})
+	})
+}
@@ -0,0 +1,90 @@
+package admin_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DebugHandler", func() {
+	var (
+		output *spyDebugOutput
+		h      http.Handler
+	)
+
+	BeforeEach(func() {
+		output = &spyDebugOutput{}
+		h = admin.NewDebugHandler(output)
+	})
+
+	put := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/debug", strings.NewReader(body)))
+		return rec
+	}
+
+	It("returns the current output", func() {
+		output.output = "stdout"
+
+		rec := serve(h, http.MethodGet, "/debug")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": "stdout"}`))
+	})
+
+	It("sets the output", func() {
+		rec := put(`{"output": "envelopes.log"}`)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"output": "envelopes.log"}`))
+		Expect(output.output).To(Equal("envelopes.log"))
+	})
+
+	It("stops the output", func() {
+		output.output = "stdout"
+
+		rec := put(`{"output": ""}`)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(output.output).To(BeEmpty())
+	})
+
+	It("rejects an output that can not be set", func() {
+		output.err = errors.New("some-error")
+
+		rec := put(`{"output": "../envelopes.log"}`)
+
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects invalid JSON", func() {
+		Expect(put(`{`).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects other methods", func() {
+		Expect(serve(h, http.MethodPost, "/debug").Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
+
+type spyDebugOutput struct {
+	output string
+	err    error
+}
+
+func (s *spyDebugOutput) Output() string {
+	return s.output
+}
+
+func (s *spyDebugOutput) SetOutput(output string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.output = output
+	return nil
+}
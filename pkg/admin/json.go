@@ -0,0 +1,16 @@
+package admin
+
+import "net/http"
+
+// NewJSONHandler returns a handler that responds to a GET with the value
+// returned by f encoded as JSON. f is called for each request.
+func NewJSONHandler(f func() interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, f())
+	})
+}
@@ -0,0 +1,35 @@
+package admin_test
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSONHandler", func() {
+	It("writes the value as JSON for each request", func() {
+		var calls int
+		h := admin.NewJSONHandler(func() interface{} {
+			calls++
+			return map[string]int{"calls": calls}
+		})
+
+		serve(h, http.MethodGet, "/")
+		rec := serve(h, http.MethodGet, "/")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(rec.Body.String()).To(MatchJSON(`{"calls": 2}`))
+	})
+
+	It("only allows GET", func() {
+		h := admin.NewJSONHandler(func() interface{} { return nil })
+
+		rec := serve(h, http.MethodPost, "/")
+
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
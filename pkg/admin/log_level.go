@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+)
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// NewLogLevelHandler returns a handler to be registered at /log-level. A GET
+// returns the current log level. A PUT with a body of {"level": "debug"}
+// changes the log level for the whole process.
+func NewLogLevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req logLevel
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			lvl, err := logging.ParseLevel(req.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if lvl != logging.CurrentLevel() {
+				logger.Printf("changing log level from %s to %s", logging.CurrentLevel(), lvl)
+				logging.SetLevel(lvl)
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, logLevel{Level: logging.CurrentLevel().String()})
+	})
+}
@@ -0,0 +1,70 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LogLevelHandler", func() {
+	var h http.Handler
+
+	BeforeEach(func() {
+		h = admin.NewLogLevelHandler()
+	})
+
+	AfterEach(func() {
+		logging.SetLevel(logging.InfoLevel)
+	})
+
+	It("returns the current log level", func() {
+		logging.SetLevel(logging.WarnLevel)
+
+		rec := serve(h, http.MethodGet, "/log-level")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"level": "warn"}`))
+	})
+
+	It("changes the log level", func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/log-level", strings.NewReader(`{"level": "debug"}`))
+
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"level": "debug"}`))
+		Expect(logging.CurrentLevel()).To(Equal(logging.DebugLevel))
+	})
+
+	It("rejects an unknown log level", func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/log-level", strings.NewReader(`{"level": "loud"}`))
+
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(logging.CurrentLevel()).To(Equal(logging.InfoLevel))
+	})
+
+	It("rejects invalid JSON", func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/log-level", strings.NewReader(`{`))
+
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("does not allow other methods", func() {
+		rec := serve(h, http.MethodPost, "/log-level")
+
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
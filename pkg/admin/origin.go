@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// CheckOrigin returns an error if the request has an Origin header that is
+// not a localhost origin. The admin API only listens on localhost, so
+// requests without an Origin header, such as those from curl, are allowed,
+// while browsers, which always send one for cross-site requests, may only
+// make requests from pages served from localhost.
+func CheckOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("invalid origin %q: %s", origin, err)
+	}
+
+	host := u.Hostname()
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return fmt.Errorf("origin %q is not localhost", origin)
+}
@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/ratelimit"
+)
+
+// RateLimitStatus is the state of a single limiter as reported by the rate
+// limits handler.
+type RateLimitStatus struct {
+	Default ratelimit.Limit            `json:"default"`
+	Limits  map[string]ratelimit.Limit `json:"limits"`
+}
+
+// NewRateLimitsHandler returns a handler to be registered at /rate-limits/.
+// A GET of /rate-limits/ lists every limiter. A PUT of a limit such as
+// {"rate": 100, "burst": 200} to /rate-limits/<name> changes the named
+// limiter's default limit and a PUT to /rate-limits/<name>/<key> changes the
+// limit for a single key. A DELETE of /rate-limits/<name>/<key> returns the
+// key to the default limit.
+func NewRateLimitsHandler(r *ratelimit.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/rate-limits"), "/")
+		if path == "" {
+			if req.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			statuses := make(map[string]RateLimitStatus)
+			for _, name := range r.Names() {
+				l, _ := r.Get(name)
+				statuses[name] = rateLimitStatus(l)
+			}
+			writeJSON(w, statuses)
+			return
+		}
+
+		parts := strings.SplitN(path, "/", 2)
+		l, ok := r.Get(parts[0])
+		if !ok {
+			http.Error(w, "unknown rate limiter: "+parts[0], http.StatusNotFound)
+			return
+		}
+
+		var key string
+		if len(parts) == 2 {
+			key = parts[1]
+		}
+
+		switch req.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var limit ratelimit.Limit
+			if err := json.NewDecoder(req.Body).Decode(&limit); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := limit.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if key == "" {
+				logger.Printf("changing default rate limit of %s to %+v", parts[0], limit)
+				l.SetDefault(limit)
+				break
+			}
+
+			logger.Printf("changing rate limit of %s for %s to %+v", parts[0], key, limit)
+			l.SetLimit(key, limit)
+		case http.MethodDelete:
+			if key == "" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			logger.Printf("removing rate limit of %s for %s", parts[0], key)
+			l.RemoveLimit(key)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, rateLimitStatus(l))
+	})
+}
+
+func rateLimitStatus(l *ratelimit.Limiter) RateLimitStatus {
+	return RateLimitStatus{
+		Default: l.Default(),
+		Limits:  l.Limits(),
+	}
+}
@@ -0,0 +1,88 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+	"code.cloudfoundry.org/loggregator-agent/pkg/ratelimit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimitsHandler", func() {
+	var (
+		l *ratelimit.Limiter
+		h http.Handler
+	)
+
+	BeforeEach(func() {
+		l = ratelimit.NewLimiter(ratelimit.Limit{Rate: 10, Burst: 20})
+		r := ratelimit.NewRegistry()
+		r.Register("ingress", l)
+		h = admin.NewRateLimitsHandler(r)
+	})
+
+	put := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+
+		return rec
+	}
+
+	It("lists every limiter", func() {
+		l.SetLimit("some-app", ratelimit.Limit{Rate: 1, Burst: 1})
+
+		rec := serve(h, http.MethodGet, "/rate-limits/")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var statuses map[string]admin.RateLimitStatus
+		Expect(json.Unmarshal(rec.Body.Bytes(), &statuses)).To(Succeed())
+		Expect(statuses).To(Equal(map[string]admin.RateLimitStatus{
+			"ingress": {
+				Default: ratelimit.Limit{Rate: 10, Burst: 20},
+				Limits:  map[string]ratelimit.Limit{"some-app": {Rate: 1, Burst: 1}},
+			},
+		}))
+	})
+
+	It("changes the default limit", func() {
+		rec := put("/rate-limits/ingress", `{"rate": 5, "burst": 6}`)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(l.Default()).To(Equal(ratelimit.Limit{Rate: 5, Burst: 6}))
+	})
+
+	It("changes and removes the limit for a key", func() {
+		rec := put("/rate-limits/ingress/some-app", `{"rate": 1, "burst": 2}`)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(l.Limits()).To(HaveKeyWithValue("some-app", ratelimit.Limit{Rate: 1, Burst: 2}))
+
+		rec = serve(h, http.MethodDelete, "/rate-limits/ingress/some-app")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(l.Limits()).To(BeEmpty())
+	})
+
+	It("rejects an invalid limit", func() {
+		rec := put("/rate-limits/ingress", `{"rate": "fast"}`)
+
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(l.Default()).To(Equal(ratelimit.Limit{Rate: 10, Burst: 20}))
+	})
+
+	It("rejects a limit with a burst of less than one", func() {
+		rec := put("/rate-limits/ingress/some-app", `{"rate": 0.5, "burst": 0}`)
+
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(l.Limits()).To(BeEmpty())
+	})
+
+	It("returns not found for an unknown limiter", func() {
+		rec := put("/rate-limits/unknown", `{"rate": 1}`)
+
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,85 @@
+// Package admin provides the agent's localhost HTTP API for inspecting and
+// controlling the agent at runtime.
+package admin
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+)
+
+var logger = logging.New("admin")
+
+// Server serves admin handlers on a local address.
+type Server struct {
+	addr string
+	mux  *http.ServeMux
+
+	mu  sync.Mutex
+	lis net.Listener
+}
+
+// NewServer returns a Server that will listen on the given address.
+func NewServer(addr string) *Server {
+	return &Server{
+		addr: addr,
+		mux:  http.NewServeMux(),
+	}
+}
+
+// Handle registers the handler for the given pattern. Handlers may be
+// registered before or after the server is started.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// Start listens on the server's address and serves requests in a new
+// goroutine. If the server fails to listen the process will exit with a
+// status code of 1.
+func (s *Server) Start() {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		logger.Fatalf("Unable to setup admin endpoint (%s): %s", s.addr, err)
+	}
+	logger.Printf("admin bound to: %s", lis.Addr())
+
+	s.mu.Lock()
+	s.lis = lis
+	s.mu.Unlock()
+
+	server := http.Server{
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		Handler:      s.mux,
+	}
+
+	go func() {
+		logger.Printf("admin server closing: %s", server.Serve(lis))
+	}()
+}
+
+// Addr returns the address the server is listening on. It returns an empty
+// string if the server has not been started.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lis == nil {
+		return ""
+	}
+
+	return s.lis.Addr().String()
+}
+
+// Stop closes the server's listener.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lis != nil {
+		s.lis.Close()
+	}
+}
@@ -0,0 +1,48 @@
+package admin_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var s *admin.Server
+
+	BeforeEach(func() {
+		s = admin.NewServer("127.0.0.1:0")
+		s.Start()
+	})
+
+	AfterEach(func() {
+		s.Stop()
+	})
+
+	It("serves registered handlers", func() {
+		s.Handle("/some-path", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("some-response"))
+		}))
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/some-path", s.Addr()))
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(string(body)).To(Equal("some-response"))
+	})
+
+	It("returns not found for unregistered paths", func() {
+		resp, err := http.Get(fmt.Sprintf("http://%s/unknown", s.Addr()))
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	})
+})
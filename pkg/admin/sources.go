@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// SourceController enables, disables and restarts named ingress sources.
+type SourceController interface {
+	Sources() []string
+	Running(name string) bool
+	Enabled(name string) bool
+	Enable(name string) error
+	Disable(name string) error
+	Restart(name string) error
+}
+
+// SourceStatus is the state of a single source as reported by the sources
+// handler.
+type SourceStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Running bool   `json:"running"`
+}
+
+// NewSourcesHandler returns a handler to be registered at /sources/. A GET of
+// /sources/ lists every source. A POST to /sources/<name>/enable,
+// /sources/<name>/disable or /sources/<name>/restart changes the state of
+// the named source, unless the request comes from a page that is not served
+// from localhost.
+func NewSourcesHandler(c SourceController) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/sources"), "/")
+		if path == "" {
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			statuses := []SourceStatus{}
+			for _, name := range c.Sources() {
+				statuses = append(statuses, sourceStatus(c, name))
+			}
+			writeJSON(w, statuses)
+			return
+		}
+
+		parts := strings.Split(path, "/")
+		if len(parts) != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := CheckOrigin(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		name, action := parts[0], parts[1]
+		var err error
+		switch action {
+		case "enable":
+			err = c.Enable(name)
+		case "disable":
+			err = c.Disable(name)
+		case "restart":
+			err = c.Restart(name)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		logger.Printf("%s source %s", action, name)
+		writeJSON(w, sourceStatus(c, name))
+	})
+}
+
+func sourceStatus(c SourceController, name string) SourceStatus {
+	return SourceStatus{
+		Name:    name,
+		Enabled: c.Enabled(name),
+		Running: c.Running(name),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Printf("failed to write admin response: %s", err)
+	}
+}
@@ -0,0 +1,166 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourcesHandler", func() {
+	var (
+		c *spySourceController
+		h http.Handler
+	)
+
+	BeforeEach(func() {
+		c = newSpySourceController("grpc", "udp")
+		h = admin.NewSourcesHandler(c)
+	})
+
+	It("lists every source", func() {
+		c.enabled["udp"] = false
+
+		rec := serve(h, http.MethodGet, "/sources/")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var statuses []admin.SourceStatus
+		Expect(json.Unmarshal(rec.Body.Bytes(), &statuses)).To(Succeed())
+		Expect(statuses).To(Equal([]admin.SourceStatus{
+			{Name: "grpc", Enabled: true, Running: true},
+			{Name: "udp", Enabled: false, Running: false},
+		}))
+	})
+
+	It("disables a source", func() {
+		rec := serve(h, http.MethodPost, "/sources/grpc/disable")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(c.calls).To(Equal([]string{"disable grpc"}))
+
+		var status admin.SourceStatus
+		Expect(json.Unmarshal(rec.Body.Bytes(), &status)).To(Succeed())
+		Expect(status).To(Equal(admin.SourceStatus{Name: "grpc"}))
+	})
+
+	It("enables a source", func() {
+		c.enabled["udp"] = false
+
+		rec := serve(h, http.MethodPost, "/sources/udp/enable")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(c.calls).To(Equal([]string{"enable udp"}))
+	})
+
+	It("restarts a source", func() {
+		rec := serve(h, http.MethodPost, "/sources/grpc/restart")
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(c.calls).To(Equal([]string{"restart grpc"}))
+	})
+
+	It("returns not found for an unknown source", func() {
+		rec := serve(h, http.MethodPost, "/sources/unknown/disable")
+
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("returns not found for an unknown action", func() {
+		rec := serve(h, http.MethodPost, "/sources/grpc/explode")
+
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+		Expect(c.calls).To(BeEmpty())
+	})
+
+	It("allows changes from localhost origins", func() {
+		req := httptest.NewRequest(http.MethodPost, "/sources/grpc/disable", nil)
+		req.Header.Set("Origin", "http://127.0.0.1:8080")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(c.calls).To(Equal([]string{"disable grpc"}))
+	})
+
+	It("forbids changes from other origins", func() {
+		req := httptest.NewRequest(http.MethodPost, "/sources/grpc/disable", nil)
+		req.Header.Set("Origin", "https://evil.example.com")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+		Expect(c.calls).To(BeEmpty())
+	})
+
+	It("only allows changes via POST", func() {
+		rec := serve(h, http.MethodGet, "/sources/grpc/disable")
+
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(c.calls).To(BeEmpty())
+	})
+})
+
+func serve(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+
+	return rec
+}
+
+type spySourceController struct {
+	names   []string
+	enabled map[string]bool
+	calls   []string
+}
+
+func newSpySourceController(names ...string) *spySourceController {
+	enabled := make(map[string]bool)
+	for _, n := range names {
+		enabled[n] = true
+	}
+
+	return &spySourceController{
+		names:   names,
+		enabled: enabled,
+	}
+}
+
+func (s *spySourceController) Sources() []string {
+	return s.names
+}
+
+func (s *spySourceController) Running(name string) bool {
+	return s.enabled[name]
+}
+
+func (s *spySourceController) Enabled(name string) bool {
+	return s.enabled[name]
+}
+
+func (s *spySourceController) Enable(name string) error {
+	return s.set("enable", name, true)
+}
+
+func (s *spySourceController) Disable(name string) error {
+	return s.set("disable", name, false)
+}
+
+func (s *spySourceController) Restart(name string) error {
+	return s.set("restart", name, true)
+}
+
+func (s *spySourceController) set(action, name string, enabled bool) error {
+	if _, ok := s.enabled[name]; !ok {
+		return errors.New("unknown source")
+	}
+
+	s.calls = append(s.calls, action+" "+name)
+	s.enabled[name] = enabled
+
+	return nil
+}
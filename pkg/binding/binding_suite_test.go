@@ -0,0 +1,13 @@
+package binding_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestBinding(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Binding Suite")
+}
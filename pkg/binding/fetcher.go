@@ -0,0 +1,130 @@
+// Package binding fetches the syslog drains bound to applications from a
+// bindings provider.
+package binding
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// Binding is a syslog drain bound to an application.
+type Binding struct {
+	AppID    string
+	Hostname string
+	Drain    string
+}
+
+// Doer performs HTTP requests.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Fetcher reads bindings from a bindings provider. The provider serves
+// pages of bindings at /bindings, each naming the ID to request the next
+// page with. A next ID of 0 marks the last page.
+type Fetcher struct {
+	addr      string
+	batchSize int
+	doer      Doer
+}
+
+// NewFetcher returns a Fetcher for the provider at the given address,
+// requesting pages of batchSize applications.
+func NewFetcher(addr string, batchSize int, d Doer) *Fetcher {
+	return &Fetcher{
+		addr:      addr,
+		batchSize: batchSize,
+		doer:      d,
+	}
+}
+
+type bindingsResponse struct {
+	Results map[string]struct {
+		Drains   []string `json:"drains"`
+		Hostname string   `json:"hostname"`
+	} `json:"results"`
+	NextID int `json:"next_id"`
+}
+
+// FetchBindings returns every binding, ordered by application ID and drain.
+// An error is returned if any page fails to be read.
+func (f *Fetcher) FetchBindings() ([]Binding, error) {
+	var (
+		bindings []Binding
+		nextID   int
+	)
+	for {
+		resp, err := f.fetchPage(nextID)
+		if err != nil {
+			return nil, err
+		}
+
+		for appID, r := range resp.Results {
+			for _, d := range r.Drains {
+				bindings = append(bindings, Binding{
+					AppID:    appID,
+					Hostname: r.Hostname,
+					Drain:    d,
+				})
+			}
+		}
+
+		if resp.NextID == 0 {
+			break
+		}
+		nextID = resp.NextID
+	}
+
+	sort.Slice(bindings, func(i, j int) bool {
+		if bindings[i].AppID != bindings[j].AppID {
+			return bindings[i].AppID < bindings[j].AppID
+		}
+		return bindings[i].Drain < bindings[j].Drain
+	})
+
+	return bindings, nil
+}
+
+func (f *Fetcher) fetchPage(nextID int) (*bindingsResponse, error) {
+	u, err := url.Parse(f.addr)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/bindings"
+	u.RawQuery = url.Values{
+		"batch_size": {strconv.Itoa(f.batchSize)},
+		"next_id":    {strconv.Itoa(nextID)},
+	}.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var r bindingsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,95 @@
+package binding_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/binding"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fetcher", func() {
+	var (
+		doer    *spyDoer
+		fetcher *binding.Fetcher
+	)
+
+	BeforeEach(func() {
+		doer = &spyDoer{}
+		fetcher = binding.NewFetcher("https://bindings.example.com:8080", 2, doer)
+	})
+
+	It("fetches every page of bindings", func() {
+		doer.bodies = []string{
+			`{"results": {"app-b": {"drains": ["syslog://b:514"], "hostname": "org.space.b"}}, "next_id": 2}`,
+			`{"results": {"app-a": {"drains": ["syslog://a2:514", "syslog://a1:514"], "hostname": "org.space.a"}}, "next_id": 0}`,
+		}
+
+		bindings, err := fetcher.FetchBindings()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(bindings).To(Equal([]binding.Binding{
+			{AppID: "app-a", Hostname: "org.space.a", Drain: "syslog://a1:514"},
+			{AppID: "app-a", Hostname: "org.space.a", Drain: "syslog://a2:514"},
+			{AppID: "app-b", Hostname: "org.space.b", Drain: "syslog://b:514"},
+		}))
+
+		Expect(doer.urls).To(Equal([]string{
+			"https://bindings.example.com:8080/bindings?batch_size=2&next_id=0",
+			"https://bindings.example.com:8080/bindings?batch_size=2&next_id=2",
+		}))
+	})
+
+	It("returns an error when a request fails", func() {
+		doer.err = errors.New("some-error")
+
+		_, err := fetcher.FetchBindings()
+		Expect(err).To(MatchError("some-error"))
+	})
+
+	It("returns an error for an unexpected status code", func() {
+		doer.status = http.StatusInternalServerError
+		doer.bodies = []string{"{}"}
+
+		_, err := fetcher.FetchBindings()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for a malformed response", func() {
+		doer.bodies = []string{"not-json"}
+
+		_, err := fetcher.FetchBindings()
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+type spyDoer struct {
+	bodies []string
+	status int
+	err    error
+	urls   []string
+}
+
+func (d *spyDoer) Do(req *http.Request) (*http.Response, error) {
+	d.urls = append(d.urls, req.URL.String())
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	status := d.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	body := d.bodies[0]
+	d.bodies = d.bodies[1:]
+
+	return &http.Response{
+		StatusCode: status,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}, nil
+}
@@ -14,7 +14,7 @@ import (
 // HandleRPC for OutPayload messages.
 // It should be constructed with NewStatsHandler.
 type StatsHandler struct {
-	tracker Tracker
+	trackers []Tracker
 }
 
 // Tracker is used to aggregate each envelope's size in bytes.
@@ -24,10 +24,11 @@ type Tracker interface {
 	Track(count, size int)
 }
 
-// NewStatsHandler constructs a new StatsHandler.
-func NewStatsHandler(tracker Tracker) *StatsHandler {
+// NewStatsHandler constructs a new StatsHandler that reports egress to
+// each of the given trackers.
+func NewStatsHandler(trackers ...Tracker) *StatsHandler {
 	return &StatsHandler{
-		tracker: tracker,
+		trackers: trackers,
 	}
 }
 
@@ -40,13 +41,20 @@ func (s *StatsHandler) HandleRPC(ctx context.Context, stat stats.RPCStats) {
 		return
 	}
 
+	var count, size int
 	switch v := out.Payload.(type) {
 	case *v1.EnvelopeData:
-		s.tracker.Track(1, len(v.Payload))
+		count, size = 1, len(v.Payload)
 	case *loggregator_v2.Envelope:
-		s.tracker.Track(1, out.Length)
+		count, size = 1, out.Length
 	case *loggregator_v2.EnvelopeBatch:
-		s.tracker.Track(len(v.Batch), out.Length)
+		count, size = len(v.Batch), out.Length
+	default:
+		return
+	}
+
+	for _, t := range s.trackers {
+		t.Track(count, size)
 	}
 }
 
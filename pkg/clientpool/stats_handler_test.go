@@ -71,6 +71,19 @@ var _ = Describe("StatsHandler", func() {
 		})
 	})
 
+	It("reports to every tracker", func() {
+		other := &spyTracker{}
+		handler = clientpool.NewStatsHandler(tracker, other)
+
+		handler.HandleRPC(context.Background(), &stats.OutPayload{
+			Payload: &loggregator_v2.Envelope{},
+			Length:  99,
+		})
+
+		Expect(tracker.lastSize).To(Equal(99))
+		Expect(other.lastSize).To(Equal(99))
+	})
+
 	Describe("unknown payload types", func() {
 		It("ignores non OutPayload messages", func() {
 			f := func() {
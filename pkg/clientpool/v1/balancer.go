@@ -4,14 +4,35 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"strings"
+	"sync/atomic"
 )
 
 // Balancer provides IPs resolved from a DNS address in random order
 type Balancer struct {
+	next   uint64
+	static []string
+
 	addr   string
+	family IPFamily
 	lookup func(string) ([]net.IP, error)
 }
 
+// IPFamily is the IP version a Balancer prefers when an address resolves to
+// both IPv4 and IPv6 addresses.
+type IPFamily int
+
+const (
+	// IPFamilyDual uses IPv4 and IPv6 addresses alike.
+	IPFamilyDual IPFamily = iota
+
+	// IPFamilyV4 uses IPv4 addresses unless there are only IPv6 addresses.
+	IPFamilyV4
+
+	// IPFamilyV6 uses IPv6 addresses unless there are only IPv4 addresses.
+	IPFamilyV6
+)
+
 // BalancerOption is a type that will manipulate a config
 type BalancerOption func(*Balancer)
 
@@ -22,6 +43,14 @@ func WithLookup(lookup func(string) ([]net.IP, error)) func(*Balancer) {
 	}
 }
 
+// WithIPFamily sets the IP version that is preferred. It defaults to
+// IPFamilyDual.
+func WithIPFamily(f IPFamily) func(*Balancer) {
+	return func(b *Balancer) {
+		b.family = f
+	}
+}
+
 // NewBalancer returns a Balancer
 func NewBalancer(addr string, opts ...BalancerOption) *Balancer {
 	balancer := &Balancer{
@@ -36,10 +65,24 @@ func NewBalancer(addr string, opts ...BalancerOption) *Balancer {
 	return balancer
 }
 
+// NewStaticBalancer returns a Balancer that provides the given host:port
+// entries in turn without resolving them.
+func NewStaticBalancer(hostPorts []string) *Balancer {
+	return &Balancer{
+		addr:   strings.Join(hostPorts, ","),
+		static: hostPorts,
+	}
+}
+
 // NextHostPort returns hostport resolved from the balancer's addr.
 // It returns error for an invalid addr or if lookup failed or
 // doesn't resolve to anything.
 func (b *Balancer) NextHostPort() (string, error) {
+	if len(b.static) > 0 {
+		i := atomic.AddUint64(&b.next, 1) - 1
+		return b.static[i%uint64(len(b.static))], nil
+	}
+
 	host, port, err := net.SplitHostPort(b.addr)
 	if err != nil {
 		return "", err
@@ -49,6 +92,7 @@ func (b *Balancer) NextHostPort() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	ips = b.preferred(ips)
 
 	if len(ips) == 0 {
 		return "", fmt.Errorf("lookup failed with addr %s", b.addr)
@@ -57,3 +101,24 @@ func (b *Balancer) NextHostPort() (string, error) {
 	return net.JoinHostPort(ips[rand.Int()%len(ips)].String(), port), nil
 
 }
+
+// preferred returns the IPs of the preferred family or all of the IPs if
+// there are none of that family.
+func (b *Balancer) preferred(ips []net.IP) []net.IP {
+	if b.family == IPFamilyDual {
+		return ips
+	}
+
+	var matched []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (b.family == IPFamilyV4) {
+			matched = append(matched, ip)
+		}
+	}
+
+	if len(matched) == 0 {
+		return ips
+	}
+
+	return matched
+}
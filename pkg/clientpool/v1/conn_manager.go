@@ -3,14 +3,16 @@ package v1
 import (
 	"errors"
 	"io"
-	"log"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
 	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
 )
 
+var logger = logging.New("clientpool")
+
 type Connector interface {
 	Connect() (io.Closer, plumbing.DopplerIngestor_PusherClient, error)
 }
@@ -62,7 +64,7 @@ func (m *ConnManager) Write(data []byte) error {
 	}
 
 	if atomic.AddInt64(&gRPCConn.writes, 1) >= m.maxWrites {
-		log.Printf("recycling connection to doppler after %d writes", m.maxWrites)
+		logger.With(logging.Fields{"count": m.maxWrites}).Debugf("recycling connection to doppler after %d writes", m.maxWrites)
 		if !atomic.CompareAndSwapPointer(&m.conn, conn, nil) {
 			return nil
 		}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
 
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
 	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
 
 	"google.golang.org/grpc"
@@ -14,6 +14,9 @@ import (
 type HealthRegistrar interface {
 	Inc(name string)
 	Dec(name string)
+	StreamOpened(addr string)
+	StreamClosed(addr string)
+	StreamFailed(addr string, err error)
 }
 
 type PusherFetcher struct {
@@ -29,9 +32,14 @@ func NewPusherFetcher(r HealthRegistrar, opts ...grpc.DialOption) *PusherFetcher
 }
 
 func (p *PusherFetcher) Fetch(addr string) (io.Closer, plumbing.DopplerIngestor_PusherClient, error) {
+	l := logger.With(logging.Fields{"destination": addr})
+	l.Debugf("dialing doppler %s", addr)
+
 	conn, err := grpc.Dial(addr, p.opts...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error dialing ingestor stream to %s: %s", addr, err)
+		err = fmt.Errorf("error dialing ingestor stream to %s: %s", addr, err)
+		p.health.StreamFailed(addr, err)
+		return nil, nil, err
 	}
 	p.health.Inc("dopplerConnections")
 
@@ -41,13 +49,17 @@ func (p *PusherFetcher) Fetch(addr string) (io.Closer, plumbing.DopplerIngestor_
 	if err != nil {
 		p.health.Dec("dopplerConnections")
 		conn.Close()
-		return nil, nil, fmt.Errorf("error establishing ingestor stream to %s: %s", addr, err)
+		err = fmt.Errorf("error establishing ingestor stream to %s: %s", addr, err)
+		p.health.StreamFailed(addr, err)
+		return nil, nil, err
 	}
 	p.health.Inc("dopplerV1Streams")
+	p.health.StreamOpened(addr)
 
-	log.Printf("successfully established a stream to doppler %s", addr)
+	l.Debugf("successfully established a stream to doppler %s", addr)
 
 	closer := &decrementingCloser{
+		addr:   addr,
 		closer: conn,
 		health: p.health,
 	}
@@ -55,6 +67,7 @@ func (p *PusherFetcher) Fetch(addr string) (io.Closer, plumbing.DopplerIngestor_
 }
 
 type decrementingCloser struct {
+	addr   string
 	closer io.Closer
 	health HealthRegistrar
 }
@@ -62,6 +75,7 @@ type decrementingCloser struct {
 func (d *decrementingCloser) Close() error {
 	d.health.Dec("dopplerConnections")
 	d.health.Dec("dopplerV1Streams")
+	d.health.StreamClosed(d.addr)
 
 	return d.closer.Close()
 }
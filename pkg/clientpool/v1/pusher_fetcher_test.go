@@ -54,6 +54,7 @@ var _ = Describe("PusherFetcher", func() {
 
 		Expect(registry.GetValue("dopplerConnections")).To(Equal(int64(1)))
 		Expect(registry.GetValue("dopplerV1Streams")).To(Equal(int64(1)))
+		Expect(registry.streams).To(HaveKeyWithValue(server.addr, 1))
 	})
 
 	It("decrements a counter when a connection is closed", func() {
@@ -75,25 +76,44 @@ var _ = Describe("PusherFetcher", func() {
 		closer.Close()
 		Expect(registry.GetValue("dopplerConnections")).To(Equal(int64(0)))
 		Expect(registry.GetValue("dopplerV1Streams")).To(Equal(int64(0)))
+		Expect(registry.streams).To(HaveKeyWithValue(server.addr, 0))
 	})
 
 	It("returns an error when the server is unavailable", func() {
-		fetcher := v1.NewPusherFetcher(newSpyRegistry(), grpc.WithInsecure())
+		registry := newSpyRegistry()
+		fetcher := v1.NewPusherFetcher(registry, grpc.WithInsecure())
 		_, _, err := fetcher.Fetch("127.0.0.1:1122")
 		Expect(err).To(HaveOccurred())
+		Expect(registry.failures).To(HaveKey("127.0.0.1:1122"))
 	})
 })
 
 type SpyRegistry struct {
 	counters map[string]int64
+	streams  map[string]int
+	failures map[string]error
 }
 
 func newSpyRegistry() *SpyRegistry {
 	return &SpyRegistry{
 		counters: make(map[string]int64),
+		streams:  make(map[string]int),
+		failures: make(map[string]error),
 	}
 }
 
+func (s *SpyRegistry) StreamOpened(addr string) {
+	s.streams[addr]++
+}
+
+func (s *SpyRegistry) StreamClosed(addr string) {
+	s.streams[addr]--
+}
+
+func (s *SpyRegistry) StreamFailed(addr string, err error) {
+	s.failures[addr] = err
+}
+
 func (s *SpyRegistry) Inc(name string) {
 	s.counters[name] += 1
 }
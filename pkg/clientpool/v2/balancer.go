@@ -4,14 +4,50 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// minResolveInterval bounds how often Watch resolves an addr whose records
+// have a short or zero TTL.
+const minResolveInterval = time.Second
+
 // Balancer provides IPs resolved from a DNS address in random order
 type Balancer struct {
-	addr   string
-	lookup func(string) ([]net.IP, error)
+	next       uint64
+	static     []string
+	discoverer Discoverer
+
+	addr      string
+	family    IPFamily
+	query     BOSHDNSQuery
+	lookup    func(string) ([]net.IP, error)
+	ttlLookup func(string) ([]net.IP, time.Duration, error)
 }
 
+// Discoverer provides the host:port addresses of the healthy instances of a
+// service from a service discovery backend such as Consul.
+type Discoverer interface {
+	Discover() ([]string, error)
+}
+
+// IPFamily is the IP version a Balancer prefers when an address resolves to
+// both IPv4 and IPv6 addresses.
+type IPFamily int
+
+const (
+	// IPFamilyDual uses IPv4 and IPv6 addresses alike.
+	IPFamilyDual IPFamily = iota
+
+	// IPFamilyV4 uses IPv4 addresses unless there are only IPv6 addresses.
+	IPFamilyV4
+
+	// IPFamilyV6 uses IPv6 addresses unless there are only IPv4 addresses.
+	IPFamilyV6
+)
+
 // BalancerOption is a type that will manipulate a config
 type BalancerOption func(*Balancer)
 
@@ -22,6 +58,36 @@ func WithLookup(lookup func(string) ([]net.IP, error)) func(*Balancer) {
 	}
 }
 
+// WithTTLLookup sets the behavior of looking up IPs with a lookup that also
+// returns how long the IPs may be cached for. Watch resolves the addr again
+// when they expire.
+func WithTTLLookup(lookup func(string) ([]net.IP, time.Duration, error)) func(*Balancer) {
+	return func(b *Balancer) {
+		b.ttlLookup = lookup
+		b.lookup = func(host string) ([]net.IP, error) {
+			ips, _, err := lookup(host)
+			return ips, err
+		}
+	}
+}
+
+// WithIPFamily sets the IP version that is preferred. It defaults to
+// IPFamilyDual.
+func WithIPFamily(f IPFamily) func(*Balancer) {
+	return func(b *Balancer) {
+		b.family = f
+	}
+}
+
+// WithBOSHDNSQuery encodes the BOSH DNS filters into the addr's host when
+// it is resolved, so that, for instance, only instances reporting healthy
+// are connected to.
+func WithBOSHDNSQuery(q BOSHDNSQuery) func(*Balancer) {
+	return func(b *Balancer) {
+		b.query = q
+	}
+}
+
 // NewBalancer returns a Balancer
 func NewBalancer(addr string, opts ...BalancerOption) *Balancer {
 	balancer := &Balancer{
@@ -36,24 +102,219 @@ func NewBalancer(addr string, opts ...BalancerOption) *Balancer {
 	return balancer
 }
 
+// NewStaticBalancer returns a Balancer that provides the given host:port
+// entries in turn without resolving them.
+func NewStaticBalancer(hostPorts []string) *Balancer {
+	return &Balancer{
+		addr:   strings.Join(hostPorts, ","),
+		static: hostPorts,
+	}
+}
+
+// NewDiscoveryBalancer returns a Balancer that provides the addresses found
+// by the discoverer in random order. The discoverer is asked for them each
+// time an address is needed.
+func NewDiscoveryBalancer(d Discoverer) *Balancer {
+	addr := "discovery"
+	if s, ok := d.(fmt.Stringer); ok {
+		addr = s.String()
+	}
+
+	return &Balancer{
+		addr:       addr,
+		discoverer: d,
+	}
+}
+
 // NextHostPort returns hostport resolved from the balancer's addr.
 // It returns error for an invalid addr or if lookup failed or
 // doesn't resolve to anything.
 func (b *Balancer) NextHostPort() (string, error) {
+	if len(b.static) > 0 {
+		i := atomic.AddUint64(&b.next, 1) - 1
+		return b.static[i%uint64(len(b.static))], nil
+	}
+
+	if b.discoverer != nil {
+		hostPorts, err := b.discoverer.Discover()
+		if err != nil {
+			return "", err
+		}
+		if len(hostPorts) == 0 {
+			return "", fmt.Errorf("discovery found no instances with addr %s", b.addr)
+		}
+
+		return hostPorts[rand.Int()%len(hostPorts)], nil
+	}
+
 	host, port, err := net.SplitHostPort(b.addr)
 	if err != nil {
 		return "", err
 	}
 
-	ips, err := b.lookup(host)
+	ips, err := b.lookup(b.query.apply(host))
 	if err != nil {
 		return "", err
 	}
+	ips = b.preferred(ips)
 
 	if len(ips) == 0 {
 		return "", fmt.Errorf("lookup failed with addr %s", b.addr)
 	}
 
 	return net.JoinHostPort(ips[rand.Int()%len(ips)].String(), port), nil
+}
+
+// Watch resolves the balancer's addr every interval and calls onChange with
+// the IPs whenever they differ from the previous resolution. If the lookup
+// reports TTLs, the addr is resolved again when the records expire when that
+// is sooner than the interval or the interval is zero. Watch returns
+// immediately for a static balancer or if there is neither an interval nor a
+// TTL lookup and otherwise blocks until done is closed.
+//
+// A discovery balancer asks its discoverer for the addresses every interval
+// instead and calls onChange with the IPs of the addresses whenever they
+// differ.
+func (b *Balancer) Watch(interval time.Duration, done <-chan struct{}, onChange func([]net.IP)) {
+	if b.discoverer != nil {
+		b.watchDiscovery(interval, done, onChange)
+		return
+	}
+
+	if len(b.static) > 0 || (interval <= 0 && b.ttlLookup == nil) {
+		return
+	}
+
+	host, _, err := net.SplitHostPort(b.addr)
+	if err != nil {
+		return
+	}
+
+	var last []string
+	for {
+		ips, ttl, err := b.resolve(host)
+		if err == nil && len(ips) > 0 {
+			current := sortedIPs(ips)
+			if last != nil && !equalIPs(last, current) {
+				onChange(ips)
+			}
+			last = current
+		}
+
+		wait := interval
+		if b.ttlLookup != nil && (wait <= 0 || ttl < wait) {
+			wait = ttl
+		}
+		if wait < minResolveInterval {
+			wait = minResolveInterval
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-done:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (b *Balancer) watchDiscovery(interval time.Duration, done <-chan struct{}, onChange func([]net.IP)) {
+	if interval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var last []string
+	for {
+		hostPorts, err := b.discoverer.Discover()
+		if err == nil && len(hostPorts) > 0 {
+			current := append([]string(nil), hostPorts...)
+			sort.Strings(current)
+			if last != nil && !equalIPs(last, current) {
+				onChange(hostIPs(current))
+			}
+			last = current
+		}
+
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// hostIPs returns the IPs of the hosts of the host:port addresses. Hosts
+// that are names rather than IPs are skipped.
+func hostIPs(hostPorts []string) []net.IP {
+	var ips []net.IP
+	for _, hp := range hostPorts {
+		host, _, err := net.SplitHostPort(hp)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+func (b *Balancer) resolve(host string) ([]net.IP, time.Duration, error) {
+	host = b.query.apply(host)
+	if b.ttlLookup != nil {
+		ips, ttl, err := b.ttlLookup(host)
+		return b.preferred(ips), ttl, err
+	}
+
+	ips, err := b.lookup(host)
+	return b.preferred(ips), 0, err
+}
+
+func sortedIPs(ips []net.IP) []string {
+	s := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	sort.Strings(s)
+
+	return s
+}
+
+func equalIPs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// preferred returns the IPs of the preferred family or all of the IPs if
+// there are none of that family.
+func (b *Balancer) preferred(ips []net.IP) []net.IP {
+	if b.family == IPFamilyDual {
+		return ips
+	}
+
+	var matched []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (b.family == IPFamilyV4) {
+			matched = append(matched, ip)
+		}
+	}
+
+	if len(matched) == 0 {
+		return ips
+	}
 
+	return matched
 }
@@ -2,8 +2,11 @@ package v2_test
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"net"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/loggregator-agent/pkg/clientpool/v2"
 
@@ -69,4 +72,281 @@ var _ = Describe("Balancer", func() {
 		_, err := balancer.NextHostPort()
 		Expect(err).To(HaveOccurred())
 	})
+
+	It("notifies when the resolved IPs change", func() {
+		var (
+			mu      sync.Mutex
+			lookups int
+		)
+		f := func(addr string) ([]net.IP, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			lookups++
+			if lookups == 1 {
+				return []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}, nil
+			}
+			return []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}, nil
+		}
+		balancer := v2.NewBalancer("some-addr:8082", v2.WithLookup(f))
+
+		changes := make(chan []net.IP, 10)
+		done := make(chan struct{})
+		defer close(done)
+		go balancer.Watch(time.Millisecond, done, func(ips []net.IP) {
+			changes <- ips
+		})
+
+		var ips []net.IP
+		Eventually(changes, 3).Should(Receive(&ips))
+		Expect(ips).To(ConsistOf(net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")))
+		Consistently(changes, 1500*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("does not watch without an interval or TTLs", func() {
+		f := func(addr string) ([]net.IP, error) {
+			panic("Never should be here")
+		}
+		balancer := v2.NewBalancer("some-addr:8082", v2.WithLookup(f))
+
+		balancer.Watch(0, nil, func([]net.IP) {})
+	})
+
+	It("resolves again when the records expire", func() {
+		var (
+			mu      sync.Mutex
+			lookups int
+		)
+		f := func(addr string) ([]net.IP, time.Duration, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			lookups++
+			return []net.IP{net.ParseIP(fmt.Sprintf("10.0.0.%d", lookups))}, time.Second, nil
+		}
+		balancer := v2.NewBalancer("some-addr:8082", v2.WithTTLLookup(f))
+
+		hostPort, err := balancer.NextHostPort()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hostPort).To(Equal("10.0.0.1:8082"))
+
+		changes := make(chan []net.IP, 10)
+		done := make(chan struct{})
+		defer close(done)
+		go balancer.Watch(0, done, func(ips []net.IP) {
+			changes <- ips
+		})
+
+		Eventually(changes, 3).Should(Receive())
+	})
+
+	It("returns static addresses in turn without lookup", func() {
+		balancer := v2.NewStaticBalancer([]string{"10.0.0.1:8082", "10.0.0.2:8082"})
+
+		var hostPorts []string
+		for i := 0; i < 4; i++ {
+			hostPort, err := balancer.NextHostPort()
+			Expect(err).ToNot(HaveOccurred())
+			hostPorts = append(hostPorts, hostPort)
+		}
+
+		Expect(hostPorts).To(Equal([]string{
+			"10.0.0.1:8082", "10.0.0.2:8082", "10.0.0.1:8082", "10.0.0.2:8082",
+		}))
+	})
+
+	It("returns discovered addresses", func() {
+		d := &spyDiscoverer{hostPorts: []string{"10.0.0.1:8082"}}
+		balancer := v2.NewDiscoveryBalancer(d)
+
+		hostPort, err := balancer.NextHostPort()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hostPort).To(Equal("10.0.0.1:8082"))
+	})
+
+	It("returns an error if discovery finds nothing", func() {
+		balancer := v2.NewDiscoveryBalancer(&spyDiscoverer{})
+
+		_, err := balancer.NextHostPort()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("notifies when the discovered addresses change", func() {
+		d := &spyDiscoverer{hostPorts: []string{"10.0.0.1:8082"}}
+		balancer := v2.NewDiscoveryBalancer(d)
+
+		changes := make(chan []net.IP, 10)
+		done := make(chan struct{})
+		defer close(done)
+		go balancer.Watch(time.Millisecond, done, func(ips []net.IP) {
+			changes <- ips
+		})
+
+		Consistently(changes, 100*time.Millisecond).ShouldNot(Receive())
+		d.set([]string{"10.0.0.2:8082"})
+
+		var ips []net.IP
+		Eventually(changes).Should(Receive(&ips))
+		Expect(ips).To(ConsistOf(net.ParseIP("10.0.0.2")))
+	})
+
+	Context("with a BOSH DNS query", func() {
+		var (
+			mu     sync.Mutex
+			lookup []string
+			f      func(string) ([]net.IP, error)
+		)
+
+		BeforeEach(func() {
+			lookup = nil
+			f = func(host string) ([]net.IP, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				lookup = append(lookup, host)
+				return []net.IP{net.ParseIP("10.0.0.1")}, nil
+			}
+		})
+
+		looked := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), lookup...)
+		}
+
+		It("encodes the health and AZ filters into the host", func() {
+			balancer := v2.NewBalancer("doppler.default.cf.bosh:8082",
+				v2.WithLookup(f),
+				v2.WithBOSHDNSQuery(v2.BOSHDNSQuery{
+					Health:    v2.BOSHDNSHealthHealthy,
+					AZIndexes: []int{1, 2},
+				}),
+			)
+
+			hostPort, err := balancer.NextHostPort()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hostPort).To(Equal("10.0.0.1:8082"))
+			Expect(looked()).To(Equal([]string{"q-a1a2s3.doppler.default.cf.bosh"}))
+		})
+
+		It("encodes smart and all health filters", func() {
+			smart := v2.NewBalancer("doppler.default.cf.bosh:8082",
+				v2.WithLookup(f),
+				v2.WithBOSHDNSQuery(v2.BOSHDNSQuery{Health: v2.BOSHDNSHealthSmart}),
+			)
+			all := v2.NewBalancer("doppler.default.cf.bosh:8082",
+				v2.WithLookup(f),
+				v2.WithBOSHDNSQuery(v2.BOSHDNSQuery{Health: v2.BOSHDNSHealthAll}),
+			)
+
+			smart.NextHostPort()
+			all.NextHostPort()
+
+			Expect(looked()).To(Equal([]string{
+				"q-s0.doppler.default.cf.bosh",
+				"q-s4.doppler.default.cf.bosh",
+			}))
+		})
+
+		It("leaves hosts that already have a query as they are", func() {
+			balancer := v2.NewBalancer("q-s0.doppler.default.cf.bosh:8082",
+				v2.WithLookup(f),
+				v2.WithBOSHDNSQuery(v2.BOSHDNSQuery{Health: v2.BOSHDNSHealthHealthy}),
+			)
+
+			balancer.NextHostPort()
+
+			Expect(looked()).To(Equal([]string{"q-s0.doppler.default.cf.bosh"}))
+		})
+
+		It("resolves the query when watching", func() {
+			balancer := v2.NewBalancer("doppler.default.cf.bosh:8082",
+				v2.WithLookup(f),
+				v2.WithBOSHDNSQuery(v2.BOSHDNSQuery{Health: v2.BOSHDNSHealthHealthy}),
+			)
+
+			done := make(chan struct{})
+			defer close(done)
+			go balancer.Watch(time.Millisecond, done, func([]net.IP) {})
+
+			Eventually(looked).Should(ContainElement("q-s3.doppler.default.cf.bosh"))
+		})
+	})
+
+	Context("when lookup returns IPv4 and IPv6 addresses", func() {
+		mixed := func(addr string) ([]net.IP, error) {
+			return []net.IP{
+				net.ParseIP("10.10.10.1"),
+				net.ParseIP("fd00::1"),
+				net.ParseIP("10.10.10.2"),
+				net.ParseIP("fd00::2"),
+			}, nil
+		}
+
+		nextHostPorts := func(b *v2.Balancer) []string {
+			var hostPorts []string
+			for i := 0; i < 20; i++ {
+				hostPort, err := b.NextHostPort()
+				Expect(err).ToNot(HaveOccurred())
+				hostPorts = append(hostPorts, hostPort)
+			}
+			return hostPorts
+		}
+
+		It("returns addresses of both families by default", func() {
+			balancer := v2.NewBalancer("some-addr:8082", v2.WithLookup(mixed))
+
+			Expect(nextHostPorts(balancer)).To(ContainElement(MatchRegexp(`^10\.10\.10\.\d:8082$`)))
+			Expect(nextHostPorts(balancer)).To(ContainElement(MatchRegexp(`^\[fd00::\d\]:8082$`)))
+		})
+
+		It("returns only IPv4 addresses when IPv4 is preferred", func() {
+			balancer := v2.NewBalancer("some-addr:8082",
+				v2.WithLookup(mixed),
+				v2.WithIPFamily(v2.IPFamilyV4),
+			)
+
+			for _, hostPort := range nextHostPorts(balancer) {
+				Expect(hostPort).To(Or(Equal("10.10.10.1:8082"), Equal("10.10.10.2:8082")))
+			}
+		})
+
+		It("returns bracketed IPv6 addresses when IPv6 is preferred", func() {
+			balancer := v2.NewBalancer("some-addr:8082",
+				v2.WithLookup(mixed),
+				v2.WithIPFamily(v2.IPFamilyV6),
+			)
+
+			for _, hostPort := range nextHostPorts(balancer) {
+				Expect(hostPort).To(Or(Equal("[fd00::1]:8082"), Equal("[fd00::2]:8082")))
+			}
+		})
+
+		It("falls back to the other family when the preferred one is missing", func() {
+			balancer := v2.NewBalancer("some-addr:8082",
+				v2.WithLookup(func(string) ([]net.IP, error) {
+					return []net.IP{net.ParseIP("10.10.10.1")}, nil
+				}),
+				v2.WithIPFamily(v2.IPFamilyV6),
+			)
+
+			Expect(balancer.NextHostPort()).To(Equal("10.10.10.1:8082"))
+		})
+	})
 })
+
+type spyDiscoverer struct {
+	mu        sync.Mutex
+	hostPorts []string
+}
+
+func (s *spyDiscoverer) Discover() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.hostPorts, nil
+}
+
+func (s *spyDiscoverer) set(hostPorts []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hostPorts = hostPorts
+}
@@ -0,0 +1,70 @@
+package v2
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// BOSHDNSHealth is the healthiness of the instances a BOSH DNS query
+// returns.
+type BOSHDNSHealth int
+
+const (
+	// BOSHDNSHealthAny leaves the healthiness filter to BOSH DNS.
+	BOSHDNSHealthAny BOSHDNSHealth = iota
+
+	// BOSHDNSHealthSmart returns healthy instances, or every instance if
+	// none are healthy.
+	BOSHDNSHealthSmart
+
+	// BOSHDNSHealthHealthy only returns healthy instances.
+	BOSHDNSHealthHealthy
+
+	// BOSHDNSHealthAll returns instances regardless of their health.
+	BOSHDNSHealthAll
+)
+
+// BOSHDNSQuery is a set of BOSH DNS filters that are encoded into the host
+// being resolved, e.g. q-a1s3.doppler.default.cf.bosh for healthy instances
+// in the AZ with index 1.
+type BOSHDNSQuery struct {
+	Health    BOSHDNSHealth
+	AZIndexes []int
+}
+
+// encode returns the q- label of the query or an empty string if the query
+// has no filters.
+func (q BOSHDNSQuery) encode() string {
+	var b strings.Builder
+	for _, i := range q.AZIndexes {
+		b.WriteString("a")
+		b.WriteString(strconv.Itoa(i))
+	}
+
+	switch q.Health {
+	case BOSHDNSHealthSmart:
+		b.WriteString("s0")
+	case BOSHDNSHealthHealthy:
+		b.WriteString("s3")
+	case BOSHDNSHealthAll:
+		b.WriteString("s4")
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+
+	return "q-" + b.String()
+}
+
+// apply prefixes the host with the query. IPs and hosts that already carry a
+// query are returned as they are.
+func (q BOSHDNSQuery) apply(host string) string {
+	label := q.encode()
+	if label == "" || net.ParseIP(host) != nil || strings.HasPrefix(host, "q-") {
+		return host
+	}
+
+	return label + "." + host
+}
@@ -28,12 +28,19 @@ func New(conns ...Conn) *ClientPool {
 	return pool
 }
 
+// Write writes the envelopes to a random conn, trying each conn in turn
+// until one succeeds. Conns that are destinations reporting themselves as
+// down are skipped.
 func (c *ClientPool) Write(msgs []*loggregator_v2.Envelope) error {
 	seed := rand.Int()
 	for i := range c.conns {
 		idx := (i + seed) % len(c.conns)
 		conn := *(*Conn)(atomic.LoadPointer(&c.conns[idx]))
 
+		if down(conn) {
+			continue
+		}
+
 		if err := conn.Write(msgs); err == nil {
 			return nil
 		}
@@ -41,3 +48,30 @@ func (c *ClientPool) Write(msgs []*loggregator_v2.Envelope) error {
 
 	return errors.New("unable to write to any dopplers")
 }
+
+// BorrowsBatches reports whether every conn borrows batches, not using the
+// envelopes slice once Write returns.
+func (c *ClientPool) BorrowsBatches() bool {
+	for i := range c.conns {
+		conn := *(*Conn)(atomic.LoadPointer(&c.conns[i]))
+		b, ok := conn.(interface{ BorrowsBatches() bool })
+		if !ok || !b.BorrowsBatches() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Health returns the health of every conn that is a destination.
+func (c *ClientPool) Health() []DestinationHealth {
+	var health []DestinationHealth
+	for i := range c.conns {
+		conn := *(*Conn)(atomic.LoadPointer(&c.conns[i]))
+		if d, ok := conn.(Destination); ok {
+			health = append(health, d.Health())
+		}
+	}
+
+	return health
+}
@@ -5,6 +5,7 @@ import (
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	clientpool "code.cloudfoundry.org/loggregator-agent/pkg/clientpool/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -20,6 +21,15 @@ func (s *SpyConn) Write(e []*loggregator_v2.Envelope) error {
 	return s.err
 }
 
+type SpyDestination struct {
+	SpyConn
+	state clientpool.DestinationState
+}
+
+func (s *SpyDestination) Health() clientpool.DestinationHealth {
+	return clientpool.DestinationHealth{State: s.state}
+}
+
 var _ = Describe("ClientPool", func() {
 	var (
 		pool  *clientpool.ClientPool
@@ -72,6 +82,59 @@ var _ = Describe("ClientPool", func() {
 				Expect(envelopeCount(conns)).To(Equal(1))
 			})
 		})
+
+		Context("with destinations that are down", func() {
+			var (
+				up   *SpyDestination
+				down *SpyDestination
+			)
+
+			BeforeEach(func() {
+				up = &SpyDestination{state: clientpool.DestinationDegraded}
+				down = &SpyDestination{state: clientpool.DestinationDown}
+				pool = clientpool.New(down, up, down)
+			})
+
+			It("skips the destinations that are down", func() {
+				for i := 0; i < 10; i++ {
+					Expect(pool.Write([]*loggregator_v2.Envelope{{SourceId: "some-uuid"}})).To(Succeed())
+				}
+
+				Expect(up.data).To(HaveLen(10))
+				Expect(down.data).To(BeEmpty())
+			})
+
+			It("returns an error when every destination is down", func() {
+				up.state = clientpool.DestinationDown
+
+				Expect(pool.Write(nil)).ToNot(Succeed())
+				Expect(up.data).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("Writer conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			return s.Harness(clientpool.New(s))
+		})
+	})
+
+	Describe("Health()", func() {
+		It("reports the health of every destination", func() {
+			pool = clientpool.New(
+				&SpyConn{},
+				&SpyDestination{state: clientpool.DestinationConnected},
+				&SpyDestination{state: clientpool.DestinationDown},
+			)
+
+			health := pool.Health()
+			Expect(health).To(HaveLen(2))
+			Expect(health[0].State).To(Equal(clientpool.DestinationConnected))
+			Expect(health[1].State).To(Equal(clientpool.DestinationDown))
+			Expect(clientpool.DestinationsUp(health)).To(BeTrue())
+			Expect(clientpool.DestinationsUp(health[1:])).To(BeFalse())
+		})
 	})
 })
 
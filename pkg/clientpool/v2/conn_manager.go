@@ -2,43 +2,129 @@ package v2
 
 import (
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
 	plumbing "code.cloudfoundry.org/loggregator-agent/pkg/plumbing/v2"
 )
 
+var logger = logging.New("clientpool")
+
+// failures summarizes the write and connection failures of every
+// ConnManager, which would otherwise be logged for each attempt while
+// Dopplers are unavailable.
+var failures = logger.Summarize(logging.WarnLevel, logging.SummaryInterval)
+
 type Connector interface {
 	Connect() (io.Closer, loggregator_v2.Ingress_BatchSenderClient, error)
 }
 
+// addresser is implemented by closers that know the address of the doppler
+// they are connected to.
+type addresser interface {
+	Addr() string
+}
+
 type v2GRPCConn struct {
-	client plumbing.DopplerIngress_BatchSenderClient
-	closer io.Closer
-	writes int64
+	client   plumbing.DopplerIngress_BatchSenderClient
+	closer   io.Closer
+	addr     string
+	features *Features
+	writes   int64
+
+	// sendMu serializes sends, which gRPC does not allow concurrently on a
+	// stream, for when several transponders share the conn.
+	sendMu sync.Mutex
+
+	// retired is set, under sendMu, once the conn has been migrated away
+	// from. Sends that have not started by then are refused.
+	retired bool
+
+	closeOnce sync.Once
+}
+
+// errConnRetired is returned for a send that was refused because its conn
+// was migrated away from before the send started. Nothing was sent, so the
+// write can be made again on the conn that replaced it.
+var errConnRetired = errors.New("connection was migrated")
+
+// migrateGrace is how long sends in progress on a migrated conn have to
+// finish before it is closed when there is no write timeout.
+const migrateGrace = 5 * time.Second
+
+// close closes the connection, which ends any send blocked on its stream.
+// It is safe to call more than once.
+func (c *v2GRPCConn) close() {
+	c.closeOnce.Do(func() {
+		c.closer.Close()
+	})
+}
+
+// ConnStats describes the connection currently held by a ConnManager.
+type ConnStats struct {
+	Connected   bool      `json:"connected"`
+	Addr        string    `json:"addr,omitempty"`
+	Features    *Features `json:"features,omitempty"`
+	Writes      int64     `json:"writes"`
+	TotalWrites int64     `json:"total_writes"`
 }
 
 type ConnManager struct {
+	totalWrites  int64
 	conn         unsafe.Pointer
 	maxWrites    int64
 	pollDuration time.Duration
 	connector    Connector
+	health       *healthTracker
+
+	ticker    *time.Ticker
+	reset     chan bool
+	done      chan struct{}
+	closeOnce sync.Once
 
-	ticker *time.Ticker
-	reset  chan bool
+	skipDeprecatedTags bool
+	writeTimeout       time.Duration
 }
 
-func NewConnManager(c Connector, maxWrites int64, pollDuration time.Duration) *ConnManager {
+// ConnManagerOption configures a ConnManager.
+type ConnManagerOption func(*ConnManager)
+
+// WithoutDeprecatedTags writes tags as they are to dopplers that do not
+// support them rather than converting them to deprecated tags. Those
+// dopplers drop the tags.
+func WithoutDeprecatedTags() ConnManagerOption {
+	return func(m *ConnManager) {
+		m.skipDeprecatedTags = true
+	}
+}
+
+// WithWriteTimeout fails writes that take longer than d and drops the
+// connection, so that a stream to an unresponsive doppler is replaced
+// rather than blocking writes indefinitely.
+func WithWriteTimeout(d time.Duration) ConnManagerOption {
+	return func(m *ConnManager) {
+		m.writeTimeout = d
+	}
+}
+
+func NewConnManager(c Connector, maxWrites int64, pollDuration time.Duration, opts ...ConnManagerOption) *ConnManager {
 	m := &ConnManager{
 		maxWrites:    maxWrites,
 		pollDuration: pollDuration,
 		connector:    c,
+		health:       newHealthTracker(DestinationDown, "not yet connected"),
 		ticker:       time.NewTicker(pollDuration),
 		reset:        make(chan bool, 100),
+		done:         make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(m)
 	}
 	go m.maintainConn()
 	return m
@@ -51,33 +137,210 @@ func (m *ConnManager) Write(envelopes []*loggregator_v2.Envelope) error {
 	}
 
 	gRPCConn := (*v2GRPCConn)(conn)
-	err := gRPCConn.client.Send(&loggregator_v2.EnvelopeBatch{Batch: envelopes})
+	batches := [][]*loggregator_v2.Envelope{envelopes}
+	if gRPCConn.features != nil {
+		batches = gRPCConn.features.adapt(envelopes, !m.skipDeprecatedTags)
+	}
 
+	err := m.send(gRPCConn, batches)
 	if err != nil {
-		log.Printf("error writing to doppler: %s", err)
-		atomic.StorePointer(&m.conn, nil)
-		gRPCConn.closer.Close()
-		m.reset <- true
+		if err == errConnRetired {
+			return m.Write(envelopes)
+		}
+
+		failures.Add(fmt.Sprintf("error writing to doppler: %s", err), 1)
+		m.drop(conn, fmt.Sprintf("write failed: %s", err))
 		return err
 	}
 
+	atomic.AddInt64(&m.totalWrites, 1)
 	if atomic.AddInt64(&gRPCConn.writes, 1) >= m.maxWrites {
-		log.Printf("recycling connection to doppler after %d writes", m.maxWrites)
-		atomic.StorePointer(&m.conn, nil)
-		gRPCConn.closer.Close()
-		m.reset <- true
+		logger.With(logging.Fields{"count": m.maxWrites}).Debugf("recycling connection to doppler after %d writes", m.maxWrites)
+		m.drop(conn, "recycling connection")
+	}
+
+	return nil
+}
+
+// BorrowsBatches returns true as envelopes are sent before Write returns,
+// or copied when a send may outlive a write timeout.
+func (m *ConnManager) BorrowsBatches() bool {
+	return true
+}
+
+// send writes the batches to the connection, giving up once the write
+// timeout has passed. A send that times out is ended by closing the
+// connection, which cancels its stream.
+func (m *ConnManager) send(conn *v2GRPCConn, batches [][]*loggregator_v2.Envelope) error {
+	if m.writeTimeout <= 0 {
+		return conn.send(batches)
+	}
+
+	// The send may still be marshaling the batches after a timeout, by
+	// which time the caller is free to reuse them, so it is given its own
+	// copies.
+	owned := make([][]*loggregator_v2.Envelope, 0, len(batches))
+	for _, b := range batches {
+		owned = append(owned, append([]*loggregator_v2.Envelope(nil), b...))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.send(owned)
+	}()
+
+	timer := time.NewTimer(m.writeTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		conn.close()
+		return fmt.Errorf("write timed out after %s", m.writeTimeout)
+	}
+}
+
+func (c *v2GRPCConn) send(batches [][]*loggregator_v2.Envelope) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.retired {
+		return errConnRetired
+	}
+
+	for _, b := range batches {
+		if err := c.client.Send(&loggregator_v2.EnvelopeBatch{Batch: b}); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// Recycle closes the current connection so that a new one is made through
+// the connector. It is used to rebalance connections when the addresses
+// being connected to change.
+func (m *ConnManager) Recycle() {
+	conn := atomic.LoadPointer(&m.conn)
+	if conn == nil || (*v2GRPCConn)(conn) == nil {
+		return
+	}
+
+	m.drop(conn, "rebalancing connection")
+}
+
+// Migrate replaces the current connection with a new one made through the
+// connector. Unlike Recycle, the new connection is made before the old one
+// is swapped out, so writes keep succeeding while the addresses being
+// connected to change. Writes that had not yet started on the old
+// connection are made on the new one. The old connection is closed once the
+// send in progress on it finishes, or after the write timeout if it does
+// not, without Migrate waiting for it. If the new connection can not be
+// made the current one is kept and the error is returned.
+func (m *ConnManager) Migrate() error {
+	closer, senderClient, err := m.connector.Connect()
+	if err != nil {
+		failures.Add(fmt.Sprintf("failed to connect: %s", err), 1)
+		return err
+	}
+
+	select {
+	case <-m.done:
+		closer.Close()
+		return errors.New("connection manager is closed")
+	default:
+	}
+
+	next := m.newConn(closer, senderClient)
+	prev := atomic.LoadPointer(&m.conn)
+	if !atomic.CompareAndSwapPointer(&m.conn, prev, unsafe.Pointer(next)) {
+		// The connection was dropped or replaced while the new one was
+		// being made, which has already rebalanced it.
+		closer.Close()
+		return nil
+	}
+	m.health.set(DestinationConnected, next.addr, "")
+
+	select {
+	case <-m.done:
+		if atomic.CompareAndSwapPointer(&m.conn, unsafe.Pointer(next), nil) {
+			closer.Close()
+		}
+	default:
+	}
+
+	if prev != nil && (*v2GRPCConn)(prev) != nil {
+		go m.retire((*v2GRPCConn)(prev))
+	}
+
+	return nil
+}
+
+// retire refuses further sends on a conn that was migrated away from and
+// closes it once the send in progress finishes or the grace period passes,
+// whichever is first.
+func (m *ConnManager) retire(c *v2GRPCConn) {
+	grace := m.writeTimeout
+	if grace <= 0 {
+		grace = migrateGrace
+	}
+
+	idle := make(chan struct{})
+	go func() {
+		c.sendMu.Lock()
+		c.retired = true
+		c.sendMu.Unlock()
+		close(idle)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+	c.close()
+}
+
+// Close closes the current connection and stops reconnecting. Writes fail
+// once the ConnManager is closed.
+func (m *ConnManager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+
+	conn := atomic.SwapPointer(&m.conn, nil)
+	if conn != nil && (*v2GRPCConn)(conn) != nil {
+		(*v2GRPCConn)(conn).close()
+	}
+	m.health.set(DestinationDown, "", "closed")
+}
+
+// drop closes the connection and starts reconnecting if it is still the
+// current connection.
+func (m *ConnManager) drop(conn unsafe.Pointer, reason string) {
+	if !atomic.CompareAndSwapPointer(&m.conn, conn, nil) {
+		return
+	}
+
+	gRPCConn := (*v2GRPCConn)(conn)
+	m.health.set(DestinationDegraded, gRPCConn.addr, reason)
+	gRPCConn.close()
+	m.reset <- true
+}
+
 func (m *ConnManager) maintainConn() {
 
 	// Ensure initial connection does not wait on timer
 	m.reset <- true
+	defer m.ticker.Stop()
 
 	for {
-		m.checkConnectionTimer()
+		if !m.checkConnectionTimer() {
+			return
+		}
 
 		conn := atomic.LoadPointer(&m.conn)
 		if conn != nil && (*v2GRPCConn)(conn) != nil {
@@ -86,20 +349,90 @@ func (m *ConnManager) maintainConn() {
 
 		closer, senderClient, err := m.connector.Connect()
 		if err != nil {
-			log.Printf("failed to connect: %s", err)
+			failures.Add(fmt.Sprintf("failed to connect: %s", err), 1)
+			m.health.set(DestinationDown, "", fmt.Sprintf("failed to connect: %s", err))
+			continue
+		}
+
+		select {
+		case <-m.done:
+			closer.Close()
+			return
+		default:
+		}
+
+		conn = unsafe.Pointer(m.newConn(closer, senderClient))
+		if !atomic.CompareAndSwapPointer(&m.conn, nil, conn) {
+			// A migration stored a connection while this one was being
+			// made.
+			closer.Close()
 			continue
 		}
+		m.health.set(DestinationConnected, (*v2GRPCConn)(conn).addr, "")
+	}
+}
+
+// newConn wraps a connection made through the connector.
+func (m *ConnManager) newConn(closer io.Closer, client loggregator_v2.Ingress_BatchSenderClient) *v2GRPCConn {
+	var addr string
+	if a, ok := closer.(addresser); ok {
+		addr = a.Addr()
+	}
+
+	var features *Features
+	if f, ok := closer.(featurer); ok {
+		fs := f.Features()
+		if m.skipDeprecatedTags && !fs.Tags {
+			logger.Warnf("doppler %s does not support tags, they will be dropped", addr)
+		}
+		features = &fs
+	}
+
+	return &v2GRPCConn{
+		client:   client,
+		closer:   closer,
+		addr:     addr,
+		features: features,
+	}
+}
+
+// Health reports whether the ConnManager has a connection. A ConnManager
+// that is reconnecting after a failed write or a recycled connection is
+// degraded and one that failed to connect is down.
+func (m *ConnManager) Health() DestinationHealth {
+	return m.health.load()
+}
+
+// Stats returns the state of the current connection and the number of
+// writes made to it.
+func (m *ConnManager) Stats() ConnStats {
+	stats := ConnStats{
+		TotalWrites: atomic.LoadInt64(&m.totalWrites),
+	}
 
-		atomic.StorePointer(&m.conn, unsafe.Pointer(&v2GRPCConn{
-			client: senderClient,
-			closer: closer,
-		}))
+	conn := atomic.LoadPointer(&m.conn)
+	if conn == nil || (*v2GRPCConn)(conn) == nil {
+		return stats
 	}
+
+	gRPCConn := (*v2GRPCConn)(conn)
+	stats.Connected = true
+	stats.Addr = gRPCConn.addr
+	stats.Features = gRPCConn.features
+	stats.Writes = atomic.LoadInt64(&gRPCConn.writes)
+
+	return stats
 }
 
-func (m *ConnManager) checkConnectionTimer() {
+// checkConnectionTimer waits until the connection should be checked. It
+// returns false once the ConnManager is closed.
+func (m *ConnManager) checkConnectionTimer() bool {
 	select {
+	case <-m.done:
+		return false
 	case <-m.ticker.C:
 	case <-m.reset:
 	}
+
+	return true
 }
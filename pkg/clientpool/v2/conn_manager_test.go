@@ -42,15 +42,60 @@ func (s *SpyConnector) called() int {
 type SpyClient struct {
 	plumbing.DopplerIngress_BatchSenderClient
 
-	batch *loggregator_v2.EnvelopeBatch
-	err   error
+	batch   *loggregator_v2.EnvelopeBatch
+	batches []*loggregator_v2.EnvelopeBatch
+	err     error
 }
 
 func (s *SpyClient) Send(e *loggregator_v2.EnvelopeBatch) error {
 	s.batch = e
+	s.batches = append(s.batches, e)
 	return s.err
 }
 
+type BlockingClient struct {
+	plumbing.DopplerIngress_BatchSenderClient
+
+	unblock chan struct{}
+	sent    chan []*loggregator_v2.Envelope
+}
+
+func (s *BlockingClient) Send(e *loggregator_v2.EnvelopeBatch) error {
+	<-s.unblock
+	s.sent <- e.Batch
+	return errors.New("stream closed")
+}
+
+// UnblockingCloser ends blocked sends when it is closed, as closing a gRPC
+// connection does.
+type UnblockingCloser struct {
+	*SpyCloser
+	client *BlockingClient
+}
+
+func (s *UnblockingCloser) Close() error {
+	close(s.client.unblock)
+	return s.SpyCloser.Close()
+}
+
+type SpyAddrCloser struct {
+	SpyCloser
+	addr string
+}
+
+func (s *SpyAddrCloser) Addr() string {
+	return s.addr
+}
+
+type SpyFeaturesCloser struct {
+	SpyCloser
+	features clientpool.Features
+}
+
+func (s *SpyFeaturesCloser) Features() clientpool.Features {
+	return s.features
+}
+
 type SpyCloser struct {
 	called int
 }
@@ -100,6 +145,186 @@ var _ = Describe("ConnManager", func() {
 			Expect(closer.called).ToNot(BeZero())
 		})
 
+		It("reports the connection and its write counts", func() {
+			connector = &SpyConnector{
+				closer: &SpyAddrCloser{addr: "10.0.0.1:8082"},
+				client: senderClient,
+			}
+			connManager = clientpool.NewConnManager(connector, 5, time.Minute)
+
+			f := func() error {
+				return connManager.Write(nil)
+			}
+			Eventually(f).Should(Succeed())
+			Expect(connManager.Write(nil)).To(Succeed())
+
+			Expect(connManager.Stats()).To(Equal(clientpool.ConnStats{
+				Connected:   true,
+				Addr:        "10.0.0.1:8082",
+				Writes:      2,
+				TotalWrites: 2,
+			}))
+		})
+
+		It("reconnects when the connection is recycled", func() {
+			f := func() error {
+				return connManager.Write(nil)
+			}
+			Eventually(f).Should(Succeed())
+
+			connManager.Recycle()
+
+			Eventually(connector.called).Should(Equal(2))
+			Eventually(f).Should(Succeed())
+		})
+
+		It("makes the new connection before closing the old one when migrated", func() {
+			f := func() error {
+				return connManager.Write(nil)
+			}
+			Eventually(f).Should(Succeed())
+
+			nextCloser := &SpyAddrCloser{addr: "10.0.0.2:8082"}
+			connector.mu.Lock()
+			connector.closer = nextCloser
+			connector.mu.Unlock()
+
+			Expect(connManager.Migrate()).To(Succeed())
+
+			Eventually(func() int { return closer.called }).Should(Equal(1))
+			Expect(nextCloser.called).To(BeZero())
+			Expect(f()).To(Succeed())
+			Expect(connManager.Stats().Addr).To(Equal("10.0.0.2:8082"))
+			Expect(connManager.Health().State).To(Equal(clientpool.DestinationConnected))
+		})
+
+		It("keeps the current connection when a migration fails to connect", func() {
+			f := func() error {
+				return connManager.Write(nil)
+			}
+			Eventually(f).Should(Succeed())
+
+			connector.mu.Lock()
+			connector.err = errors.New("an error")
+			connector.mu.Unlock()
+
+			Expect(connManager.Migrate()).To(MatchError("an error"))
+
+			Expect(closer.called).To(BeZero())
+			Expect(f()).To(Succeed())
+		})
+
+		It("closes the connection and does not reconnect when closed", func() {
+			f := func() error {
+				return connManager.Write(nil)
+			}
+			Eventually(f).Should(Succeed())
+
+			connManager.Close()
+
+			Expect(closer.called).To(Equal(1))
+			Expect(f()).To(HaveOccurred())
+			Consistently(connector.called).Should(Equal(1))
+			Expect(connManager.Health().State).To(Equal(clientpool.DestinationDown))
+		})
+
+		It("writes tags as deprecated tags to dopplers without tags support", func() {
+			connector = &SpyConnector{
+				closer: &SpyFeaturesCloser{features: clientpool.Features{
+					API:   clientpool.DopplerIngressAPI,
+					Batch: true,
+				}},
+				client: senderClient,
+			}
+			connManager = clientpool.NewConnManager(connector, 5, time.Minute)
+
+			e := &loggregator_v2.Envelope{
+				SourceId: "some-uuid",
+				Tags:     map[string]string{"a": "b"},
+			}
+			f := func() error {
+				return connManager.Write([]*loggregator_v2.Envelope{e})
+			}
+			Eventually(f).Should(Succeed())
+
+			sent := senderClient.batch.Batch[0]
+			Expect(sent.SourceId).To(Equal("some-uuid"))
+			Expect(sent.Tags).To(BeEmpty())
+			Expect(sent.DeprecatedTags["a"].GetText()).To(Equal("b"))
+			Expect(e.Tags).To(HaveKeyWithValue("a", "b"))
+		})
+
+		It("does not write deprecated tags when configured without them", func() {
+			connector = &SpyConnector{
+				closer: &SpyFeaturesCloser{features: clientpool.Features{
+					API:   clientpool.DopplerIngressAPI,
+					Batch: true,
+				}},
+				client: senderClient,
+			}
+			connManager = clientpool.NewConnManager(connector, 5, time.Minute,
+				clientpool.WithoutDeprecatedTags(),
+			)
+
+			e := &loggregator_v2.Envelope{
+				SourceId: "some-uuid",
+				Tags:     map[string]string{"a": "b"},
+			}
+			f := func() error {
+				return connManager.Write([]*loggregator_v2.Envelope{e})
+			}
+			Eventually(f).Should(Succeed())
+
+			sent := senderClient.batch.Batch[0]
+			Expect(sent.Tags).To(HaveKeyWithValue("a", "b"))
+			Expect(sent.DeprecatedTags).To(BeEmpty())
+			Expect(connManager.Stats().Features.Tags).To(BeFalse())
+		})
+
+		It("splits batches larger than the doppler's max message size", func() {
+			connector = &SpyConnector{
+				closer: &SpyFeaturesCloser{features: clientpool.Features{
+					API:            clientpool.IngressAPI,
+					Tags:           true,
+					Batch:          true,
+					MaxMessageSize: 100,
+				}},
+				client: senderClient,
+			}
+			connManager = clientpool.NewConnManager(connector, 5, time.Minute)
+
+			var batch []*loggregator_v2.Envelope
+			for i := 0; i < 10; i++ {
+				batch = append(batch, &loggregator_v2.Envelope{SourceId: "some-long-source-id"})
+			}
+			f := func() error {
+				return connManager.Write(batch)
+			}
+			Eventually(f).Should(Succeed())
+
+			Expect(len(senderClient.batches)).To(BeNumerically(">", 1))
+			var sent int
+			for _, b := range senderClient.batches {
+				sent += len(b.Batch)
+			}
+			Expect(sent).To(Equal(10))
+			Expect(connManager.Stats().Features.MaxMessageSize).To(Equal(100))
+		})
+
+		It("reports itself as connected", func() {
+			connector = &SpyConnector{
+				closer: &SpyAddrCloser{addr: "10.0.0.1:8082"},
+				client: senderClient,
+			}
+			connManager = clientpool.NewConnManager(connector, 5, time.Minute)
+
+			f := func() clientpool.DestinationState {
+				return connManager.Health().State
+			}
+			Eventually(f).Should(Equal(clientpool.DestinationConnected))
+			Expect(connManager.Health().Addr).To(Equal("10.0.0.1:8082"))
+		})
+
 		Context("when Send() returns an error", func() {
 			BeforeEach(func() {
 				f := func() error {
@@ -116,6 +341,95 @@ var _ = Describe("ConnManager", func() {
 				Expect(actualErr).To(Equal(expectedErr))
 				Expect(closer.called).To(Equal(1))
 			})
+
+			It("reports itself as degraded with the reason", func() {
+				senderClient.err = errors.New("It is the error")
+				connManager.Write(nil)
+
+				health := connManager.Health()
+				Expect(health.State).To(Equal(clientpool.DestinationDegraded))
+				Expect(health.Reason).To(Equal("write failed: It is the error"))
+			})
+		})
+	})
+
+	Context("when a write does not complete", func() {
+		var blockingClient *BlockingClient
+
+		BeforeEach(func() {
+			blockingClient = &BlockingClient{
+				unblock: make(chan struct{}),
+				sent:    make(chan []*loggregator_v2.Envelope, 10),
+			}
+			closer = &SpyCloser{}
+			connector = &SpyConnector{
+				closer: &UnblockingCloser{SpyCloser: closer, client: blockingClient},
+				client: blockingClient,
+			}
+			connManager = clientpool.NewConnManager(connector, 5, time.Minute,
+				clientpool.WithWriteTimeout(50*time.Millisecond),
+			)
+			Eventually(func() bool {
+				return connManager.Stats().Connected
+			}).Should(BeTrue())
+		})
+
+		It("fails the write and reconnects once the timeout passes", func() {
+			err := connManager.Write([]*loggregator_v2.Envelope{{SourceId: "some-uuid"}})
+			Expect(err).To(MatchError("write timed out after 50ms"))
+
+			Eventually(connector.called).Should(Equal(2))
+			Expect(closer.called).To(Equal(1))
+		})
+
+		It("does not send a batch the caller reused after the timeout", func() {
+			batch := []*loggregator_v2.Envelope{{SourceId: "some-uuid"}}
+			err := connManager.Write(batch)
+			Expect(err).To(HaveOccurred())
+
+			batch[0] = &loggregator_v2.Envelope{SourceId: "reused"}
+
+			var sent []*loggregator_v2.Envelope
+			Eventually(blockingClient.sent).Should(Receive(&sent))
+			Expect(sent[0].SourceId).To(Equal("some-uuid"))
+		})
+	})
+
+	Context("when a send is stuck on the connection being migrated", func() {
+		var blockingClient *BlockingClient
+
+		BeforeEach(func() {
+			blockingClient = &BlockingClient{
+				unblock: make(chan struct{}),
+				sent:    make(chan []*loggregator_v2.Envelope, 10),
+			}
+			connector = &SpyConnector{
+				closer: &UnblockingCloser{SpyCloser: &SpyCloser{}, client: blockingClient},
+				client: blockingClient,
+			}
+			connManager = clientpool.NewConnManager(connector, 5, time.Minute)
+			Eventually(func() bool {
+				return connManager.Stats().Connected
+			}).Should(BeTrue())
+		})
+
+		It("migrates without waiting for the send", func() {
+			go connManager.Write(nil)
+
+			senderClient = &SpyClient{}
+			connector.mu.Lock()
+			connector.closer = &SpyCloser{}
+			connector.client = senderClient
+			connector.mu.Unlock()
+
+			migrated := make(chan error, 1)
+			go func() {
+				migrated <- connManager.Migrate()
+			}()
+
+			Eventually(migrated).Should(Receive(BeNil()))
+			Expect(connManager.Write([]*loggregator_v2.Envelope{{SourceId: "some-uuid"}})).To(Succeed())
+			Expect(senderClient.batch.Batch[0].SourceId).To(Equal("some-uuid"))
 		})
 	})
 
@@ -133,5 +447,17 @@ var _ = Describe("ConnManager", func() {
 			}
 			Consistently(f).Should(HaveOccurred())
 		})
+
+		It("reports that it is not connected", func() {
+			Expect(connManager.Stats()).To(Equal(clientpool.ConnStats{}))
+		})
+
+		It("reports itself as down with the reason", func() {
+			f := func() string {
+				return connManager.Health().Reason
+			}
+			Eventually(f).Should(Equal("failed to connect: an error"))
+			Expect(connManager.Health().State).To(Equal(clientpool.DestinationDown))
+		})
 	})
 })
@@ -0,0 +1,150 @@
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsulDiscoverer finds the instances of a service that are passing their
+// Consul health checks through the health endpoint of a Consul agent.
+type ConsulDiscoverer struct {
+	addr       string
+	service    string
+	tag        string
+	datacenter string
+	token      string
+	client     *http.Client
+}
+
+// ConsulDiscovererOption configures a ConsulDiscoverer.
+type ConsulDiscovererOption func(*ConsulDiscoverer)
+
+// WithConsulTag only discovers instances registered with the tag.
+func WithConsulTag(tag string) ConsulDiscovererOption {
+	return func(d *ConsulDiscoverer) {
+		d.tag = tag
+	}
+}
+
+// WithConsulDatacenter discovers instances in the datacenter rather than in
+// the datacenter of the Consul agent.
+func WithConsulDatacenter(dc string) ConsulDiscovererOption {
+	return func(d *ConsulDiscoverer) {
+		d.datacenter = dc
+	}
+}
+
+// WithConsulToken sets the ACL token sent with each request.
+func WithConsulToken(token string) ConsulDiscovererOption {
+	return func(d *ConsulDiscoverer) {
+		d.token = token
+	}
+}
+
+// WithConsulHTTPClient sets the client the Consul agent is queried with.
+func WithConsulHTTPClient(c *http.Client) ConsulDiscovererOption {
+	return func(d *ConsulDiscoverer) {
+		d.client = c
+	}
+}
+
+// NewConsulDiscoverer returns a ConsulDiscoverer that queries the Consul
+// agent at addr, e.g. http://127.0.0.1:8500, for the service. An addr
+// without a scheme is queried over HTTP.
+func NewConsulDiscoverer(addr, service string, opts ...ConsulDiscovererOption) *ConsulDiscoverer {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	d := &ConsulDiscoverer{
+		addr:    strings.TrimSuffix(addr, "/"),
+		service: service,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+	for _, o := range opts {
+		o(d)
+	}
+
+	return d
+}
+
+// consulServiceEntry is the part of an entry returned by Consul's health
+// endpoint that holds the instance's address.
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+// Discover returns the host:port of each instance of the service that is
+// passing its health checks. An instance registered without an address is
+// reached at the address of its node.
+func (d *ConsulDiscoverer) Discover() ([]string, error) {
+	q := url.Values{}
+	q.Set("passing", "1")
+	if d.tag != "" {
+		q.Set("tag", d.tag)
+	}
+	if d.datacenter != "" {
+		q.Set("dc", d.datacenter)
+	}
+
+	u := fmt.Sprintf("%s/v1/health/service/%s?%s", d.addr, url.PathEscape(d.service), q.Encode())
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.token != "" {
+		req.Header.Set("X-Consul-Token", d.token)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned %d for service %s", resp.StatusCode, d.service)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode consul response: %s", err)
+	}
+
+	hostPorts := make([]string, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		if host == "" || e.Service.Port == 0 {
+			continue
+		}
+
+		hostPorts = append(hostPorts, net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+
+	return hostPorts, nil
+}
+
+// String returns the Consul agent and service being discovered.
+func (d *ConsulDiscoverer) String() string {
+	return fmt.Sprintf("consul %s/%s", d.addr, d.service)
+}
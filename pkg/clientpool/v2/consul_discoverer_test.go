@@ -0,0 +1,77 @@
+package v2_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/clientpool/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConsulDiscoverer", func() {
+	var (
+		requests chan *http.Request
+		status   int
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		requests = make(chan *http.Request, 10)
+		status = http.StatusOK
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- r
+			w.WriteHeader(status)
+			w.Write([]byte(`[
+				{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "", "Port": 8082}},
+				{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": "10.0.1.2", "Port": 8083}}
+			]`))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("returns the addresses of the passing instances", func() {
+		d := v2.NewConsulDiscoverer(server.URL, "doppler")
+
+		hostPorts, err := d.Discover()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hostPorts).To(Equal([]string{"10.0.0.1:8082", "10.0.1.2:8083"}))
+
+		var r *http.Request
+		Expect(requests).To(Receive(&r))
+		Expect(r.URL.Path).To(Equal("/v1/health/service/doppler"))
+		Expect(r.URL.Query().Get("passing")).To(Equal("1"))
+	})
+
+	It("queries by tag and datacenter with the token", func() {
+		u, err := url.Parse(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		d := v2.NewConsulDiscoverer(u.Host, "doppler",
+			v2.WithConsulTag("z1"),
+			v2.WithConsulDatacenter("dc2"),
+			v2.WithConsulToken("some-token"),
+		)
+
+		_, err = d.Discover()
+		Expect(err).ToNot(HaveOccurred())
+
+		var r *http.Request
+		Expect(requests).To(Receive(&r))
+		Expect(r.URL.Query().Get("tag")).To(Equal("z1"))
+		Expect(r.URL.Query().Get("dc")).To(Equal("dc2"))
+		Expect(r.Header.Get("X-Consul-Token")).To(Equal("some-token"))
+	})
+
+	It("returns an error when consul fails", func() {
+		status = http.StatusInternalServerError
+		d := v2.NewConsulDiscoverer(server.URL, "doppler")
+
+		_, err := d.Discover()
+		Expect(err).To(MatchError("consul returned 500 for service doppler"))
+	})
+})
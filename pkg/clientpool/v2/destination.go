@@ -0,0 +1,99 @@
+package v2
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// DestinationState describes whether a destination is able to accept
+// envelopes.
+type DestinationState string
+
+const (
+	// DestinationConnected means the destination has an open stream.
+	DestinationConnected DestinationState = "connected"
+
+	// DestinationDegraded means the destination lost its stream and is
+	// reconnecting.
+	DestinationDegraded DestinationState = "degraded"
+
+	// DestinationDown means the destination could not open a stream.
+	// Writes to the pool skip destinations that are down.
+	DestinationDown DestinationState = "down"
+)
+
+// DestinationHealth is the state of a destination and the reason it entered
+// that state.
+type DestinationHealth struct {
+	Addr   string           `json:"addr,omitempty"`
+	State  DestinationState `json:"state"`
+	Reason string           `json:"reason,omitempty"`
+	Since  time.Time        `json:"since"`
+}
+
+// Destination is a Conn that reports its own health.
+type Destination interface {
+	Conn
+	Health() DestinationHealth
+}
+
+// DestinationsUp reports whether any of the given destinations is not down.
+func DestinationsUp(h []DestinationHealth) bool {
+	for _, d := range h {
+		if d.State != DestinationDown {
+			return true
+		}
+	}
+
+	return false
+}
+
+// down reports whether the conn is a destination that is down.
+func down(c Conn) bool {
+	d, ok := c.(Destination)
+	if !ok {
+		return false
+	}
+
+	return d.Health().State == DestinationDown
+}
+
+// healthTracker holds the health of a destination. Reads are lock free so
+// that they can be made on every write to the pool.
+type healthTracker struct {
+	mu     sync.Mutex
+	health unsafe.Pointer
+}
+
+func newHealthTracker(state DestinationState, reason string) *healthTracker {
+	return &healthTracker{
+		health: unsafe.Pointer(&DestinationHealth{
+			State:  state,
+			Reason: reason,
+			Since:  time.Now(),
+		}),
+	}
+}
+
+func (t *healthTracker) load() DestinationHealth {
+	return *(*DestinationHealth)(atomic.LoadPointer(&t.health))
+}
+
+// set records the destination's state. Since is only updated when the state
+// changes.
+func (t *healthTracker) set(state DestinationState, addr, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h := t.load()
+	if h.State != state {
+		h.Since = time.Now()
+	}
+	h.State = state
+	h.Addr = addr
+	h.Reason = reason
+
+	atomic.StorePointer(&t.health, unsafe.Pointer(&h))
+}
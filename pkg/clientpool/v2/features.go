@@ -0,0 +1,150 @@
+package v2
+
+import (
+	"strconv"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	plumbing "code.cloudfoundry.org/loggregator-agent/pkg/plumbing/v2"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+const (
+	// IngressAPI is the loggregator.v2.Ingress API.
+	IngressAPI = "ingress"
+
+	// DopplerIngressAPI is the deprecated loggregator.v2.DopplerIngress
+	// API.
+	DopplerIngressAPI = "doppler_ingress"
+)
+
+// MaxMessageSizeHeader is the header a doppler may send to advertise the
+// largest message it accepts.
+const MaxMessageSizeHeader = "loggregator-max-message-size"
+
+// defaultMaxMessageSize is gRPC's default limit on the size of a message a
+// server receives. It is assumed for dopplers that do not advertise a size.
+const defaultMaxMessageSize = 4 * 1024 * 1024
+
+// Features are the envelope features a doppler supports. They are probed
+// each time a connection is made and determine how envelopes are written to
+// the connection.
+type Features struct {
+	// API is the ingress API the envelopes are written with.
+	API string `json:"api"`
+
+	// Tags is true if the doppler supports the tags map. Otherwise tags
+	// are written as deprecated tags.
+	Tags bool `json:"tags"`
+
+	// Batch is true if the doppler supports the batch RPC. Otherwise
+	// envelopes are sent one at a time.
+	Batch bool `json:"batch"`
+
+	// MaxMessageSize is the largest batch, in bytes, the doppler accepts.
+	// Larger batches are split.
+	MaxMessageSize int `json:"max_message_size"`
+}
+
+// featurer is implemented by closers that know the features of the doppler
+// they are connected to.
+type featurer interface {
+	Features() Features
+}
+
+// maxMessageSize returns the message size advertised in the headers of a
+// completed stream or the default size if none is advertised.
+func maxMessageSize(s grpc.ClientStream) int {
+	md, err := s.Header()
+	if err != nil {
+		return defaultMaxMessageSize
+	}
+
+	v := md.Get(MaxMessageSizeHeader)
+	if len(v) == 0 {
+		return defaultMaxMessageSize
+	}
+
+	size, err := strconv.Atoi(v[0])
+	if err != nil || size <= 0 {
+		return defaultMaxMessageSize
+	}
+
+	return size
+}
+
+// adapt converts the envelopes to the form the features require and splits
+// them into batches that fit within the maximum message size. Tags are only
+// converted to deprecated tags if deprecatedTags is true.
+func (f Features) adapt(envelopes []*loggregator_v2.Envelope, deprecatedTags bool) [][]*loggregator_v2.Envelope {
+	if !f.Tags && deprecatedTags {
+		envelopes = withDeprecatedTags(envelopes)
+	}
+
+	return splitBatch(envelopes, f.MaxMessageSize)
+}
+
+// withDeprecatedTags returns copies of the envelopes with their tags moved
+// to deprecated tags. The given envelopes are not modified as they may be
+// written to other destinations.
+func withDeprecatedTags(envelopes []*loggregator_v2.Envelope) []*loggregator_v2.Envelope {
+	converted := make([]*loggregator_v2.Envelope, 0, len(envelopes))
+	for _, e := range envelopes {
+		if len(e.GetTags()) == 0 {
+			converted = append(converted, e)
+			continue
+		}
+
+		tags := make(map[string]*loggregator_v2.Value, len(e.DeprecatedTags)+len(e.Tags))
+		for k, v := range e.DeprecatedTags {
+			tags[k] = v
+		}
+		for k, v := range e.Tags {
+			tags[k] = &loggregator_v2.Value{
+				Data: &loggregator_v2.Value_Text{Text: v},
+			}
+		}
+
+		converted = append(converted, &loggregator_v2.Envelope{
+			Timestamp:      e.Timestamp,
+			SourceId:       e.SourceId,
+			InstanceId:     e.InstanceId,
+			DeprecatedTags: tags,
+			Message:        e.Message,
+		})
+	}
+
+	return converted
+}
+
+// splitBatch splits the envelopes into batches no larger than max bytes. A
+// single envelope larger than max is returned in a batch of its own.
+func splitBatch(envelopes []*loggregator_v2.Envelope, max int) [][]*loggregator_v2.Envelope {
+	if max <= 0 || len(envelopes) <= 1 || proto.Size(&loggregator_v2.EnvelopeBatch{Batch: envelopes}) <= max {
+		return [][]*loggregator_v2.Envelope{envelopes}
+	}
+
+	mid := len(envelopes) / 2
+	return append(splitBatch(envelopes[:mid], max), splitBatch(envelopes[mid:], max)...)
+}
+
+// envelopeSender sends each envelope of a batch on a stream to a doppler
+// that does not support the batch RPC.
+type envelopeSender struct {
+	plumbing.DopplerIngress_SenderClient
+}
+
+func (s envelopeSender) Send(b *loggregator_v2.EnvelopeBatch) error {
+	for _, e := range b.GetBatch() {
+		if err := s.DopplerIngress_SenderClient.Send(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s envelopeSender) CloseAndRecv() (*loggregator_v2.BatchSenderResponse, error) {
+	_, err := s.DopplerIngress_SenderClient.CloseAndRecv()
+	return &loggregator_v2.BatchSenderResponse{}, err
+}
@@ -3,6 +3,8 @@ package v2
 import (
 	"errors"
 	"io"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 )
@@ -11,27 +13,171 @@ type ClientFetcher interface {
 	Fetch(addr string) (conn io.Closer, client loggregator_v2.Ingress_BatchSenderClient, err error)
 }
 
+// FailoverPolicy is how a GRPCConnector chooses between its balancers, which
+// are ordered by preference.
+type FailoverPolicy int
+
+const (
+	// FailoverPrefer connects through the first balancer that resolves and
+	// connects, in order.
+	FailoverPrefer FailoverPolicy = iota
+
+	// FailoverStrict only connects through the first balancer.
+	FailoverStrict
+
+	// FailoverRoundRobin starts each connection at the next balancer in
+	// turn and falls back to the others.
+	FailoverRoundRobin
+)
+
+// GRPCConnectorOption configures a GRPCConnector.
+type GRPCConnectorOption func(*GRPCConnector)
+
+// WithFailoverPolicy sets how the connector chooses between its balancers.
+// It defaults to FailoverPrefer.
+func WithFailoverPolicy(p FailoverPolicy) GRPCConnectorOption {
+	return func(c *GRPCConnector) {
+		c.policy = p
+	}
+}
+
+// WithFailoverTimeout sets how long the first balancer must have been
+// failing before FailoverPrefer falls back to the others. It defaults to
+// falling back immediately.
+func WithFailoverTimeout(d time.Duration) GRPCConnectorOption {
+	return func(c *GRPCConnector) {
+		c.timeout = d
+	}
+}
+
 type GRPCConnector struct {
 	fetcher   ClientFetcher
 	balancers []*Balancer
+	policy    FailoverPolicy
+	timeout   time.Duration
+
+	state *connectorState
+}
+
+type connectorState struct {
+	mu           sync.Mutex
+	next         int
+	failingSince time.Time
+
+	// origins maps every address connected to onto the index of the
+	// balancer it was resolved from.
+	origins map[string]int
 }
 
-func MakeGRPCConnector(fetcher ClientFetcher, balancers []*Balancer) GRPCConnector {
-	return GRPCConnector{
+func MakeGRPCConnector(fetcher ClientFetcher, balancers []*Balancer, opts ...GRPCConnectorOption) GRPCConnector {
+	c := GRPCConnector{
 		fetcher:   fetcher,
 		balancers: balancers,
+		state: &connectorState{
+			origins: make(map[string]int),
+		},
+	}
+	for _, o := range opts {
+		o(&c)
 	}
+
+	return c
 }
 
 func (c GRPCConnector) Connect() (io.Closer, loggregator_v2.Ingress_BatchSenderClient, error) {
-	for _, balancer := range c.balancers {
-		hostPort, err := balancer.NextHostPort()
-		if err != nil {
-			continue
+	switch c.policy {
+	case FailoverStrict:
+		return c.connect(0)
+	case FailoverRoundRobin:
+		c.state.mu.Lock()
+		start := c.state.next
+		c.state.next = (c.state.next + 1) % len(c.balancers)
+		c.state.mu.Unlock()
+
+		for i := range c.balancers {
+			closer, client, err := c.connect((start + i) % len(c.balancers))
+			if err == nil {
+				return closer, client, nil
+			}
 		}
 
-		return c.fetcher.Fetch(hostPort)
+		return nil, nil, errors.New("unable to lookup a log consumer")
+	}
+
+	closer, client, err := c.connect(0)
+	if err == nil || len(c.balancers) == 1 {
+		return closer, client, err
+	}
+
+	if !c.failingOver() {
+		return nil, nil, err
+	}
+
+	for i := 1; i < len(c.balancers); i++ {
+		closer, client, err := c.connect(i)
+		if err == nil {
+			logger.Warnf("failed over to %s", c.balancers[i].addr)
+			return closer, client, nil
+		}
 	}
 
 	return nil, nil, errors.New("unable to lookup a log consumer")
 }
+
+// Fallback reports whether the address was resolved from a balancer other
+// than the first.
+func (c GRPCConnector) Fallback(addr string) bool {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	return c.state.origins[addr] > 0
+}
+
+func (c GRPCConnector) connect(i int) (io.Closer, loggregator_v2.Ingress_BatchSenderClient, error) {
+	if i >= len(c.balancers) {
+		return nil, nil, errors.New("unable to lookup a log consumer")
+	}
+
+	hostPort, err := c.balancers[i].NextHostPort()
+	if err != nil {
+		c.failed(i)
+		return nil, nil, err
+	}
+
+	closer, client, err := c.fetcher.Fetch(hostPort)
+	if err != nil {
+		c.failed(i)
+		return nil, nil, err
+	}
+
+	c.state.mu.Lock()
+	c.state.origins[hostPort] = i
+	if i == 0 {
+		c.state.failingSince = time.Time{}
+	}
+	c.state.mu.Unlock()
+
+	return closer, client, nil
+}
+
+func (c GRPCConnector) failed(i int) {
+	if i != 0 {
+		return
+	}
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	if c.state.failingSince.IsZero() {
+		c.state.failingSince = time.Now()
+	}
+}
+
+// failingOver reports whether the first balancer has been failing for long
+// enough to fall back to the others.
+func (c GRPCConnector) failingOver() bool {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	return time.Since(c.state.failingSince) >= c.timeout
+}
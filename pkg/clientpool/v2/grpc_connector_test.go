@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	"code.cloudfoundry.org/loggregator-agent/pkg/clientpool/v2"
@@ -89,6 +90,25 @@ var _ = Describe("GRPCConnector", func() {
 		})
 	})
 
+	Context("when a balancer returns an IPv6 address", func() {
+		It("fetches a client with a bracketed address", func() {
+			balancers := []*v2.Balancer{
+				v2.NewBalancer("doppler.com:99", v2.WithLookup(func(string) ([]net.IP, error) {
+					return []net.IP{net.ParseIP("::1")}, nil
+				})),
+			}
+			fetcher := &SpyFetcher{
+				Closer: ioutil.NopCloser(nil),
+				Client: SpyStream{},
+			}
+			connector := v2.MakeGRPCConnector(fetcher, balancers)
+
+			_, _, err := connector.Connect()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fetcher.Addr).To(Equal("[::1]:99"))
+		})
+	})
+
 	Context("when the none balancer return anything", func() {
 		It("returns an error", func() {
 			balancers := []*v2.Balancer{
@@ -109,4 +129,88 @@ var _ = Describe("GRPCConnector", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+
+	Context("with a failover policy", func() {
+		var (
+			fetcher *SpyFetcher
+			failing bool
+		)
+
+		balancers := func() []*v2.Balancer {
+			return []*v2.Balancer{
+				v2.NewBalancer("z1.doppler.com:99", v2.WithLookup(func(string) ([]net.IP, error) {
+					if failing {
+						return nil, errors.New("lookup failed")
+					}
+					return []net.IP{net.ParseIP("10.10.10.1")}, nil
+				})),
+				v2.NewBalancer("doppler.com:99", v2.WithLookup(func(string) ([]net.IP, error) {
+					return []net.IP{net.ParseIP("1.1.1.1")}, nil
+				})),
+			}
+		}
+
+		BeforeEach(func() {
+			failing = false
+			fetcher = &SpyFetcher{
+				Closer: ioutil.NopCloser(nil),
+				Client: SpyStream{},
+			}
+		})
+
+		It("does not fall back with the strict policy", func() {
+			failing = true
+			connector := v2.MakeGRPCConnector(fetcher, balancers(),
+				v2.WithFailoverPolicy(v2.FailoverStrict),
+			)
+
+			_, _, err := connector.Connect()
+			Expect(err).To(HaveOccurred())
+			Expect(fetcher.Addr).To(BeEmpty())
+		})
+
+		It("falls back only after the failover timeout", func() {
+			failing = true
+			connector := v2.MakeGRPCConnector(fetcher, balancers(),
+				v2.WithFailoverTimeout(100*time.Millisecond),
+			)
+
+			_, _, err := connector.Connect()
+			Expect(err).To(HaveOccurred())
+			Expect(fetcher.Addr).To(BeEmpty())
+
+			Eventually(func() error {
+				_, _, err := connector.Connect()
+				return err
+			}).ShouldNot(HaveOccurred())
+			Expect(fetcher.Addr).To(Equal("1.1.1.1:99"))
+		})
+
+		It("alternates balancers with the round robin policy", func() {
+			connector := v2.MakeGRPCConnector(fetcher, balancers(),
+				v2.WithFailoverPolicy(v2.FailoverRoundRobin),
+			)
+
+			var addrs []string
+			for i := 0; i < 4; i++ {
+				_, _, err := connector.Connect()
+				Expect(err).ToNot(HaveOccurred())
+				addrs = append(addrs, fetcher.Addr)
+			}
+
+			Expect(addrs).To(Equal([]string{
+				"10.10.10.1:99", "1.1.1.1:99", "10.10.10.1:99", "1.1.1.1:99",
+			}))
+		})
+
+		It("reports which addresses were fallbacks", func() {
+			connector := v2.MakeGRPCConnector(fetcher, balancers())
+			connector.Connect()
+			Expect(connector.Fallback("10.10.10.1:99")).To(BeFalse())
+
+			failing = true
+			connector.Connect()
+			Expect(connector.Fallback("1.1.1.1:99")).To(BeTrue())
+		})
+	})
 })
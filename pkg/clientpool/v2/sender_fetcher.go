@@ -4,11 +4,11 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
 
 	"google.golang.org/grpc/codes"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
 	plumbing "code.cloudfoundry.org/loggregator-agent/pkg/plumbing/v2"
 
 	"google.golang.org/grpc"
@@ -18,6 +18,9 @@ import (
 type HealthRegistrar interface {
 	Inc(name string)
 	Dec(name string)
+	StreamOpened(addr string)
+	StreamClosed(addr string)
+	StreamFailed(addr string, err error)
 }
 
 type SenderFetcher struct {
@@ -33,65 +36,128 @@ func NewSenderFetcher(r HealthRegistrar, opts ...grpc.DialOption) *SenderFetcher
 }
 
 func (p *SenderFetcher) Fetch(addr string) (io.Closer, loggregator_v2.Ingress_BatchSenderClient, error) {
+	l := logger.With(logging.Fields{"destination": addr})
+	l.Debugf("dialing doppler %s", addr)
+
 	conn, err := grpc.Dial(addr, p.opts...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error dialing ingestor stream to %s: %s", addr, err)
+		err = fmt.Errorf("error dialing ingestor stream to %s: %s", addr, err)
+		p.health.StreamFailed(addr, err)
+		return nil, nil, err
 	}
 
-	sender, err := openStream(conn)
+	sender, features, err := openStream(conn)
 	if err != nil {
 		conn.Close()
+		p.health.StreamFailed(addr, err)
 		return nil, nil, err
 	}
 
 	p.health.Inc("dopplerConnections")
 	p.health.Inc("dopplerV2Streams")
+	p.health.StreamOpened(addr)
 
-	log.Printf("successfully established a stream to doppler %s", addr)
+	l.Debugf("successfully established a stream to doppler %s with features %+v", addr, features)
 
 	closer := &decrementingCloser{
-		closer: conn,
-		health: p.health,
+		addr:     addr,
+		features: features,
+		closer:   conn,
+		health:   p.health,
 	}
 	return closer, sender, err
 }
 
-func openStream(conn *grpc.ClientConn) (loggregator_v2.Ingress_BatchSenderClient, error) {
+// openStream probes the doppler for the features it supports and opens a
+// stream with the newest API it implements. Each API is probed by making an
+// empty call to it.
+func openStream(conn *grpc.ClientConn) (loggregator_v2.Ingress_BatchSenderClient, Features, error) {
 	client := loggregator_v2.NewIngressClient(conn)
-	sender, err := client.BatchSender(context.Background())
+	probe, err := client.BatchSender(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("error establishing ingestor stream to: %s", err)
+		return nil, Features{}, fmt.Errorf("error establishing ingestor stream to: %s", err)
 	}
 
-	_, err = sender.CloseAndRecv()
-	s, ok := status.FromError(err)
-	if ok && s.Code() == codes.Unimplemented {
-		log.Printf("failed to open stream, falling back to deprecated API")
-		client := plumbing.NewDopplerIngressClient(conn)
-		sender, err = client.BatchSender(context.Background())
+	_, err = probe.CloseAndRecv()
+	if !unimplemented(err) {
+		features := Features{
+			API:            IngressAPI,
+			Tags:           true,
+			Batch:          true,
+			MaxMessageSize: maxMessageSize(probe),
+		}
+
+		sender, err := client.BatchSender(context.Background())
 		if err != nil {
-			return nil, fmt.Errorf("error establishing ingestor stream to: %s", err)
+			return nil, Features{}, fmt.Errorf("error establishing ingestor stream to: %s", err)
 		}
 
-		return sender, nil
+		return sender, features, nil
 	}
 
-	sender, err = client.BatchSender(context.Background())
+	logger.Debugf("failed to open stream, falling back to deprecated API")
+	deprecatedClient := plumbing.NewDopplerIngressClient(conn)
+	deprecatedProbe, err := deprecatedClient.BatchSender(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("error establishing ingestor stream to: %s", err)
+		return nil, Features{}, fmt.Errorf("error establishing ingestor stream to: %s", err)
 	}
 
-	return sender, nil
+	_, err = deprecatedProbe.CloseAndRecv()
+	if !unimplemented(err) {
+		features := Features{
+			API:            DopplerIngressAPI,
+			Batch:          true,
+			MaxMessageSize: maxMessageSize(deprecatedProbe),
+		}
+
+		sender, err := deprecatedClient.BatchSender(context.Background())
+		if err != nil {
+			return nil, Features{}, fmt.Errorf("error establishing ingestor stream to: %s", err)
+		}
+
+		return sender, features, nil
+	}
+
+	logger.Debugf("batch API not available, falling back to sending envelopes individually")
+	sender, err := deprecatedClient.Sender(context.Background())
+	if err != nil {
+		return nil, Features{}, fmt.Errorf("error establishing ingestor stream to: %s", err)
+	}
+
+	features := Features{
+		API:            DopplerIngressAPI,
+		MaxMessageSize: defaultMaxMessageSize,
+	}
+
+	return envelopeSender{sender}, features, nil
+}
+
+func unimplemented(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.Unimplemented
 }
 
 type decrementingCloser struct {
-	closer io.Closer
-	health HealthRegistrar
+	addr     string
+	features Features
+	closer   io.Closer
+	health   HealthRegistrar
+}
+
+// Addr returns the address of the doppler the connection was made to.
+func (d *decrementingCloser) Addr() string {
+	return d.addr
+}
+
+// Features returns the features the doppler was found to support.
+func (d *decrementingCloser) Features() Features {
+	return d.features
 }
 
 func (d *decrementingCloser) Close() error {
 	d.health.Dec("dopplerConnections")
 	d.health.Dec("dopplerV2Streams")
+	d.health.StreamClosed(d.addr)
 
 	return d.closer.Close()
 }
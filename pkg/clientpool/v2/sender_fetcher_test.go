@@ -9,6 +9,9 @@ import (
 	plumbing "code.cloudfoundry.org/loggregator-agent/pkg/plumbing/v2"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -47,6 +50,69 @@ var _ = Describe("PusherFetcher", func() {
 		Expect(closer.Close()).To(Succeed())
 	})
 
+	It("negotiates the features of the ingress API", func() {
+		server := newSpyIngestorServer(true)
+		server.maxMessageSize = "1024"
+		Expect(server.Start()).To(Succeed())
+		defer server.Stop()
+
+		fetcher := v2.NewSenderFetcher(newSpyRegistry(), grpc.WithInsecure())
+		closer, _, err := fetcher.Fetch(server.addr)
+		Expect(err).ToNot(HaveOccurred())
+		defer closer.Close()
+
+		Expect(features(closer)).To(Equal(v2.Features{
+			API:            v2.IngressAPI,
+			Tags:           true,
+			Batch:          true,
+			MaxMessageSize: 1024,
+		}))
+	})
+
+	It("negotiates the features of the deprecated API", func() {
+		server := newSpyIngestorServer(false)
+		Expect(server.Start()).To(Succeed())
+		defer server.Stop()
+
+		fetcher := v2.NewSenderFetcher(newSpyRegistry(), grpc.WithInsecure())
+		closer, _, err := fetcher.Fetch(server.addr)
+		Expect(err).ToNot(HaveOccurred())
+		defer closer.Close()
+
+		Expect(features(closer)).To(Equal(v2.Features{
+			API:            v2.DopplerIngressAPI,
+			Batch:          true,
+			MaxMessageSize: 4 * 1024 * 1024,
+		}))
+	})
+
+	It("sends envelopes individually when the batch API is not available", func() {
+		server := newSpyIngestorServer(false)
+		server.excludeDeprecatedBatch = true
+		Expect(server.Start()).To(Succeed())
+		defer server.Stop()
+
+		fetcher := v2.NewSenderFetcher(newSpyRegistry(), grpc.WithInsecure())
+		closer, sender, err := fetcher.Fetch(server.addr)
+		Expect(err).ToNot(HaveOccurred())
+		defer closer.Close()
+		Expect(features(closer).Batch).To(BeFalse())
+
+		err = sender.Send(&loggregator_v2.EnvelopeBatch{
+			Batch: []*loggregator_v2.Envelope{
+				{SourceId: "a"},
+				{SourceId: "b"},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var e *loggregator_v2.Envelope
+		Eventually(server.deprecatedEnvelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("a"))
+		Eventually(server.deprecatedEnvelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("b"))
+	})
+
 	It("increments a counter when a connection is established", func() {
 		server := newSpyIngestorServer(true)
 		Expect(server.Start()).To(Succeed())
@@ -60,6 +126,7 @@ var _ = Describe("PusherFetcher", func() {
 
 		Expect(registry.GetValue("dopplerConnections")).To(Equal(int64(1)))
 		Expect(registry.GetValue("dopplerV2Streams")).To(Equal(int64(1)))
+		Expect(registry.streams).To(HaveKeyWithValue(server.addr, 1))
 	})
 
 	It("decrements a counter when a connection is closed", func() {
@@ -76,25 +143,53 @@ var _ = Describe("PusherFetcher", func() {
 		closer.Close()
 		Expect(registry.GetValue("dopplerConnections")).To(Equal(int64(0)))
 		Expect(registry.GetValue("dopplerV2Streams")).To(Equal(int64(0)))
+		Expect(registry.streams).To(HaveKeyWithValue(server.addr, 0))
 	})
 
 	It("returns an error when the server is unavailable", func() {
-		fetcher := v2.NewSenderFetcher(newSpyRegistry(), grpc.WithInsecure())
+		registry := newSpyRegistry()
+		fetcher := v2.NewSenderFetcher(registry, grpc.WithInsecure())
 		_, _, err := fetcher.Fetch("127.0.0.1:1122")
 		Expect(err).To(HaveOccurred())
+		Expect(registry.failures).To(HaveKey("127.0.0.1:1122"))
 	})
 })
 
+func features(c io.Closer) v2.Features {
+	f, ok := c.(interface {
+		Features() v2.Features
+	})
+	Expect(ok).To(BeTrue())
+
+	return f.Features()
+}
+
 type SpyRegistry struct {
 	counters map[string]int64
+	streams  map[string]int
+	failures map[string]error
 }
 
 func newSpyRegistry() *SpyRegistry {
 	return &SpyRegistry{
 		counters: make(map[string]int64),
+		streams:  make(map[string]int),
+		failures: make(map[string]error),
 	}
 }
 
+func (s *SpyRegistry) StreamOpened(addr string) {
+	s.streams[addr]++
+}
+
+func (s *SpyRegistry) StreamClosed(addr string) {
+	s.streams[addr]--
+}
+
+func (s *SpyRegistry) StreamFailed(addr string, err error) {
+	s.failures[addr] = err
+}
+
 func (s *SpyRegistry) Inc(name string) {
 	s.counters[name] += 1
 }
@@ -113,20 +208,24 @@ func (s *SpyRegistry) GetValue(name string) int64 {
 }
 
 type SpyIngestorServer struct {
-	addr             string
-	server           *grpc.Server
-	stop             chan struct{}
-	deprecatedBatch  chan *loggregator_v2.EnvelopeBatch
-	batch            chan *loggregator_v2.EnvelopeBatch
-	includeV2Ingress bool
+	addr                   string
+	server                 *grpc.Server
+	stop                   chan struct{}
+	deprecatedBatch        chan *loggregator_v2.EnvelopeBatch
+	deprecatedEnvelopes    chan *loggregator_v2.Envelope
+	batch                  chan *loggregator_v2.EnvelopeBatch
+	includeV2Ingress       bool
+	excludeDeprecatedBatch bool
+	maxMessageSize         string
 }
 
 func newSpyIngestorServer(includeV2Ingress bool) *SpyIngestorServer {
 	return &SpyIngestorServer{
-		stop:             make(chan struct{}),
-		batch:            make(chan *loggregator_v2.EnvelopeBatch),
-		deprecatedBatch:  make(chan *loggregator_v2.EnvelopeBatch),
-		includeV2Ingress: includeV2Ingress,
+		stop:                make(chan struct{}),
+		batch:               make(chan *loggregator_v2.EnvelopeBatch),
+		deprecatedBatch:     make(chan *loggregator_v2.EnvelopeBatch),
+		deprecatedEnvelopes: make(chan *loggregator_v2.Envelope, 10),
+		includeV2Ingress:    includeV2Ingress,
 	}
 }
 
@@ -159,18 +258,29 @@ type spyV2DeprecatedIngressServer struct {
 }
 
 func (s *spyV2DeprecatedIngressServer) Sender(srv plumbing.DopplerIngress_SenderServer) error {
-	return nil
+	for {
+		e, err := srv.Recv()
+		if err != nil {
+			return nil
+		}
+
+		s.spyIngestorServer.deprecatedEnvelopes <- e
+	}
 }
 
 func (s *spyV2DeprecatedIngressServer) BatchSender(srv plumbing.DopplerIngress_BatchSenderServer) error {
+	if s.spyIngestorServer.excludeDeprecatedBatch {
+		return status.Error(codes.Unimplemented, "unknown method")
+	}
+
 	for {
 		select {
 		case <-s.spyIngestorServer.stop:
-			break
+			return io.EOF
 		default:
 			b, err := srv.Recv()
 			if err != nil {
-				break
+				return nil
 			}
 
 			s.spyIngestorServer.deprecatedBatch <- b
@@ -191,6 +301,10 @@ func (s *spyV2IngressServer) Sender(srv loggregator_v2.Ingress_SenderServer) err
 }
 
 func (s *spyV2IngressServer) BatchSender(srv loggregator_v2.Ingress_BatchSenderServer) error {
+	if s.spyIngestorServer.maxMessageSize != "" {
+		srv.SendHeader(metadata.Pairs(v2.MaxMessageSizeHeader, s.spyIngestorServer.maxMessageSize))
+	}
+
 	for {
 		select {
 		case <-srv.Context().Done():
@@ -0,0 +1,79 @@
+package diodes
+
+import (
+	"sync/atomic"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// BlockingEnvelopeV2 is a bounded queue of V2 envelopes for many writers and
+// a single reader. Unlike a diode it never drops envelopes: Set blocks while
+// the queue is full, which pushes back on the writer.
+type BlockingEnvelopeV2 struct {
+	blocked uint64
+
+	queue chan *loggregator_v2.Envelope
+}
+
+// NewBlockingEnvelopeV2 returns a new BlockingEnvelopeV2 that holds size
+// envelopes.
+func NewBlockingEnvelopeV2(size int) *BlockingEnvelopeV2 {
+	return &BlockingEnvelopeV2{
+		queue: make(chan *loggregator_v2.Envelope, size),
+	}
+}
+
+// Set inserts the given V2 envelope into the queue. If the queue is full it
+// blocks until the reader makes room.
+func (d *BlockingEnvelopeV2) Set(data *loggregator_v2.Envelope) {
+	select {
+	case d.queue <- data:
+	default:
+		atomic.AddUint64(&d.blocked, 1)
+		d.queue <- data
+	}
+}
+
+// TryNext returns the next V2 envelope to be read from the queue. If the
+// queue is empty it will return a nil envelope and false for the bool.
+func (d *BlockingEnvelopeV2) TryNext() (*loggregator_v2.Envelope, bool) {
+	select {
+	case e := <-d.queue:
+		return e, true
+	default:
+		return nil, false
+	}
+}
+
+// Next will return the next V2 envelope to be read from the queue. If the
+// queue is empty this method will block until an envelope is available to be
+// read.
+func (d *BlockingEnvelopeV2) Next() *loggregator_v2.Envelope {
+	return <-d.queue
+}
+
+// Depth returns the number of envelopes waiting to be read.
+func (d *BlockingEnvelopeV2) Depth() int {
+	return len(d.queue)
+}
+
+// Size returns the number of envelopes the queue can hold.
+func (d *BlockingEnvelopeV2) Size() int {
+	return cap(d.queue)
+}
+
+// Blocked returns the number of times Set found the queue full and had to
+// wait for the reader.
+func (d *BlockingEnvelopeV2) Blocked() uint64 {
+	return atomic.LoadUint64(&d.blocked)
+}
+
+// Dropped always returns zero as the queue never drops envelopes.
+func (d *BlockingEnvelopeV2) Dropped() uint64 {
+	return 0
+}
+
+// RecentlyDropped always returns zero as the queue never drops envelopes.
+func (d *BlockingEnvelopeV2) RecentlyDropped() uint64 {
+	return 0
+}
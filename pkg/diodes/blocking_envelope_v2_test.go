@@ -0,0 +1,54 @@
+package diodes_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BlockingEnvelopeV2", func() {
+	var d *diodes.BlockingEnvelopeV2
+
+	BeforeEach(func() {
+		d = diodes.NewBlockingEnvelopeV2(2)
+	})
+
+	It("returns envelopes in the order they were set", func() {
+		d.Set(&loggregator_v2.Envelope{SourceId: "a"})
+		d.Set(&loggregator_v2.Envelope{SourceId: "b"})
+		Expect(d.Size()).To(Equal(2))
+		Expect(d.Depth()).To(Equal(2))
+
+		e, ok := d.TryNext()
+		Expect(ok).To(BeTrue())
+		Expect(e.SourceId).To(Equal("a"))
+		Expect(d.Next().SourceId).To(Equal("b"))
+
+		_, ok = d.TryNext()
+		Expect(ok).To(BeFalse())
+		Expect(d.Depth()).To(Equal(0))
+	})
+
+	It("blocks writers while full instead of dropping", func() {
+		d.Set(&loggregator_v2.Envelope{})
+		d.Set(&loggregator_v2.Envelope{})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			d.Set(&loggregator_v2.Envelope{SourceId: "late"})
+		}()
+
+		Consistently(done).ShouldNot(BeClosed())
+		Expect(d.Blocked()).To(Equal(uint64(1)))
+
+		d.Next()
+		Eventually(done).Should(BeClosed())
+
+		d.Next()
+		Expect(d.Next().SourceId).To(Equal("late"))
+		Expect(d.Dropped()).To(BeZero())
+	})
+})
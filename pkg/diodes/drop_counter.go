@@ -0,0 +1,74 @@
+package diodes
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+)
+
+// recentWindow is the length of time over which RecentlyDropped counts
+// drops.
+const recentWindow = 60
+
+// dropCounter wraps an alerter and records the total number of dropped items
+// along with the number dropped in each of the last 60 seconds.
+type dropCounter struct {
+	total   uint64
+	alerter gendiodes.Alerter
+	now     func() time.Time
+
+	mu      sync.Mutex
+	buckets [recentWindow]dropBucket
+}
+
+type dropBucket struct {
+	second int64
+	count  uint64
+}
+
+func newDropCounter(a gendiodes.Alerter) *dropCounter {
+	return &dropCounter{
+		alerter: a,
+		now:     time.Now,
+	}
+}
+
+func (c *dropCounter) Alert(missed int) {
+	atomic.AddUint64(&c.total, uint64(missed))
+
+	sec := c.now().Unix()
+	c.mu.Lock()
+	b := &c.buckets[sec%recentWindow]
+	if b.second != sec {
+		b.second = sec
+		b.count = 0
+	}
+	b.count += uint64(missed)
+	c.mu.Unlock()
+
+	if c.alerter != nil {
+		c.alerter.Alert(missed)
+	}
+}
+
+func (c *dropCounter) dropped() uint64 {
+	return atomic.LoadUint64(&c.total)
+}
+
+func (c *dropCounter) recentlyDropped() uint64 {
+	sec := c.now().Unix()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n uint64
+	for _, b := range c.buckets {
+		if sec-b.second < recentWindow {
+			n += b.count
+		}
+	}
+
+	return n
+}
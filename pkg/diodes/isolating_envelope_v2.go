@@ -0,0 +1,221 @@
+package diodes
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// maxIsolationCandidates is the number of distinct source IDs an
+// IsolatingEnvelopeV2 counts between rebalances. Source IDs seen after the
+// limit is reached are not considered for isolation until the next window.
+const maxIsolationCandidates = 10000
+
+// IsolatingEnvelopeV2 gives the noisiest source IDs their own bounded
+// ManyToOneEnvelopeV2 diodes so that a flooding source overwrites only its
+// own envelopes. Every other source shares a single diode.
+type IsolatingEnvelopeV2 struct {
+	cursor int
+
+	shared   *ManyToOneEnvelopeV2
+	isolated []*ManyToOneEnvelopeV2
+
+	// assigned maps the isolated source IDs to their diode. It is replaced
+	// rather than modified so Set can read it without locking.
+	assigned atomic.Value
+
+	mu     sync.RWMutex
+	counts map[string]*uint64
+}
+
+// NewIsolatingEnvelopeV2 returns an IsolatingEnvelopeV2 with a shared diode
+// holding size envelopes and up to isolated diodes holding isolatedSize
+// envelopes each.
+func NewIsolatingEnvelopeV2(size, isolated, isolatedSize int, alerter gendiodes.Alerter) *IsolatingEnvelopeV2 {
+	d := &IsolatingEnvelopeV2{
+		shared:   NewManyToOneEnvelopeV2(size, alerter),
+		isolated: make([]*ManyToOneEnvelopeV2, isolated),
+		counts:   make(map[string]*uint64),
+	}
+	for i := range d.isolated {
+		d.isolated[i] = NewManyToOneEnvelopeV2(isolatedSize, alerter)
+	}
+	d.assigned.Store(map[string]*ManyToOneEnvelopeV2{})
+
+	return d
+}
+
+// Set inserts the given V2 envelope into the diode of its source ID, or the
+// shared diode if the source is not isolated.
+func (d *IsolatingEnvelopeV2) Set(data *loggregator_v2.Envelope) {
+	id := data.GetSourceId()
+	if c := d.counter(id); c != nil {
+		atomic.AddUint64(c, 1)
+	}
+
+	if b, ok := d.assigned.Load().(map[string]*ManyToOneEnvelopeV2)[id]; ok {
+		b.Set(data)
+		return
+	}
+	d.shared.Set(data)
+}
+
+// Rebalance isolates the source IDs that set the most envelopes since the
+// last rebalance and starts a new counting window. Envelopes already in the
+// diode of a source that is no longer isolated are still read.
+func (d *IsolatingEnvelopeV2) Rebalance() {
+	d.mu.Lock()
+	counts := d.counts
+	d.counts = make(map[string]*uint64, len(counts))
+	d.mu.Unlock()
+
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		ci, cj := atomic.LoadUint64(counts[ids[i]]), atomic.LoadUint64(counts[ids[j]])
+		if ci == cj {
+			return ids[i] < ids[j]
+		}
+		return ci > cj
+	})
+	if len(ids) > len(d.isolated) {
+		ids = ids[:len(d.isolated)]
+	}
+
+	// Sources that stay isolated keep their diode so that their envelopes
+	// stay in order.
+	prev := d.assigned.Load().(map[string]*ManyToOneEnvelopeV2)
+	next := make(map[string]*ManyToOneEnvelopeV2, len(ids))
+	used := make(map[*ManyToOneEnvelopeV2]bool, len(ids))
+	for _, id := range ids {
+		if b, ok := prev[id]; ok {
+			next[id] = b
+			used[b] = true
+		}
+	}
+
+	free := make([]*ManyToOneEnvelopeV2, 0, len(d.isolated))
+	for _, b := range d.isolated {
+		if !used[b] {
+			free = append(free, b)
+		}
+	}
+	for _, id := range ids {
+		if _, ok := next[id]; !ok {
+			next[id], free = free[0], free[1:]
+		}
+	}
+
+	d.assigned.Store(next)
+}
+
+// Isolated returns the source IDs that currently have their own diode.
+func (d *IsolatingEnvelopeV2) Isolated() []string {
+	assigned := d.assigned.Load().(map[string]*ManyToOneEnvelopeV2)
+
+	ids := make([]string, 0, len(assigned))
+	for id := range assigned {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// TryNext returns the next V2 envelope from the shared and isolated diodes
+// in turn. If every diode is empty it will return a nil envelope and false
+// for the bool.
+func (d *IsolatingEnvelopeV2) TryNext() (*loggregator_v2.Envelope, bool) {
+	for i := 0; i <= len(d.isolated); i++ {
+		b := d.diode(d.cursor)
+		d.cursor = (d.cursor + 1) % (len(d.isolated) + 1)
+
+		if e, ok := b.TryNext(); ok {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+// Depth returns the approximate number of envelopes waiting to be read
+// across every diode.
+func (d *IsolatingEnvelopeV2) Depth() int {
+	n := d.shared.Depth()
+	for _, b := range d.isolated {
+		n += b.Depth()
+	}
+
+	return n
+}
+
+// Size returns the number of envelopes the diodes can hold together.
+func (d *IsolatingEnvelopeV2) Size() int {
+	n := d.shared.Size()
+	for _, b := range d.isolated {
+		n += b.Size()
+	}
+
+	return n
+}
+
+// Dropped returns the total number of envelopes dropped by the diodes.
+func (d *IsolatingEnvelopeV2) Dropped() uint64 {
+	n := d.shared.Dropped()
+	for _, b := range d.isolated {
+		n += b.Dropped()
+	}
+
+	return n
+}
+
+// RecentlyDropped returns the number of envelopes dropped by the diodes in
+// the last minute.
+func (d *IsolatingEnvelopeV2) RecentlyDropped() uint64 {
+	n := d.shared.RecentlyDropped()
+	for _, b := range d.isolated {
+		n += b.RecentlyDropped()
+	}
+
+	return n
+}
+
+func (d *IsolatingEnvelopeV2) diode(i int) *ManyToOneEnvelopeV2 {
+	if i == 0 {
+		return d.shared
+	}
+
+	return d.isolated[i-1]
+}
+
+// counter returns the counter for the source ID in the current window, or
+// nil if the window is tracking too many sources already.
+func (d *IsolatingEnvelopeV2) counter(id string) *uint64 {
+	d.mu.RLock()
+	c, ok := d.counts[id]
+	d.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if c, ok := d.counts[id]; ok {
+		return c
+	}
+
+	if len(d.counts) >= maxIsolationCandidates {
+		return nil
+	}
+
+	c = new(uint64)
+	d.counts[id] = c
+
+	return c
+}
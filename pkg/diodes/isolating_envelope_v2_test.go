@@ -0,0 +1,74 @@
+package diodes_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IsolatingEnvelopeV2", func() {
+	var d *diodes.IsolatingEnvelopeV2
+
+	set := func(id string, n int) {
+		for i := 0; i < n; i++ {
+			d.Set(&loggregator_v2.Envelope{SourceId: id})
+		}
+	}
+
+	drain := func() map[string]int {
+		counts := make(map[string]int)
+		for {
+			e, ok := d.TryNext()
+			if !ok {
+				return counts
+			}
+			counts[e.GetSourceId()]++
+		}
+	}
+
+	BeforeEach(func() {
+		d = diodes.NewIsolatingEnvelopeV2(10, 1, 5, nil)
+	})
+
+	It("shares a single diode until sources are isolated", func() {
+		set("noisy", 20)
+		set("quiet", 3)
+
+		Expect(d.Isolated()).To(BeEmpty())
+		Expect(d.Size()).To(Equal(15))
+		Expect(drain()).To(Equal(map[string]int{"quiet": 3, "noisy": 7}))
+		Expect(d.Dropped()).To(Equal(uint64(13)))
+	})
+
+	It("isolates the noisiest sources so they only overwrite themselves", func() {
+		set("noisy", 20)
+		set("quiet", 3)
+		drain()
+		d.Rebalance()
+		Expect(d.Isolated()).To(ConsistOf("noisy"))
+
+		set("quiet", 3)
+		set("noisy", 20)
+
+		Expect(d.Depth()).To(Equal(8))
+		Expect(drain()).To(Equal(map[string]int{"quiet": 3, "noisy": 5}))
+	})
+
+	It("releases sources that are no longer the noisiest", func() {
+		set("a", 5)
+		set("b", 1)
+		d.Rebalance()
+		Expect(d.Isolated()).To(ConsistOf("a"))
+
+		set("a", 1)
+		set("b", 5)
+		d.Rebalance()
+		Expect(d.Isolated()).To(ConsistOf("b"))
+
+		drain()
+		set("a", 3)
+		Expect(drain()).To(Equal(map[string]int{"a": 3}))
+	})
+})
@@ -1,6 +1,8 @@
 package diodes
 
 import (
+	"sync/atomic"
+
 	gendiodes "code.cloudfoundry.org/go-diodes"
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 )
@@ -8,20 +10,30 @@ import (
 // ManyToOneEnvelopeV2 diode is optimal for many writers and a single reader for
 // V2 envelopes.
 type ManyToOneEnvelopeV2 struct {
-	d *gendiodes.Waiter
+	sets  uint64
+	reads uint64
+
+	d     *gendiodes.Waiter
+	size  int
+	drops *dropCounter
 }
 
 // NewManyToOneEnvelopeV2 returns a new ManyToOneEnvelopeV2 diode to be used
 // with many writers and a single reader.
 func NewManyToOneEnvelopeV2(size int, alerter gendiodes.Alerter) *ManyToOneEnvelopeV2 {
+	drops := newDropCounter(alerter)
+
 	return &ManyToOneEnvelopeV2{
-		d: gendiodes.NewWaiter(gendiodes.NewManyToOne(size, alerter)),
+		d:     gendiodes.NewWaiter(gendiodes.NewManyToOne(size, drops)),
+		size:  size,
+		drops: drops,
 	}
 }
 
 // Set inserts the given V2 envelope into the diode.
 func (d *ManyToOneEnvelopeV2) Set(data *loggregator_v2.Envelope) {
 	d.d.Set(gendiodes.GenericDataType(data))
+	atomic.AddUint64(&d.sets, 1)
 }
 
 // TryNext returns the next V2 envelope to be read from the diode. If the
@@ -31,6 +43,7 @@ func (d *ManyToOneEnvelopeV2) TryNext() (*loggregator_v2.Envelope, bool) {
 	if !ok {
 		return nil, ok
 	}
+	atomic.AddUint64(&d.reads, 1)
 
 	return (*loggregator_v2.Envelope)(data), true
 }
@@ -40,5 +53,41 @@ func (d *ManyToOneEnvelopeV2) TryNext() (*loggregator_v2.Envelope, bool) {
 // read.
 func (d *ManyToOneEnvelopeV2) Next() *loggregator_v2.Envelope {
 	data := d.d.Next()
+	atomic.AddUint64(&d.reads, 1)
+
 	return (*loggregator_v2.Envelope)(data)
 }
+
+// Depth returns the approximate number of envelopes waiting to be read.
+// Envelopes that have been overwritten but not yet reported as dropped are
+// included, so the depth never exceeds the size of the diode.
+func (d *ManyToOneEnvelopeV2) Depth() int {
+	handled := atomic.LoadUint64(&d.reads) + d.drops.dropped()
+	sets := atomic.LoadUint64(&d.sets)
+	if handled >= sets {
+		return 0
+	}
+
+	depth := sets - handled
+	if depth > uint64(d.size) {
+		return d.size
+	}
+
+	return int(depth)
+}
+
+// Size returns the number of envelopes the diode can hold.
+func (d *ManyToOneEnvelopeV2) Size() int {
+	return d.size
+}
+
+// Dropped returns the total number of envelopes dropped by the diode.
+func (d *ManyToOneEnvelopeV2) Dropped() uint64 {
+	return d.drops.dropped()
+}
+
+// RecentlyDropped returns the number of envelopes dropped by the diode in
+// the last minute.
+func (d *ManyToOneEnvelopeV2) RecentlyDropped() uint64 {
+	return d.drops.recentlyDropped()
+}
@@ -0,0 +1,59 @@
+package diodes_test
+
+import (
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ManyToOneEnvelopeV2", func() {
+	var (
+		d      *diodes.ManyToOneEnvelopeV2
+		missed int
+	)
+
+	BeforeEach(func() {
+		missed = 0
+		d = diodes.NewManyToOneEnvelopeV2(10, gendiodes.AlertFunc(func(m int) {
+			missed += m
+		}))
+	})
+
+	It("reports the number of envelopes waiting to be read", func() {
+		Expect(d.Size()).To(Equal(10))
+		Expect(d.Depth()).To(Equal(0))
+
+		for i := 0; i < 3; i++ {
+			d.Set(&loggregator_v2.Envelope{})
+		}
+		Expect(d.Depth()).To(Equal(3))
+
+		_, ok := d.TryNext()
+		Expect(ok).To(BeTrue())
+		Expect(d.Depth()).To(Equal(2))
+
+		d.Next()
+		Expect(d.Depth()).To(Equal(1))
+	})
+
+	It("reports dropped envelopes and still calls the alerter", func() {
+		for i := 0; i < 15; i++ {
+			d.Set(&loggregator_v2.Envelope{})
+		}
+		Expect(d.Depth()).To(Equal(10))
+
+		for {
+			if _, ok := d.TryNext(); !ok {
+				break
+			}
+		}
+
+		Expect(d.Depth()).To(Equal(0))
+		Expect(d.Dropped()).To(Equal(uint64(5)))
+		Expect(d.RecentlyDropped()).To(Equal(uint64(5)))
+		Expect(missed).To(Equal(5))
+	})
+})
@@ -0,0 +1,206 @@
+package diodes
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// lengthPrefixSize is the number of bytes used to store the length of each
+// envelope in the ring.
+const lengthPrefixSize = 4
+
+// MMapEnvelopeV2 is a ring buffer of V2 envelopes stored in memory mapped
+// outside of the Go heap. Envelopes are marshalled when they are set and
+// unmarshalled when they are read, so large buffers do not add to garbage
+// collection pressure. When the ring is full the oldest envelopes are
+// dropped to make room for new ones.
+type MMapEnvelopeV2 struct {
+	drops *dropCounter
+
+	mu    sync.Mutex
+	buf   []byte
+	read  uint64
+	write uint64
+	count int
+}
+
+// NewMMapEnvelopeV2 maps a ring buffer of the given size in bytes. The
+// alerter is called with the number of envelopes dropped whenever envelopes
+// are dropped.
+func NewMMapEnvelopeV2(size int, alerter gendiodes.Alerter) (*MMapEnvelopeV2, error) {
+	if size <= lengthPrefixSize {
+		return nil, errors.New("mmap buffer size is too small")
+	}
+
+	buf, err := mmap(size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MMapEnvelopeV2{
+		buf:   buf,
+		drops: newDropCounter(alerter),
+	}, nil
+}
+
+// Set marshals the given envelope into the ring. Envelopes that fail to
+// marshal or are larger than the ring are dropped.
+func (d *MMapEnvelopeV2) Set(e *loggregator_v2.Envelope) {
+	data, err := proto.Marshal(e)
+	if err != nil {
+		d.drops.Alert(1)
+		return
+	}
+
+	dropped := d.set(data)
+	if dropped > 0 {
+		d.drops.Alert(dropped)
+	}
+}
+
+func (d *MMapEnvelopeV2) set(data []byte) int {
+	need := uint64(lengthPrefixSize + len(data))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	size := uint64(len(d.buf))
+	if need > size {
+		return 1
+	}
+
+	var dropped int
+	for d.write-d.read+need > size {
+		d.discard()
+		dropped++
+	}
+
+	var prefix [lengthPrefixSize]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(len(data)))
+	d.copyIn(prefix[:])
+	d.copyIn(data)
+	d.count++
+
+	return dropped
+}
+
+// TryNext returns the next V2 envelope to be read from the ring. If the ring
+// is empty it will return a nil envelope and false for the bool.
+func (d *MMapEnvelopeV2) TryNext() (*loggregator_v2.Envelope, bool) {
+	for {
+		data, ok := d.next()
+		if !ok {
+			return nil, false
+		}
+
+		var e loggregator_v2.Envelope
+		if err := proto.Unmarshal(data, &e); err != nil {
+			d.drops.Alert(1)
+			continue
+		}
+
+		return &e, true
+	}
+}
+
+// Next will return the next V2 envelope to be read from the ring. If the
+// ring is empty this method will block until an envelope is available to be
+// read.
+func (d *MMapEnvelopeV2) Next() *loggregator_v2.Envelope {
+	for {
+		e, ok := d.TryNext()
+		if ok {
+			return e
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (d *MMapEnvelopeV2) next() ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.count == 0 {
+		return nil, false
+	}
+
+	n := d.peekLen()
+	data := make([]byte, n)
+	d.copyOut(data, d.read+lengthPrefixSize)
+	d.read += lengthPrefixSize + uint64(n)
+	d.count--
+
+	return data, true
+}
+
+// Depth returns the number of envelopes waiting to be read.
+func (d *MMapEnvelopeV2) Depth() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.count
+}
+
+// Size returns the size of the ring in bytes.
+func (d *MMapEnvelopeV2) Size() int {
+	return len(d.buf)
+}
+
+// Dropped returns the total number of envelopes dropped by the ring.
+func (d *MMapEnvelopeV2) Dropped() uint64 {
+	return d.drops.dropped()
+}
+
+// RecentlyDropped returns the number of envelopes dropped by the ring in the
+// last minute.
+func (d *MMapEnvelopeV2) RecentlyDropped() uint64 {
+	return d.drops.recentlyDropped()
+}
+
+// Close unmaps the ring. The ring must not be used after it is closed.
+func (d *MMapEnvelopeV2) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	buf := d.buf
+	d.buf = nil
+	d.count = 0
+
+	return munmap(buf)
+}
+
+// discard drops the oldest envelope in the ring.
+func (d *MMapEnvelopeV2) discard() {
+	d.read += lengthPrefixSize + uint64(d.peekLen())
+	d.count--
+}
+
+func (d *MMapEnvelopeV2) peekLen() uint32 {
+	var prefix [lengthPrefixSize]byte
+	d.copyOut(prefix[:], d.read)
+
+	return binary.BigEndian.Uint32(prefix[:])
+}
+
+// copyIn writes p at the write offset, wrapping around the end of the ring.
+func (d *MMapEnvelopeV2) copyIn(p []byte) {
+	start := d.write % uint64(len(d.buf))
+	n := copy(d.buf[start:], p)
+	copy(d.buf, p[n:])
+	d.write += uint64(len(p))
+}
+
+// copyOut reads len(p) bytes starting at offset, wrapping around the end of
+// the ring.
+func (d *MMapEnvelopeV2) copyOut(p []byte, offset uint64) {
+	start := offset % uint64(len(d.buf))
+	n := copy(p, d.buf[start:])
+	copy(p[n:], d.buf)
+}
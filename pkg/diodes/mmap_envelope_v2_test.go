@@ -0,0 +1,112 @@
+package diodes_test
+
+import (
+	"fmt"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+	"github.com/golang/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MMapEnvelopeV2", func() {
+	var (
+		d      *diodes.MMapEnvelopeV2
+		missed int
+	)
+
+	newRing := func(size int) *diodes.MMapEnvelopeV2 {
+		r, err := diodes.NewMMapEnvelopeV2(size, gendiodes.AlertFunc(func(m int) {
+			missed += m
+		}))
+		Expect(err).ToNot(HaveOccurred())
+
+		return r
+	}
+
+	BeforeEach(func() {
+		missed = 0
+		d = newRing(1024)
+	})
+
+	AfterEach(func() {
+		Expect(d.Close()).To(Succeed())
+	})
+
+	It("returns envelopes in the order they were set", func() {
+		for i := 0; i < 3; i++ {
+			d.Set(buildEnvelope(i))
+		}
+		Expect(d.Depth()).To(Equal(3))
+
+		for i := 0; i < 3; i++ {
+			e, ok := d.TryNext()
+			Expect(ok).To(BeTrue())
+			Expect(proto.Equal(e, buildEnvelope(i))).To(BeTrue())
+		}
+
+		_, ok := d.TryNext()
+		Expect(ok).To(BeFalse())
+		Expect(d.Depth()).To(Equal(0))
+	})
+
+	It("wraps around the end of the ring", func() {
+		for i := 0; i < 1000; i++ {
+			d.Set(buildEnvelope(i))
+
+			e := d.Next()
+			Expect(proto.Equal(e, buildEnvelope(i))).To(BeTrue())
+		}
+
+		Expect(d.Dropped()).To(BeZero())
+	})
+
+	It("drops the oldest envelopes when full", func() {
+		envelopeSize := 4 + proto.Size(buildEnvelope(0))
+		capacity := 1024 / envelopeSize
+
+		for i := 0; i < capacity+5; i++ {
+			d.Set(buildEnvelope(i))
+		}
+
+		Expect(d.Depth()).To(Equal(capacity))
+		Expect(d.Dropped()).To(Equal(uint64(5)))
+		Expect(d.RecentlyDropped()).To(Equal(uint64(5)))
+		Expect(missed).To(Equal(5))
+
+		e, ok := d.TryNext()
+		Expect(ok).To(BeTrue())
+		Expect(proto.Equal(e, buildEnvelope(5))).To(BeTrue())
+	})
+
+	It("drops envelopes larger than the ring", func() {
+		d.Set(&loggregator_v2.Envelope{
+			SourceId: string(make([]byte, 2048)),
+		})
+
+		Expect(d.Depth()).To(Equal(0))
+		Expect(d.Dropped()).To(Equal(uint64(1)))
+	})
+
+	It("reports its size in bytes", func() {
+		Expect(d.Size()).To(Equal(1024))
+	})
+
+	It("returns an error for a size too small to hold an envelope", func() {
+		_, err := diodes.NewMMapEnvelopeV2(4, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func buildEnvelope(i int) *loggregator_v2.Envelope {
+	return &loggregator_v2.Envelope{
+		SourceId:  fmt.Sprintf("source-%04d", i),
+		Timestamp: int64(1000 + i),
+		Message: &loggregator_v2.Envelope_Log{
+			Log: &loggregator_v2.Log{Payload: []byte("some-payload")},
+		},
+	}
+}
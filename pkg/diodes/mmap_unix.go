@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package diodes
+
+import "syscall"
+
+func mmap(size int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+func munmap(b []byte) error {
+	if b == nil {
+		return nil
+	}
+
+	return syscall.Munmap(b)
+}
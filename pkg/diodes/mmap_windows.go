@@ -0,0 +1,11 @@
+package diodes
+
+import "errors"
+
+func mmap(size int) ([]byte, error) {
+	return nil, errors.New("mmap buffers are not supported on windows")
+}
+
+func munmap(b []byte) error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package diodes
+
+import (
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// PriorityEnvelopeV2 buffers V2 envelopes in two ManyToOneEnvelopeV2 diodes
+// so that metrics survive log storms. Log envelopes are low priority and
+// every other envelope is high priority. High priority envelopes are read
+// first, and log envelopes are shed once the buffer is three quarters full.
+type PriorityEnvelopeV2 struct {
+	size   int
+	shedAt int
+
+	high  *ManyToOneEnvelopeV2
+	low   *ManyToOneEnvelopeV2
+	sheds *dropCounter
+}
+
+// NewPriorityEnvelopeV2 returns a new PriorityEnvelopeV2 that holds size
+// envelopes. Envelopes that are shed or overwritten are reported to the
+// alerter.
+func NewPriorityEnvelopeV2(size int, alerter gendiodes.Alerter) *PriorityEnvelopeV2 {
+	return &PriorityEnvelopeV2{
+		size:   size,
+		shedAt: size * 3 / 4,
+		high:   NewManyToOneEnvelopeV2(size, alerter),
+		low:    NewManyToOneEnvelopeV2(size, alerter),
+		sheds:  newDropCounter(alerter),
+	}
+}
+
+// Set inserts the given V2 envelope into the diode for its priority. Log
+// envelopes are dropped instead while the buffer is nearly full.
+func (d *PriorityEnvelopeV2) Set(data *loggregator_v2.Envelope) {
+	if data.GetLog() == nil {
+		d.high.Set(data)
+		return
+	}
+
+	if d.high.Depth()+d.low.Depth() >= d.shedAt {
+		d.sheds.Alert(1)
+		return
+	}
+	d.low.Set(data)
+}
+
+// TryNext returns the next high priority V2 envelope, or the next log
+// envelope if there are none. If the buffer is empty it will return a nil
+// envelope and false for the bool.
+func (d *PriorityEnvelopeV2) TryNext() (*loggregator_v2.Envelope, bool) {
+	if e, ok := d.high.TryNext(); ok {
+		return e, true
+	}
+
+	return d.low.TryNext()
+}
+
+// Depth returns the approximate number of envelopes waiting to be read. It
+// never exceeds the size of the buffer.
+func (d *PriorityEnvelopeV2) Depth() int {
+	n := d.high.Depth() + d.low.Depth()
+	if n > d.size {
+		return d.size
+	}
+
+	return n
+}
+
+// Size returns the number of envelopes the buffer can hold.
+func (d *PriorityEnvelopeV2) Size() int {
+	return d.size
+}
+
+// Shed returns the number of log envelopes dropped to make room for
+// metrics.
+func (d *PriorityEnvelopeV2) Shed() uint64 {
+	return d.sheds.dropped()
+}
+
+// Dropped returns the total number of envelopes shed or overwritten.
+func (d *PriorityEnvelopeV2) Dropped() uint64 {
+	return d.high.Dropped() + d.low.Dropped() + d.sheds.dropped()
+}
+
+// RecentlyDropped returns the number of envelopes shed or overwritten in the
+// last minute.
+func (d *PriorityEnvelopeV2) RecentlyDropped() uint64 {
+	return d.high.RecentlyDropped() + d.low.RecentlyDropped() + d.sheds.recentlyDropped()
+}
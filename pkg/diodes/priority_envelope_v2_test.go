@@ -0,0 +1,71 @@
+package diodes_test
+
+import (
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PriorityEnvelopeV2", func() {
+	var (
+		d      *diodes.PriorityEnvelopeV2
+		missed int
+	)
+
+	logEnvelope := func() *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId: "log",
+			Message:  &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{}},
+		}
+	}
+
+	counterEnvelope := func() *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId: "counter",
+			Message:  &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{}},
+		}
+	}
+
+	BeforeEach(func() {
+		missed = 0
+		d = diodes.NewPriorityEnvelopeV2(8, gendiodes.AlertFunc(func(m int) {
+			missed += m
+		}))
+	})
+
+	It("reads metrics before logs", func() {
+		d.Set(logEnvelope())
+		d.Set(counterEnvelope())
+
+		e, ok := d.TryNext()
+		Expect(ok).To(BeTrue())
+		Expect(e.SourceId).To(Equal("counter"))
+
+		e, ok = d.TryNext()
+		Expect(ok).To(BeTrue())
+		Expect(e.SourceId).To(Equal("log"))
+
+		_, ok = d.TryNext()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("sheds logs once the buffer is nearly full", func() {
+		for i := 0; i < 10; i++ {
+			d.Set(logEnvelope())
+		}
+		Expect(d.Depth()).To(Equal(6))
+		Expect(d.Shed()).To(Equal(uint64(4)))
+
+		d.Set(counterEnvelope())
+		d.Set(counterEnvelope())
+		Expect(d.Depth()).To(Equal(8))
+		Expect(d.Shed()).To(Equal(uint64(4)))
+
+		Expect(d.Dropped()).To(Equal(uint64(4)))
+		Expect(d.RecentlyDropped()).To(Equal(uint64(4)))
+		Expect(missed).To(Equal(4))
+	})
+})
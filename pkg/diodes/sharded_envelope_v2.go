@@ -0,0 +1,123 @@
+package diodes
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// ShardPolicy is how a ShardedEnvelopeV2 picks the shard for an envelope.
+type ShardPolicy int
+
+const (
+	// ShardBySourceID sets every envelope with the same source ID on the
+	// same shard, which keeps the envelopes of a source in order.
+	ShardBySourceID ShardPolicy = iota
+
+	// ShardRoundRobin spreads envelopes evenly across the shards.
+	ShardRoundRobin
+)
+
+// ShardedEnvelopeV2 spreads V2 envelopes across several ManyToOneEnvelopeV2
+// diodes so that each can be drained by its own reader.
+type ShardedEnvelopeV2 struct {
+	next   uint64
+	cursor int
+
+	policy ShardPolicy
+	shards []*ManyToOneEnvelopeV2
+}
+
+// NewShardedEnvelopeV2 returns a ShardedEnvelopeV2 with the given number of
+// shards, each holding size envelopes.
+func NewShardedEnvelopeV2(shards, size int, policy ShardPolicy, alerter gendiodes.Alerter) *ShardedEnvelopeV2 {
+	d := &ShardedEnvelopeV2{
+		policy: policy,
+		shards: make([]*ManyToOneEnvelopeV2, shards),
+	}
+	for i := range d.shards {
+		d.shards[i] = NewManyToOneEnvelopeV2(size, alerter)
+	}
+
+	return d
+}
+
+// Set inserts the given V2 envelope into one of the shards.
+func (d *ShardedEnvelopeV2) Set(data *loggregator_v2.Envelope) {
+	d.shards[d.shardFor(data)].Set(data)
+}
+
+// TryNext returns the next V2 envelope from the shards in turn. It must not
+// be used while the shards are being read directly.
+func (d *ShardedEnvelopeV2) TryNext() (*loggregator_v2.Envelope, bool) {
+	for range d.shards {
+		s := d.shards[d.cursor]
+		d.cursor = (d.cursor + 1) % len(d.shards)
+
+		if e, ok := s.TryNext(); ok {
+			return e, true
+		}
+	}
+
+	return nil, false
+}
+
+// Shards returns the shards so that each can be read by its own reader.
+func (d *ShardedEnvelopeV2) Shards() []*ManyToOneEnvelopeV2 {
+	return d.shards
+}
+
+// Depth returns the approximate number of envelopes waiting to be read
+// across every shard.
+func (d *ShardedEnvelopeV2) Depth() int {
+	var n int
+	for _, s := range d.shards {
+		n += s.Depth()
+	}
+
+	return n
+}
+
+// Size returns the number of envelopes the shards can hold together.
+func (d *ShardedEnvelopeV2) Size() int {
+	var n int
+	for _, s := range d.shards {
+		n += s.Size()
+	}
+
+	return n
+}
+
+// Dropped returns the total number of envelopes dropped by the shards.
+func (d *ShardedEnvelopeV2) Dropped() uint64 {
+	var n uint64
+	for _, s := range d.shards {
+		n += s.Dropped()
+	}
+
+	return n
+}
+
+// RecentlyDropped returns the number of envelopes dropped by the shards in
+// the last minute.
+func (d *ShardedEnvelopeV2) RecentlyDropped() uint64 {
+	var n uint64
+	for _, s := range d.shards {
+		n += s.RecentlyDropped()
+	}
+
+	return n
+}
+
+func (d *ShardedEnvelopeV2) shardFor(e *loggregator_v2.Envelope) int {
+	if d.policy == ShardRoundRobin {
+		return int(atomic.AddUint64(&d.next, 1) % uint64(len(d.shards)))
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(e.GetSourceId()))
+
+	return int(h.Sum32() % uint32(len(d.shards)))
+}
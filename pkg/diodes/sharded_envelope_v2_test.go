@@ -0,0 +1,78 @@
+package diodes_test
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ShardedEnvelopeV2", func() {
+	drain := func(d *diodes.ManyToOneEnvelopeV2) []string {
+		var ids []string
+		for {
+			e, ok := d.TryNext()
+			if !ok {
+				return ids
+			}
+			ids = append(ids, e.GetSourceId())
+		}
+	}
+
+	It("sets every envelope from a source on the same shard", func() {
+		d := diodes.NewShardedEnvelopeV2(4, 100, diodes.ShardBySourceID, nil)
+
+		for i := 0; i < 10; i++ {
+			for j := 0; j < 3; j++ {
+				d.Set(&loggregator_v2.Envelope{SourceId: fmt.Sprint("source-", j)})
+			}
+		}
+
+		var total int
+		for _, s := range d.Shards() {
+			ids := drain(s)
+			total += len(ids)
+			for _, id := range ids {
+				Expect(id).To(Equal(ids[0]))
+			}
+		}
+		Expect(total).To(Equal(30))
+	})
+
+	It("spreads envelopes evenly when sharding round robin", func() {
+		d := diodes.NewShardedEnvelopeV2(4, 100, diodes.ShardRoundRobin, nil)
+
+		for i := 0; i < 20; i++ {
+			d.Set(&loggregator_v2.Envelope{SourceId: "source"})
+		}
+
+		for _, s := range d.Shards() {
+			Expect(drain(s)).To(HaveLen(5))
+		}
+	})
+
+	It("reports the totals across every shard", func() {
+		d := diodes.NewShardedEnvelopeV2(2, 10, diodes.ShardRoundRobin, nil)
+		Expect(d.Size()).To(Equal(20))
+
+		for i := 0; i < 30; i++ {
+			d.Set(&loggregator_v2.Envelope{})
+		}
+
+		var read int
+		for {
+			if _, ok := d.TryNext(); !ok {
+				break
+			}
+			read++
+		}
+
+		Expect(read).To(Equal(20))
+		Expect(d.Depth()).To(Equal(0))
+		Expect(d.Dropped()).To(Equal(uint64(10)))
+		Expect(d.RecentlyDropped()).To(Equal(uint64(10)))
+	})
+})
@@ -1,14 +1,16 @@
 package v1
 
 import (
-	"log"
 	"sync"
 
 	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
 	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/gogo/protobuf/proto"
 )
 
+var logger = logging.New("egress")
+
 //go:generate hel --type BatchChainByteWriter --output mock_writer_test.go
 
 // MetricClient creates new CounterMetrics to be emitted periodically.
@@ -47,13 +49,13 @@ func (m *EventMarshaller) writer() BatchChainByteWriter {
 func (m *EventMarshaller) Write(envelope *events.Envelope) {
 	writer := m.writer()
 	if writer == nil {
-		log.Print("EventMarshaller: Write called while byteWriter is nil")
+		logger.Printf("EventMarshaller: Write called while byteWriter is nil")
 		return
 	}
 
 	envelopeBytes, err := proto.Marshal(envelope)
 	if err != nil {
-		log.Printf("marshalling error: %v", err)
+		logger.Errorf("marshalling error: %v", err)
 		return
 	}
 
@@ -0,0 +1,203 @@
+package v2
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// AdaptiveSampler sheds a share of the envelopes from noisy source IDs. The
+// rate of each source ID is measured over a window. At the end of each
+// window, source IDs whose rate exceeds the configured percentile of all
+// source rates are sampled for the next window, and source IDs that have
+// quieted down are no longer sampled.
+//
+// Sampling is deterministic: a source kept at a rate of 0.25 has every
+// fourth envelope written.
+type AdaptiveSampler struct {
+	percentile    float64
+	shed          float64
+	window        time.Duration
+	now           func() time.Time
+	next          Writer
+	droppedMetric pulseemitter.CounterMetric
+
+	mu          sync.Mutex
+	windowStart time.Time
+	sources     map[string]*sampledSource
+}
+
+type sampledSource struct {
+	count  int
+	keep   float64
+	credit float64
+}
+
+// AdaptiveSamplerOption configures an AdaptiveSampler.
+type AdaptiveSamplerOption func(*AdaptiveSampler)
+
+// WithSamplingWindow sets the window source rates are measured over. The
+// default is 10 seconds.
+func WithSamplingWindow(d time.Duration) AdaptiveSamplerOption {
+	return func(s *AdaptiveSampler) {
+		s.window = d
+	}
+}
+
+// WithSamplingClock sets the function used to read the time. It is intended
+// for tests.
+func WithSamplingClock(now func() time.Time) AdaptiveSamplerOption {
+	return func(s *AdaptiveSampler) {
+		s.now = now
+	}
+}
+
+// NewAdaptiveSampler returns an AdaptiveSampler that sheds the given
+// fraction, between 0 and 1, of the envelopes from source IDs above the
+// given percentile, between 0 and 100.
+func NewAdaptiveSampler(
+	percentile float64,
+	shed float64,
+	next Writer,
+	m MetricClient,
+	opts ...AdaptiveSamplerOption,
+) *AdaptiveSampler {
+	// metric-documentation-v2: (loggregator.metron.dropped) Number of
+	// envelopes shed from noisy sources by adaptive sampling
+	droppedMetric := m.NewCounterMetric("dropped",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"direction": "sampling"}),
+	)
+
+	s := &AdaptiveSampler{
+		percentile:    percentile,
+		shed:          shed,
+		window:        10 * time.Second,
+		now:           time.Now,
+		next:          next,
+		droppedMetric: droppedMetric,
+		sources:       make(map[string]*sampledSource),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// Write writes the envelopes kept by sampling to the next Writer. Shedding
+// envelopes is not an error.
+func (s *AdaptiveSampler) Write(batch []*loggregator_v2.Envelope) error {
+	s.mu.Lock()
+	s.roll()
+
+	kept := make([]*loggregator_v2.Envelope, 0, len(batch))
+	for _, e := range batch {
+		src, ok := s.sources[e.GetSourceId()]
+		if !ok {
+			src = &sampledSource{keep: 1}
+			s.sources[e.GetSourceId()] = src
+		}
+		src.count++
+
+		src.credit += src.keep
+		if src.credit >= 1 {
+			src.credit--
+			kept = append(kept, e)
+		}
+	}
+	s.mu.Unlock()
+
+	if dropped := len(batch) - len(kept); dropped > 0 {
+		s.droppedMetric.Increment(uint64(dropped))
+	}
+
+	if len(kept) == 0 {
+		return nil
+	}
+
+	return s.next.Write(kept)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (s *AdaptiveSampler) BorrowsBatches() bool {
+	return BorrowsBatches(s.next)
+}
+
+// SamplingRates returns the fraction of envelopes kept for each source ID
+// that is being sampled.
+func (s *AdaptiveSampler) SamplingRates() map[string]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.roll()
+
+	rates := make(map[string]float64)
+	for id, src := range s.sources {
+		if src.keep < 1 {
+			rates[id] = src.keep
+		}
+	}
+
+	return rates
+}
+
+// roll ends the current window if it has elapsed and decides which sources
+// to sample in the next one.
+func (s *AdaptiveSampler) roll() {
+	now := s.now()
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+		return
+	}
+
+	if now.Sub(s.windowStart) < s.window {
+		return
+	}
+	s.windowStart = now
+
+	counts := make([]int, 0, len(s.sources))
+	for id, src := range s.sources {
+		if src.count == 0 {
+			delete(s.sources, id)
+			continue
+		}
+		counts = append(counts, src.count)
+	}
+
+	threshold := percentile(counts, s.percentile)
+	for _, src := range s.sources {
+		if src.count > threshold {
+			src.keep = 1 - s.shed
+		} else {
+			src.keep = 1
+			src.credit = 0
+		}
+		src.count = 0
+	}
+}
+
+// percentile returns the count at the given percentile using the nearest
+// rank method.
+func percentile(counts []int, p float64) int {
+	if len(counts) == 0 {
+		return 0
+	}
+
+	sort.Ints(counts)
+
+	rank := int(math.Ceil(p / 100 * float64(len(counts))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(counts) {
+		rank = len(counts)
+	}
+
+	return counts[rank-1]
+}
@@ -0,0 +1,98 @@
+package v2_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AdaptiveSampler", func() {
+	var (
+		spy          *conformance.SpyWriter
+		clock        *fakeClock
+		metricClient *testhelper.SpyMetricClient
+		s            *egress.AdaptiveSampler
+	)
+
+	// writeFrom writes n envelopes from each of the given source IDs.
+	writeFrom := func(n int, sourceIDs ...string) {
+		var batch []*loggregator_v2.Envelope
+		for _, id := range sourceIDs {
+			for i := 0; i < n; i++ {
+				batch = append(batch, &loggregator_v2.Envelope{SourceId: id})
+			}
+		}
+		Expect(s.Write(batch)).To(Succeed())
+	}
+
+	countFrom := func(sourceID string) int {
+		var n int
+		for _, e := range spy.Delivered() {
+			if e.SourceId == sourceID {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	BeforeEach(func() {
+		spy = conformance.NewSpyWriter()
+		clock = &fakeClock{now: time.Unix(0, 0)}
+		metricClient = testhelper.NewMetricClient()
+		s = egress.NewAdaptiveSampler(
+			75, 0.75,
+			spy,
+			metricClient,
+			egress.WithSamplingWindow(time.Second),
+			egress.WithSamplingClock(clock.Now),
+		)
+
+		writeFrom(1, "quiet-1")
+		writeFrom(10, "quiet-1", "quiet-2", "quiet-3")
+		writeFrom(100, "noisy")
+		clock.Advance(time.Second)
+		writeFrom(0)
+	})
+
+	It("sheds envelopes only from sources above the percentile", func() {
+		writeFrom(100, "noisy", "quiet-1")
+
+		Expect(countFrom("noisy")).To(Equal(100 + 25))
+		Expect(countFrom("quiet-1")).To(Equal(11 + 100))
+		Expect(metricClient.GetMetric("dropped").Delta()).To(Equal(uint64(75)))
+	})
+
+	It("reports the sampling rate of sampled sources", func() {
+		Expect(s.SamplingRates()).To(Equal(map[string]float64{
+			"noisy": 0.25,
+		}))
+	})
+
+	It("stops sampling sources that quiet down", func() {
+		writeFrom(10, "noisy", "quiet-1", "quiet-2", "quiet-3")
+		clock.Advance(time.Second)
+
+		Expect(s.SamplingRates()).To(BeEmpty())
+	})
+
+	It("stops sampling sources that go silent", func() {
+		writeFrom(10, "quiet-1", "quiet-2")
+		clock.Advance(time.Second)
+
+		Expect(s.SamplingRates()).To(BeEmpty())
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			return s.Harness(egress.NewAdaptiveSampler(95, 0.5, s, testhelper.NewMetricClient()))
+		})
+	})
+})
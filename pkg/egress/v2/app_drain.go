@@ -0,0 +1,549 @@
+package v2
+
+import (
+	"crypto/tls"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/binding"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+)
+
+// appDrainBatchSize is the most envelopes written to a drain at once.
+const appDrainBatchSize = 100
+
+// appDrainIdleInterval is how long a drain waits before checking its buffer
+// and backoff again.
+const appDrainIdleInterval = 10 * time.Millisecond
+
+// BindingFetcher fetches the syslog drains bound to applications.
+type BindingFetcher interface {
+	FetchBindings() ([]binding.Binding, error)
+}
+
+// AppDrainWriter forwards the envelopes of each application to the syslog
+// drains bound to it. Envelopes are routed by source ID, which is the
+// application ID for application envelopes. Bindings are polled from a
+// BindingFetcher and a drain is connected to when it is first written to.
+//
+// Each drain has its own buffer and goroutine, so Write never waits on a
+// drain and a slow or failing drain does not hold up the others. A batch
+// that fails to be written is retried once the drain's backoff has elapsed.
+// The backoff doubles with each consecutive failure. While a drain is
+// backing off its buffer keeps filling and, once full, its oldest envelopes
+// are dropped.
+//
+// When the bindings change, drains that are still bound keep their buffer
+// and connection. New drains are connected to before they are routed to,
+// and unbound drains write what they have buffered, for up to a grace
+// period, before they are closed.
+//
+// A drain URL may set drain-type=all in its query to receive counters and
+// gauges as well as logs. By default a drain receives only logs.
+type AppDrainWriter struct {
+	fetcher        BindingFetcher
+	interval       time.Duration
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	skipCertVerify bool
+	bufferSize     int
+	grace          time.Duration
+	now            func() time.Time
+	metrics        *sharedMetricClient
+	droppedMetric  pulseemitter.CounterMetric
+
+	mu     sync.Mutex
+	drains map[string][]*appDrain
+	done   chan struct{}
+}
+
+type appDrain struct {
+	binding binding.Binding
+	writer  *SyslogWriter
+	buffer  *diodes.ManyToOneEnvelopeV2
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	// grace is set before the drain is stopped and lost once it has.
+	grace time.Duration
+	lost  uint64
+
+	mu       sync.Mutex
+	failures int
+	retryAt  time.Time
+}
+
+// AppDrainStats is the state of a drain bound to an application.
+type AppDrainStats struct {
+	AppID    string    `json:"app_id"`
+	Host     string    `json:"host"`
+	Failures int       `json:"failures"`
+	RetryAt  time.Time `json:"retry_at,omitempty"`
+}
+
+// AppDrainOption configures an AppDrainWriter.
+type AppDrainOption func(*AppDrainWriter)
+
+// WithAppDrainPollInterval sets how often bindings are fetched. The default
+// is 1 minute.
+func WithAppDrainPollInterval(d time.Duration) AppDrainOption {
+	return func(w *AppDrainWriter) {
+		w.interval = d
+	}
+}
+
+// WithAppDrainBackoff sets the bounds of the delay before a failed drain is
+// written to again. The defaults are 1 second and 1 minute.
+func WithAppDrainBackoff(min, max time.Duration) AppDrainOption {
+	return func(w *AppDrainWriter) {
+		w.minBackoff = min
+		w.maxBackoff = max
+	}
+}
+
+// WithAppDrainSkipCertVerify sets whether the certificates of syslog-tls
+// drains are verified. The default is to verify them.
+func WithAppDrainSkipCertVerify(skip bool) AppDrainOption {
+	return func(w *AppDrainWriter) {
+		w.skipCertVerify = skip
+	}
+}
+
+// WithAppDrainBufferSize sets how many envelopes are buffered for each
+// drain. The default is 1000.
+func WithAppDrainBufferSize(size int) AppDrainOption {
+	return func(w *AppDrainWriter) {
+		w.bufferSize = size
+	}
+}
+
+// WithAppDrainGracePeriod sets how long a drain that is unbound, or closed,
+// has to write the envelopes it has buffered. The default is 10 seconds.
+func WithAppDrainGracePeriod(d time.Duration) AppDrainOption {
+	return func(w *AppDrainWriter) {
+		w.grace = d
+	}
+}
+
+// WithAppDrainClock sets the function used to read the time. It is intended
+// for tests.
+func WithAppDrainClock(now func() time.Time) AppDrainOption {
+	return func(w *AppDrainWriter) {
+		w.now = now
+	}
+}
+
+// NewAppDrainWriter returns an AppDrainWriter that polls the given fetcher
+// for bindings. Start must be called for bindings to be fetched.
+func NewAppDrainWriter(f BindingFetcher, m MetricClient, opts ...AppDrainOption) *AppDrainWriter {
+	// metric-documentation-v2: (loggregator.metron.dropped) Number of
+	// envelopes dropped because the buffer of an application drain was full
+	droppedMetric := m.NewCounterMetric("dropped",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{
+			"direction":   "egress",
+			"destination": "app_drain",
+		}),
+	)
+
+	w := &AppDrainWriter{
+		fetcher:       f,
+		interval:      time.Minute,
+		minBackoff:    time.Second,
+		maxBackoff:    time.Minute,
+		bufferSize:    1000,
+		grace:         10 * time.Second,
+		now:           time.Now,
+		metrics:       newSharedMetricClient(m),
+		droppedMetric: droppedMetric,
+		drains:        make(map[string][]*appDrain),
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	return w
+}
+
+// Start fetches bindings until Stop is called. When fetching fails the
+// previous bindings are kept.
+func (w *AppDrainWriter) Start() {
+	done := make(chan struct{})
+	w.mu.Lock()
+	w.done = done
+	w.mu.Unlock()
+
+	w.refresh()
+
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			w.refresh()
+		}
+	}
+}
+
+// Stop causes Start to return.
+func (w *AppDrainWriter) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done != nil {
+		close(w.done)
+		w.done = nil
+	}
+}
+
+// Close stops routing envelopes to the drains and closes each one once it
+// has written what it has buffered or its grace period has elapsed.
+func (w *AppDrainWriter) Close() error {
+	w.Flush(w.grace)
+
+	return nil
+}
+
+// Flush stops fetching bindings and closes the drains as Close does, with a
+// grace period no longer than the timeout. It returns the number of buffered
+// envelopes that were not written.
+func (w *AppDrainWriter) Flush(timeout time.Duration) uint64 {
+	w.Stop()
+
+	grace := w.grace
+	if timeout < grace {
+		grace = timeout
+	}
+
+	w.mu.Lock()
+	drains := w.drains
+	w.drains = make(map[string][]*appDrain)
+	w.mu.Unlock()
+
+	var (
+		wg   sync.WaitGroup
+		lost uint64
+	)
+	for _, ds := range drains {
+		for _, d := range ds {
+			wg.Add(1)
+			go func(d *appDrain) {
+				defer wg.Done()
+				atomic.AddUint64(&lost, d.stop(grace))
+			}(d)
+		}
+	}
+	wg.Wait()
+
+	return lost
+}
+
+// Write sets each envelope on the buffer of every drain bound to its source
+// ID. It never waits on a drain and never returns an error.
+func (w *AppDrainWriter) Write(batch []*loggregator_v2.Envelope) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, e := range batch {
+		for _, d := range w.drains[e.GetSourceId()] {
+			d.buffer.Set(e)
+		}
+	}
+
+	return nil
+}
+
+// BorrowsBatches returns true as the envelopes, rather than the batch, are
+// put in the drains' buffers.
+func (w *AppDrainWriter) BorrowsBatches() bool {
+	return true
+}
+
+// Stats returns the state of every drain, ordered by application ID.
+func (w *AppDrainWriter) Stats() []AppDrainStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var stats []AppDrainStats
+	for _, drains := range w.drains {
+		for _, d := range drains {
+			d.mu.Lock()
+			stats = append(stats, AppDrainStats{
+				AppID:    d.binding.AppID,
+				Host:     d.writer.addr,
+				Failures: d.failures,
+				RetryAt:  d.retryAt,
+			})
+			d.mu.Unlock()
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].AppID != stats[j].AppID {
+			return stats[i].AppID < stats[j].AppID
+		}
+		return stats[i].Host < stats[j].Host
+	})
+
+	return stats
+}
+
+// run writes the drain's buffered envelopes until the drain is stopped. A
+// batch that fails is held and retried once the backoff has elapsed.
+func (w *AppDrainWriter) run(d *appDrain) {
+	defer close(d.stopped)
+
+	batch := make([]*loggregator_v2.Envelope, 0, appDrainBatchSize)
+	for {
+		if len(batch) == 0 {
+			batch = d.next(batch)
+		}
+
+		if len(batch) == 0 || w.now().Before(d.retryTime()) {
+			select {
+			case <-d.done:
+				w.flush(d, batch)
+				return
+			case <-time.After(appDrainIdleInterval):
+			}
+			continue
+		}
+
+		if w.writeDrain(d, batch) {
+			batch = batch[:0]
+		}
+	}
+}
+
+// writeDrain writes the envelopes to the drain and updates its backoff. It
+// reports whether the envelopes were written.
+func (w *AppDrainWriter) writeDrain(d *appDrain, envelopes []*loggregator_v2.Envelope) bool {
+	err := d.writer.Write(envelopes)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err != nil {
+		backoff := w.minBackoff << uint(d.failures)
+		if backoff > w.maxBackoff || backoff <= 0 {
+			backoff = w.maxBackoff
+		}
+		d.failures++
+		d.retryAt = w.now().Add(backoff)
+
+		logger.Debugf("failed to write to drain for %s, retrying in %s: %s", d.binding.AppID, backoff, err)
+		return false
+	}
+
+	d.failures = 0
+	d.retryAt = time.Time{}
+
+	return true
+}
+
+// flush writes the held batch and the rest of the drain's buffer, ignoring
+// its backoff. Envelopes that are not written before the first failure or
+// the end of the grace period are dropped.
+func (w *AppDrainWriter) flush(d *appDrain, batch []*loggregator_v2.Envelope) {
+	deadline := w.now().Add(d.grace)
+	for {
+		if len(batch) == 0 {
+			batch = d.next(batch)
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		if !w.now().Before(deadline) || !w.writeDrain(d, batch) {
+			d.lost = uint64(len(batch) + d.buffer.Depth())
+			w.droppedMetric.Increment(d.lost)
+			logger.Debugf("dropped envelopes buffered for closed drain for %s", d.binding.AppID)
+			return
+		}
+		batch = batch[:0]
+	}
+}
+
+// next appends up to a batch of buffered envelopes to the slice.
+func (d *appDrain) next(batch []*loggregator_v2.Envelope) []*loggregator_v2.Envelope {
+	for len(batch) < appDrainBatchSize {
+		e, ok := d.buffer.TryNext()
+		if !ok {
+			break
+		}
+		batch = append(batch, e)
+	}
+
+	return batch
+}
+
+func (d *appDrain) retryTime() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.retryAt
+}
+
+// stop causes the drain's goroutine to flush its buffer, for up to the
+// grace period, and return. It closes the connection once the goroutine has
+// returned and returns the number of envelopes that were not written.
+func (d *appDrain) stop(grace time.Duration) uint64 {
+	d.once.Do(func() {
+		d.grace = grace
+		close(d.done)
+	})
+	<-d.stopped
+	d.writer.Close()
+
+	return d.lost
+}
+
+// refresh fetches the bindings and updates the drains. Drains that are
+// still bound keep their buffer, connection and backoff. New drains are
+// connected to before envelopes are routed to them so that they do not
+// start out behind, and unbound drains are flushed in the background.
+func (w *AppDrainWriter) refresh() {
+	bindings, err := w.fetcher.FetchBindings()
+	if err != nil {
+		logger.Warnf("failed to fetch bindings: %s", err)
+		return
+	}
+
+	// Only refresh changes the drains, so they can be read and the new ones
+	// connected to without holding the lock.
+	w.mu.Lock()
+	existing := make(map[binding.Binding]*appDrain)
+	for _, drains := range w.drains {
+		for _, d := range drains {
+			existing[d.binding] = d
+		}
+	}
+	w.mu.Unlock()
+
+	var added []*appDrain
+	drains := make(map[string][]*appDrain)
+	for _, b := range bindings {
+		d, ok := existing[b]
+		if ok {
+			delete(existing, b)
+		} else {
+			d = w.newAppDrain(b)
+			if d == nil {
+				continue
+			}
+			added = append(added, d)
+		}
+
+		drains[b.AppID] = append(drains[b.AppID], d)
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range added {
+		wg.Add(1)
+		go func(d *appDrain) {
+			defer wg.Done()
+			if err := d.writer.connect(); err != nil {
+				logger.Debugf("failed to connect to new drain for %s: %s", d.binding.AppID, err)
+			}
+		}(d)
+	}
+	wg.Wait()
+
+	w.mu.Lock()
+	w.drains = drains
+	w.mu.Unlock()
+
+	for _, d := range existing {
+		go d.stop(w.grace)
+	}
+}
+
+func (w *AppDrainWriter) newAppDrain(b binding.Binding) *appDrain {
+	u, err := url.Parse(b.Drain)
+	if err != nil {
+		logger.Debugf("ignoring invalid drain for %s: %s", b.AppID, err)
+		return nil
+	}
+
+	var logsOnly bool
+	switch t := u.Query().Get("drain-type"); t {
+	case "", "logs":
+		logsOnly = true
+	case "all":
+	default:
+		logger.Debugf("ignoring drain for %s with unsupported drain-type %q", b.AppID, t)
+		return nil
+	}
+
+	opts := []SyslogOption{WithSyslogLogsOnly(logsOnly)}
+	if b.Hostname != "" {
+		opts = append(opts, WithSyslogHostname(b.Hostname))
+	}
+	if u.Scheme == "syslog-tls" {
+		opts = append(opts, WithSyslogTLSConfig(&tls.Config{
+			ServerName:         u.Hostname(),
+			InsecureSkipVerify: w.skipCertVerify,
+		}))
+	}
+
+	sw, err := NewSyslogWriter(u, w.metrics, opts...)
+	if err != nil {
+		logger.Debugf("ignoring drain for %s: %s", b.AppID, err)
+		return nil
+	}
+
+	d := &appDrain{
+		binding: b,
+		writer:  sw,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	d.buffer = diodes.NewManyToOneEnvelopeV2(w.bufferSize, gendiodes.AlertFunc(func(missed int) {
+		w.droppedMetric.Increment(uint64(missed))
+	}))
+	go w.run(d)
+
+	return d
+}
+
+// sharedMetricClient returns the same CounterMetric for every request for
+// a metric name, so that drains created as bindings change share their
+// metrics rather than registering new ones.
+type sharedMetricClient struct {
+	m MetricClient
+
+	mu      sync.Mutex
+	metrics map[string]pulseemitter.CounterMetric
+}
+
+func newSharedMetricClient(m MetricClient) *sharedMetricClient {
+	return &sharedMetricClient{
+		m:       m,
+		metrics: make(map[string]pulseemitter.CounterMetric),
+	}
+}
+
+func (c *sharedMetricClient) NewCounterMetric(name string, opts ...pulseemitter.MetricOption) pulseemitter.CounterMetric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m, ok := c.metrics[name]; ok {
+		return m
+	}
+
+	m := c.m.NewCounterMetric(name, opts...)
+	c.metrics[name] = m
+
+	return m
+}
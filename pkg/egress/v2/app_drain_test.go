@@ -0,0 +1,220 @@
+package v2_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	"code.cloudfoundry.org/loggregator-agent/pkg/binding"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AppDrainWriter", func() {
+	var (
+		fetcher      *spyBindingFetcher
+		drainA       *spySyslogDrain
+		drainB       *spySyslogDrain
+		clock        *fakeClock
+		metricClient *testhelper.SpyMetricClient
+		w            *egress.AppDrainWriter
+	)
+
+	BeforeEach(func() {
+		drainA = newSpySyslogDrain()
+		drainB = newSpySyslogDrain()
+		fetcher = &spyBindingFetcher{}
+		fetcher.SetBindings([]binding.Binding{
+			{AppID: "app-a", Hostname: "org.space.a", Drain: "syslog://" + drainA.Addr()},
+			{AppID: "app-b", Hostname: "org.space.b", Drain: "syslog://" + drainB.Addr() + "?drain-type=all"},
+		})
+		clock = &fakeClock{now: time.Unix(0, 0)}
+		metricClient = testhelper.NewMetricClient()
+
+		w = egress.NewAppDrainWriter(
+			fetcher,
+			metricClient,
+			egress.WithAppDrainPollInterval(10*time.Millisecond),
+			egress.WithAppDrainBackoff(time.Second, 4*time.Second),
+			egress.WithAppDrainClock(clock.Now),
+		)
+		go w.Start()
+		Eventually(w.Stats).Should(HaveLen(2))
+	})
+
+	// bindClosedDrain binds app-a to an address that nothing listens on and
+	// returns the address.
+	bindClosedDrain := func() string {
+		closed := newSpySyslogDrain()
+		addr := closed.Addr()
+		closed.Close()
+
+		fetcher.SetBindings([]binding.Binding{
+			{AppID: "app-a", Hostname: "org.space.a", Drain: "syslog://" + addr},
+			{AppID: "app-b", Hostname: "org.space.b", Drain: "syslog://" + drainB.Addr() + "?drain-type=all"},
+		})
+		Eventually(func() string { return w.Stats()[0].Host }).Should(Equal(addr))
+
+		return addr
+	}
+
+	AfterEach(func() {
+		w.Stop()
+		w.Close()
+		drainA.Close()
+		drainB.Close()
+	})
+
+	It("forwards envelopes to the drains bound to their source ID", func() {
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			appLog("app-a", "for a"),
+			appLog("app-b", "for b"),
+			appLog("app-c", "unbound"),
+			{
+				SourceId: "app-a",
+				Message:  &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{Name: "requests"}},
+			},
+			{
+				SourceId: "app-b",
+				Message:  &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{Name: "requests"}},
+			},
+		})).To(Succeed())
+
+		Eventually(drainA.Messages).Should(ConsistOf(
+			`<14>1 1970-01-01T00:00:00Z org.space.a app-a - - - for a`,
+		))
+		Eventually(drainB.Messages).Should(ConsistOf(
+			`<14>1 1970-01-01T00:00:00Z org.space.b app-b - - - for b`,
+			`<14>1 1970-01-01T00:00:00Z org.space.b app-b - - [counter@47450 name="requests" total="0" delta="0"]`,
+		))
+	})
+
+	It("stops forwarding to drains that are unbound", func() {
+		fetcher.SetBindings([]binding.Binding{
+			{AppID: "app-b", Drain: "syslog://" + drainB.Addr()},
+		})
+		Eventually(w.Stats).Should(HaveLen(1))
+
+		Expect(w.Write([]*loggregator_v2.Envelope{appLog("app-a", "for a")})).To(Succeed())
+
+		Consistently(drainA.Messages).Should(BeEmpty())
+	})
+
+	It("connects to new drains before routing to them", func() {
+		drainC := newSpySyslogDrain()
+		defer drainC.Close()
+
+		fetcher.SetBindings([]binding.Binding{
+			{AppID: "app-a", Hostname: "org.space.a", Drain: "syslog://" + drainA.Addr()},
+			{AppID: "app-b", Hostname: "org.space.b", Drain: "syslog://" + drainB.Addr() + "?drain-type=all"},
+			{AppID: "app-c", Drain: "syslog://" + drainC.Addr()},
+		})
+		Eventually(w.Stats).Should(HaveLen(3))
+
+		Eventually(drainC.Connections).Should(Equal(1))
+	})
+
+	It("writes what an unbound drain has buffered before closing it", func() {
+		addr := bindClosedDrain()
+
+		Expect(w.Write([]*loggregator_v2.Envelope{appLog("app-a", "before unbinding")})).To(Succeed())
+		Eventually(func() int { return w.Stats()[0].Failures }).Should(Equal(1))
+		drainA.Close()
+		drainA = newSpySyslogDrainAt(addr)
+
+		fetcher.SetBindings([]binding.Binding{
+			{AppID: "app-b", Hostname: "org.space.b", Drain: "syslog://" + drainB.Addr() + "?drain-type=all"},
+		})
+		Eventually(w.Stats).Should(HaveLen(1))
+
+		Eventually(drainA.Messages).Should(ConsistOf(
+			`<14>1 1970-01-01T00:00:00Z org.space.a app-a - - - before unbinding`,
+		))
+	})
+
+	It("keeps the previous bindings when fetching fails", func() {
+		fetcher.SetErr(errors.New("some-error"))
+
+		Consistently(w.Stats).Should(HaveLen(2))
+	})
+
+	It("backs off from a failing drain without affecting the others", func() {
+		bindClosedDrain()
+
+		Expect(w.Write([]*loggregator_v2.Envelope{appLog("app-a", "1"), appLog("app-b", "1")})).To(Succeed())
+		Eventually(func() int { return w.Stats()[0].Failures }).Should(Equal(1))
+		Expect(w.Stats()[0].RetryAt).To(Equal(time.Unix(1, 0)))
+		Eventually(drainB.Messages).Should(HaveLen(1))
+
+		// The drain is not retried until its backoff has elapsed.
+		Consistently(func() int { return w.Stats()[0].Failures }).Should(Equal(1))
+
+		clock.Advance(time.Second)
+		Eventually(func() int { return w.Stats()[0].Failures }).Should(Equal(2))
+		Expect(w.Stats()[0].RetryAt).To(Equal(time.Unix(3, 0)))
+	})
+
+	It("retries a failed batch once the drain recovers", func() {
+		addr := bindClosedDrain()
+
+		Expect(w.Write([]*loggregator_v2.Envelope{appLog("app-a", "while down")})).To(Succeed())
+		Eventually(func() int { return w.Stats()[0].Failures }).Should(Equal(1))
+
+		drainA.Close()
+		drainA = newSpySyslogDrainAt(addr)
+		clock.Advance(time.Second)
+
+		Eventually(drainA.Messages).Should(ConsistOf(
+			`<14>1 1970-01-01T00:00:00Z org.space.a app-a - - - while down`,
+		))
+		Eventually(func() int { return w.Stats()[0].Failures }).Should(Equal(0))
+	})
+
+	It("ignores drains that are not syslog URLs", func() {
+		fetcher.SetBindings([]binding.Binding{
+			{AppID: "app-a", Drain: "https://drain.example.com"},
+			{AppID: "app-b", Drain: "syslog://" + drainB.Addr() + "?drain-type=metrics"},
+			{AppID: "app-b", Drain: "syslog://" + drainB.Addr()},
+		})
+
+		Eventually(w.Stats).Should(HaveLen(1))
+	})
+})
+
+func appLog(sourceID, payload string) *loggregator_v2.Envelope {
+	return &loggregator_v2.Envelope{
+		SourceId: sourceID,
+		Message: &loggregator_v2.Envelope_Log{
+			Log: &loggregator_v2.Log{Payload: []byte(payload)},
+		},
+	}
+}
+
+type spyBindingFetcher struct {
+	mu       sync.Mutex
+	bindings []binding.Binding
+	err      error
+}
+
+func (f *spyBindingFetcher) FetchBindings() ([]binding.Binding, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.bindings, f.err
+}
+
+func (f *spyBindingFetcher) SetBindings(b []binding.Binding) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bindings = b
+}
+
+func (f *spyBindingFetcher) SetErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
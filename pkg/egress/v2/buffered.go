@@ -0,0 +1,209 @@
+package v2
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing/batching"
+)
+
+// BufferedWriter decouples a destination from the writers in front of it.
+// Envelopes written to it are set on its own diode and written to the
+// destination in batches by a separate goroutine, so a slow destination
+// drops its own envelopes rather than holding up every other destination.
+type BufferedWriter struct {
+	dropped uint64
+
+	name          string
+	buffer        *diodes.ManyToOneEnvelopeV2
+	next          Writer
+	batchSize     int
+	batchInterval time.Duration
+	droppedMetric pulseemitter.CounterMetric
+
+	mu      sync.Mutex
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// BufferedWriterOption configures a BufferedWriter.
+type BufferedWriterOption func(*BufferedWriter)
+
+// WithBufferedBatching sets the size and interval of the batches written to
+// the destination. The defaults are 100 envelopes and 100 milliseconds.
+func WithBufferedBatching(size int, interval time.Duration) BufferedWriterOption {
+	return func(w *BufferedWriter) {
+		w.batchSize = size
+		w.batchInterval = interval
+	}
+}
+
+// NewBufferedWriter returns a BufferedWriter that holds up to size envelopes
+// for the named destination. Start must be called for envelopes to be
+// written to next.
+func NewBufferedWriter(
+	name string,
+	size int,
+	next Writer,
+	m MetricClient,
+	opts ...BufferedWriterOption,
+) *BufferedWriter {
+	// metric-documentation-v2: (loggregator.metron.dropped) Number of
+	// envelopes dropped by an egress destination, either because its buffer
+	// was full or because it failed to write them
+	droppedMetric := m.NewCounterMetric("dropped",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{
+			"direction":   "egress",
+			"destination": name,
+		}),
+	)
+
+	w := &BufferedWriter{
+		name:          name,
+		next:          next,
+		batchSize:     100,
+		batchInterval: 100 * time.Millisecond,
+		droppedMetric: droppedMetric,
+	}
+
+	drops := logger.Summarize(logging.WarnLevel, logging.SummaryInterval)
+	w.buffer = diodes.NewManyToOneEnvelopeV2(size, gendiodes.AlertFunc(func(missed int) {
+		w.drop(missed)
+		drops.Add(fmt.Sprintf("Dropped envelopes buffered for %s", name), uint64(missed))
+	}))
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	return w
+}
+
+// Write sets each envelope of the batch on the buffer. It never blocks on
+// the destination and never returns an error; envelopes that the
+// destination can not keep up with are dropped from the buffer.
+func (w *BufferedWriter) Write(batch []*loggregator_v2.Envelope) error {
+	for _, e := range batch {
+		w.buffer.Set(e)
+	}
+
+	return nil
+}
+
+// BorrowsBatches returns true as the envelopes, rather than the batch, are
+// put in the buffer.
+func (w *BufferedWriter) BorrowsBatches() bool {
+	return true
+}
+
+// Start writes buffered envelopes to the destination until Stop is called.
+func (w *BufferedWriter) Start() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	w.mu.Lock()
+	w.done = done
+	w.stopped = stopped
+	w.mu.Unlock()
+	defer close(stopped)
+
+	b := batching.NewV2EnvelopeBatcher(
+		w.batchSize,
+		w.batchInterval,
+		batching.V2EnvelopeWriterFunc(w.write),
+	)
+
+	for {
+		select {
+		case <-done:
+			b.Flush()
+			return
+		default:
+		}
+
+		e, ok := w.buffer.TryNext()
+		if !ok {
+			b.Flush()
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		b.Write(e)
+	}
+}
+
+// Stop causes Start to return.
+func (w *BufferedWriter) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done != nil {
+		close(w.done)
+		w.done = nil
+	}
+}
+
+// Flush stops writing in the background and writes what is left in the
+// buffer, giving up once the timeout has passed. It returns the number of
+// envelopes that were left in the buffer or failed to be written.
+func (w *BufferedWriter) Flush(timeout time.Duration) uint64 {
+	deadline := time.Now().Add(timeout)
+
+	w.mu.Lock()
+	stopped := w.stopped
+	w.mu.Unlock()
+
+	w.Stop()
+	if stopped != nil {
+		<-stopped
+	}
+
+	droppedBefore := w.Dropped()
+	for time.Now().Before(deadline) {
+		batch := make([]*loggregator_v2.Envelope, 0, w.batchSize)
+		for len(batch) < w.batchSize {
+			e, ok := w.buffer.TryNext()
+			if !ok {
+				break
+			}
+			batch = append(batch, e)
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+		w.write(batch)
+	}
+
+	return w.Dropped() - droppedBefore + uint64(w.buffer.Depth())
+}
+
+// Depth returns the number of envelopes waiting to be written to the
+// destination.
+func (w *BufferedWriter) Depth() int {
+	return w.buffer.Depth()
+}
+
+// Dropped returns the number of envelopes dropped by the destination.
+func (w *BufferedWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+func (w *BufferedWriter) write(batch []*loggregator_v2.Envelope) {
+	if err := w.next.Write(batch); err != nil {
+		logger.Debugf("failed to write to %s: %s", w.name, err)
+		w.drop(len(batch))
+	}
+}
+
+func (w *BufferedWriter) drop(n int) {
+	atomic.AddUint64(&w.dropped, uint64(n))
+	w.droppedMetric.Increment(uint64(n))
+}
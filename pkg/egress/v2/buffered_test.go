@@ -0,0 +1,142 @@
+package v2_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BufferedWriter", func() {
+	var (
+		next         *gatedWriter
+		metricClient *testhelper.SpyMetricClient
+		w            *egress.BufferedWriter
+	)
+
+	BeforeEach(func() {
+		next = newGatedWriter()
+		metricClient = testhelper.NewMetricClient()
+		w = egress.NewBufferedWriter(
+			"some-destination",
+			5,
+			next,
+			metricClient,
+			egress.WithBufferedBatching(2, 10*time.Millisecond),
+		)
+		go w.Start()
+	})
+
+	AfterEach(func() {
+		next.Open()
+		w.Stop()
+	})
+
+	It("writes buffered envelopes to the destination in batches", func() {
+		next.Open()
+
+		Expect(w.Write(batchOf(3))).To(Succeed())
+
+		Eventually(next.Count).Should(Equal(3))
+		Expect(next.LargestBatch()).To(Equal(2))
+	})
+
+	It("drops envelopes without blocking when the destination is slow", func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 20; i++ {
+				w.Write(batchOf(1))
+			}
+		}()
+		Eventually(done).Should(BeClosed())
+
+		Eventually(w.Dropped).Should(BeNumerically(">", 0))
+		Expect(metricClient.GetMetric("dropped").Delta()).To(Equal(w.Dropped()))
+	})
+
+	It("counts envelopes the destination fails to write as dropped", func() {
+		next.Open()
+		next.SetErr(errors.New("some-error"))
+
+		Expect(w.Write(batchOf(2))).To(Succeed())
+
+		Eventually(w.Dropped).Should(Equal(uint64(2)))
+	})
+
+	It("writes what is left in the buffer when flushed", func() {
+		next.Open()
+
+		Expect(w.Write(batchOf(4))).To(Succeed())
+
+		Expect(w.Flush(time.Second)).To(BeZero())
+		Expect(next.Count()).To(Equal(4))
+	})
+
+	It("reports the depth of the buffer", func() {
+		Expect(w.Write(batchOf(4))).To(Succeed())
+
+		Eventually(w.Depth).Should(BeNumerically(">", 0))
+	})
+})
+
+// gatedWriter blocks writes until it is opened.
+type gatedWriter struct {
+	once sync.Once
+	open chan struct{}
+
+	mu      sync.Mutex
+	count   int
+	largest int
+	err     error
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{open: make(chan struct{})}
+}
+
+func (w *gatedWriter) Write(batch []*loggregator_v2.Envelope) error {
+	<-w.open
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	w.count += len(batch)
+	if len(batch) > w.largest {
+		w.largest = len(batch)
+	}
+
+	return nil
+}
+
+func (w *gatedWriter) Open() {
+	w.once.Do(func() { close(w.open) })
+}
+
+func (w *gatedWriter) SetErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+}
+
+func (w *gatedWriter) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+func (w *gatedWriter) LargestBatch() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.largest
+}
@@ -0,0 +1,320 @@
+package v2
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// Backlog reports how many envelopes are waiting to be written.
+type Backlog interface {
+	Depth() int
+}
+
+// CatchUpWriter limits how fast the backlog built up during an egress
+// outage is written once the outage ends. A batch that fails to be written
+// is held and retried, so that while egress is down envelopes build up in
+// the buffer rather than being read from it and dropped. Egress is
+// considered down after several consecutive failures. Once it recovers,
+// envelopes are written at no more than a multiple of the steady state rate
+// measured before the outage until the backlog is drained.
+type CatchUpWriter struct {
+	next          Writer
+	backlog       Backlog
+	multiple      float64
+	minRate       float64
+	doneDepth     int
+	threshold     int
+	retryInterval time.Duration
+	holdPeriod    time.Duration
+	now           func() time.Time
+	sleep         func(time.Duration)
+	catchUpMetric pulseemitter.CounterMetric
+
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu          sync.Mutex
+	failures    int
+	failing     bool
+	catchingUp  bool
+	startDepth  int
+	rate        float64
+	windowStart time.Time
+	windowCount int
+	nextWrite   time.Time
+}
+
+// CatchUpOption configures a CatchUpWriter.
+type CatchUpOption func(*CatchUpWriter)
+
+// WithCatchUpMinRate sets the lowest rate, in envelopes per second, the
+// backlog is written at while catching up. It applies when little or no
+// steady state traffic was measured before the outage. The default is 100.
+func WithCatchUpMinRate(r float64) CatchUpOption {
+	return func(w *CatchUpWriter) {
+		w.minRate = r
+	}
+}
+
+// WithCatchUpRetry sets the delay between attempts to write a failed batch
+// and how long the batch is held before it is given up on and the error
+// returned. The defaults are 1 second and 1 minute.
+func WithCatchUpRetry(interval, hold time.Duration) CatchUpOption {
+	return func(w *CatchUpWriter) {
+		w.retryInterval = interval
+		w.holdPeriod = hold
+	}
+}
+
+// WithCatchUpOutageThreshold sets how many consecutive writes must fail
+// before egress is considered down, so that a single transient error does
+// not start catching up. The default is 3.
+func WithCatchUpOutageThreshold(n int) CatchUpOption {
+	return func(w *CatchUpWriter) {
+		w.threshold = n
+	}
+}
+
+// WithCatchUpClock sets the functions used to read the time and to wait
+// between writes. It is intended for tests.
+func WithCatchUpClock(now func() time.Time, sleep func(time.Duration)) CatchUpOption {
+	return func(w *CatchUpWriter) {
+		w.now = now
+		w.sleep = sleep
+	}
+}
+
+// NewCatchUpWriter returns a CatchUpWriter that writes to next at no more
+// than multiple times the steady state rate while the backlog is caught up.
+func NewCatchUpWriter(
+	multiple float64,
+	backlog Backlog,
+	next Writer,
+	m MetricClient,
+	opts ...CatchUpOption,
+) *CatchUpWriter {
+	// metric-documentation-v2: (loggregator.metron.catch_up) Number of
+	// envelopes written while catching up after an egress outage
+	catchUpMetric := m.NewCounterMetric("catch_up",
+		pulseemitter.WithVersion(2, 0),
+	)
+
+	w := &CatchUpWriter{
+		next:          next,
+		backlog:       backlog,
+		multiple:      multiple,
+		minRate:       100,
+		doneDepth:     100,
+		threshold:     3,
+		retryInterval: time.Second,
+		holdPeriod:    time.Minute,
+		done:          make(chan struct{}),
+		now:           time.Now,
+		sleep:         time.Sleep,
+		catchUpMetric: catchUpMetric,
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	return w
+}
+
+// Write writes the batch to the next Writer. While catching up it first
+// waits long enough to keep to the catch up rate. A batch that fails is
+// retried until it is written, the hold period passes or the writer is
+// stopped. Write does not retain the batch after it returns.
+func (w *CatchUpWriter) Write(batch []*loggregator_v2.Envelope) error {
+	w.mu.Lock()
+	delay := w.pace(len(batch))
+	w.mu.Unlock()
+
+	if delay > 0 {
+		w.sleep(delay)
+	}
+
+	if err := w.hold(batch); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.catchingUp {
+		w.observe(len(batch))
+		return nil
+	}
+
+	w.catchUpMetric.Increment(uint64(len(batch)))
+	if w.backlog.Depth() <= w.doneDepth {
+		w.catchingUp = false
+		w.windowStart = time.Time{}
+		w.windowCount = 0
+	}
+
+	return nil
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (w *CatchUpWriter) BorrowsBatches() bool {
+	return BorrowsBatches(w.next)
+}
+
+// Stop stops holding failed batches. A batch that is being held is given up
+// on, so that the goroutine writing it is not blocked while the agent shuts
+// down.
+func (w *CatchUpWriter) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+// hold writes the batch to the next Writer, retrying it while it fails.
+func (w *CatchUpWriter) hold(batch []*loggregator_v2.Envelope) error {
+	start := w.now()
+	for {
+		err := w.next.Write(batch)
+
+		w.mu.Lock()
+		w.record(err)
+		w.mu.Unlock()
+
+		if err == nil || w.stopped() || w.now().Sub(start) >= w.holdPeriod {
+			return err
+		}
+
+		w.sleep(w.retryInterval)
+		if w.stopped() {
+			return err
+		}
+	}
+}
+
+func (w *CatchUpWriter) stopped() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// record tracks consecutive failures to tell when egress goes down and
+// starts catching up once it recovers.
+func (w *CatchUpWriter) record(err error) {
+	if err != nil {
+		w.failures++
+		if w.failures >= w.threshold && !w.failing {
+			w.failing = true
+			w.catchingUp = false
+			w.windowStart = time.Time{}
+			w.windowCount = 0
+		}
+		return
+	}
+
+	w.failures = 0
+	if w.failing {
+		w.failing = false
+		w.startCatchUp()
+	}
+}
+
+// CatchingUp reports whether the backlog from an outage is being caught
+// up.
+func (w *CatchUpWriter) CatchingUp() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.catchingUp
+}
+
+// Progress returns the fraction of the backlog present when catching up
+// started that has since been written. It is 1 when not catching up.
+func (w *CatchUpWriter) Progress() float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.catchingUp {
+		return 1
+	}
+
+	p := 1 - float64(w.backlog.Depth())/float64(w.startDepth)
+	if p < 0 {
+		return 0
+	}
+
+	return p
+}
+
+// Rate returns the measured steady state rate in envelopes per second.
+func (w *CatchUpWriter) Rate() float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.rate
+}
+
+func (w *CatchUpWriter) startCatchUp() {
+	depth := w.backlog.Depth()
+	if depth <= w.doneDepth {
+		return
+	}
+
+	w.catchingUp = true
+	w.startDepth = depth
+	w.nextWrite = w.now()
+}
+
+// pace returns how long to wait before writing n envelopes to keep to the
+// catch up rate.
+func (w *CatchUpWriter) pace(n int) time.Duration {
+	if !w.catchingUp {
+		return 0
+	}
+
+	limit := w.rate * w.multiple
+	if limit < w.minRate {
+		limit = w.minRate
+	}
+
+	now := w.now()
+	if w.nextWrite.Before(now) {
+		w.nextWrite = now
+	}
+
+	delay := w.nextWrite.Sub(now)
+	w.nextWrite = w.nextWrite.Add(time.Duration(float64(n) / limit * float64(time.Second)))
+
+	return delay
+}
+
+// observe records n envelopes written in steady state. The rate is a moving
+// average updated once a second.
+func (w *CatchUpWriter) observe(n int) {
+	now := w.now()
+	if w.windowStart.IsZero() {
+		w.windowStart = now
+		return
+	}
+	w.windowCount += n
+
+	elapsed := now.Sub(w.windowStart)
+	if elapsed < time.Second {
+		return
+	}
+
+	r := float64(w.windowCount) / elapsed.Seconds()
+	if w.rate == 0 {
+		w.rate = r
+	} else {
+		w.rate = 0.8*w.rate + 0.2*r
+	}
+
+	w.windowStart = now
+	w.windowCount = 0
+}
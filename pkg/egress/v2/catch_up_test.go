@@ -0,0 +1,413 @@
+package v2_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	gendiodes "code.cloudfoundry.org/go-diodes"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	clientpool "code.cloudfoundry.org/loggregator-agent/pkg/clientpool/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CatchUpWriter", func() {
+	var (
+		next         *flakyWriter
+		backlog      *spyBacklog
+		clock        *fakeClock
+		metricClient *testhelper.SpyMetricClient
+		w            *egress.CatchUpWriter
+	)
+
+	BeforeEach(func() {
+		next = &flakyWriter{}
+		backlog = &spyBacklog{}
+		clock = &fakeClock{now: time.Unix(0, 0)}
+		metricClient = testhelper.NewMetricClient()
+		w = egress.NewCatchUpWriter(
+			2,
+			backlog,
+			next,
+			metricClient,
+			egress.WithCatchUpMinRate(10),
+			egress.WithCatchUpRetry(time.Second, 0),
+			egress.WithCatchUpClock(clock.Now, clock.Sleep),
+		)
+	})
+
+	// failOutage fails enough writes in a row for egress to be considered
+	// down.
+	failOutage := func() {
+		next.SetFail(true)
+		for i := 0; i < 3; i++ {
+			Expect(w.Write(batchOf(1))).ToNot(Succeed())
+		}
+		next.SetFail(false)
+	}
+
+	// writeSteadily writes 100 envelopes a second for the given number of
+	// seconds.
+	writeSteadily := func(seconds int) {
+		for i := 0; i < seconds*10; i++ {
+			Expect(w.Write(batchOf(10))).To(Succeed())
+			clock.Advance(100 * time.Millisecond)
+		}
+	}
+
+	It("measures the steady state rate", func() {
+		writeSteadily(3)
+
+		Expect(w.Rate()).To(BeNumerically("~", 100, 10))
+		Expect(w.CatchingUp()).To(BeFalse())
+		Expect(clock.Slept()).To(Equal(time.Duration(0)))
+	})
+
+	It("limits the rate of writes after an outage", func() {
+		writeSteadily(3)
+
+		failOutage()
+
+		backlog.SetDepth(1000)
+		Expect(w.Write(batchOf(10))).To(Succeed())
+		Expect(w.CatchingUp()).To(BeTrue())
+
+		for i := 0; i < 10; i++ {
+			Expect(w.Write(batchOf(20))).To(Succeed())
+		}
+
+		// 200 envelopes at twice the steady state rate of ~100/s.
+		Expect(clock.Slept()).To(BeNumerically("~", time.Second, 150*time.Millisecond))
+		Expect(metricClient.GetMetric("catch_up").Delta()).To(Equal(uint64(210)))
+	})
+
+	It("uses the minimum rate when no steady state rate was measured", func() {
+		failOutage()
+
+		backlog.SetDepth(1000)
+		Expect(w.Write(batchOf(10))).To(Succeed())
+		Expect(w.Write(batchOf(10))).To(Succeed())
+		Expect(w.Write(batchOf(10))).To(Succeed())
+
+		Expect(clock.Slept()).To(Equal(time.Second))
+	})
+
+	It("reports progress through the backlog", func() {
+		failOutage()
+
+		Expect(w.Progress()).To(Equal(1.0))
+
+		backlog.SetDepth(1000)
+		Expect(w.Write(batchOf(10))).To(Succeed())
+		Expect(w.Progress()).To(Equal(0.0))
+
+		backlog.SetDepth(250)
+		Expect(w.Progress()).To(Equal(0.75))
+	})
+
+	It("stops catching up once the backlog is drained", func() {
+		failOutage()
+
+		backlog.SetDepth(1000)
+		Expect(w.Write(batchOf(10))).To(Succeed())
+		Expect(w.CatchingUp()).To(BeTrue())
+
+		backlog.SetDepth(0)
+		Expect(w.Write(batchOf(10))).To(Succeed())
+		Expect(w.CatchingUp()).To(BeFalse())
+		Expect(w.Progress()).To(Equal(1.0))
+	})
+
+	It("does not catch up when there is no backlog", func() {
+		failOutage()
+
+		Expect(w.Write(batchOf(10))).To(Succeed())
+		Expect(w.CatchingUp()).To(BeFalse())
+	})
+
+	It("does not catch up after a single failure", func() {
+		next.SetFail(true)
+		Expect(w.Write(batchOf(1))).ToNot(Succeed())
+		next.SetFail(false)
+
+		backlog.SetDepth(1000)
+		Expect(w.Write(batchOf(10))).To(Succeed())
+		Expect(w.CatchingUp()).To(BeFalse())
+	})
+
+	It("holds a failed batch until it is written", func() {
+		w = egress.NewCatchUpWriter(2, backlog, next, metricClient,
+			egress.WithCatchUpRetry(time.Second, time.Minute),
+			egress.WithCatchUpClock(clock.Now, clock.Sleep),
+		)
+		next.FailTimes(2)
+
+		Expect(w.Write(batchOf(10))).To(Succeed())
+		Expect(next.Attempts()).To(Equal(3))
+		Expect(clock.Slept()).To(Equal(2 * time.Second))
+	})
+
+	It("gives up on a batch held for the hold period", func() {
+		w = egress.NewCatchUpWriter(2, backlog, next, metricClient,
+			egress.WithCatchUpRetry(time.Second, 10*time.Second),
+			egress.WithCatchUpClock(clock.Now, clock.Sleep),
+		)
+		next.SetFail(true)
+
+		Expect(w.Write(batchOf(10))).ToNot(Succeed())
+		Expect(next.Attempts()).To(Equal(11))
+	})
+
+	It("gives up on a held batch when stopped", func() {
+		w = egress.NewCatchUpWriter(2, backlog, next, metricClient,
+			egress.WithCatchUpRetry(time.Second, time.Minute),
+			egress.WithCatchUpClock(clock.Now, func(d time.Duration) {
+				clock.Sleep(d)
+				w.Stop()
+			}),
+		)
+		next.SetFail(true)
+
+		Expect(w.Write(batchOf(10))).ToNot(Succeed())
+		Expect(next.Attempts()).To(Equal(1))
+
+		Expect(w.Write(batchOf(10))).ToNot(Succeed())
+		Expect(next.Attempts()).To(Equal(2))
+	})
+
+	Context("with a transponder and connection manager", func() {
+		var (
+			buffer    *diodes.ManyToOneEnvelopeV2
+			connector *toggledConnector
+			manager   *clientpool.ConnManager
+			tx        *egress.Transponder
+		)
+
+		BeforeEach(func() {
+			buffer = diodes.NewManyToOneEnvelopeV2(10000, gendiodes.AlertFunc(func(int) {}))
+			connector = &toggledConnector{}
+			manager = clientpool.NewConnManager(connector, 100000, 10*time.Millisecond)
+			w = egress.NewCatchUpWriter(2, buffer, manager, metricClient,
+				egress.WithCatchUpMinRate(1000),
+				egress.WithCatchUpRetry(10*time.Millisecond, time.Minute),
+			)
+			tx = egress.NewTransponder(buffer, w, nil, 10, 10*time.Millisecond, metricClient)
+			go tx.Start()
+		})
+
+		AfterEach(func() {
+			w.Stop()
+			tx.Stop(time.Second)
+			manager.Close()
+		})
+
+		It("holds the backlog during an outage and paces it once egress recovers", func() {
+			Eventually(func() bool {
+				return manager.Stats().Connected
+			}).Should(BeTrue())
+
+			connector.SetDown(true)
+			buffer.Set(&loggregator_v2.Envelope{SourceId: "during-outage"})
+			Eventually(connector.Attempts).Should(BeNumerically(">", 5))
+
+			for i := 0; i < 999; i++ {
+				buffer.Set(&loggregator_v2.Envelope{SourceId: "during-outage"})
+			}
+			start := time.Now()
+			connector.SetDown(false)
+
+			Eventually(w.CatchingUp).Should(BeTrue())
+			Eventually(connector.Sent, 5).Should(Equal(1000))
+			elapsed := time.Since(start)
+
+			Expect(tx.Dropped()).To(BeZero())
+			Expect(metricClient.GetMetric("catch_up").Delta()).To(BeNumerically(">", 0))
+			// At least 900 envelopes at the minimum rate of 1000/s.
+			Expect(elapsed).To(BeNumerically(">=", 800*time.Millisecond))
+		})
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			return s.Harness(egress.NewCatchUpWriter(2, &spyBacklog{}, s, testhelper.NewMetricClient()))
+		})
+	})
+})
+
+func batchOf(n int) []*loggregator_v2.Envelope {
+	batch := make([]*loggregator_v2.Envelope, n)
+	for i := range batch {
+		batch[i] = &loggregator_v2.Envelope{SourceId: "some-id"}
+	}
+
+	return batch
+}
+
+type flakyWriter struct {
+	mu       sync.Mutex
+	fail     bool
+	failures int
+	attempts int
+}
+
+func (w *flakyWriter) Write([]*loggregator_v2.Envelope) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.attempts++
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("flaky writer failure")
+	}
+	if w.fail {
+		return errors.New("flaky writer failure")
+	}
+
+	return nil
+}
+
+// FailTimes fails the next n writes.
+func (w *flakyWriter) FailTimes(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.failures = n
+}
+
+func (w *flakyWriter) Attempts() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.attempts
+}
+
+func (w *flakyWriter) SetFail(fail bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.fail = fail
+}
+
+type spyBacklog struct {
+	mu    sync.Mutex
+	depth int
+}
+
+func (b *spyBacklog) Depth() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.depth
+}
+
+func (b *spyBacklog) SetDepth(d int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.depth = d
+}
+
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Sleep advances the clock without blocking and records the duration.
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	c.slept += d
+}
+
+func (c *fakeClock) Slept() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.slept
+}
+
+// toggledConnector connects to a doppler that can be taken down, which
+// fails connections and sends while it is down.
+type toggledConnector struct {
+	mu       sync.Mutex
+	down     bool
+	attempts int
+	sent     int
+}
+
+func (c *toggledConnector) Connect() (io.Closer, loggregator_v2.Ingress_BatchSenderClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.attempts++
+	if c.down {
+		return nil, nil, errors.New("doppler unavailable")
+	}
+
+	return ioutil.NopCloser(nil), &toggledClient{connector: c}, nil
+}
+
+func (c *toggledConnector) SetDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.down = down
+}
+
+func (c *toggledConnector) Attempts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.attempts
+}
+
+func (c *toggledConnector) Sent() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sent
+}
+
+type toggledClient struct {
+	loggregator_v2.Ingress_BatchSenderClient
+
+	connector *toggledConnector
+}
+
+func (c *toggledClient) Send(b *loggregator_v2.EnvelopeBatch) error {
+	c.connector.mu.Lock()
+	defer c.connector.mu.Unlock()
+
+	if c.connector.down {
+		return errors.New("stream closed")
+	}
+	c.connector.sent += len(b.Batch)
+
+	return nil
+}
@@ -0,0 +1,142 @@
+// Package conformance is a suite of specs that every v2 egress Writer must
+// pass. Sinks and processors, whether built in or provided by a plugin,
+// register the specs from their own test suites with WriterSpecs.
+package conformance
+
+import (
+	"fmt"
+	"io"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Harness is a Writer under test along with the means to observe and
+// control where it delivers envelopes.
+type Harness struct {
+	// Writer is the Writer under test.
+	Writer egress.Writer
+
+	// Delivered returns every envelope the Writer has delivered downstream.
+	Delivered func() []*loggregator_v2.Envelope
+
+	// FailDownstream causes every later delivery to fail. The specs for
+	// error signaling are skipped when it is nil.
+	FailDownstream func()
+
+	// Egressed returns the number of envelopes the Writer reports as
+	// egressed through its metrics. The specs for metric emission are
+	// skipped when it is nil.
+	Egressed func() uint64
+}
+
+// WriterSpecs registers the conformance specs. newHarness is called before
+// each spec and must return a Writer that has not been written to. It must
+// be called while ginkgo builds the spec tree, for example inside a
+// Describe.
+func WriterSpecs(newHarness func() Harness) {
+	var h Harness
+
+	BeforeEach(func() {
+		h = newHarness()
+	})
+
+	Describe("batching", func() {
+		It("delivers every envelope in a batch", func() {
+			Expect(h.Writer.Write(batch("a", 3))).To(Succeed())
+
+			Eventually(func() []string {
+				return sourceIDs(h.Delivered())
+			}).Should(ConsistOf("a-0", "a-1", "a-2"))
+		})
+
+		It("delivers every envelope across batches", func() {
+			Expect(h.Writer.Write(batch("a", 2))).To(Succeed())
+			Expect(h.Writer.Write(batch("b", 2))).To(Succeed())
+
+			Eventually(func() []string {
+				return sourceIDs(h.Delivered())
+			}).Should(ConsistOf("a-0", "a-1", "b-0", "b-1"))
+		})
+
+		It("accepts an empty batch", func() {
+			Expect(h.Writer.Write(nil)).To(Succeed())
+		})
+
+		It("does not reorder or resize the caller's batch", func() {
+			b := batch("a", 3)
+			original := make([]*loggregator_v2.Envelope, len(b))
+			copy(original, b)
+
+			Expect(h.Writer.Write(b)).To(Succeed())
+
+			Expect(b).To(HaveLen(len(original)))
+			for i := range b {
+				Expect(b[i]).To(BeIdenticalTo(original[i]))
+			}
+		})
+	})
+
+	Describe("retry signaling", func() {
+		It("returns an error when envelopes cannot be delivered", func() {
+			if h.FailDownstream == nil {
+				Skip("harness cannot fail downstream")
+			}
+			h.FailDownstream()
+
+			Expect(h.Writer.Write(batch("a", 1))).ToNot(Succeed())
+		})
+	})
+
+	Describe("shutdown", func() {
+		It("delivers every written envelope before Close returns", func() {
+			c, ok := h.Writer.(io.Closer)
+			if !ok {
+				Skip("writer does not implement io.Closer")
+			}
+
+			Expect(h.Writer.Write(batch("a", 3))).To(Succeed())
+			Expect(c.Close()).To(Succeed())
+
+			Expect(sourceIDs(h.Delivered())).To(ConsistOf("a-0", "a-1", "a-2"))
+		})
+	})
+
+	Describe("metrics", func() {
+		It("counts every egressed envelope", func() {
+			if h.Egressed == nil {
+				Skip("harness does not observe metrics")
+			}
+
+			Expect(h.Writer.Write(batch("a", 3))).To(Succeed())
+
+			Eventually(h.Egressed).Should(Equal(uint64(3)))
+		})
+	})
+}
+
+func batch(prefix string, n int) []*loggregator_v2.Envelope {
+	b := make([]*loggregator_v2.Envelope, 0, n)
+	for i := 0; i < n; i++ {
+		b = append(b, &loggregator_v2.Envelope{
+			SourceId: fmt.Sprintf("%s-%d", prefix, i),
+			Message: &loggregator_v2.Envelope_Log{
+				Log: &loggregator_v2.Log{Payload: []byte("conformance")},
+			},
+		})
+	}
+
+	return b
+}
+
+func sourceIDs(envelopes []*loggregator_v2.Envelope) []string {
+	ids := make([]string, 0, len(envelopes))
+	for _, e := range envelopes {
+		ids = append(ids, e.GetSourceId())
+	}
+
+	return ids
+}
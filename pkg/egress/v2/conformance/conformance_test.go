@@ -0,0 +1,14 @@
+package conformance_test
+
+import (
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("SpyWriter", func() {
+	conformance.WriterSpecs(func() conformance.Harness {
+		s := conformance.NewSpyWriter()
+		return s.Harness(s)
+	})
+})
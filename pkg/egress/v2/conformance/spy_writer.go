@@ -0,0 +1,63 @@
+package conformance
+
+import (
+	"errors"
+	"sync"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+)
+
+// SpyWriter is a downstream Writer that records what it is given. It can be
+// used to build a Harness for Writers that wrap another Writer.
+type SpyWriter struct {
+	mu        sync.Mutex
+	delivered []*loggregator_v2.Envelope
+	fail      bool
+}
+
+// NewSpyWriter returns a SpyWriter that accepts every batch.
+func NewSpyWriter() *SpyWriter {
+	return &SpyWriter{}
+}
+
+// Write records the batch or returns an error if Fail has been called.
+func (s *SpyWriter) Write(batch []*loggregator_v2.Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fail {
+		return errors.New("spy writer failure")
+	}
+	s.delivered = append(s.delivered, batch...)
+
+	return nil
+}
+
+// Delivered returns every envelope written to the SpyWriter.
+func (s *SpyWriter) Delivered() []*loggregator_v2.Envelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := make([]*loggregator_v2.Envelope, len(s.delivered))
+	copy(d, s.delivered)
+
+	return d
+}
+
+// Fail causes every later write to return an error.
+func (s *SpyWriter) Fail() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fail = true
+}
+
+// Harness returns a Harness for the given Writer that delivers to s.
+func (s *SpyWriter) Harness(w egress.Writer) Harness {
+	return Harness{
+		Writer:         w,
+		Delivered:      s.Delivered,
+		FailDownstream: s.Fail,
+	}
+}
@@ -0,0 +1,38 @@
+package v2_test
+
+import (
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("Writer conformance", func() {
+	Describe("CounterAggregator", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			return s.Harness(egress.NewCounterAggregator(s))
+		})
+	})
+
+	Describe("ReplayTimestampWriter", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			return s.Harness(egress.NewReplayTimestampWriter(egress.RewriteTimestamps, s))
+		})
+	})
+
+	Describe("BufferedWriter", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			w := egress.NewBufferedWriter("spy", 100, s, testhelper.NewMetricClient())
+			go w.Start()
+
+			// Failures are counted as drops rather than returned.
+			h := s.Harness(w)
+			h.FailDownstream = nil
+			return h
+		})
+	})
+})
@@ -0,0 +1,35 @@
+package v2
+
+import "time"
+
+// The bounds of the delay between attempts to connect to a destination.
+const (
+	minConnectBackoff = time.Second
+	maxConnectBackoff = time.Minute
+)
+
+// retryConnect calls connect until it succeeds or done is closed. The delay
+// between attempts starts at minBackoff and doubles up to maxBackoff. It is
+// used by writers whose clients fail to be created while their destination
+// is down, so that an unavailable destination does not stop the agent.
+func retryConnect(name string, connect func() error, done <-chan struct{}, minBackoff, maxBackoff time.Duration) {
+	backoff := minBackoff
+	for {
+		err := connect()
+		if err == nil {
+			return
+		}
+		logger.Warnf("failed to connect to %s, retrying in %s: %s", name, backoff, err)
+
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
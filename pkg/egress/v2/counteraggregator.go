@@ -1,11 +1,15 @@
 package v2
 
 import (
+	"container/list"
 	"crypto/sha1"
 	"fmt"
 	"io"
 	"sort"
+	"sync"
+	"time"
 
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 )
 
@@ -14,46 +18,232 @@ type counterID struct {
 	tagsHash string
 }
 
+// CounterEviction is how a CounterAggregator makes room for a new counter
+// when it is tracking the maximum number of counters.
+type CounterEviction int
+
+const (
+	// EvictAllCounters forgets the totals of every tracked counter.
+	EvictAllCounters CounterEviction = iota
+
+	// EvictLeastRecentCounter forgets the total of the counter that was
+	// updated least recently.
+	EvictLeastRecentCounter
+)
+
+// ParseCounterEviction returns the CounterEviction with the given name:
+// "all" or "least_recent".
+func ParseCounterEviction(name string) (CounterEviction, error) {
+	switch name {
+	case "all":
+		return EvictAllCounters, nil
+	case "least_recent":
+		return EvictLeastRecentCounter, nil
+	default:
+		return 0, fmt.Errorf("unknown counter eviction policy %q", name)
+	}
+}
+
+// trackedCounter is the running total of a counter.
+type trackedCounter struct {
+	id      counterID
+	total   uint64
+	updated time.Time
+}
+
+// CounterAggregator sets the total of counter envelopes that only have a
+// delta to the sum of the deltas seen for the counter.
 type CounterAggregator struct {
-	writer        Writer
-	counterTotals map[counterID]uint64
+	writer       Writer
+	maxTracked   int
+	eviction     CounterEviction
+	staleAfter   time.Duration
+	window       time.Duration
+	now          func() time.Time
+	trackedGauge pulseemitter.GaugeMetric
+
+	mu          sync.Mutex
+	windowStart time.Time
+
+	// counters holds every tracked counter, ordered from the most to the
+	// least recently updated.
+	counters      *list.List
+	counterTotals map[counterID]*list.Element
+}
+
+// CounterAggregatorOption configures a CounterAggregator.
+type CounterAggregatorOption func(*CounterAggregator)
+
+// WithCounterMaxTracked sets the number of counters whose totals are
+// tracked before the eviction policy is applied. The default is 10000.
+func WithCounterMaxTracked(n int) CounterAggregatorOption {
+	return func(ca *CounterAggregator) {
+		ca.maxTracked = n
+	}
 }
 
-func NewCounterAggregator(w Writer) *CounterAggregator {
-	return &CounterAggregator{
+// WithCounterEviction sets how room is made for a new counter when the
+// maximum number of counters are tracked. The default is EvictAllCounters.
+func WithCounterEviction(e CounterEviction) CounterAggregatorOption {
+	return func(ca *CounterAggregator) {
+		ca.eviction = e
+	}
+}
+
+// WithCounterStaleAfter sets how long a counter that is not updated is
+// tracked for. By default counters are tracked until they are evicted.
+func WithCounterStaleAfter(d time.Duration) CounterAggregatorOption {
+	return func(ca *CounterAggregator) {
+		ca.staleAfter = d
+	}
+}
+
+// WithCounterWindow sets the window that totals are aggregated over. Every
+// total restarts from zero at the end of each window. By default totals
+// are aggregated indefinitely.
+func WithCounterWindow(d time.Duration) CounterAggregatorOption {
+	return func(ca *CounterAggregator) {
+		ca.window = d
+	}
+}
+
+// WithCounterTrackedGauge sets a gauge that is set to the number of tracked
+// counters after every write, to detect an explosion of counter tags.
+func WithCounterTrackedGauge(g pulseemitter.GaugeMetric) CounterAggregatorOption {
+	return func(ca *CounterAggregator) {
+		ca.trackedGauge = g
+	}
+}
+
+// WithCounterClock sets the function used to read the time. It is intended
+// for tests.
+func WithCounterClock(now func() time.Time) CounterAggregatorOption {
+	return func(ca *CounterAggregator) {
+		ca.now = now
+	}
+}
+
+func NewCounterAggregator(w Writer, opts ...CounterAggregatorOption) *CounterAggregator {
+	ca := &CounterAggregator{
 		writer:        w,
-		counterTotals: make(map[counterID]uint64),
+		maxTracked:    10000,
+		now:           time.Now,
+		counters:      list.New(),
+		counterTotals: make(map[counterID]*list.Element),
+	}
+
+	for _, o := range opts {
+		o(ca)
 	}
+
+	return ca
 }
 
 func (ca *CounterAggregator) Write(msgs []*loggregator_v2.Envelope) error {
+	ca.mu.Lock()
+	now := ca.now()
+	ca.expire(now)
+
 	for i := range msgs {
 		c := msgs[i].GetCounter()
 		if c != nil {
-			if len(ca.counterTotals) > 10000 {
-				ca.resetTotals()
-			}
-
 			id := counterID{
 				name:     c.Name,
 				tagsHash: hashTags(msgs[i].GetDeprecatedTags()),
 			}
 
+			tc := ca.track(id, now)
+
 			if c.GetTotal() != 0 {
-				ca.counterTotals[id] = c.GetTotal()
+				tc.total = c.GetTotal()
 				continue
 			}
 
-			ca.counterTotals[id] = ca.counterTotals[id] + c.GetDelta()
-			c.Total = ca.counterTotals[id]
+			tc.total = tc.total + c.GetDelta()
+			c.Total = tc.total
 		}
 	}
 
+	tracked := len(ca.counterTotals)
+	ca.mu.Unlock()
+
+	if ca.trackedGauge != nil {
+		ca.trackedGauge.Set(float64(tracked))
+	}
+
 	return ca.writer.Write(msgs)
 }
 
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (ca *CounterAggregator) BorrowsBatches() bool {
+	return BorrowsBatches(ca.writer)
+}
+
+// Tracked returns the number of counters whose totals are tracked.
+func (ca *CounterAggregator) Tracked() int {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	return len(ca.counterTotals)
+}
+
+// track returns the tracked counter with the given ID, tracking it if it is
+// new, and marks it as updated.
+func (ca *CounterAggregator) track(id counterID, now time.Time) *trackedCounter {
+	if ca.eviction == EvictAllCounters && len(ca.counterTotals) > ca.maxTracked {
+		ca.resetTotals()
+	}
+
+	if e, ok := ca.counterTotals[id]; ok {
+		ca.counters.MoveToFront(e)
+		tc := e.Value.(*trackedCounter)
+		tc.updated = now
+		return tc
+	}
+
+	if ca.eviction == EvictLeastRecentCounter {
+		for len(ca.counterTotals) >= ca.maxTracked && ca.counters.Len() > 0 {
+			ca.remove(ca.counters.Back())
+		}
+	}
+
+	tc := &trackedCounter{id: id, updated: now}
+	ca.counterTotals[id] = ca.counters.PushFront(tc)
+
+	return tc
+}
+
+// expire forgets every total at the end of a window and the totals of
+// counters that have gone stale.
+func (ca *CounterAggregator) expire(now time.Time) {
+	if ca.window > 0 {
+		if ca.windowStart.IsZero() {
+			ca.windowStart = now
+		}
+		if now.Sub(ca.windowStart) >= ca.window {
+			ca.windowStart = now
+			ca.resetTotals()
+		}
+	}
+
+	if ca.staleAfter > 0 {
+		for e := ca.counters.Back(); e != nil; e = ca.counters.Back() {
+			if now.Sub(e.Value.(*trackedCounter).updated) < ca.staleAfter {
+				break
+			}
+			ca.remove(e)
+		}
+	}
+}
+
+func (ca *CounterAggregator) remove(e *list.Element) {
+	ca.counters.Remove(e)
+	delete(ca.counterTotals, e.Value.(*trackedCounter).id)
+}
+
 func (ca *CounterAggregator) resetTotals() {
-	ca.counterTotals = make(map[counterID]uint64)
+	ca.counters.Init()
+	ca.counterTotals = make(map[counterID]*list.Element)
 }
 
 // hashTags only uses the deprecated tags because agent only egresses
@@ -2,8 +2,10 @@ package v2_test
 
 import (
 	"fmt"
+	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
 	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
 
 	. "github.com/onsi/ginkgo"
@@ -155,6 +157,98 @@ var _ = Describe("Counteraggregator", func() {
 		Expect(receivedEnvelopes).To(HaveLen(1))
 		Expect(receivedEnvelopes[0].GetCounter().GetDelta()).To(Equal(uint64(10)))
 	})
+
+	It("evicts the least recently updated counter when configured", func() {
+		mockWriter := newMockWriter()
+		close(mockWriter.WriteOutput.Ret0)
+
+		aggregator := egress.NewCounterAggregator(mockWriter,
+			egress.WithCounterMaxTracked(2),
+			egress.WithCounterEviction(egress.EvictLeastRecentCounter),
+		)
+		aggregator.Write(buildCounterEnvelope(10, "name-1", "origin-1"))
+		aggregator.Write(buildCounterEnvelope(10, "name-2", "origin-1"))
+		aggregator.Write(buildCounterEnvelope(10, "name-1", "origin-1"))
+		aggregator.Write(buildCounterEnvelope(10, "name-3", "origin-1"))
+		Expect(aggregator.Tracked()).To(Equal(2))
+
+		aggregator.Write(buildCounterEnvelope(10, "name-1", "origin-1"))
+		aggregator.Write(buildCounterEnvelope(10, "name-2", "origin-1"))
+
+		var receivedEnvelopes []*loggregator_v2.Envelope
+		for i := 0; i < 5; i++ {
+			Expect(mockWriter.WriteInput.Msg).To(Receive())
+		}
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelopes))
+		Expect(receivedEnvelopes[0].GetCounter().GetTotal()).To(Equal(uint64(10)))
+	})
+
+	It("evicts counters that have not been updated recently", func() {
+		mockWriter := newMockWriter()
+		close(mockWriter.WriteOutput.Ret0)
+
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		aggregator := egress.NewCounterAggregator(mockWriter,
+			egress.WithCounterStaleAfter(time.Minute),
+			egress.WithCounterClock(clock.Now),
+		)
+		aggregator.Write(buildCounterEnvelope(10, "name-1", "origin-1"))
+		aggregator.Write(buildCounterEnvelope(10, "name-2", "origin-1"))
+
+		clock.Advance(45 * time.Second)
+		aggregator.Write(buildCounterEnvelope(10, "name-2", "origin-1"))
+
+		clock.Advance(45 * time.Second)
+		aggregator.Write(buildCounterEnvelope(10, "name-3", "origin-1"))
+		Expect(aggregator.Tracked()).To(Equal(2))
+
+		aggregator.Write(buildCounterEnvelope(10, "name-1", "origin-1"))
+
+		var receivedEnvelopes []*loggregator_v2.Envelope
+		for i := 0; i < 4; i++ {
+			Expect(mockWriter.WriteInput.Msg).To(Receive())
+		}
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelopes))
+		Expect(receivedEnvelopes[0].GetCounter().GetTotal()).To(Equal(uint64(10)))
+	})
+
+	It("restarts every total at the end of each window", func() {
+		mockWriter := newMockWriter()
+		close(mockWriter.WriteOutput.Ret0)
+
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		aggregator := egress.NewCounterAggregator(mockWriter,
+			egress.WithCounterWindow(time.Minute),
+			egress.WithCounterClock(clock.Now),
+		)
+		aggregator.Write(buildCounterEnvelope(10, "name-1", "origin-1"))
+		clock.Advance(30 * time.Second)
+		aggregator.Write(buildCounterEnvelope(15, "name-1", "origin-1"))
+		clock.Advance(30 * time.Second)
+		aggregator.Write(buildCounterEnvelope(5, "name-1", "origin-1"))
+
+		var receivedEnvelopes []*loggregator_v2.Envelope
+		Expect(mockWriter.WriteInput.Msg).To(Receive())
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelopes))
+		Expect(receivedEnvelopes[0].GetCounter().GetTotal()).To(Equal(uint64(25)))
+		Expect(mockWriter.WriteInput.Msg).To(Receive(&receivedEnvelopes))
+		Expect(receivedEnvelopes[0].GetCounter().GetTotal()).To(Equal(uint64(5)))
+	})
+
+	It("sets a gauge to the number of tracked counters", func() {
+		mockWriter := newMockWriter()
+		close(mockWriter.WriteOutput.Ret0)
+
+		metricClient := testhelper.NewMetricClient()
+		aggregator := egress.NewCounterAggregator(mockWriter,
+			egress.WithCounterTrackedGauge(metricClient.NewGaugeMetric("tracked_counters", "counters")),
+		)
+		aggregator.Write(buildCounterEnvelope(10, "name-1", "origin-1"))
+		aggregator.Write(buildCounterEnvelope(10, "name-1", "origin-2"))
+		aggregator.Write(buildCounterEnvelope(10, "name-2", "origin-1"))
+
+		Expect(metricClient.GetMetric("tracked_counters").GaugeValue()).To(Equal(3.0))
+	})
 })
 
 func buildCounterEnvelope(delta uint64, name, origin string) []*loggregator_v2.Envelope {
@@ -0,0 +1,186 @@
+package v2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// StdoutDebugOutput is the debug output that writes to the process's
+// stdout.
+const StdoutDebugOutput = "stdout"
+
+// DebugWriter passes every batch to the next Writer and, while an output is
+// set, also writes each envelope to the output as a single human readable
+// line. The output can be changed at any time.
+//
+// As the output is set through the admin API, files are only written to in
+// a directory fixed when the DebugWriter is created.
+type DebugWriter struct {
+	next   Writer
+	stdout io.Writer
+	dir    string
+
+	mu     sync.Mutex
+	output string
+	out    io.Writer
+	file   *os.File
+}
+
+// DebugOption configures a DebugWriter.
+type DebugOption func(*DebugWriter)
+
+// WithDebugDir sets the directory debug output files are written to. Without
+// it only StdoutDebugOutput can be set.
+func WithDebugDir(dir string) DebugOption {
+	return func(w *DebugWriter) {
+		w.dir = dir
+	}
+}
+
+// NewDebugWriter returns a DebugWriter with no output set.
+func NewDebugWriter(next Writer, opts ...DebugOption) *DebugWriter {
+	w := &DebugWriter{
+		next:   next,
+		stdout: os.Stdout,
+	}
+	for _, o := range opts {
+		o(w)
+	}
+
+	return w
+}
+
+// Write writes the batch to the output, if one is set, and to the next
+// Writer.
+func (w *DebugWriter) Write(batch []*loggregator_v2.Envelope) error {
+	w.mu.Lock()
+	if w.out != nil {
+		bw := bufio.NewWriter(w.out)
+		for _, e := range batch {
+			bw.WriteString(formatDebug(e))
+			bw.WriteByte('\n')
+		}
+		if err := bw.Flush(); err != nil {
+			logger.Warnf("failed to write debug output to %s: %s", w.output, err)
+		}
+	}
+	w.mu.Unlock()
+
+	return w.next.Write(batch)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (w *DebugWriter) BorrowsBatches() bool {
+	return BorrowsBatches(w.next)
+}
+
+// SetOutput sets where envelopes are written: StdoutDebugOutput, the name
+// of a file in the debug directory to append to, or the empty string to stop
+// writing envelopes. Names that are paths rather than plain file names are
+// rejected.
+func (w *DebugWriter) SetOutput(output string) error {
+	var (
+		out  io.Writer
+		file *os.File
+	)
+	switch output {
+	case "":
+	case StdoutDebugOutput:
+		out = w.stdout
+	default:
+		if w.dir == "" {
+			return fmt.Errorf("no debug directory is configured, only %q can be written to", StdoutDebugOutput)
+		}
+		if output != filepath.Base(output) || output == "." || output == ".." {
+			return fmt.Errorf("debug output must be a file name in the debug directory: %q", output)
+		}
+
+		f, err := os.OpenFile(filepath.Join(w.dir, output), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			return err
+		}
+		out, file = f, f
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.output, w.out, w.file = output, out, file
+
+	return nil
+}
+
+// Output returns where envelopes are being written, or the empty string if
+// they are not.
+func (w *DebugWriter) Output() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.output
+}
+
+// formatDebug returns a human readable representation of the envelope.
+func formatDebug(e *loggregator_v2.Envelope) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %s",
+		time.Unix(0, e.GetTimestamp()).UTC().Format(time.RFC3339Nano),
+		e.GetSourceId(),
+	)
+	if e.GetInstanceId() != "" {
+		fmt.Fprintf(&b, "/%s", e.GetInstanceId())
+	}
+
+	switch m := e.Message.(type) {
+	case *loggregator_v2.Envelope_Log:
+		fmt.Fprintf(&b, " log %s %q", m.Log.GetType(), m.Log.GetPayload())
+	case *loggregator_v2.Envelope_Counter:
+		fmt.Fprintf(&b, " counter %s delta=%d total=%d",
+			m.Counter.GetName(), m.Counter.GetDelta(), m.Counter.GetTotal())
+	case *loggregator_v2.Envelope_Gauge:
+		b.WriteString(" gauge")
+		names := make([]string, 0, len(m.Gauge.GetMetrics()))
+		for name := range m.Gauge.GetMetrics() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			v := m.Gauge.GetMetrics()[name]
+			fmt.Fprintf(&b, " %s=%g%s", name, v.GetValue(), v.GetUnit())
+		}
+	case *loggregator_v2.Envelope_Timer:
+		fmt.Fprintf(&b, " timer %s %s",
+			m.Timer.GetName(), time.Duration(m.Timer.GetStop()-m.Timer.GetStart()))
+	case *loggregator_v2.Envelope_Event:
+		fmt.Fprintf(&b, " event %q %q", m.Event.GetTitle(), m.Event.GetBody())
+	default:
+		b.WriteString(" unknown")
+	}
+
+	if len(e.GetTags()) > 0 {
+		names := make([]string, 0, len(e.GetTags()))
+		for name := range e.GetTags() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		b.WriteString(" tags:")
+		for _, name := range names {
+			fmt.Fprintf(&b, " %s=%q", name, e.GetTags()[name])
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package v2_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DebugWriter", func() {
+	var (
+		next *flakyWriter
+		dir  string
+		path string
+		w    *egress.DebugWriter
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "debug")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "envelopes.log")
+
+		next = &flakyWriter{}
+		w = egress.NewDebugWriter(next, egress.WithDebugDir(dir))
+	})
+
+	AfterEach(func() {
+		w.SetOutput("")
+		os.RemoveAll(dir)
+	})
+
+	readOutput := func() string {
+		data, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		return string(data)
+	}
+
+	It("passes batches to the next writer without output", func() {
+		Expect(w.Write(batchOf(2))).To(Succeed())
+
+		Expect(w.Output()).To(BeEmpty())
+		_, err := os.Stat(path)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("writes each envelope as a line to a file", func() {
+		Expect(w.SetOutput("envelopes.log")).To(Succeed())
+		Expect(w.Output()).To(Equal("envelopes.log"))
+
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{
+				Timestamp:  1500000000000000000,
+				SourceId:   "some-app",
+				InstanceId: "2",
+				Tags:       map[string]string{"job": "router"},
+				Message: &loggregator_v2.Envelope_Log{
+					Log: &loggregator_v2.Log{Payload: []byte("hello\n"), Type: loggregator_v2.Log_ERR},
+				},
+			},
+			{
+				SourceId: "some-app",
+				Message: &loggregator_v2.Envelope_Counter{
+					Counter: &loggregator_v2.Counter{Name: "requests", Delta: 1, Total: 5},
+				},
+			},
+			{
+				SourceId: "some-app",
+				Message: &loggregator_v2.Envelope_Gauge{
+					Gauge: &loggregator_v2.Gauge{Metrics: map[string]*loggregator_v2.GaugeValue{
+						"memory": {Value: 1024, Unit: "bytes"},
+						"cpu":    {Value: 0.5, Unit: "percentage"},
+					}},
+				},
+			},
+		})).To(Succeed())
+
+		Expect(readOutput()).To(Equal(
+			`2017-07-14T02:40:00Z some-app/2 log ERR "hello\n" tags: job="router"` + "\n" +
+				`1970-01-01T00:00:00Z some-app counter requests delta=1 total=5` + "\n" +
+				`1970-01-01T00:00:00Z some-app gauge cpu=0.5percentage memory=1024bytes` + "\n",
+		))
+	})
+
+	It("stops writing when the output is cleared", func() {
+		Expect(w.SetOutput("envelopes.log")).To(Succeed())
+		Expect(w.Write(batchOf(1))).To(Succeed())
+		written := readOutput()
+
+		Expect(w.SetOutput("")).To(Succeed())
+		Expect(w.Write(batchOf(1))).To(Succeed())
+
+		Expect(readOutput()).To(Equal(written))
+		Expect(w.Output()).To(BeEmpty())
+	})
+
+	It("rejects outputs outside of the debug directory", func() {
+		for _, output := range []string{
+			filepath.Join(dir, "envelopes.log"),
+			"../envelopes.log",
+			"missing/envelopes.log",
+			"..",
+		} {
+			Expect(w.SetOutput(output)).ToNot(Succeed(), output)
+		}
+		Expect(w.Output()).To(BeEmpty())
+	})
+
+	It("only writes to stdout without a debug directory", func() {
+		w = egress.NewDebugWriter(next)
+
+		Expect(w.SetOutput("envelopes.log")).ToNot(Succeed())
+		Expect(w.SetOutput(egress.StdoutDebugOutput)).To(Succeed())
+	})
+
+	It("returns errors from the next writer", func() {
+		next.SetFail(true)
+
+		Expect(w.Write(batchOf(1))).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,196 @@
+package v2
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// maxSummaryPayload is the longest prefix of a suppressed log message that
+// is included in its summary.
+const maxSummaryPayload = 100
+
+// Deduplicator suppresses log envelopes that repeat a log from the same
+// source instance within a window, such as those from an application that
+// is crash looping. The first log of a window is written. When the window
+// ends, a summary log reporting the number of duplicates suppressed is
+// written with the next batch.
+//
+// Only log envelopes are deduplicated. Two logs are duplicates if they have
+// the same source ID, instance ID, type and payload.
+type Deduplicator struct {
+	window           time.Duration
+	maxEntries       int
+	now              func() time.Time
+	next             Writer
+	suppressedMetric pulseemitter.CounterMetric
+
+	mu      sync.Mutex
+	entries map[uint64]*dedupEntry
+}
+
+type dedupEntry struct {
+	start      time.Time
+	suppressed int
+	first      *loggregator_v2.Envelope
+}
+
+// DeduplicatorOption configures a Deduplicator.
+type DeduplicatorOption func(*Deduplicator)
+
+// WithDedupMaxEntries sets the number of distinct logs tracked at once.
+// Logs seen while the limit is reached are not deduplicated. The default
+// is 10000.
+func WithDedupMaxEntries(n int) DeduplicatorOption {
+	return func(d *Deduplicator) {
+		d.maxEntries = n
+	}
+}
+
+// WithDedupClock sets the function used to read the time. It is intended
+// for tests.
+func WithDedupClock(now func() time.Time) DeduplicatorOption {
+	return func(d *Deduplicator) {
+		d.now = now
+	}
+}
+
+// NewDeduplicator returns a Deduplicator that suppresses duplicate logs
+// within the given window.
+func NewDeduplicator(window time.Duration, next Writer, m MetricClient, opts ...DeduplicatorOption) *Deduplicator {
+	// metric-documentation-v2: (loggregator.metron.dropped) Number of
+	// duplicate logs suppressed by deduplication
+	suppressedMetric := m.NewCounterMetric("dropped",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"direction": "dedup"}),
+	)
+
+	d := &Deduplicator{
+		window:           window,
+		maxEntries:       10000,
+		now:              time.Now,
+		next:             next,
+		suppressedMetric: suppressedMetric,
+		entries:          make(map[uint64]*dedupEntry),
+	}
+
+	for _, o := range opts {
+		o(d)
+	}
+
+	return d
+}
+
+// Write writes the envelopes that are not duplicates, along with the
+// summaries of windows that have ended, to the next Writer.
+func (d *Deduplicator) Write(batch []*loggregator_v2.Envelope) error {
+	d.mu.Lock()
+	now := d.now()
+	kept := d.expire(now, false)
+
+	var suppressed int
+	for _, e := range batch {
+		if e.GetLog() == nil {
+			kept = append(kept, e)
+			continue
+		}
+
+		key := dedupKey(e)
+		if entry, ok := d.entries[key]; ok {
+			entry.suppressed++
+			suppressed++
+			continue
+		}
+
+		if len(d.entries) < d.maxEntries {
+			d.entries[key] = &dedupEntry{start: now, first: e}
+		}
+		kept = append(kept, e)
+	}
+	d.mu.Unlock()
+
+	if suppressed > 0 {
+		d.suppressedMetric.Increment(uint64(suppressed))
+	}
+
+	if len(kept) == 0 {
+		return nil
+	}
+
+	return d.next.Write(kept)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (d *Deduplicator) BorrowsBatches() bool {
+	return BorrowsBatches(d.next)
+}
+
+// Flush writes the summaries of every window, ended or not, to the next
+// Writer.
+func (d *Deduplicator) Flush(timeout time.Duration) uint64 {
+	d.mu.Lock()
+	summaries := d.expire(d.now(), true)
+	d.mu.Unlock()
+
+	return flushTo(d.next, summaries)
+}
+
+// expire removes the entries whose window has ended, or every entry if all
+// is set, and returns summaries for those that suppressed duplicates.
+func (d *Deduplicator) expire(now time.Time, all bool) []*loggregator_v2.Envelope {
+	var summaries []*loggregator_v2.Envelope
+	for key, entry := range d.entries {
+		if !all && now.Sub(entry.start) < d.window {
+			continue
+		}
+		delete(d.entries, key)
+
+		if entry.suppressed > 0 {
+			summaries = append(summaries, dedupSummary(entry, now))
+		}
+	}
+
+	return summaries
+}
+
+func dedupSummary(entry *dedupEntry, now time.Time) *loggregator_v2.Envelope {
+	first := entry.first
+
+	payload := first.GetLog().GetPayload()
+	if len(payload) > maxSummaryPayload {
+		payload = payload[:maxSummaryPayload]
+	}
+
+	tags := make(map[string]string, len(first.GetTags()))
+	for k, v := range first.GetTags() {
+		tags[k] = v
+	}
+
+	return &loggregator_v2.Envelope{
+		Timestamp:  now.UnixNano(),
+		SourceId:   first.GetSourceId(),
+		InstanceId: first.GetInstanceId(),
+		Tags:       tags,
+		Message: &loggregator_v2.Envelope_Log{
+			Log: &loggregator_v2.Log{
+				Payload: []byte(fmt.Sprintf("suppressed %d duplicates of %q", entry.suppressed, payload)),
+				Type:    first.GetLog().GetType(),
+			},
+		},
+	}
+}
+
+func dedupKey(e *loggregator_v2.Envelope) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(e.GetSourceId()))
+	h.Write([]byte{0})
+	h.Write([]byte(e.GetInstanceId()))
+	h.Write([]byte{0, byte(e.GetLog().GetType())})
+	h.Write(e.GetLog().GetPayload())
+
+	return h.Sum64()
+}
@@ -0,0 +1,153 @@
+package v2_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deduplicator", func() {
+	var (
+		next         *conformance.SpyWriter
+		clock        *fakeClock
+		metricClient *testhelper.SpyMetricClient
+		d            *egress.Deduplicator
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		clock = &fakeClock{now: time.Unix(0, 0)}
+		metricClient = testhelper.NewMetricClient()
+		d = egress.NewDeduplicator(
+			10*time.Second,
+			next,
+			metricClient,
+			egress.WithDedupClock(clock.Now),
+		)
+	})
+
+	logFrom := func(sourceID, instanceID, payload string) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId:   sourceID,
+			InstanceId: instanceID,
+			Tags:       map[string]string{"job": "some-job"},
+			Message: &loggregator_v2.Envelope_Log{
+				Log: &loggregator_v2.Log{Payload: []byte(payload), Type: loggregator_v2.Log_ERR},
+			},
+		}
+	}
+
+	payloads := func() []string {
+		var p []string
+		for _, e := range next.Delivered() {
+			p = append(p, string(e.GetLog().GetPayload()))
+		}
+		return p
+	}
+
+	It("suppresses duplicate logs within the window", func() {
+		Expect(d.Write([]*loggregator_v2.Envelope{
+			logFrom("app", "0", "crashed"),
+			logFrom("app", "0", "crashed"),
+			logFrom("app", "1", "crashed"),
+			logFrom("app", "0", "restarting"),
+		})).To(Succeed())
+		clock.Advance(5 * time.Second)
+		Expect(d.Write([]*loggregator_v2.Envelope{logFrom("app", "0", "crashed")})).To(Succeed())
+
+		Expect(payloads()).To(Equal([]string{"crashed", "crashed", "restarting"}))
+		Expect(metricClient.GetMetric("dropped").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("writes a summary when the window ends", func() {
+		Expect(d.Write([]*loggregator_v2.Envelope{
+			logFrom("app", "0", "crashed"),
+			logFrom("app", "0", "crashed"),
+			logFrom("app", "0", "crashed"),
+		})).To(Succeed())
+
+		clock.Advance(10 * time.Second)
+		Expect(d.Write([]*loggregator_v2.Envelope{logFrom("app", "0", "crashed")})).To(Succeed())
+
+		Expect(payloads()).To(Equal([]string{
+			"crashed",
+			`suppressed 2 duplicates of "crashed"`,
+			"crashed",
+		}))
+
+		summary := next.Delivered()[1]
+		Expect(summary.SourceId).To(Equal("app"))
+		Expect(summary.InstanceId).To(Equal("0"))
+		Expect(summary.Tags).To(Equal(map[string]string{"job": "some-job"}))
+		Expect(summary.GetLog().GetType()).To(Equal(loggregator_v2.Log_ERR))
+		Expect(summary.Timestamp).To(Equal(time.Unix(10, 0).UnixNano()))
+	})
+
+	It("writes the summaries of open windows when flushed", func() {
+		Expect(d.Write([]*loggregator_v2.Envelope{
+			logFrom("app", "0", "crashed"),
+			logFrom("app", "0", "crashed"),
+			logFrom("app", "1", "started"),
+		})).To(Succeed())
+
+		Expect(d.Flush(time.Second)).To(BeZero())
+
+		Expect(payloads()).To(Equal([]string{
+			"crashed",
+			"started",
+			`suppressed 1 duplicates of "crashed"`,
+		}))
+	})
+
+	It("does not write a summary when nothing was suppressed", func() {
+		Expect(d.Write([]*loggregator_v2.Envelope{logFrom("app", "0", "started")})).To(Succeed())
+
+		clock.Advance(10 * time.Second)
+		Expect(d.Write([]*loggregator_v2.Envelope{logFrom("app", "0", "started")})).To(Succeed())
+
+		Expect(payloads()).To(Equal([]string{"started", "started"}))
+	})
+
+	It("does not deduplicate other envelope types", func() {
+		counter := &loggregator_v2.Envelope{
+			SourceId: "app",
+			Message:  &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{Name: "requests"}},
+		}
+
+		Expect(d.Write([]*loggregator_v2.Envelope{counter, counter})).To(Succeed())
+
+		Expect(next.Delivered()).To(HaveLen(2))
+	})
+
+	It("stops tracking logs once the maximum is reached", func() {
+		d = egress.NewDeduplicator(
+			10*time.Second,
+			next,
+			metricClient,
+			egress.WithDedupMaxEntries(1),
+			egress.WithDedupClock(clock.Now),
+		)
+
+		Expect(d.Write([]*loggregator_v2.Envelope{
+			logFrom("app", "0", "a"),
+			logFrom("app", "0", "b"),
+			logFrom("app", "0", "b"),
+			logFrom("app", "0", "a"),
+		})).To(Succeed())
+
+		Expect(payloads()).To(Equal([]string{"a", "b", "b"}))
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			return s.Harness(egress.NewDeduplicator(time.Minute, s, testhelper.NewMetricClient()))
+		})
+	})
+})
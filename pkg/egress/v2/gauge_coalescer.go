@@ -0,0 +1,172 @@
+package v2
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// GaugeCoalescer collapses gauge envelopes that report the same gauge within
+// an interval into the latest of them, for sources that emit gauges more
+// often than anything downstream needs them. Gauges are held until the
+// interval ends and are then written with the next batch.
+//
+// Two gauges are the same if they have the same source ID, instance ID, tags
+// and metric names. Other envelope types are written immediately.
+type GaugeCoalescer struct {
+	interval        time.Duration
+	now             func() time.Time
+	next            Writer
+	coalescedMetric pulseemitter.CounterMetric
+
+	mu          sync.Mutex
+	windowStart time.Time
+	order       []uint64
+	pending     map[uint64]*loggregator_v2.Envelope
+}
+
+// GaugeCoalescerOption configures a GaugeCoalescer.
+type GaugeCoalescerOption func(*GaugeCoalescer)
+
+// WithGaugeCoalescerClock sets the function used to read the time. It is
+// intended for tests.
+func WithGaugeCoalescerClock(now func() time.Time) GaugeCoalescerOption {
+	return func(c *GaugeCoalescer) {
+		c.now = now
+	}
+}
+
+// NewGaugeCoalescer returns a GaugeCoalescer that writes the latest of each
+// gauge once per interval.
+func NewGaugeCoalescer(interval time.Duration, next Writer, m MetricClient, opts ...GaugeCoalescerOption) *GaugeCoalescer {
+	// metric-documentation-v2: (loggregator.metron.dropped) Number of gauges
+	// replaced by a later value of the same gauge
+	coalescedMetric := m.NewCounterMetric("dropped",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"direction": "gauge_coalescer"}),
+	)
+
+	c := &GaugeCoalescer{
+		interval:        interval,
+		now:             time.Now,
+		next:            next,
+		coalescedMetric: coalescedMetric,
+		pending:         make(map[uint64]*loggregator_v2.Envelope),
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// Write holds the gauges in the batch and writes the other envelopes, along
+// with the held gauges if the interval has ended, to the next Writer.
+func (c *GaugeCoalescer) Write(batch []*loggregator_v2.Envelope) error {
+	c.mu.Lock()
+	now := c.now()
+	if c.windowStart.IsZero() {
+		c.windowStart = now
+	}
+
+	var (
+		kept      []*loggregator_v2.Envelope
+		coalesced int
+	)
+	for _, e := range batch {
+		if e.GetGauge() == nil {
+			kept = append(kept, e)
+			continue
+		}
+
+		key := gaugeKey(e)
+		if _, ok := c.pending[key]; ok {
+			coalesced++
+		} else {
+			c.order = append(c.order, key)
+		}
+		c.pending[key] = e
+	}
+
+	if now.Sub(c.windowStart) >= c.interval {
+		kept = append(kept, c.flush()...)
+		c.windowStart = now
+	}
+	c.mu.Unlock()
+
+	if coalesced > 0 {
+		c.coalescedMetric.Increment(uint64(coalesced))
+	}
+
+	if len(kept) == 0 {
+		return nil
+	}
+
+	return c.next.Write(kept)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (c *GaugeCoalescer) BorrowsBatches() bool {
+	return BorrowsBatches(c.next)
+}
+
+// Flush writes the held gauges to the next Writer.
+func (c *GaugeCoalescer) Flush(timeout time.Duration) uint64 {
+	c.mu.Lock()
+	gauges := c.flush()
+	c.mu.Unlock()
+
+	return flushTo(c.next, gauges)
+}
+
+// flush returns the held gauges in the order they were first seen and
+// stops holding them.
+func (c *GaugeCoalescer) flush() []*loggregator_v2.Envelope {
+	gauges := make([]*loggregator_v2.Envelope, 0, len(c.order))
+	for _, key := range c.order {
+		gauges = append(gauges, c.pending[key])
+	}
+
+	c.order = nil
+	c.pending = make(map[uint64]*loggregator_v2.Envelope)
+
+	return gauges
+}
+
+func gaugeKey(e *loggregator_v2.Envelope) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(e.GetSourceId()))
+	h.Write([]byte{0})
+	h.Write([]byte(e.GetInstanceId()))
+	h.Write([]byte{0})
+
+	names := make([]string, 0, len(e.GetGauge().GetMetrics()))
+	for name := range e.GetGauge().GetMetrics() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte{0})
+
+	tags := make([]string, 0, len(e.GetTags()))
+	for name := range e.GetTags() {
+		tags = append(tags, name)
+	}
+	sort.Strings(tags)
+	for _, name := range tags {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(e.GetTags()[name]))
+		h.Write([]byte{0})
+	}
+
+	return h.Sum64()
+}
@@ -0,0 +1,100 @@
+package v2_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GaugeCoalescer", func() {
+	var (
+		next         *conformance.SpyWriter
+		clock        *fakeClock
+		metricClient *testhelper.SpyMetricClient
+		c            *egress.GaugeCoalescer
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		clock = &fakeClock{now: time.Unix(0, 0)}
+		metricClient = testhelper.NewMetricClient()
+		c = egress.NewGaugeCoalescer(
+			time.Second,
+			next,
+			metricClient,
+			egress.WithGaugeCoalescerClock(clock.Now),
+		)
+	})
+
+	gaugeFrom := func(sourceID, job string, value float64) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId: sourceID,
+			Tags:     map[string]string{"job": job},
+			Message: &loggregator_v2.Envelope_Gauge{
+				Gauge: &loggregator_v2.Gauge{
+					Metrics: map[string]*loggregator_v2.GaugeValue{
+						"cpu": {Value: value, Unit: "percentage"},
+					},
+				},
+			},
+		}
+	}
+
+	values := func() []float64 {
+		var v []float64
+		for _, e := range next.Delivered() {
+			v = append(v, e.GetGauge().GetMetrics()["cpu"].GetValue())
+		}
+		return v
+	}
+
+	It("writes the latest value of each gauge when the interval ends", func() {
+		Expect(c.Write([]*loggregator_v2.Envelope{
+			gaugeFrom("app", "a", 1),
+			gaugeFrom("app", "a", 2),
+			gaugeFrom("app", "b", 3),
+		})).To(Succeed())
+		clock.Advance(500 * time.Millisecond)
+		Expect(c.Write([]*loggregator_v2.Envelope{gaugeFrom("app", "a", 4)})).To(Succeed())
+
+		Expect(next.Delivered()).To(BeEmpty())
+
+		clock.Advance(500 * time.Millisecond)
+		Expect(c.Write([]*loggregator_v2.Envelope{gaugeFrom("other", "a", 5)})).To(Succeed())
+
+		Expect(values()).To(Equal([]float64{4, 3, 5}))
+		Expect(metricClient.GetMetric("dropped").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("holds gauges again after the interval ends", func() {
+		clock.Advance(time.Second)
+		Expect(c.Write([]*loggregator_v2.Envelope{gaugeFrom("app", "a", 1)})).To(Succeed())
+		Expect(c.Write([]*loggregator_v2.Envelope{gaugeFrom("app", "a", 2)})).To(Succeed())
+
+		Expect(next.Delivered()).To(BeEmpty())
+	})
+
+	It("writes other envelope types immediately", func() {
+		counter := &loggregator_v2.Envelope{
+			SourceId: "app",
+			Message:  &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{Name: "requests"}},
+		}
+
+		Expect(c.Write([]*loggregator_v2.Envelope{counter, gaugeFrom("app", "a", 1), counter})).To(Succeed())
+
+		Expect(next.Delivered()).To(Equal([]*loggregator_v2.Envelope{counter, counter}))
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			return s.Harness(egress.NewGaugeCoalescer(time.Second, s, testhelper.NewMetricClient()))
+		})
+	})
+})
@@ -0,0 +1,214 @@
+package v2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// HTTPEncoding is how batches are encoded in requests.
+type HTTPEncoding int
+
+const (
+	// HTTPJSON encodes each batch as the JSON mapping of a
+	// loggregator_v2.EnvelopeBatch.
+	HTTPJSON HTTPEncoding = iota
+
+	// HTTPProtobuf encodes each batch as a marshalled
+	// loggregator_v2.EnvelopeBatch.
+	HTTPProtobuf
+)
+
+// ParseHTTPEncoding returns the HTTPEncoding with the given name: "json" or
+// "protobuf".
+func ParseHTTPEncoding(name string) (HTTPEncoding, error) {
+	switch name {
+	case "json":
+		return HTTPJSON, nil
+	case "protobuf":
+		return HTTPProtobuf, nil
+	default:
+		return 0, fmt.Errorf("unknown http encoding %q", name)
+	}
+}
+
+// Doer performs HTTP requests. It is implemented by *http.Client.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// HTTPWriter POSTs each batch to an HTTP endpoint. Requests that fail with
+// a network error, a 429 or a 5xx status are retried with exponential
+// backoff. Any other status outside of 2xx fails the batch immediately.
+type HTTPWriter struct {
+	url           string
+	doer          Doer
+	encoding      HTTPEncoding
+	headers       http.Header
+	attempts      int
+	backoff       time.Duration
+	sleep         func(time.Duration)
+	egressMetric  pulseemitter.CounterMetric
+	retriesMetric pulseemitter.CounterMetric
+	jsonMarshaler *jsonpb.Marshaler
+}
+
+// HTTPOption configures an HTTPWriter.
+type HTTPOption func(*HTTPWriter)
+
+// WithHTTPEncoding sets how batches are encoded. The default is HTTPJSON.
+func WithHTTPEncoding(e HTTPEncoding) HTTPOption {
+	return func(w *HTTPWriter) {
+		w.encoding = e
+	}
+}
+
+// WithHTTPHeader adds a header to every request, for example an
+// Authorization header.
+func WithHTTPHeader(name, value string) HTTPOption {
+	return func(w *HTTPWriter) {
+		w.headers.Add(name, value)
+	}
+}
+
+// WithHTTPRetry sets how many times a batch is attempted and the delay
+// before the first retry. The delay doubles with each retry. The defaults
+// are 3 attempts and 1 second.
+func WithHTTPRetry(attempts int, backoff time.Duration) HTTPOption {
+	return func(w *HTTPWriter) {
+		w.attempts = attempts
+		w.backoff = backoff
+	}
+}
+
+// NewHTTPWriter returns an HTTPWriter that POSTs to the given URL. Its
+// metrics are tagged with the given destination name.
+func NewHTTPWriter(name, url string, d Doer, m MetricClient, opts ...HTTPOption) *HTTPWriter {
+	tags := map[string]string{"destination": name}
+
+	// metric-documentation-v2: (loggregator.metron.egress) Number of
+	// envelopes POSTed to an HTTP destination
+	egressMetric := m.NewCounterMetric("egress",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(tags),
+	)
+
+	// metric-documentation-v2: (loggregator.metron.retries) Number of
+	// requests to an HTTP destination that were retried
+	retriesMetric := m.NewCounterMetric("retries",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(tags),
+	)
+
+	w := &HTTPWriter{
+		url:           url,
+		doer:          d,
+		encoding:      HTTPJSON,
+		headers:       make(http.Header),
+		attempts:      3,
+		backoff:       time.Second,
+		sleep:         time.Sleep,
+		egressMetric:  egressMetric,
+		retriesMetric: retriesMetric,
+		jsonMarshaler: &jsonpb.Marshaler{},
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	return w
+}
+
+// Write POSTs the batch. An error is returned if every attempt fails.
+func (w *HTTPWriter) Write(batch []*loggregator_v2.Envelope) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	body, contentType, err := w.encode(batch)
+	if err != nil {
+		return err
+	}
+
+	return w.send(body, contentType, len(batch))
+}
+
+// BorrowsBatches returns true as batches are encoded before Write returns.
+func (w *HTTPWriter) BorrowsBatches() bool {
+	return true
+}
+
+// send POSTs the body of n envelopes, retrying as Write does.
+func (w *HTTPWriter) send(body []byte, contentType string, n int) error {
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(body, contentType)
+		if err == nil {
+			w.egressMetric.Increment(uint64(n))
+			return nil
+		}
+
+		if !retry || attempt >= w.attempts {
+			return err
+		}
+
+		w.retriesMetric.Increment(1)
+		w.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends a single request. It reports whether a failed request should
+// be retried.
+func (w *HTTPWriter) post(body []byte, contentType string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	for name, values := range w.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := w.doer.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+
+	return retry, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+}
+
+func (w *HTTPWriter) encode(batch []*loggregator_v2.Envelope) ([]byte, string, error) {
+	b := &loggregator_v2.EnvelopeBatch{Batch: batch}
+
+	if w.encoding == HTTPProtobuf {
+		data, err := proto.Marshal(b)
+		return data, "application/x-protobuf", err
+	}
+
+	var buf bytes.Buffer
+	if err := w.jsonMarshaler.Marshal(&buf, b); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), "application/json", nil
+}
@@ -0,0 +1,188 @@
+package v2_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPWriter", func() {
+	var (
+		endpoint     *spyHTTPEndpoint
+		metricClient *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		endpoint = newSpyHTTPEndpoint()
+		metricClient = testhelper.NewMetricClient()
+	})
+
+	AfterEach(func() {
+		endpoint.Close()
+	})
+
+	newWriter := func(opts ...egress.HTTPOption) *egress.HTTPWriter {
+		opts = append([]egress.HTTPOption{egress.WithHTTPRetry(3, time.Millisecond)}, opts...)
+		return egress.NewHTTPWriter("webhook", endpoint.URL, http.DefaultClient, metricClient, opts...)
+	}
+
+	It("posts batches as JSON", func() {
+		w := newWriter(egress.WithHTTPHeader("Authorization", "Bearer some-token"))
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}, {SourceId: "b"}})).To(Succeed())
+
+		Expect(endpoint.Envelopes()).To(HaveLen(2))
+		Expect(endpoint.Envelopes()[1].SourceId).To(Equal("b"))
+		Expect(endpoint.LastRequest().Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(endpoint.LastRequest().Header.Get("Authorization")).To(Equal("Bearer some-token"))
+		Expect(metricClient.GetMetric("egress").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("posts batches as protobuf", func() {
+		w := newWriter(egress.WithHTTPEncoding(egress.HTTPProtobuf))
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).To(Succeed())
+
+		Expect(endpoint.Envelopes()).To(HaveLen(1))
+		Expect(endpoint.LastRequest().Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+	})
+
+	It("retries server errors", func() {
+		endpoint.SetStatuses(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+		w := newWriter()
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).To(Succeed())
+
+		Expect(endpoint.Requests()).To(Equal(3))
+		Expect(endpoint.Envelopes()).To(HaveLen(1))
+		Expect(metricClient.GetMetric("retries").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("returns an error when every attempt fails", func() {
+		endpoint.SetStatuses(500, 500, 500)
+		w := newWriter()
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).ToNot(Succeed())
+		Expect(endpoint.Requests()).To(Equal(3))
+	})
+
+	It("does not retry client errors", func() {
+		endpoint.SetStatuses(http.StatusUnauthorized)
+		w := newWriter()
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).ToNot(Succeed())
+		Expect(endpoint.Requests()).To(Equal(1))
+	})
+
+	It("parses encodings", func() {
+		e, err := egress.ParseHTTPEncoding("protobuf")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e).To(Equal(egress.HTTPProtobuf))
+
+		_, err = egress.ParseHTTPEncoding("xml")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			e := newSpyHTTPEndpoint()
+			return conformance.Harness{
+				Writer: egress.NewHTTPWriter(
+					"webhook",
+					e.URL,
+					http.DefaultClient,
+					testhelper.NewMetricClient(),
+					egress.WithHTTPRetry(1, 0),
+				),
+				Delivered: e.Envelopes,
+				FailDownstream: func() {
+					e.SetStatuses(400, 400, 400)
+				},
+			}
+		})
+	})
+})
+
+// spyHTTPEndpoint records the envelope batches posted to it. It responds
+// with the queued statuses before responding with 200.
+type spyHTTPEndpoint struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	statuses    []int
+	requests    int
+	lastRequest *http.Request
+	envelopes   []*loggregator_v2.Envelope
+}
+
+func newSpyHTTPEndpoint() *spyHTTPEndpoint {
+	e := &spyHTTPEndpoint{}
+	e.Server = httptest.NewServer(http.HandlerFunc(e.serveHTTP))
+
+	return e
+}
+
+func (e *spyHTTPEndpoint) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests++
+	e.lastRequest = r
+
+	if len(e.statuses) > 0 {
+		status := e.statuses[0]
+		if len(e.statuses) > 1 {
+			e.statuses = e.statuses[1:]
+		}
+		if status >= 300 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	var batch loggregator_v2.EnvelopeBatch
+	if r.Header.Get("Content-Type") == "application/x-protobuf" {
+		proto.Unmarshal(body, &batch)
+	} else {
+		jsonpb.UnmarshalString(string(body), &batch)
+	}
+	e.envelopes = append(e.envelopes, batch.Batch...)
+}
+
+func (e *spyHTTPEndpoint) SetStatuses(statuses ...int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.statuses = append(statuses, http.StatusOK)
+}
+
+func (e *spyHTTPEndpoint) Requests() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.requests
+}
+
+func (e *spyHTTPEndpoint) LastRequest() *http.Request {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastRequest
+}
+
+func (e *spyHTTPEndpoint) Envelopes() []*loggregator_v2.Envelope {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*loggregator_v2.Envelope(nil), e.envelopes...)
+}
@@ -0,0 +1,241 @@
+package v2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// KafkaProducer publishes messages to Kafka. It is implemented by
+// sarama.SyncProducer.
+type KafkaProducer interface {
+	SendMessages(msgs []*sarama.ProducerMessage) error
+	Close() error
+}
+
+// KafkaEncoding is how envelopes are encoded in Kafka messages.
+type KafkaEncoding int
+
+const (
+	// KafkaProtobuf encodes each envelope as a marshalled
+	// loggregator_v2.Envelope.
+	KafkaProtobuf KafkaEncoding = iota
+
+	// KafkaJSON encodes each envelope as the JSON mapping of a
+	// loggregator_v2.Envelope.
+	KafkaJSON
+)
+
+// ParseKafkaEncoding returns the KafkaEncoding with the given name:
+// "protobuf" or "json".
+func ParseKafkaEncoding(name string) (KafkaEncoding, error) {
+	switch name {
+	case "protobuf":
+		return KafkaProtobuf, nil
+	case "json":
+		return KafkaJSON, nil
+	default:
+		return 0, fmt.Errorf("unknown kafka encoding %q", name)
+	}
+}
+
+// errKafkaNotConnected is returned by writes made before the producer of a
+// KafkaWriter has been created.
+var errKafkaNotConnected = errors.New("not connected to kafka")
+
+// KafkaWriter publishes each envelope of a batch as a message to a Kafka
+// topic.
+type KafkaWriter struct {
+	topic               string
+	encoding            KafkaEncoding
+	partitionBySourceID bool
+	connectMin          time.Duration
+	connectMax          time.Duration
+	egressMetric        pulseemitter.CounterMetric
+	jsonMarshaler       *jsonpb.Marshaler
+
+	mu       sync.Mutex
+	producer KafkaProducer
+	done     chan struct{}
+	closed   bool
+}
+
+// KafkaOption configures a KafkaWriter.
+type KafkaOption func(*KafkaWriter)
+
+// WithKafkaEncoding sets how envelopes are encoded. The default is
+// KafkaProtobuf.
+func WithKafkaEncoding(e KafkaEncoding) KafkaOption {
+	return func(w *KafkaWriter) {
+		w.encoding = e
+	}
+}
+
+// WithKafkaPartitionBySourceID sets whether messages are keyed by source ID
+// so that every envelope from a source is published to the same partition.
+// When disabled, messages have no key and are spread across partitions. The
+// default is enabled.
+func WithKafkaPartitionBySourceID(enabled bool) KafkaOption {
+	return func(w *KafkaWriter) {
+		w.partitionBySourceID = enabled
+	}
+}
+
+// WithKafkaConnectBackoff sets the bounds of the delay between attempts to
+// create the producer of a KafkaWriter returned by NewKafkaConnectWriter.
+// The defaults are 1 second and 1 minute.
+func WithKafkaConnectBackoff(min, max time.Duration) KafkaOption {
+	return func(w *KafkaWriter) {
+		w.connectMin = min
+		w.connectMax = max
+	}
+}
+
+// NewKafkaWriter returns a KafkaWriter that publishes to the given topic.
+func NewKafkaWriter(p KafkaProducer, topic string, m MetricClient, opts ...KafkaOption) *KafkaWriter {
+	w := newKafkaWriter(topic, m, opts...)
+	w.producer = p
+
+	return w
+}
+
+// NewKafkaConnectWriter returns a KafkaWriter that creates its producer
+// with connect in the background, retrying until it succeeds, so that
+// brokers that are unavailable when the agent starts do not stop it. Writes
+// fail until the producer has been created.
+func NewKafkaConnectWriter(connect func() (KafkaProducer, error), topic string, m MetricClient, opts ...KafkaOption) *KafkaWriter {
+	w := newKafkaWriter(topic, m, opts...)
+	go retryConnect("kafka", func() error {
+		p, err := connect()
+		if err != nil {
+			return err
+		}
+
+		return w.setProducer(p)
+	}, w.done, w.connectMin, w.connectMax)
+
+	return w
+}
+
+func newKafkaWriter(topic string, m MetricClient, opts ...KafkaOption) *KafkaWriter {
+	// metric-documentation-v2: (loggregator.metron.egress) Number of
+	// envelopes published to Kafka
+	egressMetric := m.NewCounterMetric("egress",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"destination": "kafka"}),
+	)
+
+	w := &KafkaWriter{
+		topic:               topic,
+		encoding:            KafkaProtobuf,
+		partitionBySourceID: true,
+		connectMin:          minConnectBackoff,
+		connectMax:          maxConnectBackoff,
+		egressMetric:        egressMetric,
+		jsonMarshaler:       &jsonpb.Marshaler{},
+		done:                make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	return w
+}
+
+// Write publishes the batch. An error is returned if any envelope fails to
+// be encoded or published.
+func (w *KafkaWriter) Write(batch []*loggregator_v2.Envelope) error {
+	msgs := make([]*sarama.ProducerMessage, 0, len(batch))
+	for _, e := range batch {
+		value, err := w.encode(e)
+		if err != nil {
+			return err
+		}
+
+		msg := &sarama.ProducerMessage{
+			Topic: w.topic,
+			Value: sarama.ByteEncoder(value),
+		}
+		if w.partitionBySourceID {
+			msg.Key = sarama.StringEncoder(e.GetSourceId())
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	w.mu.Lock()
+	p := w.producer
+	w.mu.Unlock()
+
+	if p == nil {
+		return errKafkaNotConnected
+	}
+
+	if err := p.SendMessages(msgs); err != nil {
+		return err
+	}
+
+	w.egressMetric.Increment(uint64(len(msgs)))
+
+	return nil
+}
+
+// BorrowsBatches returns true as batches are produced before Write returns.
+func (w *KafkaWriter) BorrowsBatches() bool {
+	return true
+}
+
+// Close stops any attempt to create the producer and closes the producer.
+func (w *KafkaWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+
+	if w.producer == nil {
+		return nil
+	}
+
+	return w.producer.Close()
+}
+
+// setProducer sets the producer created in the background, or closes it if
+// the writer has been closed in the meantime.
+func (w *KafkaWriter) setProducer(p KafkaProducer) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		p.Close()
+		return nil
+	}
+	w.producer = p
+
+	return nil
+}
+
+func (w *KafkaWriter) encode(e *loggregator_v2.Envelope) ([]byte, error) {
+	if w.encoding == KafkaJSON {
+		var buf bytes.Buffer
+		if err := w.jsonMarshaler.Marshal(&buf, e); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	return proto.Marshal(e)
+}
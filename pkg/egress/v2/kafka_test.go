@@ -0,0 +1,186 @@
+package v2_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KafkaWriter", func() {
+	var (
+		producer     *spyKafkaProducer
+		metricClient *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		producer = &spyKafkaProducer{}
+		metricClient = testhelper.NewMetricClient()
+	})
+
+	It("publishes each envelope as protobuf keyed by source ID", func() {
+		w := egress.NewKafkaWriter(producer, "some-topic", metricClient)
+
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{SourceId: "a", Timestamp: 1},
+			{SourceId: "b", Timestamp: 2},
+		})).To(Succeed())
+
+		msgs := producer.Messages()
+		Expect(msgs).To(HaveLen(2))
+		Expect(msgs[0].Topic).To(Equal("some-topic"))
+		Expect(msgs[0].Key).To(Equal(sarama.StringEncoder("a")))
+		Expect(msgs[1].Key).To(Equal(sarama.StringEncoder("b")))
+
+		value, err := msgs[1].Value.Encode()
+		Expect(err).ToNot(HaveOccurred())
+		var e loggregator_v2.Envelope
+		Expect(proto.Unmarshal(value, &e)).To(Succeed())
+		Expect(e.SourceId).To(Equal("b"))
+		Expect(e.Timestamp).To(Equal(int64(2)))
+
+		Expect(metricClient.GetMetric("egress").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("publishes envelopes as JSON", func() {
+		w := egress.NewKafkaWriter(producer, "some-topic", metricClient,
+			egress.WithKafkaEncoding(egress.KafkaJSON),
+		)
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a", Timestamp: 1}})).To(Succeed())
+
+		value, err := producer.Messages()[0].Value.Encode()
+		Expect(err).ToNot(HaveOccurred())
+		var e loggregator_v2.Envelope
+		Expect(jsonpb.UnmarshalString(string(value), &e)).To(Succeed())
+		Expect(e.SourceId).To(Equal("a"))
+	})
+
+	It("does not key messages when not partitioning by source ID", func() {
+		w := egress.NewKafkaWriter(producer, "some-topic", metricClient,
+			egress.WithKafkaPartitionBySourceID(false),
+		)
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).To(Succeed())
+		Expect(producer.Messages()[0].Key).To(BeNil())
+	})
+
+	It("returns an error when publishing fails", func() {
+		producer.err = errors.New("some-error")
+		w := egress.NewKafkaWriter(producer, "some-topic", metricClient)
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).To(MatchError("some-error"))
+	})
+
+	It("creates its producer in the background until it succeeds", func() {
+		var (
+			mu       sync.Mutex
+			attempts int
+		)
+		connect := func() (egress.KafkaProducer, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			attempts++
+			if attempts < 3 {
+				return nil, errors.New("brokers unavailable")
+			}
+			return producer, nil
+		}
+
+		w := egress.NewKafkaConnectWriter(connect, "some-topic", metricClient,
+			egress.WithKafkaConnectBackoff(time.Millisecond, time.Millisecond),
+		)
+		defer w.Close()
+
+		Eventually(func() error {
+			return w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})
+		}).Should(Succeed())
+		Expect(producer.Messages()).To(HaveLen(1))
+	})
+
+	It("closes its producer", func() {
+		w := egress.NewKafkaWriter(producer, "some-topic", metricClient)
+
+		Expect(w.Close()).To(Succeed())
+		Expect(producer.closed).To(BeTrue())
+	})
+
+	It("parses encodings", func() {
+		e, err := egress.ParseKafkaEncoding("json")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e).To(Equal(egress.KafkaJSON))
+
+		_, err = egress.ParseKafkaEncoding("xml")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			p := &spyKafkaProducer{}
+			return conformance.Harness{
+				Writer: egress.NewKafkaWriter(p, "some-topic", testhelper.NewMetricClient()),
+				Delivered: func() []*loggregator_v2.Envelope {
+					var envelopes []*loggregator_v2.Envelope
+					for _, m := range p.Messages() {
+						value, _ := m.Value.Encode()
+						var e loggregator_v2.Envelope
+						proto.Unmarshal(value, &e)
+						envelopes = append(envelopes, &e)
+					}
+
+					return envelopes
+				},
+				FailDownstream: func() {
+					p.mu.Lock()
+					defer p.mu.Unlock()
+					p.err = errors.New("some-error")
+				},
+			}
+		})
+	})
+})
+
+type spyKafkaProducer struct {
+	mu     sync.Mutex
+	msgs   []*sarama.ProducerMessage
+	err    error
+	closed bool
+}
+
+func (p *spyKafkaProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.msgs = append(p.msgs, msgs...)
+
+	return nil
+}
+
+func (p *spyKafkaProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	return nil
+}
+
+func (p *spyKafkaProducer) Messages() []*sarama.ProducerMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*sarama.ProducerMessage(nil), p.msgs...)
+}
@@ -0,0 +1,83 @@
+package v2
+
+import (
+	"bytes"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// LineSplitter splits log envelopes whose payloads contain newlines into an
+// envelope per line, for consumers that expect a log to be a single line.
+// Each envelope keeps the tags and timestamp of the original. Empty lines
+// are dropped.
+type LineSplitter struct {
+	next Writer
+}
+
+// NewLineSplitter returns a LineSplitter that writes to next.
+func NewLineSplitter(next Writer) *LineSplitter {
+	return &LineSplitter{
+		next: next,
+	}
+}
+
+// Write splits the multi-line logs of the batch and writes the result to
+// the next Writer.
+func (s *LineSplitter) Write(batch []*loggregator_v2.Envelope) error {
+	var split []*loggregator_v2.Envelope
+	for i, e := range batch {
+		log := e.GetLog()
+		if log == nil || bytes.IndexByte(log.Payload, '\n') < 0 {
+			if split != nil {
+				split = append(split, e)
+			}
+			continue
+		}
+
+		if split == nil {
+			split = append(make([]*loggregator_v2.Envelope, 0, len(batch)), batch[:i]...)
+		}
+		split = append(split, splitLines(e)...)
+	}
+
+	if split == nil {
+		return s.next.Write(batch)
+	}
+
+	if len(split) == 0 {
+		return nil
+	}
+
+	return s.next.Write(split)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (s *LineSplitter) BorrowsBatches() bool {
+	return BorrowsBatches(s.next)
+}
+
+func splitLines(e *loggregator_v2.Envelope) []*loggregator_v2.Envelope {
+	var lines [][]byte
+	for _, line := range bytes.Split(e.GetLog().GetPayload(), []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	envelopes := make([]*loggregator_v2.Envelope, 0, len(lines))
+	for i := range lines {
+		if i == 0 {
+			envelopes = append(envelopes, e)
+			continue
+		}
+		envelopes = append(envelopes, proto.Clone(e).(*loggregator_v2.Envelope))
+	}
+
+	for i, le := range envelopes {
+		le.GetLog().Payload = lines[i]
+	}
+
+	return envelopes
+}
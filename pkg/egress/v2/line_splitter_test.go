@@ -0,0 +1,68 @@
+package v2_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LineSplitter", func() {
+	var (
+		next *conformance.SpyWriter
+		s    *egress.LineSplitter
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		s = egress.NewLineSplitter(next)
+	})
+
+	logOf := func(payload string) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId:  "app",
+			Timestamp: 99,
+			Tags:      map[string]string{"a": "b"},
+			Message: &loggregator_v2.Envelope_Log{
+				Log: &loggregator_v2.Log{Payload: []byte(payload), Type: loggregator_v2.Log_ERR},
+			},
+		}
+	}
+
+	payloads := func() []string {
+		var p []string
+		for _, e := range next.Delivered() {
+			p = append(p, string(e.GetLog().GetPayload()))
+		}
+		return p
+	}
+
+	It("writes an envelope per line", func() {
+		counter := &loggregator_v2.Envelope{
+			Message: &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{Name: "c"}},
+		}
+		Expect(s.Write([]*loggregator_v2.Envelope{
+			logOf("first"),
+			logOf("one\r\ntwo\n\nthree\n"),
+			counter,
+		})).To(Succeed())
+
+		Expect(payloads()).To(Equal([]string{"first", "one", "two", "three", ""}))
+		for _, e := range next.Delivered()[:4] {
+			Expect(e.GetSourceId()).To(Equal("app"))
+			Expect(e.GetTimestamp()).To(Equal(int64(99)))
+			Expect(e.GetTags()).To(Equal(map[string]string{"a": "b"}))
+			Expect(e.GetLog().GetType()).To(Equal(loggregator_v2.Log_ERR))
+		}
+		Expect(next.Delivered()[4]).To(Equal(counter))
+	})
+
+	It("writes batches without multi-line logs as they are", func() {
+		batch := []*loggregator_v2.Envelope{logOf("one"), logOf("two")}
+		Expect(s.Write(batch)).To(Succeed())
+
+		Expect(next.Delivered()).To(Equal(batch))
+	})
+})
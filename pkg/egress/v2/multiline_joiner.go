@@ -0,0 +1,184 @@
+package v2
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// DefaultContinuationPattern matches the continuation lines of Java and Go
+// stack traces: indented lines, "Caused by:" and "... n more" lines, Go
+// goroutine headers and Go function calls.
+const DefaultContinuationPattern = `^(\s+|Caused by:|\.\.\. \d+ more|goroutine \d+ \[|[\w./*()-]+\(.*\)$)`
+
+// MultilineJoiner joins log envelopes that continue the previous log of the
+// same source into a single envelope, so that a stack trace is written as
+// one log. A log is held until a log from the same source that does not
+// continue it arrives, it reaches the maximum number of lines or the flush
+// timeout passes without a continuation. The joined envelope keeps the
+// tags and timestamp of its first line.
+type MultilineJoiner struct {
+	continuation *regexp.Regexp
+	timeout      time.Duration
+	maxLines     int
+	now          func() time.Time
+	next         Writer
+
+	mu      sync.Mutex
+	pending map[multilineKey]*multilineLog
+}
+
+type multilineKey struct {
+	sourceID   string
+	instanceID string
+}
+
+type multilineLog struct {
+	envelope *loggregator_v2.Envelope
+	payload  []byte
+	lines    int
+	updated  time.Time
+}
+
+// MultilineJoinerOption configures a MultilineJoiner.
+type MultilineJoinerOption func(*MultilineJoiner)
+
+// WithMultilineMaxLines sets the maximum number of lines joined into one
+// envelope. The default is 500.
+func WithMultilineMaxLines(n int) MultilineJoinerOption {
+	return func(j *MultilineJoiner) {
+		j.maxLines = n
+	}
+}
+
+// WithMultilineClock sets the function used to read the time. It is
+// intended for tests.
+func WithMultilineClock(now func() time.Time) MultilineJoinerOption {
+	return func(j *MultilineJoiner) {
+		j.now = now
+	}
+}
+
+// NewMultilineJoiner returns a MultilineJoiner that joins logs matching the
+// continuation pattern to the log before them.
+func NewMultilineJoiner(continuation *regexp.Regexp, timeout time.Duration, next Writer, opts ...MultilineJoinerOption) *MultilineJoiner {
+	j := &MultilineJoiner{
+		continuation: continuation,
+		timeout:      timeout,
+		maxLines:     500,
+		now:          time.Now,
+		next:         next,
+		pending:      make(map[multilineKey]*multilineLog),
+	}
+
+	for _, o := range opts {
+		o(j)
+	}
+
+	return j
+}
+
+// Write holds the logs of the batch that may be continued and writes the
+// other envelopes, along with any logs that are complete, to the next
+// Writer.
+func (j *MultilineJoiner) Write(batch []*loggregator_v2.Envelope) error {
+	var out []*loggregator_v2.Envelope
+
+	j.mu.Lock()
+	now := j.now()
+	for _, e := range batch {
+		log := e.GetLog()
+		if log == nil {
+			out = append(out, e)
+			continue
+		}
+
+		key := multilineKey{sourceID: e.GetSourceId(), instanceID: e.GetInstanceId()}
+		p, ok := j.pending[key]
+		if ok && j.continuation.Match(log.Payload) {
+			// The first payload may share its array with other
+			// envelopes so it is copied before it is appended to.
+			if p.payload == nil {
+				p.payload = append([]byte(nil), p.envelope.GetLog().GetPayload()...)
+			}
+			p.payload = append(append(p.payload, '\n'), log.Payload...)
+			p.envelope.GetLog().Payload = p.payload
+			p.lines++
+			p.updated = now
+
+			if p.lines >= j.maxLines {
+				out = append(out, p.envelope)
+				delete(j.pending, key)
+			}
+			continue
+		}
+
+		if ok {
+			out = append(out, p.envelope)
+		}
+		j.pending[key] = &multilineLog{envelope: e, lines: 1, updated: now}
+	}
+	out = append(out, j.expired(now)...)
+	j.mu.Unlock()
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	return j.next.Write(out)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (j *MultilineJoiner) BorrowsBatches() bool {
+	return BorrowsBatches(j.next)
+}
+
+// Start writes held logs once the flush timeout has passed without a
+// continuation. It blocks forever.
+func (j *MultilineJoiner) Start() {
+	t := time.NewTicker(j.timeout / 2)
+	defer t.Stop()
+
+	for range t.C {
+		j.mu.Lock()
+		out := j.expired(j.now())
+		j.mu.Unlock()
+
+		if len(out) == 0 {
+			continue
+		}
+
+		if err := j.next.Write(out); err != nil {
+			logger.Debugf("failed to write joined logs: %s", err)
+		}
+	}
+}
+
+// Flush writes every held log to the next Writer.
+func (j *MultilineJoiner) Flush(timeout time.Duration) uint64 {
+	j.mu.Lock()
+	out := make([]*loggregator_v2.Envelope, 0, len(j.pending))
+	for key, p := range j.pending {
+		out = append(out, p.envelope)
+		delete(j.pending, key)
+	}
+	j.mu.Unlock()
+
+	return flushTo(j.next, out)
+}
+
+// expired returns the held logs that have not been continued within the
+// flush timeout and stops holding them.
+func (j *MultilineJoiner) expired(now time.Time) []*loggregator_v2.Envelope {
+	var out []*loggregator_v2.Envelope
+	for key, p := range j.pending {
+		if now.Sub(p.updated) >= j.timeout {
+			out = append(out, p.envelope)
+			delete(j.pending, key)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,128 @@
+package v2_test
+
+import (
+	"regexp"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MultilineJoiner", func() {
+	var (
+		next  *conformance.SpyWriter
+		clock *fakeClock
+		j     *egress.MultilineJoiner
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		clock = &fakeClock{now: time.Unix(0, 0)}
+		j = egress.NewMultilineJoiner(
+			regexp.MustCompile(egress.DefaultContinuationPattern),
+			time.Second,
+			next,
+			egress.WithMultilineClock(clock.Now),
+		)
+	})
+
+	logFrom := func(sourceID, payload string) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId: sourceID,
+			Message: &loggregator_v2.Envelope_Log{
+				Log: &loggregator_v2.Log{Payload: []byte(payload)},
+			},
+		}
+	}
+
+	payloads := func() []string {
+		var p []string
+		for _, e := range next.Delivered() {
+			p = append(p, string(e.GetLog().GetPayload()))
+		}
+		return p
+	}
+
+	It("writes held logs when flushed", func() {
+		Expect(j.Write([]*loggregator_v2.Envelope{
+			logFrom("app", "panic: boom"),
+			logFrom("app", "\tat main.go:10"),
+		})).To(Succeed())
+		Expect(payloads()).To(BeEmpty())
+
+		Expect(j.Flush(time.Second)).To(BeZero())
+
+		Expect(payloads()).To(Equal([]string{"panic: boom\n\tat main.go:10"}))
+	})
+
+	It("joins continuation lines to the log before them", func() {
+		Expect(j.Write([]*loggregator_v2.Envelope{
+			logFrom("app", "java.lang.IllegalStateException: boom"),
+			logFrom("app", "\tat com.example.Main.run(Main.java:10)"),
+			logFrom("other", "hello"),
+			logFrom("app", "Caused by: java.io.IOException"),
+			logFrom("app", "\t... 3 more"),
+			logFrom("app", "next log"),
+		})).To(Succeed())
+
+		Expect(payloads()).To(Equal([]string{
+			"java.lang.IllegalStateException: boom\n\tat com.example.Main.run(Main.java:10)\nCaused by: java.io.IOException\n\t... 3 more",
+		}))
+	})
+
+	It("joins Go stack traces", func() {
+		Expect(j.Write([]*loggregator_v2.Envelope{
+			logFrom("app", "panic: runtime error"),
+			logFrom("app", "goroutine 1 [running]:"),
+			logFrom("app", "main.main()"),
+			logFrom("app", "\t/app/main.go:12 +0x20"),
+			logFrom("app", "done"),
+		})).To(Succeed())
+
+		Expect(payloads()).To(Equal([]string{
+			"panic: runtime error\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:12 +0x20",
+		}))
+	})
+
+	It("writes held logs once the flush timeout passes", func() {
+		Expect(j.Write([]*loggregator_v2.Envelope{logFrom("app", "only line")})).To(Succeed())
+		Expect(next.Delivered()).To(BeEmpty())
+
+		clock.Advance(time.Second)
+		Expect(j.Write([]*loggregator_v2.Envelope{{SourceId: "metrics"}})).To(Succeed())
+
+		Expect(next.Delivered()).To(HaveLen(2))
+		Expect(string(next.Delivered()[1].GetLog().GetPayload())).To(Equal("only line"))
+	})
+
+	It("writes held logs without further writes when started", func() {
+		j = egress.NewMultilineJoiner(regexp.MustCompile(egress.DefaultContinuationPattern), 50*time.Millisecond, next)
+		go j.Start()
+
+		Expect(j.Write([]*loggregator_v2.Envelope{logFrom("app", "only line")})).To(Succeed())
+
+		Eventually(next.Delivered).Should(HaveLen(1))
+	})
+
+	It("writes logs that reach the maximum number of lines", func() {
+		j = egress.NewMultilineJoiner(
+			regexp.MustCompile(egress.DefaultContinuationPattern),
+			time.Second,
+			next,
+			egress.WithMultilineClock(clock.Now),
+			egress.WithMultilineMaxLines(2),
+		)
+
+		Expect(j.Write([]*loggregator_v2.Envelope{
+			logFrom("app", "first"),
+			logFrom("app", "  second"),
+			logFrom("app", "  third"),
+		})).To(Succeed())
+
+		Expect(payloads()).To(Equal([]string{"first\n  second"}))
+	})
+})
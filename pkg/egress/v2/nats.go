@@ -0,0 +1,197 @@
+package v2
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// NATSPublisher publishes messages to NATS subjects. It is implemented by
+// *nats.Conn.
+type NATSPublisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// NATSWriter publishes each envelope as a marshalled
+// loggregator_v2.Envelope to a NATS subject derived from its source ID:
+// <prefix>.<source id>. Characters that are not valid in a subject token
+// are replaced with underscores so that each source ID is a single token.
+type NATSWriter struct {
+	prefix       string
+	connectMin   time.Duration
+	connectMax   time.Duration
+	egressMetric pulseemitter.CounterMetric
+
+	mu        sync.Mutex
+	publisher NATSPublisher
+	done      chan struct{}
+	closed    bool
+}
+
+// errNATSNotConnected is returned by writes made before the connection of a
+// NATSWriter has been established.
+var errNATSNotConnected = errors.New("not connected to nats")
+
+// NATSOption configures a NATSWriter.
+type NATSOption func(*NATSWriter)
+
+// WithNATSConnectBackoff sets the bounds of the delay between attempts to
+// connect for a NATSWriter returned by NewNATSConnectWriter. The defaults
+// are 1 second and 1 minute.
+func WithNATSConnectBackoff(min, max time.Duration) NATSOption {
+	return func(w *NATSWriter) {
+		w.connectMin = min
+		w.connectMax = max
+	}
+}
+
+// NewNATSWriter returns a NATSWriter that publishes to subjects with the
+// given prefix.
+func NewNATSWriter(p NATSPublisher, prefix string, m MetricClient, opts ...NATSOption) *NATSWriter {
+	w := newNATSWriter(prefix, m, opts...)
+	w.publisher = p
+
+	return w
+}
+
+// NewNATSConnectWriter returns a NATSWriter that connects with connect in
+// the background, retrying until it succeeds, so that servers that are
+// unavailable when the agent starts do not stop it. Writes fail until the
+// connection has been established.
+func NewNATSConnectWriter(connect func() (NATSPublisher, error), prefix string, m MetricClient, opts ...NATSOption) *NATSWriter {
+	w := newNATSWriter(prefix, m, opts...)
+	go retryConnect("nats", func() error {
+		p, err := connect()
+		if err != nil {
+			return err
+		}
+
+		w.setPublisher(p)
+		return nil
+	}, w.done, w.connectMin, w.connectMax)
+
+	return w
+}
+
+func newNATSWriter(prefix string, m MetricClient, opts ...NATSOption) *NATSWriter {
+	// metric-documentation-v2: (loggregator.metron.egress) Number of
+	// envelopes published to NATS
+	egressMetric := m.NewCounterMetric("egress",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"destination": "nats"}),
+	)
+
+	w := &NATSWriter{
+		prefix:       prefix,
+		connectMin:   minConnectBackoff,
+		connectMax:   maxConnectBackoff,
+		egressMetric: egressMetric,
+		done:         make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	return w
+}
+
+// Write publishes each envelope of the batch. Publishing stops at the first
+// error, which is returned.
+func (w *NATSWriter) Write(batch []*loggregator_v2.Envelope) error {
+	w.mu.Lock()
+	p := w.publisher
+	w.mu.Unlock()
+
+	if p == nil {
+		return errNATSNotConnected
+	}
+
+	var published uint64
+	defer func() {
+		if published > 0 {
+			w.egressMetric.Increment(published)
+		}
+	}()
+
+	for _, e := range batch {
+		data, err := proto.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		if err := p.Publish(w.Subject(e.GetSourceId()), data); err != nil {
+			return err
+		}
+		published++
+	}
+
+	return nil
+}
+
+// BorrowsBatches returns true as batches are published before Write returns.
+func (w *NATSWriter) BorrowsBatches() bool {
+	return true
+}
+
+// Close stops any attempt to connect and drains the connection, publishing
+// any buffered messages before it is closed, if the publisher supports it
+// as *nats.Conn does.
+func (w *NATSWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+
+	return drainNATS(w.publisher)
+}
+
+// setPublisher sets the publisher connected in the background, or drains
+// it if the writer has been closed in the meantime.
+func (w *NATSWriter) setPublisher(p NATSPublisher) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		drainNATS(p)
+		return
+	}
+	w.publisher = p
+}
+
+func drainNATS(p NATSPublisher) error {
+	if d, ok := p.(interface{ Drain() error }); ok {
+		return d.Drain()
+	}
+
+	return nil
+}
+
+// Subject returns the subject envelopes with the given source ID are
+// published to.
+func (w *NATSWriter) Subject(sourceID string) string {
+	if sourceID == "" {
+		sourceID = "_"
+	}
+
+	return w.prefix + "." + subjectTokenReplacer.Replace(sourceID)
+}
+
+var subjectTokenReplacer = strings.NewReplacer(
+	".", "_",
+	"*", "_",
+	">", "_",
+	" ", "_",
+	"\t", "_",
+	"\r", "_",
+	"\n", "_",
+)
@@ -0,0 +1,136 @@
+package v2_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+	"github.com/golang/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NATSWriter", func() {
+	var (
+		publisher    *spyNATSPublisher
+		metricClient *testhelper.SpyMetricClient
+		w            *egress.NATSWriter
+	)
+
+	BeforeEach(func() {
+		publisher = &spyNATSPublisher{}
+		metricClient = testhelper.NewMetricClient()
+		w = egress.NewNATSWriter(publisher, "loggregator.v2", metricClient)
+	})
+
+	It("publishes each envelope to a subject for its source ID", func() {
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{SourceId: "some-id", Timestamp: 1},
+			{SourceId: "other-id", Timestamp: 2},
+		})).To(Succeed())
+
+		Expect(publisher.subjects).To(Equal([]string{
+			"loggregator.v2.some-id",
+			"loggregator.v2.other-id",
+		}))
+
+		var e loggregator_v2.Envelope
+		Expect(proto.Unmarshal(publisher.data[1], &e)).To(Succeed())
+		Expect(e.SourceId).To(Equal("other-id"))
+		Expect(e.Timestamp).To(Equal(int64(2)))
+
+		Expect(metricClient.GetMetric("egress").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("replaces characters that are not valid in a subject token", func() {
+		Expect(w.Subject("app.web *>")).To(Equal("loggregator.v2.app_web___"))
+		Expect(w.Subject("")).To(Equal("loggregator.v2._"))
+	})
+
+	It("returns an error when publishing fails", func() {
+		publisher.err = errors.New("some-error")
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).To(MatchError("some-error"))
+	})
+
+	It("connects in the background until it succeeds", func() {
+		var (
+			mu       sync.Mutex
+			attempts int
+		)
+		connect := func() (egress.NATSPublisher, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			attempts++
+			if attempts < 3 {
+				return nil, errors.New("servers unavailable")
+			}
+			return publisher, nil
+		}
+
+		w := egress.NewNATSConnectWriter(connect, "loggregator.v2", metricClient,
+			egress.WithNATSConnectBackoff(time.Millisecond, time.Millisecond),
+		)
+		defer w.Close()
+
+		Eventually(func() error {
+			return w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})
+		}).Should(Succeed())
+		Expect(publisher.Envelopes()).To(HaveLen(1))
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			p := &spyNATSPublisher{}
+			return conformance.Harness{
+				Writer:    egress.NewNATSWriter(p, "loggregator.v2", testhelper.NewMetricClient()),
+				Delivered: p.Envelopes,
+				FailDownstream: func() {
+					p.mu.Lock()
+					defer p.mu.Unlock()
+					p.err = errors.New("some-error")
+				},
+			}
+		})
+	})
+})
+
+type spyNATSPublisher struct {
+	mu       sync.Mutex
+	subjects []string
+	data     [][]byte
+	err      error
+}
+
+func (p *spyNATSPublisher) Publish(subject string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.subjects = append(p.subjects, subject)
+	p.data = append(p.data, data)
+
+	return nil
+}
+
+func (p *spyNATSPublisher) Envelopes() []*loggregator_v2.Envelope {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var envelopes []*loggregator_v2.Envelope
+	for _, d := range p.data {
+		var e loggregator_v2.Envelope
+		proto.Unmarshal(d, &e)
+		envelopes = append(envelopes, &e)
+	}
+
+	return envelopes
+}
@@ -0,0 +1,183 @@
+package v2
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// ProbeTag is the tag that identifies a probe envelope. Its value is unique
+// to each probe.
+const ProbeTag = "probe_id"
+
+// ProbeSetter accepts the probe envelopes, usually the ingress buffer.
+type ProbeSetter interface {
+	Set(e *loggregator_v2.Envelope)
+}
+
+// ProbeMetricClient creates the metrics reported by a Prober.
+type ProbeMetricClient interface {
+	NewCounterMetric(name string, opts ...pulseemitter.MetricOption) pulseemitter.CounterMetric
+	NewGaugeMetric(name, unit string, opts ...pulseemitter.MetricOption) pulseemitter.GaugeMetric
+}
+
+// Prober periodically sets a uniquely tagged probe envelope and reports
+// whether it is written to egress within a timeout. Unlike connection
+// counts, this shows that envelopes make it all the way through the agent.
+// The transponder acknowledges probes once they are written, see
+// WithTransponderProber. It should be constructed with NewProber.
+type Prober struct {
+	inFlight int64
+
+	setter   ProbeSetter
+	sourceID string
+	interval time.Duration
+	timeout  time.Duration
+	prefix   string
+
+	successMetric pulseemitter.GaugeMetric
+	latencyMetric pulseemitter.GaugeMetric
+	failedMetric  pulseemitter.CounterMetric
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[string]time.Time
+}
+
+// NewProber returns a Prober that sets a probe envelope with the given
+// source ID every interval. A probe not written within timeout has failed.
+func NewProber(
+	s ProbeSetter,
+	sourceID string,
+	interval time.Duration,
+	timeout time.Duration,
+	m ProbeMetricClient,
+) *Prober {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return &Prober{
+		setter:   s,
+		sourceID: sourceID,
+		interval: interval,
+		timeout:  timeout,
+		prefix:   hex.EncodeToString(id),
+		pending:  make(map[string]time.Time),
+
+		// metric-documentation-v2: (loggregator.metron.probe_success) 1 when
+		// the most recent probe envelope was written to egress in time and 0
+		// when it was not
+		successMetric: m.NewGaugeMetric("probe_success", "bool",
+			pulseemitter.WithVersion(2, 0),
+		),
+		// metric-documentation-v2: (loggregator.metron.probe_latency) Time
+		// taken for the most recent successful probe envelope to be written
+		// to egress
+		latencyMetric: m.NewGaugeMetric("probe_latency", "ms",
+			pulseemitter.WithVersion(2, 0),
+		),
+		// metric-documentation-v2: (loggregator.metron.probe_failures) Number
+		// of probe envelopes that failed or were not written to egress in
+		// time
+		failedMetric: m.NewCounterMetric("probe_failures",
+			pulseemitter.WithVersion(2, 0),
+		),
+	}
+}
+
+// Start sets a probe every interval and fails the probes that have timed
+// out. It blocks forever.
+func (p *Prober) Start() {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+
+	for range t.C {
+		p.expire(time.Now())
+		p.Probe()
+	}
+}
+
+// Probe sets a single probe envelope.
+func (p *Prober) Probe() {
+	now := time.Now()
+
+	p.mu.Lock()
+	p.seq++
+	id := p.prefix + "-" + strconv.FormatUint(p.seq, 10)
+	p.pending[id] = now
+	atomic.StoreInt64(&p.inFlight, int64(len(p.pending)))
+	p.mu.Unlock()
+
+	p.setter.Set(&loggregator_v2.Envelope{
+		SourceId:  p.sourceID,
+		Timestamp: now.UnixNano(),
+		Tags:      map[string]string{ProbeTag: id},
+		Message: &loggregator_v2.Envelope_Event{
+			Event: &loggregator_v2.Event{
+				Title: "agent probe",
+				Body:  id,
+			},
+		},
+	})
+}
+
+// Acknowledge records the result of writing the probes in the batch. A nil
+// Prober ignores it.
+func (p *Prober) Acknowledge(batch []*loggregator_v2.Envelope, err error) {
+	if p == nil || atomic.LoadInt64(&p.inFlight) == 0 {
+		return
+	}
+
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, e := range batch {
+		id, ok := e.GetTags()[ProbeTag]
+		if !ok {
+			continue
+		}
+
+		sent, ok := p.pending[id]
+		if !ok {
+			continue
+		}
+		delete(p.pending, id)
+
+		if err != nil {
+			p.fail()
+			continue
+		}
+
+		p.successMetric.Set(1)
+		p.latencyMetric.Set(float64(now.Sub(sent)) / float64(time.Millisecond))
+	}
+	atomic.StoreInt64(&p.inFlight, int64(len(p.pending)))
+}
+
+// expire fails the probes that were set more than the timeout before now.
+func (p *Prober) expire(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, sent := range p.pending {
+		if now.Sub(sent) < p.timeout {
+			continue
+		}
+		delete(p.pending, id)
+		p.fail()
+	}
+	atomic.StoreInt64(&p.inFlight, int64(len(p.pending)))
+}
+
+func (p *Prober) fail() {
+	p.successMetric.Set(0)
+	p.failedMetric.Increment(1)
+}
@@ -0,0 +1,99 @@
+package v2_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prober", func() {
+	var (
+		setter       *spyProbeSetter
+		metricClient *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		setter = &spyProbeSetter{}
+		metricClient = testhelper.NewMetricClient()
+	})
+
+	It("sets uniquely tagged probe envelopes", func() {
+		p := egress.NewProber(setter, "metron", time.Minute, time.Minute, metricClient)
+		p.Probe()
+		p.Probe()
+
+		envelopes := setter.Envelopes()
+		Expect(envelopes).To(HaveLen(2))
+		Expect(envelopes[0].SourceId).To(Equal("metron"))
+		Expect(envelopes[0].GetEvent()).ToNot(BeNil())
+		Expect(envelopes[0].Tags[egress.ProbeTag]).ToNot(BeEmpty())
+		Expect(envelopes[0].Tags[egress.ProbeTag]).ToNot(Equal(envelopes[1].Tags[egress.ProbeTag]))
+	})
+
+	It("reports success when a probe is written", func() {
+		p := egress.NewProber(setter, "metron", time.Minute, time.Minute, metricClient)
+		p.Probe()
+
+		p.Acknowledge([]*loggregator_v2.Envelope{
+			{SourceId: "other"},
+			setter.Envelopes()[0],
+		}, nil)
+
+		Expect(metricClient.GetMetric("probe_success").GaugeValue()).To(Equal(1.0))
+		Expect(metricClient.GetMetric("probe_failures").Delta()).To(BeZero())
+	})
+
+	It("reports failure when a probe fails to be written", func() {
+		p := egress.NewProber(setter, "metron", time.Minute, time.Minute, metricClient)
+		p.Probe()
+		p.Acknowledge(setter.Envelopes(), nil)
+		p.Probe()
+
+		p.Acknowledge(setter.Envelopes()[1:], errors.New("some-error"))
+
+		Expect(metricClient.GetMetric("probe_success").GaugeValue()).To(Equal(0.0))
+		Expect(metricClient.GetMetric("probe_failures").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("reports failure when a probe is not written in time", func() {
+		p := egress.NewProber(setter, "metron", time.Millisecond, time.Millisecond, metricClient)
+		go p.Start()
+
+		Eventually(func() uint64 {
+			return metricClient.GetMetric("probe_failures").Delta()
+		}).ShouldNot(BeZero())
+	})
+
+	It("ignores acknowledgements when nil", func() {
+		var p *egress.Prober
+		Expect(func() {
+			p.Acknowledge([]*loggregator_v2.Envelope{{}}, nil)
+		}).ToNot(Panic())
+	})
+})
+
+type spyProbeSetter struct {
+	mu        sync.Mutex
+	envelopes []*loggregator_v2.Envelope
+}
+
+func (s *spyProbeSetter) Set(e *loggregator_v2.Envelope) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.envelopes = append(s.envelopes, e)
+}
+
+func (s *spyProbeSetter) Envelopes() []*loggregator_v2.Envelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*loggregator_v2.Envelope(nil), s.envelopes...)
+}
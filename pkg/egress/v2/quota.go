@@ -0,0 +1,145 @@
+package v2
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// QuotaWriter drops envelopes from source IDs that have written their quota
+// of envelopes for the current period and writes the rest to the next
+// Writer. Periods are aligned to the clock, so an hourly quota starts over
+// on the hour and a daily quota at midnight UTC.
+type QuotaWriter struct {
+	quota           uint64
+	period          time.Duration
+	notice          bool
+	now             func() time.Time
+	next            Writer
+	overQuotaMetric pulseemitter.CounterMetric
+
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]uint64
+}
+
+// QuotaOption configures a QuotaWriter.
+type QuotaOption func(*QuotaWriter)
+
+// WithQuotaNotice writes a log envelope to a source the first time it
+// exceeds its quota in a period, so that app developers learn their logs
+// are being dropped. The notice has the source's tags and is not counted
+// against its quota.
+func WithQuotaNotice() QuotaOption {
+	return func(w *QuotaWriter) {
+		w.notice = true
+	}
+}
+
+// WithQuotaClock sets the function used to read the time. It is intended
+// for tests.
+func WithQuotaClock(now func() time.Time) QuotaOption {
+	return func(w *QuotaWriter) {
+		w.now = now
+	}
+}
+
+// NewQuotaWriter returns a QuotaWriter that allows each source ID quota
+// envelopes per period.
+func NewQuotaWriter(quota uint64, period time.Duration, next Writer, m MetricClient, opts ...QuotaOption) *QuotaWriter {
+	// metric-documentation-v2: (loggregator.metron.over_quota) Number of
+	// envelopes dropped for exceeding their source's quota
+	overQuotaMetric := m.NewCounterMetric("over_quota",
+		pulseemitter.WithVersion(2, 0),
+	)
+
+	w := &QuotaWriter{
+		quota:           quota,
+		period:          period,
+		now:             time.Now,
+		next:            next,
+		overQuotaMetric: overQuotaMetric,
+		counts:          make(map[string]uint64),
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	return w
+}
+
+// Write writes the envelopes that are within their source's quota. Dropping
+// envelopes is not an error.
+func (w *QuotaWriter) Write(batch []*loggregator_v2.Envelope) error {
+	allowed := make([]*loggregator_v2.Envelope, 0, len(batch))
+
+	w.mu.Lock()
+	now := w.now()
+	if window := now.Truncate(w.period); !window.Equal(w.window) {
+		w.window = window
+		w.counts = make(map[string]uint64)
+	}
+
+	var dropped uint64
+	for _, e := range batch {
+		id := e.GetSourceId()
+		n := w.counts[id] + 1
+		w.counts[id] = n
+		if n <= w.quota {
+			allowed = append(allowed, e)
+			continue
+		}
+
+		dropped++
+		if w.notice && n == w.quota+1 {
+			allowed = append(allowed, w.noticeFor(e, now))
+		}
+	}
+	w.mu.Unlock()
+
+	if dropped > 0 {
+		w.overQuotaMetric.Increment(dropped)
+	}
+
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	return w.next.Write(allowed)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (w *QuotaWriter) BorrowsBatches() bool {
+	return BorrowsBatches(w.next)
+}
+
+func (w *QuotaWriter) noticeFor(e *loggregator_v2.Envelope, now time.Time) *loggregator_v2.Envelope {
+	tags := make(map[string]string, len(e.GetTags()))
+	for k, v := range e.GetTags() {
+		tags[k] = v
+	}
+
+	payload := fmt.Sprintf(
+		"log quota of %d envelopes per %s exceeded, envelopes will be dropped until %s",
+		w.quota,
+		w.period,
+		w.window.Add(w.period).UTC().Format(time.RFC3339),
+	)
+
+	return &loggregator_v2.Envelope{
+		SourceId:   e.GetSourceId(),
+		InstanceId: e.GetInstanceId(),
+		Timestamp:  now.UnixNano(),
+		Tags:       tags,
+		Message: &loggregator_v2.Envelope_Log{
+			Log: &loggregator_v2.Log{
+				Payload: []byte(payload),
+				Type:    loggregator_v2.Log_ERR,
+			},
+		},
+	}
+}
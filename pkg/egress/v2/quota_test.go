@@ -0,0 +1,85 @@
+package v2_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QuotaWriter", func() {
+	var (
+		next         *conformance.SpyWriter
+		clock        *fakeClock
+		metricClient *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		clock = &fakeClock{now: time.Date(2019, 1, 1, 10, 30, 0, 0, time.UTC)}
+		metricClient = testhelper.NewMetricClient()
+	})
+
+	It("drops envelopes over each source's quota", func() {
+		w := egress.NewQuotaWriter(2, time.Hour, next, metricClient, egress.WithQuotaClock(clock.Now))
+
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{SourceId: "a"},
+			{SourceId: "a"},
+			{SourceId: "b"},
+			{SourceId: "a"},
+		})).To(Succeed())
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).To(Succeed())
+
+		Expect(next.Delivered()).To(HaveLen(3))
+		Expect(metricClient.GetMetric("over_quota").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("starts each source's quota over every period", func() {
+		w := egress.NewQuotaWriter(1, time.Hour, next, metricClient, egress.WithQuotaClock(clock.Now))
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}, {SourceId: "a"}})).To(Succeed())
+		Expect(next.Delivered()).To(HaveLen(1))
+
+		clock.Advance(30 * time.Minute)
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).To(Succeed())
+
+		Expect(next.Delivered()).To(HaveLen(2))
+	})
+
+	It("writes a notice the first time a source exceeds its quota", func() {
+		w := egress.NewQuotaWriter(1, time.Hour, next, metricClient,
+			egress.WithQuotaClock(clock.Now),
+			egress.WithQuotaNotice(),
+		)
+
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{SourceId: "a"},
+			{SourceId: "a", InstanceId: "2", Tags: map[string]string{"app": "a"}},
+			{SourceId: "a"},
+		})).To(Succeed())
+
+		Expect(next.Delivered()).To(HaveLen(2))
+		notice := next.Delivered()[1]
+		Expect(notice.GetSourceId()).To(Equal("a"))
+		Expect(notice.GetInstanceId()).To(Equal("2"))
+		Expect(notice.GetTags()).To(Equal(map[string]string{"app": "a"}))
+		Expect(notice.GetTimestamp()).To(Equal(clock.Now().UnixNano()))
+		Expect(string(notice.GetLog().GetPayload())).To(Equal(
+			"log quota of 1 envelopes per 1h0m0s exceeded, envelopes will be dropped until 2019-01-01T11:00:00Z",
+		))
+		Expect(metricClient.GetMetric("over_quota").Delta()).To(Equal(uint64(2)))
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			return s.Harness(egress.NewQuotaWriter(1<<62, time.Hour, s, testhelper.NewMetricClient()))
+		})
+	})
+})
@@ -0,0 +1,58 @@
+package v2
+
+import (
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/ratelimit"
+)
+
+// RateLimitWriter drops envelopes from source IDs that exceed their rate
+// limit and writes the rest to the next Writer.
+type RateLimitWriter struct {
+	limiter       *ratelimit.Limiter
+	next          Writer
+	droppedMetric pulseemitter.CounterMetric
+}
+
+// NewRateLimitWriter returns a RateLimitWriter that limits each source ID
+// with the given limiter.
+func NewRateLimitWriter(l *ratelimit.Limiter, next Writer, m MetricClient) *RateLimitWriter {
+	// metric-documentation-v2: (loggregator.metron.dropped) Number of
+	// envelopes dropped for exceeding their source's rate limit
+	droppedMetric := m.NewCounterMetric("dropped",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"direction": "rate_limit"}),
+	)
+
+	return &RateLimitWriter{
+		limiter:       l,
+		next:          next,
+		droppedMetric: droppedMetric,
+	}
+}
+
+// Write writes the envelopes that are within their source's rate limit.
+// Dropping envelopes is not an error.
+func (w *RateLimitWriter) Write(batch []*loggregator_v2.Envelope) error {
+	allowed := make([]*loggregator_v2.Envelope, 0, len(batch))
+	for _, e := range batch {
+		if w.limiter.Allow(e.GetSourceId()) {
+			allowed = append(allowed, e)
+		}
+	}
+
+	if dropped := len(batch) - len(allowed); dropped > 0 {
+		w.droppedMetric.Increment(uint64(dropped))
+	}
+
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	return w.next.Write(allowed)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (w *RateLimitWriter) BorrowsBatches() bool {
+	return BorrowsBatches(w.next)
+}
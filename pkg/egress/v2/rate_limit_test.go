@@ -0,0 +1,48 @@
+package v2_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+	"code.cloudfoundry.org/loggregator-agent/pkg/ratelimit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimitWriter", func() {
+	It("drops envelopes over each source's limit", func() {
+		spy := conformance.NewSpyWriter()
+		metricClient := testhelper.NewMetricClient()
+		l := ratelimit.NewLimiter(ratelimit.Limit{Rate: 1, Burst: 2})
+		w := egress.NewRateLimitWriter(l, spy, metricClient)
+
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{SourceId: "a"},
+			{SourceId: "a"},
+			{SourceId: "a"},
+			{SourceId: "b"},
+		})).To(Succeed())
+
+		Expect(spy.Delivered()).To(HaveLen(3))
+		Expect(metricClient.GetMetric("dropped").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("does not write a batch when every envelope is dropped", func() {
+		spy := conformance.NewSpyWriter()
+		spy.Fail()
+		l := ratelimit.NewLimiter(ratelimit.Limit{Rate: 1, Burst: 0})
+		w := egress.NewRateLimitWriter(l, spy, testhelper.NewMetricClient())
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "a"}})).To(Succeed())
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			l := ratelimit.NewLimiter(ratelimit.Limit{})
+			return s.Harness(egress.NewRateLimitWriter(l, s, testhelper.NewMetricClient()))
+		})
+	})
+})
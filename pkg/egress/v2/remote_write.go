@@ -0,0 +1,208 @@
+package v2
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+)
+
+// RemoteWriteWriter sends counter and gauge envelopes to a Prometheus
+// remote_write endpoint, such as a Cortex, Mimir or Thanos receiver. Each
+// counter and each gauge metric becomes a series named after it and
+// labelled with the envelope's tags, source ID and instance ID. Other
+// envelopes are skipped.
+type RemoteWriteWriter struct {
+	http *HTTPWriter
+	now  func() time.Time
+}
+
+// NewRemoteWriteWriter returns a RemoteWriteWriter that POSTs to the given
+// URL. Requests are retried as an HTTPWriter's are and the options are
+// those of an HTTPWriter, except that the encoding is always snappy
+// compressed protobuf.
+func NewRemoteWriteWriter(name, url string, d Doer, m MetricClient, opts ...HTTPOption) *RemoteWriteWriter {
+	opts = append([]HTTPOption{
+		WithHTTPHeader("Content-Encoding", "snappy"),
+		WithHTTPHeader("X-Prometheus-Remote-Write-Version", "0.1.0"),
+	}, opts...)
+
+	return &RemoteWriteWriter{
+		http: NewHTTPWriter(name, url, d, m, opts...),
+		now:  time.Now,
+	}
+}
+
+// Write POSTs the counters and gauges in the batch as a single remote_write
+// request. An error is returned if every attempt fails.
+func (w *RemoteWriteWriter) Write(batch []*loggregator_v2.Envelope) error {
+	var (
+		series []remoteWriteSeries
+		n      int
+	)
+	for _, e := range batch {
+		s := w.series(e)
+		if len(s) == 0 {
+			continue
+		}
+
+		series = append(series, s...)
+		n++
+	}
+
+	if len(series) == 0 {
+		return nil
+	}
+
+	body, err := encodeWriteRequest(series)
+	if err != nil {
+		return err
+	}
+
+	return w.http.send(snappy.Encode(nil, body), "application/x-protobuf", n)
+}
+
+// BorrowsBatches returns true as batches are encoded before Write returns.
+func (w *RemoteWriteWriter) BorrowsBatches() bool {
+	return true
+}
+
+type remoteWriteSeries struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// series returns the series of a counter or gauge envelope.
+func (w *RemoteWriteWriter) series(e *loggregator_v2.Envelope) []remoteWriteSeries {
+	ts := e.GetTimestamp()
+	if ts == 0 {
+		ts = w.now().UnixNano()
+	}
+	ts /= int64(time.Millisecond)
+
+	switch m := e.GetMessage().(type) {
+	case *loggregator_v2.Envelope_Counter:
+		return []remoteWriteSeries{{
+			labels:    remoteWriteLabels(m.Counter.GetName(), e),
+			value:     float64(m.Counter.GetTotal()),
+			timestamp: ts,
+		}}
+	case *loggregator_v2.Envelope_Gauge:
+		series := make([]remoteWriteSeries, 0, len(m.Gauge.GetMetrics()))
+		for name, v := range m.Gauge.GetMetrics() {
+			series = append(series, remoteWriteSeries{
+				labels:    remoteWriteLabels(name, e),
+				value:     v.GetValue(),
+				timestamp: ts,
+			})
+		}
+
+		return series
+	default:
+		return nil
+	}
+}
+
+func remoteWriteLabels(name string, e *loggregator_v2.Envelope) map[string]string {
+	labels := make(map[string]string, len(e.GetTags())+3)
+	for k, v := range e.GetTags() {
+		labels[promName(k, false)] = v
+	}
+	if e.GetSourceId() != "" {
+		labels["source_id"] = e.GetSourceId()
+	}
+	if e.GetInstanceId() != "" {
+		labels["instance_id"] = e.GetInstanceId()
+	}
+	labels["__name__"] = promName(name, true)
+
+	return labels
+}
+
+// promName replaces the characters that are not allowed in Prometheus
+// metric or label names with underscores. Only metric names may contain
+// colons.
+func promName(s string, metric bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		case c == ':' && metric:
+		default:
+			b[i] = '_'
+		}
+	}
+
+	return string(b)
+}
+
+// encodeWriteRequest marshals the series as a Prometheus WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []remoteWriteSeries) ([]byte, error) {
+	req := proto.NewBuffer(nil)
+	for _, s := range series {
+		names := make([]string, 0, len(s.labels))
+		for name := range s.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		ts := proto.NewBuffer(nil)
+		for _, name := range names {
+			label := proto.NewBuffer(nil)
+			if err := encodeString(label, 1, name); err != nil {
+				return nil, err
+			}
+			if err := encodeString(label, 2, s.labels[name]); err != nil {
+				return nil, err
+			}
+			if err := encodeBytes(ts, 1, label.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+
+		sample := proto.NewBuffer(nil)
+		if err := sample.EncodeVarint(1<<3 | proto.WireFixed64); err != nil {
+			return nil, err
+		}
+		if err := sample.EncodeFixed64(math.Float64bits(s.value)); err != nil {
+			return nil, err
+		}
+		if err := sample.EncodeVarint(2<<3 | proto.WireVarint); err != nil {
+			return nil, err
+		}
+		if err := sample.EncodeVarint(uint64(s.timestamp)); err != nil {
+			return nil, err
+		}
+		if err := encodeBytes(ts, 2, sample.Bytes()); err != nil {
+			return nil, err
+		}
+
+		if err := encodeBytes(req, 1, ts.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	return req.Bytes(), nil
+}
+
+func encodeString(b *proto.Buffer, field uint64, s string) error {
+	return encodeBytes(b, field, []byte(s))
+}
+
+func encodeBytes(b *proto.Buffer, field uint64, data []byte) error {
+	if err := b.EncodeVarint(field<<3 | proto.WireBytes); err != nil {
+		return err
+	}
+
+	return b.EncodeRawBytes(data)
+}
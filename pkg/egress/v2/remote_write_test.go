@@ -0,0 +1,170 @@
+package v2_test
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RemoteWriteWriter", func() {
+	var (
+		requests     chan *http.Request
+		bodies       chan []byte
+		server       *httptest.Server
+		metricClient *testhelper.SpyMetricClient
+		w            *egress.RemoteWriteWriter
+	)
+
+	BeforeEach(func() {
+		requests = make(chan *http.Request, 10)
+		bodies = make(chan []byte, 10)
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			requests <- r
+			bodies <- body
+			rw.WriteHeader(http.StatusNoContent)
+		}))
+		metricClient = testhelper.NewMetricClient()
+		w = egress.NewRemoteWriteWriter("cortex", server.URL, http.DefaultClient, metricClient,
+			egress.WithHTTPRetry(1, time.Millisecond),
+			egress.WithHTTPHeader("Authorization", "Bearer some-token"),
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("posts counters and gauges as snappy compressed protobuf", func() {
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{
+				Timestamp:  int64(2 * time.Second),
+				SourceId:   "some-source",
+				InstanceId: "1",
+				Tags:       map[string]string{"deployment": "cf"},
+				Message: &loggregator_v2.Envelope_Counter{
+					Counter: &loggregator_v2.Counter{Name: "requests.total", Total: 42},
+				},
+			},
+			{
+				Timestamp: int64(3 * time.Second),
+				SourceId:  "some-source",
+				Message: &loggregator_v2.Envelope_Gauge{
+					Gauge: &loggregator_v2.Gauge{Metrics: map[string]*loggregator_v2.GaugeValue{
+						"cpu": {Unit: "percentage", Value: 0.5},
+					}},
+				},
+			},
+			{
+				SourceId: "some-source",
+				Message: &loggregator_v2.Envelope_Log{
+					Log: &loggregator_v2.Log{Payload: []byte("some-log")},
+				},
+			},
+		})).To(Succeed())
+
+		var r *http.Request
+		Expect(requests).To(Receive(&r))
+		Expect(r.Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+		Expect(r.Header.Get("Content-Encoding")).To(Equal("snappy"))
+		Expect(r.Header.Get("X-Prometheus-Remote-Write-Version")).To(Equal("0.1.0"))
+		Expect(r.Header.Get("Authorization")).To(Equal("Bearer some-token"))
+
+		var body []byte
+		Expect(bodies).To(Receive(&body))
+		series := decodeWriteRequest(body)
+		Expect(series).To(ConsistOf(
+			writtenSeries{
+				labels: []string{
+					"__name__=requests_total",
+					"deployment=cf",
+					"instance_id=1",
+					"source_id=some-source",
+				},
+				value:     42,
+				timestamp: 2000,
+			},
+			writtenSeries{
+				labels:    []string{"__name__=cpu", "source_id=some-source"},
+				value:     0.5,
+				timestamp: 3000,
+			},
+		))
+		Expect(metricClient.GetMetric("egress").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("does not post batches without counters or gauges", func() {
+		Expect(w.Write([]*loggregator_v2.Envelope{{
+			Message: &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{}},
+		}})).To(Succeed())
+
+		Expect(requests).ToNot(Receive())
+	})
+})
+
+type writtenSeries struct {
+	labels    []string
+	value     float64
+	timestamp int64
+}
+
+// decodeWriteRequest decompresses and decodes a Prometheus WriteRequest.
+func decodeWriteRequest(body []byte) []writtenSeries {
+	data, err := snappy.Decode(nil, body)
+	Expect(err).ToNot(HaveOccurred())
+
+	var series []writtenSeries
+	for _, ts := range decodeFields(data)[1] {
+		var s writtenSeries
+		fields := decodeFields(ts)
+		for _, l := range fields[1] {
+			label := decodeFields(l)
+			s.labels = append(s.labels, string(label[1][0])+"="+string(label[2][0]))
+		}
+
+		sample := fields[2][0]
+		Expect(sample[0]).To(Equal(byte(1<<3 | proto.WireFixed64)))
+		v := binary.LittleEndian.Uint64(sample[1:9])
+		Expect(sample[9]).To(Equal(byte(2<<3 | proto.WireVarint)))
+		t, _ := binary.Uvarint(sample[10:])
+
+		s.value = math.Float64frombits(v)
+		s.timestamp = int64(t)
+		series = append(series, s)
+	}
+
+	return series
+}
+
+// decodeFields returns the length delimited fields of a message by field
+// number.
+func decodeFields(data []byte) map[uint64][][]byte {
+	fields := make(map[uint64][][]byte)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		Expect(n).To(BeNumerically(">", 0))
+		Expect(key & 7).To(Equal(uint64(proto.WireBytes)))
+		data = data[n:]
+
+		length, n := binary.Uvarint(data)
+		Expect(n).To(BeNumerically(">", 0))
+		data = data[n:]
+
+		fields[key>>3] = append(fields[key>>3], data[:length])
+		data = data[length:]
+	}
+
+	return fields
+}
@@ -0,0 +1,117 @@
+package v2
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// ReplayedTag marks an envelope that is being replayed rather than
+	// emitted in real time.
+	ReplayedTag = "replayed"
+
+	// OriginalTimestampTag holds the timestamp, in nanoseconds, that a
+	// replayed envelope was originally emitted with.
+	OriginalTimestampTag = "original_timestamp"
+)
+
+// MarkReplayed tags the envelope as replayed and records its original
+// timestamp. Marking an envelope more than once keeps the first original
+// timestamp.
+func MarkReplayed(e *loggregator_v2.Envelope) {
+	if e.Tags == nil {
+		e.Tags = make(map[string]string)
+	}
+
+	if _, ok := e.Tags[OriginalTimestampTag]; !ok {
+		e.Tags[OriginalTimestampTag] = strconv.FormatInt(e.Timestamp, 10)
+	}
+	e.Tags[ReplayedTag] = "true"
+}
+
+// IsReplayed reports whether the envelope has been marked as replayed.
+func IsReplayed(e *loggregator_v2.Envelope) bool {
+	return e.GetTags()[ReplayedTag] == "true"
+}
+
+// ReplayPolicy determines the timestamp a destination receives for replayed
+// envelopes.
+type ReplayPolicy string
+
+const (
+	// PreserveTimestamps writes replayed envelopes with their original
+	// timestamp.
+	PreserveTimestamps ReplayPolicy = "preserve"
+
+	// RewriteTimestamps writes replayed envelopes with the time they are
+	// written so they do not appear late to real time consumers. The
+	// original timestamp is kept in the OriginalTimestampTag.
+	RewriteTimestamps ReplayPolicy = "rewrite"
+)
+
+// ParseReplayPolicy returns the ReplayPolicy with the given name.
+func ParseReplayPolicy(name string) (ReplayPolicy, error) {
+	switch p := ReplayPolicy(name); p {
+	case PreserveTimestamps, RewriteTimestamps:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown replay timestamp policy: %s", name)
+	}
+}
+
+// ReplayTimestampWriter applies a ReplayPolicy to replayed envelopes before
+// writing them to the next Writer. Envelopes are copied before they are
+// modified so that other destinations of the same batch are not affected.
+type ReplayTimestampWriter struct {
+	policy ReplayPolicy
+	next   Writer
+	now    func() time.Time
+}
+
+// NewReplayTimestampWriter returns a ReplayTimestampWriter that writes to
+// next.
+func NewReplayTimestampWriter(p ReplayPolicy, next Writer) *ReplayTimestampWriter {
+	return &ReplayTimestampWriter{
+		policy: p,
+		next:   next,
+		now:    time.Now,
+	}
+}
+
+// Write writes the batch to the next Writer.
+func (w *ReplayTimestampWriter) Write(batch []*loggregator_v2.Envelope) error {
+	if w.policy != RewriteTimestamps {
+		return w.next.Write(batch)
+	}
+
+	var rewritten []*loggregator_v2.Envelope
+	for i, e := range batch {
+		if !IsReplayed(e) {
+			continue
+		}
+
+		if rewritten == nil {
+			rewritten = make([]*loggregator_v2.Envelope, len(batch))
+			copy(rewritten, batch)
+		}
+
+		c := proto.Clone(e).(*loggregator_v2.Envelope)
+		c.Timestamp = w.now().UnixNano()
+		rewritten[i] = c
+	}
+
+	if rewritten == nil {
+		return w.next.Write(batch)
+	}
+
+	return w.next.Write(rewritten)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (w *ReplayTimestampWriter) BorrowsBatches() bool {
+	return BorrowsBatches(w.next)
+}
@@ -0,0 +1,95 @@
+package v2_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	Describe("MarkReplayed()", func() {
+		It("tags the envelope with the replay marker and original timestamp", func() {
+			e := &loggregator_v2.Envelope{Timestamp: 12345}
+			Expect(egress.IsReplayed(e)).To(BeFalse())
+
+			egress.MarkReplayed(e)
+
+			Expect(egress.IsReplayed(e)).To(BeTrue())
+			Expect(e.Tags).To(HaveKeyWithValue(egress.OriginalTimestampTag, "12345"))
+		})
+
+		It("keeps the first original timestamp", func() {
+			e := &loggregator_v2.Envelope{Timestamp: 12345}
+			egress.MarkReplayed(e)
+
+			e.Timestamp = 99999
+			egress.MarkReplayed(e)
+
+			Expect(e.Tags).To(HaveKeyWithValue(egress.OriginalTimestampTag, "12345"))
+		})
+	})
+
+	Describe("ParseReplayPolicy()", func() {
+		It("parses known policies", func() {
+			p, err := egress.ParseReplayPolicy("rewrite")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(Equal(egress.RewriteTimestamps))
+
+			p, err = egress.ParseReplayPolicy("preserve")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(Equal(egress.PreserveTimestamps))
+
+			_, err = egress.ParseReplayPolicy("shift")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("ReplayTimestampWriter", func() {
+		var (
+			mockWriter *mockWriter
+			replayed   *loggregator_v2.Envelope
+			live       *loggregator_v2.Envelope
+		)
+
+		BeforeEach(func() {
+			mockWriter = newMockWriter()
+			close(mockWriter.WriteOutput.Ret0)
+
+			replayed = &loggregator_v2.Envelope{Timestamp: 1}
+			egress.MarkReplayed(replayed)
+			live = &loggregator_v2.Envelope{Timestamp: 2}
+		})
+
+		It("preserves the original timestamp", func() {
+			w := egress.NewReplayTimestampWriter(egress.PreserveTimestamps, mockWriter)
+			batch := []*loggregator_v2.Envelope{replayed, live}
+
+			Expect(w.Write(batch)).To(Succeed())
+
+			Expect(mockWriter.WriteInput.Msg).To(Receive(Equal(batch)))
+		})
+
+		It("rewrites the timestamp of replayed envelopes only", func() {
+			w := egress.NewReplayTimestampWriter(egress.RewriteTimestamps, mockWriter)
+			batch := []*loggregator_v2.Envelope{replayed, live}
+			start := time.Now().UnixNano()
+
+			Expect(w.Write(batch)).To(Succeed())
+
+			var written []*loggregator_v2.Envelope
+			Expect(mockWriter.WriteInput.Msg).To(Receive(&written))
+			Expect(written).To(HaveLen(2))
+			Expect(written[0].Timestamp).To(BeNumerically(">=", start))
+			Expect(written[0].Tags).To(HaveKeyWithValue(egress.OriginalTimestampTag, "1"))
+			Expect(written[1]).To(BeIdenticalTo(live))
+
+			By("leaving the original batch unmodified")
+			Expect(batch[0]).To(BeIdenticalTo(replayed))
+			Expect(replayed.Timestamp).To(Equal(int64(1)))
+		})
+	})
+})
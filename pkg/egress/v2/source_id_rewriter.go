@@ -0,0 +1,110 @@
+package v2
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// SourceIDRule replaces source IDs that match a pattern. The replacement
+// may refer to the pattern's submatches, e.g. $1.
+type SourceIDRule struct {
+	Match   *regexp.Regexp
+	Replace string
+}
+
+// ParseSourceIDRules parses one rule per line of the form
+// "pattern => replacement". Blank lines are ignored.
+func ParseSourceIDRules(rules string) ([]SourceIDRule, error) {
+	var parsed []SourceIDRule
+	for _, line := range strings.Split(rules, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=>", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("source ID rule %q must be of the form \"pattern => replacement\"", line)
+		}
+
+		re, err := regexp.Compile(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid source ID rule pattern: %s", err)
+		}
+
+		parsed = append(parsed, SourceIDRule{
+			Match:   re,
+			Replace: strings.TrimSpace(parts[1]),
+		})
+	}
+
+	return parsed, nil
+}
+
+// SourceIDRewriter normalizes the source IDs of envelopes before writing
+// them to the next Writer. Envelopes are modified in place.
+type SourceIDRewriter struct {
+	rules        []SourceIDRule
+	emptyFromTag string
+	next         Writer
+}
+
+// SourceIDRewriterOption configures a SourceIDRewriter.
+type SourceIDRewriterOption func(*SourceIDRewriter)
+
+// WithEmptySourceIDFromTag sets the source ID of envelopes without one to
+// the value of the given tag before the rules are applied.
+func WithEmptySourceIDFromTag(tag string) SourceIDRewriterOption {
+	return func(r *SourceIDRewriter) {
+		r.emptyFromTag = tag
+	}
+}
+
+// NewSourceIDRewriter returns a SourceIDRewriter that applies the first of
+// the rules that matches each source ID.
+func NewSourceIDRewriter(rules []SourceIDRule, next Writer, opts ...SourceIDRewriterOption) *SourceIDRewriter {
+	r := &SourceIDRewriter{
+		rules: rules,
+		next:  next,
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
+}
+
+// Write rewrites the source IDs of the batch and writes it to the next
+// Writer.
+func (r *SourceIDRewriter) Write(batch []*loggregator_v2.Envelope) error {
+	for _, e := range batch {
+		r.rewrite(e)
+	}
+
+	return r.next.Write(batch)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (r *SourceIDRewriter) BorrowsBatches() bool {
+	return BorrowsBatches(r.next)
+}
+
+func (r *SourceIDRewriter) rewrite(e *loggregator_v2.Envelope) {
+	if e.SourceId == "" && r.emptyFromTag != "" {
+		e.SourceId = e.GetTags()[r.emptyFromTag]
+		if e.SourceId == "" {
+			e.SourceId = e.GetDeprecatedTags()[r.emptyFromTag].GetText()
+		}
+	}
+
+	for _, rule := range r.rules {
+		if rule.Match.MatchString(e.SourceId) {
+			e.SourceId = rule.Match.ReplaceAllString(e.SourceId, rule.Replace)
+			return
+		}
+	}
+}
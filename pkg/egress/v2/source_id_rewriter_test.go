@@ -0,0 +1,67 @@
+package v2_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceIDRewriter", func() {
+	var next *conformance.SpyWriter
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+	})
+
+	sourceIDs := func() []string {
+		var ids []string
+		for _, e := range next.Delivered() {
+			ids = append(ids, e.GetSourceId())
+		}
+		return ids
+	}
+
+	It("applies the first matching rule", func() {
+		rules, err := egress.ParseSourceIDRules(`
+			^(.*)-[0-9]+$ => $1
+			^router.*$ => gorouter
+		`)
+		Expect(err).ToNot(HaveOccurred())
+		r := egress.NewSourceIDRewriter(rules, next)
+
+		Expect(r.Write([]*loggregator_v2.Envelope{
+			{SourceId: "cell-12"},
+			{SourceId: "router-3"},
+			{SourceId: "router_z1"},
+			{SourceId: "app"},
+		})).To(Succeed())
+
+		Expect(sourceIDs()).To(Equal([]string{"cell", "router", "gorouter", "app"}))
+	})
+
+	It("sets empty source IDs from a tag", func() {
+		r := egress.NewSourceIDRewriter(nil, next, egress.WithEmptySourceIDFromTag("origin"))
+
+		Expect(r.Write([]*loggregator_v2.Envelope{
+			{Tags: map[string]string{"origin": "rep"}},
+			{DeprecatedTags: map[string]*loggregator_v2.Value{
+				"origin": {Data: &loggregator_v2.Value_Text{Text: "bbs"}},
+			}},
+			{SourceId: "app", Tags: map[string]string{"origin": "rep"}},
+			{},
+		})).To(Succeed())
+
+		Expect(sourceIDs()).To(Equal([]string{"rep", "bbs", "app", ""}))
+	})
+
+	It("returns an error for a malformed rule", func() {
+		_, err := egress.ParseSourceIDRules("no-arrow")
+		Expect(err).To(HaveOccurred())
+
+		_, err = egress.ParseSourceIDRules("[ => x")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,220 @@
+package v2
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"github.com/golang/protobuf/proto"
+)
+
+var logger = logging.New("egress")
+
+// ErrSubprocessNotRunning is returned by SubprocessWriter.Write while the
+// subprocess is not running.
+var ErrSubprocessNotRunning = errors.New("subprocess is not running")
+
+var errSubprocessClosed = errors.New("writer is closed")
+
+// SubprocessWriter streams envelope batches to the stdin of a subprocess.
+// Each batch is written as a 4 byte big endian length followed by the
+// marshalled loggregator_v2.EnvelopeBatch. The subprocess is restarted with
+// exponential backoff whenever it exits.
+type SubprocessWriter struct {
+	name       string
+	args       []string
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	killAfter  time.Duration
+
+	// writeMu orders writes to stdin. It is not held with mu so that a
+	// write blocked on a subprocess that is not reading does not stop the
+	// subprocess from being closed or restarted.
+	writeMu sync.Mutex
+
+	mu     sync.Mutex
+	stdin  io.WriteCloser
+	cmd    *exec.Cmd
+	closed bool
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// SubprocessOption configures a SubprocessWriter.
+type SubprocessOption func(*SubprocessWriter)
+
+// WithSubprocessBackoff sets the bounds of the delay before a subprocess
+// that has exited is restarted. The delay doubles after each exit up to
+// max and is reset once the subprocess runs for longer than max. The
+// defaults are 1 second and 30 seconds.
+func WithSubprocessBackoff(min, max time.Duration) SubprocessOption {
+	return func(w *SubprocessWriter) {
+		w.minBackoff = min
+		w.maxBackoff = max
+	}
+}
+
+// WithSubprocessKillTimeout sets how long Close waits for the subprocess to
+// exit before killing it. The default is 5 seconds.
+func WithSubprocessKillTimeout(d time.Duration) SubprocessOption {
+	return func(w *SubprocessWriter) {
+		w.killAfter = d
+	}
+}
+
+// NewSubprocessWriter starts the named command with the given arguments
+// and returns a SubprocessWriter that writes to it. The subprocess's stdout
+// and stderr are passed through to the agent's.
+func NewSubprocessWriter(name string, args []string, opts ...SubprocessOption) *SubprocessWriter {
+	w := &SubprocessWriter{
+		name:       name,
+		args:       args,
+		minBackoff: time.Second,
+		maxBackoff: 30 * time.Second,
+		killAfter:  5 * time.Second,
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	go w.run()
+
+	return w
+}
+
+// Write writes the batch to the subprocess. It returns
+// ErrSubprocessNotRunning if the subprocess is being restarted.
+func (w *SubprocessWriter) Write(batch []*loggregator_v2.Envelope) error {
+	data, err := proto.Marshal(&loggregator_v2.EnvelopeBatch{Batch: batch})
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.mu.Lock()
+	stdin := w.stdin
+	w.mu.Unlock()
+
+	if stdin == nil {
+		return ErrSubprocessNotRunning
+	}
+
+	_, err = stdin.Write(frame)
+	return err
+}
+
+// BorrowsBatches returns true as batches are encoded before Write returns.
+func (w *SubprocessWriter) BorrowsBatches() bool {
+	return true
+}
+
+// Close closes the subprocess's stdin, which ends any write in progress,
+// and waits for it to exit. The subprocess is killed if it does not exit
+// within the kill timeout. It is not restarted.
+func (w *SubprocessWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+
+	if w.stdin != nil {
+		w.stdin.Close()
+		w.stdin = nil
+	}
+	cmd := w.cmd
+	w.mu.Unlock()
+
+	select {
+	case <-w.stopped:
+	case <-time.After(w.killAfter):
+		if cmd != nil && cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+		<-w.stopped
+	}
+
+	return nil
+}
+
+func (w *SubprocessWriter) run() {
+	defer close(w.stopped)
+
+	backoff := w.minBackoff
+	for {
+		started := time.Now()
+		if err := w.start(); err == errSubprocessClosed {
+			return
+		} else if err != nil {
+			logger.Errorf("failed to start subprocess %s: %s", w.name, err)
+		} else {
+			err := w.cmd.Wait()
+
+			w.mu.Lock()
+			w.stdin = nil
+			w.mu.Unlock()
+
+			logger.Warnf("subprocess %s exited: %v", w.name, err)
+		}
+
+		if time.Since(started) > w.maxBackoff {
+			backoff = w.minBackoff
+		}
+
+		select {
+		case <-w.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+func (w *SubprocessWriter) start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errSubprocessClosed
+	}
+
+	cmd := exec.Command(w.name, w.args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	w.cmd = cmd
+	w.stdin = stdin
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package v2_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"github.com/golang/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SubprocessWriter", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "subprocess")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("writes length prefixed batches to the subprocess's stdin", func() {
+		out := filepath.Join(dir, "out")
+		w := egress.NewSubprocessWriter("sh", []string{"-c", fmt.Sprintf("cat > %s", out)})
+
+		Eventually(func() error {
+			return w.Write([]*loggregator_v2.Envelope{{SourceId: "some-id"}})
+		}).Should(Succeed())
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{SourceId: "other-id"},
+			{SourceId: "another-id"},
+		})).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		data, err := ioutil.ReadFile(out)
+		Expect(err).ToNot(HaveOccurred())
+
+		var batches []*loggregator_v2.EnvelopeBatch
+		for len(data) > 0 {
+			Expect(len(data)).To(BeNumerically(">=", 4))
+			n := binary.BigEndian.Uint32(data)
+			data = data[4:]
+
+			var b loggregator_v2.EnvelopeBatch
+			Expect(proto.Unmarshal(data[:n], &b)).To(Succeed())
+			batches = append(batches, &b)
+			data = data[n:]
+		}
+
+		Expect(batches).To(HaveLen(2))
+		Expect(batches[0].Batch).To(HaveLen(1))
+		Expect(batches[0].Batch[0].SourceId).To(Equal("some-id"))
+		Expect(batches[1].Batch).To(HaveLen(2))
+		Expect(batches[1].Batch[1].SourceId).To(Equal("another-id"))
+	})
+
+	It("restarts the subprocess when it exits", func() {
+		starts := filepath.Join(dir, "starts")
+		w := egress.NewSubprocessWriter(
+			"sh",
+			[]string{"-c", fmt.Sprintf("echo started >> %s", starts)},
+			egress.WithSubprocessBackoff(time.Millisecond, 10*time.Millisecond),
+		)
+		defer w.Close()
+
+		Eventually(func() int {
+			data, _ := ioutil.ReadFile(starts)
+			return strings.Count(string(data), "started")
+		}).Should(BeNumerically(">=", 3))
+	})
+
+	It("returns an error while the subprocess is not running", func() {
+		w := egress.NewSubprocessWriter("/does/not/exist", nil)
+		defer w.Close()
+
+		err := w.Write([]*loggregator_v2.Envelope{{SourceId: "some-id"}})
+		Expect(err).To(MatchError(egress.ErrSubprocessNotRunning))
+	})
+
+	It("closes a subprocess that does not read its stdin", func() {
+		w := egress.NewSubprocessWriter("sleep", []string{"60"},
+			egress.WithSubprocessKillTimeout(10*time.Millisecond),
+		)
+		payload := make([]byte, 1<<20)
+		batch := []*loggregator_v2.Envelope{{
+			Message: &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{Payload: payload}},
+		}}
+
+		// The first write large enough to fill the pipe blocks until stdin
+		// is closed.
+		written := make(chan error, 1)
+		go func() {
+			for {
+				err := w.Write(batch)
+				if err != egress.ErrSubprocessNotRunning {
+					written <- err
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		Consistently(written, 100*time.Millisecond).ShouldNot(Receive())
+
+		closed := make(chan error, 1)
+		go func() {
+			closed <- w.Close()
+		}()
+
+		Eventually(closed, 5*time.Second).Should(Receive(BeNil()))
+		Eventually(written).Should(Receive(HaveOccurred()))
+	})
+
+	It("does not restart the subprocess once closed", func() {
+		starts := filepath.Join(dir, "starts")
+		w := egress.NewSubprocessWriter(
+			"sh",
+			[]string{"-c", fmt.Sprintf("echo started >> %s; cat > /dev/null", starts)},
+			egress.WithSubprocessBackoff(time.Millisecond, 10*time.Millisecond),
+		)
+		Eventually(func() error {
+			return w.Write([]*loggregator_v2.Envelope{{SourceId: "some-id"}})
+		}).Should(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		Consistently(func() int {
+			data, _ := ioutil.ReadFile(starts)
+			return strings.Count(string(data), "started")
+		}, 100*time.Millisecond).Should(Equal(1))
+	})
+})
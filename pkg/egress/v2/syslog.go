@@ -0,0 +1,382 @@
+package v2
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// syslogSDID is the private enterprise number used for the structured data
+// elements of syslog messages.
+const syslogSDID = "47450"
+
+// SyslogWriter forwards envelopes to a syslog drain as RFC 5424 messages
+// framed with octet counting (RFC 6587) over TCP, or over TLS for
+// syslog-tls URLs. Logs are written as messages with their payload, and
+// counters and gauges as messages whose structured data holds their value.
+// Other envelope types are not forwarded. With the CEF or LEEF format the
+// same envelopes are instead written as messages holding a CEF or LEEF
+// record.
+//
+// The connection is established on the first write and re-established on
+// the write after a failure.
+type SyslogWriter struct {
+	addr         string
+	tlsConfig    *tls.Config
+	hostname     string
+	logsOnly     bool
+	format       SyslogFormat
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+	egressMetric pulseemitter.CounterMetric
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// SyslogOption configures a SyslogWriter.
+type SyslogOption func(*SyslogWriter)
+
+// WithSyslogTLSConfig sets the TLS config used to connect to syslog-tls
+// drains.
+func WithSyslogTLSConfig(c *tls.Config) SyslogOption {
+	return func(w *SyslogWriter) {
+		w.tlsConfig = c
+	}
+}
+
+// WithSyslogLogsOnly sets whether only log envelopes are forwarded. The
+// default is to forward logs, counters and gauges.
+func WithSyslogLogsOnly(logsOnly bool) SyslogOption {
+	return func(w *SyslogWriter) {
+		w.logsOnly = logsOnly
+	}
+}
+
+// WithSyslogHostname sets the hostname field of each message. The default
+// is the hostname of the machine.
+func WithSyslogHostname(hostname string) SyslogOption {
+	return func(w *SyslogWriter) {
+		w.hostname = hostname
+	}
+}
+
+// WithSyslogFormat sets how envelopes are written. The default is
+// SyslogRFC5424.
+func WithSyslogFormat(f SyslogFormat) SyslogOption {
+	return func(w *SyslogWriter) {
+		w.format = f
+	}
+}
+
+// NewSyslogWriter returns a SyslogWriter for the drain at the given URL. An
+// error is returned if the URL does not have a syslog or syslog-tls scheme
+// and a host with a port.
+func NewSyslogWriter(u *url.URL, m MetricClient, opts ...SyslogOption) (*SyslogWriter, error) {
+	if err := ValidateSyslogURL(u); err != nil {
+		return nil, err
+	}
+
+	// metric-documentation-v2: (loggregator.metron.egress) Number of
+	// envelopes forwarded to syslog drains
+	egressMetric := m.NewCounterMetric("egress",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"destination": "syslog"}),
+	)
+
+	w := &SyslogWriter{
+		addr:         u.Host,
+		dialTimeout:  5 * time.Second,
+		writeTimeout: 10 * time.Second,
+		egressMetric: egressMetric,
+	}
+	w.hostname, _ = os.Hostname()
+
+	if u.Scheme == "syslog-tls" {
+		w.tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+
+	for _, o := range opts {
+		o(w)
+	}
+
+	return w, nil
+}
+
+// ValidateSyslogURL returns an error if the URL is not a syslog or
+// syslog-tls URL with a host and port.
+func ValidateSyslogURL(u *url.URL) error {
+	if u.Scheme != "syslog" && u.Scheme != "syslog-tls" {
+		return fmt.Errorf("unsupported syslog drain scheme %q", u.Scheme)
+	}
+
+	if u.Hostname() == "" || u.Port() == "" {
+		return fmt.Errorf("syslog drain URL requires a host and port")
+	}
+
+	return nil
+}
+
+// Write forwards the batch to the drain. An error is returned if the drain
+// can not be connected to or the batch can not be written.
+func (w *SyslogWriter) Write(batch []*loggregator_v2.Envelope) error {
+	var (
+		buf bytes.Buffer
+		n   uint64
+	)
+	for _, e := range batch {
+		msgs := w.messages(e)
+		for _, msg := range msgs {
+			buf.WriteString(strconv.Itoa(len(msg)))
+			buf.WriteByte(' ')
+			buf.Write(msg)
+		}
+		if len(msgs) > 0 {
+			n++
+		}
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.connectLocked(); err != nil {
+		return err
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	if _, err := w.conn.Write(buf.Bytes()); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+
+	w.egressMetric.Increment(n)
+
+	return nil
+}
+
+// BorrowsBatches returns true as batches are written before Write returns.
+func (w *SyslogWriter) BorrowsBatches() bool {
+	return true
+}
+
+// Close closes the connection to the drain.
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+// connect connects to the drain if it is not already connected to, so that
+// the first write does not have to wait for the connection.
+func (w *SyslogWriter) connect() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.connectLocked()
+}
+
+func (w *SyslogWriter) connectLocked() error {
+	if w.conn != nil {
+		return nil
+	}
+
+	conn, err := w.dial()
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+
+	return nil
+}
+
+func (w *SyslogWriter) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: w.dialTimeout}
+	if w.tlsConfig != nil {
+		return tls.DialWithDialer(d, "tcp", w.addr, w.tlsConfig)
+	}
+
+	return d.Dial("tcp", w.addr)
+}
+
+func (w *SyslogWriter) messages(e *loggregator_v2.Envelope) [][]byte {
+	switch w.format {
+	case SyslogCEF, SyslogLEEF:
+		return formatSecurityRecords(e, w.hostname, w.logsOnly, w.format)
+	default:
+		return formatSyslog(e, w.hostname, w.logsOnly)
+	}
+}
+
+// formatSyslog returns the RFC 5424 messages for an envelope. A gauge
+// envelope results in a message for each of its metrics.
+func formatSyslog(e *loggregator_v2.Envelope, hostname string, logsOnly bool) [][]byte {
+	switch m := e.Message.(type) {
+	case *loggregator_v2.Envelope_Log:
+		severity := 6 // informational
+		if m.Log.GetType() == loggregator_v2.Log_ERR {
+			severity = 3 // error
+		}
+		payload := bytes.TrimRight(m.Log.GetPayload(), "\r\n")
+
+		return [][]byte{syslogMessage(e, hostname, severity, "", payload)}
+	case *loggregator_v2.Envelope_Counter:
+		if logsOnly {
+			return nil
+		}
+
+		sd := fmt.Sprintf(`[counter@%s name="%s" total="%d" delta="%d"]`,
+			syslogSDID,
+			escapeSDParam(m.Counter.GetName()),
+			m.Counter.GetTotal(),
+			m.Counter.GetDelta(),
+		)
+
+		return [][]byte{syslogMessage(e, hostname, 6, sd, nil)}
+	case *loggregator_v2.Envelope_Gauge:
+		if logsOnly {
+			return nil
+		}
+
+		names := make([]string, 0, len(m.Gauge.GetMetrics()))
+		for name := range m.Gauge.GetMetrics() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		msgs := make([][]byte, 0, len(names))
+		for _, name := range names {
+			v := m.Gauge.GetMetrics()[name]
+			sd := fmt.Sprintf(`[gauge@%s name="%s" value="%s" unit="%s"]`,
+				syslogSDID,
+				escapeSDParam(name),
+				strconv.FormatFloat(v.GetValue(), 'g', -1, 64),
+				escapeSDParam(v.GetUnit()),
+			)
+			msgs = append(msgs, syslogMessage(e, hostname, 6, sd, nil))
+		}
+
+		return msgs
+	default:
+		return nil
+	}
+}
+
+// syslogMessage formats a message with the user facility. The envelope's
+// tags are added to the given structured data.
+func syslogMessage(e *loggregator_v2.Envelope, hostname string, severity int, sd string, msg []byte) []byte {
+	var buf bytes.Buffer
+	writeSyslogHeader(&buf, e, hostname, severity)
+
+	sd += tagsSDElement(e.GetTags())
+	if sd == "" {
+		sd = "-"
+	}
+	buf.WriteString(sd)
+
+	if len(msg) > 0 {
+		buf.WriteByte(' ')
+		buf.Write(msg)
+	}
+
+	return buf.Bytes()
+}
+
+// writeSyslogHeader writes the RFC 5424 header of a message with the user
+// facility, up to the structured data.
+func writeSyslogHeader(buf *bytes.Buffer, e *loggregator_v2.Envelope, hostname string, severity int) {
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %s - ",
+		8+severity,
+		time.Unix(0, e.GetTimestamp()).UTC().Format("2006-01-02T15:04:05.999999Z07:00"),
+		syslogHeaderField(hostname, 255),
+		syslogHeaderField(e.GetSourceId(), 48),
+		syslogHeaderField(e.GetInstanceId(), 128),
+	)
+}
+
+// tagsSDElement returns a structured data element holding the tags. Tags
+// whose names are not valid parameter names are left out.
+func tagsSDElement(tags map[string]string) string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		if validSDName(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[tags@%s", syslogSDID)
+	for _, name := range names {
+		fmt.Fprintf(&buf, ` %s="%s"`, name, escapeSDParam(tags[name]))
+	}
+	buf.WriteByte(']')
+
+	return buf.String()
+}
+
+// syslogHeaderField returns the value as a header field of at most max
+// printable ASCII characters, or the nil value if it is empty.
+func syslogHeaderField(v string, max int) string {
+	v = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, v)
+
+	if v == "" {
+		return "-"
+	}
+	if len(v) > max {
+		v = v[:max]
+	}
+
+	return v
+}
+
+func validSDName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+
+	for _, r := range name {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return false
+		}
+	}
+
+	return true
+}
+
+var sdParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func escapeSDParam(v string) string {
+	return sdParamEscaper.Replace(v)
+}
@@ -0,0 +1,310 @@
+package v2
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// SyslogFormat is how a SyslogWriter writes envelopes.
+type SyslogFormat int
+
+const (
+	// SyslogRFC5424 writes envelopes as RFC 5424 messages whose structured
+	// data holds the tags and metric values.
+	SyslogRFC5424 SyslogFormat = iota
+
+	// SyslogCEF writes envelopes as ArcSight Common Event Format records.
+	SyslogCEF
+
+	// SyslogLEEF writes envelopes as QRadar Log Event Extended Format 1.0
+	// records.
+	SyslogLEEF
+)
+
+// ParseSyslogFormat returns the SyslogFormat with the given name: "rfc5424",
+// "cef" or "leef".
+func ParseSyslogFormat(name string) (SyslogFormat, error) {
+	switch name {
+	case "rfc5424":
+		return SyslogRFC5424, nil
+	case "cef":
+		return SyslogCEF, nil
+	case "leef":
+		return SyslogLEEF, nil
+	default:
+		return 0, fmt.Errorf("unknown syslog format %q", name)
+	}
+}
+
+// The device fields of the header of each CEF and LEEF record.
+const (
+	securityVendor  = "Cloud Foundry"
+	securityProduct = "Loggregator Agent"
+	securityVersion = "2.0"
+)
+
+// The layout of devTime in LEEF records, and the same layout as it is given
+// to QRadar in devTimeFormat.
+const (
+	leefTimeFormat     = "Jan 02 2006 15:04:05.000 MST"
+	leefTimeFormatJava = "MMM dd yyyy HH:mm:ss.SSS z"
+)
+
+// securityEvent is an envelope, or one metric of a gauge envelope, as a
+// CEF or LEEF event.
+type securityEvent struct {
+	id       string
+	name     string
+	severity int
+	fields   []securityField
+}
+
+// securityField is a field of an event with its CEF extension key and its
+// LEEF attribute key. CEF has no keys for most of the fields so they are
+// written to custom fields named by a label.
+type securityField struct {
+	cef      string
+	cefLabel string
+	leef     string
+	value    string
+}
+
+// formatSecurityRecords returns the syslog messages holding the CEF or LEEF
+// records of an envelope. A gauge envelope results in a record for each of
+// its metrics.
+func formatSecurityRecords(e *loggregator_v2.Envelope, hostname string, logsOnly bool, f SyslogFormat) [][]byte {
+	events := securityEvents(e, logsOnly)
+	msgs := make([][]byte, 0, len(events))
+	for _, ev := range events {
+		var record []byte
+		if f == SyslogLEEF {
+			record = leefRecord(e, hostname, ev)
+		} else {
+			record = cefRecord(e, hostname, ev)
+		}
+
+		severity := 6 // informational
+		if ev.severity > 5 {
+			severity = 3 // error
+		}
+
+		var buf bytes.Buffer
+		writeSyslogHeader(&buf, e, hostname, severity)
+		buf.WriteString("- ")
+		buf.Write(record)
+		msgs = append(msgs, buf.Bytes())
+	}
+
+	return msgs
+}
+
+// securityEvents maps the envelopes forwarded to syslog drains to events.
+// Logs written to stderr have a medium severity and everything else a low
+// one.
+func securityEvents(e *loggregator_v2.Envelope, logsOnly bool) []securityEvent {
+	switch m := e.Message.(type) {
+	case *loggregator_v2.Envelope_Log:
+		ev := securityEvent{id: "log", name: "Log message", severity: 3}
+		if m.Log.GetType() == loggregator_v2.Log_ERR {
+			ev.severity = 6
+		}
+		ev.fields = []securityField{{
+			cef:   "msg",
+			leef:  "msg",
+			value: string(bytes.TrimRight(m.Log.GetPayload(), "\r\n")),
+		}}
+
+		return []securityEvent{ev}
+	case *loggregator_v2.Envelope_Counter:
+		if logsOnly {
+			return nil
+		}
+
+		return []securityEvent{{
+			id:       "counter",
+			name:     m.Counter.GetName(),
+			severity: 1,
+			fields: []securityField{
+				{cef: "cn1", cefLabel: "total", leef: "total", value: strconv.FormatUint(m.Counter.GetTotal(), 10)},
+				{cef: "cn2", cefLabel: "delta", leef: "delta", value: strconv.FormatUint(m.Counter.GetDelta(), 10)},
+			},
+		}}
+	case *loggregator_v2.Envelope_Gauge:
+		if logsOnly {
+			return nil
+		}
+
+		names := make([]string, 0, len(m.Gauge.GetMetrics()))
+		for name := range m.Gauge.GetMetrics() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		events := make([]securityEvent, 0, len(names))
+		for _, name := range names {
+			v := m.Gauge.GetMetrics()[name]
+			events = append(events, securityEvent{
+				id:       "gauge",
+				name:     name,
+				severity: 1,
+				fields: []securityField{
+					{cef: "cfp1", cefLabel: "value", leef: "value", value: strconv.FormatFloat(v.GetValue(), 'g', -1, 64)},
+					{cef: "cs4", cefLabel: "unit", leef: "unit", value: v.GetUnit()},
+				},
+			})
+		}
+
+		return events
+	default:
+		return nil
+	}
+}
+
+// cefRecord returns the CEF record of an event. The envelope's source ID,
+// instance ID and tags are written to custom string fields.
+func cefRecord(e *loggregator_v2.Envelope, hostname string, ev securityEvent) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(securityVendor),
+		cefHeaderEscaper.Replace(securityProduct),
+		cefHeaderEscaper.Replace(securityVersion),
+		cefHeaderEscaper.Replace(ev.id),
+		cefHeaderEscaper.Replace(ev.name),
+		ev.severity,
+	)
+
+	fields := []securityField{
+		{cef: "rt", value: strconv.FormatInt(e.GetTimestamp()/int64(time.Millisecond), 10)},
+		{cef: "dvchost", value: hostname},
+		{cef: "cs1", cefLabel: "source_id", value: e.GetSourceId()},
+		{cef: "cs2", cefLabel: "instance_id", value: e.GetInstanceId()},
+	}
+	if tags := e.GetTags(); len(tags) > 0 {
+		names := make([]string, 0, len(tags))
+		for name := range tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		pairs := make([]string, 0, len(names))
+		for _, name := range names {
+			pairs = append(pairs, name+"="+tags[name])
+		}
+		fields = append(fields, securityField{cef: "cs3", cefLabel: "tags", value: strings.Join(pairs, ",")})
+	}
+	fields = append(fields, ev.fields...)
+
+	first := true
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		if !first {
+			buf.WriteByte(' ')
+		}
+		first = false
+
+		if f.cefLabel != "" {
+			fmt.Fprintf(&buf, "%sLabel=%s ", f.cef, cefExtensionEscaper.Replace(f.cefLabel))
+		}
+		fmt.Fprintf(&buf, "%s=%s", f.cef, cefExtensionEscaper.Replace(f.value))
+	}
+
+	return buf.Bytes()
+}
+
+// leefRecord returns the LEEF 1.0 record of an event. The envelope's tags
+// are written as attributes of their own unless their names are not valid
+// attribute keys or are taken by another attribute.
+func leefRecord(e *loggregator_v2.Envelope, hostname string, ev securityEvent) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "LEEF:1.0|%s|%s|%s|%s|",
+		leefHeaderReplacer.Replace(securityVendor),
+		leefHeaderReplacer.Replace(securityProduct),
+		leefHeaderReplacer.Replace(securityVersion),
+		leefHeaderReplacer.Replace(ev.id),
+	)
+
+	fields := []securityField{
+		{leef: "devTime", value: time.Unix(0, e.GetTimestamp()).UTC().Format(leefTimeFormat)},
+		{leef: "devTimeFormat", value: leefTimeFormatJava},
+		{leef: "sev", value: strconv.Itoa(leefSeverity(ev.severity))},
+		{leef: "cat", value: ev.id},
+		{leef: "name", value: ev.name},
+		{leef: "hostname", value: hostname},
+		{leef: "source_id", value: e.GetSourceId()},
+		{leef: "instance_id", value: e.GetInstanceId()},
+	}
+	fields = append(fields, ev.fields...)
+
+	taken := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		taken[f.leef] = true
+	}
+
+	names := make([]string, 0, len(e.GetTags()))
+	for name := range e.GetTags() {
+		if validLEEFKey(name) && !taken[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, securityField{leef: name, value: e.GetTags()[name]})
+	}
+
+	first := true
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		if !first {
+			buf.WriteByte('\t')
+		}
+		first = false
+
+		fmt.Fprintf(&buf, "%s=%s", f.leef, leefValueReplacer.Replace(f.value))
+	}
+
+	return buf.Bytes()
+}
+
+// leefSeverity maps a CEF severity, 0 to 10, to a LEEF one, 1 to 10.
+func leefSeverity(s int) int {
+	if s < 1 {
+		return 1
+	}
+
+	return s
+}
+
+func validLEEFKey(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if r <= ' ' || r > '~' || r == '=' || r == '|' {
+			return false
+		}
+	}
+
+	return true
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+	// leefHeaderReplacer and leefValueReplacer replace the header and
+	// attribute delimiters and line breaks, which LEEF 1.0 has no escapes
+	// for, with spaces.
+	leefHeaderReplacer = strings.NewReplacer("|", " ", "\r", " ", "\n", " ")
+	leefValueReplacer  = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
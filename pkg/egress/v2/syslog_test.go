@@ -0,0 +1,292 @@
+package v2_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SyslogWriter", func() {
+	var (
+		drain        *spySyslogDrain
+		metricClient *testhelper.SpyMetricClient
+		w            *egress.SyslogWriter
+	)
+
+	BeforeEach(func() {
+		drain = newSpySyslogDrain()
+		metricClient = testhelper.NewMetricClient()
+
+		var err error
+		w, err = egress.NewSyslogWriter(
+			&url.URL{Scheme: "syslog", Host: drain.Addr()},
+			metricClient,
+			egress.WithSyslogHostname("some-host"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		w.Close()
+		drain.Close()
+	})
+
+	It("writes logs as RFC 5424 messages", func() {
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{
+				SourceId:   "some-app",
+				InstanceId: "3",
+				Timestamp:  1500000000123456000,
+				Tags:       map[string]string{"job": "router", "quote": `a"b]`},
+				Message: &loggregator_v2.Envelope_Log{
+					Log: &loggregator_v2.Log{Payload: []byte("hello\n"), Type: loggregator_v2.Log_ERR},
+				},
+			},
+			{
+				SourceId: "other-app",
+				Message: &loggregator_v2.Envelope_Log{
+					Log: &loggregator_v2.Log{Payload: []byte("world")},
+				},
+			},
+		})).To(Succeed())
+
+		Eventually(drain.Messages).Should(Equal([]string{
+			`<11>1 2017-07-14T02:40:00.123456Z some-host some-app 3 - [tags@47450 job="router" quote="a\"b\]"] hello`,
+			`<14>1 1970-01-01T00:00:00Z some-host other-app - - - world`,
+		}))
+		Expect(metricClient.GetMetric("egress").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("writes counters and gauges as structured data", func() {
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{
+				SourceId: "some-app",
+				Message: &loggregator_v2.Envelope_Counter{
+					Counter: &loggregator_v2.Counter{Name: "requests", Delta: 2, Total: 10},
+				},
+			},
+			{
+				SourceId: "some-app",
+				Message: &loggregator_v2.Envelope_Gauge{
+					Gauge: &loggregator_v2.Gauge{
+						Metrics: map[string]*loggregator_v2.GaugeValue{
+							"memory": {Unit: "bytes", Value: 1024},
+							"cpu":    {Unit: "percentage", Value: 0.5},
+						},
+					},
+				},
+			},
+			{
+				SourceId: "some-app",
+				Message:  &loggregator_v2.Envelope_Timer{Timer: &loggregator_v2.Timer{}},
+			},
+		})).To(Succeed())
+
+		Eventually(drain.Messages).Should(Equal([]string{
+			`<14>1 1970-01-01T00:00:00Z some-host some-app - - [counter@47450 name="requests" total="10" delta="2"]`,
+			`<14>1 1970-01-01T00:00:00Z some-host some-app - - [gauge@47450 name="cpu" value="0.5" unit="percentage"]`,
+			`<14>1 1970-01-01T00:00:00Z some-host some-app - - [gauge@47450 name="memory" value="1024" unit="bytes"]`,
+		}))
+	})
+
+	It("only writes logs when configured to", func() {
+		var err error
+		w, err = egress.NewSyslogWriter(
+			&url.URL{Scheme: "syslog", Host: drain.Addr()},
+			metricClient,
+			egress.WithSyslogLogsOnly(true),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{Message: &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{Name: "requests"}}},
+			{Message: &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{Payload: []byte("hello")}}},
+		})).To(Succeed())
+
+		Eventually(drain.Messages).Should(HaveLen(1))
+		Consistently(drain.Messages).Should(HaveLen(1))
+	})
+
+	It("writes CEF records when configured to", func() {
+		var err error
+		w, err = egress.NewSyslogWriter(
+			&url.URL{Scheme: "syslog", Host: drain.Addr()},
+			metricClient,
+			egress.WithSyslogHostname("some-host"),
+			egress.WithSyslogFormat(egress.SyslogCEF),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{
+				SourceId:   "some-app",
+				InstanceId: "3",
+				Timestamp:  1500000000123456000,
+				Tags:       map[string]string{"job": "router"},
+				Message: &loggregator_v2.Envelope_Log{
+					Log: &loggregator_v2.Log{Payload: []byte("a=b\n"), Type: loggregator_v2.Log_ERR},
+				},
+			},
+			{
+				SourceId: "some-app",
+				Message: &loggregator_v2.Envelope_Gauge{
+					Gauge: &loggregator_v2.Gauge{
+						Metrics: map[string]*loggregator_v2.GaugeValue{
+							"cpu": {Unit: "percentage", Value: 0.5},
+						},
+					},
+				},
+			},
+		})).To(Succeed())
+
+		Eventually(drain.Messages).Should(Equal([]string{
+			`<11>1 2017-07-14T02:40:00.123456Z some-host some-app 3 - - CEF:0|Cloud Foundry|Loggregator Agent|2.0|log|Log message|6|` +
+				`rt=1500000000123 dvchost=some-host cs1Label=source_id cs1=some-app cs2Label=instance_id cs2=3 ` +
+				`cs3Label=tags cs3=job\=router msg=a\=b`,
+			`<14>1 1970-01-01T00:00:00Z some-host some-app - - - CEF:0|Cloud Foundry|Loggregator Agent|2.0|gauge|cpu|1|` +
+				`rt=0 dvchost=some-host cs1Label=source_id cs1=some-app cfp1Label=value cfp1=0.5 cs4Label=unit cs4=percentage`,
+		}))
+	})
+
+	It("writes LEEF records when configured to", func() {
+		var err error
+		w, err = egress.NewSyslogWriter(
+			&url.URL{Scheme: "syslog", Host: drain.Addr()},
+			metricClient,
+			egress.WithSyslogHostname("some-host"),
+			egress.WithSyslogFormat(egress.SyslogLEEF),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{
+			SourceId: "some-app",
+			Tags:     map[string]string{"job": "router", "source_id": "other", "bad key": "x"},
+			Message: &loggregator_v2.Envelope_Counter{
+				Counter: &loggregator_v2.Counter{Name: "requests", Delta: 2, Total: 10},
+			},
+		}})).To(Succeed())
+
+		Eventually(drain.Messages).Should(Equal([]string{
+			"<14>1 1970-01-01T00:00:00Z some-host some-app - - - LEEF:1.0|Cloud Foundry|Loggregator Agent|2.0|counter|" +
+				"devTime=Jan 01 1970 00:00:00.000 UTC\tdevTimeFormat=MMM dd yyyy HH:mm:ss.SSS z\tsev=1\tcat=counter\t" +
+				"name=requests\thostname=some-host\tsource_id=some-app\ttotal=10\tdelta=2\tjob=router",
+		}))
+	})
+
+	It("returns an error when the drain can not be reached", func() {
+		drain.Close()
+
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{Message: &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{}}},
+		})).ToNot(Succeed())
+	})
+
+	It("rejects unsupported URLs", func() {
+		for _, raw := range []string{"https://drain:443", "syslog://drain", "syslog://:514"} {
+			u, err := url.Parse(raw)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = egress.NewSyslogWriter(u, metricClient)
+			Expect(err).To(HaveOccurred(), raw)
+		}
+	})
+})
+
+// spySyslogDrain accepts connections and records octet counted syslog
+// messages.
+type spySyslogDrain struct {
+	lis net.Listener
+
+	mu       sync.Mutex
+	messages []string
+	conns    int
+}
+
+func newSpySyslogDrain() *spySyslogDrain {
+	return newSpySyslogDrainAt("127.0.0.1:0")
+}
+
+// newSpySyslogDrainAt returns a drain listening on the given address, such
+// as that of a drain that was closed.
+func newSpySyslogDrainAt(addr string) *spySyslogDrain {
+	lis, err := net.Listen("tcp", addr)
+	Expect(err).ToNot(HaveOccurred())
+
+	d := &spySyslogDrain{lis: lis}
+	go d.accept()
+
+	return d
+}
+
+func (d *spySyslogDrain) Addr() string {
+	return d.lis.Addr().String()
+}
+
+func (d *spySyslogDrain) Close() {
+	d.lis.Close()
+}
+
+func (d *spySyslogDrain) Messages() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.messages...)
+}
+
+func (d *spySyslogDrain) Connections() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.conns
+}
+
+func (d *spySyslogDrain) accept() {
+	for {
+		conn, err := d.lis.Accept()
+		if err != nil {
+			return
+		}
+
+		d.mu.Lock()
+		d.conns++
+		d.mu.Unlock()
+
+		go d.read(conn)
+	}
+}
+
+func (d *spySyslogDrain) read(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			return
+		}
+
+		n, err := strconv.Atoi(length[:len(length)-1])
+		if err != nil {
+			return
+		}
+
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return
+		}
+
+		d.mu.Lock()
+		d.messages = append(d.messages, string(msg))
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,237 @@
+package v2
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// TimerAggregator replaces timer envelopes with a periodic summary of the
+// timers from each source, for sources such as routers that emit a timer
+// for every request. Timers are summarized by source ID and timer name.
+// When the interval ends, a gauge envelope is written with the next batch
+// for each summary. Its metrics are the count and the minimum, maximum and
+// percentiles of the durations in milliseconds, named after the timer, for
+// example "http.count" and "http.p99".
+//
+// Percentiles are calculated from a uniform sample of each timer's
+// durations, so that memory use is bounded however many timers are seen.
+type TimerAggregator struct {
+	interval    time.Duration
+	maxSamples  int
+	percentiles []float64
+	now         func() time.Time
+	rand        *rand.Rand
+	next        Writer
+	aggMetric   pulseemitter.CounterMetric
+
+	mu          sync.Mutex
+	windowStart time.Time
+	order       []timerID
+	timers      map[timerID]*timerSummary
+}
+
+type timerID struct {
+	sourceID string
+	name     string
+}
+
+type timerSummary struct {
+	count   int
+	min     int64
+	max     int64
+	samples []int64
+}
+
+// TimerAggregatorOption configures a TimerAggregator.
+type TimerAggregatorOption func(*TimerAggregator)
+
+// WithTimerMaxSamples sets the number of durations sampled for each timer
+// in an interval. The default is 1000.
+func WithTimerMaxSamples(n int) TimerAggregatorOption {
+	return func(a *TimerAggregator) {
+		a.maxSamples = n
+	}
+}
+
+// WithTimerPercentiles sets the percentiles reported for each timer, each
+// between 0 and 100. The defaults are 50, 95 and 99.
+func WithTimerPercentiles(p ...float64) TimerAggregatorOption {
+	return func(a *TimerAggregator) {
+		a.percentiles = p
+	}
+}
+
+// WithTimerClock sets the function used to read the time. It is intended
+// for tests.
+func WithTimerClock(now func() time.Time) TimerAggregatorOption {
+	return func(a *TimerAggregator) {
+		a.now = now
+	}
+}
+
+// NewTimerAggregator returns a TimerAggregator that writes a summary of
+// each timer once per interval.
+func NewTimerAggregator(interval time.Duration, next Writer, m MetricClient, opts ...TimerAggregatorOption) *TimerAggregator {
+	// metric-documentation-v2: (loggregator.metron.aggregated) Number of
+	// timers replaced by a summary
+	aggMetric := m.NewCounterMetric("aggregated",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"type": "timer"}),
+	)
+
+	a := &TimerAggregator{
+		interval:    interval,
+		maxSamples:  1000,
+		percentiles: []float64{50, 95, 99},
+		now:         time.Now,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		next:        next,
+		aggMetric:   aggMetric,
+		timers:      make(map[timerID]*timerSummary),
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	return a
+}
+
+// Write summarizes the timers in the batch and writes the other envelopes,
+// along with the summaries if the interval has ended, to the next Writer.
+func (a *TimerAggregator) Write(batch []*loggregator_v2.Envelope) error {
+	a.mu.Lock()
+	now := a.now()
+	if a.windowStart.IsZero() {
+		a.windowStart = now
+	}
+
+	var (
+		kept       []*loggregator_v2.Envelope
+		aggregated int
+	)
+	for _, e := range batch {
+		t := e.GetTimer()
+		if t == nil {
+			kept = append(kept, e)
+			continue
+		}
+
+		a.add(timerID{sourceID: e.GetSourceId(), name: t.GetName()}, t.GetStop()-t.GetStart())
+		aggregated++
+	}
+
+	if now.Sub(a.windowStart) >= a.interval {
+		kept = append(kept, a.flush(now)...)
+		a.windowStart = now
+	}
+	a.mu.Unlock()
+
+	if aggregated > 0 {
+		a.aggMetric.Increment(uint64(aggregated))
+	}
+
+	if len(kept) == 0 {
+		return nil
+	}
+
+	return a.next.Write(kept)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (a *TimerAggregator) BorrowsBatches() bool {
+	return BorrowsBatches(a.next)
+}
+
+// Flush writes the summaries of the current interval to the next Writer.
+func (a *TimerAggregator) Flush(timeout time.Duration) uint64 {
+	a.mu.Lock()
+	gauges := a.flush(a.now())
+	a.mu.Unlock()
+
+	return flushTo(a.next, gauges)
+}
+
+func (a *TimerAggregator) add(id timerID, d int64) {
+	s, ok := a.timers[id]
+	if !ok {
+		s = &timerSummary{min: d, max: d}
+		a.timers[id] = s
+		a.order = append(a.order, id)
+	}
+
+	s.count++
+	if d < s.min {
+		s.min = d
+	}
+	if d > s.max {
+		s.max = d
+	}
+
+	if len(s.samples) < a.maxSamples {
+		s.samples = append(s.samples, d)
+		return
+	}
+
+	// Reservoir sampling keeps each duration with equal probability.
+	if i := a.rand.Intn(s.count); i < a.maxSamples {
+		s.samples[i] = d
+	}
+}
+
+// flush returns a gauge envelope for each timer, in the order the timers
+// were first seen, and resets the summaries.
+func (a *TimerAggregator) flush(now time.Time) []*loggregator_v2.Envelope {
+	gauges := make([]*loggregator_v2.Envelope, 0, len(a.order))
+	for _, id := range a.order {
+		s := a.timers[id]
+		sort.Slice(s.samples, func(i, j int) bool { return s.samples[i] < s.samples[j] })
+
+		metrics := map[string]*loggregator_v2.GaugeValue{
+			id.name + ".count": {Value: float64(s.count), Unit: "count"},
+			id.name + ".min":   {Value: millis(s.min), Unit: "ms"},
+			id.name + ".max":   {Value: millis(s.max), Unit: "ms"},
+		}
+		for _, p := range a.percentiles {
+			metrics[id.name+".p"+strconv.FormatFloat(p, 'f', -1, 64)] = &loggregator_v2.GaugeValue{
+				Value: millis(durationPercentile(s.samples, p)),
+				Unit:  "ms",
+			}
+		}
+
+		gauges = append(gauges, &loggregator_v2.Envelope{
+			Timestamp: now.UnixNano(),
+			SourceId:  id.sourceID,
+			Message: &loggregator_v2.Envelope_Gauge{
+				Gauge: &loggregator_v2.Gauge{Metrics: metrics},
+			},
+		})
+	}
+
+	a.order = nil
+	a.timers = make(map[timerID]*timerSummary)
+
+	return gauges
+}
+
+// durationPercentile returns the nearest rank percentile of the sorted
+// durations.
+func durationPercentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+func millis(ns int64) float64 {
+	return float64(ns) / float64(time.Millisecond)
+}
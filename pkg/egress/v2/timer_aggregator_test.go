@@ -0,0 +1,144 @@
+package v2_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TimerAggregator", func() {
+	var (
+		next         *conformance.SpyWriter
+		clock        *fakeClock
+		metricClient *testhelper.SpyMetricClient
+		a            *egress.TimerAggregator
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		clock = &fakeClock{now: time.Unix(0, 0)}
+		metricClient = testhelper.NewMetricClient()
+		a = egress.NewTimerAggregator(
+			10*time.Second,
+			next,
+			metricClient,
+			egress.WithTimerClock(clock.Now),
+		)
+	})
+
+	timerFrom := func(sourceID, name string, d time.Duration) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId: sourceID,
+			Message: &loggregator_v2.Envelope_Timer{
+				Timer: &loggregator_v2.Timer{Name: name, Start: 1000, Stop: 1000 + int64(d)},
+			},
+		}
+	}
+
+	values := func(e *loggregator_v2.Envelope) map[string]float64 {
+		v := make(map[string]float64)
+		for name, m := range e.GetGauge().GetMetrics() {
+			v[name] = m.GetValue()
+		}
+		return v
+	}
+
+	It("writes a summary of each timer when the interval ends", func() {
+		var batch []*loggregator_v2.Envelope
+		for i := 1; i <= 100; i++ {
+			batch = append(batch, timerFrom("router", "http", time.Duration(i)*time.Millisecond))
+		}
+		batch = append(batch, timerFrom("other", "http", 5*time.Millisecond))
+		Expect(a.Write(batch)).To(Succeed())
+
+		Expect(next.Delivered()).To(BeEmpty())
+
+		clock.Advance(10 * time.Second)
+		Expect(a.Write(nil)).To(Succeed())
+
+		delivered := next.Delivered()
+		Expect(delivered).To(HaveLen(2))
+
+		Expect(delivered[0].SourceId).To(Equal("router"))
+		Expect(delivered[0].Timestamp).To(Equal(time.Unix(10, 0).UnixNano()))
+		Expect(values(delivered[0])).To(Equal(map[string]float64{
+			"http.count": 100,
+			"http.min":   1,
+			"http.max":   100,
+			"http.p50":   50,
+			"http.p95":   95,
+			"http.p99":   99,
+		}))
+		Expect(delivered[0].GetGauge().GetMetrics()["http.p99"].GetUnit()).To(Equal("ms"))
+
+		Expect(delivered[1].SourceId).To(Equal("other"))
+		Expect(values(delivered[1])["http.count"]).To(Equal(1.0))
+
+		Expect(metricClient.GetMetric("aggregated").Delta()).To(Equal(uint64(101)))
+	})
+
+	It("starts a new summary after the interval ends", func() {
+		Expect(a.Write([]*loggregator_v2.Envelope{timerFrom("router", "http", time.Millisecond)})).To(Succeed())
+		clock.Advance(10 * time.Second)
+		Expect(a.Write([]*loggregator_v2.Envelope{timerFrom("router", "http", time.Millisecond)})).To(Succeed())
+
+		Expect(next.Delivered()).To(HaveLen(1))
+		Expect(values(next.Delivered()[0])["http.count"]).To(Equal(2.0))
+
+		clock.Advance(10 * time.Second)
+		Expect(a.Write([]*loggregator_v2.Envelope{timerFrom("router", "http", time.Millisecond)})).To(Succeed())
+
+		Expect(next.Delivered()).To(HaveLen(2))
+		Expect(values(next.Delivered()[1])["http.count"]).To(Equal(1.0))
+	})
+
+	It("bounds the number of durations sampled", func() {
+		a = egress.NewTimerAggregator(
+			10*time.Second,
+			next,
+			metricClient,
+			egress.WithTimerClock(clock.Now),
+			egress.WithTimerMaxSamples(10),
+			egress.WithTimerPercentiles(50),
+		)
+
+		var batch []*loggregator_v2.Envelope
+		for i := 0; i < 1000; i++ {
+			batch = append(batch, timerFrom("router", "http", 7*time.Millisecond))
+		}
+		Expect(a.Write(batch)).To(Succeed())
+		clock.Advance(10 * time.Second)
+		Expect(a.Write(nil)).To(Succeed())
+
+		Expect(values(next.Delivered()[0])).To(Equal(map[string]float64{
+			"http.count": 1000,
+			"http.min":   7,
+			"http.max":   7,
+			"http.p50":   7,
+		}))
+	})
+
+	It("writes other envelope types immediately", func() {
+		counter := &loggregator_v2.Envelope{
+			SourceId: "router",
+			Message:  &loggregator_v2.Envelope_Counter{Counter: &loggregator_v2.Counter{Name: "requests"}},
+		}
+
+		Expect(a.Write([]*loggregator_v2.Envelope{counter, timerFrom("router", "http", time.Millisecond)})).To(Succeed())
+
+		Expect(next.Delivered()).To(Equal([]*loggregator_v2.Envelope{counter}))
+	})
+
+	Describe("conformance", func() {
+		conformance.WriterSpecs(func() conformance.Harness {
+			s := conformance.NewSpyWriter()
+			return s.Harness(egress.NewTimerAggregator(time.Minute, s, testhelper.NewMetricClient()))
+		})
+	})
+})
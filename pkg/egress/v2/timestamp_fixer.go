@@ -0,0 +1,143 @@
+package v2
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// maxTimestampSources bounds the number of sources the last timestamp is
+// tracked for. Tracking starts over once it is reached.
+const maxTimestampSources = 10000
+
+// TimestampFixer sets the timestamps of envelopes without one to the time
+// they are processed. It can also correct timestamps that are too far from
+// the current time and keep each source's timestamps from decreasing, for
+// emitters with skewed clocks. Envelopes are modified in place.
+type TimestampFixer struct {
+	monotonic        bool
+	maxSkew          time.Duration
+	now              func() time.Time
+	next             Writer
+	backfilledMetric pulseemitter.CounterMetric
+	skewedMetric     pulseemitter.CounterMetric
+
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+// TimestampFixerOption configures a TimestampFixer.
+type TimestampFixerOption func(*TimestampFixer)
+
+// WithMonotonicTimestamps sets the timestamp of an envelope that is earlier
+// than the last envelope from the same source to the last envelope's
+// timestamp.
+func WithMonotonicTimestamps() TimestampFixerOption {
+	return func(f *TimestampFixer) {
+		f.monotonic = true
+	}
+}
+
+// WithMaxClockSkew sets the timestamps of envelopes that are more than d
+// before or after the current time to the current time.
+func WithMaxClockSkew(d time.Duration) TimestampFixerOption {
+	return func(f *TimestampFixer) {
+		f.maxSkew = d
+	}
+}
+
+// WithTimestampFixerClock sets the function used to read the time. It is
+// intended for tests.
+func WithTimestampFixerClock(now func() time.Time) TimestampFixerOption {
+	return func(f *TimestampFixer) {
+		f.now = now
+	}
+}
+
+// NewTimestampFixer returns a TimestampFixer that writes to next.
+func NewTimestampFixer(next Writer, m MetricClient, opts ...TimestampFixerOption) *TimestampFixer {
+	// metric-documentation-v2: (loggregator.metron.backfilled_timestamps)
+	// Number of envelopes without a timestamp given the time they were
+	// processed
+	backfilledMetric := m.NewCounterMetric("backfilled_timestamps",
+		pulseemitter.WithVersion(2, 0),
+	)
+
+	// metric-documentation-v2: (loggregator.metron.clock_skew) Number of
+	// envelopes whose timestamp was corrected for clock skew
+	skewedMetric := m.NewCounterMetric("clock_skew",
+		pulseemitter.WithVersion(2, 0),
+	)
+
+	f := &TimestampFixer{
+		now:              time.Now,
+		next:             next,
+		backfilledMetric: backfilledMetric,
+		skewedMetric:     skewedMetric,
+		last:             make(map[string]int64),
+	}
+
+	for _, o := range opts {
+		o(f)
+	}
+
+	return f
+}
+
+// Write fixes the timestamps of the batch and writes it to the next Writer.
+func (f *TimestampFixer) Write(batch []*loggregator_v2.Envelope) error {
+	now := f.now().UnixNano()
+
+	var backfilled, skewed uint64
+	f.mu.Lock()
+	for _, e := range batch {
+		if e.Timestamp == 0 {
+			e.Timestamp = now
+			backfilled++
+		} else if f.maxSkew > 0 && absDuration(time.Duration(e.Timestamp-now)) > f.maxSkew {
+			e.Timestamp = now
+			skewed++
+		}
+
+		if !f.monotonic {
+			continue
+		}
+
+		if last, ok := f.last[e.GetSourceId()]; ok && e.Timestamp < last {
+			e.Timestamp = last
+			skewed++
+			continue
+		}
+
+		if len(f.last) >= maxTimestampSources {
+			f.last = make(map[string]int64)
+		}
+		f.last[e.GetSourceId()] = e.Timestamp
+	}
+	f.mu.Unlock()
+
+	if backfilled > 0 {
+		f.backfilledMetric.Increment(backfilled)
+	}
+
+	if skewed > 0 {
+		f.skewedMetric.Increment(skewed)
+	}
+
+	return f.next.Write(batch)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (f *TimestampFixer) BorrowsBatches() bool {
+	return BorrowsBatches(f.next)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
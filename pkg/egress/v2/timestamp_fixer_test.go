@@ -0,0 +1,83 @@
+package v2_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TimestampFixer", func() {
+	var (
+		next         *conformance.SpyWriter
+		clock        *fakeClock
+		metricClient *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		clock = &fakeClock{now: time.Unix(1000, 0)}
+		metricClient = testhelper.NewMetricClient()
+	})
+
+	timestamps := func() []int64 {
+		var ts []int64
+		for _, e := range next.Delivered() {
+			ts = append(ts, e.GetTimestamp())
+		}
+		return ts
+	}
+
+	It("sets missing timestamps to the current time", func() {
+		f := egress.NewTimestampFixer(next, metricClient, egress.WithTimestampFixerClock(clock.Now))
+
+		Expect(f.Write([]*loggregator_v2.Envelope{{}, {Timestamp: 5}})).To(Succeed())
+
+		Expect(timestamps()).To(Equal([]int64{clock.Now().UnixNano(), 5}))
+		Expect(metricClient.GetMetric("backfilled_timestamps").Delta()).To(Equal(uint64(1)))
+		Expect(metricClient.GetMetric("clock_skew").Delta()).To(BeZero())
+	})
+
+	It("keeps timestamps from decreasing per source", func() {
+		f := egress.NewTimestampFixer(next, metricClient,
+			egress.WithTimestampFixerClock(clock.Now),
+			egress.WithMonotonicTimestamps(),
+		)
+
+		Expect(f.Write([]*loggregator_v2.Envelope{
+			{SourceId: "a", Timestamp: 10},
+			{SourceId: "b", Timestamp: 3},
+			{SourceId: "a", Timestamp: 7},
+			{SourceId: "a", Timestamp: 12},
+		})).To(Succeed())
+
+		Expect(timestamps()).To(Equal([]int64{10, 3, 10, 12}))
+		Expect(metricClient.GetMetric("clock_skew").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("corrects timestamps beyond the max clock skew", func() {
+		f := egress.NewTimestampFixer(next, metricClient,
+			egress.WithTimestampFixerClock(clock.Now),
+			egress.WithMaxClockSkew(time.Minute),
+		)
+
+		now := clock.Now()
+		Expect(f.Write([]*loggregator_v2.Envelope{
+			{Timestamp: now.Add(time.Hour).UnixNano()},
+			{Timestamp: now.Add(-time.Hour).UnixNano()},
+			{Timestamp: now.Add(-time.Second).UnixNano()},
+		})).To(Succeed())
+
+		Expect(timestamps()).To(Equal([]int64{
+			now.UnixNano(),
+			now.UnixNano(),
+			now.Add(-time.Second).UnixNano(),
+		}))
+		Expect(metricClient.GetMetric("clock_skew").Delta()).To(Equal(uint64(2)))
+	})
+})
@@ -2,11 +2,16 @@ package v2
 
 import (
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"code.cloudfoundry.org/go-loggregator/pulseemitter"
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
 	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing/batching"
+	"code.cloudfoundry.org/loggregator-agent/pkg/tracing"
 )
 
 type Nexter interface {
@@ -17,20 +22,118 @@ type Writer interface {
 	Write(msgs []*loggregator_v2.Envelope) error
 }
 
+// Flusher is implemented by Writers that hold envelopes between writes.
+// Flush writes everything the Writer holds, taking no longer than the
+// timeout where it can, and returns the number of envelopes that were lost.
+type Flusher interface {
+	Flush(timeout time.Duration) uint64
+}
+
+// BatchBorrower is implemented by Writers that can report whether they
+// borrow batches: that they do not use a batch, the slice rather than the
+// envelopes in it, once Write returns. A Writer that passes batches on
+// borrows them only if the Writers it passes them to do.
+type BatchBorrower interface {
+	BorrowsBatches() bool
+}
+
+// BorrowsBatches reports whether w is known to borrow batches, in which
+// case the slices written to it can be reused. Writers that do not
+// implement BatchBorrower are assumed not to.
+func BorrowsBatches(w Writer) bool {
+	b, ok := w.(BatchBorrower)
+	return ok && b.BorrowsBatches()
+}
+
+// flushTo writes the envelopes a Flusher held to the next Writer and
+// returns the number lost.
+func flushTo(next Writer, batch []*loggregator_v2.Envelope) uint64 {
+	if len(batch) == 0 {
+		return 0
+	}
+
+	if err := next.Write(batch); err != nil {
+		logger.Debugf("failed to flush envelopes: %s", err)
+		return uint64(len(batch))
+	}
+
+	return 0
+}
+
 // MetricClient creates new CounterMetrics to be emitted periodically.
 type MetricClient interface {
 	NewCounterMetric(name string, opts ...pulseemitter.MetricOption) pulseemitter.CounterMetric
 }
 
 type Transponder struct {
+	dropped      uint64
+	writeLatency int64
+
 	nexter        Nexter
 	writer        Writer
 	tags          map[string]string
 	batcher       *batching.V2EnvelopeBatcher
 	batchSize     int
 	batchInterval time.Duration
+	pooled        bool
+	tracer        *tracing.Tracer
+	prober        *Prober
 	droppedMetric pulseemitter.CounterMetric
 	egressMetric  pulseemitter.CounterMetric
+	typeCounter   *plumbing.TypeCounter
+
+	overrideTags    bool
+	reportConflicts bool
+	conflictMetric  pulseemitter.CounterMetric
+	loggedConflicts sync.Map
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// TransponderOption configures a Transponder.
+type TransponderOption func(*Transponder)
+
+// WithTransponderPooledBatches reuses the batch slices written to the
+// Writer, which must borrow batches as BorrowsBatches reports.
+func WithTransponderPooledBatches() TransponderOption {
+	return func(t *Transponder) {
+		t.pooled = true
+	}
+}
+
+// WithTransponderTracer finishes the traces of sampled envelopes once they
+// have been written.
+func WithTransponderTracer(tr *tracing.Tracer) TransponderOption {
+	return func(t *Transponder) {
+		t.tracer = tr
+	}
+}
+
+// WithTransponderProber acknowledges the probe envelopes of the Prober once
+// they have been written.
+func WithTransponderProber(p *Prober) TransponderOption {
+	return func(t *Transponder) {
+		t.prober = p
+	}
+}
+
+// WithTransponderTagOverride makes the given tags replace tags of the same
+// name on envelopes. By default tags on envelopes are kept.
+func WithTransponderTagOverride() TransponderOption {
+	return func(t *Transponder) {
+		t.overrideTags = true
+	}
+}
+
+// WithTransponderTagConflicts counts envelopes with a tag that has a
+// different value to one of the given tags and logs the first conflict for
+// each tag.
+func WithTransponderTagConflicts() TransponderOption {
+	return func(t *Transponder) {
+		t.reportConflicts = true
+	}
 }
 
 func NewTransponder(
@@ -40,6 +143,7 @@ func NewTransponder(
 	batchSize int,
 	batchInterval time.Duration,
 	metricClient MetricClient,
+	opts ...TransponderOption,
 ) *Transponder {
 	droppedMetric := metricClient.NewCounterMetric("dropped",
 		pulseemitter.WithVersion(2, 0),
@@ -50,32 +154,76 @@ func NewTransponder(
 		pulseemitter.WithVersion(2, 0),
 	)
 
-	return &Transponder{
+	// metric-documentation-v2: (loggregator.metron.egress_by_type) Number
+	// of messages of each envelope type written to Doppler's v2 API, tagged
+	// with the type
+	typeCounter := plumbing.NewTypeCounter(metricClient, "egress_by_type")
+
+	t := &Transponder{
 		nexter:        n,
 		writer:        w,
 		tags:          tags,
 		droppedMetric: droppedMetric,
 		egressMetric:  egressMetric,
+		typeCounter:   typeCounter,
 		batchSize:     batchSize,
 		batchInterval: batchInterval,
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(t)
 	}
+
+	if t.reportConflicts {
+		// metric-documentation-v2: (loggregator.metron.tag_conflicts) Number
+		// of envelopes with a tag that conflicts with an agent tag
+		t.conflictMetric = metricClient.NewCounterMetric("tag_conflicts",
+			pulseemitter.WithVersion(2, 0),
+		)
+	}
+
+	return t
 }
 
+// Start reads envelopes from the Nexter and writes them in batches. It
+// blocks until Stop is called.
 func (t *Transponder) Start() {
+	var opts []batching.V2EnvelopeBatcherOption
+	if t.pooled {
+		opts = append(opts, batching.WithPooledBatches())
+	}
+
 	b := batching.NewV2EnvelopeBatcher(
 		t.batchSize,
 		t.batchInterval,
 		batching.V2EnvelopeWriterFunc(t.write),
+		opts...,
 	)
 
+	defer close(t.stopped)
+
 	for {
+		select {
+		case <-t.stop:
+			b.ForcedFlush()
+			return
+		default:
+		}
+
 		envelope, ok := t.nexter.TryNext()
 		if !ok {
 			b.Flush()
-			time.Sleep(100 * time.Millisecond)
+
+			select {
+			case <-t.stop:
+			case <-time.After(100 * time.Millisecond):
+			}
 			continue
 		}
 
+		t.tracer.Mark(envelope, "batch")
 		b.Write(envelope)
 	}
 }
@@ -85,16 +233,56 @@ func (t *Transponder) write(batch []*loggregator_v2.Envelope) {
 		t.addTags(e)
 	}
 
-	if err := t.writer.Write(batch); err != nil {
+	t.tracer.MarkBatch(batch, "write")
+	start := time.Now()
+	err := t.writer.Write(batch)
+	atomic.StoreInt64(&t.writeLatency, int64(time.Since(start)))
+	t.tracer.Finish(batch, err)
+	t.prober.Acknowledge(batch, err)
+
+	if err != nil {
 		// metric-documentation-v2: (loggregator.metron.dropped) Number of messages
 		// dropped when failing to write to Dopplers v2 API
 		t.droppedMetric.Increment(uint64(len(batch)))
+		atomic.AddUint64(&t.dropped, uint64(len(batch)))
 		return
 	}
 
 	// metric-documentation-v2: (loggregator.metron.egress)
 	// Number of messages written to Doppler's v2 API
 	t.egressMetric.Increment(uint64(len(batch)))
+	t.typeCounter.Count(batch)
+}
+
+// Stop stops reading envelopes and writes the envelopes of the current
+// batch, so that they are not lost when the agent stops. It waits up to
+// timeout for the final write and reports whether it completed. Stop must
+// only be called once Start has been called.
+func (t *Transponder) Stop(timeout time.Duration) bool {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-t.stopped:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Dropped returns the number of envelopes dropped because a batch failed to
+// be written.
+func (t *Transponder) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// WriteLatency returns how long the most recent batch took to be written.
+func (t *Transponder) WriteLatency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.writeLatency))
 }
 
 func (t *Transponder) addTags(e *loggregator_v2.Envelope) {
@@ -116,11 +304,42 @@ func (t *Transponder) addTags(e *loggregator_v2.Envelope) {
 		}
 	}
 
+	var conflicted bool
 	for k, v := range t.tags {
-		if _, ok := e.Tags[k]; !ok {
+		existing, ok := e.Tags[k]
+		if ok && existing != v {
+			conflicted = true
+			t.logConflict(k, existing, v)
+		}
+
+		if !ok || t.overrideTags {
 			e.Tags[k] = v
 		}
 	}
 
+	if conflicted && t.reportConflicts {
+		t.conflictMetric.Increment(1)
+	}
+
 	e.DeprecatedTags = nil
 }
+
+// logConflict logs the first conflict for each agent tag.
+func (t *Transponder) logConflict(k, envelopeValue, agentValue string) {
+	if !t.reportConflicts {
+		return
+	}
+
+	if _, logged := t.loggedConflicts.LoadOrStore(k, true); logged {
+		return
+	}
+
+	kept := envelopeValue
+	if t.overrideTags {
+		kept = agentValue
+	}
+	logger.With(logging.Fields{"tag": k}).Warnf(
+		"envelope tag %s=%q conflicts with agent tag %s=%q, keeping %q",
+		k, envelopeValue, k, agentValue, kept,
+	)
+}
@@ -2,11 +2,14 @@ package v2_test
 
 import (
 	"errors"
+	"net/http"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
 	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/tracing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -90,6 +93,23 @@ var _ = Describe("Transponder", func() {
 			Consistently(writer.WriteCalled).Should(HaveLen(1))
 		})
 
+		It("counts envelopes dropped upon egress failure", func() {
+			envelope := &loggregator_v2.Envelope{SourceId: "uuid"}
+			nexter := newMockNexter()
+			writer := newMockWriter()
+			writer.WriteOutput.Ret0 <- errors.New("some-error")
+
+			for i := 0; i < 5; i++ {
+				nexter.TryNextOutput.Ret0 <- envelope
+				nexter.TryNextOutput.Ret1 <- true
+			}
+
+			tx := egress.NewTransponder(nexter, writer, nil, 5, time.Minute, testhelper.NewMetricClient())
+			go tx.Start()
+
+			Eventually(tx.Dropped).Should(Equal(uint64(5)))
+		})
+
 		It("emits egress metric", func() {
 			envelope := &loggregator_v2.Envelope{SourceId: "uuid"}
 			nexter := newMockNexter()
@@ -173,6 +193,37 @@ var _ = Describe("Transponder", func() {
 			Expect(output[0].Tags["existing-tag"]).To(Equal("existing-value"))
 		})
 
+		It("overrides envelope tags and counts conflicts when configured", func() {
+			tags := map[string]string{
+				"existing-tag": "some-new-value",
+				"same-tag":     "same-value",
+			}
+			input := &loggregator_v2.Envelope{
+				SourceId: "uuid",
+				Tags: map[string]string{
+					"existing-tag": "existing-value",
+					"same-tag":     "same-value",
+				},
+			}
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- input
+			nexter.TryNextOutput.Ret1 <- true
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			spy := testhelper.NewMetricClient()
+			tx := egress.NewTransponder(nexter, writer, tags, 1, time.Nanosecond, spy,
+				egress.WithTransponderTagOverride(),
+				egress.WithTransponderTagConflicts(),
+			)
+			go tx.Start()
+
+			var output []*loggregator_v2.Envelope
+			Eventually(writer.WriteInput.Msg).Should(Receive(&output))
+			Expect(output[0].Tags).To(HaveKeyWithValue("existing-tag", "some-new-value"))
+			Expect(spy.GetMetric("tag_conflicts").Delta()).To(Equal(uint64(1)))
+		})
+
 		It("moves DesprecatedTags to Tags", func() {
 			input := &loggregator_v2.Envelope{
 				SourceId: "uuid",
@@ -203,4 +254,132 @@ var _ = Describe("Transponder", func() {
 			Expect(output[0].Tags["decimal-tag"]).To(Equal("0.23"))
 		})
 	})
+
+	Describe("tracing", func() {
+		It("finishes the traces of written envelopes", func() {
+			envelope := &loggregator_v2.Envelope{SourceId: "uuid"}
+			exporter := &spySpanExporter{}
+			tracer := tracing.NewTracer(1, exporter)
+			tracer.Sample(envelope, "ingress")
+
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- envelope
+			nexter.TryNextOutput.Ret1 <- true
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			tx := egress.NewTransponder(nexter, writer, nil, 1, time.Nanosecond, testhelper.NewMetricClient(),
+				egress.WithTransponderTracer(tracer),
+			)
+			go tx.Start()
+
+			Eventually(exporter.names).Should(Equal([]string{"envelope", "ingress", "batch", "write"}))
+		})
+	})
+
+	Describe("stopping", func() {
+		It("writes the current batch when stopped", func() {
+			envelope := &loggregator_v2.Envelope{SourceId: "uuid"}
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- envelope
+			nexter.TryNextOutput.Ret1 <- true
+			close(nexter.TryNextOutput.Ret0)
+			close(nexter.TryNextOutput.Ret1)
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			tx := egress.NewTransponder(nexter, writer, nil, 5, time.Hour, testhelper.NewMetricClient())
+			go tx.Start()
+			Eventually(nexter.TryNextCalled).Should(Receive())
+			Eventually(nexter.TryNextCalled).Should(Receive())
+
+			Expect(tx.Stop(time.Second)).To(BeTrue())
+
+			var batch []*loggregator_v2.Envelope
+			Expect(writer.WriteInput.Msg).To(Receive(&batch))
+			Expect(batch).To(HaveLen(1))
+		})
+
+		It("gives up on the final write after the timeout", func() {
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- &loggregator_v2.Envelope{SourceId: "uuid"}
+			nexter.TryNextOutput.Ret1 <- true
+			close(nexter.TryNextOutput.Ret0)
+			close(nexter.TryNextOutput.Ret1)
+			writer := newMockWriter()
+
+			tx := egress.NewTransponder(nexter, writer, nil, 5, time.Hour, testhelper.NewMetricClient())
+			go tx.Start()
+			Eventually(nexter.TryNextCalled).Should(Receive())
+			Eventually(nexter.TryNextCalled).Should(Receive())
+
+			Expect(tx.Stop(10 * time.Millisecond)).To(BeFalse())
+		})
+	})
+
+	Describe("probing", func() {
+		It("acknowledges written probes", func() {
+			setter := &spyProbeSetter{}
+			proberMetrics := testhelper.NewMetricClient()
+			prober := egress.NewProber(setter, "metron", time.Minute, time.Minute, proberMetrics)
+			prober.Probe()
+
+			nexter := newMockNexter()
+			nexter.TryNextOutput.Ret0 <- setter.Envelopes()[0]
+			nexter.TryNextOutput.Ret1 <- true
+			writer := newMockWriter()
+			close(writer.WriteOutput.Ret0)
+
+			tx := egress.NewTransponder(nexter, writer, nil, 1, time.Nanosecond, testhelper.NewMetricClient(),
+				egress.WithTransponderProber(prober),
+			)
+			go tx.Start()
+
+			Eventually(proberMetrics.GetMetric("probe_success").GaugeValue).Should(Equal(1.0))
+		})
+	})
+
+	Describe("BorrowsBatches", func() {
+		It("is false for writers that do not report it", func() {
+			Expect(egress.BorrowsBatches(newMockWriter())).To(BeFalse())
+		})
+
+		It("is true for sinks that do not retain batches", func() {
+			w := egress.NewHTTPWriter("some-sink", "http://localhost", http.DefaultClient, testhelper.NewMetricClient())
+
+			Expect(egress.BorrowsBatches(w)).To(BeTrue())
+		})
+
+		It("follows processors to the writers they wrap", func() {
+			mc := testhelper.NewMetricClient()
+			sink := egress.NewHTTPWriter("some-sink", "http://localhost", http.DefaultClient, mc)
+
+			Expect(egress.BorrowsBatches(egress.NewTruncator(1024, sink, mc))).To(BeTrue())
+			Expect(egress.BorrowsBatches(egress.NewTruncator(1024, newMockWriter(), mc))).To(BeFalse())
+		})
+	})
 })
+
+type spySpanExporter struct {
+	mu    sync.Mutex
+	spans []tracing.Span
+}
+
+func (s *spySpanExporter) Export(spans []tracing.Span) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spans = append(s.spans, spans...)
+}
+
+func (s *spySpanExporter) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for _, span := range s.spans {
+		names = append(names, span.Name)
+	}
+
+	return names
+}
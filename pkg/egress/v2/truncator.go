@@ -0,0 +1,169 @@
+package v2
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// TruncatedTag is the tag set on envelopes whose log payload was truncated.
+const TruncatedTag = "truncated"
+
+// maxTruncationSources bounds the number of source IDs truncations are
+// counted for. Truncations from further sources are counted together.
+const maxTruncationSources = 1000
+
+// otherTruncationSource is the source_id tag of truncations counted
+// together once maxTruncationSources is reached.
+const otherTruncationSource = "other"
+
+// Truncator truncates the payloads of log envelopes larger than a maximum
+// size so that a single large log does not fail the whole batch it is sent
+// in. Envelopes of other types are written as they are. Envelopes are
+// modified in place.
+type Truncator struct {
+	maxSize        int
+	maxPayloadSize int
+	suffix         []byte
+	next           Writer
+	metricClient   MetricClient
+
+	mu      sync.Mutex
+	metrics map[string]pulseemitter.CounterMetric
+}
+
+// TruncatorOption configures a Truncator.
+type TruncatorOption func(*Truncator)
+
+// WithMaxPayloadSize limits the payloads of log envelopes to n bytes,
+// including any suffix.
+func WithMaxPayloadSize(n int) TruncatorOption {
+	return func(t *Truncator) {
+		t.maxPayloadSize = n
+	}
+}
+
+// WithTruncationSuffix appends the suffix to truncated payloads, e.g.
+// "...TRUNCATED".
+func WithTruncationSuffix(suffix string) TruncatorOption {
+	return func(t *Truncator) {
+		t.suffix = []byte(suffix)
+	}
+}
+
+// NewTruncator returns a Truncator that limits envelopes to maxSize bytes.
+// A maxSize of 0 does not limit the size of envelopes.
+func NewTruncator(maxSize int, next Writer, m MetricClient, opts ...TruncatorOption) *Truncator {
+	t := &Truncator{
+		maxSize:      maxSize,
+		next:         next,
+		metricClient: m,
+		metrics:      make(map[string]pulseemitter.CounterMetric),
+	}
+
+	for _, o := range opts {
+		o(t)
+	}
+
+	return t
+}
+
+// Write truncates the oversized logs of the batch and writes it to the next
+// Writer.
+func (t *Truncator) Write(batch []*loggregator_v2.Envelope) error {
+	var truncated map[string]uint64
+	for _, e := range batch {
+		if !t.truncate(e) {
+			continue
+		}
+
+		if truncated == nil {
+			truncated = make(map[string]uint64)
+		}
+		truncated[e.GetSourceId()]++
+	}
+
+	for sourceID, n := range truncated {
+		t.metric(sourceID).Increment(n)
+	}
+
+	return t.next.Write(batch)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (t *Truncator) BorrowsBatches() bool {
+	return BorrowsBatches(t.next)
+}
+
+// metric returns the truncation counter for a source ID. Counters are
+// created when a source's log is first truncated.
+func (t *Truncator) metric(sourceID string) pulseemitter.CounterMetric {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if m, ok := t.metrics[sourceID]; ok {
+		return m
+	}
+
+	if len(t.metrics) >= maxTruncationSources {
+		sourceID = otherTruncationSource
+		if m, ok := t.metrics[sourceID]; ok {
+			return m
+		}
+	}
+
+	// metric-documentation-v2: (loggregator.metron.truncated_envelopes)
+	// Number of log envelopes whose payload was truncated to fit the max
+	// envelope or payload size
+	m := t.metricClient.NewCounterMetric("truncated_envelopes",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"source_id": sourceID}),
+	)
+	t.metrics[sourceID] = m
+
+	return m
+}
+
+func (t *Truncator) truncate(e *loggregator_v2.Envelope) bool {
+	log := e.GetLog()
+	if log == nil {
+		return false
+	}
+
+	overPayload := t.maxPayloadSize > 0 && len(log.Payload) > t.maxPayloadSize
+	overSize := t.maxSize > 0 && proto.Size(e) > t.maxSize
+	if !overPayload && !overSize {
+		return false
+	}
+
+	if e.Tags == nil {
+		e.Tags = make(map[string]string)
+	}
+	e.Tags[TruncatedTag] = "true"
+
+	limit := len(log.Payload)
+	if overPayload {
+		limit = t.maxPayloadSize
+	}
+	if t.maxSize > 0 {
+		if n := len(log.Payload) - (proto.Size(e) - t.maxSize); n < limit {
+			limit = n
+		}
+	}
+
+	n := limit - len(t.suffix)
+	if n < 0 {
+		n = 0
+	}
+
+	// Do not split a multi-byte character.
+	for n > 0 && n < len(log.Payload) && !utf8.RuneStart(log.Payload[n]) {
+		n--
+	}
+	log.Payload = append(log.Payload[:n:n], t.suffix...)
+
+	return true
+}
@@ -0,0 +1,107 @@
+package v2_test
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+	"github.com/golang/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Truncator", func() {
+	var (
+		next         *conformance.SpyWriter
+		metricClient *testhelper.SpyMetricClient
+		t            *egress.Truncator
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		metricClient = testhelper.NewMetricClient()
+		t = egress.NewTruncator(200, next, metricClient)
+	})
+
+	logOf := func(payload string) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId: "app",
+			Message: &loggregator_v2.Envelope_Log{
+				Log: &loggregator_v2.Log{Payload: []byte(payload)},
+			},
+		}
+	}
+
+	It("truncates oversized logs to the max size", func() {
+		e := logOf(strings.Repeat("a", 1000))
+		Expect(t.Write([]*loggregator_v2.Envelope{e})).To(Succeed())
+
+		sent := next.Delivered()[0]
+		Expect(proto.Size(sent)).To(BeNumerically("<=", 200))
+		Expect(len(sent.GetLog().GetPayload())).To(BeNumerically(">", 100))
+		Expect(sent.GetTags()).To(HaveKeyWithValue(egress.TruncatedTag, "true"))
+		Expect(metricClient.GetMetric("truncated_envelopes").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("does not split multi-byte characters", func() {
+		e := logOf(strings.Repeat("é", 500))
+		Expect(t.Write([]*loggregator_v2.Envelope{e})).To(Succeed())
+
+		payload := next.Delivered()[0].GetLog().GetPayload()
+		Expect(strings.Repeat("é", len(payload)/2)).To(Equal(string(payload)))
+	})
+
+	It("limits log payloads and appends the truncation suffix", func() {
+		t = egress.NewTruncator(0, next, metricClient,
+			egress.WithMaxPayloadSize(20),
+			egress.WithTruncationSuffix("...TRUNCATED"),
+		)
+
+		Expect(t.Write([]*loggregator_v2.Envelope{
+			logOf(strings.Repeat("a", 100)),
+			logOf("short"),
+		})).To(Succeed())
+
+		Expect(string(next.Delivered()[0].GetLog().GetPayload())).To(Equal("aaaaaaaa...TRUNCATED"))
+		Expect(string(next.Delivered()[1].GetLog().GetPayload())).To(Equal("short"))
+	})
+
+	It("counts truncations per source ID", func() {
+		t = egress.NewTruncator(0, next, metricClient, egress.WithMaxPayloadSize(10))
+
+		e := logOf(strings.Repeat("a", 100))
+		e.SourceId = "noisy"
+		Expect(t.Write([]*loggregator_v2.Envelope{e})).To(Succeed())
+		Expect(t.Write([]*loggregator_v2.Envelope{
+			logOf(strings.Repeat("a", 100)),
+			logOf(strings.Repeat("a", 100)),
+		})).To(Succeed())
+
+		Expect(metricClient.GetMetric("truncated_envelopes").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("writes envelopes within the max size as they are", func() {
+		e := logOf("hello")
+		Expect(t.Write([]*loggregator_v2.Envelope{e})).To(Succeed())
+
+		sent := next.Delivered()[0]
+		Expect(string(sent.GetLog().GetPayload())).To(Equal("hello"))
+		Expect(sent.GetTags()).ToNot(HaveKey(egress.TruncatedTag))
+		Expect(metricClient.GetMetric("truncated_envelopes")).To(BeNil())
+	})
+
+	It("writes oversized envelopes of other types as they are", func() {
+		e := &loggregator_v2.Envelope{
+			SourceId: strings.Repeat("a", 1000),
+			Message: &loggregator_v2.Envelope_Counter{
+				Counter: &loggregator_v2.Counter{Name: "requests"},
+			},
+		}
+		Expect(t.Write([]*loggregator_v2.Envelope{e})).To(Succeed())
+
+		Expect(next.Delivered()[0].GetSourceId()).To(HaveLen(1000))
+	})
+})
@@ -0,0 +1,76 @@
+package v2
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// UTF8Sanitizer replaces invalid UTF-8 in the payloads of log envelopes
+// before writing them to the next Writer, for consumers that reject or
+// mangle invalid sequences. Envelopes are modified in place.
+type UTF8Sanitizer struct {
+	replacement     []byte
+	next            Writer
+	sanitizedMetric pulseemitter.CounterMetric
+}
+
+// UTF8SanitizerOption configures a UTF8Sanitizer.
+type UTF8SanitizerOption func(*UTF8Sanitizer)
+
+// WithUTF8Replacement sets what each run of invalid bytes is replaced with.
+// It defaults to the Unicode replacement character.
+func WithUTF8Replacement(r string) UTF8SanitizerOption {
+	return func(s *UTF8Sanitizer) {
+		s.replacement = []byte(r)
+	}
+}
+
+// NewUTF8Sanitizer returns a UTF8Sanitizer that writes to next.
+func NewUTF8Sanitizer(next Writer, m MetricClient, opts ...UTF8SanitizerOption) *UTF8Sanitizer {
+	// metric-documentation-v2: (loggregator.metron.sanitized_envelopes)
+	// Number of log envelopes whose payload had invalid UTF-8 replaced
+	sanitizedMetric := m.NewCounterMetric("sanitized_envelopes",
+		pulseemitter.WithVersion(2, 0),
+	)
+
+	s := &UTF8Sanitizer{
+		replacement:     []byte(string(utf8.RuneError)),
+		next:            next,
+		sanitizedMetric: sanitizedMetric,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// Write sanitizes the log payloads of the batch and writes it to the next
+// Writer.
+func (s *UTF8Sanitizer) Write(batch []*loggregator_v2.Envelope) error {
+	var sanitized uint64
+	for _, e := range batch {
+		log := e.GetLog()
+		if log == nil || utf8.Valid(log.Payload) {
+			continue
+		}
+
+		log.Payload = bytes.ToValidUTF8(log.Payload, s.replacement)
+		sanitized++
+	}
+
+	if sanitized > 0 {
+		s.sanitizedMetric.Increment(sanitized)
+	}
+
+	return s.next.Write(batch)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (s *UTF8Sanitizer) BorrowsBatches() bool {
+	return BorrowsBatches(s.next)
+}
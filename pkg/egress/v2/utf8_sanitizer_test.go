@@ -0,0 +1,53 @@
+package v2_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UTF8Sanitizer", func() {
+	var (
+		next         *conformance.SpyWriter
+		metricClient *testhelper.SpyMetricClient
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		metricClient = testhelper.NewMetricClient()
+	})
+
+	logOf := func(payload []byte) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId: "app",
+			Message: &loggregator_v2.Envelope_Log{
+				Log: &loggregator_v2.Log{Payload: payload},
+			},
+		}
+	}
+
+	It("replaces invalid UTF-8 in log payloads", func() {
+		s := egress.NewUTF8Sanitizer(next, metricClient)
+
+		Expect(s.Write([]*loggregator_v2.Envelope{
+			logOf([]byte("bad \xff\xfe byte")),
+			logOf([]byte("héllo")),
+		})).To(Succeed())
+
+		Expect(string(next.Delivered()[0].GetLog().GetPayload())).To(Equal("bad � byte"))
+		Expect(string(next.Delivered()[1].GetLog().GetPayload())).To(Equal("héllo"))
+		Expect(metricClient.GetMetric("sanitized_envelopes").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("uses the configured replacement", func() {
+		s := egress.NewUTF8Sanitizer(next, metricClient, egress.WithUTF8Replacement("?"))
+
+		Expect(s.Write([]*loggregator_v2.Envelope{logOf([]byte("a\xffb"))})).To(Succeed())
+
+		Expect(string(next.Delivered()[0].GetLog().GetPayload())).To(Equal("a?b"))
+	})
+})
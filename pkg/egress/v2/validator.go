@@ -0,0 +1,112 @@
+package v2
+
+import (
+	"math"
+	"sync"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// The violations an envelope can be rejected for.
+const (
+	ViolationMissingTimestamp = "missing_timestamp"
+	ViolationMissingSourceID  = "missing_source_id"
+	ViolationMissingMessage   = "missing_message"
+	ViolationInvalidGauge     = "invalid_gauge"
+)
+
+// Validator drops malformed envelopes rather than writing them to the next
+// Writer. Dropped envelopes are counted by the first violation found.
+type Validator struct {
+	next         Writer
+	metricClient MetricClient
+
+	mu      sync.Mutex
+	metrics map[string]pulseemitter.CounterMetric
+}
+
+// NewValidator returns a Validator that writes valid envelopes to next.
+func NewValidator(next Writer, m MetricClient) *Validator {
+	return &Validator{
+		next:         next,
+		metricClient: m,
+		metrics:      make(map[string]pulseemitter.CounterMetric),
+	}
+}
+
+// Write writes the valid envelopes of the batch to the next Writer.
+func (v *Validator) Write(batch []*loggregator_v2.Envelope) error {
+	valid := batch[:0:0]
+	violations := make(map[string]uint64)
+	for _, e := range batch {
+		if violation := validate(e); violation != "" {
+			violations[violation]++
+			continue
+		}
+		valid = append(valid, e)
+	}
+
+	for violation, n := range violations {
+		v.metric(violation).Increment(n)
+	}
+
+	if len(violations) == 0 {
+		return v.next.Write(batch)
+	}
+
+	if len(valid) == 0 {
+		return nil
+	}
+
+	return v.next.Write(valid)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches.
+func (v *Validator) BorrowsBatches() bool {
+	return BorrowsBatches(v.next)
+}
+
+// metric returns the counter for a violation. Counters are created when a
+// violation is first seen.
+func (v *Validator) metric(violation string) pulseemitter.CounterMetric {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	m, ok := v.metrics[violation]
+	if !ok {
+		// metric-documentation-v2: (loggregator.metron.invalid_envelopes)
+		// Number of malformed envelopes dropped by validation
+		m = v.metricClient.NewCounterMetric("invalid_envelopes",
+			pulseemitter.WithVersion(2, 0),
+			pulseemitter.WithTags(map[string]string{"violation": violation}),
+		)
+		v.metrics[violation] = m
+	}
+
+	return m
+}
+
+// validate returns the first violation of the envelope or an empty string
+// if it is valid.
+func validate(e *loggregator_v2.Envelope) string {
+	if e.GetTimestamp() == 0 {
+		return ViolationMissingTimestamp
+	}
+
+	if e.GetSourceId() == "" {
+		return ViolationMissingSourceID
+	}
+
+	if e.GetMessage() == nil {
+		return ViolationMissingMessage
+	}
+
+	for _, m := range e.GetGauge().GetMetrics() {
+		if math.IsNaN(m.GetValue()) || math.IsInf(m.GetValue(), 0) {
+			return ViolationInvalidGauge
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,86 @@
+package v2_test
+
+import (
+	"math"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/egress/v2/conformance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validator", func() {
+	var (
+		next         *conformance.SpyWriter
+		metricClient *testhelper.SpyMetricClient
+		v            *egress.Validator
+	)
+
+	BeforeEach(func() {
+		next = conformance.NewSpyWriter()
+		metricClient = testhelper.NewMetricClient()
+		v = egress.NewValidator(next, metricClient)
+	})
+
+	logFrom := func(sourceID string, timestamp int64) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId:  sourceID,
+			Timestamp: timestamp,
+			Message: &loggregator_v2.Envelope_Log{
+				Log: &loggregator_v2.Log{Payload: []byte("hello")},
+			},
+		}
+	}
+
+	gauge := func(value float64) *loggregator_v2.Envelope {
+		return &loggregator_v2.Envelope{
+			SourceId:  "app",
+			Timestamp: 1,
+			Message: &loggregator_v2.Envelope_Gauge{
+				Gauge: &loggregator_v2.Gauge{
+					Metrics: map[string]*loggregator_v2.GaugeValue{
+						"cpu": {Value: value},
+					},
+				},
+			},
+		}
+	}
+
+	It("writes valid envelopes", func() {
+		batch := []*loggregator_v2.Envelope{logFrom("app", 1), gauge(0.5)}
+		Expect(v.Write(batch)).To(Succeed())
+
+		Expect(next.Delivered()).To(Equal(batch))
+	})
+
+	It("drops envelopes without a timestamp", func() {
+		Expect(v.Write([]*loggregator_v2.Envelope{logFrom("app", 0), logFrom("app", 1)})).To(Succeed())
+
+		Expect(next.Delivered()).To(HaveLen(1))
+		Expect(metricClient.GetMetric("invalid_envelopes").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("drops envelopes without a source ID", func() {
+		Expect(v.Write([]*loggregator_v2.Envelope{logFrom("", 1)})).To(Succeed())
+
+		Expect(next.Delivered()).To(BeEmpty())
+		Expect(metricClient.GetMetric("invalid_envelopes").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("drops envelopes without a message", func() {
+		Expect(v.Write([]*loggregator_v2.Envelope{{SourceId: "app", Timestamp: 1}})).To(Succeed())
+
+		Expect(next.Delivered()).To(BeEmpty())
+		Expect(metricClient.GetMetric("invalid_envelopes").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("drops gauges with NaN or infinite values", func() {
+		Expect(v.Write([]*loggregator_v2.Envelope{gauge(math.NaN()), gauge(math.Inf(1))})).To(Succeed())
+
+		Expect(next.Delivered()).To(BeEmpty())
+		Expect(metricClient.GetMetric("invalid_envelopes").Delta()).To(Equal(uint64(2)))
+	})
+})
@@ -0,0 +1,49 @@
+package healthendpoint
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Destinations is a set of named reporters that each describe the health of
+// a group of egress destinations.
+type Destinations struct {
+	mu        sync.Mutex
+	reporters map[string]func() interface{}
+}
+
+// NewDestinations returns a Destinations with no reporters.
+func NewDestinations() *Destinations {
+	return &Destinations{
+		reporters: make(map[string]func() interface{}),
+	}
+}
+
+// Register adds a reporter with the given name. Registering a name again
+// replaces the previous reporter.
+func (d *Destinations) Register(name string, f func() interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.reporters[name] = f
+}
+
+// ServeHTTP responds with the result of every reporter as JSON keyed by
+// reporter name.
+func (d *Destinations) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	reporters := make(map[string]func() interface{}, len(d.reporters))
+	for k, v := range d.reporters {
+		reporters[k] = v
+	}
+	d.mu.Unlock()
+
+	health := make(map[string]interface{}, len(reporters))
+	for name, f := range reporters {
+		health[name] = f()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,132 @@
+package healthendpoint
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StreamState is the state of the streams to a single doppler.
+type StreamState string
+
+const (
+	// StreamConnected means at least one stream to the doppler is open.
+	StreamConnected StreamState = "connected"
+
+	// StreamReconnecting means every stream to the doppler has been closed
+	// and no attempt to open a new one has failed.
+	StreamReconnecting StreamState = "reconnecting"
+
+	// StreamFailed means no stream to the doppler is open and the last
+	// attempt to open one failed.
+	StreamFailed StreamState = "failed"
+)
+
+// DopplerState is the state of the streams to a single doppler address.
+type DopplerState struct {
+	Addr    string      `json:"addr"`
+	State   StreamState `json:"state"`
+	Since   time.Time   `json:"since"`
+	Streams int         `json:"streams"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// StreamOpened records that a stream to the doppler at addr was opened.
+func (h *Registrar) StreamOpened(addr string) {
+	h.dopplers.update(addr, func(s *DopplerState) {
+		s.Streams++
+		s.Error = ""
+	})
+}
+
+// StreamClosed records that a stream to the doppler at addr was closed.
+func (h *Registrar) StreamClosed(addr string) {
+	h.dopplers.update(addr, func(s *DopplerState) {
+		if s.Streams > 0 {
+			s.Streams--
+		}
+	})
+}
+
+// StreamFailed records that a stream to the doppler at addr could not be
+// opened.
+func (h *Registrar) StreamFailed(addr string, err error) {
+	h.dopplers.update(addr, func(s *DopplerState) {
+		s.Error = err.Error()
+	})
+}
+
+// DopplerStates returns the state of every doppler a stream has been opened
+// to or attempted, sorted by address.
+func (h *Registrar) DopplerStates() []DopplerState {
+	return h.dopplers.list()
+}
+
+// DopplerStatesHandler returns a handler that serves the state of every
+// doppler as JSON.
+func (h *Registrar) DopplerStatesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.DopplerStates())
+	})
+}
+
+type dopplerStates struct {
+	mu     sync.Mutex
+	states map[string]*DopplerState
+	now    func() time.Time
+}
+
+func newDopplerStates() *dopplerStates {
+	return &dopplerStates{
+		states: make(map[string]*DopplerState),
+		now:    time.Now,
+	}
+}
+
+// update applies f to the state for addr and then derives the stream state
+// from the number of open streams and the last error. Since is only changed
+// when the stream state changes.
+func (d *dopplerStates) update(addr string, f func(*DopplerState)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.states[addr]
+	if !ok {
+		s = &DopplerState{Addr: addr}
+		d.states[addr] = s
+	}
+
+	f(s)
+
+	state := StreamReconnecting
+	switch {
+	case s.Streams > 0:
+		state = StreamConnected
+	case s.Error != "":
+		state = StreamFailed
+	}
+
+	if state != s.State {
+		s.State = state
+		s.Since = d.now()
+	}
+}
+
+func (d *dopplerStates) list() []DopplerState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	states := make([]DopplerState, 0, len(d.states))
+	for _, s := range d.states {
+		states = append(states, *s)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Addr < states[j].Addr
+	})
+
+	return states
+}
@@ -0,0 +1,79 @@
+package healthendpoint
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Readiness is a set of named checks that must all pass before the agent is
+// ready to accept traffic.
+type Readiness struct {
+	mu     sync.Mutex
+	names  []string
+	checks map[string]func() error
+}
+
+// NewReadiness returns a Readiness with no checks. It is ready until a
+// failing check is registered.
+func NewReadiness() *Readiness {
+	return &Readiness{
+		checks: make(map[string]func() error),
+	}
+}
+
+// Register adds a check with the given name. The check returns an error
+// describing why the agent is not ready. Registering a name again replaces
+// the previous check.
+func (r *Readiness) Register(name string, check func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.checks[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.checks[name] = check
+}
+
+// Check runs every check and returns the reason each failing check gave,
+// keyed by check name. An empty map means the agent is ready.
+func (r *Readiness) Check() map[string]string {
+	r.mu.Lock()
+	names := make([]string, len(r.names))
+	copy(names, r.names)
+	checks := make(map[string]func() error, len(r.checks))
+	for k, v := range r.checks {
+		checks[k] = v
+	}
+	r.mu.Unlock()
+
+	failures := make(map[string]string)
+	for _, name := range names {
+		if err := checks[name](); err != nil {
+			failures[name] = err.Error()
+		}
+	}
+
+	return failures
+}
+
+type readinessResponse struct {
+	Ready    bool              `json:"ready"`
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+// ServeHTTP responds with 200 when every check passes and 503 with the
+// reasons for each failing check otherwise.
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	failures := r.Check()
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(readinessResponse{
+		Ready:    len(failures) == 0,
+		Failures: failures,
+	})
+}
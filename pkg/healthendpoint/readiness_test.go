@@ -0,0 +1,53 @@
+package healthendpoint_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/healthendpoint"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Readiness", func() {
+	var r *healthendpoint.Readiness
+
+	BeforeEach(func() {
+		r = healthendpoint.NewReadiness()
+	})
+
+	It("is ready without checks", func() {
+		Expect(r.Check()).To(BeEmpty())
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"ready": true}`))
+	})
+
+	It("is not ready while any check fails", func() {
+		r.Register("doppler", func() error { return errors.New("no streams") })
+		r.Register("ingress", func() error { return nil })
+
+		Expect(r.Check()).To(Equal(map[string]string{"doppler": "no streams"}))
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Body.String()).To(MatchJSON(`{
+			"ready": false,
+			"failures": {"doppler": "no streams"}
+		}`))
+	})
+
+	It("replaces a check registered with the same name", func() {
+		r.Register("doppler", func() error { return errors.New("no streams") })
+		r.Register("doppler", func() error { return nil })
+
+		Expect(r.Check()).To(BeEmpty())
+	})
+})
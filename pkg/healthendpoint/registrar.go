@@ -4,12 +4,14 @@ import (
 	"log"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 // Registrar maintains a list of metrics to be served by the health endpoint
-// server.
+// server along with the state of the streams to each doppler.
 type Registrar struct {
-	gauges map[string]prometheus.Gauge
+	gauges   map[string]prometheus.Gauge
+	dopplers *dopplerStates
 }
 
 // New returns an initialized health endpoint registrar configured with the
@@ -20,7 +22,8 @@ func New(registrar prometheus.Registerer, gauges map[string]prometheus.Gauge) *R
 	}
 
 	return &Registrar{
-		gauges: gauges,
+		gauges:   gauges,
+		dopplers: newDopplerStates(),
 	}
 }
 
@@ -57,3 +60,20 @@ func (h *Registrar) Dec(name string) {
 
 	g.Dec()
 }
+
+// Get returns the current value of the gauge metric with the given name. If
+// the gauge metric is not found the process will exit with a status code of
+// 1.
+func (h *Registrar) Get(name string) float64 {
+	g, ok := h.gauges[name]
+	if !ok {
+		log.Panicf("Get called for unknown health metric: %s", name)
+	}
+
+	var m dto.Metric
+	if err := g.Write(&m); err != nil {
+		log.Panicf("Unable to read health metric %s: %s", name, err)
+	}
+
+	return m.GetGauge().GetValue()
+}
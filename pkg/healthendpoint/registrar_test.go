@@ -1,6 +1,8 @@
 package healthendpoint_test
 
 import (
+	"errors"
+
 	"code.cloudfoundry.org/loggregator-agent/pkg/healthendpoint"
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -61,6 +63,75 @@ var _ = Describe("Health", func() {
 			Expect(gaugeCount2.dec).To(Equal(1))
 		})
 	})
+
+	Describe("Get()", func() {
+		It("returns the value of the gauge", func() {
+			g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "count"})
+			h = healthendpoint.New(registrar, map[string]prometheus.Gauge{
+				"count": g,
+			})
+
+			h.Set("count", 3)
+			h.Inc("count")
+
+			Expect(h.Get("count")).To(Equal(4.0))
+		})
+
+		It("panics for an unknown gauge", func() {
+			Expect(func() { h.Get("unknown") }).To(Panic())
+		})
+	})
+
+	Describe("DopplerStates()", func() {
+		It("reports each doppler sorted by address", func() {
+			h.StreamOpened("doppler-b:8082")
+			h.StreamOpened("doppler-a:8082")
+			h.StreamOpened("doppler-a:8082")
+
+			states := h.DopplerStates()
+			Expect(states).To(HaveLen(2))
+			Expect(states[0].Addr).To(Equal("doppler-a:8082"))
+			Expect(states[0].State).To(Equal(healthendpoint.StreamConnected))
+			Expect(states[0].Streams).To(Equal(2))
+			Expect(states[1].Addr).To(Equal("doppler-b:8082"))
+			Expect(states[1].Streams).To(Equal(1))
+		})
+
+		It("reports a doppler with no open streams as reconnecting", func() {
+			h.StreamOpened("doppler:8082")
+			h.StreamClosed("doppler:8082")
+
+			states := h.DopplerStates()
+			Expect(states).To(HaveLen(1))
+			Expect(states[0].State).To(Equal(healthendpoint.StreamReconnecting))
+			Expect(states[0].Streams).To(Equal(0))
+		})
+
+		It("reports a doppler as failed until a stream is opened", func() {
+			h.StreamFailed("doppler:8082", errors.New("connection refused"))
+
+			states := h.DopplerStates()
+			Expect(states[0].State).To(Equal(healthendpoint.StreamFailed))
+			Expect(states[0].Error).To(Equal("connection refused"))
+			failedSince := states[0].Since
+			Expect(failedSince).ToNot(BeZero())
+
+			h.StreamFailed("doppler:8082", errors.New("connection refused"))
+			Expect(h.DopplerStates()[0].Since).To(Equal(failedSince))
+
+			h.StreamOpened("doppler:8082")
+			states = h.DopplerStates()
+			Expect(states[0].State).To(Equal(healthendpoint.StreamConnected))
+			Expect(states[0].Error).To(BeEmpty())
+		})
+
+		It("keeps a doppler connected while any stream is open", func() {
+			h.StreamOpened("doppler:8082")
+			h.StreamFailed("doppler:8082", errors.New("connection refused"))
+
+			Expect(h.DopplerStates()[0].State).To(Equal(healthendpoint.StreamConnected))
+		})
+	})
 })
 
 type spyRegistrar struct {
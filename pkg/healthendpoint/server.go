@@ -10,12 +10,66 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// ServerOption configures the health endpoint server.
+type ServerOption func(*serverConfig)
+
+type serverConfig struct {
+	readiness    *Readiness
+	dopplers     *Registrar
+	destinations *Destinations
+}
+
+// WithReadiness serves the given readiness checks at /ready. Without this
+// option /ready always reports the agent as ready.
+func WithReadiness(r *Readiness) ServerOption {
+	return func(c *serverConfig) {
+		c.readiness = r
+	}
+}
+
+// WithDopplerStates serves the stream state of every doppler tracked by the
+// given registrar at /dopplers.
+func WithDopplerStates(r *Registrar) ServerOption {
+	return func(c *serverConfig) {
+		c.dopplers = r
+	}
+}
+
+// WithDestinations serves the health reported by the given destinations at
+// /destinations.
+func WithDestinations(d *Destinations) ServerOption {
+	return func(c *serverConfig) {
+		c.destinations = d
+	}
+}
+
 // StartServer listens and serves the health endpoint HTTP handler on a given
 // address. If the server fails to listen or serve the process will exit with
 // a status code of 1.
-func StartServer(addr string, gatherer prometheus.Gatherer) net.Listener {
+//
+// In addition to the metrics served at /health and /metrics, /alive
+// responds with 200 for as long as the process is able to serve requests and
+// /ready responds with 200 only when the agent is able to egress envelopes.
+func StartServer(addr string, gatherer prometheus.Gatherer, opts ...ServerOption) net.Listener {
+	c := serverConfig{
+		readiness: NewReadiness(),
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+
 	router := http.NewServeMux()
-	router.Handle("/health", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	metrics := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+	router.Handle("/health", metrics)
+	router.Handle("/metrics", metrics)
+	router.HandleFunc("/alive", ok)
+	router.Handle("/ready", c.readiness)
+	if c.dopplers != nil {
+		router.Handle("/dopplers", c.dopplers.DopplerStatesHandler())
+	}
+	if c.destinations != nil {
+		router.Handle("/destinations", c.destinations)
+	}
 
 	server := http.Server{
 		Addr:         addr,
@@ -36,3 +90,7 @@ func StartServer(addr string, gatherer prometheus.Gatherer) net.Listener {
 	}()
 	return lis
 }
+
+func ok(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,94 @@
+package healthendpoint_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/healthendpoint"
+	"github.com/prometheus/client_golang/prometheus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		lis          net.Listener
+		readiness    *healthendpoint.Readiness
+		registrar    *healthendpoint.Registrar
+		destinations *healthendpoint.Destinations
+	)
+
+	BeforeEach(func() {
+		readiness = healthendpoint.NewReadiness()
+		registrar = healthendpoint.New(prometheus.NewRegistry(), nil)
+		destinations = healthendpoint.NewDestinations()
+		lis = healthendpoint.StartServer(
+			"127.0.0.1:0",
+			prometheus.NewRegistry(),
+			healthendpoint.WithReadiness(readiness),
+			healthendpoint.WithDopplerStates(registrar),
+			healthendpoint.WithDestinations(destinations),
+		)
+	})
+
+	AfterEach(func() {
+		lis.Close()
+	})
+
+	get := func(path string) int {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", lis.Addr(), path))
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	It("serves metrics and liveness", func() {
+		Expect(get("/health")).To(Equal(http.StatusOK))
+		Expect(get("/metrics")).To(Equal(http.StatusOK))
+		Expect(get("/alive")).To(Equal(http.StatusOK))
+	})
+
+	It("serves readiness separately from liveness", func() {
+		Expect(get("/ready")).To(Equal(http.StatusOK))
+
+		readiness.Register("doppler", func() error {
+			return errors.New("no streams")
+		})
+
+		Expect(get("/ready")).To(Equal(http.StatusServiceUnavailable))
+		Expect(get("/alive")).To(Equal(http.StatusOK))
+	})
+
+	It("serves the state of each doppler", func() {
+		registrar.StreamOpened("doppler:8082")
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/dopplers", lis.Addr()))
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		var states []healthendpoint.DopplerState
+		Expect(json.NewDecoder(resp.Body).Decode(&states)).To(Succeed())
+		Expect(states).To(HaveLen(1))
+		Expect(states[0].Addr).To(Equal("doppler:8082"))
+		Expect(states[0].State).To(Equal(healthendpoint.StreamConnected))
+	})
+
+	It("serves the health of each destination", func() {
+		destinations.Register("doppler", func() interface{} {
+			return []string{"down"}
+		})
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/destinations", lis.Addr()))
+		Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		var health map[string][]string
+		Expect(json.NewDecoder(resp.Body).Decode(&health)).To(Succeed())
+		Expect(health).To(Equal(map[string][]string{"doppler": {"down"}}))
+	})
+})
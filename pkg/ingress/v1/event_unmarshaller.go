@@ -2,7 +2,6 @@ package v1
 
 import (
 	"errors"
-	"log"
 
 	"github.com/cloudfoundry/sonde-go/events"
 	"github.com/gogo/protobuf/proto"
@@ -31,7 +30,7 @@ func NewUnMarshaller(outputWriter EnvelopeWriter) *EventUnmarshaller {
 func (u *EventUnmarshaller) Write(message []byte) {
 	envelope, err := u.UnmarshallMessage(message)
 	if err != nil {
-		log.Printf("Error unmarshalling: %s", err)
+		logger.Printf("Error unmarshalling: %s", err)
 		return
 	}
 	u.outputWriter.Write(envelope)
@@ -41,7 +40,7 @@ func (u *EventUnmarshaller) UnmarshallMessage(message []byte) (*events.Envelope,
 	envelope := &events.Envelope{}
 	err := proto.Unmarshal(message, envelope)
 	if err != nil {
-		log.Printf("eventUnmarshaller: unmarshal error %v", err)
+		logger.Printf("eventUnmarshaller: unmarshal error %v", err)
 		return nil, err
 	}
 
@@ -54,7 +53,7 @@ func (u *EventUnmarshaller) UnmarshallMessage(message []byte) (*events.Envelope,
 	}
 
 	if !valid(envelope) {
-		log.Printf("eventUnmarshaller: validation failed for message %v", envelope.GetEventType())
+		logger.Printf("eventUnmarshaller: validation failed for message %v", envelope.GetEventType())
 		return nil, invalidEnvelope
 	}
 
@@ -1,14 +1,16 @@
 package v1
 
 import (
-	"log"
 	"net"
 
 	gendiodes "code.cloudfoundry.org/go-diodes"
 	"code.cloudfoundry.org/go-loggregator/pulseemitter"
 	"code.cloudfoundry.org/loggregator-agent/pkg/diodes"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
 )
 
+var logger = logging.New("ingress")
+
 type ByteArrayWriter interface {
 	Write(message []byte)
 }
@@ -35,7 +37,7 @@ func NewNetworkReader(
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("udp bound to: %s", connection.LocalAddr())
+	logger.Printf("udp bound to: %s", connection.LocalAddr())
 	rxErrCount := m.NewCounterMetric("dropped")
 
 	return &NetworkReader{
@@ -43,7 +45,7 @@ func NewNetworkReader(
 		rxMsgCount: m.NewCounterMetric("ingress"),
 		writer:     writer,
 		buffer: diodes.NewOneToOne(10000, gendiodes.AlertFunc(func(missed int) {
-			log.Printf("network reader dropped messages %d", missed)
+			logger.With(logging.Fields{"count": missed}).Warnf("network reader dropped messages %d", missed)
 			rxErrCount.Increment(uint64(missed))
 		})),
 	}, nil
@@ -54,7 +56,7 @@ func (nr *NetworkReader) StartReading() {
 	for {
 		readCount, _, err := nr.connection.ReadFrom(readBuffer)
 		if err != nil {
-			log.Printf("Error while reading: %s", err)
+			logger.Errorf("Error while reading: %s", err)
 			return
 		}
 		readData := make([]byte, readCount)
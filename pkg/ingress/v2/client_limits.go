@@ -0,0 +1,177 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ClientKey is what envelopes are rate limited by.
+type ClientKey int
+
+const (
+	// ClientByConnection limits each connection separately.
+	ClientByConnection ClientKey = iota
+
+	// ClientByIdentity limits every connection from a client with the same
+	// certificate common name together.
+	ClientByIdentity
+)
+
+// ParseClientKey returns the ClientKey with the given name: "connection" or
+// "identity".
+func ParseClientKey(name string) (ClientKey, error) {
+	switch name {
+	case "connection":
+		return ClientByConnection, nil
+	case "identity":
+		return ClientByIdentity, nil
+	default:
+		return 0, fmt.Errorf("unknown client key %q", name)
+	}
+}
+
+// ClientRateLimiter limits the rate at which each client may send
+// envelopes, protecting the agent from an emitter sending in a tight loop.
+// Calls that exceed the limit fail with codes.ResourceExhausted.
+type ClientRateLimiter struct {
+	limiter        *ratelimit.Limiter
+	key            ClientKey
+	rejectedMetric pulseemitter.CounterMetric
+}
+
+// NewClientRateLimiter returns a ClientRateLimiter that allows each client
+// the given limit in envelopes per second.
+func NewClientRateLimiter(limit ratelimit.Limit, key ClientKey, metricClient MetricClient) *ClientRateLimiter {
+	// metric-documentation-v2: (loggregator.metron.client_rate_limit_rejections)
+	// Number of envelopes rejected because their client exceeded its
+	// ingress rate limit.
+	rejectedMetric := metricClient.NewCounterMetric("client_rate_limit_rejections",
+		pulseemitter.WithVersion(2, 0),
+	)
+
+	return &ClientRateLimiter{
+		limiter:        ratelimit.NewLimiter(limit),
+		key:            key,
+		rejectedMetric: rejectedMetric,
+	}
+}
+
+// ServerOptions returns the interceptors that enforce the limit. They are
+// chained with any other interceptors.
+func (l *ClientRateLimiter) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(l.unary),
+		grpc.ChainStreamInterceptor(l.stream),
+	}
+}
+
+func (l *ClientRateLimiter) unary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if isHealthCheck(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	if err := l.allow(l.clientKey(ctx), req); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (l *ClientRateLimiter) stream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if isHealthCheck(info.FullMethod) {
+		return handler(srv, ss)
+	}
+
+	return handler(srv, &limitedStream{
+		ServerStream: ss,
+		limiter:      l,
+		key:          l.clientKey(ss.Context()),
+	})
+}
+
+// allow takes a token for each envelope in the message. A batch with more
+// envelopes than the client has tokens for is truncated to the envelopes it
+// has tokens for, so batches larger than the burst are still admitted in
+// part. An error is returned if none of the envelopes are admitted.
+func (l *ClientRateLimiter) allow(key string, m interface{}) error {
+	n := envelopeCount(m)
+	if n == 0 {
+		return nil
+	}
+
+	taken := l.limiter.TakeUpTo(key, n)
+	if taken == n {
+		return nil
+	}
+
+	l.rejectedMetric.Increment(uint64(n - taken))
+	logger.Debugf("ingress client %s exceeded its rate limit", key)
+
+	if taken > 0 {
+		b := m.(*loggregator_v2.EnvelopeBatch)
+		b.Batch = b.Batch[:taken]
+		return nil
+	}
+
+	return status.Errorf(codes.ResourceExhausted, "rate limit of %g envelopes/second exceeded", l.limiter.Default().Rate)
+}
+
+func (l *ClientRateLimiter) clientKey(ctx context.Context) string {
+	if l.key == ClientByIdentity {
+		if cert := peerCertificate(ctx); cert != nil {
+			return cert.Subject.CommonName
+		}
+		return UnknownPeer
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+
+	return UnknownPeer
+}
+
+// limitedStream fails RecvMsg once its client exceeds its limit, ending the
+// stream.
+type limitedStream struct {
+	grpc.ServerStream
+	limiter *ClientRateLimiter
+	key     string
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	return s.limiter.allow(s.key, m)
+}
+
+func envelopeCount(m interface{}) int {
+	switch v := m.(type) {
+	case *loggregator_v2.Envelope:
+		return 1
+	case *loggregator_v2.EnvelopeBatch:
+		return len(v.GetBatch())
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,134 @@
+package v2
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+)
+
+// DropCountsFunc returns the running totals of envelopes received and
+// dropped by the agent.
+type DropCountsFunc func() (received, dropped uint64)
+
+// DropWatchdog checks the share of received envelopes the agent dropped
+// over each window. When it rises above a threshold, and again when it
+// falls back below it, the watchdog writes an event envelope and logs a
+// line, giving alerting a clear trigger without needing rate math on the
+// dropped and ingress counters.
+type DropWatchdog struct {
+	setter    DataSetter
+	sourceID  string
+	threshold float64
+	window    time.Duration
+	counts    DropCountsFunc
+
+	received uint64
+	dropped  uint64
+	alerting bool
+
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+// NewDropWatchdog returns a DropWatchdog that alerts when more than
+// threshold percent of the envelopes received in a window are dropped.
+func NewDropWatchdog(
+	s DataSetter,
+	sourceID string,
+	threshold float64,
+	window time.Duration,
+	counts DropCountsFunc,
+) *DropWatchdog {
+	w := &DropWatchdog{
+		setter:    s,
+		sourceID:  sourceID,
+		threshold: threshold,
+		window:    window,
+		counts:    counts,
+	}
+	w.received, w.dropped = counts()
+
+	return w
+}
+
+// Start checks the drop rate every window. It blocks until Stop is called.
+func (w *DropWatchdog) Start() {
+	done := make(chan struct{})
+	w.mu.Lock()
+	w.done = done
+	w.mu.Unlock()
+
+	t := time.NewTicker(w.window)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			w.check()
+		}
+	}
+}
+
+// Stop causes Start to return.
+func (w *DropWatchdog) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done != nil {
+		close(w.done)
+		w.done = nil
+	}
+}
+
+func (w *DropWatchdog) check() {
+	received, dropped := w.counts()
+	receivedDelta := received - w.received
+	droppedDelta := dropped - w.dropped
+	w.received, w.dropped = received, dropped
+
+	var rate float64
+	if receivedDelta > 0 {
+		rate = 100 * float64(droppedDelta) / float64(receivedDelta)
+	} else if droppedDelta > 0 {
+		rate = 100
+	}
+
+	exceeded := rate > w.threshold
+	if exceeded == w.alerting {
+		return
+	}
+	w.alerting = exceeded
+
+	title := "drop rate recovered"
+	if exceeded {
+		title = "drop rate exceeded threshold"
+	}
+	body := fmt.Sprintf("dropped %d of %d envelopes (%.2f%%) in the last %s, threshold is %g%%",
+		droppedDelta, receivedDelta, rate, w.window, w.threshold)
+
+	l := logger.With(logging.Fields{
+		"dropped":  droppedDelta,
+		"received": receivedDelta,
+	})
+	if exceeded {
+		l.Warnf("%s: %s", title, body)
+	} else {
+		l.Printf("%s: %s", title, body)
+	}
+
+	w.setter.Set(&loggregator_v2.Envelope{
+		Timestamp: time.Now().UnixNano(),
+		SourceId:  w.sourceID,
+		Message: &loggregator_v2.Envelope_Event{
+			Event: &loggregator_v2.Event{
+				Title: title,
+				Body:  body,
+			},
+		},
+	})
+}
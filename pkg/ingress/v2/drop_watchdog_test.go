@@ -0,0 +1,63 @@
+package v2_test
+
+import (
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DropWatchdog", func() {
+	var (
+		spySetter *SpySetter
+		received  uint64
+		dropped   uint64
+		w         *ingress.DropWatchdog
+	)
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		atomic.StoreUint64(&received, 0)
+		atomic.StoreUint64(&dropped, 0)
+		w = ingress.NewDropWatchdog(spySetter, "metron", 10, 10*time.Millisecond, func() (uint64, uint64) {
+			return atomic.LoadUint64(&received), atomic.LoadUint64(&dropped)
+		})
+		go w.Start()
+	})
+
+	AfterEach(func() {
+		w.Stop()
+	})
+
+	It("writes an event when the drop rate exceeds the threshold", func() {
+		atomic.AddUint64(&received, 100)
+		atomic.AddUint64(&dropped, 50)
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("metron"))
+		Expect(e.GetEvent().GetTitle()).To(Equal("drop rate exceeded threshold"))
+		Expect(e.GetEvent().GetBody()).To(ContainSubstring("dropped 50 of 100 envelopes (50.00%)"))
+	})
+
+	It("writes an event when the drop rate recovers", func() {
+		atomic.AddUint64(&received, 100)
+		atomic.AddUint64(&dropped, 50)
+		Eventually(spySetter.envelopes).Should(Receive())
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.GetEvent().GetTitle()).To(Equal("drop rate recovered"))
+	})
+
+	It("does not write events while the drop rate is below the threshold", func() {
+		atomic.AddUint64(&received, 100)
+		atomic.AddUint64(&dropped, 5)
+
+		Consistently(spySetter.envelopes).ShouldNot(Receive())
+	})
+})
@@ -0,0 +1,433 @@
+package v2
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// maxLineLength is the longest line a FileSource writes as a single
+// envelope. Longer lines are split.
+const maxLineLength = 64 * 1024
+
+// FileSource is a Source that tails the files matching a set of glob
+// patterns and writes each line as a v2 log envelope tagged with the file's
+// path and the host. Files are polled for new lines, rotated and truncated
+// files are followed and the offset reached in each file is checkpointed so
+// that lines are not repeated across restarts.
+//
+// The source ID of each envelope is the name of the directory containing
+// the file, which is the job name for files in /var/vcap/sys/log/<job>.
+type FileSource struct {
+	globs          []string
+	checkpointPath string
+	setter         DataSetter
+	interval       time.Duration
+	host           string
+
+	mu          sync.Mutex
+	done        chan struct{}
+	stopPending bool
+
+	// files and checkpoint are only accessed by the goroutine running
+	// Start.
+	files      map[string]*tailedFile
+	checkpoint []byte
+}
+
+// FileSourceOption configures a FileSource.
+type FileSourceOption func(*FileSource)
+
+// WithFilePollInterval sets how often files are checked for new lines. The
+// default is 1 second.
+func WithFilePollInterval(d time.Duration) FileSourceOption {
+	return func(s *FileSource) {
+		s.interval = d
+	}
+}
+
+// NewFileSource returns a FileSource that tails the files matching globs
+// and checkpoints offsets to the given path.
+func NewFileSource(globs []string, checkpointPath string, setter DataSetter, opts ...FileSourceOption) *FileSource {
+	host, _ := os.Hostname()
+
+	s := &FileSource{
+		globs:          globs,
+		checkpointPath: checkpointPath,
+		setter:         setter,
+		interval:       time.Second,
+		host:           host,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// Start tails the files until Stop is called. Files found when the source
+// starts are read from their checkpointed offset, or from their end if they
+// have none. Files created later are read from the beginning.
+func (s *FileSource) Start() error {
+	done := make(chan struct{})
+	s.mu.Lock()
+	if s.stopPending {
+		s.stopPending = false
+		s.mu.Unlock()
+		return nil
+	}
+	s.done = done
+	s.mu.Unlock()
+
+	s.files = make(map[string]*tailedFile)
+
+	checkpoints := s.loadCheckpoints()
+	s.poll(checkpoints, true)
+
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			s.closeFiles()
+			return nil
+		case <-t.C:
+			s.poll(nil, false)
+		}
+	}
+}
+
+// Stop causes Start to return once the offsets have been checkpointed. If
+// the source has not yet begun tailing, Start returns without doing so.
+func (s *FileSource) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done == nil {
+		s.stopPending = true
+		return
+	}
+
+	close(s.done)
+	s.done = nil
+}
+
+func (s *FileSource) poll(checkpoints map[string]fileCheckpoint, initial bool) {
+	matched := make(map[string]bool)
+	for _, g := range s.globs {
+		paths, err := filepath.Glob(g)
+		if err != nil {
+			logger.Warnf("invalid file glob %q: %s", g, err)
+			continue
+		}
+
+		for _, p := range paths {
+			matched[p] = true
+		}
+	}
+
+	infos := make(map[string]os.FileInfo, len(matched))
+	paths := make([]string, 0, len(matched))
+	for p := range matched {
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		infos[p] = info
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	// Follow each tailed file to wherever it now is, so a file rotated to a
+	// path that still matches, e.g. job.log.1, carries on from its offset
+	// rather than being read again. Files that no longer match are finished.
+	files := make(map[string]*tailedFile, len(s.files))
+	for p, f := range s.files {
+		q, ok := findFile(infos, paths, files, f.info, p)
+		if !ok {
+			f.read(s.emit)
+			f.flush(s.emit)
+			f.close()
+			continue
+		}
+
+		f.path = q
+		files[q] = f
+	}
+	s.files = files
+
+	for _, p := range paths {
+		info := infos[p]
+		f, ok := s.files[p]
+		if !ok {
+			var offset int64
+			if initial {
+				offset = info.Size()
+				if c, ok := findCheckpoint(checkpoints, p, info); ok {
+					offset = c.Offset
+				}
+			}
+
+			var err error
+			f, err = openTailedFile(p, info, offset)
+			if err != nil {
+				logger.Warnf("failed to open %s: %s", p, err)
+				continue
+			}
+
+			s.files[p] = f
+		}
+
+		if info.Size() < f.offset {
+			// The file was truncated.
+			f.reset()
+		}
+
+		f.read(s.emit)
+	}
+
+	s.saveCheckpoints()
+}
+
+// findFile returns the path the file is at, preferring the path it was last
+// seen at. Paths already taken by another tailed file are skipped.
+func findFile(
+	infos map[string]os.FileInfo,
+	paths []string,
+	taken map[string]*tailedFile,
+	file os.FileInfo,
+	last string,
+) (string, bool) {
+	if info, ok := infos[last]; ok && os.SameFile(file, info) {
+		return last, true
+	}
+
+	for _, p := range paths {
+		if _, ok := taken[p]; ok {
+			continue
+		}
+
+		if os.SameFile(file, infos[p]) {
+			return p, true
+		}
+	}
+
+	return "", false
+}
+
+// findCheckpoint returns the checkpoint of the file at the path, or the
+// checkpoint of the same file at another path if it was rotated while the
+// source was stopped.
+func findCheckpoint(checkpoints map[string]fileCheckpoint, path string, info os.FileInfo) (fileCheckpoint, bool) {
+	ino := inode(info)
+	if c, ok := checkpoints[path]; ok && c.Inode == ino {
+		return c, true
+	}
+
+	if ino == 0 {
+		return fileCheckpoint{}, false
+	}
+
+	for _, c := range checkpoints {
+		if c.Inode == ino {
+			return c, true
+		}
+	}
+
+	return fileCheckpoint{}, false
+}
+
+func (s *FileSource) emit(path string, line []byte) {
+	payload := make([]byte, len(line))
+	copy(payload, line)
+
+	s.setter.Set(&loggregator_v2.Envelope{
+		Timestamp: time.Now().UnixNano(),
+		SourceId:  filepath.Base(filepath.Dir(path)),
+		Tags: map[string]string{
+			"path": path,
+			"host": s.host,
+		},
+		Message: &loggregator_v2.Envelope_Log{
+			Log: &loggregator_v2.Log{
+				Payload: payload,
+				Type:    loggregator_v2.Log_OUT,
+			},
+		},
+	})
+}
+
+func (s *FileSource) closeFiles() {
+	s.saveCheckpoints()
+
+	for _, f := range s.files {
+		f.close()
+	}
+}
+
+// fileCheckpoint is the offset reached in a file. The inode identifies the
+// file so that a checkpoint is not applied to a file that replaced it.
+type fileCheckpoint struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+func (s *FileSource) loadCheckpoints() map[string]fileCheckpoint {
+	checkpoints := make(map[string]fileCheckpoint)
+
+	data, err := ioutil.ReadFile(s.checkpointPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("failed to read file checkpoints: %s", err)
+		}
+		return checkpoints
+	}
+
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		logger.Warnf("failed to parse file checkpoints: %s", err)
+	}
+
+	return checkpoints
+}
+
+// saveCheckpoints atomically writes the offset of every tailed file to the
+// checkpoint path.
+func (s *FileSource) saveCheckpoints() {
+	checkpoints := make(map[string]fileCheckpoint, len(s.files))
+	for p, f := range s.files {
+		checkpoints[p] = fileCheckpoint{Inode: inode(f.info), Offset: f.offset}
+	}
+
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		logger.Warnf("failed to write file checkpoints: %s", err)
+		return
+	}
+
+	if bytes.Equal(data, s.checkpoint) {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.checkpointPath), ".file-checkpoints")
+	if err != nil {
+		logger.Warnf("failed to write file checkpoints: %s", err)
+		return
+	}
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.checkpointPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logger.Warnf("failed to write file checkpoints: %s", err)
+		return
+	}
+
+	s.checkpoint = data
+}
+
+// tailedFile is an open file being tailed. The offset is that of the end of
+// the last complete line read.
+type tailedFile struct {
+	path    string
+	info    os.FileInfo
+	file    *os.File
+	offset  int64
+	partial []byte
+}
+
+func openTailedFile(path string, info os.FileInfo, offset int64) (*tailedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &tailedFile{
+		path:   path,
+		info:   info,
+		file:   f,
+		offset: offset,
+	}, nil
+}
+
+// read emits every complete line written since the last read.
+func (f *tailedFile) read(emit func(path string, line []byte)) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.file.Read(buf)
+		if n > 0 {
+			f.partial = append(f.partial, buf[:n]...)
+			f.emitLines(emit)
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				logger.Warnf("failed to read %s: %s", f.path, err)
+			}
+			return
+		}
+	}
+}
+
+func (f *tailedFile) emitLines(emit func(path string, line []byte)) {
+	for {
+		i := bytes.IndexByte(f.partial, '\n')
+		if i < 0 {
+			if len(f.partial) < maxLineLength {
+				return
+			}
+			i = maxLineLength
+			emit(f.path, f.partial[:i])
+			f.offset += int64(i)
+			f.partial = f.partial[i:]
+			continue
+		}
+
+		line := bytes.TrimSuffix(f.partial[:i], []byte("\r"))
+		if len(line) > 0 {
+			emit(f.path, line)
+		}
+		f.offset += int64(i + 1)
+		f.partial = f.partial[i+1:]
+	}
+}
+
+// flush emits a final line that was not terminated by a newline.
+func (f *tailedFile) flush(emit func(path string, line []byte)) {
+	if len(f.partial) > 0 {
+		emit(f.path, f.partial)
+		f.offset += int64(len(f.partial))
+		f.partial = nil
+	}
+}
+
+// reset rereads a truncated file from its beginning.
+func (f *tailedFile) reset() {
+	f.file.Seek(0, io.SeekStart)
+	f.offset = 0
+	f.partial = nil
+}
+
+func (f *tailedFile) close() {
+	f.file.Close()
+}
@@ -0,0 +1,197 @@
+package v2_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileSource", func() {
+	var (
+		dir            string
+		logDir         string
+		checkpointPath string
+		spySetter      *SpySetter
+		s              *ingress.FileSource
+	)
+
+	appendFile := func(path, data string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		_, err = f.WriteString(data)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	receivePayload := func() string {
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		return string(e.GetLog().GetPayload())
+	}
+
+	startSource := func() {
+		s = ingress.NewFileSource(
+			[]string{filepath.Join(dir, "*", "*.log")},
+			checkpointPath,
+			spySetter,
+			ingress.WithFilePollInterval(10*time.Millisecond),
+		)
+		go s.Start()
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "files")
+		Expect(err).ToNot(HaveOccurred())
+
+		logDir = filepath.Join(dir, "some-job")
+		Expect(os.Mkdir(logDir, 0755)).To(Succeed())
+		checkpointPath = filepath.Join(dir, "checkpoints.json")
+		spySetter = NewSpySetter()
+	})
+
+	AfterEach(func() {
+		s.Stop()
+		os.RemoveAll(dir)
+	})
+
+	It("writes each new line as a log envelope", func() {
+		path := filepath.Join(logDir, "job.log")
+		appendFile(path, "existing line\n")
+		startSource()
+		Eventually(checkpointPath).Should(BeAnExistingFile())
+
+		appendFile(path, "first line\nsecond ")
+		appendFile(path, "line\n")
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("some-job"))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("first line")))
+		Expect(e.GetLog().GetType()).To(Equal(loggregator_v2.Log_OUT))
+		Expect(e.Tags).To(HaveKeyWithValue("path", path))
+		Expect(e.Tags).To(HaveKey("host"))
+
+		Expect(receivePayload()).To(Equal("second line"))
+		Consistently(spySetter.envelopes).ShouldNot(Receive())
+	})
+
+	It("reads files created after starting from the beginning", func() {
+		startSource()
+		Eventually(checkpointPath).Should(BeAnExistingFile())
+
+		appendFile(filepath.Join(logDir, "new.log"), "hello\n")
+		Expect(receivePayload()).To(Equal("hello"))
+	})
+
+	It("follows rotated files", func() {
+		path := filepath.Join(logDir, "job.log")
+		appendFile(path, "")
+		startSource()
+		Eventually(checkpointPath).Should(BeAnExistingFile())
+
+		appendFile(path, "before rotation\n")
+		Expect(receivePayload()).To(Equal("before rotation"))
+
+		appendFile(path, "unread before rotation\n")
+		Expect(os.Rename(path, filepath.Join(logDir, "job.log.1"))).To(Succeed())
+		appendFile(path, "after rotation\n")
+
+		Expect(receivePayload()).To(Equal("unread before rotation"))
+		Expect(receivePayload()).To(Equal("after rotation"))
+	})
+
+	It("does not reread rotated files that still match", func() {
+		s = ingress.NewFileSource(
+			[]string{filepath.Join(dir, "*", "*.log*")},
+			checkpointPath,
+			spySetter,
+			ingress.WithFilePollInterval(10*time.Millisecond),
+		)
+		go s.Start()
+		Eventually(checkpointPath).Should(BeAnExistingFile())
+
+		path := filepath.Join(logDir, "job.log")
+		appendFile(path, "before rotation\n")
+		Expect(receivePayload()).To(Equal("before rotation"))
+
+		rotated := filepath.Join(logDir, "job.log.1")
+		Expect(os.Rename(path, rotated)).To(Succeed())
+		appendFile(rotated, "written after rotation\n")
+		appendFile(path, "after rotation\n")
+
+		Expect([]string{receivePayload(), receivePayload()}).To(ConsistOf(
+			"written after rotation",
+			"after rotation",
+		))
+		Consistently(spySetter.envelopes).ShouldNot(Receive())
+	})
+
+	It("follows truncated files", func() {
+		path := filepath.Join(logDir, "job.log")
+		appendFile(path, "")
+		startSource()
+		Eventually(checkpointPath).Should(BeAnExistingFile())
+
+		appendFile(path, "a long line before truncation\n")
+		Expect(receivePayload()).To(Equal("a long line before truncation"))
+
+		Expect(os.Truncate(path, 0)).To(Succeed())
+		Eventually(func() int64 {
+			return checkpointOffset(checkpointPath, path)
+		}).Should(Equal(int64(0)))
+
+		appendFile(path, "short\n")
+		Expect(receivePayload()).To(Equal("short"))
+	})
+
+	It("resumes from the checkpointed offset", func() {
+		path := filepath.Join(logDir, "job.log")
+		appendFile(path, "")
+		startSource()
+		Eventually(checkpointPath).Should(BeAnExistingFile())
+
+		appendFile(path, "first\n")
+		Expect(receivePayload()).To(Equal("first"))
+		Eventually(func() int64 {
+			return checkpointOffset(checkpointPath, path)
+		}).Should(Equal(int64(len("first\n"))))
+		s.Stop()
+
+		appendFile(path, "while stopped\n")
+		startSource()
+
+		Expect(receivePayload()).To(Equal("while stopped"))
+		Consistently(spySetter.envelopes).ShouldNot(Receive())
+	})
+})
+
+func checkpointOffset(checkpointPath, path string) int64 {
+	data, err := ioutil.ReadFile(checkpointPath)
+	if err != nil {
+		return -1
+	}
+
+	var checkpoints map[string]struct {
+		Offset int64 `json:"offset"`
+	}
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return -1
+	}
+
+	c, ok := checkpoints[path]
+	if !ok {
+		return -1
+	}
+
+	return c.Offset
+}
@@ -0,0 +1,8 @@
+package v2
+
+import "os"
+
+// inode is not available on Windows. Checkpoints are matched by path alone.
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
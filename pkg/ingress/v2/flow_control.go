@@ -0,0 +1,189 @@
+package v2
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// FlowControlCreditsHeader is the response header the number of envelopes
+// a client may send on a stream before it is asked to slow down is
+// advertised in.
+const FlowControlCreditsHeader = "loggregator-ingress-credits"
+
+// flowControlHighWater is the fraction of the buffer that must be in use
+// for streams to wait before they are granted more credits.
+const flowControlHighWater = 0.8
+
+// BufferLevel reports how full a buffer is. Depth and Size must both be
+// counted in envelopes.
+type BufferLevel interface {
+	Depth() int
+	Size() int
+}
+
+// FlowController applies backpressure to ingress streams while the buffer
+// is nearly full. Each stream is granted a window of credits, one per
+// envelope, that is advertised to the client when the stream opens. Once a
+// stream has used its credits the next message is not read until the
+// buffer has drained below its high water mark, or the maximum wait has
+// passed, and the stream is then granted another window. While a message
+// is not read gRPC flow control blocks the client's sends, so that well
+// behaved emitters slow down instead of the buffer dropping envelopes.
+type FlowController struct {
+	buffer   BufferLevel
+	window   int
+	maxWait  time.Duration
+	interval time.Duration
+
+	waitsMetric    pulseemitter.CounterMetric
+	timeoutsMetric pulseemitter.CounterMetric
+}
+
+// FlowControlOption configures a FlowController.
+type FlowControlOption func(*FlowController)
+
+// WithFlowControlMaxWait sets the longest a stream waits for the buffer to
+// drain before its next message is read regardless. The default is one
+// second.
+func WithFlowControlMaxWait(d time.Duration) FlowControlOption {
+	return func(c *FlowController) {
+		c.maxWait = d
+	}
+}
+
+// WithFlowControlInterval sets how often a waiting stream checks the
+// buffer. The default is 10 milliseconds.
+func WithFlowControlInterval(d time.Duration) FlowControlOption {
+	return func(c *FlowController) {
+		c.interval = d
+	}
+}
+
+// NewFlowController returns a FlowController that grants streams window
+// credits at a time while the buffer has room.
+func NewFlowController(buffer BufferLevel, window int, metricClient MetricClient, opts ...FlowControlOption) *FlowController {
+	// metric-documentation-v2: (loggregator.metron.flow_control_waits)
+	// Number of times an ingress stream waited for the buffer to drain
+	// before it was granted more credits
+	waitsMetric := metricClient.NewCounterMetric("flow_control_waits",
+		pulseemitter.WithVersion(2, 0),
+	)
+
+	// metric-documentation-v2: (loggregator.metron.flow_control_timeouts)
+	// Number of times an ingress stream was granted more credits because it
+	// waited the maximum time for the buffer to drain
+	timeoutsMetric := metricClient.NewCounterMetric("flow_control_timeouts",
+		pulseemitter.WithVersion(2, 0),
+	)
+
+	c := &FlowController{
+		buffer:         buffer,
+		window:         window,
+		maxWait:        time.Second,
+		interval:       10 * time.Millisecond,
+		waitsMetric:    waitsMetric,
+		timeoutsMetric: timeoutsMetric,
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// ServerOptions returns the interceptor that applies flow control to
+// streams. It is chained with any other interceptors.
+func (c *FlowController) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainStreamInterceptor(c.stream),
+	}
+}
+
+func (c *FlowController) stream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if isHealthCheck(info.FullMethod) || !info.IsClientStream {
+		return handler(srv, ss)
+	}
+
+	// The header is sent straight away as client streams otherwise only
+	// receive it when they are closed.
+	md := metadata.Pairs(FlowControlCreditsHeader, strconv.Itoa(c.window))
+	if err := ss.SendHeader(md); err != nil {
+		logger.Debugf("failed to advertise flow control credits: %s", err)
+	}
+
+	return handler(srv, &flowControlledStream{
+		ServerStream: ss,
+		controller:   c,
+		credits:      c.window,
+	})
+}
+
+// wait returns once the buffer is below its high water mark, the maximum
+// wait has passed or the context is done.
+func (c *FlowController) wait(ctx context.Context) {
+	if !c.full() {
+		return
+	}
+	c.waitsMetric.Increment(1)
+
+	timeout := time.NewTimer(c.maxWait)
+	defer timeout.Stop()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout.C:
+			c.timeoutsMetric.Increment(1)
+			return
+		case <-ticker.C:
+			if !c.full() {
+				return
+			}
+		}
+	}
+}
+
+func (c *FlowController) full() bool {
+	size := c.buffer.Size()
+	if size <= 0 {
+		return false
+	}
+
+	return float64(c.buffer.Depth()) >= flowControlHighWater*float64(size)
+}
+
+// flowControlledStream waits for more credits before reading a message
+// once it has used its window.
+type flowControlledStream struct {
+	grpc.ServerStream
+	controller *FlowController
+	credits    int
+}
+
+func (s *flowControlledStream) RecvMsg(m interface{}) error {
+	if s.credits <= 0 {
+		s.controller.wait(s.Context())
+		s.credits = s.controller.window
+	}
+
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	s.credits -= envelopeCount(m)
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package v2_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"google.golang.org/grpc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FlowController", func() {
+	var (
+		spySetter    *SpySetter
+		metricClient *testhelper.SpyMetricClient
+		buffer       *spyBufferLevel
+		server       *grpc.Server
+		conn         *grpc.ClientConn
+		client       loggregator_v2.IngressClient
+	)
+
+	batch := func() *loggregator_v2.EnvelopeBatch {
+		return &loggregator_v2.EnvelopeBatch{
+			Batch: []*loggregator_v2.Envelope{{SourceId: "some-id"}},
+		}
+	}
+
+	start := func(opts ...ingress.FlowControlOption) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		opts = append(opts, ingress.WithFlowControlInterval(time.Millisecond))
+		c := ingress.NewFlowController(buffer, 1, metricClient, opts...)
+		server = grpc.NewServer(c.ServerOptions()...)
+		rx := ingress.NewReceiver(spySetter, metricClient, newSpyHealthEndpointClient())
+		loggregator_v2.RegisterIngressServer(server, rx)
+		go server.Serve(lis)
+
+		conn, err = grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+		Expect(err).ToNot(HaveOccurred())
+		client = loggregator_v2.NewIngressClient(conn)
+	}
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		metricClient = testhelper.NewMetricClient()
+		buffer = &spyBufferLevel{size: 10}
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		server.Stop()
+	})
+
+	It("advertises the stream's credits", func() {
+		start()
+
+		s, err := client.BatchSender(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		md, err := s.Header()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(md.Get(ingress.FlowControlCreditsHeader)).To(Equal([]string{"1"}))
+	})
+
+	It("does not read past a stream's credits until the buffer drains", func() {
+		start(ingress.WithFlowControlMaxWait(time.Minute))
+		buffer.setDepth(8)
+
+		s, err := client.BatchSender(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Send(batch())).To(Succeed())
+		Expect(s.Send(batch())).To(Succeed())
+
+		Eventually(spySetter.envelopes).Should(Receive())
+		Consistently(spySetter.envelopes, 100*time.Millisecond).ShouldNot(Receive())
+
+		buffer.setDepth(7)
+		Eventually(spySetter.envelopes).Should(Receive())
+		Expect(metricClient.GetMetric("flow_control_waits").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("reads the next message once the maximum wait has passed", func() {
+		start(ingress.WithFlowControlMaxWait(50 * time.Millisecond))
+		buffer.setDepth(10)
+
+		s, err := client.BatchSender(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Send(batch())).To(Succeed())
+		Expect(s.Send(batch())).To(Succeed())
+		_, err = s.CloseAndRecv()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(spySetter.envelopes).To(HaveLen(2))
+
+		// Reading the end of the stream waits as well.
+		Expect(metricClient.GetMetric("flow_control_timeouts").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("does not apply to unary calls", func() {
+		start(ingress.WithFlowControlMaxWait(time.Minute))
+		buffer.setDepth(10)
+
+		for i := 0; i < 3; i++ {
+			_, err := client.Send(context.Background(), batch())
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		Expect(spySetter.envelopes).To(HaveLen(3))
+	})
+})
+
+type spyBufferLevel struct {
+	depth int64
+	size  int
+}
+
+func (s *spyBufferLevel) setDepth(d int64) {
+	atomic.StoreInt64(&s.depth, d)
+}
+
+func (s *spyBufferLevel) Depth() int {
+	return int(atomic.LoadInt64(&s.depth))
+}
+
+func (s *spyBufferLevel) Size() int {
+	return s.size
+}
@@ -0,0 +1,253 @@
+package v2
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// maxHTTPBatchBytes is the largest request body the HTTPSource accepts.
+const maxHTTPBatchBytes = 10 * 1024 * 1024
+
+// HTTPSource is a Source that accepts batches of envelopes in a simplified
+// JSON schema POSTed to /v2/envelopes over HTTPS. It is intended for
+// emitters that cannot easily use the gRPC API.
+type HTTPSource struct {
+	addr      string
+	tlsConfig *tls.Config
+	setter    DataSetter
+
+	mu     sync.Mutex
+	server *http.Server
+	lis    net.Listener
+
+	// stopPending is set when Stop is called before Start has begun
+	// serving, so that Start returns rather than serving.
+	stopPending bool
+}
+
+// NewHTTPSource returns an HTTPSource that listens on the given address
+// with the given TLS config once started.
+func NewHTTPSource(addr string, tlsConfig *tls.Config, s DataSetter) *HTTPSource {
+	return &HTTPSource{
+		addr:      addr,
+		tlsConfig: tlsConfig,
+		setter:    s,
+	}
+}
+
+// Start listens on the source's address and serves requests until Stop is
+// called. It returns an error if the source can not listen or fails to
+// serve.
+func (s *HTTPSource) Start() error {
+	lis, err := tls.Listen("tcp", s.addr, s.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %s", err)
+	}
+	logger.Printf("http bound to: %s", lis.Addr())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/envelopes", s.handleEnvelopes)
+
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	s.mu.Lock()
+	if s.stopPending {
+		s.stopPending = false
+		s.mu.Unlock()
+		lis.Close()
+		return nil
+	}
+	s.server = server
+	s.lis = lis
+	s.mu.Unlock()
+
+	if err := server.Serve(lis); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve: %s", err)
+	}
+
+	return nil
+}
+
+// Addr returns the address the source is listening on or nil if it is not
+// listening.
+func (s *HTTPSource) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lis == nil {
+		return nil
+	}
+
+	return s.lis.Addr()
+}
+
+// Stop closes the listener and all open connections and causes Start to
+// return. If the source has not yet begun serving, Start returns without
+// serving.
+func (s *HTTPSource) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.server == nil {
+		s.stopPending = true
+		return
+	}
+
+	s.server.Close()
+	s.server = nil
+	s.lis = nil
+}
+
+func (s *HTTPSource) handleEnvelopes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch jsonBatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHTTPBatchBytes)).Decode(&batch); err != nil {
+		http.Error(w, fmt.Sprintf("invalid batch: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	envelopes := make([]*loggregator_v2.Envelope, 0, len(batch.Batch))
+	for i, je := range batch.Batch {
+		e, err := je.toV2()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid envelope %d: %s", i, err), http.StatusBadRequest)
+			return
+		}
+		envelopes = append(envelopes, e)
+	}
+
+	for _, e := range envelopes {
+		s.setter.Set(e)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jsonBatch is the body of a request to /v2/envelopes.
+type jsonBatch struct {
+	Batch []jsonEnvelope `json:"batch"`
+}
+
+// jsonEnvelope is the simplified JSON form of a v2 envelope. Exactly one of
+// Log, Counter or Gauge must be set. The timestamp is in nanoseconds since
+// the epoch and defaults to the time the envelope is received.
+type jsonEnvelope struct {
+	SourceID   string            `json:"source_id"`
+	InstanceID string            `json:"instance_id"`
+	Timestamp  int64             `json:"timestamp"`
+	Tags       map[string]string `json:"tags"`
+
+	Log     *jsonLog     `json:"log"`
+	Counter *jsonCounter `json:"counter"`
+	Gauge   *jsonGauge   `json:"gauge"`
+}
+
+type jsonLog struct {
+	Payload string `json:"payload"`
+	Type    string `json:"type"`
+}
+
+type jsonCounter struct {
+	Name  string `json:"name"`
+	Delta uint64 `json:"delta"`
+	Total uint64 `json:"total"`
+}
+
+type jsonGauge struct {
+	Metrics map[string]jsonGaugeValue `json:"metrics"`
+}
+
+type jsonGaugeValue struct {
+	Unit  string  `json:"unit"`
+	Value float64 `json:"value"`
+}
+
+func (je jsonEnvelope) toV2() (*loggregator_v2.Envelope, error) {
+	if je.SourceID == "" {
+		return nil, errors.New("source_id is required")
+	}
+
+	e := &loggregator_v2.Envelope{
+		SourceId:   je.SourceID,
+		InstanceId: je.InstanceID,
+		Timestamp:  je.Timestamp,
+		Tags:       je.Tags,
+	}
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().UnixNano()
+	}
+
+	var messages int
+	if je.Log != nil {
+		messages++
+
+		logType := loggregator_v2.Log_OUT
+		switch je.Log.Type {
+		case "", "OUT":
+		case "ERR":
+			logType = loggregator_v2.Log_ERR
+		default:
+			return nil, fmt.Errorf("unknown log type %q", je.Log.Type)
+		}
+
+		e.Message = &loggregator_v2.Envelope_Log{
+			Log: &loggregator_v2.Log{
+				Payload: []byte(je.Log.Payload),
+				Type:    logType,
+			},
+		}
+	}
+
+	if je.Counter != nil {
+		messages++
+		if je.Counter.Name == "" {
+			return nil, errors.New("counter name is required")
+		}
+
+		e.Message = &loggregator_v2.Envelope_Counter{
+			Counter: &loggregator_v2.Counter{
+				Name:  je.Counter.Name,
+				Delta: je.Counter.Delta,
+				Total: je.Counter.Total,
+			},
+		}
+	}
+
+	if je.Gauge != nil {
+		messages++
+		if len(je.Gauge.Metrics) == 0 {
+			return nil, errors.New("gauge requires at least one metric")
+		}
+
+		metrics := make(map[string]*loggregator_v2.GaugeValue, len(je.Gauge.Metrics))
+		for name, v := range je.Gauge.Metrics {
+			metrics[name] = &loggregator_v2.GaugeValue{Unit: v.Unit, Value: v.Value}
+		}
+
+		e.Message = &loggregator_v2.Envelope_Gauge{
+			Gauge: &loggregator_v2.Gauge{Metrics: metrics},
+		}
+	}
+
+	if messages != 1 {
+		return nil, errors.New("exactly one of log, counter or gauge is required")
+	}
+
+	return e, nil
+}
@@ -0,0 +1,116 @@
+package v2_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPSource", func() {
+	var (
+		spySetter *SpySetter
+		s         *ingress.HTTPSource
+		client    *http.Client
+	)
+
+	BeforeEach(func() {
+		tlsConfig, err := plumbing.NewServerTLSConfig(
+			testhelper.Cert("localhost.crt"),
+			testhelper.Cert("localhost.key"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		spySetter = NewSpySetter()
+		s = ingress.NewHTTPSource("127.0.0.1:0", tlsConfig, spySetter)
+		go s.Start()
+		Eventually(s.Addr).ShouldNot(BeNil())
+
+		client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		s.Stop()
+	})
+
+	post := func(body string) int {
+		resp, err := client.Post(
+			fmt.Sprintf("https://%s/v2/envelopes", s.Addr()),
+			"application/json",
+			strings.NewReader(body),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	It("writes each envelope in the batch", func() {
+		Expect(post(`{"batch": [
+			{"source_id": "some-id", "instance_id": "1", "timestamp": 99, "tags": {"a": "b"}, "log": {"payload": "hello", "type": "ERR"}},
+			{"source_id": "some-id", "counter": {"name": "requests", "delta": 2}},
+			{"source_id": "some-id", "gauge": {"metrics": {"cpu": {"unit": "percentage", "value": 0.5}}}}
+		]}`)).To(Equal(http.StatusNoContent))
+
+		var e *loggregator_v2.Envelope
+		Expect(spySetter.envelopes).To(Receive(&e))
+		Expect(e.SourceId).To(Equal("some-id"))
+		Expect(e.InstanceId).To(Equal("1"))
+		Expect(e.Timestamp).To(Equal(int64(99)))
+		Expect(e.Tags).To(Equal(map[string]string{"a": "b"}))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("hello")))
+		Expect(e.GetLog().GetType()).To(Equal(loggregator_v2.Log_ERR))
+
+		Expect(spySetter.envelopes).To(Receive(&e))
+		Expect(e.Timestamp).ToNot(BeZero())
+		Expect(e.GetCounter().GetName()).To(Equal("requests"))
+		Expect(e.GetCounter().GetDelta()).To(Equal(uint64(2)))
+
+		Expect(spySetter.envelopes).To(Receive(&e))
+		Expect(e.GetGauge().GetMetrics()["cpu"].GetUnit()).To(Equal("percentage"))
+		Expect(e.GetGauge().GetMetrics()["cpu"].GetValue()).To(Equal(0.5))
+	})
+
+	It("rejects the whole batch when any envelope is invalid", func() {
+		Expect(post(`{"batch": [
+			{"source_id": "some-id", "log": {"payload": "hello"}},
+			{"source_id": "some-id"}
+		]}`)).To(Equal(http.StatusBadRequest))
+
+		Expect(spySetter.envelopes).ToNot(Receive())
+	})
+
+	It("rejects envelopes without a source id", func() {
+		Expect(post(`{"batch": [{"log": {"payload": "hello"}}]}`)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects envelopes with more than one message", func() {
+		Expect(post(`{"batch": [
+			{"source_id": "some-id", "log": {"payload": "hello"}, "counter": {"name": "requests"}}
+		]}`)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects invalid JSON", func() {
+		Expect(post(`{`)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("only accepts POST", func() {
+		resp, err := client.Get(fmt.Sprintf("https://%s/v2/envelopes", s.Addr()))
+		Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+
+		Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,233 @@
+package v2
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnauthorizedIdentityTag is the tag set to the identity of an unexpected
+// client on its envelopes when the allow-list tags rather than rejects.
+// Clients without a certificate are tagged with an empty identity.
+const UnauthorizedIdentityTag = "unauthorized_identity"
+
+// IdentityAction is what an IdentityAllowList does with calls from clients
+// whose identity is not allowed.
+type IdentityAction int
+
+const (
+	// RejectIdentity fails the call with codes.PermissionDenied.
+	RejectIdentity IdentityAction = iota
+
+	// TagIdentity accepts the call and sets UnauthorizedIdentityTag on
+	// every envelope received.
+	TagIdentity
+)
+
+// ParseIdentityAction returns the IdentityAction with the given name:
+// "reject" or "tag".
+func ParseIdentityAction(name string) (IdentityAction, error) {
+	switch name {
+	case "reject":
+		return RejectIdentity, nil
+	case "tag":
+		return TagIdentity, nil
+	default:
+		return 0, fmt.Errorf("unknown identity action %q", name)
+	}
+}
+
+func (a IdentityAction) String() string {
+	if a == TagIdentity {
+		return "tag"
+	}
+	return "reject"
+}
+
+// IdentityAllowList authorizes ingress calls by the identity in the
+// client's verified TLS certificate. A client is allowed when its subject
+// common name, or any of its DNS or URI SANs, is in the list.
+type IdentityAllowList struct {
+	allowed map[string]bool
+	action  IdentityAction
+
+	rejectedMetric pulseemitter.CounterMetric
+}
+
+// NewIdentityAllowList returns an IdentityAllowList for the given
+// identities.
+func NewIdentityAllowList(
+	identities []string,
+	action IdentityAction,
+	metricClient MetricClient,
+) *IdentityAllowList {
+	allowed := make(map[string]bool, len(identities))
+	for _, id := range identities {
+		allowed[id] = true
+	}
+
+	return &IdentityAllowList{
+		allowed: allowed,
+		action:  action,
+		rejectedMetric: metricClient.NewCounterMetric("rejected_connections",
+			pulseemitter.WithVersion(2, 0),
+			pulseemitter.WithTags(map[string]string{"action": action.String()}),
+		),
+	}
+}
+
+// ServerOptions returns the interceptors that enforce the allow-list. They
+// are chained with any other interceptors.
+func (l *IdentityAllowList) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(l.unary),
+		grpc.ChainStreamInterceptor(l.stream),
+	}
+}
+
+func (l *IdentityAllowList) unary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if isHealthCheck(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	id, ok := l.authorize(ctx)
+	if !ok {
+		if l.action == RejectIdentity {
+			return nil, status.Errorf(codes.PermissionDenied, "identity %q is not allowed", id)
+		}
+		tagEnvelopes(req, id)
+	}
+
+	return handler(ctx, req)
+}
+
+func (l *IdentityAllowList) stream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if isHealthCheck(info.FullMethod) {
+		return handler(srv, ss)
+	}
+
+	id, ok := l.authorize(ss.Context())
+	if !ok {
+		if l.action == RejectIdentity {
+			return status.Errorf(codes.PermissionDenied, "identity %q is not allowed", id)
+		}
+		ss = &taggingStream{ServerStream: ss, identity: id}
+	}
+
+	return handler(srv, ss)
+}
+
+// isHealthCheck reports whether the method belongs to the gRPC health
+// service, which reveals nothing about envelopes and so is open to any
+// client that completes the TLS handshake.
+func isHealthCheck(method string) bool {
+	return strings.HasPrefix(method, "/grpc.health.v1.Health/")
+}
+
+// authorize returns the identity of the caller and whether it is allowed.
+func (l *IdentityAllowList) authorize(ctx context.Context) (string, bool) {
+	cert := peerCertificate(ctx)
+	if cert == nil {
+		l.reject("")
+		return "", false
+	}
+
+	for _, id := range certIdentities(cert) {
+		if l.allowed[id] {
+			return id, true
+		}
+	}
+
+	id := certIdentities(cert)[0]
+	l.reject(id)
+
+	return id, false
+}
+
+func (l *IdentityAllowList) reject(id string) {
+	// metric-documentation-v2: (loggregator.metron.rejected_connections)
+	// Number of ingress calls from clients whose certificate identity is not
+	// in the allow-list. Tagged with the action taken: reject or tag.
+	l.rejectedMetric.Increment(1)
+	logger.Debugf("ingress call from unauthorized identity %q", id)
+}
+
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return info.State.VerifiedChains[0][0]
+}
+
+// certIdentities returns every identity of the certificate, most specific
+// first: URI SANs, DNS SANs and then the common name.
+func certIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.Subject.CommonName)
+
+	return ids
+}
+
+// taggingStream tags every envelope received on the stream with the
+// identity of the unauthorized client.
+type taggingStream struct {
+	grpc.ServerStream
+	identity string
+}
+
+func (s *taggingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	tagEnvelopes(m, s.identity)
+
+	return nil
+}
+
+func tagEnvelopes(m interface{}, identity string) {
+	switch v := m.(type) {
+	case *loggregator_v2.Envelope:
+		tagEnvelope(v, identity)
+	case *loggregator_v2.EnvelopeBatch:
+		for _, e := range v.GetBatch() {
+			tagEnvelope(e, identity)
+		}
+	}
+}
+
+func tagEnvelope(e *loggregator_v2.Envelope, identity string) {
+	if e.Tags == nil {
+		e.Tags = make(map[string]string)
+	}
+	e.Tags[UnauthorizedIdentityTag] = identity
+}
@@ -0,0 +1,205 @@
+package v2_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IdentityAllowList", func() {
+	var (
+		spySetter    *SpySetter
+		metricClient *testhelper.SpyMetricClient
+		server       *grpc.Server
+		addr         string
+	)
+
+	start := func(serverOpts ...grpc.ServerOption) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		addr = lis.Addr().String()
+
+		server = grpc.NewServer(serverOpts...)
+		rx := ingress.NewReceiver(spySetter, metricClient, newSpyHealthEndpointClient())
+		loggregator_v2.RegisterIngressServer(server, rx)
+		healthpb.RegisterHealthServer(server, health.NewServer())
+		go server.Serve(lis)
+	}
+
+	startTLS := func(l *ingress.IdentityAllowList) {
+		creds, err := plumbing.NewServerCredentials(
+			testhelper.Cert("router.crt"),
+			testhelper.Cert("router.key"),
+			testhelper.Cert("loggregator-ca.crt"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		start(append(l.ServerOptions(), grpc.Creds(creds))...)
+	}
+
+	// dial connects with the metron certificate, whose common name is
+	// "metron". The test certificates have no SANs, so the server's
+	// hostname is not verified.
+	dialConn := func() *grpc.ClientConn {
+		cert, err := tls.LoadX509KeyPair(
+			testhelper.Cert("metron.crt"),
+			testhelper.Cert("metron.key"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		})))
+		Expect(err).ToNot(HaveOccurred())
+
+		return conn
+	}
+
+	dial := func() loggregator_v2.IngressClient {
+		return loggregator_v2.NewIngressClient(dialConn())
+	}
+
+	batch := func() *loggregator_v2.EnvelopeBatch {
+		return &loggregator_v2.EnvelopeBatch{
+			Batch: []*loggregator_v2.Envelope{{SourceId: "some-id"}},
+		}
+	}
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		metricClient = testhelper.NewMetricClient()
+		server = nil
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			server.Stop()
+		}
+	})
+
+	It("parses identity actions", func() {
+		a, err := ingress.ParseIdentityAction("reject")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a).To(Equal(ingress.RejectIdentity))
+
+		a, err = ingress.ParseIdentityAction("tag")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a).To(Equal(ingress.TagIdentity))
+
+		_, err = ingress.ParseIdentityAction("drop")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("accepts calls from allowed identities", func() {
+		startTLS(ingress.NewIdentityAllowList([]string{"doppler", "metron"}, ingress.RejectIdentity, metricClient))
+
+		_, err := dial().Send(context.Background(), batch())
+		Expect(err).ToNot(HaveOccurred())
+
+		var e *loggregator_v2.Envelope
+		Expect(spySetter.envelopes).To(Receive(&e))
+		Expect(e.GetTags()).ToNot(HaveKey(ingress.UnauthorizedIdentityTag))
+		Expect(metricClient.GetMetric("rejected_connections").Delta()).To(BeZero())
+	})
+
+	Context("when rejecting", func() {
+		It("rejects unary calls from unexpected identities", func() {
+			startTLS(ingress.NewIdentityAllowList([]string{"doppler"}, ingress.RejectIdentity, metricClient))
+
+			_, err := dial().Send(context.Background(), batch())
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(spySetter.envelopes).ToNot(Receive())
+			Expect(metricClient.GetMetric("rejected_connections").Delta()).To(Equal(uint64(1)))
+		})
+
+		It("rejects streams from unexpected identities", func() {
+			startTLS(ingress.NewIdentityAllowList([]string{"doppler"}, ingress.RejectIdentity, metricClient))
+
+			sender, err := dial().BatchSender(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			sender.Send(batch())
+
+			_, err = sender.CloseAndRecv()
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+			Expect(spySetter.envelopes).ToNot(Receive())
+			Expect(metricClient.GetMetric("rejected_connections").Delta()).To(Equal(uint64(1)))
+		})
+
+		It("rejects clients without a certificate", func() {
+			l := ingress.NewIdentityAllowList([]string{"metron"}, ingress.RejectIdentity, metricClient)
+			start(l.ServerOptions()...)
+
+			conn, err := grpc.Dial(addr, grpc.WithInsecure())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			_, err = loggregator_v2.NewIngressClient(conn).Send(context.Background(), batch())
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+		})
+	})
+
+	It("allows health checks from any identity", func() {
+		l := ingress.NewIdentityAllowList([]string{"doppler"}, ingress.RejectIdentity, metricClient)
+		creds, err := plumbing.NewServerCredentials(
+			testhelper.Cert("router.crt"),
+			testhelper.Cert("router.key"),
+			testhelper.Cert("loggregator-ca.crt"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		start(append(l.ServerOptions(), grpc.Creds(creds))...)
+
+		resp, err := healthpb.NewHealthClient(dialConn()).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.GetStatus()).To(Equal(healthpb.HealthCheckResponse_SERVING))
+		Expect(metricClient.GetMetric("rejected_connections").Delta()).To(BeZero())
+	})
+
+	Context("when tagging", func() {
+		It("tags envelopes sent in unary calls", func() {
+			startTLS(ingress.NewIdentityAllowList([]string{"doppler"}, ingress.TagIdentity, metricClient))
+
+			_, err := dial().Send(context.Background(), batch())
+			Expect(err).ToNot(HaveOccurred())
+
+			var e *loggregator_v2.Envelope
+			Expect(spySetter.envelopes).To(Receive(&e))
+			Expect(e.GetTags()).To(HaveKeyWithValue(ingress.UnauthorizedIdentityTag, "metron"))
+			Expect(metricClient.GetMetric("rejected_connections").Delta()).To(Equal(uint64(1)))
+		})
+
+		It("tags envelopes sent on streams", func() {
+			startTLS(ingress.NewIdentityAllowList([]string{"doppler"}, ingress.TagIdentity, metricClient))
+
+			client := dial()
+			sender, err := client.Sender(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sender.Send(&loggregator_v2.Envelope{SourceId: "some-id"})).To(Succeed())
+
+			batchSender, err := client.BatchSender(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(batchSender.Send(batch())).To(Succeed())
+
+			var e *loggregator_v2.Envelope
+			Eventually(spySetter.envelopes).Should(Receive(&e))
+			Expect(e.GetTags()).To(HaveKeyWithValue(ingress.UnauthorizedIdentityTag, "metron"))
+			Eventually(spySetter.envelopes).Should(Receive(&e))
+			Expect(e.GetTags()).To(HaveKeyWithValue(ingress.UnauthorizedIdentityTag, "metron"))
+			Expect(metricClient.GetMetric("rejected_connections").Delta()).To(Equal(uint64(2)))
+		})
+	})
+})
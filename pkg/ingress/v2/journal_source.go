@@ -0,0 +1,315 @@
+package v2
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// journalPriorities are the names of the syslog priorities used by
+// journald, indexed by their value.
+var journalPriorities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// JournalSource is a Source that follows the systemd journal by running
+// journalctl and writes each entry as a v2 log envelope tagged with its
+// unit, priority and hostname. The cursor of the last entry read is
+// persisted so that entries are not lost or repeated across restarts.
+type JournalSource struct {
+	setter       DataSetter
+	cursorPath   string
+	command      string
+	args         []string
+	restartDelay time.Duration
+
+	mu          sync.Mutex
+	done        chan struct{}
+	cmd         *exec.Cmd
+	cursor      string
+	stopPending bool
+}
+
+// JournalOption configures a JournalSource.
+type JournalOption func(*JournalSource)
+
+// WithJournalCommand sets the command run to read the journal. The follow,
+// output and cursor arguments are appended to the given arguments. The
+// default is journalctl.
+func WithJournalCommand(name string, args ...string) JournalOption {
+	return func(s *JournalSource) {
+		s.command = name
+		s.args = args
+	}
+}
+
+// WithJournalUnits limits the entries read to those of the given units.
+func WithJournalUnits(units []string) JournalOption {
+	return func(s *JournalSource) {
+		for _, u := range units {
+			s.args = append(s.args, "--unit="+u)
+		}
+	}
+}
+
+// WithJournalRestartDelay sets how long to wait before running the command
+// again after it exits. The default is 5 seconds.
+func WithJournalRestartDelay(d time.Duration) JournalOption {
+	return func(s *JournalSource) {
+		s.restartDelay = d
+	}
+}
+
+// NewJournalSource returns a JournalSource that persists its cursor to the
+// given path.
+func NewJournalSource(cursorPath string, setter DataSetter, opts ...JournalOption) *JournalSource {
+	s := &JournalSource{
+		setter:       setter,
+		cursorPath:   cursorPath,
+		command:      "journalctl",
+		restartDelay: 5 * time.Second,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// Start follows the journal from the persisted cursor, or from the newest
+// entry if there is none. It blocks until Stop is called.
+func (s *JournalSource) Start() error {
+	done := make(chan struct{})
+	s.mu.Lock()
+	if s.stopPending {
+		s.stopPending = false
+		s.mu.Unlock()
+		return nil
+	}
+	s.done = done
+	s.cursor = s.loadCursor()
+	s.mu.Unlock()
+
+	for {
+		s.follow(done)
+		s.saveCursor()
+
+		select {
+		case <-done:
+			return nil
+		case <-time.After(s.restartDelay):
+		}
+	}
+}
+
+// Stop stops journalctl, persists the cursor and causes Start to return. If
+// the source has not yet begun following the journal, Start returns without
+// doing so.
+func (s *JournalSource) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done == nil {
+		s.stopPending = true
+		return
+	}
+
+	close(s.done)
+	s.done = nil
+
+	if s.cmd != nil && s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+	}
+}
+
+// Cursor returns the cursor of the last entry read.
+func (s *JournalSource) Cursor() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cursor
+}
+
+func (s *JournalSource) follow(done chan struct{}) {
+	s.mu.Lock()
+	select {
+	case <-done:
+		s.mu.Unlock()
+		return
+	default:
+	}
+
+	args := append([]string{}, s.args...)
+	args = append(args, "--follow", "--output=json")
+	if s.cursor != "" {
+		args = append(args, "--after-cursor="+s.cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+
+	cmd := exec.Command(s.command, args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		s.mu.Unlock()
+		logger.Errorf("failed to start %s: %s", s.command, err)
+		return
+	}
+	s.cmd = cmd
+	s.mu.Unlock()
+
+	lastSave := time.Now()
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e, cursor, err := journalEntryToEnvelope(scanner.Bytes())
+		if err != nil {
+			logger.Debugf("failed to parse journal entry: %s", err)
+			continue
+		}
+		s.setter.Set(e)
+
+		s.mu.Lock()
+		s.cursor = cursor
+		s.mu.Unlock()
+
+		if time.Since(lastSave) > time.Second {
+			s.saveCursor()
+			lastSave = time.Now()
+		}
+	}
+
+	err = cmd.Wait()
+	logger.Debugf("%s exited: %v", s.command, err)
+
+	s.mu.Lock()
+	s.cmd = nil
+	s.mu.Unlock()
+}
+
+func (s *JournalSource) loadCursor() string {
+	data, err := ioutil.ReadFile(s.cursorPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("failed to read journal cursor: %s", err)
+		}
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// saveCursor atomically writes the cursor to the cursor path.
+func (s *JournalSource) saveCursor() {
+	cursor := s.Cursor()
+	if cursor == "" {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.cursorPath), ".journal-cursor")
+	if err != nil {
+		logger.Warnf("failed to write journal cursor: %s", err)
+		return
+	}
+
+	_, err = tmp.WriteString(cursor)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.cursorPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		logger.Warnf("failed to write journal cursor: %s", err)
+	}
+}
+
+// journalEntryToEnvelope converts a journal entry in journalctl's JSON
+// output format to a log envelope and returns it with the entry's cursor.
+func journalEntryToEnvelope(line []byte) (*loggregator_v2.Envelope, string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return nil, "", err
+	}
+
+	field := func(name string) string {
+		raw, ok := fields[name]
+		if !ok {
+			return ""
+		}
+
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s
+		}
+
+		// journalctl encodes values that are not valid UTF-8 as an array
+		// of bytes.
+		var b []byte
+		var ints []int
+		if err := json.Unmarshal(raw, &ints); err == nil {
+			for _, i := range ints {
+				b = append(b, byte(i))
+			}
+		}
+
+		return string(b)
+	}
+
+	unit := field("_SYSTEMD_UNIT")
+	sourceID := unit
+	if sourceID == "" {
+		sourceID = field("SYSLOG_IDENTIFIER")
+	}
+	if sourceID == "" {
+		sourceID = "journald"
+	}
+
+	logType := loggregator_v2.Log_OUT
+	tags := map[string]string{
+		"hostname": field("_HOSTNAME"),
+	}
+	if unit != "" {
+		tags["unit"] = unit
+	}
+
+	if p, err := strconv.Atoi(field("PRIORITY")); err == nil && p >= 0 && p < len(journalPriorities) {
+		tags["priority"] = journalPriorities[p]
+		if p <= 3 {
+			logType = loggregator_v2.Log_ERR
+		}
+	}
+
+	var timestamp int64
+	if us, err := strconv.ParseInt(field("__REALTIME_TIMESTAMP"), 10, 64); err == nil {
+		timestamp = us * int64(time.Microsecond)
+	} else {
+		timestamp = time.Now().UnixNano()
+	}
+
+	return &loggregator_v2.Envelope{
+		Timestamp: timestamp,
+		SourceId:  sourceID,
+		Tags:      tags,
+		Message: &loggregator_v2.Envelope_Log{
+			Log: &loggregator_v2.Log{
+				Payload: []byte(field("MESSAGE")),
+				Type:    logType,
+			},
+		},
+	}, field("__CURSOR"), nil
+}
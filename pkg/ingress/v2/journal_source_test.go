@@ -0,0 +1,141 @@
+package v2_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JournalSource", func() {
+	var (
+		dir        string
+		cursorPath string
+		argsPath   string
+		spySetter  *SpySetter
+		s          *ingress.JournalSource
+	)
+
+	// journalctl returns a command that records its arguments, writes the
+	// given journal entries and then waits to be killed.
+	journalctl := func(entries ...string) ingress.JournalOption {
+		script := fmt.Sprintf("echo \"$@\" >> %s\n", argsPath)
+		for _, e := range entries {
+			script += fmt.Sprintf("echo '%s'\n", e)
+		}
+		script += "exec sleep 10\n"
+
+		return ingress.WithJournalCommand("sh", "-c", script, "journalctl")
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "journal")
+		Expect(err).ToNot(HaveOccurred())
+
+		cursorPath = filepath.Join(dir, "cursor")
+		argsPath = filepath.Join(dir, "args")
+		spySetter = NewSpySetter()
+	})
+
+	AfterEach(func() {
+		s.Stop()
+		os.RemoveAll(dir)
+	})
+
+	It("writes journal entries as log envelopes", func() {
+		s = ingress.NewJournalSource(cursorPath, spySetter, journalctl(
+			`{"__CURSOR":"c1","__REALTIME_TIMESTAMP":"1500000000000000","_SYSTEMD_UNIT":"monit.service","_HOSTNAME":"some-host","PRIORITY":"6","MESSAGE":"hello"}`,
+			`{"__CURSOR":"c2","SYSLOG_IDENTIFIER":"kernel","_HOSTNAME":"some-host","PRIORITY":"3","MESSAGE":[104,105]}`,
+		))
+		go s.Start()
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("monit.service"))
+		Expect(e.Timestamp).To(Equal(int64(1500000000000000000)))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("hello")))
+		Expect(e.GetLog().GetType()).To(Equal(loggregator_v2.Log_OUT))
+		Expect(e.Tags).To(Equal(map[string]string{
+			"unit":     "monit.service",
+			"priority": "info",
+			"hostname": "some-host",
+		}))
+
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("kernel"))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("hi")))
+		Expect(e.GetLog().GetType()).To(Equal(loggregator_v2.Log_ERR))
+		Expect(e.Tags).To(HaveKeyWithValue("priority", "err"))
+		Expect(e.Tags).ToNot(HaveKey("unit"))
+
+		Eventually(s.Cursor).Should(Equal("c2"))
+	})
+
+	It("skips entries that are not valid JSON", func() {
+		s = ingress.NewJournalSource(cursorPath, spySetter, journalctl(
+			`not json`,
+			`{"__CURSOR":"c1","MESSAGE":"hello"}`,
+		))
+		go s.Start()
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("journald"))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("hello")))
+	})
+
+	It("starts from the newest entry without a persisted cursor", func() {
+		s = ingress.NewJournalSource(cursorPath, spySetter, journalctl())
+		go s.Start()
+
+		Eventually(func() string {
+			data, _ := ioutil.ReadFile(argsPath)
+			return string(data)
+		}).Should(ContainSubstring("--follow --output=json --lines=0"))
+	})
+
+	It("persists the cursor and resumes after it", func() {
+		s = ingress.NewJournalSource(cursorPath, spySetter, journalctl(
+			`{"__CURSOR":"c1","MESSAGE":"hello"}`,
+		))
+		go s.Start()
+		Eventually(s.Cursor).Should(Equal("c1"))
+		s.Stop()
+
+		Eventually(func() string {
+			data, _ := ioutil.ReadFile(cursorPath)
+			return string(data)
+		}).Should(Equal("c1"))
+
+		s = ingress.NewJournalSource(cursorPath, spySetter, journalctl())
+		go s.Start()
+
+		Eventually(func() string {
+			data, _ := ioutil.ReadFile(argsPath)
+			return string(data)
+		}).Should(ContainSubstring("--after-cursor=c1"))
+	})
+
+	It("restarts the command when it exits", func() {
+		s = ingress.NewJournalSource(
+			cursorPath,
+			spySetter,
+			ingress.WithJournalCommand("sh", "-c", fmt.Sprintf("echo started >> %s", argsPath)),
+			ingress.WithJournalRestartDelay(time.Millisecond),
+		)
+		go s.Start()
+
+		Eventually(func() int {
+			data, _ := ioutil.ReadFile(argsPath)
+			return len(data) / len("started\n")
+		}).Should(BeNumerically(">=", 2))
+	})
+})
@@ -0,0 +1,127 @@
+package v2
+
+import (
+	"net"
+	"sync"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StreamLimiter limits the number of ingress streams open at once across
+// every connection to a server. Streams opened beyond the limit fail with
+// codes.ResourceExhausted.
+type StreamLimiter struct {
+	slots          chan struct{}
+	rejectedMetric pulseemitter.CounterMetric
+}
+
+// NewStreamLimiter returns a StreamLimiter that allows max streams.
+func NewStreamLimiter(max int, metricClient MetricClient) *StreamLimiter {
+	return &StreamLimiter{
+		slots: make(chan struct{}, max),
+		rejectedMetric: metricClient.NewCounterMetric("stream_limit_rejections",
+			pulseemitter.WithVersion(2, 0),
+		),
+	}
+}
+
+// ServerOptions returns the interceptor that enforces the limit. It is
+// chained with any other interceptors.
+func (l *StreamLimiter) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainStreamInterceptor(l.stream),
+	}
+}
+
+func (l *StreamLimiter) stream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if isHealthCheck(info.FullMethod) {
+		return handler(srv, ss)
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		// metric-documentation-v2: (loggregator.metron.stream_limit_rejections)
+		// Number of ingress streams rejected because the maximum number of
+		// concurrent streams were open.
+		l.rejectedMetric.Increment(1)
+		return status.Errorf(codes.ResourceExhausted, "too many concurrent streams (max %d)", cap(l.slots))
+	}
+	defer func() { <-l.slots }()
+
+	return handler(srv, ss)
+}
+
+// connLimitListener closes connections accepted while max connections are
+// already open, rather than leaving them queued as a blocking limit would.
+type connLimitListener struct {
+	net.Listener
+
+	max            int
+	rejectedMetric pulseemitter.CounterMetric
+
+	mu   sync.Mutex
+	open int
+}
+
+func newConnLimitListener(lis net.Listener, max int, rejected pulseemitter.CounterMetric) *connLimitListener {
+	return &connLimitListener{
+		Listener:       lis,
+		max:            max,
+		rejectedMetric: rejected,
+	}
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		l.mu.Lock()
+		if l.open >= l.max {
+			l.mu.Unlock()
+
+			// metric-documentation-v2: (loggregator.metron.connection_limit_rejections)
+			// Number of ingress connections closed because the maximum number
+			// of connections were open.
+			l.rejectedMetric.Increment(1)
+			conn.Close()
+			continue
+		}
+		l.open++
+		l.mu.Unlock()
+
+		return &limitedConn{Conn: conn, release: l.release}, nil
+	}
+}
+
+func (l *connLimitListener) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.open--
+}
+
+type limitedConn struct {
+	net.Conn
+
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+
+	return err
+}
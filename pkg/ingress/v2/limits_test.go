@@ -0,0 +1,264 @@
+package v2_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limits", func() {
+	var (
+		spySetter    *SpySetter
+		metricClient *testhelper.SpyMetricClient
+	)
+
+	batch := func() *loggregator_v2.EnvelopeBatch {
+		return &loggregator_v2.EnvelopeBatch{
+			Batch: []*loggregator_v2.Envelope{{SourceId: "some-id"}},
+		}
+	}
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		metricClient = testhelper.NewMetricClient()
+	})
+
+	Describe("StreamLimiter", func() {
+		var (
+			server *grpc.Server
+			conn   *grpc.ClientConn
+			client loggregator_v2.IngressClient
+		)
+
+		BeforeEach(func() {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+
+			l := ingress.NewStreamLimiter(1, metricClient)
+			server = grpc.NewServer(l.ServerOptions()...)
+			rx := ingress.NewReceiver(spySetter, metricClient, newSpyHealthEndpointClient())
+			loggregator_v2.RegisterIngressServer(server, rx)
+			go server.Serve(lis)
+
+			conn, err = grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+			Expect(err).ToNot(HaveOccurred())
+			client = loggregator_v2.NewIngressClient(conn)
+		})
+
+		AfterEach(func() {
+			conn.Close()
+			server.Stop()
+		})
+
+		It("rejects streams beyond the limit", func() {
+			first, err := client.BatchSender(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(first.Send(batch())).To(Succeed())
+			Eventually(spySetter.envelopes).Should(Receive())
+
+			second, err := client.BatchSender(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			second.Send(batch())
+			_, err = second.CloseAndRecv()
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			Expect(metricClient.GetMetric("stream_limit_rejections").Delta()).To(Equal(uint64(1)))
+
+			first.CloseSend()
+			Eventually(func() bool {
+				s, err := client.BatchSender(context.Background())
+				if err != nil {
+					return false
+				}
+				s.Send(batch())
+				_, err = s.CloseAndRecv()
+				return status.Code(err) != codes.ResourceExhausted
+			}).Should(BeTrue())
+			Eventually(spySetter.envelopes).Should(Receive())
+		})
+
+		It("does not limit unary calls", func() {
+			first, err := client.BatchSender(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(first.Send(batch())).To(Succeed())
+			Eventually(spySetter.envelopes).Should(Receive())
+
+			_, err = client.Send(context.Background(), batch())
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("ClientRateLimiter", func() {
+		var (
+			server *grpc.Server
+			addr   string
+		)
+
+		start := func(key ingress.ClientKey) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			addr = lis.Addr().String()
+
+			l := ingress.NewClientRateLimiter(ratelimit.Limit{Rate: 0.001, Burst: 2}, key, metricClient)
+			server = grpc.NewServer(l.ServerOptions()...)
+			rx := ingress.NewReceiver(spySetter, metricClient, newSpyHealthEndpointClient())
+			loggregator_v2.RegisterIngressServer(server, rx)
+			go server.Serve(lis)
+		}
+
+		dial := func() loggregator_v2.IngressClient {
+			conn, err := grpc.Dial(addr, grpc.WithInsecure())
+			Expect(err).ToNot(HaveOccurred())
+			return loggregator_v2.NewIngressClient(conn)
+		}
+
+		AfterEach(func() {
+			server.Stop()
+		})
+
+		It("rejects unary calls once the client exceeds its limit", func() {
+			start(ingress.ClientByConnection)
+			client := dial()
+
+			_, err := client.Send(context.Background(), batch())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = client.Send(context.Background(), batch())
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = client.Send(context.Background(), batch())
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			Expect(metricClient.GetMetric("client_rate_limit_rejections").Delta()).To(Equal(uint64(1)))
+
+			_, err = dial().Send(context.Background(), batch())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("ends streams once the client exceeds its limit", func() {
+			start(ingress.ClientByConnection)
+
+			s, err := dial().BatchSender(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			for i := 0; i < 3; i++ {
+				s.Send(batch())
+			}
+			_, err = s.CloseAndRecv()
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			Expect(spySetter.envelopes).To(HaveLen(2))
+		})
+
+		It("admits the part of a batch larger than the burst it has tokens for", func() {
+			start(ingress.ClientByConnection)
+
+			_, err := dial().Send(context.Background(), &loggregator_v2.EnvelopeBatch{
+				Batch: []*loggregator_v2.Envelope{
+					{SourceId: "first"},
+					{SourceId: "second"},
+					{SourceId: "third"},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(spySetter.envelopes).To(HaveLen(2))
+			Expect(metricClient.GetMetric("client_rate_limit_rejections").Delta()).To(Equal(uint64(1)))
+		})
+
+		It("limits clients without a certificate together by identity", func() {
+			start(ingress.ClientByIdentity)
+
+			for i := 0; i < 2; i++ {
+				_, err := dial().Send(context.Background(), batch())
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			_, err := dial().Send(context.Background(), batch())
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		})
+
+		It("parses client keys", func() {
+			k, err := ingress.ParseClientKey("identity")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(k).To(Equal(ingress.ClientByIdentity))
+
+			_, err = ingress.ParseClientKey("address")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Server.LimitConnections", func() {
+		var (
+			dir  string
+			path string
+			s    *ingress.Server
+		)
+
+		dial := func() *grpc.ClientConn {
+			conn, err := grpc.Dial(
+				path,
+				grpc.WithInsecure(),
+				grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", addr)
+				}),
+			)
+			Expect(err).ToNot(HaveOccurred())
+
+			return conn
+		}
+
+		send := func(conn *grpc.ClientConn) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			_, err := loggregator_v2.NewIngressClient(conn).Send(ctx, batch())
+			return err
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "limits")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "agent.sock")
+
+			rx := ingress.NewReceiver(spySetter, metricClient, newSpyHealthEndpointClient())
+			s = ingress.NewUnixServer(path, 0600, rx)
+			s.LimitConnections(1, metricClient)
+			go s.Start()
+			Eventually(s.Listening).Should(BeTrue())
+		})
+
+		AfterEach(func() {
+			s.Stop()
+			os.RemoveAll(dir)
+		})
+
+		It("closes connections beyond the limit", func() {
+			first := dial()
+			Expect(send(first)).To(Succeed())
+
+			second := dial()
+			defer second.Close()
+			Expect(send(second)).ToNot(Succeed())
+			Expect(metricClient.GetMetric("connection_limit_rejections").Delta()).ToNot(BeZero())
+
+			first.Close()
+			Eventually(func() error {
+				conn := dial()
+				defer conn.Close()
+				return send(conn)
+			}).Should(Succeed())
+		})
+	})
+})
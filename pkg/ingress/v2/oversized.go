@@ -0,0 +1,55 @@
+package v2
+
+import (
+	"context"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// OversizedMessageCounter returns a server option that counts calls failed
+// by gRPC because a message was larger than the server's max receive
+// message size. gRPC rejects such messages before they reach the Receiver.
+func OversizedMessageCounter(metricClient MetricClient) grpc.ServerOption {
+	return grpc.StatsHandler(&oversizedHandler{
+		metric: metricClient.NewCounterMetric("oversized_batches",
+			pulseemitter.WithVersion(2, 0),
+		),
+	})
+}
+
+type oversizedHandler struct {
+	metric pulseemitter.CounterMetric
+}
+
+func (h *oversizedHandler) HandleRPC(_ context.Context, s stats.RPCStats) {
+	end, ok := s.(*stats.End)
+	if !ok || end.Error == nil {
+		return
+	}
+
+	st, ok := status.FromError(end.Error)
+	if !ok || st.Code() != codes.ResourceExhausted || !strings.Contains(st.Message(), "received message larger than max") {
+		return
+	}
+
+	// metric-documentation-v2: (loggregator.metron.oversized_batches)
+	// Number of ingress messages rejected for exceeding the max receive
+	// message size.
+	h.metric.Increment(1)
+	logger.Debugf("rejected oversized ingress message: %s", st.Message())
+}
+
+func (h *oversizedHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *oversizedHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *oversizedHandler) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,91 @@
+package v2_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OversizedMessageCounter", func() {
+	var (
+		spySetter    *SpySetter
+		metricClient *testhelper.SpyMetricClient
+		server       *grpc.Server
+		client       loggregator_v2.IngressClient
+		conn         *grpc.ClientConn
+	)
+
+	batch := func(payloadSize int) *loggregator_v2.EnvelopeBatch {
+		return &loggregator_v2.EnvelopeBatch{
+			Batch: []*loggregator_v2.Envelope{{
+				SourceId: "some-id",
+				Message: &loggregator_v2.Envelope_Log{
+					Log: &loggregator_v2.Log{Payload: []byte(strings.Repeat("x", payloadSize))},
+				},
+			}},
+		}
+	}
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		metricClient = testhelper.NewMetricClient()
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		server = grpc.NewServer(
+			grpc.MaxRecvMsgSize(1024),
+			ingress.OversizedMessageCounter(metricClient),
+		)
+		rx := ingress.NewReceiver(spySetter, metricClient, newSpyHealthEndpointClient())
+		loggregator_v2.RegisterIngressServer(server, rx)
+		go server.Serve(lis)
+
+		conn, err = grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+		Expect(err).ToNot(HaveOccurred())
+		client = loggregator_v2.NewIngressClient(conn)
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		server.Stop()
+	})
+
+	It("counts oversized messages sent in unary calls", func() {
+		_, err := client.Send(context.Background(), batch(2048))
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+
+		Eventually(metricClient.GetMetric("oversized_batches").Delta).Should(Equal(uint64(1)))
+		Expect(spySetter.envelopes).ToNot(Receive())
+	})
+
+	It("counts oversized messages sent on streams", func() {
+		sender, err := client.BatchSender(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.Send(batch(2048))).To(Succeed())
+
+		_, err = sender.CloseAndRecv()
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+
+		Eventually(metricClient.GetMetric("oversized_batches").Delta).Should(Equal(uint64(1)))
+	})
+
+	It("does not count messages within the limit", func() {
+		_, err := client.Send(context.Background(), batch(10))
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(spySetter.envelopes).Should(Receive())
+		Consistently(metricClient.GetMetric("oversized_batches").Delta, 100*time.Millisecond).Should(BeZero())
+	})
+})
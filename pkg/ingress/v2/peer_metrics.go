@@ -0,0 +1,77 @@
+package v2
+
+import (
+	"context"
+	"sync"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+)
+
+// maxPeers is the number of client identities ingress is counted for.
+// Envelopes from further clients are counted under OtherPeers.
+const maxPeers = 1000
+
+// OtherPeers is the peer reported for envelopes from clients that are not
+// counted individually.
+const OtherPeers = "other"
+
+// UnknownPeer is the peer reported for envelopes from clients without a
+// verified certificate.
+const UnknownPeer = "unknown"
+
+// peerMetrics counts the envelopes and streams received from each client
+// by the common name of its certificate.
+type peerMetrics struct {
+	metricClient MetricClient
+
+	mu      sync.Mutex
+	ingress map[string]pulseemitter.CounterMetric
+	streams map[string]pulseemitter.CounterMetric
+}
+
+func newPeerMetrics(m MetricClient) *peerMetrics {
+	return &peerMetrics{
+		metricClient: m,
+		ingress:      make(map[string]pulseemitter.CounterMetric),
+		streams:      make(map[string]pulseemitter.CounterMetric),
+	}
+}
+
+// forContext returns the ingress counter of the client of a call and counts
+// the call.
+func (p *peerMetrics) forContext(ctx context.Context) pulseemitter.CounterMetric {
+	name := UnknownPeer
+	if cert := peerCertificate(ctx); cert != nil && cert.Subject.CommonName != "" {
+		name = cert.Subject.CommonName
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.ingress[name]; !ok && len(p.ingress) >= maxPeers {
+		name = OtherPeers
+	}
+
+	if _, ok := p.ingress[name]; !ok {
+		tags := map[string]string{"peer": name}
+
+		// metric-documentation-v2: (loggregator.metron.peer_ingress) Number
+		// of envelopes received from each client, tagged with the common
+		// name of its certificate.
+		p.ingress[name] = p.metricClient.NewCounterMetric("peer_ingress",
+			pulseemitter.WithVersion(2, 0),
+			pulseemitter.WithTags(tags),
+		)
+
+		// metric-documentation-v2: (loggregator.metron.peer_streams) Number
+		// of ingress calls made by each client, tagged with the common name
+		// of its certificate.
+		p.streams[name] = p.metricClient.NewCounterMetric("peer_streams",
+			pulseemitter.WithVersion(2, 0),
+			pulseemitter.WithTags(tags),
+		)
+	}
+	p.streams[name].Increment(1)
+
+	return p.ingress[name]
+}
@@ -1,10 +1,12 @@
 package v2
 
 import (
-	"log"
+	"io"
 
 	"code.cloudfoundry.org/go-loggregator/pulseemitter"
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	"code.cloudfoundry.org/loggregator-agent/pkg/tracing"
 	"golang.org/x/net/context"
 )
 
@@ -27,9 +29,44 @@ type Receiver struct {
 	ingressMetric        pulseemitter.CounterMetric
 	originMappingsMetric pulseemitter.CounterMetric
 	healthEndpointClient HealthEndpointClient
+	tracer               *tracing.Tracer
+	peers                *peerMetrics
+	sizes                *sizeMetrics
+	types                *plumbing.TypeCounter
 }
 
-func NewReceiver(dataSetter DataSetter, metricClient MetricClient, health HealthEndpointClient) *Receiver {
+// ReceiverOption configures a Receiver.
+type ReceiverOption func(*Receiver)
+
+// WithReceiverPeerMetrics counts the envelopes received from each client by
+// the common name of its certificate, so that the jobs sending the most
+// envelopes can be identified. The ingress metric continues to count every
+// envelope.
+func WithReceiverPeerMetrics(m MetricClient) ReceiverOption {
+	return func(r *Receiver) {
+		r.peers = newPeerMetrics(m)
+	}
+}
+
+// WithReceiverSizeMetrics counts the bytes received and the number of
+// envelopes received of each size, so that emitters of unusually large
+// envelopes can be spotted. Each envelope's size is calculated as it is
+// received.
+func WithReceiverSizeMetrics(m MetricClient) ReceiverOption {
+	return func(r *Receiver) {
+		r.sizes = newSizeMetrics(m)
+	}
+}
+
+// WithReceiverTracer samples received envelopes to be traced through the
+// agent.
+func WithReceiverTracer(t *tracing.Tracer) ReceiverOption {
+	return func(r *Receiver) {
+		r.tracer = t
+	}
+}
+
+func NewReceiver(dataSetter DataSetter, metricClient MetricClient, health HealthEndpointClient, opts ...ReceiverOption) *Receiver {
 	// metric-documentation-v2: (loggregator.metron.ingress) The number of
 	// received messages over Metrons V2 gRPC API.
 	ingressMetric := metricClient.NewCounterMetric("ingress",
@@ -40,56 +77,122 @@ func NewReceiver(dataSetter DataSetter, metricClient MetricClient, health Health
 		pulseemitter.WithVersion(2, 0),
 	)
 
-	return &Receiver{
+	// metric-documentation-v2: (loggregator.metron.ingress_by_type) The
+	// number of received messages of each envelope type, tagged with the
+	// type.
+	types := plumbing.NewTypeCounter(metricClient, "ingress_by_type")
+
+	r := &Receiver{
 		dataSetter:           dataSetter,
 		ingressMetric:        ingressMetric,
 		originMappingsMetric: originMappingsMetric,
 		healthEndpointClient: health,
+		types:                types,
+	}
+	for _, o := range opts {
+		o(r)
 	}
+
+	return r
 }
 
+// Sender receives envelopes one at a time until the client closes the
+// stream.
 func (s *Receiver) Sender(sender loggregator_v2.Ingress_SenderServer) error {
+	peerMetric := s.peerMetric(sender)
 	for {
 		e, err := sender.Recv()
+		if err == io.EOF {
+			return sender.SendAndClose(&loggregator_v2.IngressResponse{})
+		}
 		if err != nil {
-			log.Printf("Failed to receive data: %s", err)
+			logger.Debugf("Failed to receive data: %s", err)
 			return err
 		}
-		e.SourceId = s.sourceID(e)
-		s.dataSetter.Set(e)
-		s.ingressMetric.Increment(1)
-	}
 
-	return nil
+		s.receive([]*loggregator_v2.Envelope{e}, peerMetric)
+	}
 }
 
+// BatchSender receives batches of envelopes until the client closes the
+// stream.
 func (s *Receiver) BatchSender(sender loggregator_v2.Ingress_BatchSenderServer) error {
+	peerMetric := s.peerMetric(sender)
 	for {
 		envelopes, err := sender.Recv()
+		if err == io.EOF {
+			return sender.SendAndClose(&loggregator_v2.BatchSenderResponse{})
+		}
 		if err != nil {
-			log.Printf("Failed to receive data: %s", err)
+			logger.Debugf("Failed to receive data: %s", err)
 			return err
 		}
 
-		for _, e := range envelopes.Batch {
-			e.SourceId = s.sourceID(e)
-			s.dataSetter.Set(e)
-		}
-		s.ingressMetric.Increment(uint64(len(envelopes.Batch)))
+		s.receive(envelopes.Batch, peerMetric)
+	}
+}
+
+// Send receives a single batch of envelopes.
+func (s *Receiver) Send(ctx context.Context, b *loggregator_v2.EnvelopeBatch) (*loggregator_v2.SendResponse, error) {
+	peerMetric := pulseemitter.CounterMetric(nopCounter{})
+	if s.peers != nil {
+		peerMetric = s.peers.forContext(ctx)
 	}
 
-	return nil
+	s.receive(b.Batch, peerMetric)
+
+	return &loggregator_v2.SendResponse{}, nil
 }
 
-func (s *Receiver) Send(_ context.Context, b *loggregator_v2.EnvelopeBatch) (*loggregator_v2.SendResponse, error) {
-	for _, e := range b.Batch {
+// receive hands on envelopes received by any of the ingress calls and
+// counts them, so that each call behaves the same.
+func (s *Receiver) receive(envelopes []*loggregator_v2.Envelope, peerMetric pulseemitter.CounterMetric) {
+	// Envelopes are sized before they are handed on, after which they may
+	// be modified as they are written.
+	if s.sizes != nil {
+		s.sizes.track(envelopes)
+	}
+	s.types.Count(envelopes)
+
+	var n uint64
+	for _, e := range envelopes {
+		if e == nil {
+			continue
+		}
+
 		e.SourceId = s.sourceID(e)
-		s.dataSetter.Set(e)
+		s.set(e)
+		n++
 	}
 
-	s.ingressMetric.Increment(uint64(len(b.Batch)))
+	if n == 0 {
+		return
+	}
 
-	return &loggregator_v2.SendResponse{}, nil
+	s.ingressMetric.Increment(n)
+	peerMetric.Increment(n)
+}
+
+// peerMetric returns the ingress counter of the stream's client, or a
+// counter that discards increments if peer metrics are not enabled.
+func (s *Receiver) peerMetric(stream interface{ Context() context.Context }) pulseemitter.CounterMetric {
+	if s.peers == nil {
+		return nopCounter{}
+	}
+
+	return s.peers.forContext(stream.Context())
+}
+
+type nopCounter struct{}
+
+func (nopCounter) Increment(uint64)            {}
+func (nopCounter) Emit(pulseemitter.LogClient) {}
+
+// set hands the envelope on, tracing it through ingress if it is sampled.
+func (s *Receiver) set(e *loggregator_v2.Envelope) {
+	s.tracer.Sample(e, "ingress")
+	s.dataSetter.Set(e)
+	s.tracer.Mark(e, "buffer")
 }
 
 func (r *Receiver) sourceID(e *loggregator_v2.Envelope) string {
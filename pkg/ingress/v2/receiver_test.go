@@ -2,16 +2,24 @@ package v2_test
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"io"
+	"net"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
 	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
 
+	"github.com/golang/protobuf/proto"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 )
 
 var _ = Describe("Receiver", func() {
@@ -64,6 +72,15 @@ var _ = Describe("Receiver", func() {
 			Expect(spySetter.envelopes).To(Receive(Equal(eExpected)))
 		})
 
+		It("closes the stream when the client is done", func() {
+			spySender.recvResponses <- SenderRecvResponse{
+				err: io.EOF,
+			}
+
+			Expect(rx.Sender(spySender)).To(Succeed())
+			Expect(spySender.closed).To(BeTrue())
+		})
+
 		It("returns an error when receive fails", func() {
 			spySender.recvResponses <- SenderRecvResponse{
 				err: errors.New("error occurred"),
@@ -211,6 +228,15 @@ var _ = Describe("Receiver", func() {
 			Expect(spySetter.envelopes).Should(HaveLen(5))
 		})
 
+		It("closes the stream when the client is done", func() {
+			spyBatchSender.recvResponses <- BatchSenderRecvResponse{
+				err: io.EOF,
+			}
+
+			Expect(rx.BatchSender(spyBatchSender)).To(Succeed())
+			Expect(spyBatchSender.closed).To(BeTrue())
+		})
+
 		It("returns an error when receive fails", func() {
 			spyBatchSender.recvResponses <- BatchSenderRecvResponse{
 				err: errors.New("error occurred"),
@@ -443,6 +469,132 @@ var _ = Describe("Receiver", func() {
 			})
 		})
 	})
+	Describe("over gRPC", func() {
+		var (
+			server *grpc.Server
+			conn   *grpc.ClientConn
+			client loggregator_v2.IngressClient
+		)
+
+		BeforeEach(func() {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+
+			server = grpc.NewServer()
+			loggregator_v2.RegisterIngressServer(server, rx)
+			go server.Serve(lis)
+
+			conn, err = grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+			Expect(err).ToNot(HaveOccurred())
+			client = loggregator_v2.NewIngressClient(conn)
+		})
+
+		AfterEach(func() {
+			conn.Close()
+			server.Stop()
+		})
+
+		It("handles envelopes the same way for every call", func() {
+			e := &loggregator_v2.Envelope{Tags: map[string]string{"origin": "some-origin"}}
+
+			_, err := client.Send(context.Background(), &loggregator_v2.EnvelopeBatch{
+				Batch: []*loggregator_v2.Envelope{e},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			sender, err := client.Sender(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sender.Send(e)).To(Succeed())
+			_, err = sender.CloseAndRecv()
+			Expect(err).ToNot(HaveOccurred())
+
+			batchSender, err := client.BatchSender(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(batchSender.Send(&loggregator_v2.EnvelopeBatch{
+				Batch: []*loggregator_v2.Envelope{e},
+			})).To(Succeed())
+			_, err = batchSender.CloseAndRecv()
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 3; i++ {
+				var actual *loggregator_v2.Envelope
+				Expect(spySetter.envelopes).To(Receive(&actual))
+				Expect(actual.GetSourceId()).To(Equal("some-origin"))
+			}
+			Expect(metricClient.GetMetric("ingress").Delta()).To(Equal(uint64(3)))
+			Expect(metricClient.GetMetric("origin_mappings").Delta()).To(Equal(uint64(3)))
+		})
+	})
+
+	Describe("with size metrics", func() {
+		BeforeEach(func() {
+			rx = ingress.NewReceiver(spySetter, metricClient, h, ingress.WithReceiverSizeMetrics(metricClient))
+		})
+
+		It("counts the bytes and sizes of envelopes", func() {
+			small := &loggregator_v2.Envelope{SourceId: "a"}
+			large := &loggregator_v2.Envelope{
+				SourceId: "a",
+				Message: &loggregator_v2.Envelope_Log{
+					Log: &loggregator_v2.Log{Payload: make([]byte, 2*1024*1024)},
+				},
+			}
+
+			_, err := rx.Send(context.Background(), &loggregator_v2.EnvelopeBatch{
+				Batch: []*loggregator_v2.Envelope{small, large, large},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(metricClient.GetMetric("ingress_bytes").Delta()).To(Equal(uint64(
+				proto.Size(small) + 2*proto.Size(large),
+			)))
+
+			// The spy metric client keeps the last bucket created, which
+			// counts the largest envelopes.
+			Expect(metricClient.GetMetric("ingress_envelope_size").Delta()).To(Equal(uint64(2)))
+		})
+	})
+
+	Describe("with peer metrics", func() {
+		peerContext := func(cn string) context.Context {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+			return peer.NewContext(context.Background(), &peer.Peer{
+				AuthInfo: credentials.TLSInfo{
+					State: tls.ConnectionState{
+						VerifiedChains: [][]*x509.Certificate{{cert}},
+					},
+				},
+			})
+		}
+
+		BeforeEach(func() {
+			rx = ingress.NewReceiver(spySetter, metricClient, h, ingress.WithReceiverPeerMetrics(metricClient))
+		})
+
+		It("counts envelopes and calls by the client's common name", func() {
+			batch := &loggregator_v2.EnvelopeBatch{
+				Batch: []*loggregator_v2.Envelope{{SourceId: "a"}, {SourceId: "b"}},
+			}
+
+			_, err := rx.Send(peerContext("rep"), batch)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rx.Send(peerContext("rep"), batch)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(metricClient.GetMetric("peer_ingress").Delta()).To(Equal(uint64(4)))
+			Expect(metricClient.GetMetric("peer_streams").Delta()).To(Equal(uint64(2)))
+			Expect(metricClient.GetMetric("ingress").Delta()).To(Equal(uint64(4)))
+		})
+
+		It("counts clients without a certificate as unknown", func() {
+			_, err := rx.Send(context.Background(), &loggregator_v2.EnvelopeBatch{
+				Batch: []*loggregator_v2.Envelope{{SourceId: "a"}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(metricClient.GetMetric("peer_ingress").Delta()).To(Equal(uint64(1)))
+		})
+	})
 })
 
 type SenderRecvResponse struct {
@@ -458,6 +610,7 @@ type BatchSenderRecvResponse struct {
 type SpySender struct {
 	loggregator_v2.Ingress_SenderServer
 	recvResponses chan SenderRecvResponse
+	closed        bool
 }
 
 func NewSpySender() *SpySender {
@@ -472,9 +625,15 @@ func (s *SpySender) Recv() (*loggregator_v2.Envelope, error) {
 	return resp.envelope, resp.err
 }
 
+func (s *SpySender) SendAndClose(*loggregator_v2.IngressResponse) error {
+	s.closed = true
+	return nil
+}
+
 type SpyBatchSender struct {
 	loggregator_v2.Ingress_BatchSenderServer
 	recvResponses chan BatchSenderRecvResponse
+	closed        bool
 }
 
 func NewSpyBatchSender() *SpyBatchSender {
@@ -489,6 +648,11 @@ func (s *SpyBatchSender) Recv() (*loggregator_v2.EnvelopeBatch, error) {
 	return &loggregator_v2.EnvelopeBatch{Batch: resp.envelopes}, resp.err
 }
 
+func (s *SpyBatchSender) SendAndClose(*loggregator_v2.BatchSenderResponse) error {
+	s.closed = true
+	return nil
+}
+
 type SpySetter struct {
 	envelopes chan *loggregator_v2.Envelope
 }
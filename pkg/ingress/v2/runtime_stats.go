@@ -0,0 +1,96 @@
+package v2
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RuntimeStats reports the resources used by the agent process: CPU, memory,
+// goroutines and garbage collection.
+type RuntimeStats struct {
+	mu         sync.Mutex
+	lastCPU    time.Duration
+	lastSample time.Time
+}
+
+// NewRuntimeStats returns a RuntimeStats.
+func NewRuntimeStats() *RuntimeStats {
+	return &RuntimeStats{}
+}
+
+// Stats returns the current resource usage. CPU usage is the percentage of
+// a core used since the previous call and is not reported by the first.
+func (r *RuntimeStats) Stats() []Stat {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	var lastPause uint64
+	if m.NumGC > 0 {
+		lastPause = m.PauseNs[(m.NumGC+255)%256]
+	}
+
+	rss, ok := residentMemory()
+	if !ok {
+		rss = m.Sys
+	}
+
+	stats := []Stat{
+		{Name: "goroutines", Unit: "goroutines", Value: float64(runtime.NumGoroutine())},
+		{Name: "memory_rss", Unit: "bytes", Value: float64(rss)},
+		{Name: "memory_heap", Unit: "bytes", Value: float64(m.HeapAlloc)},
+		{Name: "gc_pause", Unit: "ms", Value: float64(lastPause) / float64(time.Millisecond)},
+		{Name: "gc_count", Value: float64(m.NumGC), Counter: true},
+	}
+
+	if cpu, ok := r.cpuPercentage(); ok {
+		stats = append(stats, Stat{Name: "cpu", Unit: "percentage", Value: cpu})
+	}
+
+	return stats
+}
+
+func (r *RuntimeStats) cpuPercentage() (float64, bool) {
+	cpu, ok := processCPUTime()
+	if !ok {
+		return 0, false
+	}
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lastCPU, lastSample := r.lastCPU, r.lastSample
+	r.lastCPU, r.lastSample = cpu, now
+
+	if lastSample.IsZero() || !now.After(lastSample) {
+		return 0, false
+	}
+
+	return 100 * float64(cpu-lastCPU) / float64(now.Sub(lastSample)), true
+}
+
+// residentMemory returns the resident set size of the process in bytes. It
+// is only available on Linux.
+func residentMemory() (uint64, bool) {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, false
+	}
+
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return pages * uint64(os.Getpagesize()), true
+}
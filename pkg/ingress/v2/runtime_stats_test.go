@@ -0,0 +1,43 @@
+package v2_test
+
+import (
+	"runtime"
+
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RuntimeStats", func() {
+	statsByName := func(stats []ingress.Stat) map[string]ingress.Stat {
+		m := make(map[string]ingress.Stat)
+		for _, s := range stats {
+			m[s.Name] = s
+		}
+		return m
+	}
+
+	It("reports the process's memory, goroutines and garbage collection", func() {
+		runtime.GC()
+		stats := statsByName(ingress.NewRuntimeStats().Stats())
+
+		Expect(stats["goroutines"].Value).To(BeNumerically(">", 0))
+		Expect(stats["memory_rss"].Value).To(BeNumerically(">", 0))
+		Expect(stats["memory_rss"].Unit).To(Equal("bytes"))
+		Expect(stats["memory_heap"].Value).To(BeNumerically(">", 0))
+		Expect(stats).To(HaveKey("gc_pause"))
+		Expect(stats["gc_count"].Counter).To(BeTrue())
+		Expect(stats["gc_count"].Value).To(BeNumerically(">", 0))
+	})
+
+	It("reports CPU usage since the previous call", func() {
+		r := ingress.NewRuntimeStats()
+		Expect(statsByName(r.Stats())).ToNot(HaveKey("cpu"))
+
+		stats := statsByName(r.Stats())
+		Expect(stats).To(HaveKey("cpu"))
+		Expect(stats["cpu"].Value).To(BeNumerically(">=", 0))
+		Expect(stats["cpu"].Unit).To(Equal("percentage"))
+	})
+})
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package v2
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the user and system CPU time used by the process.
+func processCPUTime() (time.Duration, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, false
+	}
+
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), true
+}
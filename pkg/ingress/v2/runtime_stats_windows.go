@@ -0,0 +1,8 @@
+package v2
+
+import "time"
+
+// processCPUTime is not available on Windows.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -1,39 +1,183 @@
 package v2
 
 import (
-	"log"
+	"fmt"
 	"net"
+	"os"
+	"sync"
 
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
+var logger = logging.New("ingress")
+
+// ingressServiceName is the name that health checks use for the v2 ingress
+// API.
+const ingressServiceName = "loggregator.v2.Ingress"
+
 type Server struct {
-	addr string
-	rx   *Receiver
-	opts []grpc.ServerOption
+	network    string
+	addr       string
+	socketMode os.FileMode
+	rx         *Receiver
+	opts       []grpc.ServerOption
+	reflection bool
+
+	maxConns          int
+	connLimitRejected pulseemitter.CounterMetric
+
+	mu           sync.Mutex
+	grpcServer   *grpc.Server
+	healthServer *health.Server
+
+	// stopPending is set when Stop is called before Start has begun
+	// serving, so that Start returns rather than serving.
+	stopPending bool
 }
 
 func NewServer(addr string, rx *Receiver, opts ...grpc.ServerOption) *Server {
 	return &Server{
-		addr: addr,
-		rx:   rx,
-		opts: opts,
+		network: "tcp",
+		addr:    addr,
+		rx:      rx,
+		opts:    opts,
 	}
 }
 
-func (s *Server) Start() {
-	lis, err := net.Listen("tcp", s.addr)
+// NewUnixServer returns a Server that listens on a Unix domain socket at the
+// given path. Access to the socket is controlled by its file mode. A stale
+// socket left at the path by a previous process is removed when the server
+// starts.
+func NewUnixServer(path string, mode os.FileMode, rx *Receiver, opts ...grpc.ServerOption) *Server {
+	return &Server{
+		network:    "unix",
+		addr:       path,
+		socketMode: mode,
+		rx:         rx,
+		opts:       opts,
+	}
+}
+
+// EnableReflection registers the gRPC reflection service when the server
+// starts, so that tools such as grpcurl can discover and call the ingress
+// API. It is intended for debugging and must be called before Start.
+func (s *Server) EnableReflection() {
+	s.reflection = true
+}
+
+// LimitConnections closes connections accepted while max connections are
+// already open. It must be called before Start.
+func (s *Server) LimitConnections(max int, metricClient MetricClient) {
+	s.maxConns = max
+	s.connLimitRejected = metricClient.NewCounterMetric("connection_limit_rejections",
+		pulseemitter.WithVersion(2, 0),
+	)
+}
+
+// Start listens on the server's address and serves the v2 ingress API and
+// the standard gRPC health service. Health checks for the server, "", and
+// for "loggregator.v2.Ingress" report SERVING until Stop is called. It
+// blocks until Stop is called or returns an error if the server can not
+// listen or fails to serve.
+func (s *Server) Start() error {
+	lis, err := s.listen()
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		return fmt.Errorf("failed to listen: %s", err)
+	}
+	logger.Printf("grpc bound to: %s", lis.Addr())
+
+	if s.maxConns > 0 {
+		lis = newConnLimitListener(lis, s.maxConns, s.connLimitRejected)
 	}
-	log.Printf("grpc bound to: %s", lis.Addr())
 
 	grpcServer := grpc.NewServer(s.opts...)
 	loggregator_v2.RegisterIngressServer(grpcServer, s.rx)
 
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(ingressServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	if s.reflection {
+		reflection.Register(grpcServer)
+	}
+
+	s.mu.Lock()
+	if s.stopPending {
+		s.stopPending = false
+		s.mu.Unlock()
+		lis.Close()
+		return nil
+	}
+	s.grpcServer = grpcServer
+	s.healthServer = healthServer
+	s.mu.Unlock()
+
 	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+		// The server is no longer listening, unless it has since been
+		// stopped and started again.
+		s.mu.Lock()
+		if s.grpcServer == grpcServer {
+			s.grpcServer = nil
+			s.healthServer = nil
+		}
+		s.mu.Unlock()
+
+		return fmt.Errorf("failed to serve: %s", err)
 	}
+
+	return nil
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	if s.network != "unix" {
+		return net.Listen(s.network, s.addr)
+	}
+
+	if err := os.Remove(s.addr); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	lis, err := net.Listen("unix", s.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(s.addr, s.socketMode); err != nil {
+		lis.Close()
+		return nil, err
+	}
+
+	return lis, nil
+}
+
+// Listening reports whether the server is listening for connections.
+func (s *Server) Listening() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.grpcServer != nil
+}
+
+// Stop closes the listener and all open streams. If the server has not yet
+// begun serving, Start returns without serving.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.grpcServer == nil {
+		s.stopPending = true
+		return
+	}
+
+	s.healthServer.Shutdown()
+	s.grpcServer.Stop()
+	s.grpcServer = nil
+	s.healthServer = nil
 }
@@ -0,0 +1,211 @@
+package v2_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	It("reports whether it is listening", func() {
+		rx := ingress.NewReceiver(NewSpySetter(), testhelper.NewMetricClient(), newSpyHealthEndpointClient())
+		s := ingress.NewServer("127.0.0.1:0", rx)
+		Expect(s.Listening()).To(BeFalse())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.Start()
+		}()
+		Eventually(s.Listening).Should(BeTrue())
+
+		s.Stop()
+		Expect(s.Listening()).To(BeFalse())
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("does not serve when stopped before it starts", func() {
+		rx := ingress.NewReceiver(NewSpySetter(), testhelper.NewMetricClient(), newSpyHealthEndpointClient())
+		s := ingress.NewServer("127.0.0.1:0", rx)
+
+		s.Stop()
+
+		Expect(s.Start()).To(Succeed())
+		Expect(s.Listening()).To(BeFalse())
+	})
+
+	It("returns an error when it can not listen", func() {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer lis.Close()
+
+		rx := ingress.NewReceiver(NewSpySetter(), testhelper.NewMetricClient(), newSpyHealthEndpointClient())
+		s := ingress.NewServer(lis.Addr().String(), rx)
+
+		Expect(s.Start()).To(MatchError(ContainSubstring("failed to listen")))
+	})
+
+	Context("with a Unix domain socket", func() {
+		var (
+			dir       string
+			path      string
+			spySetter *SpySetter
+			s         *ingress.Server
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "ingress")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "agent.sock")
+
+			spySetter = NewSpySetter()
+			rx := ingress.NewReceiver(spySetter, testhelper.NewMetricClient(), newSpyHealthEndpointClient())
+			s = ingress.NewUnixServer(path, 0660, rx)
+		})
+
+		AfterEach(func() {
+			s.Stop()
+			os.RemoveAll(dir)
+		})
+
+		It("accepts envelopes over the socket", func() {
+			go s.Start()
+			Eventually(s.Listening).Should(BeTrue())
+
+			info, err := os.Stat(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode() & os.ModePerm).To(Equal(os.FileMode(0660)))
+
+			conn, err := grpc.Dial(
+				path,
+				grpc.WithInsecure(),
+				grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", addr)
+				}),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			client := loggregator_v2.NewIngressClient(conn)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err = client.Send(ctx, &loggregator_v2.EnvelopeBatch{
+				Batch: []*loggregator_v2.Envelope{{SourceId: "some-id"}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			var e *loggregator_v2.Envelope
+			Eventually(spySetter.envelopes).Should(Receive(&e))
+			Expect(e.SourceId).To(Equal("some-id"))
+		})
+
+		It("serves the gRPC health service", func() {
+			go s.Start()
+			Eventually(s.Listening).Should(BeTrue())
+
+			conn, err := grpc.Dial(
+				path,
+				grpc.WithInsecure(),
+				grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", addr)
+				}),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			client := healthpb.NewHealthClient(conn)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			for _, service := range []string{"", "loggregator.v2.Ingress"} {
+				resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.GetStatus()).To(Equal(healthpb.HealthCheckResponse_SERVING))
+			}
+
+			_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+			Expect(status.Code(err)).To(Equal(codes.NotFound))
+		})
+
+		Describe("reflection", func() {
+			listServices := func() ([]string, error) {
+				conn, err := grpc.Dial(
+					path,
+					grpc.WithInsecure(),
+					grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+						var d net.Dialer
+						return d.DialContext(ctx, "unix", addr)
+					}),
+				)
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+				Expect(err).ToNot(HaveOccurred())
+
+				err = stream.Send(&reflectionpb.ServerReflectionRequest{
+					MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				resp, err := stream.Recv()
+				if err != nil {
+					return nil, err
+				}
+
+				var names []string
+				for _, svc := range resp.GetListServicesResponse().GetService() {
+					names = append(names, svc.GetName())
+				}
+
+				return names, nil
+			}
+
+			It("is not served by default", func() {
+				go s.Start()
+				Eventually(s.Listening).Should(BeTrue())
+
+				_, err := listServices()
+				Expect(status.Code(err)).To(Equal(codes.Unimplemented))
+			})
+
+			It("lists the ingress API when enabled", func() {
+				s.EnableReflection()
+				go s.Start()
+				Eventually(s.Listening).Should(BeTrue())
+
+				names, err := listServices()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(names).To(ContainElement("loggregator.v2.Ingress"))
+				Expect(names).To(ContainElement("grpc.health.v1.Health"))
+			})
+		})
+
+		It("replaces a stale socket", func() {
+			Expect(ioutil.WriteFile(path, nil, 0600)).To(Succeed())
+
+			go s.Start()
+			Eventually(s.Listening).Should(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,85 @@
+package v2
+
+import (
+	"strconv"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// envelopeSizeBuckets are the largest sizes, in bytes, of the envelopes
+// counted in each bucket of the size distribution. Larger envelopes are
+// counted in a final "larger" bucket.
+var envelopeSizeBuckets = []int{256, 1024, 4096, 16384, 65536, 262144, 1048576}
+
+// sizeMetrics counts the bytes received and the number of envelopes in
+// each size bucket.
+type sizeMetrics struct {
+	bytesMetric   pulseemitter.CounterMetric
+	bucketMetrics []pulseemitter.CounterMetric
+}
+
+func newSizeMetrics(m MetricClient) *sizeMetrics {
+	// metric-documentation-v2: (loggregator.metron.ingress_bytes) Number of
+	// bytes of envelopes received over the v2 gRPC API.
+	bytesMetric := m.NewCounterMetric("ingress_bytes",
+		pulseemitter.WithVersion(2, 0),
+	)
+
+	var bucketMetrics []pulseemitter.CounterMetric
+	for i := 0; i <= len(envelopeSizeBuckets); i++ {
+		size := "larger"
+		if i < len(envelopeSizeBuckets) {
+			size = strconv.Itoa(envelopeSizeBuckets[i])
+		}
+
+		// metric-documentation-v2: (loggregator.metron.ingress_envelope_size)
+		// Number of envelopes received no larger than the size tag in bytes
+		// and larger than the next smallest size.
+		bucketMetrics = append(bucketMetrics, m.NewCounterMetric("ingress_envelope_size",
+			pulseemitter.WithVersion(2, 0),
+			pulseemitter.WithTags(map[string]string{"size": size}),
+		))
+	}
+
+	return &sizeMetrics{
+		bytesMetric:   bytesMetric,
+		bucketMetrics: bucketMetrics,
+	}
+}
+
+// track counts the envelopes by size and adds their bytes to the total.
+func (s *sizeMetrics) track(envelopes []*loggregator_v2.Envelope) {
+	var total int
+	counts := make([]uint64, len(s.bucketMetrics))
+	for _, e := range envelopes {
+		if e == nil {
+			continue
+		}
+
+		size := proto.Size(e)
+		total += size
+		counts[sizeBucket(size)]++
+	}
+
+	if total > 0 {
+		s.bytesMetric.Increment(uint64(total))
+	}
+
+	for i, n := range counts {
+		if n > 0 {
+			s.bucketMetrics[i].Increment(n)
+		}
+	}
+}
+
+func sizeBucket(size int) int {
+	for i, max := range envelopeSizeBuckets {
+		if size <= max {
+			return i
+		}
+	}
+
+	return len(envelopeSizeBuckets)
+}
@@ -0,0 +1,270 @@
+package v2
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+)
+
+// Source is an ingress that writes envelopes into the agent's shared
+// envelope buffer.
+type Source interface {
+	// Start begins accepting envelopes. It blocks until Stop is called, when
+	// it returns nil, or until the source fails, such as when it can not
+	// listen on its address, when it returns the error.
+	Start() error
+
+	// Stop stops accepting envelopes and causes Start to return. A Start
+	// that has not yet begun accepting envelopes returns without doing so.
+	Stop()
+}
+
+// sourceStopTimeout is how long a source is waited for to stop before it is
+// restarted or the agent moves on.
+const sourceStopTimeout = 5 * time.Second
+
+// SourceFunc builds a Source that writes all of its envelopes to the given
+// DataSetter.
+type SourceFunc func(DataSetter) Source
+
+// SourceManager owns the lifecycle of a set of named sources. Each source
+// writes into the same DataSetter and has its ingress counted separately.
+type SourceManager struct {
+	dataSetter   DataSetter
+	metricClient MetricClient
+
+	mu      sync.Mutex
+	names   []string
+	sources map[string]*managedSource
+}
+
+type managedSource struct {
+	source   Source
+	running  bool
+	disabled bool
+
+	// runs is incremented each time the source is started so that a
+	// previous run returning does not mark a newer run as stopped.
+	runs int
+
+	// exited is closed when the current run's Start returns.
+	exited chan struct{}
+}
+
+// NewSourceManager returns a SourceManager that writes envelopes from each
+// source to the given DataSetter.
+func NewSourceManager(s DataSetter, m MetricClient) *SourceManager {
+	return &SourceManager{
+		dataSetter:   s,
+		metricClient: m,
+		sources:      make(map[string]*managedSource),
+	}
+}
+
+// Add builds a source with the given name. The source is not started until
+// Start is called. Adding a name more than once panics.
+func (m *SourceManager) Add(name string, f SourceFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sources[name]; ok {
+		logger.Panicf("source %s already added", name)
+	}
+
+	// metric-documentation-v2: (loggregator.metron.source_ingress) The number
+	// of envelopes received by an individual ingress source.
+	ingressMetric := m.metricClient.NewCounterMetric("source_ingress",
+		pulseemitter.WithVersion(2, 0),
+		pulseemitter.WithTags(map[string]string{"source": name}),
+	)
+
+	m.names = append(m.names, name)
+	m.sources[name] = &managedSource{
+		source: f(&countingSetter{
+			dataSetter: m.dataSetter,
+			metric:     ingressMetric,
+		}),
+	}
+}
+
+// Start starts each enabled source that is not already running in its own
+// goroutine.
+func (m *SourceManager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, name := range m.names {
+		ms := m.sources[name]
+		if ms.disabled {
+			continue
+		}
+
+		m.start(name, ms)
+	}
+}
+
+// Stop stops all running sources.
+func (m *SourceManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, name := range m.names {
+		m.stop(name, m.sources[name])
+	}
+}
+
+// Enable starts the named source if it is not running. A disabled source is
+// started again by Start.
+func (m *SourceManager) Enable(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	ms.disabled = false
+	m.start(name, ms)
+
+	return nil
+}
+
+// Disable stops the named source and keeps it from being started by Start
+// until it is enabled again.
+func (m *SourceManager) Disable(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	ms.disabled = true
+	m.stop(name, ms)
+
+	return nil
+}
+
+// Restart stops the named source if it is running and starts it again. A
+// disabled source is enabled.
+func (m *SourceManager) Restart(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms, err := m.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	ms.disabled = false
+	m.stop(name, ms)
+	m.start(name, ms)
+
+	return nil
+}
+
+// Sources returns the names of all sources in the order they were added.
+func (m *SourceManager) Sources() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, len(m.names))
+	copy(names, m.names)
+
+	return names
+}
+
+// Running reports whether the named source is running.
+func (m *SourceManager) Running(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms, ok := m.sources[name]
+	return ok && ms.running
+}
+
+// Enabled reports whether the named source is enabled.
+func (m *SourceManager) Enabled(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms, ok := m.sources[name]
+	return ok && !ms.disabled
+}
+
+func (m *SourceManager) lookup(name string) (*managedSource, error) {
+	ms, ok := m.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source: %s", name)
+	}
+
+	return ms, nil
+}
+
+func (m *SourceManager) start(name string, ms *managedSource) {
+	if ms.running {
+		return
+	}
+	ms.running = true
+	ms.runs++
+	run := ms.runs
+	exited := make(chan struct{})
+	ms.exited = exited
+
+	l := logger.With(logging.Fields{"source": name})
+	l.Printf("starting source %s", name)
+
+	go func() {
+		err := ms.source.Start()
+		close(exited)
+		if err != nil {
+			l.Errorf("source %s failed: %s", name, err)
+		} else {
+			l.Printf("source %s stopped", name)
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if ms.runs == run {
+			ms.running = false
+		}
+	}()
+}
+
+func (m *SourceManager) stop(name string, ms *managedSource) {
+	if !ms.running {
+		return
+	}
+	ms.running = false
+
+	l := logger.With(logging.Fields{"source": name})
+	l.Printf("stopping source %s", name)
+	ms.source.Stop()
+
+	// Start is waited for so that a restarted source does not run alongside
+	// the run being stopped or contend with it for its address.
+	timer := time.NewTimer(sourceStopTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ms.exited:
+	case <-timer.C:
+		l.Warnf("source %s did not stop within %s", name, sourceStopTimeout)
+	}
+}
+
+type countingSetter struct {
+	dataSetter DataSetter
+	metric     pulseemitter.CounterMetric
+}
+
+func (s *countingSetter) Set(e *loggregator_v2.Envelope) {
+	s.dataSetter.Set(e)
+	s.metric.Increment(1)
+}
@@ -0,0 +1,252 @@
+package v2_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SourceManager", func() {
+	var (
+		spySetter    *SpySetter
+		metricClient *testhelper.SpyMetricClient
+		m            *ingress.SourceManager
+	)
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		metricClient = testhelper.NewMetricClient()
+		m = ingress.NewSourceManager(spySetter, metricClient)
+	})
+
+	It("writes envelopes from each source to the data setter", func() {
+		src := newSpySource()
+		m.Add("some-source", src.build)
+		m.Start()
+
+		Eventually(src.setter).ShouldNot(BeNil())
+		src.setter().Set(&loggregator_v2.Envelope{SourceId: "some-id"})
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("some-id"))
+		Expect(metricClient.GetMetric("source_ingress").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("starts and stops each source", func() {
+		a := newSpySource()
+		b := newSpySource()
+		m.Add("a", a.build)
+		m.Add("b", b.build)
+
+		Expect(m.Sources()).To(Equal([]string{"a", "b"}))
+		Expect(m.Running("a")).To(BeFalse())
+
+		m.Start()
+		Eventually(a.starts).Should(Equal(1))
+		Eventually(b.starts).Should(Equal(1))
+		Expect(m.Running("a")).To(BeTrue())
+		Expect(m.Running("b")).To(BeTrue())
+
+		m.Stop()
+		Eventually(a.stops).Should(Equal(1))
+		Eventually(b.stops).Should(Equal(1))
+		Expect(m.Running("a")).To(BeFalse())
+		Expect(m.Running("b")).To(BeFalse())
+	})
+
+	It("does not start a running source twice", func() {
+		src := newSpySource()
+		m.Add("some-source", src.build)
+
+		m.Start()
+		m.Start()
+
+		Eventually(src.starts).Should(Equal(1))
+		Consistently(src.starts).Should(Equal(1))
+	})
+
+	It("marks a source as not running when it returns on its own", func() {
+		src := newSpySource()
+		m.Add("some-source", src.build)
+		m.Start()
+		Eventually(src.starts).Should(Equal(1))
+
+		src.Stop()
+
+		Eventually(func() bool { return m.Running("some-source") }).Should(BeFalse())
+	})
+
+	It("disables and enables a source", func() {
+		src := newSpySource()
+		m.Add("some-source", src.build)
+		m.Start()
+		Eventually(src.starts).Should(Equal(1))
+
+		Expect(m.Disable("some-source")).To(Succeed())
+		Expect(src.stops()).To(Equal(1))
+		Expect(m.Running("some-source")).To(BeFalse())
+		Expect(m.Enabled("some-source")).To(BeFalse())
+
+		m.Start()
+		Consistently(src.starts).Should(Equal(1))
+
+		Expect(m.Enable("some-source")).To(Succeed())
+		Eventually(src.starts).Should(Equal(2))
+		Expect(m.Running("some-source")).To(BeTrue())
+		Expect(m.Enabled("some-source")).To(BeTrue())
+	})
+
+	It("restarts a source", func() {
+		src := newSpySource()
+		m.Add("some-source", src.build)
+		m.Start()
+		Eventually(src.starts).Should(Equal(1))
+
+		Expect(m.Restart("some-source")).To(Succeed())
+
+		Expect(src.stops()).To(Equal(1))
+		Eventually(src.starts).Should(Equal(2))
+		Consistently(func() bool { return m.Running("some-source") }).Should(BeTrue())
+	})
+
+	It("stops a source that has not yet begun running", func() {
+		src := newSpySource()
+		src.begin = make(chan struct{})
+		m.Add("some-source", src.build)
+		m.Start()
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(src.begin)
+		}()
+		Expect(m.Disable("some-source")).To(Succeed())
+
+		Expect(src.stops()).To(Equal(1))
+		Consistently(src.starts).Should(Equal(0))
+		Expect(m.Running("some-source")).To(BeFalse())
+	})
+
+	It("marks a source that fails to start as not running", func() {
+		src := newSpySource()
+		src.err = errors.New("address in use")
+		m.Add("some-source", src.build)
+		m.Start()
+
+		Eventually(func() bool { return m.Running("some-source") }).Should(BeFalse())
+	})
+
+	It("returns an error for an unknown source", func() {
+		Expect(m.Enable("unknown")).ToNot(Succeed())
+		Expect(m.Disable("unknown")).ToNot(Succeed())
+		Expect(m.Restart("unknown")).ToNot(Succeed())
+	})
+
+	It("panics when a name is added twice", func() {
+		m.Add("some-source", newSpySource().build)
+
+		Expect(func() {
+			m.Add("some-source", newSpySource().build)
+		}).To(Panic())
+	})
+
+	It("returns false for an unknown source", func() {
+		Expect(m.Running("unknown")).To(BeFalse())
+	})
+})
+
+type spySource struct {
+	mu       sync.Mutex
+	s        ingress.DataSetter
+	startCnt int
+	stopCnt  int
+	done     chan struct{}
+	pending  bool
+
+	// begin, if set, is waited on before Start begins running.
+	begin chan struct{}
+
+	// err, if set, is returned by Start straight away.
+	err error
+}
+
+func newSpySource() *spySource {
+	return &spySource{}
+}
+
+func (s *spySource) build(ds ingress.DataSetter) ingress.Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s = ds
+
+	return s
+}
+
+func (s *spySource) Start() error {
+	s.mu.Lock()
+	begin := s.begin
+	s.mu.Unlock()
+	if begin != nil {
+		<-begin
+	}
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return s.err
+	}
+	if s.pending {
+		s.pending = false
+		s.mu.Unlock()
+		return nil
+	}
+	s.startCnt++
+	done := make(chan struct{})
+	s.done = done
+	s.mu.Unlock()
+
+	<-done
+	return nil
+}
+
+func (s *spySource) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopCnt++
+
+	if s.done == nil {
+		s.pending = true
+		return
+	}
+
+	close(s.done)
+	s.done = nil
+}
+
+func (s *spySource) setter() ingress.DataSetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.s
+}
+
+func (s *spySource) starts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.startCnt
+}
+
+func (s *spySource) stops() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stopCnt
+}
@@ -0,0 +1,441 @@
+package v2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// maxSyslogMessageBytes is the largest syslog message the SyslogSource
+// accepts. Connections sending larger messages are closed.
+const maxSyslogMessageBytes = 64 * 1024
+
+// syslogTagsSDID is the structured data ID whose parameters are set as
+// envelope tags without a prefix. It matches the element written by the
+// syslog egress so that tags survive a round trip.
+const syslogTagsSDID = "tags@47450"
+
+// SyslogSource is a Source that accepts RFC 5424 syslog messages over TCP,
+// or TLS if configured, and sets them as Log envelopes. Messages may be
+// framed by octet counting or terminated by a newline (RFC 6587).
+//
+// The APP-NAME of a message is its source ID and the PROCID its instance ID.
+// The HOSTNAME is set as the hostname tag, and the parameters of structured
+// data elements as tags named "<SD-ID>.<PARAM-NAME>", except for the
+// parameters of the tags@47450 element which keep their names. Messages
+// with a severity of error or worse are ERR logs.
+type SyslogSource struct {
+	addr            string
+	tlsConfig       *tls.Config
+	defaultSourceID string
+	setter          DataSetter
+
+	ingressMetric pulseemitter.CounterMetric
+	invalidMetric pulseemitter.CounterMetric
+
+	mu    sync.Mutex
+	lis   net.Listener
+	conns map[net.Conn]struct{}
+
+	// stopPending is set when Stop is called before Start has begun
+	// accepting, so that Start returns rather than accepting.
+	stopPending bool
+}
+
+// SyslogSourceOption configures a SyslogSource.
+type SyslogSourceOption func(*SyslogSource)
+
+// WithSyslogSourceTLSConfig serves syslog over TLS with the given config.
+func WithSyslogSourceTLSConfig(c *tls.Config) SyslogSourceOption {
+	return func(s *SyslogSource) {
+		s.tlsConfig = c
+	}
+}
+
+// WithSyslogDefaultSourceID sets the source ID of messages without an
+// APP-NAME. It defaults to "syslog".
+func WithSyslogDefaultSourceID(id string) SyslogSourceOption {
+	return func(s *SyslogSource) {
+		s.defaultSourceID = id
+	}
+}
+
+// NewSyslogSource returns a SyslogSource that listens on the given address
+// once started.
+func NewSyslogSource(addr string, setter DataSetter, m MetricClient, opts ...SyslogSourceOption) *SyslogSource {
+	s := &SyslogSource{
+		addr:            addr,
+		defaultSourceID: "syslog",
+		setter:          setter,
+		conns:           make(map[net.Conn]struct{}),
+
+		// metric-documentation-v2: (loggregator.metron.ingress) The number of
+		// received syslog messages.
+		ingressMetric: m.NewCounterMetric("ingress",
+			pulseemitter.WithVersion(2, 0),
+			pulseemitter.WithTags(map[string]string{"protocol": "syslog"}),
+		),
+
+		// metric-documentation-v2: (loggregator.metron.invalid_syslog_messages)
+		// The number of received syslog messages that could not be parsed.
+		invalidMetric: m.NewCounterMetric("invalid_syslog_messages",
+			pulseemitter.WithVersion(2, 0),
+		),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// Start listens on the source's address and accepts connections until Stop
+// is called. It returns an error if the source can not listen.
+func (s *SyslogSource) Start() error {
+	lis, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %s", err)
+	}
+	if s.tlsConfig != nil {
+		lis = tls.NewListener(lis, s.tlsConfig)
+	}
+	logger.Printf("syslog bound to: %s", lis.Addr())
+
+	s.mu.Lock()
+	if s.stopPending {
+		s.stopPending = false
+		s.mu.Unlock()
+		lis.Close()
+		return nil
+	}
+	s.lis = lis
+	s.mu.Unlock()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			logger.Debugf("syslog source stopped accepting: %s", err)
+			return nil
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+// Addr returns the address the source is listening on or nil if it is not
+// listening.
+func (s *SyslogSource) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lis == nil {
+		return nil
+	}
+
+	return s.lis.Addr()
+}
+
+// Stop closes the listener and all open connections and causes Start to
+// return. If the source has not yet begun accepting, Start returns without
+// accepting.
+func (s *SyslogSource) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lis == nil {
+		s.stopPending = true
+		return
+	}
+
+	s.lis.Close()
+	s.lis = nil
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *SyslogSource) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	r := bufio.NewReaderSize(conn, maxSyslogMessageBytes)
+	for {
+		msg, err := readSyslogFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				logger.Debugf("closing syslog connection from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(msg) == 0 {
+			continue
+		}
+
+		e, err := parseSyslog(msg, s.defaultSourceID)
+		if err != nil {
+			s.invalidMetric.Increment(1)
+			logger.Debugf("invalid syslog message from %s: %s", conn.RemoteAddr(), err)
+			continue
+		}
+
+		s.setter.Set(e)
+		s.ingressMetric.Increment(1)
+	}
+}
+
+// readSyslogFrame returns the next message on the connection. A frame
+// beginning with a digit is octet counted and any other is terminated by a
+// newline.
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if b[0] < '0' || b[0] > '9' {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, errors.New("message is too large")
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+
+	prefix, err := r.ReadSlice(' ')
+	if err != nil {
+		return nil, fmt.Errorf("invalid octet count: %s", err)
+	}
+	n, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+	if err != nil || n > maxSyslogMessageBytes {
+		return nil, fmt.Errorf("invalid octet count %q", prefix[:len(prefix)-1])
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// parseSyslog converts an RFC 5424 message to a Log envelope.
+func parseSyslog(msg []byte, defaultSourceID string) (*loggregator_v2.Envelope, error) {
+	p := &syslogParser{buf: msg}
+
+	pri, err := p.priority()
+	if err != nil {
+		return nil, err
+	}
+	if !p.consume("1 ") {
+		return nil, errors.New("unsupported syslog version")
+	}
+
+	var fields [5]string
+	for i := range fields {
+		if fields[i], err = p.headerField(); err != nil {
+			return nil, err
+		}
+	}
+	timestamp, hostname, appName, procID := fields[0], fields[1], fields[2], fields[3]
+
+	e := &loggregator_v2.Envelope{
+		SourceId:   appName,
+		InstanceId: procID,
+		Timestamp:  time.Now().UnixNano(),
+		Tags:       make(map[string]string),
+	}
+	if e.SourceId == "" {
+		e.SourceId = defaultSourceID
+	}
+	if timestamp != "" {
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		e.Timestamp = t.UnixNano()
+	}
+	if hostname != "" {
+		e.Tags["hostname"] = hostname
+	}
+
+	if err := p.structuredData(e.Tags); err != nil {
+		return nil, err
+	}
+
+	payload := p.rest()
+	payload = bytes.TrimPrefix(payload, []byte("\xef\xbb\xbf"))
+
+	logType := loggregator_v2.Log_OUT
+	if pri%8 <= 3 {
+		logType = loggregator_v2.Log_ERR
+	}
+	e.Message = &loggregator_v2.Envelope_Log{
+		Log: &loggregator_v2.Log{
+			Payload: payload,
+			Type:    logType,
+		},
+	}
+
+	return e, nil
+}
+
+type syslogParser struct {
+	buf []byte
+	pos int
+}
+
+func (p *syslogParser) consume(s string) bool {
+	if !bytes.HasPrefix(p.buf[p.pos:], []byte(s)) {
+		return false
+	}
+	p.pos += len(s)
+
+	return true
+}
+
+func (p *syslogParser) priority() (int, error) {
+	if !p.consume("<") {
+		return 0, errors.New("missing priority")
+	}
+
+	end := bytes.IndexByte(p.buf[p.pos:], '>')
+	if end < 1 || end > 3 {
+		return 0, errors.New("invalid priority")
+	}
+	pri, err := strconv.Atoi(string(p.buf[p.pos : p.pos+end]))
+	if err != nil || pri > 191 {
+		return 0, errors.New("invalid priority")
+	}
+	p.pos += end + 1
+
+	return pri, nil
+}
+
+// headerField returns the next space terminated header field, or an empty
+// string for the nil value.
+func (p *syslogParser) headerField() (string, error) {
+	end := bytes.IndexByte(p.buf[p.pos:], ' ')
+	if end < 1 {
+		return "", errors.New("truncated header")
+	}
+	v := string(p.buf[p.pos : p.pos+end])
+	p.pos += end + 1
+
+	if v == "-" {
+		return "", nil
+	}
+
+	return v, nil
+}
+
+// structuredData sets the parameters of the structured data elements as
+// tags.
+func (p *syslogParser) structuredData(tags map[string]string) error {
+	if p.consume("-") {
+		return nil
+	}
+
+	for p.consume("[") {
+		end := bytes.IndexAny(p.buf[p.pos:], " ]")
+		if end < 1 {
+			return errors.New("invalid structured data ID")
+		}
+		id := string(p.buf[p.pos : p.pos+end])
+		p.pos += end
+
+		for p.consume(" ") {
+			eq := bytes.IndexByte(p.buf[p.pos:], '=')
+			if eq < 1 {
+				return errors.New("invalid structured data parameter")
+			}
+			name := string(p.buf[p.pos : p.pos+eq])
+			p.pos += eq + 1
+
+			value, err := p.paramValue()
+			if err != nil {
+				return err
+			}
+
+			if id == syslogTagsSDID {
+				tags[name] = value
+				continue
+			}
+			tags[id+"."+name] = value
+		}
+
+		if !p.consume("]") {
+			return errors.New("unterminated structured data element")
+		}
+	}
+
+	if p.pos == 0 || p.buf[p.pos-1] != ']' {
+		return errors.New("invalid structured data")
+	}
+
+	return nil
+}
+
+// paramValue returns the next quoted parameter value with its escapes
+// removed.
+func (p *syslogParser) paramValue() (string, error) {
+	if !p.consume(`"`) {
+		return "", errors.New("unquoted structured data value")
+	}
+
+	var v []byte
+	for p.pos < len(p.buf) {
+		c := p.buf[p.pos]
+		p.pos++
+
+		switch c {
+		case '"':
+			return string(v), nil
+		case '\\':
+			if p.pos < len(p.buf) {
+				next := p.buf[p.pos]
+				if next == '"' || next == '\\' || next == ']' {
+					c = next
+					p.pos++
+				}
+			}
+		}
+		v = append(v, c)
+	}
+
+	return "", errors.New("unterminated structured data value")
+}
+
+// rest returns the MSG part of the message, after the structured data.
+func (p *syslogParser) rest() []byte {
+	if !p.consume(" ") {
+		return nil
+	}
+
+	msg := make([]byte, len(p.buf)-p.pos)
+	copy(msg, p.buf[p.pos:])
+
+	return msg
+}
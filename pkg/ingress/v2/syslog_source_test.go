@@ -0,0 +1,133 @@
+package v2_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SyslogSource", func() {
+	var (
+		spySetter    *SpySetter
+		metricClient *testhelper.SpyMetricClient
+		s            *ingress.SyslogSource
+	)
+
+	start := func(opts ...ingress.SyslogSourceOption) {
+		s = ingress.NewSyslogSource("127.0.0.1:0", spySetter, metricClient, opts...)
+		go s.Start()
+		Eventually(s.Addr).ShouldNot(BeNil())
+	}
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+
+		return conn
+	}
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		metricClient = testhelper.NewMetricClient()
+	})
+
+	AfterEach(func() {
+		s.Stop()
+	})
+
+	It("converts newline terminated messages to log envelopes", func() {
+		start()
+		conn := dial()
+		defer conn.Close()
+
+		fmt.Fprint(conn, "<14>1 2019-03-01T12:00:00.5Z some-host some-app 3 - - hello\n")
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("some-app"))
+		Expect(e.InstanceId).To(Equal("3"))
+		Expect(e.Timestamp).To(Equal(time.Date(2019, 3, 1, 12, 0, 0, 5e8, time.UTC).UnixNano()))
+		Expect(e.Tags).To(Equal(map[string]string{"hostname": "some-host"}))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("hello")))
+		Expect(e.GetLog().GetType()).To(Equal(loggregator_v2.Log_OUT))
+		Expect(metricClient.GetMetric("ingress").Delta()).To(Equal(uint64(1)))
+	})
+
+	It("converts octet counted messages and tags them with their structured data", func() {
+		start()
+		conn := dial()
+		defer conn.Close()
+
+		msg := `<11>1 - - - - - [tags@47450 job="router" az="z1"][origin@123 ip="10.0.0.1" note="a \"b\" \]"] failed`
+		fmt.Fprintf(conn, "%d %s", len(msg), msg)
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("syslog"))
+		Expect(e.Timestamp).ToNot(BeZero())
+		Expect(e.Tags).To(Equal(map[string]string{
+			"job":             "router",
+			"az":              "z1",
+			"origin@123.ip":   "10.0.0.1",
+			"origin@123.note": `a "b" ]`,
+		}))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("failed")))
+		Expect(e.GetLog().GetType()).To(Equal(loggregator_v2.Log_ERR))
+	})
+
+	It("uses the default source ID for messages without an app name", func() {
+		start(ingress.WithSyslogDefaultSourceID("system"))
+		conn := dial()
+		defer conn.Close()
+
+		fmt.Fprint(conn, "<14>1 - host - - - -\n")
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("system"))
+		Expect(e.GetLog().GetPayload()).To(BeEmpty())
+	})
+
+	It("skips invalid messages and keeps reading", func() {
+		start()
+		conn := dial()
+		defer conn.Close()
+
+		fmt.Fprint(conn, "not syslog\n")
+		fmt.Fprint(conn, "<14>1 - - app - - [bad hello\n")
+		fmt.Fprint(conn, "<14>1 - - app - - - ok\n")
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("ok")))
+		Expect(metricClient.GetMetric("invalid_syslog_messages").Delta()).To(Equal(uint64(2)))
+	})
+
+	It("accepts messages over TLS", func() {
+		tlsConfig, err := plumbing.NewServerTLSConfig(
+			testhelper.Cert("localhost.crt"),
+			testhelper.Cert("localhost.key"),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		start(ingress.WithSyslogSourceTLSConfig(tlsConfig))
+
+		conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		fmt.Fprint(conn, "<14>1 - - app - - - secure\n")
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("secure")))
+	})
+})
@@ -0,0 +1,109 @@
+package v2
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// maxTalkers is the number of distinct source IDs a TalkerCounter tracks.
+// Envelopes from source IDs seen after the limit is reached are counted
+// under OtherTalkers.
+const maxTalkers = 10000
+
+// OtherTalkers is the source ID reported for envelopes from source IDs that
+// are not tracked individually.
+const OtherTalkers = "other"
+
+// TalkerCount is the number of envelopes received from a source ID.
+type TalkerCount struct {
+	SourceID string `json:"source_id"`
+	Count    uint64 `json:"count"`
+}
+
+// TalkerCounter is a DataSetter that counts envelopes by source ID before
+// passing them to the next DataSetter.
+type TalkerCounter struct {
+	total uint64
+
+	next DataSetter
+
+	mu     sync.RWMutex
+	counts map[string]*uint64
+}
+
+// NewTalkerCounter returns a TalkerCounter that writes to next.
+func NewTalkerCounter(next DataSetter) *TalkerCounter {
+	return &TalkerCounter{
+		next:   next,
+		counts: make(map[string]*uint64),
+	}
+}
+
+// Set counts the envelope and passes it to the next DataSetter.
+func (t *TalkerCounter) Set(e *loggregator_v2.Envelope) {
+	atomic.AddUint64(t.counter(e.GetSourceId()), 1)
+	atomic.AddUint64(&t.total, 1)
+	t.next.Set(e)
+}
+
+// Total returns the number of envelopes counted from every source ID.
+func (t *TalkerCounter) Total() uint64 {
+	return atomic.LoadUint64(&t.total)
+}
+
+func (t *TalkerCounter) counter(id string) *uint64 {
+	t.mu.RLock()
+	c, ok := t.counts[id]
+	t.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.counts[id]; ok {
+		return c
+	}
+
+	if len(t.counts) >= maxTalkers {
+		id = OtherTalkers
+		if c, ok := t.counts[id]; ok {
+			return c
+		}
+	}
+
+	c = new(uint64)
+	t.counts[id] = c
+
+	return c
+}
+
+// Top returns the n source IDs with the most envelopes, most first.
+func (t *TalkerCounter) Top(n int) []TalkerCount {
+	t.mu.RLock()
+	counts := make([]TalkerCount, 0, len(t.counts))
+	for id, c := range t.counts {
+		counts = append(counts, TalkerCount{
+			SourceID: id,
+			Count:    atomic.LoadUint64(c),
+		})
+	}
+	t.mu.RUnlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count == counts[j].Count {
+			return counts[i].SourceID < counts[j].SourceID
+		}
+		return counts[i].Count > counts[j].Count
+	})
+
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+
+	return counts
+}
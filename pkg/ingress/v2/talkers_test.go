@@ -0,0 +1,27 @@
+package v2_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TalkerCounter", func() {
+	It("passes envelopes on and reports the top source IDs", func() {
+		spySetter := NewSpySetter()
+		t := ingress.NewTalkerCounter(spySetter)
+
+		for _, id := range []string{"a", "b", "b", "c", "c", "c"} {
+			t.Set(&loggregator_v2.Envelope{SourceId: id})
+		}
+
+		Expect(spySetter.envelopes).To(HaveLen(6))
+		Expect(t.Top(2)).To(Equal([]ingress.TalkerCount{
+			{SourceID: "c", Count: 3},
+			{SourceID: "b", Count: 2},
+		}))
+		Expect(t.Total()).To(Equal(uint64(6)))
+	})
+})
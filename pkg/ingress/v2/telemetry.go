@@ -0,0 +1,123 @@
+package v2
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// Stat is a runtime value the agent reports about itself. Counters report
+// their running total as Value.
+type Stat struct {
+	Name    string
+	Unit    string
+	Value   float64
+	Counter bool
+	Tags    map[string]string
+}
+
+// StatsFunc returns the agent's current runtime stats.
+type StatsFunc func() []Stat
+
+// TelemetrySource is a Source that periodically writes the agent's runtime
+// stats as v2 gauge and counter envelopes.
+type TelemetrySource struct {
+	setter   DataSetter
+	sourceID string
+	interval time.Duration
+	stats    StatsFunc
+
+	mu          sync.Mutex
+	done        chan struct{}
+	stopPending bool
+}
+
+// NewTelemetrySource returns a TelemetrySource that writes the result of
+// stats to the DataSetter every interval.
+func NewTelemetrySource(
+	s DataSetter,
+	sourceID string,
+	interval time.Duration,
+	stats StatsFunc,
+) *TelemetrySource {
+	return &TelemetrySource{
+		setter:   s,
+		sourceID: sourceID,
+		interval: interval,
+		stats:    stats,
+	}
+}
+
+// Start writes the stats every interval. It blocks until Stop is called.
+func (s *TelemetrySource) Start() error {
+	done := make(chan struct{})
+	s.mu.Lock()
+	if s.stopPending {
+		s.stopPending = false
+		s.mu.Unlock()
+		return nil
+	}
+	s.done = done
+	s.mu.Unlock()
+
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-t.C:
+			s.emit()
+		}
+	}
+}
+
+// Stop causes Start to return. If the source has not yet begun, Start
+// returns straight away.
+func (s *TelemetrySource) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done == nil {
+		s.stopPending = true
+		return
+	}
+
+	close(s.done)
+	s.done = nil
+}
+
+func (s *TelemetrySource) emit() {
+	now := time.Now().UnixNano()
+	for _, st := range s.stats() {
+		e := &loggregator_v2.Envelope{
+			Timestamp: now,
+			SourceId:  s.sourceID,
+			Tags:      make(map[string]string, len(st.Tags)),
+		}
+		for k, v := range st.Tags {
+			e.Tags[k] = v
+		}
+
+		if st.Counter {
+			e.Message = &loggregator_v2.Envelope_Counter{
+				Counter: &loggregator_v2.Counter{
+					Name:  st.Name,
+					Total: uint64(st.Value),
+				},
+			}
+		} else {
+			e.Message = &loggregator_v2.Envelope_Gauge{
+				Gauge: &loggregator_v2.Gauge{
+					Metrics: map[string]*loggregator_v2.GaugeValue{
+						st.Name: {Unit: st.Unit, Value: st.Value},
+					},
+				},
+			}
+		}
+
+		s.setter.Set(e)
+	}
+}
@@ -0,0 +1,60 @@
+package v2_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TelemetrySource", func() {
+	var (
+		spySetter *SpySetter
+		s         *ingress.TelemetrySource
+	)
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		s = ingress.NewTelemetrySource(spySetter, "metron", time.Millisecond, func() []ingress.Stat {
+			return []ingress.Stat{
+				{Name: "buffer_depth", Unit: "envelopes", Value: 7},
+				{Name: "dropped", Value: 3, Counter: true, Tags: map[string]string{"reason": "buffer_full"}},
+			}
+		})
+		go s.Start()
+	})
+
+	AfterEach(func() {
+		s.Stop()
+	})
+
+	It("writes gauges and counters for each stat", func() {
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("metron"))
+		Expect(e.GetGauge().GetMetrics()).To(HaveKey("buffer_depth"))
+		Expect(e.GetGauge().GetMetrics()["buffer_depth"].GetUnit()).To(Equal("envelopes"))
+		Expect(e.GetGauge().GetMetrics()["buffer_depth"].GetValue()).To(Equal(7.0))
+
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.GetCounter().GetName()).To(Equal("dropped"))
+		Expect(e.GetCounter().GetTotal()).To(Equal(uint64(3)))
+		Expect(e.Tags).To(HaveKeyWithValue("reason", "buffer_full"))
+	})
+
+	It("stops writing when stopped", func() {
+		Eventually(spySetter.envelopes).Should(Receive())
+		s.Stop()
+
+		// Drain anything written before the source stopped.
+		time.Sleep(10 * time.Millisecond)
+		for len(spySetter.envelopes) > 0 {
+			<-spySetter.envelopes
+		}
+
+		Consistently(spySetter.envelopes).ShouldNot(Receive())
+	})
+})
@@ -0,0 +1,106 @@
+package v2
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	v1 "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v1"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing/conversion"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// UDPSource is a Source that reads dropsonde v1 envelopes from a UDP socket,
+// converts them to v2 envelopes and writes them to a DataSetter.
+type UDPSource struct {
+	addr   string
+	setter DataSetter
+
+	mu   sync.Mutex
+	conn net.PacketConn
+
+	// stopPending is set when Stop is called before Start has begun
+	// reading, so that Start returns rather than reading.
+	stopPending bool
+}
+
+// NewUDPSource returns a UDPSource that listens on the given address once
+// started.
+func NewUDPSource(addr string, s DataSetter) *UDPSource {
+	return &UDPSource{
+		addr:   addr,
+		setter: s,
+	}
+}
+
+// Start listens on the source's address and reads envelopes until Stop is
+// called. It returns an error if the source can not listen.
+func (s *UDPSource) Start() error {
+	conn, err := net.ListenPacket("udp4", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %s", err)
+	}
+	logger.Printf("udp bound to: %s", conn.LocalAddr())
+
+	s.mu.Lock()
+	if s.stopPending {
+		s.stopPending = false
+		s.mu.Unlock()
+		conn.Close()
+		return nil
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	u := v1.NewUnMarshaller(v1EnvelopeWriterFunc(s.write))
+	buf := make([]byte, 65535) // max theoretical UDP size
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			logger.Debugf("udp source stopped reading: %s", err)
+			return nil
+		}
+
+		u.Write(buf[:n])
+	}
+}
+
+// Addr returns the address the source is listening on or nil if it is not
+// listening.
+func (s *UDPSource) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	return s.conn.LocalAddr()
+}
+
+// Stop closes the socket and causes Start to return. If the source has not
+// yet begun reading, Start returns without reading.
+func (s *UDPSource) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		s.stopPending = true
+		return
+	}
+
+	s.conn.Close()
+	s.conn = nil
+}
+
+func (s *UDPSource) write(e *events.Envelope) {
+	if v2e := conversion.ToV2(e); v2e != nil {
+		s.setter.Set(v2e)
+	}
+}
+
+type v1EnvelopeWriterFunc func(*events.Envelope)
+
+func (f v1EnvelopeWriterFunc) Write(e *events.Envelope) {
+	f(e)
+}
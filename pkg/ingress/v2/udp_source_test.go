@@ -0,0 +1,70 @@
+package v2_test
+
+import (
+	"net"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UDPSource", func() {
+	var (
+		spySetter *SpySetter
+		s         *ingress.UDPSource
+		conn      net.Conn
+	)
+
+	BeforeEach(func() {
+		spySetter = NewSpySetter()
+		s = ingress.NewUDPSource("127.0.0.1:0", spySetter)
+		go s.Start()
+		Eventually(s.Addr).ShouldNot(BeNil())
+
+		var err error
+		conn, err = net.Dial("udp4", s.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		s.Stop()
+	})
+
+	It("converts dropsonde envelopes to v2 envelopes", func() {
+		msg, err := proto.Marshal(&events.Envelope{
+			Origin:    proto.String("some-origin"),
+			EventType: events.Envelope_CounterEvent.Enum(),
+			CounterEvent: &events.CounterEvent{
+				Name:  proto.String("some-counter"),
+				Delta: proto.Uint64(1),
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = conn.Write(msg)
+		Expect(err).ToNot(HaveOccurred())
+
+		var e *loggregator_v2.Envelope
+		Eventually(spySetter.envelopes).Should(Receive(&e))
+		Expect(e.SourceId).To(Equal("some-origin"))
+		Expect(e.GetCounter().GetName()).To(Equal("some-counter"))
+		Expect(e.GetCounter().GetDelta()).To(Equal(uint64(1)))
+	})
+
+	It("ignores invalid messages", func() {
+		_, err := conn.Write([]byte("invalid"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Consistently(spySetter.envelopes).ShouldNot(Receive())
+	})
+
+	It("stops listening when stopped", func() {
+		s.Stop()
+		Expect(s.Addr()).To(BeNil())
+	})
+})
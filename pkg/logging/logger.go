@@ -0,0 +1,219 @@
+// Package logging provides the logger used throughout the agent. Log lines
+// are either written as plain text via the standard library logger or as JSON
+// objects carrying structured fields, depending on the configured format.
+// Lines below the configured level are discarded. The level may be changed
+// while the agent is running.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warn",
+	ErrorLevel: "error",
+}
+
+// String returns the name of the level.
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel returns the Level with the given name. Names are case
+// insensitive.
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(name)
+	for l, n := range levelNames {
+		if n == name {
+			return l, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown log level: %s", name)
+}
+
+const (
+	// TextFormat writes log lines via the standard library logger. Fields
+	// are not included in the output.
+	TextFormat = "text"
+
+	// JSONFormat writes each log line as a JSON object containing the
+	// message, a timestamp and all of the logger's fields.
+	JSONFormat = "json"
+)
+
+var (
+	mu         sync.RWMutex
+	format     = TextFormat
+	level      = InfoLevel
+	jsonLogger = log.New(os.Stderr, "", 0)
+)
+
+// SetFormat sets the format for all loggers. An empty format is treated as
+// TextFormat.
+func SetFormat(f string) error {
+	switch f {
+	case "":
+		f = TextFormat
+	case TextFormat, JSONFormat:
+	default:
+		return fmt.Errorf("unknown log format: %s", f)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	format = f
+
+	return nil
+}
+
+// SetOutput sets the destination for all loggers, including the standard
+// library logger.
+func SetOutput(w io.Writer) {
+	log.SetOutput(w)
+	jsonLogger.SetOutput(w)
+}
+
+// SetLevel sets the minimum level written by all loggers.
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	level = l
+}
+
+// CurrentLevel returns the minimum level written by all loggers.
+func CurrentLevel() Level {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return level
+}
+
+func currentFormat() string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return format
+}
+
+// Fields are key/value pairs that are included in each JSON log line.
+type Fields map[string]interface{}
+
+// Logger writes log lines for a single component.
+type Logger struct {
+	fields Fields
+}
+
+// New returns a Logger that includes the given component name with each log
+// line.
+func New(component string) *Logger {
+	return &Logger{
+		fields: Fields{"component": component},
+	}
+}
+
+// With returns a new Logger that includes the given fields in addition to the
+// fields of the current Logger.
+func (l *Logger) With(f Fields) *Logger {
+	fields := make(Fields, len(l.fields)+len(f))
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for k, v := range f {
+		fields[k] = v
+	}
+
+	return &Logger{fields: fields}
+}
+
+// Debugf writes a log line at DebugLevel.
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.logf(DebugLevel, format, v...)
+}
+
+// Printf writes a log line at InfoLevel.
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.logf(InfoLevel, format, v...)
+}
+
+// Warnf writes a log line at WarnLevel.
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.logf(WarnLevel, format, v...)
+}
+
+// Errorf writes a log line at ErrorLevel.
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.logf(ErrorLevel, format, v...)
+}
+
+// Fatalf writes a log line regardless of level and exits the process with a
+// status code of 1.
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.output(ErrorLevel, fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+// Panicf writes a log line regardless of level and then panics with the
+// message.
+func (l *Logger) Panicf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	l.output(ErrorLevel, msg)
+	panic(msg)
+}
+
+func (l *Logger) logf(lvl Level, format string, v ...interface{}) {
+	if lvl < CurrentLevel() {
+		return
+	}
+
+	l.output(lvl, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) output(lvl Level, msg string) {
+	if currentFormat() != JSONFormat {
+		log.Print(msg)
+		return
+	}
+
+	entry := make(map[string]interface{}, len(l.fields)+3)
+	for k, v := range l.fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["timestamp"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["message"] = msg
+	entry["level"] = lvl.String()
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Print(msg)
+		return
+	}
+
+	jsonLogger.Print(string(b))
+}
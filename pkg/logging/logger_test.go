@@ -0,0 +1,137 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logger", func() {
+	var buf *bytes.Buffer
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		logging.SetOutput(buf)
+	})
+
+	AfterEach(func() {
+		logging.SetOutput(os.Stderr)
+		logging.SetLevel(logging.InfoLevel)
+		Expect(logging.SetFormat(logging.TextFormat)).To(Succeed())
+	})
+
+	It("writes plain text by default", func() {
+		logging.New("ingress").Printf("dropped %d envelopes", 5)
+
+		Expect(buf.String()).To(HaveSuffix("dropped 5 envelopes\n"))
+		Expect(buf.String()).ToNot(ContainSubstring("component"))
+	})
+
+	It("writes JSON with the component and fields", func() {
+		Expect(logging.SetFormat(logging.JSONFormat)).To(Succeed())
+
+		logger := logging.New("clientpool").With(logging.Fields{
+			"destination": "10.0.0.1:8082",
+		})
+		logger.With(logging.Fields{"count": 5}).Printf("dropped %d envelopes", 5)
+
+		var entry map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("component", "clientpool"))
+		Expect(entry).To(HaveKeyWithValue("destination", "10.0.0.1:8082"))
+		Expect(entry).To(HaveKeyWithValue("count", 5.0))
+		Expect(entry).To(HaveKeyWithValue("message", "dropped 5 envelopes"))
+		Expect(entry).To(HaveKeyWithValue("level", "info"))
+		Expect(entry).To(HaveKey("timestamp"))
+	})
+
+	It("writes errors as strings", func() {
+		Expect(logging.SetFormat(logging.JSONFormat)).To(Succeed())
+
+		logging.New("egress").With(logging.Fields{
+			"error": errors.New("some-error"),
+		}).Printf("failed")
+
+		var entry map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("error", "some-error"))
+	})
+
+	It("does not modify the parent logger's fields", func() {
+		Expect(logging.SetFormat(logging.JSONFormat)).To(Succeed())
+
+		parent := logging.New("egress")
+		parent.With(logging.Fields{"destination": "some-addr"})
+		parent.Printf("hello")
+
+		var entry map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+		Expect(entry).ToNot(HaveKey("destination"))
+	})
+
+	It("returns an error for an unknown format", func() {
+		Expect(logging.SetFormat("xml")).ToNot(Succeed())
+	})
+
+	It("panics with the message", func() {
+		Expect(func() {
+			logging.New("agent").Panicf("bad %s", "thing")
+		}).To(PanicWith("bad thing"))
+	})
+
+	It("discards lines below the current level", func() {
+		logger := logging.New("clientpool")
+
+		logger.Debugf("dialing")
+		Expect(buf.String()).To(BeEmpty())
+
+		logging.SetLevel(logging.DebugLevel)
+		logger.Debugf("dialing")
+		Expect(buf.String()).To(ContainSubstring("dialing"))
+
+		buf.Reset()
+		logging.SetLevel(logging.ErrorLevel)
+		logger.Printf("connected")
+		logger.Warnf("slow")
+		Expect(buf.String()).To(BeEmpty())
+
+		logger.Errorf("failed")
+		Expect(buf.String()).To(ContainSubstring("failed"))
+	})
+
+	It("writes the level in JSON", func() {
+		Expect(logging.SetFormat(logging.JSONFormat)).To(Succeed())
+
+		logging.New("egress").Warnf("slow")
+
+		var entry map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("level", "warn"))
+	})
+
+	It("parses level names", func() {
+		for _, lvl := range []logging.Level{
+			logging.DebugLevel,
+			logging.InfoLevel,
+			logging.WarnLevel,
+			logging.ErrorLevel,
+		} {
+			parsed, err := logging.ParseLevel(lvl.String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsed).To(Equal(lvl))
+		}
+
+		parsed, err := logging.ParseLevel("WARN")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed).To(Equal(logging.WarnLevel))
+
+		_, err = logging.ParseLevel("loud")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,265 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the format of the time a log file was rotated at in
+// the name of its backup. Backups sort by name in the order they were
+// rotated.
+const backupTimeFormat = "20060102T150405.000000000"
+
+// RotatingFile is a log file that is moved aside to a backup once it grows
+// beyond a maximum size or has been written to for longer than a maximum
+// age. Backups are optionally gzipped and only the most recent are kept. It
+// should be constructed with NewRotatingFile.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+	now        func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// cleanupMu serializes compressing and removing backups, which happens
+	// in the background so that rotation does not hold up logging.
+	cleanupMu sync.Mutex
+	cleanups  sync.WaitGroup
+}
+
+// RotationOption configures a RotatingFile.
+type RotationOption func(*RotatingFile)
+
+// WithMaxSize sets the size in bytes a file can grow to before it is
+// rotated. The default is 100MB. Zero disables rotation by size.
+func WithMaxSize(n int64) RotationOption {
+	return func(f *RotatingFile) {
+		f.maxSize = n
+	}
+}
+
+// WithMaxAge sets how long a file is written to before it is rotated. By
+// default files are not rotated by age.
+func WithMaxAge(d time.Duration) RotationOption {
+	return func(f *RotatingFile) {
+		f.maxAge = d
+	}
+}
+
+// WithMaxBackups sets the number of rotated files that are kept. The
+// default is 7. Zero keeps every backup.
+func WithMaxBackups(n int) RotationOption {
+	return func(f *RotatingFile) {
+		f.maxBackups = n
+	}
+}
+
+// WithCompression gzips rotated files.
+func WithCompression() RotationOption {
+	return func(f *RotatingFile) {
+		f.compress = true
+	}
+}
+
+// WithRotationClock sets the function used to read the time. It is intended
+// for tests.
+func WithRotationClock(now func() time.Time) RotationOption {
+	return func(f *RotatingFile) {
+		f.now = now
+	}
+}
+
+// NewRotatingFile opens the file at path for appending, creating it if
+// needed.
+func NewRotatingFile(path string, opts ...RotationOption) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    100 * 1024 * 1024,
+		maxBackups: 7,
+		now:        time.Now,
+	}
+
+	for _, o := range opts {
+		o(f)
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write writes p to the file, rotating the file first if writing p would
+// take it beyond the maximum size or it has reached the maximum age.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the file and waits for backups to be cleaned up.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+
+	f.cleanups.Wait()
+
+	return err
+}
+
+func (f *RotatingFile) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+
+	if f.maxSize > 0 && f.size+int64(n) > f.maxSize {
+		return true
+	}
+
+	return f.maxAge > 0 && f.now().Sub(f.opened) >= f.maxAge
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	backup := fmt.Sprintf("%s.%s", f.path, f.now().UTC().Format(backupTimeFormat))
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	f.cleanups.Add(1)
+	go f.cleanup(backup)
+
+	return nil
+}
+
+// cleanup compresses the new backup if needed and removes the oldest
+// backups beyond the maximum number.
+func (f *RotatingFile) cleanup(backup string) {
+	defer f.cleanups.Done()
+
+	f.cleanupMu.Lock()
+	defer f.cleanupMu.Unlock()
+
+	if f.compress {
+		if err := compressFile(backup); err != nil {
+			// Logging here could write back to this file.
+			fmt.Fprintf(os.Stderr, "failed to compress %s: %s\n", backup, err)
+		}
+	}
+
+	if f.maxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+
+	var rotated []string
+	for _, b := range backups {
+		if !strings.HasSuffix(b, ".tmp") {
+			rotated = append(rotated, b)
+		}
+	}
+	sort.Strings(rotated)
+
+	for len(rotated) > f.maxBackups {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+// compressFile replaces the file at path with a gzipped copy at path.gz.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
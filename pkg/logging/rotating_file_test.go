@@ -0,0 +1,120 @@
+package logging_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RotatingFile", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "rotating-file")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "agent.log")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	backups := func() []string {
+		matches, err := filepath.Glob(path + ".*")
+		Expect(err).ToNot(HaveOccurred())
+		return matches
+	}
+
+	It("rotates the file once it reaches the max size", func() {
+		f, err := logging.NewRotatingFile(path, logging.WithMaxSize(10))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = f.Write([]byte("0123456789"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.Write([]byte("abc"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte("abc")))
+		Expect(backups()).To(HaveLen(1))
+		Expect(ioutil.ReadFile(backups()[0])).To(Equal([]byte("0123456789")))
+	})
+
+	It("rotates the file once it reaches the max age", func() {
+		now := time.Now()
+		f, err := logging.NewRotatingFile(path,
+			logging.WithMaxAge(time.Hour),
+			logging.WithRotationClock(func() time.Time { return now }),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = f.Write([]byte("first"))
+		Expect(err).ToNot(HaveOccurred())
+		now = now.Add(time.Hour)
+		_, err = f.Write([]byte("second"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte("second")))
+		Expect(backups()).To(HaveLen(1))
+	})
+
+	It("compresses backups", func() {
+		f, err := logging.NewRotatingFile(path, logging.WithMaxSize(5), logging.WithCompression())
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = f.Write([]byte("first"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.Write([]byte("second"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(backups()).To(HaveLen(1))
+		Expect(backups()[0]).To(HaveSuffix(".gz"))
+
+		gzipped, err := os.Open(backups()[0])
+		Expect(err).ToNot(HaveOccurred())
+		defer gzipped.Close()
+		r, err := gzip.NewReader(gzipped)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.ReadAll(r)).To(Equal([]byte("first")))
+	})
+
+	It("keeps the most recent backups", func() {
+		f, err := logging.NewRotatingFile(path, logging.WithMaxSize(1), logging.WithMaxBackups(2))
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, b := range []string{"a", "b", "c", "d"} {
+			_, err = f.Write([]byte(b))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(f.Close()).To(Succeed())
+
+		Expect(backups()).To(HaveLen(2))
+		Expect(ioutil.ReadFile(backups()[0])).To(Equal([]byte("b")))
+		Expect(ioutil.ReadFile(backups()[1])).To(Equal([]byte("c")))
+	})
+
+	It("appends to an existing file", func() {
+		Expect(ioutil.WriteFile(path, []byte("existing\n"), 0644)).To(Succeed())
+
+		f, err := logging.NewRotatingFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.Write([]byte("new\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte("existing\nnew\n")))
+	})
+})
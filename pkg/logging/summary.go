@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SummaryInterval is how often the agent's summaries of repeated events are
+// logged.
+const SummaryInterval = time.Minute
+
+// maxSummaryMessages bounds the number of distinct messages a Summary
+// counts in an interval. Further messages are counted together.
+const maxSummaryMessages = 100
+
+// otherMessages is the message that distinct messages beyond
+// maxSummaryMessages are counted under.
+const otherMessages = "other messages"
+
+// Summary logs repeated events as a single line per interval rather than a
+// line for every event, so that logs stay readable during a sustained
+// incident. Each distinct message is logged once with the total number of
+// times it occurred in the interval. It should be constructed with
+// Logger.Summarize.
+type Summary struct {
+	logger   *Logger
+	level    Level
+	interval time.Duration
+
+	mu     sync.Mutex
+	counts map[string]uint64
+	timer  *time.Timer
+}
+
+// Summarize returns a Summary that writes its lines at the given level once
+// every interval.
+func (l *Logger) Summarize(lvl Level, interval time.Duration) *Summary {
+	return &Summary{
+		logger:   l,
+		level:    lvl,
+		interval: interval,
+		counts:   make(map[string]uint64),
+	}
+}
+
+// Add counts n occurrences of the message. The total is logged at the end
+// of the interval.
+func (s *Summary) Add(msg string, n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.counts[msg]; !ok && len(s.counts) >= maxSummaryMessages {
+		msg = otherMessages
+	}
+	s.counts[msg] += n
+
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.interval, s.Flush)
+	}
+}
+
+// Flush logs the messages counted since the last flush without waiting for
+// the end of the interval.
+func (s *Summary) Flush() {
+	s.mu.Lock()
+	counts := s.counts
+	s.counts = make(map[string]uint64)
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.mu.Unlock()
+
+	msgs := make([]string, 0, len(counts))
+	for msg := range counts {
+		msgs = append(msgs, msg)
+	}
+	sort.Strings(msgs)
+
+	for _, msg := range msgs {
+		s.logger.With(Fields{
+			"count":    counts[msg],
+			"interval": s.interval.String(),
+		}).logf(s.level, "%s (%d in the last %s)", msg, counts[msg], s.interval)
+	}
+}
@@ -0,0 +1,59 @@
+package logging_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Summary", func() {
+	var buf *bytes.Buffer
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		logging.SetOutput(buf)
+	})
+
+	AfterEach(func() {
+		logging.SetOutput(os.Stderr)
+	})
+
+	It("logs each distinct message once with its total", func() {
+		s := logging.New("egress").Summarize(logging.WarnLevel, time.Hour)
+		s.Add("Dropped v2 envelopes", 5)
+		s.Add("failed to connect: some-error", 1)
+		s.Add("Dropped v2 envelopes", 10)
+		Expect(buf.String()).To(BeEmpty())
+
+		s.Flush()
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(HaveSuffix("Dropped v2 envelopes (15 in the last 1h0m0s)"))
+		Expect(lines[1]).To(HaveSuffix("failed to connect: some-error (1 in the last 1h0m0s)"))
+	})
+
+	It("starts counting again after a flush", func() {
+		s := logging.New("egress").Summarize(logging.WarnLevel, time.Hour)
+		s.Add("Dropped v2 envelopes", 5)
+		s.Flush()
+		buf.Reset()
+
+		s.Flush()
+		Expect(buf.String()).To(BeEmpty())
+	})
+
+	It("does not log below the current level", func() {
+		s := logging.New("egress").Summarize(logging.DebugLevel, time.Hour)
+		s.Add("Dropped v2 envelopes", 5)
+		s.Flush()
+
+		Expect(buf.String()).To(BeEmpty())
+	})
+})
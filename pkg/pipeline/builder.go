@@ -0,0 +1,228 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+)
+
+// SourceBuilder builds a source for a stage. The source writes its envelopes
+// to the given DataSetter.
+type SourceBuilder func(s Stage, w ingress.DataSetter) (ingress.Source, error)
+
+// ProcessorBuilder builds a processor for a stage. The processor writes the
+// envelopes it processes to next.
+type ProcessorBuilder func(s Stage, next egress.Writer) (egress.Writer, error)
+
+// SinkBuilder builds a sink for a stage.
+type SinkBuilder func(s Stage) (egress.Writer, error)
+
+// ReplayTimestampsOption is a sink option available to every sink type. It
+// sets the ReplayPolicy applied to replayed envelopes written to the sink.
+const ReplayTimestampsOption = "replay_timestamps"
+
+// BufferSizeOption is a sink option available to every sink type. It sets
+// the number of envelopes buffered for the sink when sink buffers are
+// enabled.
+const BufferSizeOption = "buffer_size"
+
+// SourceAdder registers sources to be started.
+type SourceAdder interface {
+	Add(name string, f ingress.SourceFunc)
+}
+
+// Builder constructs pipelines from stages of registered types.
+type Builder struct {
+	sources    map[string]SourceBuilder
+	processors map[string]ProcessorBuilder
+	sinks      map[string]SinkBuilder
+
+	bufferSize   int
+	metricClient egress.MetricClient
+}
+
+// BuilderOption configures a Builder.
+type BuilderOption func(*Builder)
+
+// WithSinkBuffers gives each sink its own buffer of the given size when a
+// pipeline has more than one sink, so that a slow sink drops its own
+// envelopes instead of delaying the others. Drops are reported through the
+// given MetricClient. By default sinks are written to synchronously.
+func WithSinkBuffers(size int, m egress.MetricClient) BuilderOption {
+	return func(b *Builder) {
+		b.bufferSize = size
+		b.metricClient = m
+	}
+}
+
+// NewBuilder returns a Builder with no registered stage types.
+func NewBuilder(opts ...BuilderOption) *Builder {
+	b := &Builder{
+		sources:    make(map[string]SourceBuilder),
+		processors: make(map[string]ProcessorBuilder),
+		sinks:      make(map[string]SinkBuilder),
+	}
+
+	for _, o := range opts {
+		o(b)
+	}
+
+	return b
+}
+
+// RegisterSource registers the builder for a source type.
+func (b *Builder) RegisterSource(typ string, f SourceBuilder) {
+	b.sources[typ] = f
+}
+
+// RegisterProcessor registers the builder for a processor type.
+func (b *Builder) RegisterProcessor(typ string, f ProcessorBuilder) {
+	b.processors[typ] = f
+}
+
+// RegisterSink registers the builder for a sink type.
+func (b *Builder) RegisterSink(typ string, f SinkBuilder) {
+	b.sinks[typ] = f
+}
+
+// Build constructs every stage of the pipeline. Sources are added to the
+// given SourceAdder and envelopes read from the sources should be written
+// to the returned Pipeline. An error is returned if any stage has an
+// unregistered type or fails to build.
+func (b *Builder) Build(c Config, sources SourceAdder) (*Pipeline, error) {
+	p := &Pipeline{}
+
+	var sinks []egress.Writer
+	for _, s := range c.Sinks {
+		f, ok := b.sinks[s.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown sink type %q for stage %s", s.Type, s.Name)
+		}
+
+		w, err := f(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build sink %s: %s", s.Name, err)
+		}
+		p.sinks = append(p.sinks, w)
+
+		if name, ok := s.Options[ReplayTimestampsOption]; ok {
+			policy, err := egress.ParseReplayPolicy(name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build sink %s: %s", s.Name, err)
+			}
+			w = egress.NewReplayTimestampWriter(policy, w)
+		}
+
+		sinks = append(sinks, w)
+	}
+
+	if b.bufferSize > 0 && len(sinks) > 1 {
+		for i, s := range c.Sinks {
+			size := b.bufferSize
+			if v, ok := s.Options[BufferSizeOption]; ok {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					return nil, fmt.Errorf("failed to build sink %s: invalid %s %q", s.Name, BufferSizeOption, v)
+				}
+				size = n
+			}
+
+			bw := egress.NewBufferedWriter(s.Name, size, sinks[i], b.metricClient)
+			go bw.Start()
+			sinks[i] = bw
+			p.buffers = append(p.buffers, bw)
+		}
+	}
+
+	var (
+		routes  routeWriter
+		routing bool
+	)
+	for i, s := range c.Sinks {
+		r, ok, err := newRoute(s, sinks[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to build sink %s: %s", s.Name, err)
+		}
+		routes = append(routes, r)
+		routing = routing || ok
+	}
+
+	var w egress.Writer = fanOutWriter(sinks)
+	switch {
+	case routing:
+		w = routes
+	case len(sinks) == 1:
+		w = sinks[0]
+	}
+
+	for i := len(c.Processors) - 1; i >= 0; i-- {
+		s := c.Processors[i]
+		f, ok := b.processors[s.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown processor type %q for stage %s", s.Type, s.Name)
+		}
+
+		var err error
+		w, err = f(s, w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build processor %s: %s", s.Name, err)
+		}
+		p.processors = append([]egress.Writer{w}, p.processors...)
+	}
+	p.head = w
+
+	for _, s := range c.Sources {
+		if _, ok := b.sources[s.Type]; !ok {
+			return nil, fmt.Errorf("unknown source type %q for stage %s", s.Type, s.Name)
+		}
+	}
+
+	for _, s := range c.Sources {
+		var (
+			stage = s
+			f     = b.sources[s.Type]
+			err   error
+		)
+		sources.Add(s.Name, func(ds ingress.DataSetter) ingress.Source {
+			var src ingress.Source
+			src, err = f(stage, ds)
+			return src
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to build source %s: %s", s.Name, err)
+		}
+	}
+
+	return p, nil
+}
+
+// fanOutWriter writes each batch to every writer.
+type fanOutWriter []egress.Writer
+
+// Write writes the batch to every writer. If any writer fails the last error
+// is returned.
+func (f fanOutWriter) Write(batch []*loggregator_v2.Envelope) error {
+	var err error
+	for _, w := range f {
+		if werr := w.Write(batch); werr != nil {
+			err = werr
+		}
+	}
+
+	return err
+}
+
+// BorrowsBatches reports whether every writer borrows batches.
+func (f fanOutWriter) BorrowsBatches() bool {
+	for _, w := range f {
+		if !egress.BorrowsBatches(w) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,417 @@
+package pipeline_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	ingress "code.cloudfoundry.org/loggregator-agent/pkg/ingress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/pipeline"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Builder", func() {
+	var (
+		b       *pipeline.Builder
+		sources *spySourceAdder
+		sinks   map[string]*spyWriter
+	)
+
+	BeforeEach(func() {
+		sources = newSpySourceAdder()
+		sinks = make(map[string]*spyWriter)
+
+		b = pipeline.NewBuilder()
+		b.RegisterSource("spy", func(s pipeline.Stage, w ingress.DataSetter) (ingress.Source, error) {
+			return &nopSource{}, nil
+		})
+		b.RegisterProcessor("suffix", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+			return &suffixWriter{suffix: s.Option("suffix", ""), next: next}, nil
+		})
+		b.RegisterSink("spy", func(s pipeline.Stage) (egress.Writer, error) {
+			w := &spyWriter{}
+			sinks[s.Name] = w
+			return w, nil
+		})
+	})
+
+	It("adds each source", func() {
+		_, err := b.Build(pipeline.Config{
+			Sources: []pipeline.Stage{
+				{Name: "a", Type: "spy"},
+				{Name: "b", Type: "spy"},
+			},
+			Sinks: []pipeline.Stage{{Name: "sink", Type: "spy"}},
+		}, sources)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(sources.names).To(Equal([]string{"a", "b"}))
+	})
+
+	It("passes envelopes through processors in order to every sink", func() {
+		w, err := b.Build(pipeline.Config{
+			Sources: []pipeline.Stage{{Name: "source", Type: "spy"}},
+			Processors: []pipeline.Stage{
+				{Name: "first", Type: "suffix", Options: map[string]string{"suffix": "-a"}},
+				{Name: "second", Type: "suffix", Options: map[string]string{"suffix": "-b"}},
+			},
+			Sinks: []pipeline.Stage{
+				{Name: "sink-1", Type: "spy"},
+				{Name: "sink-2", Type: "spy"},
+			},
+		}, sources)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "id"}})).To(Succeed())
+
+		for _, name := range []string{"sink-1", "sink-2"} {
+			Expect(sinks[name].batches).To(HaveLen(1))
+			Expect(sinks[name].batches[0][0].SourceId).To(Equal("id-a-b"))
+		}
+	})
+
+	It("returns an error if any sink fails to write", func() {
+		w, err := b.Build(pipeline.Config{
+			Sources: []pipeline.Stage{{Name: "source", Type: "spy"}},
+			Sinks: []pipeline.Stage{
+				{Name: "sink-1", Type: "spy"},
+				{Name: "sink-2", Type: "spy"},
+			},
+		}, sources)
+		Expect(err).ToNot(HaveOccurred())
+		sinks["sink-1"].err = errors.New("some-error")
+
+		Expect(w.Write([]*loggregator_v2.Envelope{{}})).ToNot(Succeed())
+		Expect(sinks["sink-2"].batches).To(HaveLen(1))
+	})
+
+	It("applies the replay timestamp policy per sink", func() {
+		w, err := b.Build(pipeline.Config{
+			Sources: []pipeline.Stage{{Name: "source", Type: "spy"}},
+			Sinks: []pipeline.Stage{
+				{Name: "realtime", Type: "spy", Options: map[string]string{"replay_timestamps": "rewrite"}},
+				{Name: "archive", Type: "spy"},
+			},
+		}, sources)
+		Expect(err).ToNot(HaveOccurred())
+
+		e := &loggregator_v2.Envelope{Timestamp: 1}
+		egress.MarkReplayed(e)
+		Expect(w.Write([]*loggregator_v2.Envelope{e})).To(Succeed())
+
+		Expect(sinks["realtime"].batches[0][0].Timestamp).ToNot(Equal(int64(1)))
+		Expect(sinks["archive"].batches[0][0].Timestamp).To(Equal(int64(1)))
+	})
+
+	It("returns an error for an unknown replay timestamp policy", func() {
+		_, err := b.Build(pipeline.Config{
+			Sources: []pipeline.Stage{{Name: "source", Type: "spy"}},
+			Sinks: []pipeline.Stage{
+				{Name: "sink", Type: "spy", Options: map[string]string{"replay_timestamps": "shift"}},
+			},
+		}, sources)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("routes envelopes to sinks by tag and source ID", func() {
+		w, err := b.Build(pipeline.Config{
+			Sources: []pipeline.Stage{{Name: "source", Type: "spy"}},
+			Sinks: []pipeline.Stage{
+				{Name: "system", Type: "spy", Options: map[string]string{"match_tags": "origin:system, job:router"}},
+				{Name: "bbs", Type: "spy", Options: map[string]string{"match_source_id": "^bbs$"}},
+				{Name: "apps", Type: "spy", Options: map[string]string{"unmatched": "true"}},
+				{Name: "archive", Type: "spy"},
+			},
+		}, sources)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(w.Write([]*loggregator_v2.Envelope{
+			{SourceId: "router", Tags: map[string]string{"origin": "system", "job": "router"}},
+			{SourceId: "bbs"},
+			{SourceId: "app", Tags: map[string]string{"origin": "system"}},
+		})).To(Succeed())
+
+		sourceIDs := func(name string) []string {
+			var ids []string
+			for _, batch := range sinks[name].batches {
+				for _, e := range batch {
+					ids = append(ids, e.SourceId)
+				}
+			}
+			return ids
+		}
+		Expect(sourceIDs("system")).To(Equal([]string{"router"}))
+		Expect(sourceIDs("bbs")).To(Equal([]string{"bbs"}))
+		Expect(sourceIDs("apps")).To(Equal([]string{"app"}))
+		Expect(sourceIDs("archive")).To(Equal([]string{"router", "bbs", "app"}))
+	})
+
+	It("returns an error for invalid routing options", func() {
+		for _, opts := range []map[string]string{
+			{"match_tags": "origin"},
+			{"match_source_id": "["},
+			{"unmatched": "yes"},
+			{"unmatched": "true", "match_source_id": "^bbs$"},
+		} {
+			_, err := b.Build(pipeline.Config{
+				Sources: []pipeline.Stage{{Name: "source", Type: "spy"}},
+				Sinks:   []pipeline.Stage{{Name: "sink", Type: "spy", Options: opts}},
+			}, sources)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+
+	It("returns an error for unknown stage types", func() {
+		configs := []pipeline.Config{
+			{
+				Sources: []pipeline.Stage{{Name: "source", Type: "unknown"}},
+				Sinks:   []pipeline.Stage{{Name: "sink", Type: "spy"}},
+			},
+			{
+				Sources:    []pipeline.Stage{{Name: "source", Type: "spy"}},
+				Processors: []pipeline.Stage{{Name: "processor", Type: "unknown"}},
+				Sinks:      []pipeline.Stage{{Name: "sink", Type: "spy"}},
+			},
+			{
+				Sources: []pipeline.Stage{{Name: "source", Type: "spy"}},
+				Sinks:   []pipeline.Stage{{Name: "sink", Type: "unknown"}},
+			},
+		}
+
+		for _, c := range configs {
+			_, err := b.Build(c, sources)
+			Expect(err).To(HaveOccurred())
+		}
+		Expect(sources.names).To(BeEmpty())
+	})
+
+	It("flushes processors in order and closes sinks when stopped", func() {
+		b.RegisterProcessor("holding", func(s pipeline.Stage, next egress.Writer) (egress.Writer, error) {
+			return &holdingWriter{next: next}, nil
+		})
+
+		p, err := b.Build(pipeline.Config{
+			Sources: []pipeline.Stage{{Name: "source", Type: "spy"}},
+			Processors: []pipeline.Stage{
+				{Name: "first", Type: "holding"},
+				{Name: "second", Type: "holding"},
+			},
+			Sinks: []pipeline.Stage{{Name: "sink", Type: "spy"}},
+		}, sources)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(p.Write([]*loggregator_v2.Envelope{{SourceId: "id"}})).To(Succeed())
+		Expect(sinks["sink"].batches).To(BeEmpty())
+
+		Expect(p.Stop(time.Second)).To(BeZero())
+		Expect(sinks["sink"].batches).To(HaveLen(1))
+		Expect(sinks["sink"].closed).To(BeTrue())
+	})
+
+	It("returns an error when a stage fails to build", func() {
+		b.RegisterSource("broken", func(pipeline.Stage, ingress.DataSetter) (ingress.Source, error) {
+			return nil, errors.New("some-error")
+		})
+
+		_, err := b.Build(pipeline.Config{
+			Sources: []pipeline.Stage{{Name: "source", Type: "broken"}},
+			Sinks:   []pipeline.Stage{{Name: "sink", Type: "spy"}},
+		}, sources)
+		Expect(err).To(MatchError(ContainSubstring("some-error")))
+	})
+
+	Context("with sink buffers", func() {
+		var (
+			metricClient *testhelper.SpyMetricClient
+			slow         *blockingWriter
+			fast         *syncSpyWriter
+		)
+
+		BeforeEach(func() {
+			metricClient = testhelper.NewMetricClient()
+			slow = newBlockingWriter()
+			fast = &syncSpyWriter{}
+
+			b = pipeline.NewBuilder(pipeline.WithSinkBuffers(10, metricClient))
+			b.RegisterSink("slow", func(pipeline.Stage) (egress.Writer, error) {
+				return slow, nil
+			})
+			b.RegisterSink("fast", func(pipeline.Stage) (egress.Writer, error) {
+				return fast, nil
+			})
+		})
+
+		AfterEach(func() {
+			slow.Unblock()
+		})
+
+		It("does not let a slow sink delay the others", func() {
+			w, err := b.Build(pipeline.Config{
+				Sinks: []pipeline.Stage{
+					{Name: "fast", Type: "fast", Options: map[string]string{"buffer_size": "1000"}},
+					{Name: "slow", Type: "slow"},
+				},
+			}, sources)
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 100; i++ {
+				Expect(w.Write([]*loggregator_v2.Envelope{{SourceId: "id"}})).To(Succeed())
+			}
+
+			Eventually(fast.Count).Should(Equal(100))
+			Eventually(func() uint64 {
+				return metricClient.GetMetric("dropped").Delta()
+			}).Should(BeNumerically(">", 0))
+		})
+
+		It("returns an error for an invalid buffer size", func() {
+			_, err := b.Build(pipeline.Config{
+				Sinks: []pipeline.Stage{
+					{Name: "slow", Type: "slow", Options: map[string]string{"buffer_size": "many"}},
+					{Name: "fast", Type: "fast"},
+				},
+			}, sources)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("writes what the sink buffers hold when stopped", func() {
+			p, err := b.Build(pipeline.Config{
+				Sinks: []pipeline.Stage{
+					{Name: "fast", Type: "fast", Options: map[string]string{"buffer_size": "1000"}},
+					{Name: "other", Type: "fast", Options: map[string]string{"buffer_size": "1000"}},
+				},
+			}, sources)
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 100; i++ {
+				Expect(p.Write([]*loggregator_v2.Envelope{{SourceId: "id"}})).To(Succeed())
+			}
+
+			Expect(p.Stop(time.Second)).To(BeZero())
+			Expect(fast.Count()).To(Equal(200))
+		})
+
+		It("writes synchronously to a single sink", func() {
+			w, err := b.Build(pipeline.Config{
+				Sinks: []pipeline.Stage{{Name: "fast", Type: "fast"}},
+			}, sources)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(w.Write([]*loggregator_v2.Envelope{{}})).To(Succeed())
+			Expect(fast.Count()).To(Equal(1))
+		})
+	})
+})
+
+type spySourceAdder struct {
+	names []string
+}
+
+func newSpySourceAdder() *spySourceAdder {
+	return &spySourceAdder{}
+}
+
+func (s *spySourceAdder) Add(name string, f ingress.SourceFunc) {
+	s.names = append(s.names, name)
+	f(nil)
+}
+
+type nopSource struct{}
+
+func (s *nopSource) Start() error { return nil }
+func (s *nopSource) Stop()        {}
+
+type suffixWriter struct {
+	suffix string
+	next   egress.Writer
+}
+
+func (w *suffixWriter) Write(batch []*loggregator_v2.Envelope) error {
+	for _, e := range batch {
+		e.SourceId += w.suffix
+	}
+
+	return w.next.Write(batch)
+}
+
+type spyWriter struct {
+	batches [][]*loggregator_v2.Envelope
+	err     error
+	closed  bool
+}
+
+func (w *spyWriter) Write(batch []*loggregator_v2.Envelope) error {
+	w.batches = append(w.batches, batch)
+	return w.err
+}
+
+func (w *spyWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+// holdingWriter holds every envelope until it is flushed.
+type holdingWriter struct {
+	held []*loggregator_v2.Envelope
+	next egress.Writer
+}
+
+func (w *holdingWriter) Write(batch []*loggregator_v2.Envelope) error {
+	w.held = append(w.held, batch...)
+	return nil
+}
+
+func (w *holdingWriter) Flush(time.Duration) uint64 {
+	held := w.held
+	w.held = nil
+	if len(held) == 0 {
+		return 0
+	}
+
+	if err := w.next.Write(held); err != nil {
+		return uint64(len(held))
+	}
+
+	return 0
+}
+
+type syncSpyWriter struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (w *syncSpyWriter) Write(batch []*loggregator_v2.Envelope) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.count += len(batch)
+	return nil
+}
+
+func (w *syncSpyWriter) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+type blockingWriter struct {
+	once    sync.Once
+	unblock chan struct{}
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{unblock: make(chan struct{})}
+}
+
+func (w *blockingWriter) Write([]*loggregator_v2.Envelope) error {
+	<-w.unblock
+	return nil
+}
+
+func (w *blockingWriter) Unblock() {
+	w.once.Do(func() { close(w.unblock) })
+}
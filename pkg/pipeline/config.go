@@ -0,0 +1,103 @@
+// Package pipeline builds the agent's v2 envelope pipeline from a
+// declarative description of its sources, processors and sinks.
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config describes a pipeline. Envelopes from every source are buffered
+// together, passed through each processor in order and then written to
+// every sink.
+type Config struct {
+	Sources    []Stage `yaml:"sources"`
+	Processors []Stage `yaml:"processors"`
+	Sinks      []Stage `yaml:"sinks"`
+}
+
+// Stage is a single named step of a pipeline. The type selects how the
+// stage is built and the options are passed to its builder.
+type Stage struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"`
+	Options map[string]string `yaml:"options"`
+}
+
+// Option returns the named option or the given default if the option is not
+// set.
+func (s Stage) Option(name, def string) string {
+	if v, ok := s.Options[name]; ok {
+		return v
+	}
+
+	return def
+}
+
+// DefaultConfig returns the pipeline the agent runs when no pipeline is
+// configured: the gRPC ingress server feeding the doppler client pool with
+// counters aggregated.
+func DefaultConfig() Config {
+	return Config{
+		Sources: []Stage{
+			{Name: "grpc", Type: "grpc"},
+		},
+		Processors: []Stage{
+			{Name: "counter_aggregator", Type: "counter_aggregator"},
+		},
+		Sinks: []Stage{
+			{Name: "doppler", Type: "doppler"},
+		},
+	}
+}
+
+// LoadConfig reads and parses the pipeline config at the given path.
+func LoadConfig(path string) (Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return ParseConfig(contents)
+}
+
+// ParseConfig parses a YAML pipeline config. Stages without a name are named
+// after their type. A pipeline requires at least one source and one sink
+// and every stage name must be unique.
+func ParseConfig(contents []byte) (Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(contents, &c); err != nil {
+		return Config{}, err
+	}
+
+	if len(c.Sources) == 0 {
+		return Config{}, fmt.Errorf("pipeline requires at least one source")
+	}
+
+	if len(c.Sinks) == 0 {
+		return Config{}, fmt.Errorf("pipeline requires at least one sink")
+	}
+
+	names := make(map[string]bool)
+	for _, stages := range [][]Stage{c.Sources, c.Processors, c.Sinks} {
+		for i := range stages {
+			s := &stages[i]
+			if s.Type == "" {
+				return Config{}, fmt.Errorf("pipeline stage requires a type: %+v", *s)
+			}
+
+			if s.Name == "" {
+				s.Name = s.Type
+			}
+
+			if names[s.Name] {
+				return Config{}, fmt.Errorf("duplicate pipeline stage name: %s", s.Name)
+			}
+			names[s.Name] = true
+		}
+	}
+
+	return c, nil
+}
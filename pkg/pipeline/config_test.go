@@ -0,0 +1,132 @@
+package pipeline_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/pipeline"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	It("parses sources, processors and sinks", func() {
+		c, err := pipeline.ParseConfig([]byte(`
+sources:
+- name: local
+  type: grpc
+  options:
+    addr: 127.0.0.1:3458
+processors:
+- type: counter_aggregator
+sinks:
+- name: primary
+  type: doppler
+- name: secondary
+  type: doppler
+  options:
+    addr: doppler.other:8082
+`))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(c).To(Equal(pipeline.Config{
+			Sources: []pipeline.Stage{
+				{Name: "local", Type: "grpc", Options: map[string]string{"addr": "127.0.0.1:3458"}},
+			},
+			Processors: []pipeline.Stage{
+				{Name: "counter_aggregator", Type: "counter_aggregator"},
+			},
+			Sinks: []pipeline.Stage{
+				{Name: "primary", Type: "doppler"},
+				{Name: "secondary", Type: "doppler", Options: map[string]string{"addr": "doppler.other:8082"}},
+			},
+		}))
+	})
+
+	It("requires a source", func() {
+		_, err := pipeline.ParseConfig([]byte(`
+sinks:
+- type: doppler
+`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires a sink", func() {
+		_, err := pipeline.ParseConfig([]byte(`
+sources:
+- type: grpc
+`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires each stage to have a type", func() {
+		_, err := pipeline.ParseConfig([]byte(`
+sources:
+- name: grpc
+sinks:
+- type: doppler
+`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("requires unique stage names", func() {
+		_, err := pipeline.ParseConfig([]byte(`
+sources:
+- type: grpc
+sinks:
+- type: doppler
+- type: doppler
+`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects unknown fields", func() {
+		_, err := pipeline.ParseConfig([]byte(`
+sources:
+- type: grpc
+  port: 3458
+sinks:
+- type: doppler
+`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("loads the config from a file", func() {
+		f, err := ioutil.TempFile("", "pipeline")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+
+		_, err = f.Write([]byte(`
+sources:
+- type: grpc
+sinks:
+- type: doppler
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		c, err := pipeline.LoadConfig(f.Name())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Sources).To(HaveLen(1))
+		Expect(c.Sinks).To(HaveLen(1))
+
+		_, err = pipeline.LoadConfig("/does/not/exist")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("defaults to the gRPC source, counter aggregation and doppler", func() {
+		c := pipeline.DefaultConfig()
+
+		Expect(c.Sources).To(ConsistOf(pipeline.Stage{Name: "grpc", Type: "grpc"}))
+		Expect(c.Processors).To(ConsistOf(pipeline.Stage{Name: "counter_aggregator", Type: "counter_aggregator"}))
+		Expect(c.Sinks).To(ConsistOf(pipeline.Stage{Name: "doppler", Type: "doppler"}))
+	})
+
+	It("returns option defaults", func() {
+		s := pipeline.Stage{Options: map[string]string{"addr": "some-addr"}}
+
+		Expect(s.Option("addr", "default")).To(Equal("some-addr"))
+		Expect(s.Option("port", "default")).To(Equal("default"))
+	})
+})
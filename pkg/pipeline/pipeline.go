@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"io"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+)
+
+var logger = logging.New("pipeline")
+
+// Pipeline is a built chain of processors and sinks.
+type Pipeline struct {
+	head egress.Writer
+
+	// processors are in the order envelopes pass through them.
+	processors []egress.Writer
+	buffers    []*egress.BufferedWriter
+	sinks      []egress.Writer
+}
+
+// Write writes the batch to the first processor, or to the sinks if there
+// are no processors.
+func (p *Pipeline) Write(batch []*loggregator_v2.Envelope) error {
+	return p.head.Write(batch)
+}
+
+// BorrowsBatches reports whether every processor and sink a batch is
+// written to borrows batches.
+func (p *Pipeline) BorrowsBatches() bool {
+	return egress.BorrowsBatches(p.head)
+}
+
+// Stop writes the envelopes held by the processors and sink buffers and
+// closes the sinks. Processors are flushed in order so that what one writes
+// is held, or flushed, by the next. Flushing takes no longer than the
+// timeout except where a sink blocks. Stop returns the number of envelopes
+// that were lost.
+func (p *Pipeline) Stop(timeout time.Duration) uint64 {
+	deadline := time.Now().Add(timeout)
+
+	var lost uint64
+	for _, w := range p.processors {
+		if f, ok := w.(egress.Flusher); ok {
+			lost += f.Flush(time.Until(deadline))
+		}
+	}
+
+	for _, bw := range p.buffers {
+		lost += bw.Flush(time.Until(deadline))
+	}
+
+	for _, w := range p.sinks {
+		if f, ok := w.(egress.Flusher); ok {
+			lost += f.Flush(time.Until(deadline))
+		}
+
+		if c, ok := w.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				logger.Warnf("failed to close sink: %s", err)
+			}
+		}
+	}
+
+	return lost
+}
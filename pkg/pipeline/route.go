@@ -0,0 +1,165 @@
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+)
+
+// MatchTagsOption is a sink option available to every sink type. It is a
+// comma-separated list of key:value pairs and the sink is only written
+// envelopes with all of the tags.
+const MatchTagsOption = "match_tags"
+
+// MatchSourceIDOption is a sink option available to every sink type. It is
+// a regular expression and the sink is only written envelopes whose source
+// ID matches it.
+const MatchSourceIDOption = "match_source_id"
+
+// UnmatchedOption is a sink option available to every sink type. When it
+// is "true" the sink is only written envelopes that no sink with match
+// options was written.
+const UnmatchedOption = "unmatched"
+
+// route is a sink and the envelopes it is written.
+type route struct {
+	w         egress.Writer
+	match     func(*loggregator_v2.Envelope) bool
+	unmatched bool
+}
+
+// newRoute returns the route for a sink stage. ok is false if the stage has
+// no routing options and so is written every envelope.
+func newRoute(s Stage, w egress.Writer) (r route, ok bool, err error) {
+	r.w = w
+
+	var tags map[string]string
+	if v, has := s.Options[MatchTagsOption]; has {
+		tags, err = parseMatchTags(v)
+		if err != nil {
+			return route{}, false, err
+		}
+	}
+
+	var sourceID *regexp.Regexp
+	if v, has := s.Options[MatchSourceIDOption]; has {
+		sourceID, err = regexp.Compile(v)
+		if err != nil {
+			return route{}, false, fmt.Errorf("invalid %s: %s", MatchSourceIDOption, err)
+		}
+	}
+
+	if v, has := s.Options[UnmatchedOption]; has {
+		if v != "true" && v != "false" {
+			return route{}, false, fmt.Errorf("%s must be true or false", UnmatchedOption)
+		}
+		r.unmatched = v == "true"
+	}
+
+	if r.unmatched && (tags != nil || sourceID != nil) {
+		return route{}, false, fmt.Errorf("%s can not be combined with match options", UnmatchedOption)
+	}
+
+	if tags != nil || sourceID != nil {
+		r.match = func(e *loggregator_v2.Envelope) bool {
+			if sourceID != nil && !sourceID.MatchString(e.GetSourceId()) {
+				return false
+			}
+
+			for k, v := range tags {
+				if tagValue(e, k) != v {
+					return false
+				}
+			}
+
+			return true
+		}
+	}
+
+	return r, r.match != nil || r.unmatched, nil
+}
+
+func parseMatchTags(v string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%s must be key:value pairs, not %q", MatchTagsOption, pair)
+		}
+		tags[kv[0]] = kv[1]
+	}
+
+	return tags, nil
+}
+
+func tagValue(e *loggregator_v2.Envelope, key string) string {
+	if v, ok := e.GetTags()[key]; ok {
+		return v
+	}
+
+	return e.GetDeprecatedTags()[key].GetText()
+}
+
+// routeWriter writes the envelopes of each batch to the sinks they are
+// routed to. Sinks without routing options are written every envelope.
+type routeWriter []route
+
+// Write writes the envelopes of the batch to their sinks. If any sink fails
+// the last error is returned.
+func (r routeWriter) Write(batch []*loggregator_v2.Envelope) error {
+	routed := make([][]*loggregator_v2.Envelope, len(r))
+	matched := make([]bool, len(batch))
+	for i, rt := range r {
+		if rt.match == nil {
+			continue
+		}
+
+		for j, e := range batch {
+			if rt.match(e) {
+				routed[i] = append(routed[i], e)
+				matched[j] = true
+			}
+		}
+	}
+
+	var unmatched []*loggregator_v2.Envelope
+	for j, e := range batch {
+		if !matched[j] {
+			unmatched = append(unmatched, e)
+		}
+	}
+
+	var err error
+	for i, rt := range r {
+		switch {
+		case rt.unmatched:
+			routed[i] = unmatched
+		case rt.match == nil:
+			routed[i] = batch
+		}
+
+		if len(routed[i]) == 0 {
+			continue
+		}
+
+		if werr := rt.w.Write(routed[i]); werr != nil {
+			err = werr
+		}
+	}
+
+	return err
+}
+
+// BorrowsBatches reports whether every sink borrows batches.
+func (r routeWriter) BorrowsBatches() bool {
+	for _, rt := range r {
+		if !egress.BorrowsBatches(rt.w) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -3,13 +3,18 @@ package batching
 import (
 	"time"
 
-	"code.cloudfoundry.org/go-batching"
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 )
 
-// V2EnvelopeBatcher batches slices of bytes.
+// V2EnvelopeBatcher batches v2 envelopes. Batches are written once they are
+// full or once the interval has lapsed since the last write.
 type V2EnvelopeBatcher struct {
-	*batching.Batcher
+	size      int
+	interval  time.Duration
+	writer    V2EnvelopeWriter
+	reuse     bool
+	batch     []*loggregator_v2.Envelope
+	lastFlush time.Time
 }
 
 // V2EnvelopeWriter is used to submit the completed batch of v2 envelopes. The
@@ -28,24 +33,77 @@ func (f V2EnvelopeWriterFunc) Write(batch []*loggregator_v2.Envelope) {
 	f(batch)
 }
 
-// NewV2EnvelopeBatcher creates a new ByteBatcher.
-func NewV2EnvelopeBatcher(size int, interval time.Duration, writer V2EnvelopeWriter) *V2EnvelopeBatcher {
-	genWriter := batching.WriterFunc(func(batch []interface{}) {
-		envBatch := make([]*loggregator_v2.Envelope, 0, len(batch))
-		for _, element := range batch {
-			envBatch = append(envBatch, element.(*loggregator_v2.Envelope))
-		}
-		writer.Write(envBatch)
-	})
-	return &V2EnvelopeBatcher{
-		Batcher: batching.NewBatcher(size, interval, genWriter),
+// V2EnvelopeBatcherOption configures a V2EnvelopeBatcher.
+type V2EnvelopeBatcherOption func(*V2EnvelopeBatcher)
+
+// WithPooledBatches reuses the slice passed to the writer for the next batch
+// rather than allocating one for every batch, which reduces garbage
+// collection at high envelope rates. The writer must not retain the batch
+// after Write returns. Only the slice is reused: envelopes are not, as
+// buffers, drains and aggregating processors keep them after a write.
+func WithPooledBatches() V2EnvelopeBatcherOption {
+	return func(b *V2EnvelopeBatcher) {
+		b.reuse = true
 	}
 }
 
+// NewV2EnvelopeBatcher creates a new V2EnvelopeBatcher.
+func NewV2EnvelopeBatcher(size int, interval time.Duration, writer V2EnvelopeWriter, opts ...V2EnvelopeBatcherOption) *V2EnvelopeBatcher {
+	b := &V2EnvelopeBatcher{
+		size:      size,
+		interval:  interval,
+		writer:    writer,
+		lastFlush: time.Now(),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+
+	b.batch = make([]*loggregator_v2.Envelope, 0, size)
+
+	return b
+}
+
 // Write stores data to the batch. It will not submit the batch to the writer
 // until either the batch has been filled, or the interval has lapsed. NOTE:
 // Write is *not* thread safe and should be called by the same goroutine that
 // calls Flush.
 func (b *V2EnvelopeBatcher) Write(data *loggregator_v2.Envelope) {
-	b.Batcher.Write(data)
+	b.batch = append(b.batch, data)
+	if len(b.batch) >= b.size {
+		b.writeBatch()
+		return
+	}
+
+	b.Flush()
+}
+
+// Flush submits the batch if the interval has lapsed since the last write.
+func (b *V2EnvelopeBatcher) Flush() {
+	if len(b.batch) > 0 && time.Since(b.lastFlush) >= b.interval {
+		b.writeBatch()
+	}
+}
+
+// ForcedFlush submits the batch regardless of the interval.
+func (b *V2EnvelopeBatcher) ForcedFlush() {
+	if len(b.batch) > 0 {
+		b.writeBatch()
+	}
+}
+
+func (b *V2EnvelopeBatcher) writeBatch() {
+	b.writer.Write(b.batch)
+	b.lastFlush = time.Now()
+
+	if !b.reuse {
+		b.batch = make([]*loggregator_v2.Envelope, 0, b.size)
+		return
+	}
+
+	// Clear the envelopes so that the batch does not keep them alive.
+	for i := range b.batch {
+		b.batch[i] = nil
+	}
+	b.batch = b.batch[:0]
 }
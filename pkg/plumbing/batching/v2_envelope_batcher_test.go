@@ -1,6 +1,7 @@
 package batching_test
 
 import (
+	"testing"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -22,8 +23,78 @@ var _ = Describe("V2EnvelopeBatcher", func() {
 		Expect(writer.batch).To(HaveLen(1))
 		Expect(writer.batch[0].GetSourceId()).To(Equal("test-source-id"))
 	})
+
+	It("clears batches for reuse when pooled", func() {
+		var (
+			batches [][]*loggregator_v2.Envelope
+			ids     []string
+		)
+		writer := batching.V2EnvelopeWriterFunc(func(batch []*loggregator_v2.Envelope) {
+			batches = append(batches, batch)
+			for _, e := range batch {
+				ids = append(ids, e.GetSourceId())
+			}
+		})
+		b := batching.NewV2EnvelopeBatcher(2, time.Minute, writer, batching.WithPooledBatches())
+
+		for _, id := range []string{"a", "b", "c", "d"} {
+			b.Write(&loggregator_v2.Envelope{SourceId: id})
+		}
+
+		Expect(ids).To(Equal([]string{"a", "b", "c", "d"}))
+		Expect(batches).To(HaveLen(2))
+		Expect(batches[0]).To(ConsistOf(BeNil(), BeNil()))
+	})
 })
 
+func BenchmarkV2EnvelopeBatcher(b *testing.B) {
+	b.Run("allocated", func(b *testing.B) {
+		benchmarkV2EnvelopeBatcher(b, 0)
+	})
+	b.Run("pooled", func(b *testing.B) {
+		benchmarkV2EnvelopeBatcher(b, 0, batching.WithPooledBatches())
+	})
+	b.Run("allocated at 100k per second", func(b *testing.B) {
+		benchmarkV2EnvelopeBatcher(b, 100000)
+	})
+	b.Run("pooled at 100k per second", func(b *testing.B) {
+		benchmarkV2EnvelopeBatcher(b, 100000, batching.WithPooledBatches())
+	})
+}
+
+// benchmarkV2EnvelopeBatcher writes envelopes in the batch size and
+// interval the transponder uses, as fast as possible or at the given rate
+// per second. Each op is one envelope, so the allocations reported are per
+// envelope.
+func benchmarkV2EnvelopeBatcher(b *testing.B, rate int, opts ...batching.V2EnvelopeBatcherOption) {
+	var written int
+	writer := batching.V2EnvelopeWriterFunc(func(batch []*loggregator_v2.Envelope) {
+		written += len(batch)
+	})
+	batcher := batching.NewV2EnvelopeBatcher(100, 100*time.Millisecond, writer, opts...)
+	e := &loggregator_v2.Envelope{SourceId: "benchmark"}
+
+	// Paced envelopes are written in a burst every millisecond.
+	burst := rate / 1000
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if burst > 0 && i%burst == 0 {
+			<-ticker.C
+		}
+		batcher.Write(e)
+	}
+	batcher.ForcedFlush()
+	b.StopTimer()
+
+	if written != b.N {
+		b.Fatalf("wrote %d envelopes, expected %d", written, b.N)
+	}
+}
+
 type spyV2EnvelopeWriter struct {
 	batch  []*loggregator_v2.Envelope
 	called int
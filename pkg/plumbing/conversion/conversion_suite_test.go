@@ -0,0 +1,13 @@
+package conversion_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestConversion(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Conversion Suite")
+}
@@ -0,0 +1,190 @@
+// Package conversion converts dropsonde v1 envelopes to loggregator v2
+// envelopes.
+package conversion
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// ToV2 converts a v1 envelope to a v2 envelope. The v1 envelope's origin,
+// deployment, job, index and ip become tags alongside its own tags. Nil is
+// returned for an envelope of unknown type.
+func ToV2(e *events.Envelope) *loggregator_v2.Envelope {
+	v2e := &loggregator_v2.Envelope{
+		Timestamp: e.GetTimestamp(),
+		SourceId:  sourceID(e),
+		Tags:      tags(e),
+	}
+
+	switch e.GetEventType() {
+	case events.Envelope_LogMessage:
+		convertLogMessage(v2e, e.GetLogMessage())
+	case events.Envelope_ValueMetric:
+		convertValueMetric(v2e, e.GetValueMetric())
+	case events.Envelope_CounterEvent:
+		convertCounterEvent(v2e, e.GetCounterEvent())
+	case events.Envelope_ContainerMetric:
+		convertContainerMetric(v2e, e.GetContainerMetric())
+	case events.Envelope_HttpStartStop:
+		convertHTTPStartStop(v2e, e.GetHttpStartStop())
+	case events.Envelope_Error:
+		convertError(v2e, e.GetError())
+	default:
+		return nil
+	}
+
+	return v2e
+}
+
+func sourceID(e *events.Envelope) string {
+	if id := e.GetTags()["source_id"]; id != "" {
+		return id
+	}
+
+	return e.GetOrigin()
+}
+
+func tags(e *events.Envelope) map[string]string {
+	t := make(map[string]string, len(e.GetTags())+5)
+	for k, v := range e.GetTags() {
+		if k == "source_id" {
+			continue
+		}
+		t[k] = v
+	}
+
+	setTag(t, "origin", e.GetOrigin())
+	setTag(t, "deployment", e.GetDeployment())
+	setTag(t, "job", e.GetJob())
+	setTag(t, "index", e.GetIndex())
+	setTag(t, "ip", e.GetIp())
+
+	return t
+}
+
+func setTag(t map[string]string, k, v string) {
+	if v != "" {
+		t[k] = v
+	}
+}
+
+func convertLogMessage(v2e *loggregator_v2.Envelope, m *events.LogMessage) {
+	if m.GetAppId() != "" {
+		v2e.SourceId = m.GetAppId()
+	}
+	v2e.InstanceId = m.GetSourceInstance()
+	setTag(v2e.Tags, "source_type", m.GetSourceType())
+
+	if m.GetTimestamp() != 0 {
+		v2e.Timestamp = m.GetTimestamp()
+	}
+
+	logType := loggregator_v2.Log_OUT
+	if m.GetMessageType() == events.LogMessage_ERR {
+		logType = loggregator_v2.Log_ERR
+	}
+
+	v2e.Message = &loggregator_v2.Envelope_Log{
+		Log: &loggregator_v2.Log{
+			Payload: m.GetMessage(),
+			Type:    logType,
+		},
+	}
+}
+
+func convertValueMetric(v2e *loggregator_v2.Envelope, m *events.ValueMetric) {
+	v2e.Message = &loggregator_v2.Envelope_Gauge{
+		Gauge: &loggregator_v2.Gauge{
+			Metrics: map[string]*loggregator_v2.GaugeValue{
+				m.GetName(): {
+					Unit:  m.GetUnit(),
+					Value: m.GetValue(),
+				},
+			},
+		},
+	}
+}
+
+func convertCounterEvent(v2e *loggregator_v2.Envelope, m *events.CounterEvent) {
+	v2e.Message = &loggregator_v2.Envelope_Counter{
+		Counter: &loggregator_v2.Counter{
+			Name:  m.GetName(),
+			Delta: m.GetDelta(),
+			Total: m.GetTotal(),
+		},
+	}
+}
+
+func convertContainerMetric(v2e *loggregator_v2.Envelope, m *events.ContainerMetric) {
+	v2e.SourceId = m.GetApplicationId()
+	v2e.InstanceId = strconv.Itoa(int(m.GetInstanceIndex()))
+	v2e.Message = &loggregator_v2.Envelope_Gauge{
+		Gauge: &loggregator_v2.Gauge{
+			Metrics: map[string]*loggregator_v2.GaugeValue{
+				"cpu":          {Unit: "percentage", Value: m.GetCpuPercentage()},
+				"memory":       {Unit: "bytes", Value: float64(m.GetMemoryBytes())},
+				"disk":         {Unit: "bytes", Value: float64(m.GetDiskBytes())},
+				"memory_quota": {Unit: "bytes", Value: float64(m.GetMemoryBytesQuota())},
+				"disk_quota":   {Unit: "bytes", Value: float64(m.GetDiskBytesQuota())},
+			},
+		},
+	}
+}
+
+func convertHTTPStartStop(v2e *loggregator_v2.Envelope, m *events.HttpStartStop) {
+	if id := uuidString(m.GetApplicationId()); id != "" {
+		v2e.SourceId = id
+	}
+	v2e.InstanceId = m.GetInstanceId()
+
+	setTag(v2e.Tags, "request_id", uuidString(m.GetRequestId()))
+	setTag(v2e.Tags, "peer_type", m.GetPeerType().String())
+	setTag(v2e.Tags, "method", m.GetMethod().String())
+	setTag(v2e.Tags, "uri", m.GetUri())
+	setTag(v2e.Tags, "remote_address", m.GetRemoteAddress())
+	setTag(v2e.Tags, "user_agent", m.GetUserAgent())
+	setTag(v2e.Tags, "status_code", strconv.Itoa(int(m.GetStatusCode())))
+	setTag(v2e.Tags, "content_length", strconv.FormatInt(m.GetContentLength(), 10))
+	setTag(v2e.Tags, "instance_index", strconv.Itoa(int(m.GetInstanceIndex())))
+	setTag(v2e.Tags, "forwarded", strings.Join(m.GetForwarded(), "\n"))
+
+	v2e.Message = &loggregator_v2.Envelope_Timer{
+		Timer: &loggregator_v2.Timer{
+			Name:  "http",
+			Start: m.GetStartTimestamp(),
+			Stop:  m.GetStopTimestamp(),
+		},
+	}
+}
+
+func convertError(v2e *loggregator_v2.Envelope, m *events.Error) {
+	setTag(v2e.Tags, "source", m.GetSource())
+	setTag(v2e.Tags, "code", strconv.Itoa(int(m.GetCode())))
+
+	v2e.Message = &loggregator_v2.Envelope_Log{
+		Log: &loggregator_v2.Log{
+			Payload: []byte(m.GetMessage()),
+			Type:    loggregator_v2.Log_ERR,
+		},
+	}
+}
+
+// uuidString formats a dropsonde UUID in its canonical form. Dropsonde
+// stores the UUID's bytes as two little endian integers.
+func uuidString(id *events.UUID) string {
+	if id == nil {
+		return ""
+	}
+
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], id.GetLow())
+	binary.LittleEndian.PutUint64(b[8:], id.GetHigh())
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,164 @@
+package conversion_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing/conversion"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ToV2", func() {
+	It("converts envelope fields to tags", func() {
+		e := conversion.ToV2(&events.Envelope{
+			Origin:     proto.String("some-origin"),
+			EventType:  events.Envelope_ValueMetric.Enum(),
+			Timestamp:  proto.Int64(99),
+			Deployment: proto.String("some-deployment"),
+			Job:        proto.String("some-job"),
+			Index:      proto.String("some-index"),
+			Ip:         proto.String("10.0.0.1"),
+			Tags:       map[string]string{"source_id": "some-source", "custom": "value"},
+			ValueMetric: &events.ValueMetric{
+				Name:  proto.String("some-metric"),
+				Value: proto.Float64(1.5),
+				Unit:  proto.String("ms"),
+			},
+		})
+
+		Expect(e.Timestamp).To(Equal(int64(99)))
+		Expect(e.SourceId).To(Equal("some-source"))
+		Expect(e.Tags).To(Equal(map[string]string{
+			"origin":     "some-origin",
+			"deployment": "some-deployment",
+			"job":        "some-job",
+			"index":      "some-index",
+			"ip":         "10.0.0.1",
+			"custom":     "value",
+		}))
+		Expect(e.GetGauge().GetMetrics()).To(HaveKeyWithValue("some-metric", &loggregator_v2.GaugeValue{
+			Unit:  "ms",
+			Value: 1.5,
+		}))
+	})
+
+	It("uses the origin as the source id without a source_id tag", func() {
+		e := conversion.ToV2(&events.Envelope{
+			Origin:    proto.String("some-origin"),
+			EventType: events.Envelope_CounterEvent.Enum(),
+			CounterEvent: &events.CounterEvent{
+				Name:  proto.String("some-counter"),
+				Delta: proto.Uint64(2),
+				Total: proto.Uint64(10),
+			},
+		})
+
+		Expect(e.SourceId).To(Equal("some-origin"))
+		Expect(e.GetCounter()).To(Equal(&loggregator_v2.Counter{
+			Name:  "some-counter",
+			Delta: 2,
+			Total: 10,
+		}))
+	})
+
+	It("converts log messages", func() {
+		e := conversion.ToV2(&events.Envelope{
+			Origin:    proto.String("some-origin"),
+			EventType: events.Envelope_LogMessage.Enum(),
+			LogMessage: &events.LogMessage{
+				Message:        []byte("hello"),
+				MessageType:    events.LogMessage_ERR.Enum(),
+				Timestamp:      proto.Int64(123),
+				AppId:          proto.String("some-app"),
+				SourceType:     proto.String("APP/PROC/WEB"),
+				SourceInstance: proto.String("3"),
+			},
+		})
+
+		Expect(e.SourceId).To(Equal("some-app"))
+		Expect(e.InstanceId).To(Equal("3"))
+		Expect(e.Timestamp).To(Equal(int64(123)))
+		Expect(e.Tags).To(HaveKeyWithValue("source_type", "APP/PROC/WEB"))
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("hello")))
+		Expect(e.GetLog().GetType()).To(Equal(loggregator_v2.Log_ERR))
+	})
+
+	It("converts container metrics", func() {
+		e := conversion.ToV2(&events.Envelope{
+			Origin:    proto.String("some-origin"),
+			EventType: events.Envelope_ContainerMetric.Enum(),
+			ContainerMetric: &events.ContainerMetric{
+				ApplicationId:    proto.String("some-app"),
+				InstanceIndex:    proto.Int32(2),
+				CpuPercentage:    proto.Float64(50),
+				MemoryBytes:      proto.Uint64(1024),
+				DiskBytes:        proto.Uint64(2048),
+				MemoryBytesQuota: proto.Uint64(4096),
+				DiskBytesQuota:   proto.Uint64(8192),
+			},
+		})
+
+		Expect(e.SourceId).To(Equal("some-app"))
+		Expect(e.InstanceId).To(Equal("2"))
+		Expect(e.GetGauge().GetMetrics()).To(HaveLen(5))
+		Expect(e.GetGauge().GetMetrics()["cpu"].GetValue()).To(Equal(50.0))
+		Expect(e.GetGauge().GetMetrics()["memory"].GetValue()).To(Equal(1024.0))
+		Expect(e.GetGauge().GetMetrics()["disk_quota"].GetValue()).To(Equal(8192.0))
+	})
+
+	It("converts http start stop events to timers", func() {
+		e := conversion.ToV2(&events.Envelope{
+			Origin:    proto.String("some-origin"),
+			EventType: events.Envelope_HttpStartStop.Enum(),
+			HttpStartStop: &events.HttpStartStop{
+				StartTimestamp: proto.Int64(100),
+				StopTimestamp:  proto.Int64(200),
+				RequestId: &events.UUID{
+					Low:  proto.Uint64(0x0706050403020100),
+					High: proto.Uint64(0x0f0e0d0c0b0a0908),
+				},
+				PeerType:   events.PeerType_Client.Enum(),
+				Method:     events.Method_GET.Enum(),
+				Uri:        proto.String("https://example.com"),
+				StatusCode: proto.Int32(200),
+			},
+		})
+
+		Expect(e.GetTimer()).To(Equal(&loggregator_v2.Timer{
+			Name:  "http",
+			Start: 100,
+			Stop:  200,
+		}))
+		Expect(e.Tags).To(HaveKeyWithValue("request_id", "00010203-0405-0607-0809-0a0b0c0d0e0f"))
+		Expect(e.Tags).To(HaveKeyWithValue("peer_type", "Client"))
+		Expect(e.Tags).To(HaveKeyWithValue("method", "GET"))
+		Expect(e.Tags).To(HaveKeyWithValue("uri", "https://example.com"))
+		Expect(e.Tags).To(HaveKeyWithValue("status_code", "200"))
+	})
+
+	It("converts errors to error logs", func() {
+		e := conversion.ToV2(&events.Envelope{
+			Origin:    proto.String("some-origin"),
+			EventType: events.Envelope_Error.Enum(),
+			Error: &events.Error{
+				Source:  proto.String("some-source"),
+				Code:    proto.Int32(500),
+				Message: proto.String("it broke"),
+			},
+		})
+
+		Expect(e.GetLog().GetPayload()).To(Equal([]byte("it broke")))
+		Expect(e.GetLog().GetType()).To(Equal(loggregator_v2.Log_ERR))
+		Expect(e.Tags).To(HaveKeyWithValue("source", "some-source"))
+		Expect(e.Tags).To(HaveKeyWithValue("code", "500"))
+	})
+
+	It("returns nil for unknown event types", func() {
+		Expect(conversion.ToV2(&events.Envelope{
+			Origin:    proto.String("some-origin"),
+			EventType: events.Envelope_EventType(99).Enum(),
+		})).To(BeNil())
+	})
+})
@@ -0,0 +1,24 @@
+package plumbing
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// NewDebugHandler returns an http.Handler that serves the pprof profiles
+// (heap, goroutine, block, CPU, etc) under /debug/pprof/ and the published
+// expvar variables under /debug/vars. It does not rely on
+// http.DefaultServeMux so they are only exposed on the listener the handler
+// is explicitly served on.
+func NewDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return mux
+}
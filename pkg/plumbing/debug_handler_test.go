@@ -0,0 +1,57 @@
+package plumbing_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DebugHandler", func() {
+	var h http.Handler
+
+	BeforeEach(func() {
+		h = plumbing.NewDebugHandler()
+	})
+
+	It("serves pprof profiles", func() {
+		paths := []string{
+			"/debug/pprof/",
+			"/debug/pprof/heap",
+			"/debug/pprof/goroutine",
+			"/debug/pprof/block",
+			"/debug/pprof/cmdline",
+		}
+
+		for _, path := range paths {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+
+			h.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK), path)
+		}
+	})
+
+	It("serves expvar variables", func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(ContainSubstring(`"memstats"`))
+	})
+
+	It("does not serve anything outside of /debug", func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+
+		h.ServeHTTP(rec, req)
+
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+})
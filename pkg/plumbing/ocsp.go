@@ -0,0 +1,221 @@
+package plumbing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	// Register the hashes that OCSP certificate IDs may use.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// The types below decode the subset of OCSP responses (RFC 6960) that is
+// needed to check a stapled response for a single certificate.
+
+var (
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	ocspHashes = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+
+	ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.3.101.112":           x509.PureEd25519,
+	}
+)
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	Type     asn1.ObjectIdentifier
+	Response []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []ocspSingleResponse
+	Extensions  []pkix.Extension `asn1:"optional,explicit,tag:1"`
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Status     asn1.RawValue
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+// ocspStatus is the status of a certificate in an OCSP response.
+type ocspStatus int
+
+const (
+	ocspGood ocspStatus = iota
+	ocspRevoked
+	ocspUnknown
+)
+
+// ocspResult is the status of a certificate and the period the status is
+// valid for.
+type ocspResult struct {
+	status     ocspStatus
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+// parseOCSPResponse returns the status of cert in a DER encoded OCSP
+// response after verifying that the response was signed by issuer, or by a
+// responder that issuer delegated to.
+func parseOCSPResponse(der []byte, cert, issuer *x509.Certificate) (ocspResult, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return ocspResult{}, fmt.Errorf("malformed OCSP response: %s", err)
+	}
+	if resp.Status != 0 {
+		return ocspResult{}, fmt.Errorf("OCSP response has status %d", resp.Status)
+	}
+	if !resp.Response.Type.Equal(oidOCSPBasic) {
+		return ocspResult{}, errors.New("OCSP response is not a basic response")
+	}
+
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return ocspResult{}, fmt.Errorf("malformed OCSP basic response: %s", err)
+	}
+
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return ocspResult{}, fmt.Errorf("malformed OCSP response data: %s", err)
+	}
+
+	if err := verifyOCSPSignature(basic, issuer); err != nil {
+		return ocspResult{}, err
+	}
+
+	for _, r := range data.Responses {
+		if !ocspCertIDMatches(r.CertID, cert, issuer) {
+			continue
+		}
+
+		result := ocspResult{
+			thisUpdate: r.ThisUpdate,
+			nextUpdate: r.NextUpdate,
+		}
+		switch r.Status.Tag {
+		case 0:
+			result.status = ocspGood
+		case 1:
+			result.status = ocspRevoked
+		default:
+			result.status = ocspUnknown
+		}
+
+		return result, nil
+	}
+
+	return ocspResult{}, errors.New("OCSP response does not cover the certificate")
+}
+
+func verifyOCSPSignature(basic ocspBasicResponse, issuer *x509.Certificate) error {
+	algo, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported OCSP signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		delegate, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return fmt.Errorf("malformed OCSP responder certificate: %s", err)
+		}
+
+		if !bytes.Equal(delegate.Raw, issuer.Raw) {
+			if err := delegate.CheckSignatureFrom(issuer); err != nil {
+				return fmt.Errorf("OCSP responder certificate is not signed by the issuer: %s", err)
+			}
+			if !hasExtKeyUsage(delegate, x509.ExtKeyUsageOCSPSigning) {
+				return errors.New("OCSP responder certificate is not authorized to sign OCSP responses")
+			}
+			signer = delegate
+		}
+	}
+
+	if err := signer.CheckSignature(algo, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return fmt.Errorf("invalid OCSP response signature: %s", err)
+	}
+
+	return nil
+}
+
+func ocspCertIDMatches(id ocspCertID, cert, issuer *x509.Certificate) bool {
+	if id.SerialNumber == nil || id.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return false
+	}
+
+	hash, ok := ocspHashes[id.HashAlgorithm.Algorithm.String()]
+	if !ok {
+		return false
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+
+	h := hash.New()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+
+	h.Reset()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+
+	return bytes.Equal(id.IssuerKeyHash, keyHash) && bytes.Equal(id.NameHash, nameHash)
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
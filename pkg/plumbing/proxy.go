@@ -0,0 +1,111 @@
+package plumbing
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// NewProxyDialer returns a gRPC dialer that connects through the proxy at
+// proxyURL. HTTP proxies are asked to tunnel each connection with CONNECT and
+// SOCKS5 proxies are also supported. User information in the URL is used to
+// authenticate with the proxy.
+func NewProxyDialer(proxyURL string) (func(context.Context, string) (net.Conn, error), error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %s", err)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", proxyURL)
+	}
+
+	switch u.Scheme {
+	case "http":
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialConnect(ctx, u, addr)
+		}, nil
+	case "socks5":
+		d, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			if cd, ok := d.(proxy.ContextDialer); ok {
+				return cd.DialContext(ctx, "tcp", addr)
+			}
+			return d.Dial("tcp", addr)
+		}, nil
+	default:
+		return nil, fmt.Errorf("proxy URL scheme must be http or socks5, not %q", u.Scheme)
+	}
+}
+
+// dialConnect opens a tunnel to addr through the HTTP proxy at u.
+func dialConnect(ctx context.Context, u *url.URL, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused to connect to %s: %s", addr, resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn reads data the proxy sent after its response before reading
+// from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,155 @@
+package plumbing_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewProxyDialer", func() {
+	var (
+		target net.Listener
+		proxy  *spyConnectProxy
+	)
+
+	BeforeEach(func() {
+		var err error
+		target, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		go echo(target)
+
+		proxy = newSpyConnectProxy()
+	})
+
+	AfterEach(func() {
+		target.Close()
+		proxy.Close()
+	})
+
+	It("tunnels through an HTTP proxy", func() {
+		dial, err := plumbing.NewProxyDialer("http://user:secret@" + proxy.Listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+
+		conn, err := dial(context.Background(), target.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello\n"))
+		Expect(err).ToNot(HaveOccurred())
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		Expect(err).ToNot(HaveOccurred())
+		Expect(line).To(Equal("hello\n"))
+
+		Expect(proxy.requested()).To(Equal(target.Addr().String()))
+		Expect(proxy.authorization()).To(Equal("Basic dXNlcjpzZWNyZXQ="))
+	})
+
+	It("returns an error when the proxy refuses the tunnel", func() {
+		dial, err := plumbing.NewProxyDialer("http://" + proxy.Listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = dial(context.Background(), "refused.example.com:8082")
+		Expect(err).To(MatchError(ContainSubstring("403")))
+	})
+
+	It("accepts SOCKS5 proxies", func() {
+		_, err := plumbing.NewProxyDialer("socks5://127.0.0.1:1080")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("returns an error for an unsupported scheme", func() {
+		_, err := plumbing.NewProxyDialer("ftp://127.0.0.1:21")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for a URL without a host", func() {
+		_, err := plumbing.NewProxyDialer("http://")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func echo(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+type spyConnectProxy struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	host string
+	auth string
+}
+
+func newSpyConnectProxy() *spyConnectProxy {
+	p := &spyConnectProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+
+	return p
+}
+
+func (p *spyConnectProxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.host = r.Host
+	p.auth = r.Header.Get("Proxy-Authorization")
+	p.mu.Unlock()
+
+	if r.Method != http.MethodConnect || strings.HasPrefix(r.Host, "refused.") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	upstream, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+	go func() {
+		defer upstream.Close()
+		io.Copy(upstream, conn)
+	}()
+	go func() {
+		defer conn.Close()
+		io.Copy(conn, upstream)
+	}()
+}
+
+func (p *spyConnectProxy) requested() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.host
+}
+
+func (p *spyConnectProxy) authorization() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.auth
+}
@@ -0,0 +1,239 @@
+package plumbing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+)
+
+var logger = logging.New("plumbing")
+
+// RevocationChecker rejects TLS connections to servers whose certificate
+// has been revoked, according to a stapled OCSP response, a CRL file, or
+// both.
+type RevocationChecker struct {
+	crlPath       string
+	crlRefresh    time.Duration
+	ocspStapling  bool
+	requireStaple bool
+	now           func() time.Time
+	done          chan struct{}
+	stopOnce      sync.Once
+
+	mu   sync.RWMutex
+	crls []*x509.RevocationList
+}
+
+// RevocationOption configures a RevocationChecker.
+type RevocationOption func(*RevocationChecker)
+
+// WithCRLFile checks server certificates against the CRLs in a PEM or DER
+// encoded file, which is reloaded every refresh interval.
+func WithCRLFile(path string, refresh time.Duration) RevocationOption {
+	return func(c *RevocationChecker) {
+		c.crlPath = path
+		c.crlRefresh = refresh
+	}
+}
+
+// WithOCSPStapling checks OCSP responses stapled by servers. When required
+// is true, connections to servers that do not staple a current response
+// are rejected.
+func WithOCSPStapling(required bool) RevocationOption {
+	return func(c *RevocationChecker) {
+		c.ocspStapling = true
+		c.requireStaple = required
+	}
+}
+
+// WithRevocationClock sets the clock used to decide whether OCSP responses
+// are current. It is intended for tests.
+func WithRevocationClock(now func() time.Time) RevocationOption {
+	return func(c *RevocationChecker) {
+		c.now = now
+	}
+}
+
+// NewRevocationChecker returns a RevocationChecker. When a CRL file is
+// configured it is loaded immediately and an error is returned if it cannot
+// be.
+func NewRevocationChecker(opts ...RevocationOption) (*RevocationChecker, error) {
+	c := &RevocationChecker{
+		now:  time.Now,
+		done: make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	if c.crlPath != "" {
+		if err := c.loadCRLs(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// WithRevocationChecker rejects connections to servers whose certificate
+// the checker finds revoked. It is intended for client configs.
+func WithRevocationChecker(c *RevocationChecker) ConfigOption {
+	return func(tc *tls.Config) {
+		tc.VerifyConnection = c.VerifyConnection
+	}
+}
+
+// Start reloads the CRL file every refresh interval until Stop is called. A
+// file that fails to load is logged and the previous CRLs are kept.
+func (c *RevocationChecker) Start() {
+	if c.crlPath == "" || c.crlRefresh <= 0 {
+		return
+	}
+
+	t := time.NewTicker(c.crlRefresh)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := c.loadCRLs(); err != nil {
+				logger.Warnf("failed to reload CRL file, keeping previous CRLs: %s", err)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Stop causes Start to return.
+func (c *RevocationChecker) Stop() {
+	c.stopOnce.Do(func() { close(c.done) })
+}
+
+// VerifyConnection returns an error if the server's certificate has been
+// revoked. It must be used with a config that verifies the server's chain.
+func (c *RevocationChecker) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+		return errors.New("revocation checking requires a verified certificate chain")
+	}
+	cert, issuer := cs.VerifiedChains[0][0], cs.VerifiedChains[0][1]
+
+	if c.ocspStapling {
+		if err := c.checkStaple(cs.OCSPResponse, cert, issuer); err != nil {
+			return err
+		}
+	}
+
+	return c.checkCRLs(cert, issuer)
+}
+
+func (c *RevocationChecker) checkStaple(staple []byte, cert, issuer *x509.Certificate) error {
+	if len(staple) == 0 {
+		if c.requireStaple {
+			return errors.New("server did not staple an OCSP response")
+		}
+		return nil
+	}
+
+	result, err := parseOCSPResponse(staple, cert, issuer)
+	if err != nil {
+		if c.requireStaple {
+			return err
+		}
+		logger.Warnf("ignoring stapled OCSP response: %s", err)
+		return nil
+	}
+
+	now := c.now()
+	current := !now.Before(result.thisUpdate) && (result.nextUpdate.IsZero() || now.Before(result.nextUpdate))
+
+	switch {
+	case result.status == ocspRevoked:
+		return fmt.Errorf("certificate %s has been revoked", cert.SerialNumber)
+	case c.requireStaple && !current:
+		return errors.New("stapled OCSP response is not current")
+	case c.requireStaple && result.status != ocspGood:
+		return errors.New("stapled OCSP response does not report the certificate as good")
+	}
+
+	return nil
+}
+
+func (c *RevocationChecker) checkCRLs(cert, issuer *x509.Certificate) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, crl := range c.crls {
+		if crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("certificate %s has been revoked", cert.SerialNumber)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *RevocationChecker) loadCRLs() error {
+	data, err := ioutil.ReadFile(c.crlPath)
+	if err != nil {
+		return fmt.Errorf("failed to read CRL file: %s", err)
+	}
+
+	crls, err := parseCRLs(data)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.crls = crls
+	c.mu.Unlock()
+
+	return nil
+}
+
+// parseCRLs parses every CRL in PEM encoded data, or a single DER encoded
+// CRL.
+func parseCRLs(data []byte) ([]*x509.RevocationList, error) {
+	var crls []*x509.RevocationList
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CRL: %s", err)
+		}
+		crls = append(crls, crl)
+	}
+
+	if len(crls) > 0 {
+		return crls, nil
+	}
+
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL: %s", err)
+	}
+
+	return []*x509.RevocationList{crl}, nil
+}
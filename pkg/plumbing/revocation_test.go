@@ -0,0 +1,402 @@
+package plumbing_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RevocationChecker", func() {
+	var (
+		ca     *revocationCA
+		server tls.Certificate
+	)
+
+	BeforeEach(func() {
+		ca = newRevocationCA()
+		server = ca.issue("doppler", big.NewInt(1001), nil)
+	})
+
+	Describe("OCSP stapling", func() {
+		It("accepts servers stapling a good response", func() {
+			server.OCSPStaple = ca.ocspResponse(server, ocspGood, time.Hour)
+			c, err := plumbing.NewRevocationChecker(plumbing.WithOCSPStapling(true))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(revocationHandshake(ca, server, c)).To(Succeed())
+		})
+
+		It("rejects servers stapling a revoked response", func() {
+			server.OCSPStaple = ca.ocspResponse(server, ocspRevoked, time.Hour)
+			c, err := plumbing.NewRevocationChecker(plumbing.WithOCSPStapling(false))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(revocationHandshake(ca, server, c)).ToNot(Succeed())
+		})
+
+		It("honors revoked responses signed by a delegated responder", func() {
+			responder := ca.issue("ocsp", big.NewInt(2001), []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning})
+			server.OCSPStaple = ca.delegatedOCSPResponse(server, responder, ocspRevoked, time.Hour)
+			c, err := plumbing.NewRevocationChecker(plumbing.WithOCSPStapling(false))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(revocationHandshake(ca, server, c)).ToNot(Succeed())
+		})
+
+		It("accepts servers that do not staple unless a staple is required", func() {
+			c, err := plumbing.NewRevocationChecker(plumbing.WithOCSPStapling(false))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revocationHandshake(ca, server, c)).To(Succeed())
+
+			c, err = plumbing.NewRevocationChecker(plumbing.WithOCSPStapling(true))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revocationHandshake(ca, server, c)).ToNot(Succeed())
+		})
+
+		It("rejects expired responses when a staple is required", func() {
+			server.OCSPStaple = ca.ocspResponse(server, ocspGood, time.Hour)
+			c, err := plumbing.NewRevocationChecker(
+				plumbing.WithOCSPStapling(true),
+				plumbing.WithRevocationClock(func() time.Time { return time.Now().Add(2 * time.Hour) }),
+			)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(revocationHandshake(ca, server, c)).ToNot(Succeed())
+		})
+
+		It("ignores responses from other CAs unless a staple is required", func() {
+			other := newRevocationCA()
+			server.OCSPStaple = other.ocspResponse(server, ocspRevoked, time.Hour)
+
+			c, err := plumbing.NewRevocationChecker(plumbing.WithOCSPStapling(false))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revocationHandshake(ca, server, c)).To(Succeed())
+
+			c, err = plumbing.NewRevocationChecker(plumbing.WithOCSPStapling(true))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revocationHandshake(ca, server, c)).ToNot(Succeed())
+		})
+	})
+
+	Describe("CRL file", func() {
+		var (
+			dir  string
+			path string
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "revocation")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "doppler.crl")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("rejects servers with revoked certificates", func() {
+			ca.writeCRL(path, big.NewInt(1001))
+			c, err := plumbing.NewRevocationChecker(plumbing.WithCRLFile(path, time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(revocationHandshake(ca, server, c)).ToNot(Succeed())
+		})
+
+		It("accepts servers with certificates that are not revoked", func() {
+			ca.writeCRL(path, big.NewInt(1002))
+			c, err := plumbing.NewRevocationChecker(plumbing.WithCRLFile(path, time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(revocationHandshake(ca, server, c)).To(Succeed())
+		})
+
+		It("ignores CRLs from other CAs", func() {
+			newRevocationCA().writeCRL(path, big.NewInt(1001))
+			c, err := plumbing.NewRevocationChecker(plumbing.WithCRLFile(path, time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(revocationHandshake(ca, server, c)).To(Succeed())
+		})
+
+		It("reloads the file periodically", func() {
+			ca.writeCRL(path)
+			c, err := plumbing.NewRevocationChecker(plumbing.WithCRLFile(path, 10*time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+			go c.Start()
+			defer c.Stop()
+			Expect(revocationHandshake(ca, server, c)).To(Succeed())
+
+			ca.writeCRL(path, big.NewInt(1001))
+
+			Eventually(func() error {
+				return revocationHandshake(ca, server, c)
+			}).ShouldNot(Succeed())
+		})
+
+		It("returns an error when the file cannot be loaded", func() {
+			_, err := plumbing.NewRevocationChecker(plumbing.WithCRLFile(path, time.Hour))
+			Expect(err).To(HaveOccurred())
+
+			Expect(ioutil.WriteFile(path, []byte("not a crl"), 0600)).To(Succeed())
+			_, err = plumbing.NewRevocationChecker(plumbing.WithCRLFile(path, time.Hour))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+// revocationHandshake connects to a TLS server presenting cert with a
+// client that trusts ca and checks revocation with c.
+func revocationHandshake(ca *revocationCA, cert tls.Certificate, c *plumbing.RevocationChecker) error {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	Expect(err).ToNot(HaveOccurred())
+	defer lis.Close()
+
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tlsConfig := plumbing.NewTLSConfig()
+	tlsConfig.RootCAs = roots
+	tlsConfig.ServerName = "doppler"
+	plumbing.WithRevocationChecker(c)(tlsConfig)
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+type revocationCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newRevocationCA() *revocationCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "loggregatorCA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	return &revocationCA{cert: cert, key: key}
+}
+
+func (ca *revocationCA) issue(name string, serial *big.Int, usage []x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	if usage == nil {
+		usage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usage,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	Expect(err).ToNot(HaveOccurred())
+
+	leaf, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+}
+
+func (ca *revocationCA) writeCRL(path string, serials ...*big.Int) {
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, s := range serials {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   s,
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	Expect(err).ToNot(HaveOccurred())
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	Expect(ioutil.WriteFile(path+".tmp", data, 0600)).To(Succeed())
+	Expect(os.Rename(path+".tmp", path)).To(Succeed())
+}
+
+// The types below encode OCSP responses (RFC 6960) for tests.
+
+const (
+	ocspGood = iota
+	ocspRevoked
+)
+
+type testOCSPResponse struct {
+	Status   asn1.Enumerated
+	Response testOCSPResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type testOCSPResponseBytes struct {
+	Type     asn1.ObjectIdentifier
+	Response []byte
+}
+
+type testOCSPBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type testOCSPResponseData struct {
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []testOCSPSingleResponse
+}
+
+type testOCSPSingleResponse struct {
+	CertID     testOCSPCertID
+	Good       asn1.Flag           `asn1:"tag:0,optional"`
+	Revoked    testOCSPRevokedInfo `asn1:"tag:1,optional"`
+	ThisUpdate time.Time           `asn1:"generalized"`
+	NextUpdate time.Time           `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type testOCSPRevokedInfo struct {
+	RevocationTime time.Time `asn1:"generalized"`
+}
+
+type testOCSPCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+func (ca *revocationCA) ocspResponse(cert tls.Certificate, status int, validFor time.Duration) []byte {
+	return ca.signOCSPResponse(cert, ca.key, nil, status, validFor)
+}
+
+func (ca *revocationCA) delegatedOCSPResponse(cert, responder tls.Certificate, status int, validFor time.Duration) []byte {
+	return ca.signOCSPResponse(cert, responder.PrivateKey.(*ecdsa.PrivateKey), responder.Certificate, status, validFor)
+}
+
+func (ca *revocationCA) signOCSPResponse(
+	cert tls.Certificate,
+	key *ecdsa.PrivateKey,
+	certs [][]byte,
+	status int,
+	validFor time.Duration,
+) []byte {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(ca.cert.RawSubjectPublicKeyInfo, &spki)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	nameHash := sha1.Sum(ca.cert.RawSubject)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	single := testOCSPSingleResponse{
+		CertID: testOCSPCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26},
+				Parameters: asn1.NullRawValue,
+			},
+			NameHash:      nameHash[:],
+			IssuerKeyHash: keyHash[:],
+			SerialNumber:  cert.Leaf.SerialNumber,
+		},
+		ThisUpdate: now.Add(-time.Minute),
+		NextUpdate: now.Add(validFor),
+	}
+	if status == ocspRevoked {
+		single.Revoked = testOCSPRevokedInfo{RevocationTime: now.Add(-time.Minute)}
+	} else {
+		single.Good = true
+	}
+
+	responderID, err := asn1.Marshal(keyHash[:])
+	Expect(err).ToNot(HaveOccurred())
+
+	tbs, err := asn1.Marshal(testOCSPResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: responderID},
+		ProducedAt:  now,
+		Responses:   []testOCSPSingleResponse{single},
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	digest := sha256.Sum256(tbs)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	Expect(err).ToNot(HaveOccurred())
+
+	basic := testOCSPBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	}
+	for _, c := range certs {
+		basic.Certificates = append(basic.Certificates, asn1.RawValue{FullBytes: c})
+	}
+
+	basicDER, err := asn1.Marshal(basic)
+	Expect(err).ToNot(HaveOccurred())
+
+	resp, err := asn1.Marshal(testOCSPResponse{
+		Response: testOCSPResponseBytes{
+			Type:     asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1},
+			Response: basicDER,
+		},
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	return resp
+}
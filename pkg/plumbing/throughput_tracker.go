@@ -0,0 +1,40 @@
+package plumbing
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ThroughputTracker keeps track of the number of bytes emitted by a grpc
+// client. It can be passed to a stats handler alongside an
+// EnvelopeAverager. It should be constructed with NewThroughputTracker.
+type ThroughputTracker struct {
+	// bytes must be accessed via atomics
+	bytes uint64
+}
+
+// NewThroughputTracker creates a new ThroughputTracker.
+func NewThroughputTracker() *ThroughputTracker {
+	return &ThroughputTracker{}
+}
+
+// Track adds the given size (in bytes) to the total. It can be called by
+// several go-routines.
+func (t *ThroughputTracker) Track(_, size int) {
+	atomic.AddUint64(&t.bytes, uint64(size))
+}
+
+// Start invokes the given callback with the bytes per second emitted over
+// the past interval.
+func (t *ThroughputTracker) Start(interval time.Duration, f func(bytesPerSecond float64)) {
+	go func() {
+		var prev uint64
+		for range time.Tick(interval) {
+			current := atomic.LoadUint64(&t.bytes)
+			delta := current - prev
+			prev = current
+
+			f(float64(delta) / interval.Seconds())
+		}
+	}()
+}
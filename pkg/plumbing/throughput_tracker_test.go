@@ -0,0 +1,33 @@
+package plumbing_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ThroughputTracker", func() {
+	var (
+		t *plumbing.ThroughputTracker
+		c chan float64
+	)
+
+	BeforeEach(func() {
+		t = plumbing.NewThroughputTracker()
+		c = make(chan float64, 100)
+	})
+
+	It("emits the bytes per second of the past interval", func() {
+		t.Track(3, 100)
+		t.Track(1, 50)
+		t.Start(500*time.Millisecond, func(f float64) {
+			c <- f
+		})
+
+		Eventually(c).Should(Receive(Equal(300.0)))
+		Eventually(c).Should(Receive(Equal(0.0)))
+	})
+})
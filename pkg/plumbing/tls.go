@@ -74,14 +74,24 @@ func NewClientMutualTLSConfig(
 	keyFile string,
 	caCertFile string,
 	serverName string,
+	opts ...ConfigOption,
 ) (*tls.Config, error) {
-	return newMutualTLSConfig(
+	tlsConfig, err := newMutualTLSConfig(
 		certFile,
 		keyFile,
 		caCertFile,
 		serverName,
 		true,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, opt := range opts {
+		opt(tlsConfig)
+	}
+
+	return tlsConfig, nil
 }
 
 // NewServerMutualTLSConfig returns a tls.Config with certs loaded from files.
@@ -117,12 +127,14 @@ func NewClientCredentials(
 	keyFile string,
 	caCertFile string,
 	serverName string,
+	opts ...ConfigOption,
 ) (credentials.TransportCredentials, error) {
 	tlsConfig, err := NewClientMutualTLSConfig(
 		certFile,
 		keyFile,
 		caCertFile,
 		serverName,
+		opts...,
 	)
 	if err != nil {
 		return nil, err
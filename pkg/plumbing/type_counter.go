@@ -0,0 +1,69 @@
+package plumbing
+
+import (
+	"code.cloudfoundry.org/go-loggregator/pulseemitter"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// envelopeTypes are the message types envelopes are counted by, in the
+// order of typeIndex.
+var envelopeTypes = []string{"log", "counter", "gauge", "timer", "event"}
+
+// MetricClient creates new CounterMetrics to be emitted periodically.
+type MetricClient interface {
+	NewCounterMetric(name string, opts ...pulseemitter.MetricOption) pulseemitter.CounterMetric
+}
+
+// TypeCounter counts envelopes with a counter per message type (log,
+// counter, gauge, timer and event), tagged with the type. Envelopes
+// without a known message are not counted. It should be constructed with
+// NewTypeCounter.
+type TypeCounter struct {
+	counters []pulseemitter.CounterMetric
+}
+
+// NewTypeCounter creates the counters named name for each message type.
+func NewTypeCounter(m MetricClient, name string) *TypeCounter {
+	counters := make([]pulseemitter.CounterMetric, 0, len(envelopeTypes))
+	for _, t := range envelopeTypes {
+		counters = append(counters, m.NewCounterMetric(name,
+			pulseemitter.WithVersion(2, 0),
+			pulseemitter.WithTags(map[string]string{"type": t}),
+		))
+	}
+
+	return &TypeCounter{counters: counters}
+}
+
+// Count increments the counter of each envelope's message type.
+func (c *TypeCounter) Count(envelopes []*loggregator_v2.Envelope) {
+	var counts [5]uint64
+	for _, e := range envelopes {
+		if i := typeIndex(e); i >= 0 {
+			counts[i]++
+		}
+	}
+
+	for i, n := range counts {
+		if n > 0 {
+			c.counters[i].Increment(n)
+		}
+	}
+}
+
+func typeIndex(e *loggregator_v2.Envelope) int {
+	switch e.GetMessage().(type) {
+	case *loggregator_v2.Envelope_Log:
+		return 0
+	case *loggregator_v2.Envelope_Counter:
+		return 1
+	case *loggregator_v2.Envelope_Gauge:
+		return 2
+	case *loggregator_v2.Envelope_Timer:
+		return 3
+	case *loggregator_v2.Envelope_Event:
+		return 4
+	default:
+		return -1
+	}
+}
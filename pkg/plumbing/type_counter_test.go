@@ -0,0 +1,29 @@
+package plumbing_test
+
+import (
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/internal/testhelper"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TypeCounter", func() {
+	It("counts envelopes by message type", func() {
+		metricClient := testhelper.NewMetricClient()
+		c := plumbing.NewTypeCounter(metricClient, "by_type")
+
+		event := &loggregator_v2.Envelope{
+			Message: &loggregator_v2.Envelope_Event{Event: &loggregator_v2.Event{}},
+		}
+		log := &loggregator_v2.Envelope{
+			Message: &loggregator_v2.Envelope_Log{Log: &loggregator_v2.Log{}},
+		}
+		c.Count([]*loggregator_v2.Envelope{event, log, event, {}, nil})
+
+		// The spy metric client keeps the last counter created, which is
+		// the event counter.
+		Expect(metricClient.GetMetric("by_type").Delta()).To(Equal(uint64(2)))
+	})
+})
@@ -0,0 +1,242 @@
+// Package ratelimit provides token bucket rate limiters whose rates can be
+// changed while they are in use.
+package ratelimit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is the number of buckets a Limiter holds before it discards
+// the buckets that have refilled. A full bucket behaves the same as a new
+// one so discarding it does not change what is allowed.
+const maxIdleBuckets = 10000
+
+// Limit is the rate at which tokens are added to a bucket and the most
+// tokens a bucket can hold. A Rate of zero or less is unlimited.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (l Limit) unlimited() bool {
+	return l.Rate <= 0
+}
+
+// Validate returns an error if the limit would allow nothing: a limited
+// rate with a burst of less than one token.
+func (l Limit) Validate() error {
+	if !l.unlimited() && l.Burst < 1 {
+		return errors.New("burst must be at least 1")
+	}
+
+	return nil
+}
+
+// LimiterOption configures a Limiter.
+type LimiterOption func(*Limiter)
+
+// WithMetricHook sets a function that is called with the result of every
+// Allow.
+func WithMetricHook(f func(key string, allowed bool)) LimiterOption {
+	return func(l *Limiter) {
+		l.hook = f
+	}
+}
+
+// WithClock sets the function the Limiter uses to read the current time.
+func WithClock(now func() time.Time) LimiterOption {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// Limiter is a set of token buckets keyed by arbitrary strings. Every key
+// uses the default limit unless a limit has been set for it.
+type Limiter struct {
+	mu        sync.Mutex
+	def       Limit
+	overrides map[string]Limit
+	buckets   map[string]*bucket
+	now       func() time.Time
+	hook      func(key string, allowed bool)
+}
+
+// NewLimiter returns a Limiter with the given default limit.
+func NewLimiter(def Limit, opts ...LimiterOption) *Limiter {
+	l := &Limiter{
+		def:       def,
+		overrides: make(map[string]Limit),
+		buckets:   make(map[string]*bucket),
+		now:       time.Now,
+		hook:      func(string, bool) {},
+	}
+
+	for _, o := range opts {
+		o(l)
+	}
+
+	return l
+}
+
+// Allow reports whether a single token is available for the key and takes
+// it if so.
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n tokens are available for the key and takes them
+// if so.
+func (l *Limiter) AllowN(key string, n int) bool {
+	l.mu.Lock()
+	allowed := l.allowN(key, n)
+	l.mu.Unlock()
+
+	l.hook(key, allowed)
+
+	return allowed
+}
+
+// TakeUpTo takes as many of n tokens as are available for the key and
+// returns how many it took. Unlike AllowN it never refuses a request for more
+// tokens than the burst outright, so callers can admit part of a batch.
+func (l *Limiter) TakeUpTo(key string, n int) int {
+	l.mu.Lock()
+	taken := l.takeUpTo(key, n)
+	l.mu.Unlock()
+
+	l.hook(key, taken == n)
+
+	return taken
+}
+
+func (l *Limiter) allowN(key string, n int) bool {
+	limit := l.limit(key)
+	if limit.unlimited() {
+		return true
+	}
+
+	b := l.bucket(key, limit)
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+
+	return true
+}
+
+func (l *Limiter) takeUpTo(key string, n int) int {
+	limit := l.limit(key)
+	if limit.unlimited() {
+		return n
+	}
+
+	b := l.bucket(key, limit)
+	if avail := int(b.tokens); avail < n {
+		n = avail
+	}
+	b.tokens -= float64(n)
+
+	return n
+}
+
+// bucket returns the refilled bucket of the key, creating a full one if the
+// key has none.
+func (l *Limiter) bucket(key string, limit Limit) *bucket {
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.discardFull(now)
+		}
+
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(limit, now)
+
+	return b
+}
+
+// Default returns the limit used by keys without their own limit.
+func (l *Limiter) Default() Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.def
+}
+
+// SetDefault changes the limit used by keys without their own limit.
+func (l *Limiter) SetDefault(limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.def = limit
+}
+
+// SetLimit sets the limit for a single key.
+func (l *Limiter) SetLimit(key string, limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.overrides[key] = limit
+}
+
+// RemoveLimit returns the key to the default limit.
+func (l *Limiter) RemoveLimit(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.overrides, key)
+}
+
+// Limits returns every key that has its own limit.
+func (l *Limiter) Limits() map[string]Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits := make(map[string]Limit, len(l.overrides))
+	for k, v := range l.overrides {
+		limits[k] = v
+	}
+
+	return limits
+}
+
+func (l *Limiter) limit(key string) Limit {
+	if limit, ok := l.overrides[key]; ok {
+		return limit
+	}
+
+	return l.def
+}
+
+func (l *Limiter) discardFull(now time.Time) {
+	for key, b := range l.buckets {
+		limit := l.limit(key)
+		b.refill(limit, now)
+		if b.tokens >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the bucket was last refilled. A
+// bucket never holds more than the limit's burst, so lowering a limit takes
+// effect immediately.
+func (b *bucket) refill(limit Limit, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * limit.Rate
+		b.last = now
+	}
+
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+}
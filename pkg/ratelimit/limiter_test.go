@@ -0,0 +1,128 @@
+package ratelimit_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/ratelimit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limiter", func() {
+	var (
+		now time.Time
+		l   *ratelimit.Limiter
+	)
+
+	clock := func() time.Time {
+		return now
+	}
+
+	BeforeEach(func() {
+		now = time.Unix(0, 0)
+		l = ratelimit.NewLimiter(
+			ratelimit.Limit{Rate: 1, Burst: 2},
+			ratelimit.WithClock(clock),
+		)
+	})
+
+	It("allows up to the burst and then refills at the rate", func() {
+		Expect(l.Allow("a")).To(BeTrue())
+		Expect(l.Allow("a")).To(BeTrue())
+		Expect(l.Allow("a")).To(BeFalse())
+
+		now = now.Add(time.Second)
+		Expect(l.Allow("a")).To(BeTrue())
+		Expect(l.Allow("a")).To(BeFalse())
+	})
+
+	It("keeps a bucket per key", func() {
+		Expect(l.AllowN("a", 2)).To(BeTrue())
+		Expect(l.Allow("a")).To(BeFalse())
+		Expect(l.Allow("b")).To(BeTrue())
+	})
+
+	It("does not take tokens when too few are available", func() {
+		Expect(l.AllowN("a", 3)).To(BeFalse())
+		Expect(l.AllowN("a", 2)).To(BeTrue())
+	})
+
+	It("takes as many tokens as are available", func() {
+		Expect(l.TakeUpTo("a", 5)).To(Equal(2))
+		Expect(l.TakeUpTo("a", 5)).To(Equal(0))
+
+		now = now.Add(time.Second)
+		Expect(l.TakeUpTo("a", 5)).To(Equal(1))
+	})
+
+	It("uses a key's own limit over the default", func() {
+		l.SetLimit("a", ratelimit.Limit{Rate: 1, Burst: 5})
+		Expect(l.Limits()).To(Equal(map[string]ratelimit.Limit{
+			"a": {Rate: 1, Burst: 5},
+		}))
+
+		Expect(l.AllowN("a", 5)).To(BeTrue())
+
+		l.RemoveLimit("a")
+		now = now.Add(time.Minute)
+		Expect(l.AllowN("a", 3)).To(BeFalse())
+		Expect(l.AllowN("a", 2)).To(BeTrue())
+	})
+
+	It("applies a lowered default immediately", func() {
+		Expect(l.Allow("a")).To(BeTrue())
+
+		l.SetDefault(ratelimit.Limit{Rate: 1, Burst: 0})
+		Expect(l.Default()).To(Equal(ratelimit.Limit{Rate: 1, Burst: 0}))
+		Expect(l.Allow("a")).To(BeFalse())
+	})
+
+	It("is unlimited without a rate", func() {
+		l.SetDefault(ratelimit.Limit{})
+		for i := 0; i < 100; i++ {
+			Expect(l.Allow("a")).To(BeTrue())
+		}
+	})
+
+	It("rejects a limited rate with a burst of less than one", func() {
+		Expect(ratelimit.Limit{Rate: 0.5}.Validate()).ToNot(Succeed())
+		Expect(ratelimit.Limit{Rate: 0.5, Burst: 1}.Validate()).To(Succeed())
+		Expect(ratelimit.Limit{}.Validate()).To(Succeed())
+	})
+
+	It("calls the metric hook with each result", func() {
+		results := map[bool]int{}
+		l = ratelimit.NewLimiter(
+			ratelimit.Limit{Rate: 1, Burst: 1},
+			ratelimit.WithClock(clock),
+			ratelimit.WithMetricHook(func(key string, allowed bool) {
+				Expect(key).To(Equal("a"))
+				results[allowed]++
+			}),
+		)
+
+		l.Allow("a")
+		l.Allow("a")
+
+		Expect(results).To(Equal(map[bool]int{true: 1, false: 1}))
+	})
+})
+
+var _ = Describe("Registry", func() {
+	It("returns registered limiters by name", func() {
+		r := ratelimit.NewRegistry()
+		a := ratelimit.NewLimiter(ratelimit.Limit{})
+		r.Register("b", ratelimit.NewLimiter(ratelimit.Limit{}))
+		r.Register("a", a)
+
+		Expect(r.Names()).To(Equal([]string{"a", "b"}))
+
+		l, ok := r.Get("a")
+		Expect(ok).To(BeTrue())
+		Expect(l).To(BeIdenticalTo(a))
+
+		_, ok = r.Get("c")
+		Expect(ok).To(BeFalse())
+	})
+})
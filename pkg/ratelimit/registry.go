@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry holds the agent's named limiters so that they can be adjusted
+// at runtime.
+type Registry struct {
+	mu       sync.Mutex
+	limiters map[string]*Limiter
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		limiters: make(map[string]*Limiter),
+	}
+}
+
+// Register adds a limiter with the given name. Registering a name again
+// replaces the previous limiter.
+func (r *Registry) Register(name string, l *Limiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limiters[name] = l
+}
+
+// Get returns the named limiter.
+func (r *Registry) Get(name string) (*Limiter, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, ok := r.limiters[name]
+	return l, ok
+}
+
+// Names returns the name of every limiter in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.limiters))
+	for name := range r.limiters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,95 @@
+package scraper
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// TargetUpdater is given the full set of targets each time the scrape config
+// changes.
+type TargetUpdater interface {
+	Update(targets []Target)
+}
+
+// ConfigWatcher polls a scrape config file and updates the scrape targets
+// whenever the contents of the file change.
+type ConfigWatcher struct {
+	path     string
+	interval time.Duration
+	updater  TargetUpdater
+	log      *log.Logger
+
+	lastContents []byte
+}
+
+// NewConfigWatcher returns a ConfigWatcher for the scrape config at the given
+// path.
+func NewConfigWatcher(
+	path string,
+	interval time.Duration,
+	u TargetUpdater,
+	l *log.Logger,
+) *ConfigWatcher {
+	return &ConfigWatcher{
+		path:     path,
+		interval: interval,
+		updater:  u,
+		log:      l,
+	}
+}
+
+// Start loads the scrape config and then polls it for changes. It blocks
+// forever.
+func (w *ConfigWatcher) Start() {
+	w.Reload()
+
+	for range time.Tick(w.interval) {
+		w.Reload()
+	}
+}
+
+// Reload reads the scrape config and updates the targets if the file has
+// changed since it was last read. An invalid config is logged and the
+// current targets are left in place.
+func (w *ConfigWatcher) Reload() {
+	contents, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		w.log.Printf("failed to read scrape config %s: %s", w.path, err)
+		return
+	}
+
+	if w.lastContents != nil && bytes.Equal(contents, w.lastContents) {
+		return
+	}
+
+	targets, err := ParseTargets(contents)
+	if err != nil {
+		w.log.Printf("failed to parse scrape config %s: %s", w.path, err)
+		return
+	}
+
+	w.lastContents = contents
+	w.log.Printf("loaded %d scrape targets from %s", len(targets), w.path)
+	w.updater.Update(targets)
+}
+
+// ParseTargets parses a YAML list of scrape targets.
+func ParseTargets(contents []byte) ([]Target, error) {
+	var targets []Target
+	if err := yaml.Unmarshal(contents, &targets); err != nil {
+		return nil, err
+	}
+
+	for _, t := range targets {
+		if t.SourceID == "" || t.URL == "" {
+			return nil, fmt.Errorf("scrape target requires source_id and url: %+v", t)
+		}
+	}
+
+	return targets, nil
+}
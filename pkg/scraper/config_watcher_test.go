@@ -0,0 +1,88 @@
+package scraper_test
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/scraper"
+)
+
+var _ = Describe("ConfigWatcher", func() {
+	var (
+		path    string
+		updater *spyTargetUpdater
+		w       *scraper.ConfigWatcher
+	)
+
+	BeforeEach(func() {
+		f, err := ioutil.TempFile("", "scrape-config")
+		Expect(err).ToNot(HaveOccurred())
+		f.Close()
+		path = f.Name()
+
+		updater = &spyTargetUpdater{}
+		w = scraper.NewConfigWatcher(path, time.Hour, updater, log.New(GinkgoWriter, "", 0))
+	})
+
+	AfterEach(func() {
+		os.Remove(path)
+	})
+
+	It("updates the targets from the config", func() {
+		writeScrapeConfig(path, `
+- source_id: id-1
+  url: http://target-1/metrics
+- source_id: id-2
+  url: http://target-2/metrics
+`)
+		w.Reload()
+
+		Expect(updater.calls).To(HaveLen(1))
+		Expect(updater.calls[0]).To(ConsistOf(
+			scraper.Target{SourceID: "id-1", URL: "http://target-1/metrics"},
+			scraper.Target{SourceID: "id-2", URL: "http://target-2/metrics"},
+		))
+	})
+
+	It("only updates the targets when the config changes", func() {
+		writeScrapeConfig(path, `
+- source_id: id-1
+  url: http://target-1/metrics
+`)
+		w.Reload()
+		w.Reload()
+		Expect(updater.calls).To(HaveLen(1))
+
+		writeScrapeConfig(path, `[]`)
+		w.Reload()
+		Expect(updater.calls).To(HaveLen(2))
+		Expect(updater.calls[1]).To(BeEmpty())
+	})
+
+	It("keeps the current targets when the config is invalid", func() {
+		writeScrapeConfig(path, `
+- source_id: id-1
+`)
+		w.Reload()
+
+		Expect(updater.calls).To(BeEmpty())
+	})
+})
+
+type spyTargetUpdater struct {
+	calls [][]scraper.Target
+}
+
+func (s *spyTargetUpdater) Update(targets []scraper.Target) {
+	s.calls = append(s.calls, targets)
+}
+
+func writeScrapeConfig(path, contents string) {
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	Expect(err).ToNot(HaveOccurred())
+}
@@ -0,0 +1,185 @@
+package scraper
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	loggregator "code.cloudfoundry.org/go-loggregator"
+)
+
+// Target is a single Prometheus endpoint to be scraped. Metrics scraped from
+// the target are emitted with the given source ID.
+type Target struct {
+	SourceID string `yaml:"source_id"`
+	URL      string `yaml:"url"`
+}
+
+// ManagerMetricClient is used by the Manager to emit scraped metrics as well
+// as metrics about the scrape loops themselves.
+type ManagerMetricClient interface {
+	MetricClient
+	EmitCounter(name string, opts ...loggregator.EmitCounterOption)
+}
+
+// Manager maintains a scrape loop for each configured Target. Targets can be
+// added and removed at runtime via Update.
+type Manager struct {
+	sourceID     string
+	interval     time.Duration
+	metricClient ManagerMetricClient
+	doer         Doer
+	log          *log.Logger
+
+	mu    sync.Mutex
+	loops map[Target]chan struct{}
+
+	stopOnce sync.Once
+	done     chan struct{}
+	running  sync.WaitGroup
+}
+
+// NewManager returns a Manager that scrapes each target on the given
+// interval. Metrics about the Manager itself are emitted with the given
+// source ID on the same interval.
+func NewManager(
+	sourceID string,
+	interval time.Duration,
+	c ManagerMetricClient,
+	d Doer,
+	l *log.Logger,
+) *Manager {
+	m := &Manager{
+		sourceID:     sourceID,
+		interval:     interval,
+		metricClient: c,
+		doer:         d,
+		log:          l,
+		loops:        make(map[Target]chan struct{}),
+		done:         make(chan struct{}),
+	}
+	m.running.Add(1)
+	go m.reportTargets()
+
+	return m
+}
+
+// Stop stops every scrape loop and the reporting of the Manager's metrics,
+// and waits for them to return. Targets should not be updated once the
+// Manager is stopped.
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+	})
+
+	m.mu.Lock()
+	for t, done := range m.loops {
+		close(done)
+		delete(m.loops, t)
+	}
+	m.mu.Unlock()
+
+	m.running.Wait()
+}
+
+// Update starts scrape loops for targets that are not yet being scraped and
+// stops scrape loops for targets that are no longer present.
+func (m *Manager) Update(targets []Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	desired := make(map[Target]bool, len(targets))
+	for _, t := range targets {
+		desired[t] = true
+	}
+
+	for t, done := range m.loops {
+		if desired[t] {
+			continue
+		}
+
+		m.log.Printf("removing scrape target %s (%s)", t.URL, t.SourceID)
+		close(done)
+		delete(m.loops, t)
+	}
+
+	for t := range desired {
+		if _, ok := m.loops[t]; ok {
+			continue
+		}
+
+		m.log.Printf("adding scrape target %s (%s)", t.URL, t.SourceID)
+		done := make(chan struct{})
+		m.loops[t] = done
+		m.running.Add(1)
+		go m.scrapeLoop(t, done)
+	}
+
+	m.emitActiveTargets()
+}
+
+// ActiveTargets returns the number of targets currently being scraped.
+func (m *Manager) ActiveTargets() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.loops)
+}
+
+// reportTargets emits the number of active targets on every interval so
+// that the gauge does not go stale between updates.
+func (m *Manager) reportTargets() {
+	defer m.running.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		m.emitActiveTargets()
+		m.mu.Unlock()
+	}
+}
+
+// emitActiveTargets emits the number of active targets. It must be called
+// with mu held.
+func (m *Manager) emitActiveTargets() {
+	m.metricClient.EmitGauge(
+		loggregator.WithGaugeSourceInfo(m.sourceID, ""),
+		loggregator.WithGaugeValue("active_scrape_targets", float64(len(m.loops)), "targets"),
+	)
+}
+
+func (m *Manager) scrapeLoop(t Target, done chan struct{}) {
+	defer m.running.Done()
+
+	s := New(t.SourceID, t.URL, m.metricClient, m.doer)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.Scrape(); err != nil {
+			m.log.Printf("failed to scrape %s: %s", t.URL, err)
+			m.metricClient.EmitCounter("scrape_errors",
+				loggregator.WithCounterSourceInfo(m.sourceID, ""),
+				loggregator.WithEnvelopeTags(map[string]string{
+					"target":           t.URL,
+					"target_source_id": t.SourceID,
+				}),
+			)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package scraper_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	loggregator "code.cloudfoundry.org/go-loggregator"
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/scraper"
+)
+
+var _ = Describe("Manager", func() {
+	var (
+		doer         *spyURLDoer
+		metricClient *spyManagerMetricClient
+		m            *scraper.Manager
+	)
+
+	BeforeEach(func() {
+		doer = newSpyURLDoer()
+		metricClient = newSpyManagerMetricClient()
+		m = scraper.NewManager(
+			"scraper-id",
+			10*time.Millisecond,
+			metricClient,
+			doer,
+			log.New(GinkgoWriter, "", 0),
+		)
+	})
+
+	AfterEach(func() {
+		m.Stop()
+	})
+
+	It("scrapes each target", func() {
+		m.Update([]scraper.Target{
+			{SourceID: "id-1", URL: "http://target-1/metrics"},
+			{SourceID: "id-2", URL: "http://target-2/metrics"},
+		})
+
+		Eventually(doer.urls).Should(And(
+			ContainElement("http://target-1/metrics"),
+			ContainElement("http://target-2/metrics"),
+		))
+		Eventually(metricClient.sourceIDs).Should(And(
+			ContainElement("id-1"),
+			ContainElement("id-2"),
+		))
+	})
+
+	It("stops scraping targets that are removed", func() {
+		m.Update([]scraper.Target{
+			{SourceID: "id-1", URL: "http://target-1/metrics"},
+			{SourceID: "id-2", URL: "http://target-2/metrics"},
+		})
+		Expect(m.ActiveTargets()).To(Equal(2))
+
+		m.Update([]scraper.Target{
+			{SourceID: "id-2", URL: "http://target-2/metrics"},
+		})
+		Expect(m.ActiveTargets()).To(Equal(1))
+
+		doer.reset()
+		Consistently(doer.urls).ShouldNot(ContainElement("http://target-1/metrics"))
+		Expect(doer.urls()).To(ContainElement("http://target-2/metrics"))
+	})
+
+	It("emits the number of active targets", func() {
+		m.Update([]scraper.Target{
+			{SourceID: "id-1", URL: "http://target-1/metrics"},
+		})
+
+		Expect(metricClient.gaugeValue("active_scrape_targets")).To(Equal(1.0))
+	})
+
+	It("emits the number of active targets on every interval", func() {
+		Eventually(metricClient.gaugeCount("active_scrape_targets")).Should(BeNumerically(">=", 2))
+		Expect(metricClient.gaugeValue("active_scrape_targets")).To(Equal(0.0))
+
+		m.Update([]scraper.Target{
+			{SourceID: "id-1", URL: "http://target-1/metrics"},
+		})
+		n := metricClient.gaugeCount("active_scrape_targets")()
+
+		Eventually(metricClient.gaugeCount("active_scrape_targets")).Should(BeNumerically(">", n+1))
+		Expect(metricClient.gaugeValue("active_scrape_targets")).To(Equal(1.0))
+	})
+
+	It("stops scraping and reporting when stopped", func() {
+		m.Update([]scraper.Target{
+			{SourceID: "id-1", URL: "http://target-1/metrics"},
+		})
+		Eventually(doer.urls).Should(ContainElement("http://target-1/metrics"))
+
+		m.Stop()
+		Expect(m.ActiveTargets()).To(Equal(0))
+
+		doer.reset()
+		n := metricClient.gaugeCount("active_scrape_targets")()
+		Consistently(doer.urls).Should(BeEmpty())
+		Expect(metricClient.gaugeCount("active_scrape_targets")()).To(Equal(n))
+	})
+
+	It("emits a scrape error counter per target", func() {
+		doer.err = errors.New("some-error")
+		m.Update([]scraper.Target{
+			{SourceID: "id-1", URL: "http://target-1/metrics"},
+		})
+
+		Eventually(metricClient.counterTags("scrape_errors")).Should(ContainElement(
+			map[string]string{
+				"target":           "http://target-1/metrics",
+				"target_source_id": "id-1",
+			},
+		))
+	})
+})
+
+type spyURLDoer struct {
+	mu    sync.Mutex
+	_urls []string
+	err   error
+}
+
+func newSpyURLDoer() *spyURLDoer {
+	return &spyURLDoer{}
+}
+
+func (s *spyURLDoer) Do(r *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s._urls = append(s._urls, r.URL.String())
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(strings.NewReader(promOutput)),
+	}, nil
+}
+
+func (s *spyURLDoer) urls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s._urls...)
+}
+
+func (s *spyURLDoer) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s._urls = nil
+}
+
+type spyManagerMetricClient struct {
+	mu        sync.Mutex
+	envelopes []*loggregator_v2.Envelope
+}
+
+func newSpyManagerMetricClient() *spyManagerMetricClient {
+	return &spyManagerMetricClient{}
+}
+
+func (s *spyManagerMetricClient) EmitGauge(opts ...loggregator.EmitGaugeOption) {
+	e := &loggregator_v2.Envelope{
+		Message: &loggregator_v2.Envelope_Gauge{
+			Gauge: &loggregator_v2.Gauge{
+				Metrics: make(map[string]*loggregator_v2.GaugeValue),
+			},
+		},
+		Tags: map[string]string{},
+	}
+
+	for _, o := range opts {
+		o(e)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.envelopes = append(s.envelopes, e)
+}
+
+func (s *spyManagerMetricClient) EmitCounter(name string, opts ...loggregator.EmitCounterOption) {
+	e := &loggregator_v2.Envelope{
+		Message: &loggregator_v2.Envelope_Counter{
+			Counter: &loggregator_v2.Counter{Name: name},
+		},
+		Tags: map[string]string{},
+	}
+
+	for _, o := range opts {
+		o(e)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.envelopes = append(s.envelopes, e)
+}
+
+func (s *spyManagerMetricClient) sourceIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for _, e := range s.envelopes {
+		ids = append(ids, e.GetSourceId())
+	}
+	return ids
+}
+
+func (s *spyManagerMetricClient) gaugeValue(name string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.envelopes) - 1; i >= 0; i-- {
+		if v, ok := s.envelopes[i].GetGauge().GetMetrics()[name]; ok {
+			return v.GetValue()
+		}
+	}
+	return -1
+}
+
+func (s *spyManagerMetricClient) gaugeCount(name string) func() int {
+	return func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var n int
+		for _, e := range s.envelopes {
+			if _, ok := e.GetGauge().GetMetrics()[name]; ok {
+				n++
+			}
+		}
+		return n
+	}
+}
+
+func (s *spyManagerMetricClient) counterTags(name string) func() []map[string]string {
+	return func() []map[string]string {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var tags []map[string]string
+		for _, e := range s.envelopes {
+			if e.GetCounter().GetName() == name {
+				tags = append(tags, e.GetTags())
+			}
+		}
+		return tags
+	}
+}
@@ -0,0 +1,296 @@
+// Package spiffe obtains TLS credentials from a SPIFFE Workload API, such
+// as the one served by a SPIRE agent, rather than from files.
+package spiffe
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	"google.golang.org/grpc"
+)
+
+var logger = logging.New("spiffe")
+
+// Source streams the agent's X.509 SVID from the Workload API and keeps
+// the latest. TLS configs created by a Source always present the latest
+// SVID and verify peers against the latest trust bundle, so rotation needs
+// no restart.
+type Source struct {
+	socketPath string
+	backoff    time.Duration
+
+	mu        sync.RWMutex
+	id        string
+	cert      *tls.Certificate
+	bundle    *x509.CertPool
+	ready     chan struct{}
+	readyOnce sync.Once
+	cancel    context.CancelFunc
+	stopped   bool
+}
+
+// SourceOption configures a Source.
+type SourceOption func(*Source)
+
+// WithBackoff sets the delay before reconnecting to the Workload API after
+// the stream fails. The default is 1 second.
+func WithBackoff(d time.Duration) SourceOption {
+	return func(s *Source) {
+		s.backoff = d
+	}
+}
+
+// NewSource returns a Source for the Workload API listening on the given
+// Unix socket. Start must be called for SVIDs to be fetched.
+func NewSource(socketPath string, opts ...SourceOption) *Source {
+	s := &Source{
+		socketPath: socketPath,
+		backoff:    time.Second,
+		ready:      make(chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// Start streams SVIDs until Stop is called, reconnecting whenever the
+// stream fails.
+func (s *Source) Start() {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.mu.Lock()
+		if s.stopped {
+			s.mu.Unlock()
+			cancel()
+			return
+		}
+		s.cancel = cancel
+		s.mu.Unlock()
+
+		err := s.stream(ctx)
+		cancel()
+
+		s.mu.RLock()
+		stopped := s.stopped
+		s.mu.RUnlock()
+		if stopped {
+			return
+		}
+
+		logger.Warnf("workload API stream failed, reconnecting in %s: %s", s.backoff, err)
+		time.Sleep(s.backoff)
+	}
+}
+
+// Stop causes Start to return.
+func (s *Source) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// WaitUntilReady blocks until the first SVID has been received or the
+// timeout elapses.
+func (s *Source) WaitUntilReady(timeout time.Duration) error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no SVID received from %s within %s", s.socketPath, timeout)
+	}
+}
+
+// SPIFFEID returns the SPIFFE ID of the latest SVID.
+func (s *Source) SPIFFEID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.id
+}
+
+// ServerTLSConfig returns a config for servers that present the latest SVID
+// and require clients to present a certificate issued by the latest trust
+// bundle.
+func (s *Source) ServerTLSConfig(opts ...plumbing.ConfigOption) *tls.Config {
+	base := plumbing.NewTLSConfig()
+	base.ClientAuth = tls.RequireAndVerifyClientCert
+	for _, o := range opts {
+		o(base)
+	}
+
+	c := base.Clone()
+	c.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, bundle, err := s.current()
+		if err != nil {
+			return nil, err
+		}
+
+		cc := base.Clone()
+		cc.Certificates = []tls.Certificate{*cert}
+		cc.ClientCAs = bundle
+
+		return cc, nil
+	}
+
+	return c
+}
+
+// ClientTLSConfig returns a config for clients that present the latest
+// SVID and verify that servers present a certificate issued by the latest
+// trust bundle. As is usual with SPIFFE, server certificates are not
+// verified against a hostname.
+func (s *Source) ClientTLSConfig() *tls.Config {
+	c := plumbing.NewTLSConfig()
+
+	// The chain is verified by VerifyPeerCertificate so that the latest
+	// bundle is used.
+	c.InsecureSkipVerify = true
+	c.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+		_, bundle, err := s.current()
+		if err != nil {
+			return err
+		}
+
+		return verifyChain(raw, bundle)
+	}
+	c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		cert, _, err := s.current()
+		return cert, err
+	}
+
+	return c
+}
+
+func (s *Source) current() (*tls.Certificate, *x509.CertPool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.cert == nil {
+		return nil, nil, errors.New("no SVID has been received from the workload API")
+	}
+
+	return s.cert, s.bundle, nil
+}
+
+func (s *Source) stream(ctx context.Context) error {
+	conn, err := grpc.DialContext(ctx, s.socketPath,
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", addr)
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := fetchX509SVID(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp := new(X509SVIDResponse)
+		if err := stream.RecvMsg(resp); err != nil {
+			return err
+		}
+
+		if err := s.update(resp); err != nil {
+			logger.Warnf("ignoring invalid SVID: %s", err)
+		}
+	}
+}
+
+// update replaces the SVID with the first, default SVID in the response.
+func (s *Source) update(resp *X509SVIDResponse) error {
+	if len(resp.Svids) == 0 {
+		return errors.New("response has no SVIDs")
+	}
+	svid := resp.Svids[0]
+
+	certs, err := x509.ParseCertificates(svid.X509Svid)
+	if err != nil {
+		return fmt.Errorf("failed to parse certificates: %s", err)
+	}
+	if len(certs) == 0 {
+		return errors.New("SVID has no certificates")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(svid.X509SvidKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %s", err)
+	}
+
+	roots, err := x509.ParseCertificates(svid.Bundle)
+	if err != nil {
+		return fmt.Errorf("failed to parse bundle: %s", err)
+	}
+	bundle := x509.NewCertPool()
+	for _, r := range roots {
+		bundle.AddCert(r)
+	}
+
+	cert := &tls.Certificate{
+		PrivateKey: key.(crypto.Signer),
+		Leaf:       certs[0],
+	}
+	for _, c := range certs {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+
+	s.mu.Lock()
+	s.id = svid.SpiffeId
+	s.cert = cert
+	s.bundle = bundle
+	s.mu.Unlock()
+
+	s.readyOnce.Do(func() { close(s.ready) })
+	logger.Printf("received SVID for %s expiring at %s", svid.SpiffeId, certs[0].NotAfter.Format(time.RFC3339))
+
+	return nil
+}
+
+func verifyChain(raw [][]byte, bundle *x509.CertPool) error {
+	if len(raw) == 0 {
+		return errors.New("peer presented no certificates")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(raw))
+	for _, r := range raw {
+		c, err := x509.ParseCertificate(r)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, c)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         bundle,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+
+	return err
+}
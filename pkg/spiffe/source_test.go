@@ -0,0 +1,295 @@
+package spiffe_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/spiffe"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Source", func() {
+	var (
+		dir    string
+		socket string
+		ca     *testCA
+		server *fakeWorkloadAPI
+		source *spiffe.Source
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "spiffe")
+		Expect(err).ToNot(HaveOccurred())
+		socket = filepath.Join(dir, "agent.sock")
+
+		ca = newTestCA()
+		server = newFakeWorkloadAPI(socket)
+		source = spiffe.NewSource(socket, spiffe.WithBackoff(10*time.Millisecond))
+	})
+
+	AfterEach(func() {
+		source.Stop()
+		server.stop()
+		os.RemoveAll(dir)
+	})
+
+	It("fetches the SVID from the workload API", func() {
+		server.send(ca.svid("spiffe://example.org/agent"))
+		go source.Start()
+
+		Expect(source.WaitUntilReady(5 * time.Second)).To(Succeed())
+		Expect(source.SPIFFEID()).To(Equal("spiffe://example.org/agent"))
+		Eventually(server.headers).Should(ContainElement("true"))
+	})
+
+	It("times out waiting for an SVID", func() {
+		go source.Start()
+
+		Expect(source.WaitUntilReady(50 * time.Millisecond)).ToNot(Succeed())
+	})
+
+	It("establishes mutual TLS between its client and server configs", func() {
+		server.send(ca.svid("spiffe://example.org/agent"))
+		go source.Start()
+		Expect(source.WaitUntilReady(5 * time.Second)).To(Succeed())
+
+		peer := handshake(source.ServerTLSConfig(), source.ClientTLSConfig())
+		Expect(peer).ToNot(BeNil())
+		Expect(peer.URIs[0].String()).To(Equal("spiffe://example.org/agent"))
+	})
+
+	It("presents rotated SVIDs", func() {
+		server.send(ca.svid("spiffe://example.org/agent"))
+		go source.Start()
+		Expect(source.WaitUntilReady(5 * time.Second)).To(Succeed())
+
+		server.send(ca.svid("spiffe://example.org/rotated"))
+		Eventually(source.SPIFFEID).Should(Equal("spiffe://example.org/rotated"))
+
+		peer := handshake(source.ServerTLSConfig(), source.ClientTLSConfig())
+		Expect(peer).ToNot(BeNil())
+		Expect(peer.URIs[0].String()).To(Equal("spiffe://example.org/rotated"))
+	})
+
+	It("rejects servers outside of the trust bundle", func() {
+		server.send(ca.svid("spiffe://example.org/agent"))
+		go source.Start()
+		Expect(source.WaitUntilReady(5 * time.Second)).To(Succeed())
+
+		serverConf := &tls.Config{
+			Certificates: []tls.Certificate{newTestCA().leaf("spiffe://other.org/doppler")},
+		}
+
+		Expect(handshake(serverConf, source.ClientTLSConfig())).To(BeNil())
+	})
+
+	It("ignores invalid SVIDs", func() {
+		server.send(ca.svid("spiffe://example.org/agent"))
+		go source.Start()
+		Expect(source.WaitUntilReady(5 * time.Second)).To(Succeed())
+
+		server.send(&spiffe.X509SVIDResponse{
+			Svids: []*spiffe.X509SVID{{SpiffeId: "spiffe://example.org/bad", X509Svid: []byte("bad")}},
+		})
+		Consistently(source.SPIFFEID, 100*time.Millisecond).Should(Equal("spiffe://example.org/agent"))
+	})
+
+	It("reconnects when the stream fails", func() {
+		server.send(ca.svid("spiffe://example.org/agent"))
+		go source.Start()
+		Expect(source.WaitUntilReady(5 * time.Second)).To(Succeed())
+
+		server.stop()
+		server = newFakeWorkloadAPI(socket)
+		server.send(ca.svid("spiffe://example.org/reconnected"))
+
+		Eventually(source.SPIFFEID, 5).Should(Equal("spiffe://example.org/reconnected"))
+	})
+})
+
+// handshake completes a TLS handshake between the configs and returns the
+// leaf certificate presented by the client, or nil if the handshake fails.
+func handshake(serverConf, clientConf *tls.Config) *x509.Certificate {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", serverConf)
+	Expect(err).ToNot(HaveOccurred())
+	defer lis.Close()
+
+	peers := make(chan *x509.Certificate, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			peers <- nil
+			return
+		}
+		defer conn.Close()
+
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			peers <- nil
+			return
+		}
+
+		certs := tlsConn.ConnectionState().PeerCertificates
+		if len(certs) == 0 {
+			peers <- nil
+			return
+		}
+		peers <- certs[0]
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), clientConf)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	// The server verifies the client's certificate after the client's
+	// handshake completes, so wait for it.
+	var peer *x509.Certificate
+	Eventually(peers).Should(Receive(&peer))
+
+	return peer
+}
+
+type fakeWorkloadAPI struct {
+	server    *grpc.Server
+	responses chan *spiffe.X509SVIDResponse
+	headerCh  chan string
+	seen      []string
+}
+
+func newFakeWorkloadAPI(socket string) *fakeWorkloadAPI {
+	os.Remove(socket)
+	lis, err := net.Listen("unix", socket)
+	Expect(err).ToNot(HaveOccurred())
+
+	f := &fakeWorkloadAPI{
+		server:    grpc.NewServer(),
+		responses: make(chan *spiffe.X509SVIDResponse, 10),
+		headerCh:  make(chan string, 10),
+	}
+	spiffe.RegisterWorkloadServer(f.server, f)
+	go f.server.Serve(lis)
+
+	return f
+}
+
+func (f *fakeWorkloadAPI) FetchX509SVID(_ *spiffe.X509SVIDRequest, s spiffe.X509SVIDStream) error {
+	md, _ := metadata.FromIncomingContext(s.Context())
+	for _, v := range md.Get("workload.spiffe.io") {
+		f.headerCh <- v
+	}
+
+	for {
+		select {
+		case r := <-f.responses:
+			if err := s.Send(r); err != nil {
+				return err
+			}
+		case <-s.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (f *fakeWorkloadAPI) send(r *spiffe.X509SVIDResponse) {
+	f.responses <- r
+}
+
+func (f *fakeWorkloadAPI) headers() []string {
+	for {
+		select {
+		case h := <-f.headerCh:
+			f.seen = append(f.seen, h)
+		default:
+			return f.seen
+		}
+	}
+}
+
+func (f *fakeWorkloadAPI) stop() {
+	f.server.Stop()
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA() *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) svid(id string) *spiffe.X509SVIDResponse {
+	cert := ca.leaf(id)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	Expect(err).ToNot(HaveOccurred())
+
+	return &spiffe.X509SVIDResponse{
+		Svids: []*spiffe.X509SVID{{
+			SpiffeId:    id,
+			X509Svid:    cert.Certificate[0],
+			X509SvidKey: keyDER,
+			Bundle:      ca.cert.Raw,
+		}},
+	}
+}
+
+func (ca *testCA) leaf(id string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	u, err := url.Parse(id)
+	Expect(err).ToNot(HaveOccurred())
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{u},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	Expect(err).ToNot(HaveOccurred())
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
@@ -0,0 +1,13 @@
+package spiffe_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSpiffe(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Spiffe Suite")
+}
@@ -0,0 +1,116 @@
+package spiffe
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// The messages and service below mirror workload.proto of the SPIFFE
+// Workload API. Only the fields used by the agent are declared; others are
+// skipped when decoding.
+
+// headerKey is the metadata that the Workload API requires on every call
+// to prove that the caller is not being proxied.
+const headerKey = "workload.spiffe.io"
+
+// X509SVIDRequest requests the X.509 SVIDs of the calling workload.
+type X509SVIDRequest struct{}
+
+func (m *X509SVIDRequest) Reset()         { *m = X509SVIDRequest{} }
+func (m *X509SVIDRequest) String() string { return proto.CompactTextString(m) }
+func (*X509SVIDRequest) ProtoMessage()    {}
+
+// X509SVIDResponse holds the X.509 SVIDs of the calling workload. A new
+// response is streamed whenever the SVIDs are rotated.
+type X509SVIDResponse struct {
+	Svids []*X509SVID `protobuf:"bytes,1,rep,name=svids,proto3" json:"svids,omitempty"`
+}
+
+func (m *X509SVIDResponse) Reset()         { *m = X509SVIDResponse{} }
+func (m *X509SVIDResponse) String() string { return proto.CompactTextString(m) }
+func (*X509SVIDResponse) ProtoMessage()    {}
+
+// X509SVID is an X.509 SVID along with its private key and the bundle of
+// its trust domain. Certificates are ASN.1 DER encoded and concatenated.
+// The key is PKCS#8 DER encoded.
+type X509SVID struct {
+	SpiffeId    string `protobuf:"bytes,1,opt,name=spiffe_id,json=spiffeId,proto3" json:"spiffe_id,omitempty"`
+	X509Svid    []byte `protobuf:"bytes,2,opt,name=x509_svid,json=x509Svid,proto3" json:"x509_svid,omitempty"`
+	X509SvidKey []byte `protobuf:"bytes,3,opt,name=x509_svid_key,json=x509SvidKey,proto3" json:"x509_svid_key,omitempty"`
+	Bundle      []byte `protobuf:"bytes,4,opt,name=bundle,proto3" json:"bundle,omitempty"`
+}
+
+func (m *X509SVID) Reset()         { *m = X509SVID{} }
+func (m *X509SVID) String() string { return proto.CompactTextString(m) }
+func (*X509SVID) ProtoMessage()    {}
+
+// WorkloadServer is the server API of the Workload API. It is implemented
+// by fakes in tests.
+type WorkloadServer interface {
+	FetchX509SVID(*X509SVIDRequest, X509SVIDStream) error
+}
+
+// X509SVIDStream streams X509SVIDResponses to a workload.
+type X509SVIDStream interface {
+	Send(*X509SVIDResponse) error
+	grpc.ServerStream
+}
+
+// RegisterWorkloadServer registers the Workload API with the gRPC server.
+func RegisterWorkloadServer(s *grpc.Server, srv WorkloadServer) {
+	s.RegisterService(&workloadServiceDesc, srv)
+}
+
+var workloadServiceDesc = grpc.ServiceDesc{
+	ServiceName: "SpiffeWorkloadAPI",
+	HandlerType: (*WorkloadServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "FetchX509SVID",
+			Handler:       fetchX509SVIDHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "workload.proto",
+}
+
+func fetchX509SVIDHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(X509SVIDRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	return srv.(WorkloadServer).FetchX509SVID(m, &x509SVIDStream{stream})
+}
+
+type x509SVIDStream struct {
+	grpc.ServerStream
+}
+
+func (s *x509SVIDStream) Send(m *X509SVIDResponse) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+// fetchX509SVID opens a stream of the workload's X.509 SVIDs.
+func fetchX509SVID(ctx context.Context, conn *grpc.ClientConn) (grpc.ClientStream, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, headerKey, "true")
+
+	stream, err := conn.NewStream(ctx, &workloadServiceDesc.Streams[0], "/SpiffeWorkloadAPI/FetchX509SVID")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stream.SendMsg(&X509SVIDRequest{}); err != nil {
+		return nil, err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
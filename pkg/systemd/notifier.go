@@ -0,0 +1,132 @@
+// Package systemd reports the agent's state to systemd with the sd_notify
+// protocol so that units with Type=notify and WatchdogSec supervise the
+// agent correctly.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+)
+
+var logger = logging.New("systemd")
+
+// readyPollInterval is how often readiness is checked before the agent is
+// ready when there is no watchdog.
+const readyPollInterval = time.Second
+
+// Notifier sends state changes to the socket systemd passes in
+// NOTIFY_SOCKET. It should be constructed with NewNotifierFromEnv.
+type Notifier struct {
+	socket   string
+	watchdog time.Duration
+
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+// NewNotifierFromEnv returns a Notifier for the socket in NOTIFY_SOCKET
+// with the watchdog interval in WATCHDOG_USEC. It returns nil when the
+// agent was not started by systemd as a notify service.
+func NewNotifierFromEnv() *Notifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	return &Notifier{
+		socket:   socket,
+		watchdog: watchdogInterval(),
+	}
+}
+
+// watchdogInterval returns the watchdog interval systemd expects pings
+// within, or zero if the watchdog is not enabled for this process.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Notify sends the state, such as "READY=1", to systemd.
+func (n *Notifier) Notify(state string) error {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{
+		Name: n.socket,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Supervise tells systemd the agent is ready the first time ready returns
+// true and, when the watchdog is enabled, pings the watchdog at half its
+// interval for as long as it runs. The watchdog tracks that the agent is
+// alive rather than ready, so that losing a dependency such as Doppler does
+// not get the agent restarted. It blocks until Stop is called.
+func (n *Notifier) Supervise(ready func() bool) {
+	done := make(chan struct{})
+	n.mu.Lock()
+	n.done = done
+	n.mu.Unlock()
+
+	interval := readyPollInterval
+	if n.watchdog > 0 {
+		interval = n.watchdog / 2
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var notified bool
+	for {
+		if !notified && ready() {
+			n.notify("READY=1")
+			notified = true
+			logger.Printf("notified systemd that the agent is ready")
+		}
+
+		if n.watchdog > 0 {
+			n.notify("WATCHDOG=1")
+		}
+
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Stop causes Supervise to return and tells systemd the agent is stopping.
+func (n *Notifier) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.done != nil {
+		close(n.done)
+		n.done = nil
+	}
+	n.notify("STOPPING=1")
+}
+
+func (n *Notifier) notify(state string) {
+	if err := n.Notify(state); err != nil {
+		logger.Warnf("failed to notify systemd of %s: %s", state, err)
+	}
+}
@@ -0,0 +1,108 @@
+package systemd_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/systemd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Notifier", func() {
+	var (
+		dir  string
+		conn *net.UnixConn
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "systemd")
+		Expect(err).ToNot(HaveOccurred())
+
+		socket := filepath.Join(dir, "notify.sock")
+		conn, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+		Expect(err).ToNot(HaveOccurred())
+
+		os.Setenv("NOTIFY_SOCKET", socket)
+	})
+
+	AfterEach(func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		os.Unsetenv("WATCHDOG_USEC")
+		conn.Close()
+		os.RemoveAll(dir)
+	})
+
+	messages := func() <-chan string {
+		c := make(chan string, 100)
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				c <- string(buf[:n])
+			}
+		}()
+
+		return c
+	}
+
+	It("is nil when not started by systemd", func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+
+		Expect(systemd.NewNotifierFromEnv()).To(BeNil())
+	})
+
+	It("notifies systemd once the agent is ready", func() {
+		var ready int32
+		n := systemd.NewNotifierFromEnv()
+		go n.Supervise(func() bool {
+			return atomic.LoadInt32(&ready) == 1
+		})
+		defer n.Stop()
+
+		msgs := messages()
+		Consistently(msgs).ShouldNot(Receive())
+
+		atomic.StoreInt32(&ready, 1)
+		Eventually(msgs, 3).Should(Receive(Equal("READY=1")))
+	})
+
+	It("pings the watchdog while the agent is not ready", func() {
+		os.Setenv("WATCHDOG_USEC", "20000")
+
+		n := systemd.NewNotifierFromEnv()
+		go n.Supervise(func() bool { return false })
+		defer n.Stop()
+
+		msgs := messages()
+		Eventually(msgs).Should(Receive(Equal("WATCHDOG=1")))
+		Consistently(msgs).ShouldNot(Receive(Equal("READY=1")))
+	})
+
+	It("pings the watchdog once the agent is ready", func() {
+		os.Setenv("WATCHDOG_USEC", "20000")
+
+		n := systemd.NewNotifierFromEnv()
+		go n.Supervise(func() bool { return true })
+		defer n.Stop()
+
+		msgs := messages()
+		Eventually(msgs).Should(Receive(Equal("READY=1")))
+		Eventually(msgs).Should(Receive(Equal("WATCHDOG=1")))
+	})
+
+	It("notifies systemd when stopping", func() {
+		n := systemd.NewNotifierFromEnv()
+		n.Stop()
+
+		Eventually(messages()).Should(Receive(Equal("STOPPING=1")))
+	})
+})
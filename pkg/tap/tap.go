@@ -0,0 +1,191 @@
+// Package tap streams a live copy of the envelopes flowing through the
+// agent to local subscribers for debugging emitters.
+package tap
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/admin"
+	egress "code.cloudfoundry.org/loggregator-agent/pkg/egress/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/websocket"
+)
+
+var logger = logging.New("tap")
+
+// subscriptionBuffer is the number of envelopes held for a subscriber that
+// is not keeping up. Further envelopes are dropped for that subscriber.
+const subscriptionBuffer = 1000
+
+// Tap is a Writer that passes every batch to the next Writer and offers a
+// copy of each envelope to its subscribers. Offering never blocks: a
+// subscriber that falls behind misses envelopes.
+type Tap struct {
+	next egress.Writer
+
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// New returns a Tap with no subscribers.
+func New(next egress.Writer) *Tap {
+	return &Tap{
+		next: next,
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Write offers the batch to every subscriber and writes it to the next
+// Writer.
+func (t *Tap) Write(batch []*loggregator_v2.Envelope) error {
+	t.mu.RLock()
+	for s := range t.subs {
+		s.offer(batch)
+	}
+	t.mu.RUnlock()
+
+	return t.next.Write(batch)
+}
+
+// BorrowsBatches reports whether the next Writer borrows batches. Only the
+// envelopes are offered to subscribers.
+func (t *Tap) BorrowsBatches() bool {
+	return egress.BorrowsBatches(t.next)
+}
+
+// Subscribe returns a Subscription to the envelopes with any of the given
+// source IDs, or to every envelope if none are given. The rate, between 0
+// and 1, is the share of matching envelopes delivered.
+func (t *Tap) Subscribe(sourceIDs []string, rate float64) *Subscription {
+	s := &Subscription{
+		C:         make(chan *loggregator_v2.Envelope, subscriptionBuffer),
+		rate:      rate,
+		sourceIDs: make(map[string]bool),
+	}
+	for _, id := range sourceIDs {
+		s.sourceIDs[id] = true
+	}
+
+	t.mu.Lock()
+	t.subs[s] = struct{}{}
+	t.mu.Unlock()
+
+	return s
+}
+
+// Unsubscribe stops delivering envelopes to the subscription.
+func (t *Tap) Unsubscribe(s *Subscription) {
+	t.mu.Lock()
+	delete(t.subs, s)
+	t.mu.Unlock()
+}
+
+// Subscribers returns the number of subscriptions.
+func (t *Tap) Subscribers() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return len(t.subs)
+}
+
+// Handler returns a WebSocket handler that streams envelopes to each
+// connection as JSON text messages. The source_id query parameter, which
+// may be repeated, limits the envelopes to those source IDs and the sample
+// parameter sets the share of envelopes sent, between 0 and 1.
+func (t *Tap) Handler() http.Handler {
+	ws := websocket.Server{
+		// The tap only listens on localhost, so connections are accepted
+		// from clients that do not send an Origin header. Browsers always
+		// send one, and only pages served from localhost may connect so
+		// that other sites cannot read the envelopes.
+		Handshake: func(_ *websocket.Config, r *http.Request) error {
+			return admin.CheckOrigin(r)
+		},
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+
+			q := conn.Request().URL.Query()
+			rate := 1.0
+			if v := q.Get("sample"); v != "" {
+				r, err := strconv.ParseFloat(v, 64)
+				if err != nil || r <= 0 || r > 1 {
+					websocket.Message.Send(conn, "sample must be greater than 0 and at most 1")
+					return
+				}
+				rate = r
+			}
+
+			s := t.Subscribe(q["source_id"], rate)
+			defer t.Unsubscribe(s)
+			logger.Printf("tap subscriber connected from %s", conn.Request().RemoteAddr)
+
+			// Reads fail once the client goes away.
+			closed := make(chan struct{})
+			go func() {
+				var discard []byte
+				for websocket.Message.Receive(conn, &discard) == nil {
+				}
+				close(closed)
+			}()
+
+			m := jsonpb.Marshaler{}
+			for {
+				select {
+				case <-closed:
+					return
+				case e := <-s.C:
+					data, err := m.MarshalToString(e)
+					if err != nil {
+						continue
+					}
+					if err := websocket.Message.Send(conn, data); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+
+	return ws
+}
+
+// Subscription receives a sampled copy of the envelopes written to a Tap.
+type Subscription struct {
+	// C receives the envelopes.
+	C chan *loggregator_v2.Envelope
+
+	rate      float64
+	sourceIDs map[string]bool
+
+	mu     sync.Mutex
+	credit float64
+}
+
+func (s *Subscription) offer(batch []*loggregator_v2.Envelope) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range batch {
+		if len(s.sourceIDs) > 0 && !s.sourceIDs[e.GetSourceId()] {
+			continue
+		}
+
+		s.credit += s.rate
+		if s.credit < 1 {
+			continue
+		}
+		s.credit--
+
+		// The pipeline goes on to modify envelopes in place, so the
+		// subscriber is given a copy of the envelope as it was offered.
+		select {
+		case s.C <- proto.Clone(e).(*loggregator_v2.Envelope):
+		default:
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package tap_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/tap"
+	"github.com/golang/protobuf/jsonpb"
+	"golang.org/x/net/websocket"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tap", func() {
+	var (
+		next *spyWriter
+		t    *tap.Tap
+	)
+
+	BeforeEach(func() {
+		next = &spyWriter{}
+		t = tap.New(next)
+	})
+
+	It("writes batches to the next writer", func() {
+		next.err = errors.New("some-error")
+
+		Expect(t.Write(envelopes("a", 2))).To(MatchError("some-error"))
+		Expect(next.batches).To(HaveLen(1))
+	})
+
+	It("delivers envelopes with the subscribed source IDs", func() {
+		s := t.Subscribe([]string{"a", "b"}, 1)
+
+		Expect(t.Write(append(envelopes("a", 1), envelopes("c", 1)...))).To(Succeed())
+		Expect(t.Write(envelopes("b", 1))).To(Succeed())
+
+		Expect(sourceIDs(s.C, 2)).To(Equal([]string{"a", "b"}))
+		Expect(s.C).To(BeEmpty())
+	})
+
+	It("delivers every envelope without source IDs", func() {
+		s := t.Subscribe(nil, 1)
+
+		Expect(t.Write(append(envelopes("a", 1), envelopes("c", 1)...))).To(Succeed())
+
+		Expect(sourceIDs(s.C, 2)).To(Equal([]string{"a", "c"}))
+	})
+
+	It("delivers a copy that is not changed by the pipeline", func() {
+		s := t.Subscribe(nil, 1)
+		batch := envelopes("a", 1)
+
+		Expect(t.Write(batch)).To(Succeed())
+		batch[0].SourceId = "rewritten"
+
+		var e *loggregator_v2.Envelope
+		Expect(s.C).To(Receive(&e))
+		Expect(e.SourceId).To(Equal("a"))
+	})
+
+	It("samples envelopes", func() {
+		s := t.Subscribe(nil, 0.25)
+
+		Expect(t.Write(envelopes("a", 100))).To(Succeed())
+
+		Expect(s.C).To(HaveLen(25))
+	})
+
+	It("drops envelopes for a subscriber that is behind", func() {
+		s := t.Subscribe(nil, 1)
+
+		for i := 0; i < 20; i++ {
+			Expect(t.Write(envelopes("a", 100))).To(Succeed())
+		}
+
+		Expect(s.C).To(HaveLen(1000))
+		Expect(next.batches).To(HaveLen(20))
+	})
+
+	It("stops delivering after unsubscribing", func() {
+		s := t.Subscribe(nil, 1)
+		t.Unsubscribe(s)
+
+		Expect(t.Write(envelopes("a", 1))).To(Succeed())
+
+		Expect(s.C).To(BeEmpty())
+		Expect(t.Subscribers()).To(Equal(0))
+	})
+
+	Describe("Handler", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			server = httptest.NewServer(t.Handler())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		dial := func(query string) *websocket.Conn {
+			url := strings.Replace(server.URL, "http", "ws", 1) + "/?" + query
+			conn, err := websocket.Dial(url, "", server.URL)
+			Expect(err).ToNot(HaveOccurred())
+			return conn
+		}
+
+		It("streams envelopes as JSON", func() {
+			conn := dial("source_id=a")
+			defer conn.Close()
+			Eventually(t.Subscribers).Should(Equal(1))
+
+			Expect(t.Write(append(envelopes("b", 1), envelopes("a", 1)...))).To(Succeed())
+
+			var msg string
+			Expect(websocket.Message.Receive(conn, &msg)).To(Succeed())
+
+			var e loggregator_v2.Envelope
+			Expect(jsonpb.UnmarshalString(msg, &e)).To(Succeed())
+			Expect(e.SourceId).To(Equal("a"))
+		})
+
+		It("unsubscribes when the connection closes", func() {
+			conn := dial("")
+			Eventually(t.Subscribers).Should(Equal(1))
+
+			conn.Close()
+
+			Eventually(t.Subscribers).Should(Equal(0))
+		})
+
+		It("accepts connections from localhost origins", func() {
+			url := strings.Replace(server.URL, "http", "ws", 1)
+			conn, err := websocket.Dial(url, "", "http://localhost:8080")
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			Eventually(t.Subscribers).Should(Equal(1))
+		})
+
+		It("rejects connections from other origins", func() {
+			url := strings.Replace(server.URL, "http", "ws", 1)
+			_, err := websocket.Dial(url, "", "https://evil.example.com")
+			Expect(err).To(HaveOccurred())
+
+			Consistently(t.Subscribers).Should(Equal(0))
+		})
+
+		It("rejects an invalid sample rate", func() {
+			conn := dial("sample=2")
+			defer conn.Close()
+
+			var msg string
+			Expect(websocket.Message.Receive(conn, &msg)).To(Succeed())
+			Expect(msg).To(ContainSubstring("sample"))
+			Expect(t.Subscribers()).To(Equal(0))
+		})
+	})
+})
+
+func envelopes(sourceID string, n int) []*loggregator_v2.Envelope {
+	batch := make([]*loggregator_v2.Envelope, n)
+	for i := range batch {
+		batch[i] = &loggregator_v2.Envelope{SourceId: sourceID}
+	}
+
+	return batch
+}
+
+func sourceIDs(c chan *loggregator_v2.Envelope, n int) []string {
+	var ids []string
+	for i := 0; i < n; i++ {
+		ids = append(ids, (<-c).GetSourceId())
+	}
+
+	return ids
+}
+
+type spyWriter struct {
+	batches [][]*loggregator_v2.Envelope
+	err     error
+}
+
+func (w *spyWriter) Write(batch []*loggregator_v2.Envelope) error {
+	w.batches = append(w.batches, batch)
+	return w.err
+}
@@ -0,0 +1,278 @@
+// Package testhelper provides fakes for integration testing against the
+// agent without running the rest of Loggregator.
+package testhelper
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	plumbingv2 "code.cloudfoundry.org/loggregator-agent/pkg/plumbing/v2"
+	"google.golang.org/grpc"
+)
+
+// FakeDoppler is an in-process Doppler that records everything written to
+// its ingress APIs: the v1 DopplerIngestor, the v2 Ingress and the
+// deprecated v2 DopplerIngress. An API that is disabled responds with
+// codes.Unimplemented, as an older Doppler would.
+type FakeDoppler struct {
+	v1         bool
+	v2         bool
+	deprecated bool
+	serverOpts []grpc.ServerOption
+
+	server *grpc.Server
+	addr   string
+
+	mu         sync.Mutex
+	batches    []*loggregator_v2.EnvelopeBatch
+	v1Payloads [][]byte
+	err        error
+}
+
+// FakeDopplerOption configures a FakeDoppler.
+type FakeDopplerOption func(*FakeDoppler)
+
+// WithV1Ingress sets whether the v1 DopplerIngestor API is served. It is
+// served by default.
+func WithV1Ingress(enabled bool) FakeDopplerOption {
+	return func(d *FakeDoppler) {
+		d.v1 = enabled
+	}
+}
+
+// WithV2Ingress sets whether the v2 Ingress API is served. It is served by
+// default.
+func WithV2Ingress(enabled bool) FakeDopplerOption {
+	return func(d *FakeDoppler) {
+		d.v2 = enabled
+	}
+}
+
+// WithDeprecatedV2Ingress sets whether the deprecated v2 DopplerIngress API
+// is served. It is served by default.
+func WithDeprecatedV2Ingress(enabled bool) FakeDopplerOption {
+	return func(d *FakeDoppler) {
+		d.deprecated = enabled
+	}
+}
+
+// WithServerOptions sets options for the gRPC server, for example
+// credentials to serve TLS.
+func WithServerOptions(opts ...grpc.ServerOption) FakeDopplerOption {
+	return func(d *FakeDoppler) {
+		d.serverOpts = opts
+	}
+}
+
+// NewFakeDoppler returns a FakeDoppler. Start must be called for it to
+// listen.
+func NewFakeDoppler(opts ...FakeDopplerOption) *FakeDoppler {
+	d := &FakeDoppler{
+		v1:         true,
+		v2:         true,
+		deprecated: true,
+	}
+
+	for _, o := range opts {
+		o(d)
+	}
+
+	return d
+}
+
+// Start listens on a random localhost port and serves the enabled APIs.
+func (d *FakeDoppler) Start() error {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	d.server = grpc.NewServer(d.serverOpts...)
+	d.addr = lis.Addr().String()
+
+	if d.v1 {
+		plumbing.RegisterDopplerIngestorServer(d.server, &fakeV1Ingestor{d})
+	}
+	if d.v2 {
+		loggregator_v2.RegisterIngressServer(d.server, &fakeV2Ingress{d})
+	}
+	if d.deprecated {
+		plumbingv2.RegisterDopplerIngressServer(d.server, &fakeDeprecatedV2Ingress{d})
+	}
+
+	go d.server.Serve(lis)
+
+	return nil
+}
+
+// Stop closes every connection and stops listening.
+func (d *FakeDoppler) Stop() {
+	d.server.Stop()
+}
+
+// Addr returns the address the FakeDoppler is listening on.
+func (d *FakeDoppler) Addr() string {
+	return d.addr
+}
+
+// SetError causes every stream to fail with the given error when it next
+// receives a message, and every unary call to fail with it. A nil error
+// restores normal operation.
+func (d *FakeDoppler) SetError(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.err = err
+}
+
+// Batches returns every v2 batch received, in the order they were
+// received. Envelopes sent individually are recorded as batches of one.
+func (d *FakeDoppler) Batches() []*loggregator_v2.EnvelopeBatch {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	batches := make([]*loggregator_v2.EnvelopeBatch, len(d.batches))
+	copy(batches, d.batches)
+
+	return batches
+}
+
+// Envelopes returns every v2 envelope received, in the order they were
+// received.
+func (d *FakeDoppler) Envelopes() []*loggregator_v2.Envelope {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var envelopes []*loggregator_v2.Envelope
+	for _, b := range d.batches {
+		envelopes = append(envelopes, b.Batch...)
+	}
+
+	return envelopes
+}
+
+// V1Payloads returns the marshalled dropsonde envelope of every v1 message
+// received, in the order they were received.
+func (d *FakeDoppler) V1Payloads() [][]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	payloads := make([][]byte, len(d.v1Payloads))
+	copy(payloads, d.v1Payloads)
+
+	return payloads
+}
+
+// recordBatch records the batch unless an error has been set, in which
+// case the error is returned.
+func (d *FakeDoppler) recordBatch(b *loggregator_v2.EnvelopeBatch) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+	d.batches = append(d.batches, b)
+
+	return nil
+}
+
+func (d *FakeDoppler) recordV1(payload []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+	d.v1Payloads = append(d.v1Payloads, payload)
+
+	return nil
+}
+
+type fakeV1Ingestor struct {
+	d *FakeDoppler
+}
+
+func (s *fakeV1Ingestor) Pusher(srv plumbing.DopplerIngestor_PusherServer) error {
+	for {
+		e, err := srv.Recv()
+		if err != nil {
+			return nil
+		}
+
+		if err := s.d.recordV1(e.GetPayload()); err != nil {
+			return err
+		}
+	}
+}
+
+type fakeV2Ingress struct {
+	d *FakeDoppler
+}
+
+func (s *fakeV2Ingress) Sender(srv loggregator_v2.Ingress_SenderServer) error {
+	for {
+		e, err := srv.Recv()
+		if err != nil {
+			return nil
+		}
+
+		if err := s.d.recordBatch(&loggregator_v2.EnvelopeBatch{Batch: []*loggregator_v2.Envelope{e}}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *fakeV2Ingress) BatchSender(srv loggregator_v2.Ingress_BatchSenderServer) error {
+	for {
+		b, err := srv.Recv()
+		if err != nil {
+			return nil
+		}
+
+		if err := s.d.recordBatch(b); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *fakeV2Ingress) Send(_ context.Context, b *loggregator_v2.EnvelopeBatch) (*loggregator_v2.SendResponse, error) {
+	if err := s.d.recordBatch(b); err != nil {
+		return nil, err
+	}
+
+	return &loggregator_v2.SendResponse{}, nil
+}
+
+type fakeDeprecatedV2Ingress struct {
+	d *FakeDoppler
+}
+
+func (s *fakeDeprecatedV2Ingress) Sender(srv plumbingv2.DopplerIngress_SenderServer) error {
+	for {
+		e, err := srv.Recv()
+		if err != nil {
+			return nil
+		}
+
+		if err := s.d.recordBatch(&loggregator_v2.EnvelopeBatch{Batch: []*loggregator_v2.Envelope{e}}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *fakeDeprecatedV2Ingress) BatchSender(srv plumbingv2.DopplerIngress_BatchSenderServer) error {
+	for {
+		b, err := srv.Recv()
+		if err != nil {
+			return nil
+		}
+
+		if err := s.d.recordBatch(b); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package testhelper_test
+
+import (
+	"context"
+	"errors"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/plumbing"
+	plumbingv2 "code.cloudfoundry.org/loggregator-agent/pkg/plumbing/v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/testhelper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FakeDoppler", func() {
+	var (
+		doppler *testhelper.FakeDoppler
+		conn    *grpc.ClientConn
+	)
+
+	start := func(opts ...testhelper.FakeDopplerOption) {
+		doppler = testhelper.NewFakeDoppler(opts...)
+		Expect(doppler.Start()).To(Succeed())
+
+		var err error
+		conn, err = grpc.Dial(doppler.Addr(), grpc.WithInsecure())
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	AfterEach(func() {
+		conn.Close()
+		doppler.Stop()
+	})
+
+	It("records v2 batches", func() {
+		start()
+
+		sender, err := loggregator_v2.NewIngressClient(conn).BatchSender(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.Send(&loggregator_v2.EnvelopeBatch{Batch: []*loggregator_v2.Envelope{
+			{SourceId: "a"},
+			{SourceId: "b"},
+		}})).To(Succeed())
+
+		Eventually(doppler.Batches).Should(HaveLen(1))
+		Expect(doppler.Envelopes()).To(HaveLen(2))
+		Expect(doppler.Envelopes()[1].GetSourceId()).To(Equal("b"))
+	})
+
+	It("records envelopes sent to the deprecated v2 API", func() {
+		start()
+
+		sender, err := plumbingv2.NewDopplerIngressClient(conn).Sender(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.Send(&loggregator_v2.Envelope{SourceId: "a"})).To(Succeed())
+
+		Eventually(doppler.Envelopes).Should(HaveLen(1))
+	})
+
+	It("records v1 payloads", func() {
+		start()
+
+		pusher, err := plumbing.NewDopplerIngestorClient(conn).Pusher(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pusher.Send(&plumbing.EnvelopeData{Payload: []byte("some-envelope")})).To(Succeed())
+
+		Eventually(doppler.V1Payloads).Should(Equal([][]byte{[]byte("some-envelope")}))
+	})
+
+	It("responds with unimplemented for disabled APIs", func() {
+		start(testhelper.WithV2Ingress(false))
+
+		_, err := loggregator_v2.NewIngressClient(conn).Send(context.Background(), &loggregator_v2.EnvelopeBatch{})
+		Expect(status.Code(err)).To(Equal(codes.Unimplemented))
+	})
+
+	It("fails with the injected error", func() {
+		start()
+		doppler.SetError(status.Error(codes.Unavailable, "injected"))
+
+		_, err := loggregator_v2.NewIngressClient(conn).Send(context.Background(), &loggregator_v2.EnvelopeBatch{})
+		Expect(status.Code(err)).To(Equal(codes.Unavailable))
+
+		doppler.SetError(nil)
+		_, err = loggregator_v2.NewIngressClient(conn).Send(context.Background(), &loggregator_v2.EnvelopeBatch{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(doppler.Batches()).To(HaveLen(1))
+	})
+
+	It("closes streams with the injected error", func() {
+		start()
+		doppler.SetError(errors.New("injected"))
+
+		sender, err := loggregator_v2.NewIngressClient(conn).BatchSender(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.Send(&loggregator_v2.EnvelopeBatch{})).To(Succeed())
+
+		_, err = sender.CloseAndRecv()
+		Expect(err).To(MatchError(ContainSubstring("injected")))
+		Expect(doppler.Batches()).To(BeEmpty())
+	})
+})
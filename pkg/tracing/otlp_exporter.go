@@ -0,0 +1,218 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// otlpSpanKindInternal is the OTLP kind of spans within the agent.
+	otlpSpanKindInternal = 1
+
+	// otlpStatusError is the OTLP status code of a failed span.
+	otlpStatusError = 2
+)
+
+// OTLPExporter batches spans and posts them as OTLP/HTTP JSON to a
+// collector. Spans are dropped rather than blocking when the collector
+// cannot keep up.
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+	interval    time.Duration
+	spans       chan Span
+}
+
+// OTLPExporterOption configures an OTLPExporter.
+type OTLPExporterOption func(*OTLPExporter)
+
+// WithOTLPServiceName sets the service.name resource attribute of exported
+// spans. It defaults to "loggregator-agent".
+func WithOTLPServiceName(name string) OTLPExporterOption {
+	return func(e *OTLPExporter) {
+		e.serviceName = name
+	}
+}
+
+// WithOTLPHTTPClient sets the client spans are posted with.
+func WithOTLPHTTPClient(c *http.Client) OTLPExporterOption {
+	return func(e *OTLPExporter) {
+		e.client = c
+	}
+}
+
+// WithOTLPInterval sets how often spans are posted. It defaults to 5
+// seconds.
+func WithOTLPInterval(d time.Duration) OTLPExporterOption {
+	return func(e *OTLPExporter) {
+		e.interval = d
+	}
+}
+
+// NewOTLPExporter returns an OTLPExporter that posts to the traces endpoint
+// of a collector, e.g. http://localhost:4318/v1/traces.
+func NewOTLPExporter(url string, opts ...OTLPExporterOption) *OTLPExporter {
+	e := &OTLPExporter{
+		url:         url,
+		serviceName: "loggregator-agent",
+		client:      &http.Client{Timeout: 10 * time.Second},
+		interval:    5 * time.Second,
+		spans:       make(chan Span, 1000),
+	}
+	for _, o := range opts {
+		o(e)
+	}
+
+	return e
+}
+
+// Export queues the spans to be posted.
+func (e *OTLPExporter) Export(spans []Span) {
+	for _, s := range spans {
+		select {
+		case e.spans <- s:
+		default:
+			return
+		}
+	}
+}
+
+// Start posts queued spans every interval. It blocks forever.
+func (e *OTLPExporter) Start() {
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+
+	var batch []Span
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+		case <-t.C:
+			if len(batch) == 0 {
+				continue
+			}
+
+			if err := e.post(batch); err != nil {
+				logger.Warnf("failed to export %d spans: %s", len(batch), err)
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+func (e *OTLPExporter) post(spans []Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *OTLPExporter) request(spans []Span) otlpRequest {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.ParentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		for k, v := range s.Attributes {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+		}
+		if s.Err != nil {
+			span.Status = &otlpStatus{Code: otlpStatusError, Message: s.Err.Error()}
+		}
+
+		converted = append(converted, span)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{
+					{Key: "service.name", Value: otlpValue{StringValue: e.serviceName}},
+				},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "code.cloudfoundry.org/loggregator-agent/pkg/tracing"},
+				Spans: converted,
+			}},
+		}},
+	}
+}
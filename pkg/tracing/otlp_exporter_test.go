@@ -0,0 +1,90 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/loggregator-agent/pkg/tracing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OTLPExporter", func() {
+	var (
+		requests chan map[string]interface{}
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		requests = make(chan map[string]interface{}, 10)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.URL.Path).To(Equal("/v1/traces"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+
+			var req map[string]interface{}
+			Expect(json.Unmarshal(body, &req)).To(Succeed())
+			requests <- req
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("posts spans as OTLP JSON", func() {
+		e := tracing.NewOTLPExporter(server.URL+"/v1/traces",
+			tracing.WithOTLPInterval(10*time.Millisecond),
+			tracing.WithOTLPServiceName("some-agent"),
+		)
+		go e.Start()
+
+		start := time.Unix(0, 1000)
+		e.Export([]tracing.Span{{
+			TraceID:  [16]byte{1},
+			SpanID:   [8]byte{2},
+			ParentID: [8]byte{3},
+			Name:     "write",
+			Start:    start,
+			End:      start.Add(time.Microsecond),
+			Err:      errors.New("some-error"),
+		}})
+
+		var req map[string]interface{}
+		Eventually(requests).Should(Receive(&req))
+
+		rs := req["resourceSpans"].([]interface{})[0].(map[string]interface{})
+		attrs := rs["resource"].(map[string]interface{})["attributes"].([]interface{})
+		Expect(attrs[0]).To(Equal(map[string]interface{}{
+			"key":   "service.name",
+			"value": map[string]interface{}{"stringValue": "some-agent"},
+		}))
+
+		span := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+		Expect(span["traceId"]).To(Equal("01000000000000000000000000000000"))
+		Expect(span["spanId"]).To(Equal("0200000000000000"))
+		Expect(span["parentSpanId"]).To(Equal("0300000000000000"))
+		Expect(span["name"]).To(Equal("write"))
+		Expect(span["startTimeUnixNano"]).To(Equal("1000"))
+		Expect(span["endTimeUnixNano"]).To(Equal("2000"))
+		Expect(span["status"]).To(HaveKeyWithValue("message", "some-error"))
+	})
+
+	It("does not post when there are no spans", func() {
+		e := tracing.NewOTLPExporter(server.URL+"/v1/traces",
+			tracing.WithOTLPInterval(10*time.Millisecond),
+		)
+		go e.Start()
+
+		Consistently(requests, 100*time.Millisecond).ShouldNot(Receive())
+	})
+})
@@ -0,0 +1,206 @@
+// Package tracing records spans for a sample of the envelopes passing
+// through the agent so that time spent in each stage of the pipeline can be
+// seen in a tracing backend.
+package tracing
+
+import (
+	"crypto/rand"
+	mathrand "math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/logging"
+)
+
+var logger = logging.New("tracing")
+
+const (
+	// maxInFlight bounds the number of sampled envelopes being traced at
+	// once. Envelopes are not sampled while it is reached.
+	maxInFlight = 1000
+
+	// inFlightTTL is how long an envelope is traced for before it is
+	// assumed to have been dropped.
+	inFlightTTL = time.Minute
+)
+
+// Span is a timed stage of an envelope's trip through the agent.
+type Span struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	ParentID   [8]byte
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        error
+}
+
+// Exporter sends finished spans to a tracing backend. It must not block.
+type Exporter interface {
+	Export(spans []Span)
+}
+
+// Tracer follows a sample of envelopes through the agent. Each stage marks
+// the envelope as it hands the envelope on, and the spans between the marks
+// are exported when the envelope has been written. A nil Tracer traces
+// nothing.
+type Tracer struct {
+	inFlight int64
+
+	ratio    float64
+	exporter Exporter
+
+	mu     sync.Mutex
+	traces map[*loggregator_v2.Envelope]*trace
+}
+
+type trace struct {
+	id    [16]byte
+	marks []mark
+}
+
+type mark struct {
+	name string
+	at   time.Time
+}
+
+// NewTracer returns a Tracer that samples the given ratio of envelopes and
+// exports their spans to the exporter.
+func NewTracer(ratio float64, e Exporter) *Tracer {
+	return &Tracer{
+		ratio:    ratio,
+		exporter: e,
+		traces:   make(map[*loggregator_v2.Envelope]*trace),
+	}
+}
+
+// Sample decides whether to trace the envelope and, if so, starts its first
+// span with the given name.
+func (t *Tracer) Sample(e *loggregator_v2.Envelope, name string) {
+	if t == nil || t.ratio <= 0 || mathrand.Float64() >= t.ratio {
+		return
+	}
+
+	now := time.Now()
+	tr := &trace{marks: []mark{{name: name, at: now}}}
+	rand.Read(tr.id[:])
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.traces) >= maxInFlight {
+		t.expireLocked(now)
+		if len(t.traces) >= maxInFlight {
+			return
+		}
+	}
+
+	t.traces[e] = tr
+	atomic.StoreInt64(&t.inFlight, int64(len(t.traces)))
+}
+
+// Mark ends the envelope's current span and starts one with the given name
+// if the envelope is being traced.
+func (t *Tracer) Mark(e *loggregator_v2.Envelope, name string) {
+	if t == nil || atomic.LoadInt64(&t.inFlight) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if tr, ok := t.traces[e]; ok {
+		tr.marks = append(tr.marks, mark{name: name, at: time.Now()})
+	}
+}
+
+// MarkBatch marks each of the envelopes.
+func (t *Tracer) MarkBatch(batch []*loggregator_v2.Envelope, name string) {
+	if t == nil || atomic.LoadInt64(&t.inFlight) == 0 {
+		return
+	}
+
+	for _, e := range batch {
+		t.Mark(e, name)
+	}
+}
+
+// Finish ends the spans of the traced envelopes in the batch and exports
+// them. err is recorded on the last span of each.
+func (t *Tracer) Finish(batch []*loggregator_v2.Envelope, err error) {
+	if t == nil || atomic.LoadInt64(&t.inFlight) == 0 {
+		return
+	}
+
+	now := time.Now()
+	var spans []Span
+
+	t.mu.Lock()
+	for _, e := range batch {
+		tr, ok := t.traces[e]
+		if !ok {
+			continue
+		}
+		delete(t.traces, e)
+
+		spans = append(spans, tr.spans(e, now, err)...)
+	}
+	atomic.StoreInt64(&t.inFlight, int64(len(t.traces)))
+	t.mu.Unlock()
+
+	if len(spans) > 0 {
+		t.exporter.Export(spans)
+	}
+}
+
+// expireLocked stops tracing envelopes that have been in flight for longer
+// than inFlightTTL. The envelopes were dropped or written without being
+// finished.
+func (t *Tracer) expireLocked(now time.Time) {
+	for e, tr := range t.traces {
+		if now.Sub(tr.marks[0].at) > inFlightTTL {
+			delete(t.traces, e)
+		}
+	}
+}
+
+// spans returns a root span for the envelope's trip through the agent with a
+// child span for each stage.
+func (tr *trace) spans(e *loggregator_v2.Envelope, end time.Time, err error) []Span {
+	root := Span{
+		TraceID: tr.id,
+		Name:    "envelope",
+		Start:   tr.marks[0].at,
+		End:     end,
+		Attributes: map[string]string{
+			"source_id": e.GetSourceId(),
+		},
+		Err: err,
+	}
+	rand.Read(root.SpanID[:])
+
+	spans := []Span{root}
+	for i, m := range tr.marks {
+		s := Span{
+			TraceID:  tr.id,
+			ParentID: root.SpanID,
+			Name:     m.name,
+			Start:    m.at,
+			End:      end,
+		}
+		rand.Read(s.SpanID[:])
+
+		if i+1 < len(tr.marks) {
+			s.End = tr.marks[i+1].at
+		} else {
+			s.Err = err
+		}
+
+		spans = append(spans, s)
+	}
+
+	return spans
+}
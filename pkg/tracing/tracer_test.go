@@ -0,0 +1,109 @@
+package tracing_test
+
+import (
+	"errors"
+	"sync"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/loggregator-agent/pkg/tracing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracer", func() {
+	var (
+		exporter *spyExporter
+		e        *loggregator_v2.Envelope
+	)
+
+	BeforeEach(func() {
+		exporter = &spyExporter{}
+		e = &loggregator_v2.Envelope{SourceId: "some-source"}
+	})
+
+	It("exports a span for each stage of a sampled envelope", func() {
+		t := tracing.NewTracer(1, exporter)
+
+		t.Sample(e, "receive")
+		t.Mark(e, "buffer")
+		t.MarkBatch([]*loggregator_v2.Envelope{e}, "write")
+		t.Finish([]*loggregator_v2.Envelope{e}, nil)
+
+		spans := exporter.exported()
+		Expect(spans).To(HaveLen(4))
+
+		root := spans[0]
+		Expect(root.Name).To(Equal("envelope"))
+		Expect(root.Attributes).To(HaveKeyWithValue("source_id", "some-source"))
+
+		var names []string
+		for _, s := range spans[1:] {
+			names = append(names, s.Name)
+			Expect(s.TraceID).To(Equal(root.TraceID))
+			Expect(s.ParentID).To(Equal(root.SpanID))
+			Expect(s.End).ToNot(BeTemporally("<", s.Start))
+		}
+		Expect(names).To(Equal([]string{"receive", "buffer", "write"}))
+		Expect(spans[1].End).To(Equal(spans[2].Start))
+	})
+
+	It("records a failed write on the last span", func() {
+		t := tracing.NewTracer(1, exporter)
+
+		t.Sample(e, "receive")
+		t.Finish([]*loggregator_v2.Envelope{e}, errors.New("some-error"))
+
+		spans := exporter.exported()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].Err).To(MatchError("some-error"))
+		Expect(spans[1].Err).To(MatchError("some-error"))
+	})
+
+	It("exports an envelope once", func() {
+		t := tracing.NewTracer(1, exporter)
+
+		t.Sample(e, "receive")
+		t.Finish([]*loggregator_v2.Envelope{e}, nil)
+		t.Finish([]*loggregator_v2.Envelope{e}, nil)
+
+		Expect(exporter.exported()).To(HaveLen(2))
+	})
+
+	It("does not trace envelopes that are not sampled", func() {
+		t := tracing.NewTracer(0, exporter)
+
+		t.Sample(e, "receive")
+		t.Mark(e, "buffer")
+		t.Finish([]*loggregator_v2.Envelope{e}, nil)
+
+		Expect(exporter.exported()).To(BeEmpty())
+	})
+
+	It("traces nothing when nil", func() {
+		var t *tracing.Tracer
+
+		t.Sample(e, "receive")
+		t.Mark(e, "buffer")
+		t.Finish([]*loggregator_v2.Envelope{e}, nil)
+	})
+})
+
+type spyExporter struct {
+	mu    sync.Mutex
+	spans []tracing.Span
+}
+
+func (s *spyExporter) Export(spans []tracing.Span) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spans = append(s.spans, spans...)
+}
+
+func (s *spyExporter) exported() []tracing.Span {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]tracing.Span(nil), s.spans...)
+}